@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"golang.org/x/oauth2"
+)
+
+// --- 多個 Google 帳號 ---
+
+// Firestore 集合名稱
+const googleAccountCollection = "google_accounts"
+
+// googleAccountDocID 回傳帳號在 google_accounts 中的文件 ID
+// 每位使用者的所有 Google 帳號都存在 google_accounts，目前使用的帳號另外複製一份到 user_tokens，
+// 因此只連結一個帳號的使用者與舊版資料都不受影響
+func googleAccountDocID(userID int64, email string) string {
+	return fmt.Sprintf("%d_%s", userID, email)
+}
+
+type googleAccountKey struct{}
+
+// withGoogleAccount 指定這次上傳要使用的 Google 帳號
+func withGoogleAccount(ctx context.Context, email string) context.Context {
+	return context.WithValue(ctx, googleAccountKey{}, email)
+}
+
+func googleAccountFromContext(ctx context.Context) string {
+	email, _ := ctx.Value(googleAccountKey{}).(string)
+	return email
+}
+
+// googleAccountEmail 以 Drive API 查詢權杖所屬的 Google 帳號，drive.file 權限即可查詢
+func googleAccountEmail(ctx context.Context, token *oauth2.Token) (string, error) {
+	driveService, err := newDriveServiceWithToken(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	about, err := driveService.About.Get().Fields("user(emailAddress)").Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	if about.User == nil || about.User.EmailAddress == "" {
+		return "", fmt.Errorf("drive API did not return an email address")
+	}
+	return about.User.EmailAddress, nil
+}
+
+// saveGoogleAccount 儲存 Google 帳號的權杖，並設為目前使用的帳號
+func saveGoogleAccount(ctx context.Context, userToken *UserToken) error {
+	if err := saveTokenDoc(ctx, googleAccountCollection, googleAccountDocID(userToken.UserID, userToken.Email), userToken); err != nil {
+		return err
+	}
+	return saveTokenDoc(ctx, tokenCollection, fmt.Sprintf("%d", userToken.UserID), userToken)
+}
+
+// listGoogleAccounts 回傳使用者連結的 Google 帳號（依 email 排序）與目前使用的帳號
+// 在支援多帳號之前連結的權杖沒有 email，第一次列出時會查詢並補存到 google_accounts
+func listGoogleAccounts(ctx context.Context, userID int64) ([]string, string, error) {
+	active, err := loadTokenDoc(ctx, tokenCollection, fmt.Sprintf("%d", userID))
+	if errors.Is(err, errNotConnected) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if active.Email == "" {
+		email, err := googleAccountEmail(ctx, active.Token())
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to look up legacy google account: %v", err)
+		}
+		active.Email = email
+		if err := saveGoogleAccount(ctx, active); err != nil {
+			return nil, "", err
+		}
+	}
+
+	docs, err := collection(ctx, googleAccountCollection).Where("user_id", "==", userID).GetAll(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	emails := make([]string, 0, len(docs))
+	for _, doc := range docs {
+		var account UserToken
+		if err := doc.DataTo(&account); err != nil {
+			return nil, "", err
+		}
+		emails = append(emails, account.Email)
+	}
+	sort.Strings(emails)
+	return emails, active.Email, nil
+}
+
+// setActiveGoogleAccount 將指定的帳號設為目前使用的帳號
+func setActiveGoogleAccount(ctx context.Context, userID int64, email string) error {
+	account, err := loadTokenDoc(ctx, googleAccountCollection, googleAccountDocID(userID, email))
+	if err != nil {
+		return err
+	}
+	return saveTokenDoc(ctx, tokenCollection, fmt.Sprintf("%d", userID), account)
+}
+
+// 處理 /accounts 指令：列出已連結的 Google 帳號，並以按鈕切換目前使用的帳號
+func handleAccounts(ctx context.Context, message *tgbotapi.Message) {
+	text, markup, err := accountsMenu(ctx, message.From.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list google accounts", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取帳號時發生錯誤，請稍後再試。")
+		return
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyToMessageID = message.MessageID
+	if markup != nil {
+		msg.ReplyMarkup = *markup
+	}
+	if _, err := sendMessage(ctx, msg); err != nil {
+		slog.ErrorContext(ctx, "Failed to send message", "error", err)
+	}
+}
+
+// accountsMenu 產生 /accounts 的訊息內容與按鈕；尚未連結任何帳號時 markup 為 nil
+func accountsMenu(ctx context.Context, userID int64) (string, *tgbotapi.InlineKeyboardMarkup, error) {
+	emails, active, err := listGoogleAccounts(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(emails) == 0 {
+		return "您還沒有連結任何 Google 帳號，請使用 /connect_drive 連結。", nil, nil
+	}
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("已連結的 Google 帳號：\n")
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, email := range emails {
+		label := email
+		if email == active {
+			label = "✅ " + email
+			sb.WriteString("• " + email + "（目前使用）\n")
+		} else {
+			sb.WriteString("• " + email + "\n")
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, encodeCallbackData("accounts", "use", strconv.Itoa(i))),
+		))
+	}
+	askLabel, askData := "每次上傳時選擇帳號：關", encodeCallbackData("accounts", "ask", "on")
+	if settings.AskDriveAccount {
+		askLabel, askData = "每次上傳時選擇帳號：開", encodeCallbackData("accounts", "ask", "off")
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(askLabel, askData)))
+	sb.WriteString("\n點選帳號即可切換上傳目的地，使用 /connect_drive 可以再連結其他帳號。")
+
+	markup := tgbotapi.NewInlineKeyboardMarkup(rows...)
+	return sb.String(), &markup, nil
+}
+
+// handleAccountsCallback 處理 /accounts 訊息上的按鈕
+func handleAccountsCallback(ctx context.Context, query *tgbotapi.CallbackQuery, args []string) {
+	userID := query.From.ID
+	if len(args) != 2 {
+		answerCallback(ctx, query, "")
+		return
+	}
+
+	switch args[0] {
+	case "use":
+		emails, _, err := listGoogleAccounts(ctx, userID)
+		i, convErr := strconv.Atoi(args[1])
+		if err != nil || convErr != nil || i < 0 || i >= len(emails) {
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to list google accounts", "error", err)
+			}
+			answerCallback(ctx, query, "帳號清單已變更，請重新輸入 /accounts。")
+			return
+		}
+		if err := setActiveGoogleAccount(ctx, userID, emails[i]); err != nil {
+			slog.ErrorContext(ctx, "Failed to switch google account", "error", err)
+			answerCallback(ctx, query, "切換帳號時發生錯誤，請稍後再試。")
+			return
+		}
+		answerCallback(ctx, query, "已切換到 "+emails[i])
+	case "ask":
+		if err := updateUserSettings(ctx, userID, map[string]interface{}{"ask_drive_account": args[1] == "on"}); err != nil {
+			slog.ErrorContext(ctx, "Failed to update settings", "error", err)
+			answerCallback(ctx, query, "更新設定時發生錯誤，請稍後再試。")
+			return
+		}
+		answerCallback(ctx, query, "已更新設定")
+	default:
+		answerCallback(ctx, query, "")
+		return
+	}
+
+	// 更新原本的訊息，讓按鈕反映最新狀態
+	text, markup, err := accountsMenu(ctx, userID)
+	if err != nil || markup == nil {
+		return
+	}
+	edit := tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID, query.Message.MessageID, text, *markup)
+	if _, err := sendChattable(ctx, query.Message.Chat.ID, edit); err != nil {
+		slog.WarnContext(ctx, "Failed to update accounts message", "error", err)
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"context"
+	"slices"
+)
+
+// --- 存取控制 ---
+
+// isUserAllowed 判斷使用者是否可以使用本機器人，封鎖清單優先於允許清單；允許清單為空時代表所有人都可使用
+func isUserAllowed(ctx context.Context, userID int64) bool {
+	cfg := configFor(ctx)
+	if slices.Contains(cfg.BlockedUserIDs, userID) {
+		return false
+	}
+	if len(cfg.AllowedUserIDs) > 0 && !slices.Contains(cfg.AllowedUserIDs, userID) {
+		return false
+	}
+	return true
+}
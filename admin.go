@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 管理員指令 ---
+
+// 廣播時每則訊息的間隔；所有訊息共用每秒 30 則的全域額度，廣播只使用其中一部分，讓一般回覆不必排隊太久
+const broadcastInterval = 50 * time.Millisecond
+
+// 列出使用者時的最大筆數
+const adminUserListLimit = 50
+
+// isAdmin 判斷使用者是否列在 ADMIN_USER_IDS 中
+func isAdmin(ctx context.Context, userID int64) bool {
+	return slices.Contains(configFor(ctx).AdminUserIDs, userID)
+}
+
+const adminUsage = `管理員指令：
+/admin stats：使用統計
+/admin users：列出已連結的使用者
+/admin users <使用者 ID>：查詢單一使用者
+/admin broadcast <訊息>：發送公告給所有已連結的使用者
+/admin ttl：設定並查看短期資料的 Firestore TTL 政策`
+
+// 處理 /admin 指令，非管理員一律視為無法辨識的指令
+func handleAdmin(ctx context.Context, message *tgbotapi.Message) {
+	if message.From == nil || !isAdmin(ctx, message.From.ID) {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "無法辨識的指令。")
+		return
+	}
+
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, adminUsage)
+		return
+	}
+
+	switch args[0] {
+	case "stats":
+		handleAdminStats(ctx, message)
+	case "users":
+		if len(args) > 1 {
+			handleAdminUserLookup(ctx, message, args[1])
+		} else {
+			handleAdminUserList(ctx, message)
+		}
+	case "broadcast":
+		text := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(message.CommandArguments()), "broadcast"))
+		if text == "" {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "請在指令後輸入要廣播的訊息。")
+			return
+		}
+		handleAdminBroadcast(ctx, message, text)
+	case "ttl":
+		handleAdminTTL(ctx, message)
+	default:
+		replyToUser(ctx, message.Chat.ID, message.MessageID, adminUsage)
+	}
+}
+
+func handleAdminStats(ctx context.Context, message *tgbotapi.Message) {
+	users, err := countDocuments(ctx, tokenCollection)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to count connected users", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取統計資料時發生錯誤。")
+		return
+	}
+	today, err := loadDailyStats(ctx, time.Now().UTC().Format("2006-01-02"))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load daily stats", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取統計資料時發生錯誤。")
+		return
+	}
+
+	reply := fmt.Sprintf("使用統計\n已連結使用者：%d\n今日上傳 (UTC %s)：%d 個檔案，共 %s",
+		users, today.Date, today.Uploads, formatSize(today.Bytes))
+	// 快取統計只涵蓋回覆這則訊息的執行個體
+	if hits, misses := userTokenCache.stats(); hits+misses > 0 {
+		reply += fmt.Sprintf("\n權杖快取命中率：%.1f%%（%d / %d）", float64(hits)*100/float64(hits+misses), hits, hits+misses)
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, reply)
+}
+
+func handleAdminUserList(ctx context.Context, message *tgbotapi.Message) {
+	docs, err := collection(ctx, tokenCollection).OrderBy("created_at", Desc).Limit(adminUserListLimit).GetAll(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list users", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取使用者列表時發生錯誤。")
+		return
+	}
+
+	var lines []string
+	for _, doc := range docs {
+		var token UserToken
+		if err := doc.DataTo(&token); err != nil {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%d（連結於 %s）", token.UserID, token.CreatedAt.Format("2006-01-02")))
+	}
+
+	if len(lines) == 0 {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "目前沒有已連結的使用者。")
+		return
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("最近連結的 %d 位使用者：\n%s", len(lines), strings.Join(lines, "\n")))
+}
+
+func handleAdminUserLookup(ctx context.Context, message *tgbotapi.Message, arg string) {
+	userID, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "使用者 ID 必須是數字。")
+		return
+	}
+
+	connected := "未連結"
+	doc, err := collection(ctx, tokenCollection).Doc(fmt.Sprintf("%d", userID)).Get(ctx)
+	switch {
+	case err == nil:
+		var token UserToken
+		if err := doc.DataTo(&token); err == nil {
+			connected = "已連結，連結時間 " + token.CreatedAt.Format("2006-01-02 15:04")
+		}
+	case !errors.Is(err, errDocNotFound):
+		slog.ErrorContext(ctx, "Failed to look up user token", "target_user_id", userID, "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取使用者資料時發生錯誤。")
+		return
+	}
+
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load user settings", "target_user_id", userID, "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取使用者資料時發生錯誤。")
+		return
+	}
+	lastUpload := "無"
+	if settings.LastUpload != nil {
+		lastUpload = fmt.Sprintf("%s（%s，%s）", joinExt(settings.LastUpload.Name, settings.LastUpload.Ext),
+			formatSize(settings.LastUpload.Size), settings.LastUpload.CreatedAt.Format("2006-01-02 15:04"))
+	}
+
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("使用者 %d\nGoogle Drive：%s\n最後上傳：%s", userID, connected, lastUpload))
+}
+
+// handleAdminBroadcast 在背景逐一發送公告，並在完成後回報結果給管理員
+func handleAdminBroadcast(ctx context.Context, message *tgbotapi.Message, text string) {
+	replyToUser(ctx, message.Chat.ID, message.MessageID, "開始發送公告…")
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		// 背景工作不在 handleUpdate 的中介層內，需要自行攔截 panic
+		defer func() {
+			if r := recover(); r != nil {
+				reportPanic(ctx, r)
+			}
+		}()
+		sent, failed := 0, 0
+		ticker := time.NewTicker(broadcastInterval)
+		defer ticker.Stop()
+
+		docs, err := collection(ctx, tokenCollection).GetAll(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list users for broadcast", "error", err)
+		}
+		for _, doc := range docs {
+			var token UserToken
+			if err := doc.DataTo(&token); err != nil {
+				continue
+			}
+
+			<-ticker.C
+			// 私人對話的 chat ID 與使用者 ID 相同
+			if _, err := sendChattable(ctx, token.UserID, tgbotapi.NewMessage(token.UserID, text)); err != nil {
+				slog.WarnContext(ctx, "Failed to send broadcast", "target_user_id", token.UserID, "error", err)
+				failed++
+				continue
+			}
+			sent++
+		}
+
+		slog.InfoContext(ctx, "Broadcast finished", "sent", sent, "failed", failed)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("公告發送完成：成功 %d 則，失敗 %d 則。", sent, failed))
+	}()
+}
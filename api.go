@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- 管理 API ---
+
+const (
+	defaultAPIUploadLimit = 50
+	maxAPIUploadLimit     = 500
+	maxAPIStatsDays       = 90
+)
+
+// hasBearerToken 以固定時間比較 Authorization 標頭中的 Bearer 權杖
+func hasBearerToken(r *http.Request, secret string) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1
+}
+
+// apiHandler 回傳 /api/v1/ 的路由，所有路由都需要以 Bearer 權杖帶上 API_TOKEN；未設定時不啟用 API
+func apiHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/stats", apiStatsHandler)
+	mux.HandleFunc("GET /api/v1/users/{id}/status", apiUserStatusHandler)
+	mux.HandleFunc("GET /api/v1/users/{id}/uploads", apiUserUploadsHandler)
+	mux.HandleFunc("POST /api/v1/users/{id}/disconnect", apiDisconnectHandler)
+	mux.HandleFunc("DELETE /api/v1/users/{id}", apiDeleteUserHandler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := configFor(r.Context()).APIToken
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if !hasBearerToken(r, token) {
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		// 以 ?bot=<機器人 ID> 指定要操作的機器人，未指定時為主要機器人
+		if id := r.URL.Query().Get("bot"); id != "" {
+			t, ok := tenants[id]
+			if !ok {
+				writeAPIError(w, http.StatusNotFound, "unknown bot")
+				return
+			}
+			r = r.WithContext(withTenant(r.Context(), t))
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeAPIJSON(w, status, map[string]string{"error": message})
+}
+
+// apiUserID 讀取路徑中的使用者 ID，格式錯誤時回應 400 並回傳 false
+func apiUserID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	userID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "user id must be a number")
+		return 0, false
+	}
+	return userID, true
+}
+
+type apiDailyStats struct {
+	Date    string `json:"date"`
+	Uploads int64  `json:"uploads"`
+	Bytes   int64  `json:"bytes"`
+}
+
+// 處理 GET /api/v1/stats?days=N：已連結的使用者數與最近 N 天（UTC，預設 7 天）的上傳統計
+func apiStatsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	days := 7
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAPIStatsDays {
+			writeAPIError(w, http.StatusBadRequest, "days must be between 1 and 90")
+			return
+		}
+		days = n
+	}
+
+	users, err := countDocuments(ctx, tokenCollection)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to count connected users", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to load stats")
+		return
+	}
+	daily := make([]apiDailyStats, 0, days)
+	today := time.Now().UTC()
+	for i := 0; i < days; i++ {
+		stats, err := loadDailyStats(ctx, today.AddDate(0, 0, -i).Format("2006-01-02"))
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load daily stats", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, "failed to load stats")
+			return
+		}
+		daily = append(daily, apiDailyStats{Date: stats.Date, Uploads: stats.Uploads, Bytes: stats.Bytes})
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]interface{}{
+		"connected_users": users,
+		"daily":           daily,
+	})
+}
+
+type apiUpload struct {
+	ChatID      int64     `json:"chat_id"`
+	MessageID   int       `json:"message_id"`
+	Destination string    `json:"destination"`
+	Account     string    `json:"account,omitempty"`
+	FileID      string    `json:"file_id"`
+	Name        string    `json:"name"`
+	Link        string    `json:"link,omitempty"`
+	MimeType    string    `json:"mime_type,omitempty"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// 處理 GET /api/v1/users/{id}/uploads?limit=N：使用者最近的上傳紀錄，由新到舊
+func apiUserUploadsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := apiUserID(w, r)
+	if !ok {
+		return
+	}
+	limit := defaultAPIUploadLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAPIUploadLimit {
+			writeAPIError(w, http.StatusBadRequest, "limit must be between 1 and 500")
+			return
+		}
+		limit = n
+	}
+
+	uploads, err := listUserUploads(ctx, userID, limit)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list uploads", "target_user_id", userID, "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to list uploads")
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]interface{}{"uploads": uploads})
+}
+
+// listUserUploads 讀取使用者最近的上傳紀錄，由新到舊；管理 API 與網頁版共用
+func listUserUploads(ctx context.Context, userID int64, limit int) ([]apiUpload, error) {
+	docs, err := collection(ctx, uploadCollection).
+		Where("user_id", "==", userID).
+		OrderBy("created_at", Desc).
+		Limit(limit).GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	uploads := make([]apiUpload, 0, len(docs))
+	for _, doc := range docs {
+		var record UploadRecord
+		if err := doc.DataTo(&record); err != nil {
+			continue
+		}
+		uploads = append(uploads, apiUpload{
+			ChatID:      record.ChatID,
+			MessageID:   record.MessageID,
+			Destination: record.Destination,
+			Account:     record.Account,
+			FileID:      record.FileID,
+			Name:        record.Name,
+			Link:        record.Link,
+			MimeType:    record.MimeType,
+			Size:        record.Size,
+			CreatedAt:   record.CreatedAt,
+		})
+	}
+	return uploads, nil
+}
+
+type apiDestinationStatus struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Connected   bool   `json:"connected"`
+	Current     bool   `json:"current"` // 目前的上傳目的地，或照片與影片的目的地
+	Media       bool   `json:"media"`   // 只接受照片與影片的目的地
+}
+
+type apiUserStatus struct {
+	UserID       int64                  `json:"user_id"`
+	Destinations []apiDestinationStatus `json:"destinations"`
+	Settings     map[string]interface{} `json:"settings"`
+	Usage        struct {
+		TodayUploads int64 `json:"today_uploads"`
+		TodayBytes   int64 `json:"today_bytes"`
+		MonthUploads int64 `json:"month_uploads"`
+		MonthBytes   int64 `json:"month_bytes"`
+	} `json:"usage"`
+	Quota struct {
+		UploadsPerMinute int   `json:"uploads_per_minute,omitempty"` // 0 代表不限制
+		DailyLimitBytes  int64 `json:"daily_limit_bytes,omitempty"`  // 0 代表不限制
+		DailyUsedBytes   int64 `json:"daily_used_bytes"`
+	} `json:"quota"`
+}
+
+// 處理 GET /api/v1/users/{id}/status：使用者各目的地的連結狀態、主要設定、上傳量與額度
+func apiUserStatusHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := apiUserID(w, r)
+	if !ok {
+		return
+	}
+	st, err := loadUserStatus(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load user status", "target_user_id", userID, "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to load user status")
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, st)
+}
+
+// loadUserStatus 讀取使用者的連結狀態、設定、上傳量與額度；管理 API 與網頁版共用
+func loadUserStatus(ctx context.Context, userID int64) (*apiUserStatus, error) {
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load settings: %v", err)
+	}
+	st := &apiUserStatus{
+		UserID: userID,
+		Settings: map[string]interface{}{
+			"filename_template": settings.FilenameTemplate,
+			"folder_template":   settings.FolderTemplate,
+			"confirm_upload":    settings.ConfirmUpload,
+			"skip_duplicates":   settings.SkipDuplicates,
+			"digest":            settings.Digest,
+			"webhook_url":       settings.WebhookURL,
+		},
+	}
+	current := userDestination(ctx, settings).Name()
+	media := settings.MediaDestination
+	for _, name := range append(primaryDestinationNames(), mediaDestinationNames()...) {
+		dest := destinations[name]
+		_, isMedia := dest.(mediaDestination)
+		connected, err := dest.Connected(ctx, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check %s: %v", name, err)
+		}
+		st.Destinations = append(st.Destinations, apiDestinationStatus{
+			Name:        name,
+			DisplayName: dest.DisplayName(),
+			Connected:   connected,
+			Current:     name == current || name == media,
+			Media:       isMedia,
+		})
+	}
+
+	now := time.Now().UTC()
+	today, err := loadUserUsage(ctx, userID, now.Format("2006-01-02"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load usage: %v", err)
+	}
+	month, err := loadUserUsage(ctx, userID, now.Format("2006-01"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load usage: %v", err)
+	}
+	st.Usage.TodayUploads, st.Usage.TodayBytes = today.Uploads, today.Bytes
+	st.Usage.MonthUploads, st.Usage.MonthBytes = month.Uploads, month.Bytes
+
+	limits := configFor(ctx).RateLimits
+	st.Quota.UploadsPerMinute, st.Quota.DailyLimitBytes = limits.UploadsPerMinute, limits.dailyUploadBytes()
+	if st.Quota.DailyLimitBytes > 0 {
+		if st.Quota.DailyUsedBytes, err = loadDailyUploadBytes(ctx, userID); err != nil {
+			return nil, fmt.Errorf("failed to load quota: %v", err)
+		}
+	}
+	return st, nil
+}
+
+// 處理 POST /api/v1/users/{id}/disconnect：刪除使用者在所有目的地的權杖
+func apiDisconnectHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := apiUserID(w, r)
+	if !ok {
+		return
+	}
+	if err := disconnectUser(ctx, userID); err != nil {
+		slog.ErrorContext(ctx, "Failed to disconnect user", "target_user_id", userID, "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to disconnect user")
+		return
+	}
+	slog.InfoContext(ctx, "User disconnected through API", "target_user_id", userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 處理 DELETE /api/v1/users/{id}：與 /forget_me 相同，撤銷 Google 授權並刪除使用者的所有資料
+func apiDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID, ok := apiUserID(w, r)
+	if !ok {
+		return
+	}
+	if err := deleteUserData(ctx, userID); err != nil {
+		slog.ErrorContext(ctx, "Failed to delete user data", "target_user_id", userID, "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to delete user data")
+		return
+	}
+	slog.InfoContext(ctx, "User data deleted through API", "target_user_id", userID)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 群組封存模式 ---
+
+// Firestore 集合名稱
+const chatSettingsCollection = "chat_settings"
+
+// 未指定資料夾時，封存的檔案依群組名稱分資料夾
+const defaultArchiveFolder = "Telegram Archive/{chat}"
+
+// ChatSettings 是群組或頻道層級的設定，目前用於封存模式
+type ChatSettings struct {
+	ChatID         int64     `firestore:"chat_id"`
+	ArchiveEnabled bool      `firestore:"archive_enabled"`
+	ArchiveOwnerID int64     `firestore:"archive_owner_id"` // 檔案會上傳到這位使用者的儲存空間
+	ArchiveFolder  string    `firestore:"archive_folder"`   // 資料夾範本
+	ArchivePolls   bool      `firestore:"archive_polls"`    // 投票被手動結束時將結果存成 CSV
+	UpdatedAt      time.Time `firestore:"updated_at"`
+}
+
+// Profile 回傳封存上傳使用的處理方式；群組中每個檔案都回覆確認訊息太吵，因此固定為靜默
+func (s *ChatSettings) Profile() ProcessingProfile {
+	return ProcessingProfile{FolderTemplate: s.ArchiveFolder, Silent: true}
+}
+
+// loadChatSettings 讀取群組設定，尚未設定過時回傳 nil
+func loadChatSettings(ctx context.Context, chatID int64) (*ChatSettings, error) {
+	doc, err := collection(ctx, chatSettingsCollection).Doc(fmt.Sprintf("%d", chatID)).Get(ctx)
+	if err != nil {
+		if errors.Is(err, errDocNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var settings ChatSettings
+	if err := doc.DataTo(&settings); err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// loadArchiveSettings 回傳開啟中的封存設定，私訊或未開啟封存時回傳 nil
+func loadArchiveSettings(ctx context.Context, chat *tgbotapi.Chat) (*ChatSettings, error) {
+	if chat.IsPrivate() {
+		return nil, nil
+	}
+	settings, err := loadChatSettings(ctx, chat.ID)
+	if err != nil || settings == nil || !settings.ArchiveEnabled {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// 處理 /enable_archive 指令：群組管理員開啟封存模式，之後群組中的檔案都會上傳到他的儲存空間
+// 頻道中無法得知下指令的人，因此頻道改在私訊中以 /enable_archive <@頻道或 ID> [資料夾範本] 設定
+func handleEnableArchive(ctx context.Context, message *tgbotapi.Message) {
+	chat, args, ok := resolveArchiveChat(ctx, message, "/enable_archive")
+	if !ok {
+		return
+	}
+
+	folder := strings.TrimSpace(args)
+	if folder == "" {
+		folder = defaultArchiveFolder
+	}
+	if err := validateTemplate(folder, folderTemplate); err != nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("資料夾範本無效：%v", err))
+		return
+	}
+
+	// 確認封存擁有者已連結目的地，否則之後每個檔案都會上傳失敗
+	owner, err := loadUserSettings(ctx, message.From.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的設定時發生錯誤，請稍後再試。")
+		return
+	}
+	dest := userDestination(ctx, owner)
+	if connected, err := dest.Connected(ctx, message.From.ID); err != nil || !connected {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("請先在私訊中使用 %s 連結您的 %s，再開啟封存模式。", connectCommand(dest), dest.DisplayName()))
+		return
+	}
+
+	err = collection(ctx, chatSettingsCollection).Doc(fmt.Sprintf("%d", chat.ID)).Merge(ctx, map[string]interface{}{
+		"chat_id":          chat.ID,
+		"archive_enabled":  true,
+		"archive_owner_id": message.From.ID,
+		"archive_folder":   folder,
+		"updated_at":       time.Now(),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to save chat settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "儲存設定時發生錯誤，請稍後再試。")
+		return
+	}
+	slog.InfoContext(ctx, "Archive mode enabled", "archive_chat_id", chat.ID, "folder", folder)
+
+	if chat.IsChannel() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("已開啟頻道「%s」的封存模式，之後發布的檔案與圖片都會上傳到您的 %s，資料夾：%s\n機器人需要是頻道管理員才會收到貼文。使用 /disable_archive %s 關閉。",
+			chatDisplayName(chat), dest.DisplayName(), folder, archiveChatArg(chat)))
+		return
+	}
+	reply := fmt.Sprintf("已開啟封存模式，此群組中所有成員傳送的檔案與圖片都會上傳到 %s 的 %s，資料夾：%s\n使用 /disable_archive 關閉。",
+		userDisplayName(message.From), dest.DisplayName(), folder)
+	if !canSeeGroupFiles(ctx, chat.ID) {
+		reply += "\n\n⚠️ 機器人目前開啟了隱私模式，只會收到指令與回覆給機器人的訊息。請將機器人設為群組管理員，或在 @BotFather 使用 /setprivacy 關閉隱私模式後重新加入群組。"
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, reply)
+}
+
+// 處理 /disable_archive 指令
+func handleDisableArchive(ctx context.Context, message *tgbotapi.Message) {
+	chat, _, ok := resolveArchiveChat(ctx, message, "/disable_archive")
+	if !ok {
+		return
+	}
+	err := collection(ctx, chatSettingsCollection).Doc(fmt.Sprintf("%d", chat.ID)).Merge(ctx, map[string]interface{}{
+		"archive_enabled": false,
+		"updated_at":      time.Now(),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to save chat settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "儲存設定時發生錯誤，請稍後再試。")
+		return
+	}
+	slog.InfoContext(ctx, "Archive mode disabled", "archive_chat_id", chat.ID)
+	replyToUser(ctx, message.Chat.ID, message.MessageID, "已關閉封存模式。")
+}
+
+// resolveArchiveChat 決定封存指令要設定的聊天室並確認下指令的人是管理員
+// 在群組中設定群組本身；在私訊中第一個參數是頻道的 @使用者名稱或 ID。回傳剩下的參數
+func resolveArchiveChat(ctx context.Context, message *tgbotapi.Message, command string) (*tgbotapi.Chat, string, bool) {
+	args := message.CommandArguments()
+	if !message.Chat.IsPrivate() {
+		if !requireChatAdmin(ctx, message, message.Chat.ID, command) {
+			return nil, "", false
+		}
+		return message.Chat, args, true
+	}
+
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("請在群組中使用 %s；若要封存頻道，請在這裡輸入 %s <@頻道或頻道 ID> [資料夾範本]。", command, command))
+		return nil, "", false
+	}
+	chat, ok := lookupChannel(ctx, message, fields[0])
+	if !ok || !requireChatAdmin(ctx, message, chat.ID, command) {
+		return nil, "", false
+	}
+	return chat, strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(args), fields[0])), true
+}
+
+// lookupChannel 以 @使用者名稱或 ID 查詢頻道，找不到時回覆使用者
+func lookupChannel(ctx context.Context, message *tgbotapi.Message, arg string) (*tgbotapi.Chat, bool) {
+	config := tgbotapi.ChatInfoConfig{}
+	if id, err := strconv.ParseInt(arg, 10, 64); err == nil {
+		config.ChatID = id
+	} else {
+		config.SuperGroupUsername = "@" + strings.TrimPrefix(arg, "@")
+	}
+	chat, err := botFor(ctx).GetChat(config)
+	if err != nil || !chat.IsChannel() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "找不到這個頻道，請確認已將機器人加入頻道並設為管理員。")
+		return nil, false
+	}
+	return &chat, true
+}
+
+// archiveChatArg 回傳在指令中代表頻道的參數
+func archiveChatArg(chat *tgbotapi.Chat) string {
+	if chat.UserName != "" {
+		return "@" + chat.UserName
+	}
+	return fmt.Sprintf("%d", chat.ID)
+}
+
+// handleChannelPost 封存已開啟封存模式的頻道中的貼文；未開啟時忽略，機器人不會在頻道中回覆
+func handleChannelPost(ctx context.Context, post *tgbotapi.Message) {
+	if messageFile(post) == nil {
+		return
+	}
+	handleFile(ctx, post)
+}
+
+// handleEditedMedia 處理編輯過的訊息或頻道貼文：已上傳過且換了檔案時重新上傳，只修改說明文字時更新檔案的描述與檔名
+func handleEditedMedia(ctx context.Context, message *tgbotapi.Message) {
+	file := messageFile(message)
+	// 即時位置會不斷以編輯更新位置，由訊息內容產生的檔案不重新上傳
+	if file == nil || file.Content != nil {
+		return
+	}
+	record, err := findUploadRecord(ctx, message.Chat.ID, message.MessageID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to find upload record", "error", err)
+		return
+	}
+	if record == nil || record.TelegramFileUniqueID == file.UniqueID {
+		handleEditedCaption(ctx, message, record)
+		return
+	}
+	slog.InfoContext(ctx, "Re-uploading edited media")
+	handleFile(ctx, message)
+}
+
+// requireChatAdmin 確認下指令的人是指定聊天室的管理員
+func requireChatAdmin(ctx context.Context, message *tgbotapi.Message, chatID int64, command string) bool {
+	member, err := botFor(ctx).GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: message.From.ID},
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to get chat member", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "無法確認您在此聊天室的權限，請稍後再試。")
+		return false
+	}
+	if !member.IsCreator() && !member.IsAdministrator() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "只有管理員可以使用 "+command+"。")
+		return false
+	}
+	return true
+}
+
+// canSeeGroupFiles 回報機器人是否能收到群組中的一般訊息：關閉隱私模式或身為管理員時才會收到
+func canSeeGroupFiles(ctx context.Context, chatID int64) bool {
+	if botFor(ctx).Self.CanReadAllGroupMessages {
+		return true
+	}
+	member, err := botFor(ctx).GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chatID, UserID: botFor(ctx).Self.ID},
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to get bot chat member", "error", err)
+		return true
+	}
+	return member.IsAdministrator()
+}
+
+// handleBotMembership 在機器人被移出群組或頻道時關閉封存並解除綁定，避免留下無法使用的設定
+func handleBotMembership(ctx context.Context, update *tgbotapi.ChatMemberUpdated) {
+	if update.NewChatMember.User == nil || update.NewChatMember.User.ID != botFor(ctx).Self.ID {
+		return
+	}
+	switch update.NewChatMember.Status {
+	case "left", "kicked":
+	default:
+		return
+	}
+	slog.InfoContext(ctx, "Bot removed from chat", "status", update.NewChatMember.Status)
+
+	if settings, err := loadChatSettings(ctx, update.Chat.ID); err != nil {
+		slog.ErrorContext(ctx, "Failed to load chat settings", "error", err)
+	} else if settings != nil && settings.ArchiveEnabled {
+		err := collection(ctx, chatSettingsCollection).Doc(fmt.Sprintf("%d", update.Chat.ID)).Merge(ctx, map[string]interface{}{
+			"archive_enabled": false,
+			"updated_at":      time.Now(),
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to disable archive", "error", err)
+		} else {
+			replyToUser(ctx, settings.ArchiveOwnerID, 0, fmt.Sprintf("機器人已被移出「%s」，已自動關閉該聊天室的封存模式。", chatDisplayName(&update.Chat)))
+		}
+	}
+	if err := deleteChatBinding(ctx, update.Chat.ID); err != nil {
+		slog.ErrorContext(ctx, "Failed to delete chat binding", "error", err)
+	}
+}
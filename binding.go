@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Firestore 集合名稱
+const bindingCollection = "chat_bindings"
+
+// 綁定的保留天數上限
+const maxRetentionDays = 3650
+
+// ChatBinding 將群組或頻道綁定到一位擁有者，並帶有獨立於擁有者個人設定的處理方式
+type ChatBinding struct {
+	ChatID    int64             `firestore:"chat_id"`
+	OwnerID   int64             `firestore:"owner_id"`
+	Profile   ProcessingProfile `firestore:"profile"`
+	CreatedAt time.Time         `firestore:"created_at"`
+	UpdatedAt time.Time         `firestore:"updated_at"`
+}
+
+// loadChatBinding 讀取聊天室的綁定，尚未綁定時回傳 nil
+func loadChatBinding(ctx context.Context, chatID int64) (*ChatBinding, error) {
+	doc, err := collection(ctx, bindingCollection).Doc(fmt.Sprintf("%d", chatID)).Get(ctx)
+	if err != nil {
+		if errors.Is(err, errDocNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var binding ChatBinding
+	if err := doc.DataTo(&binding); err != nil {
+		return nil, err
+	}
+	return &binding, nil
+}
+
+func saveChatBinding(ctx context.Context, binding *ChatBinding) error {
+	binding.UpdatedAt = time.Now()
+	err := collection(ctx, bindingCollection).Doc(fmt.Sprintf("%d", binding.ChatID)).Set(ctx, binding)
+	return err
+}
+
+func deleteChatBinding(ctx context.Context, chatID int64) error {
+	err := collection(ctx, bindingCollection).Doc(fmt.Sprintf("%d", chatID)).Delete(ctx)
+	return err
+}
+
+// resolveProfile 決定這次上傳要使用的處理方式：
+// 已綁定的聊天室中，不論由誰上傳（包含頻道貼文）都使用綁定的設定；
+// 未綁定時，封存模式使用封存資料夾，其他情況使用上傳者的個人設定
+func resolveProfile(ctx context.Context, message *tgbotapi.Message, settings *UserSettings, archive *ChatSettings) (ProcessingProfile, error) {
+	if message.Chat.IsPrivate() {
+		return settings.Profile(), nil
+	}
+	binding, err := loadChatBinding(ctx, message.Chat.ID)
+	if err != nil {
+		return ProcessingProfile{}, err
+	}
+	if binding != nil {
+		return binding.Profile, nil
+	}
+	if archive != nil {
+		// 封存模式沿用擁有者個人的轉換設定
+		profile := archive.Profile()
+		profile.Convert = settings.ConvertToGoogle
+		return profile, nil
+	}
+	return settings.Profile(), nil
+}
+
+// resolveBindingChat 決定 /binding 要設定的聊天室並回傳剩下的參數
+// 在群組中設定群組本身；頻道中的指令不會送到機器人，因此在私訊中以第一個參數指定頻道的 @使用者名稱或 ID
+func resolveBindingChat(ctx context.Context, message *tgbotapi.Message) (*tgbotapi.Chat, []string, bool) {
+	args := strings.Fields(message.CommandArguments())
+	if !message.Chat.IsPrivate() {
+		return message.Chat, args, true
+	}
+	if len(args) == 0 {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請在群組中使用 /binding；若要設定頻道，請在這裡輸入 /binding <@頻道或頻道 ID> [bind|unbind|settings]。")
+		return nil, nil, false
+	}
+	chat, ok := lookupChannel(ctx, message, args[0])
+	if !ok {
+		return nil, nil, false
+	}
+	return chat, args[1:], true
+}
+
+// 處理 /binding 指令
+func handleBinding(ctx context.Context, message *tgbotapi.Message) {
+	chat, args, ok := resolveBindingChat(ctx, message)
+	if !ok {
+		return
+	}
+
+	binding, err := loadChatBinding(ctx, chat.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load chat binding", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取聊天室設定時發生錯誤，請稍後再試。")
+		return
+	}
+
+	if len(args) == 0 {
+		if binding == nil {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "此聊天室尚未綁定。管理員可以使用 /binding bind 綁定此聊天室，讓聊天室中所有上傳的檔案使用綁定的處理設定。")
+			return
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, describeBinding(binding))
+		return
+	}
+
+	switch args[0] {
+	case "bind":
+		handleBindingBind(ctx, message, chat, binding)
+	case "unbind":
+		if !requireBindingOwner(ctx, message, binding) {
+			return
+		}
+		if err := deleteChatBinding(ctx, chat.ID); err != nil {
+			slog.ErrorContext(ctx, "Failed to delete chat binding", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "解除綁定時發生錯誤，請稍後再試。")
+			return
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "已解除此聊天室的綁定。")
+	case "settings":
+		if !requireBindingOwner(ctx, message, binding) {
+			return
+		}
+		handleBindingSettings(ctx, message, binding, args[1:])
+	default:
+		replyToUser(ctx, message.Chat.ID, message.MessageID, bindingUsage)
+	}
+}
+
+const bindingUsage = `用法：
+/binding：查看目前的綁定
+/binding bind：綁定此聊天室，之後聊天室中所有成員上傳的檔案都使用綁定的處理設定（限管理員）
+/binding unbind：解除綁定
+/binding settings：查看綁定的處理設定
+/binding settings filename <範本|reset>：設定檔名範本
+/binding settings folder <範本|reset>：設定資料夾範本
+/binding settings silent <on|off>：上傳成功時不回覆確認訊息
+/binding settings convert <on|off>：上傳到 Google Drive 時轉換成 Google 文件格式
+/binding settings retention <天數|off>：上傳的檔案在指定天數後移到垃圾桶
+
+檔案仍上傳到傳送者自己的儲存空間，開啟封存模式時則上傳到封存擁有者的儲存空間。
+頻道請在私訊中設定：/binding <@頻道或頻道 ID> [bind|unbind|settings ...]；頻道貼文沒有傳送者，需同時以 /enable_archive 開啟封存模式才會上傳。`
+
+func handleBindingBind(ctx context.Context, message *tgbotapi.Message, chat *tgbotapi.Chat, binding *ChatBinding) {
+	// 匿名管理員與以頻道身分傳送的訊息沒有 From，無法決定擁有者
+	if message.From == nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請以個人帳號使用 /binding bind，匿名管理員或頻道身分無法綁定。")
+		return
+	}
+	if binding != nil {
+		if binding.OwnerID == message.From.ID {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "此聊天室已經由您綁定。")
+		} else {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "此聊天室已由其他使用者綁定，需由對方先解除綁定。")
+		}
+		return
+	}
+
+	member, err := botFor(ctx).GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chat.ID, UserID: message.From.ID},
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to get chat member", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "無法確認您在此聊天室的權限，請稍後再試。")
+		return
+	}
+	if !member.IsCreator() && !member.IsAdministrator() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "只有管理員可以綁定此聊天室。")
+		return
+	}
+
+	binding = &ChatBinding{
+		ChatID:    chat.ID,
+		OwnerID:   message.From.ID,
+		CreatedAt: time.Now(),
+	}
+	if err := saveChatBinding(ctx, binding); err != nil {
+		slog.ErrorContext(ctx, "Failed to save chat binding", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "綁定時發生錯誤，請稍後再試。")
+		return
+	}
+	slog.InfoContext(ctx, "Chat bound to owner", "binding_chat_id", chat.ID)
+	if chat.IsChannel() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("已綁定頻道「%s」，可使用 /binding %s settings 調整處理設定。頻道貼文沒有傳送者，需同時以 /enable_archive %s 開啟封存模式，貼文才會上傳並套用此綁定的設定。",
+			chatDisplayName(chat), archiveChatArg(chat), archiveChatArg(chat)))
+		return
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, "已綁定此聊天室。之後聊天室中所有成員上傳的檔案都會使用此綁定的處理設定，可使用 /binding settings 調整；檔案仍上傳到傳送者自己的儲存空間，開啟封存模式時則上傳到您的儲存空間。")
+}
+
+func handleBindingSettings(ctx context.Context, message *tgbotapi.Message, binding *ChatBinding, args []string) {
+	if len(args) == 0 {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, describeBinding(binding))
+		return
+	}
+	if len(args) < 2 {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, bindingUsage)
+		return
+	}
+
+	key, value := args[0], strings.Join(args[1:], " ")
+	profile := &binding.Profile
+	switch key {
+	case "filename", "folder":
+		kind, target := filenameTemplate, &profile.FilenameTemplate
+		if key == "folder" {
+			kind, target = folderTemplate, &profile.FolderTemplate
+		}
+		if value == "reset" {
+			*target = ""
+			break
+		}
+		if err := validateTemplate(value, kind); err != nil {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("範本無效：%v\n\n設定未儲存，請修正後再試一次。", err))
+			return
+		}
+		*target = value
+	case "silent", "convert":
+		on, ok := parseOnOff(value)
+		if !ok {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "請使用 on 或 off。")
+			return
+		}
+		if key == "silent" {
+			profile.Silent = on
+		} else {
+			profile.Convert = on
+		}
+	case "retention":
+		if on, ok := parseOnOff(value); ok && !on {
+			profile.RetentionDays = 0
+			break
+		}
+		days, err := strconv.Atoi(value)
+		if err != nil || days <= 0 || days > maxRetentionDays {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("請輸入 1 到 %d 之間的天數，或 off 永久保留。", maxRetentionDays))
+			return
+		}
+		profile.RetentionDays = days
+	default:
+		replyToUser(ctx, message.Chat.ID, message.MessageID, bindingUsage)
+		return
+	}
+
+	if err := saveChatBinding(ctx, binding); err != nil {
+		slog.ErrorContext(ctx, "Failed to save chat binding", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "儲存設定時發生錯誤，請稍後再試。")
+		return
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, "已更新綁定設定。\n\n"+describeBinding(binding))
+}
+
+// requireBindingOwner 確認聊天室已綁定且指令來自擁有者
+func requireBindingOwner(ctx context.Context, message *tgbotapi.Message, binding *ChatBinding) bool {
+	if binding == nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "此聊天室尚未綁定。")
+		return false
+	}
+	if message.From == nil || binding.OwnerID != message.From.ID {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "只有綁定此聊天室的使用者可以變更設定。")
+		return false
+	}
+	return true
+}
+
+func describeBinding(binding *ChatBinding) string {
+	orUnset := func(s string) string {
+		if s == "" {
+			return "（未設定）"
+		}
+		return s
+	}
+	onOff := func(on bool) string {
+		if on {
+			return "開啟"
+		}
+		return "關閉"
+	}
+	retention := "永久保留"
+	if binding.Profile.RetentionDays > 0 {
+		retention = fmt.Sprintf("%d 天後移到垃圾桶", binding.Profile.RetentionDays)
+	}
+	return fmt.Sprintf("此聊天室已綁定到使用者 %d。\n檔名範本：%s\n資料夾範本：%s\n靜默模式：%s\n轉換成 Google 文件：%s\n保留期限：%s",
+		binding.OwnerID, orUnset(binding.Profile.FilenameTemplate), orUnset(binding.Profile.FolderTemplate),
+		onOff(binding.Profile.Silent), onOff(binding.Profile.Convert), retention)
+}
+
+// parseOnOff 解析 on/off 形式的開關參數
+func parseOnOff(s string) (bool, bool) {
+	switch strings.ToLower(s) {
+	case "on", "true", "1", "開":
+		return true, true
+	case "off", "false", "0", "關":
+		return false, true
+	}
+	return false, false
+}
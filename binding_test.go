@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestResolveProfile(t *testing.T) {
+	const ownerID, memberID = 7700, 7701
+	group := &tgbotapi.Chat{ID: -1007700, Type: "supergroup"}
+	channel := &tgbotapi.Chat{ID: -1007701, Type: "channel"}
+	unbound := &tgbotapi.Chat{ID: -1007702, Type: "group"}
+	personal := &UserSettings{FilenameTemplate: "{name}", ConvertToGoogle: true}
+	bound := ProcessingProfile{FolderTemplate: "Team/{year}", Silent: true, RetentionDays: 30}
+	archive := &ChatSettings{ArchiveEnabled: true, ArchiveOwnerID: ownerID, ArchiveFolder: "Archive"}
+
+	tests := []struct {
+		name    string
+		message *tgbotapi.Message
+		archive *ChatSettings
+		want    ProcessingProfile
+	}{
+		{"private chat", &tgbotapi.Message{From: &tgbotapi.User{ID: memberID}, Chat: &tgbotapi.Chat{ID: memberID, Type: "private"}}, nil, personal.Profile()},
+		{"owner in bound group", &tgbotapi.Message{From: &tgbotapi.User{ID: ownerID}, Chat: group}, nil, bound},
+		{"member in bound group", &tgbotapi.Message{From: &tgbotapi.User{ID: memberID}, Chat: group}, nil, bound},
+		{"bound channel post", &tgbotapi.Message{Chat: channel}, archive, bound},
+		{"unbound group", &tgbotapi.Message{From: &tgbotapi.User{ID: memberID}, Chat: unbound}, nil, personal.Profile()},
+		{"unbound archive", &tgbotapi.Message{From: &tgbotapi.User{ID: memberID}, Chat: unbound}, archive, ProcessingProfile{FolderTemplate: "Archive", Silent: true, Convert: true}},
+	}
+
+	env := newHandlerEnv(t)
+	for _, chat := range []*tgbotapi.Chat{group, channel} {
+		if err := saveChatBinding(env.ctx, &ChatBinding{ChatID: chat.ID, OwnerID: ownerID, Profile: bound}); err != nil {
+			t.Fatalf("saveChatBinding: %v", err)
+		}
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveProfile(env.ctx, tt.message, personal, tt.archive)
+			if err != nil {
+				t.Fatalf("resolveProfile: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("resolveProfile = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
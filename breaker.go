@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// --- 斷路器 ---
+
+// Google 服務中斷時，每則訊息都會等到逾時才失敗；連續失敗達到門檻後暫停呼叫一段時間，直接回覆使用者
+const (
+	breakerThreshold = 5                // 連續失敗幾次後斷開
+	breakerCooldown  = 30 * time.Second // 斷開後多久放行一個探測請求
+)
+
+// errServiceDegraded 表示斷路器已斷開，請求沒有送出
+var errServiceDegraded = errors.New("service temporarily degraded")
+
+// 告知使用者服務暫時不穩定的訊息
+const degradedMessage = "Google 的服務暫時不穩定，請稍後再試。"
+
+var (
+	driveBreaker     = &circuitBreaker{name: "drive"}
+	firestoreBreaker = &circuitBreaker{name: "firestore"}
+)
+
+// circuitBreaker 在連續失敗達到門檻後斷開；冷卻時間過後放行一個探測請求，成功就恢復，失敗則再等一次冷卻時間
+type circuitBreaker struct {
+	name string
+
+	mu        sync.Mutex
+	failures  int       // 連續失敗的次數
+	openUntil time.Time // 斷開到這個時間後才放行探測請求
+	probing   bool      // 已放行一個探測請求，還在等待結果
+}
+
+// breakerOutcome 是一次呼叫對斷路器的意義
+type breakerOutcome int
+
+const (
+	outcomeSuccess breakerOutcome = iota
+	outcomeFailure
+	outcomeIgnored // 呼叫端取消等與服務狀態無關的結果
+)
+
+// allow 回報是否可以送出請求；斷開時回傳包裝了 errServiceDegraded 的錯誤
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < breakerThreshold {
+		return nil
+	}
+	if time.Now().Before(b.openUntil) {
+		return fmt.Errorf("%s: %w", b.name, errServiceDegraded)
+	}
+	// 探測期間其他請求仍然斷開；探測請求沒有回報結果時（例如串流沒有讀完），冷卻時間過後再放行一個
+	b.openUntil = time.Now().Add(breakerCooldown)
+	b.probing = true
+	slog.Info("Circuit breaker probing", "service", b.name)
+	return nil
+}
+
+// done 記錄一次請求的結果
+func (b *circuitBreaker) done(outcome breakerOutcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch outcome {
+	case outcomeIgnored:
+		b.probing = false
+		if b.failures >= breakerThreshold {
+			// 探測沒有結果，讓下一個請求立即再探測
+			b.openUntil = time.Time{}
+		}
+	case outcomeSuccess:
+		if b.failures >= breakerThreshold {
+			slog.Info("Circuit breaker closed", "service", b.name)
+		}
+		b.failures, b.probing = 0, false
+	case outcomeFailure:
+		b.failures++
+		if b.failures == breakerThreshold || b.probing {
+			slog.Warn("Circuit breaker opened", "service", b.name, "cooldown", breakerCooldown)
+		}
+		if b.failures >= breakerThreshold {
+			b.openUntil = time.Now().Add(breakerCooldown)
+		}
+		b.probing = false
+	}
+}
+
+// isOpen 回報斷路器目前是否斷開，冷卻時間過後視為可以探測
+func (b *circuitBreaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= breakerThreshold && time.Now().Before(b.openUntil)
+}
+
+// breakerTransport 讓 HTTP 請求經過斷路器；連線錯誤與 5xx 視為失敗
+type breakerTransport struct {
+	base    http.RoundTripper
+	breaker *circuitBreaker
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.breaker.allow(); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		t.breaker.done(outcomeIgnored)
+	case err != nil || resp.StatusCode >= http.StatusInternalServerError:
+		t.breaker.done(outcomeFailure)
+	default:
+		t.breaker.done(outcomeSuccess)
+	}
+	return resp, err
+}
+
+// withBreaker 回傳經過斷路器的 client，原本的 client 不受影響
+func withBreaker(client *http.Client, b *circuitBreaker) *http.Client {
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	wrapped := *client
+	wrapped.Transport = &breakerTransport{base: base, breaker: b}
+	return &wrapped
+}
+
+// grpcOutcome 將 gRPC 的錯誤分類；服務無法使用、內部錯誤與呼叫端沒有取消的逾時視為失敗
+func grpcOutcome(ctx context.Context, err error) breakerOutcome {
+	if err == nil || errors.Is(err, io.EOF) {
+		return outcomeSuccess
+	}
+	if ctx.Err() != nil {
+		return outcomeIgnored
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Internal, codes.DeadlineExceeded:
+		return outcomeFailure
+	case codes.Canceled:
+		return outcomeIgnored
+	}
+	return outcomeSuccess
+}
+
+// breakerUnaryInterceptor 讓 Firestore 的一般呼叫經過斷路器
+// 斷開時回傳的不是 gRPC 狀態，Firestore client 不會重試
+func breakerUnaryInterceptor(b *circuitBreaker) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if err := b.allow(); err != nil {
+			return err
+		}
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		b.done(grpcOutcome(ctx, err))
+		return err
+	}
+}
+
+// breakerStreamInterceptor 讓 Firestore 的串流呼叫（讀取文件與查詢）經過斷路器，以第一次讀取的結果判斷
+func breakerStreamInterceptor(b *circuitBreaker) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if err := b.allow(); err != nil {
+			return nil, err
+		}
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			b.done(grpcOutcome(ctx, err))
+			return nil, err
+		}
+		return &breakerStream{ClientStream: stream, breaker: b}, nil
+	}
+}
+
+type breakerStream struct {
+	grpc.ClientStream
+	breaker *circuitBreaker
+	once    sync.Once
+}
+
+func (s *breakerStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	s.once.Do(func() { s.breaker.done(grpcOutcome(s.Context(), err)) })
+	return err
+}
+
+// rejectWhenDegraded 在 Firestore 的斷路器斷開時直接回覆使用者，不再讓每則訊息等到逾時
+func rejectWhenDegraded(next updateHandler) updateHandler {
+	return func(ctx context.Context, update *tgbotapi.Update, body []byte) {
+		if !firestoreBreaker.isOpen() {
+			next(ctx, update, body)
+			return
+		}
+		slog.WarnContext(ctx, "Rejected update while Firestore is degraded")
+		switch {
+		case update.Message != nil:
+			// 只在私訊或下指令時回覆，避免在群組中對每則訊息都回覆
+			message := update.Message
+			if message.Chat.IsPrivate() || message.IsCommand() {
+				replyToUser(ctx, message.Chat.ID, message.MessageID, degradedMessage)
+			}
+		case update.CallbackQuery != nil:
+			answerCallback(ctx, update.CallbackQuery, degradedMessage)
+		case update.InlineQuery != nil:
+			answerInlineQuery(ctx, tgbotapi.InlineConfig{InlineQueryID: update.InlineQuery.ID})
+		}
+	}
+}
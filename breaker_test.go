@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	// 每個步驟：expire 為 true 時先讓冷卻時間結束，再呼叫 allow 並以 outcome 回報結果
+	type step struct {
+		expire    bool
+		wantAllow bool
+		outcome   breakerOutcome
+	}
+	failures := func(n int) []step {
+		steps := make([]step, n)
+		for i := range steps {
+			steps[i] = step{wantAllow: true, outcome: outcomeFailure}
+		}
+		return steps
+	}
+	tests := []struct {
+		name     string
+		steps    []step
+		wantOpen bool
+	}{
+		{"stays closed below the threshold", failures(breakerThreshold - 1), false},
+		{"opens at the threshold", failures(breakerThreshold), true},
+		{"rejects while open", append(failures(breakerThreshold), step{wantAllow: false}), true},
+		{"success resets the count", append(append(failures(breakerThreshold-1), step{wantAllow: true, outcome: outcomeSuccess}), failures(breakerThreshold-1)...), false},
+		{"ignored outcomes do not count", append(failures(breakerThreshold-1), step{wantAllow: true, outcome: outcomeIgnored}), false},
+		{"successful probe closes", append(failures(breakerThreshold), step{expire: true, wantAllow: true, outcome: outcomeSuccess}), false},
+		{"failed probe reopens", append(failures(breakerThreshold), step{expire: true, wantAllow: true, outcome: outcomeFailure}, step{wantAllow: false}), true},
+		{"ignored probe allows another probe", append(failures(breakerThreshold), step{expire: true, wantAllow: true, outcome: outcomeIgnored}, step{wantAllow: true, outcome: outcomeSuccess}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &circuitBreaker{name: "test"}
+			for i, s := range tt.steps {
+				if s.expire {
+					b.mu.Lock()
+					b.openUntil = time.Now().Add(-time.Second)
+					b.mu.Unlock()
+				}
+				err := b.allow()
+				if allowed := err == nil; allowed != s.wantAllow {
+					t.Fatalf("step %d: allow() = %v, want allowed %v", i, err, s.wantAllow)
+				}
+				if err != nil {
+					if !errors.Is(err, errServiceDegraded) {
+						t.Fatalf("step %d: allow() = %v, want errServiceDegraded", i, err)
+					}
+					continue
+				}
+				b.done(s.outcome)
+			}
+			if got := b.isOpen(); got != tt.wantOpen {
+				t.Errorf("isOpen() = %v, want %v", got, tt.wantOpen)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerProbeBlocksOthers(t *testing.T) {
+	b := &circuitBreaker{name: "test"}
+	for range breakerThreshold {
+		b.allow()
+		b.done(outcomeFailure)
+	}
+	b.openUntil = time.Now().Add(-time.Second)
+	if err := b.allow(); err != nil {
+		t.Fatalf("probe was not allowed: %v", err)
+	}
+	// 探測請求還沒有結果時，其他請求仍然被拒絕
+	if err := b.allow(); !errors.Is(err, errServiceDegraded) {
+		t.Errorf("second request during the probe = %v, want errServiceDegraded", err)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 說明文字 ---
+
+// {caption} 變數最多使用的字元數，過長的說明文字只取開頭
+const maxCaptionPlaceholderRunes = 60
+
+// captionTitle 取說明文字的第一行作為檔名的一部分
+func captionTitle(caption string) string {
+	title, _, _ := strings.Cut(strings.TrimSpace(caption), "\n")
+	title = strings.TrimSpace(title)
+	if runes := []rune(title); len(runes) > maxCaptionPlaceholderRunes {
+		title = strings.TrimSpace(string(runes[:maxCaptionPlaceholderRunes]))
+	}
+	return title
+}
+
+// uploadDescription 產生寫入檔案描述的文字：說明文字在前、轉傳來源在後，都沒有時回傳空字串
+// 說明文字固定放在開頭，編輯說明文字時才能只替換這一段
+func uploadDescription(caption string, origin *ForwardOrigin) string {
+	var parts []string
+	if caption = strings.TrimSpace(caption); caption != "" {
+		parts = append(parts, caption)
+	}
+	if origin != nil {
+		parts = append(parts, origin.Description())
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// replaceCaption 將描述開頭的舊說明文字換成新的，保留轉傳來源與 OCR 文字等其他內容
+func replaceCaption(description, oldCaption, caption string) string {
+	oldCaption, caption = strings.TrimSpace(oldCaption), strings.TrimSpace(caption)
+	rest := description
+	if oldCaption != "" && strings.HasPrefix(description, oldCaption) {
+		rest = strings.TrimLeft(strings.TrimPrefix(description, oldCaption), "\n")
+	}
+	switch {
+	case caption == "":
+		return rest
+	case rest == "":
+		return caption
+	}
+	return caption + "\n\n" + rest
+}
+
+// handleEditedCaption 將編輯後的說明文字同步到已上傳的檔案：更新檔案描述，檔名範本使用 {caption} 時也重新命名
+// 找不到 Firestore 的上傳紀錄時，改以上傳時寫入的 appProperties 找出檔案，只更新描述
+func handleEditedCaption(ctx context.Context, message *tgbotapi.Message, record *UploadRecord) {
+	var (
+		dest       Destination
+		userID     int64
+		fileID     string
+		oldCaption string
+	)
+	if record != nil {
+		dest, userID, fileID = destinations[record.Destination], record.UserID, record.FileID
+		if record.Meta != nil {
+			oldCaption = record.Meta.Caption
+		}
+		if oldCaption == message.Caption {
+			return
+		}
+		if record.Account != "" {
+			ctx = withGoogleAccount(ctx, record.Account)
+		}
+	} else {
+		// 頻道貼文沒有上傳紀錄時無法得知封存擁有者
+		if message.From == nil {
+			return
+		}
+		userID = message.From.ID
+		settings, err := loadUserSettings(ctx, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+			return
+		}
+		d, ok := userDestination(ctx, settings).(fetchDestination)
+		if !ok {
+			return
+		}
+		source := &TelegramSource{ChatID: message.Chat.ID, MessageID: message.MessageID}
+		files, err := d.FindByProperties(ctx, userID, source.Properties(), 1)
+		if errors.Is(err, errNotConnected) {
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to look up edited file", "error", err)
+			return
+		}
+		if len(files) == 0 {
+			return
+		}
+		dest, fileID = d, files[0].ID
+	}
+	if dest == nil {
+		return
+	}
+
+	if d, ok := dest.(captionDestination); ok {
+		if err := d.SetCaption(ctx, userID, fileID, oldCaption, message.Caption); err != nil {
+			slog.ErrorContext(ctx, "Failed to update file description", "destination", dest.Name(), "file_id", fileID, "error", err)
+		}
+	}
+	// 沒有上傳紀錄，或是較舊的上傳紀錄沒有 meta 時只更新描述
+	if record == nil || record.Meta == nil {
+		slog.InfoContext(ctx, "Updated caption of edited message", "file_id", fileID)
+		return
+	}
+	updates := []Update{{Path: "meta.caption", Value: message.Caption}}
+	record.Meta.Caption = message.Caption
+	if strings.Contains(record.FilenameTemplate, "{caption}") {
+		name := renderFileName(record.FilenameTemplate, record.Meta)
+		if d, ok := dest.(renameDestination); ok && name != record.Name {
+			if err := d.Rename(ctx, userID, fileID, name); err != nil {
+				slog.ErrorContext(ctx, "Failed to rename edited file", "destination", dest.Name(), "file_id", fileID, "error", err)
+			} else {
+				updates = append(updates, Update{Path: "name", Value: name})
+			}
+		}
+	}
+	err := collection(ctx, uploadCollection).Doc(uploadRecordDocID(record.ChatID, record.MessageID)).Update(ctx, updates)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to update upload record", "error", err)
+	}
+	slog.InfoContext(ctx, "Updated caption of edited message", "file_id", fileID)
+}
+
+// SetCaption 更新 Drive 檔案描述開頭的說明文字
+func (driveDestination) SetCaption(ctx context.Context, userID int64, fileID, oldCaption, caption string) error {
+	driveService, err := newDriveService(ctx, userID)
+	if err != nil {
+		return err
+	}
+	file, err := driveService.Files.Get(fileID).Fields("description").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to get description: %v", err)
+	}
+	description := replaceCaption(file.Description, oldCaption, caption)
+	if description == file.Description {
+		return nil
+	}
+	return setDriveDescription(ctx, userID, fileID, description)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"google.golang.org/api/aiplatform/v1"
+)
+
+// --- AI 自動分類 ---
+
+// 可以分類的類別與預設的資料夾；other 代表不分類，照原本的資料夾範本上傳
+var defaultCategoryFolders = map[string]string{
+	"invoice":    "發票",
+	"receipt":    "收據",
+	"photo":      "照片",
+	"screenshot": "截圖",
+	"ebook":      "電子書",
+	"document":   "文件",
+}
+
+const categoryOther = "other"
+
+// 送給 Gemini 的檔案內容上限，超過時只以檔名與說明文字分類
+const maxCategorizeContentSize = 10 * 1024 * 1024
+
+// categoryNames 回傳所有類別，依字母排序
+func categoryNames() []string {
+	names := make([]string, 0, len(defaultCategoryFolders))
+	for name := range defaultCategoryFolders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// categoryFolder 回傳類別的資料夾範本，使用者自訂的優先
+func categoryFolder(settings *UserSettings, category string) string {
+	if folder, ok := settings.CategoryFolders[category]; ok && folder != "" {
+		return folder
+	}
+	return defaultCategoryFolders[category]
+}
+
+// categorizeFile 以 Gemini 判斷檔案的類別；content 可以是 nil，此時只依檔名與說明文字判斷
+func categorizeFile(ctx context.Context, file *telegramFile, caption string, content []byte) (string, error) {
+	prompt := fmt.Sprintf(
+		"請判斷這個檔案屬於以下哪一個類別：%s、%s。只回覆類別的英文名稱，不要加上其他文字。\n檔名：%s\n說明文字：%s",
+		strings.Join(categoryNames(), "、"), categoryOther, file.Name, caption)
+
+	var parts []*aiplatform.GoogleCloudAiplatformV1Part
+	if len(content) > 0 && len(content) <= maxCategorizeContentSize &&
+		(strings.HasPrefix(file.MimeType, "image/") || summarizableMimeType(file.MimeType)) {
+		parts = append(parts, inlinePart(file.MimeType, content))
+	}
+	answer, err := generateText(ctx, prompt, parts...)
+	if err != nil {
+		return "", err
+	}
+	category := strings.ToLower(strings.Trim(answer, " \n.。`\"'"))
+	if _, ok := defaultCategoryFolders[category]; !ok {
+		return categoryOther, nil
+	}
+	return category, nil
+}
+
+// 處理 /categories 指令：查看或設定各類別要放到哪個資料夾
+func handleCategories(ctx context.Context, message *tgbotapi.Message) {
+	userID := message.From.ID
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的設定時發生錯誤，請稍後再試。")
+		return
+	}
+
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 {
+		var sb strings.Builder
+		state := "關閉"
+		if settings.AutoCategorize {
+			state = "開啟"
+		}
+		fmt.Fprintf(&sb, "自動分類目前為%s，可在 /settings 中切換。\n\n各類別的資料夾：\n", state)
+		for _, name := range categoryNames() {
+			fmt.Fprintf(&sb, "• %s → %s\n", name, categoryFolder(settings, name))
+		}
+		sb.WriteString("\n設定方式：/categories <類別> <資料夾範本>\n還原預設：/categories <類別> reset\n無法分類的檔案會照原本的資料夾範本上傳。")
+		replyToUser(ctx, message.Chat.ID, message.MessageID, sb.String())
+		return
+	}
+
+	category := strings.ToLower(args[0])
+	if _, ok := defaultCategoryFolders[category]; !ok || len(args) < 2 {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("用法：/categories <類別> <資料夾範本>，可用的類別：%s", strings.Join(categoryNames(), "、")))
+		return
+	}
+	folder := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(message.CommandArguments()), args[0]))
+	if folder == "reset" {
+		folder = ""
+	} else if err := validateTemplate(folder, folderTemplate); err != nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("資料夾範本無效：%v", err))
+		return
+	}
+
+	if err := updateUserSettings(ctx, userID, map[string]interface{}{
+		"category_folders": map[string]interface{}{category: folder},
+	}); err != nil {
+		slog.ErrorContext(ctx, "Failed to save category folder", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "儲存設定時發生錯誤，請稍後再試。")
+		return
+	}
+	if folder == "" {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("已將 %s 還原為預設資料夾「%s」。", category, defaultCategoryFolders[category]))
+		return
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("之後分類為 %s 的檔案會上傳到「%s」。", category, folder))
+}
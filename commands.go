@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 指令 ---
+
+// botCommand 描述一個指令；Description 會同時用於 /help 與 Telegram 用戶端的指令自動完成
+type botCommand struct {
+	Name        string
+	Description string
+	Handler     func(ctx context.Context, message *tgbotapi.Message)
+	AdminOnly   bool // 只有 ADMIN_USER_IDS 中的使用者可以使用，其他人視為無法辨識的指令
+}
+
+// 依註冊順序排列，/help 也依此順序顯示
+var (
+	commandList  []*botCommand
+	commandIndex = map[string]*botCommand{}
+)
+
+func registerCommand(c *botCommand) {
+	commandList = append(commandList, c)
+	commandIndex[c.Name] = c
+}
+
+// registerCommands 註冊所有指令，需在目的地都註冊後呼叫，才會為每個目的地產生 /connect_<name>
+func registerCommands() {
+	registerCommand(&botCommand{Name: "start", Description: "開始使用", Handler: handleStart})
+	registerCommand(&botCommand{Name: "help", Description: "列出所有指令", Handler: handleHelp})
+	for _, name := range destinationNames() {
+		dest := destinations[name]
+		registerCommand(&botCommand{
+			Name:        "connect_" + name,
+			Description: "連結 " + dest.DisplayName(),
+			Handler: func(ctx context.Context, message *tgbotapi.Message) {
+				handleConnect(ctx, message, dest)
+			},
+		})
+	}
+	registerCommand(&botCommand{Name: "settings", Description: "查看並切換個人設定", Handler: handleSettings})
+	registerCommand(&botCommand{Name: "destination", Description: "查看或切換上傳目的地", Handler: handleDestination})
+	registerCommand(&botCommand{Name: "media_destination", Description: "指定照片與影片的上傳目的地", Handler: handleMediaDestination})
+	registerCommand(&botCommand{Name: "ledger", Description: "將上傳紀錄寫入 Google 試算表", Handler: handleLedger})
+	registerCommand(&botCommand{Name: "remind", Description: "在 Google 日曆建立提醒", Handler: handleRemind})
+	registerCommand(&botCommand{Name: "gmail_attachments", Description: "將 Gmail 郵件的附件複製到雲端", Handler: handleGmailAttachments})
+	registerCommand(&botCommand{Name: "request_file", Description: "產生讓別人上傳檔案的一次性連結", Handler: handleRequestFile})
+	registerCommand(&botCommand{Name: "app", Description: "在 Telegram 中開啟設定與上傳紀錄", Handler: handleApp})
+	registerCommand(&botCommand{Name: "email_address", Description: "取得專屬的郵件上傳信箱", Handler: handleEmailAddress})
+	registerCommand(&botCommand{Name: "permissions", Description: "查看或追加 Google 權限", Handler: handlePermissions})
+	registerCommand(&botCommand{Name: "accounts", Description: "管理已連結的 Google 帳號", Handler: handleAccounts})
+	registerCommand(&botCommand{Name: "filename_template", Description: "設定檔名範本", Handler: handleFilenameTemplate})
+	registerCommand(&botCommand{Name: "folder_template", Description: "設定資料夾範本", Handler: handleFolderTemplate})
+	registerCommand(&botCommand{Name: "confirm", Description: "切換上傳前確認", Handler: handleConfirm})
+	registerCommand(&botCommand{Name: "pick_destination", Description: "設定每次上傳時選擇目的地", Handler: handlePickDestination})
+	registerCommand(&botCommand{Name: "resize", Description: "設定上傳前縮小大圖", Handler: handleResize})
+	registerCommand(&botCommand{Name: "photo_size", Description: "選擇上傳的照片尺寸", Handler: handlePhotoSize})
+	registerCommand(&botCommand{Name: "photo_quality", Description: "切換壓縮照片的原始畫質提醒", Handler: handlePhotoQuality})
+	registerCommand(&botCommand{Name: "exif", Description: "依照片的拍攝時間與地點整理", Handler: handleExif})
+	registerCommand(&botCommand{Name: "digest", Description: "設定定期的上傳摘要", Handler: handleDigest})
+	registerCommand(&botCommand{Name: "webhook", Description: "設定上傳事件的 Webhook", Handler: handleWebhook})
+	registerCommand(&botCommand{Name: "usage", Description: "查看您的上傳統計與剩餘額度", Handler: handleUsage})
+	registerCommand(&botCommand{Name: "get", Description: "將已上傳的檔案傳回 Telegram", Handler: handleGet})
+	registerCommand(&botCommand{Name: "rename", Description: "重新命名已上傳的檔案", Handler: handleRename})
+	registerCommand(&botCommand{Name: "star", Description: "將上傳的檔案加上星號", Handler: handleStar})
+	registerCommand(&botCommand{Name: "trash", Description: "將上傳的檔案移到垃圾桶", Handler: handleTrash})
+	registerCommand(&botCommand{Name: "restore", Description: "還原垃圾桶中的檔案", Handler: handleRestore})
+	registerCommand(&botCommand{Name: "share", Description: "產生已上傳檔案的分享連結", Handler: handleShare})
+	registerCommand(&botCommand{Name: "join", Description: "說明如何合併分割上傳的檔案", Handler: handleJoin})
+	registerCommand(&botCommand{Name: "zip", Description: "將多個檔案打包成一個 ZIP 上傳", Handler: handleZip})
+	registerCommand(&botCommand{Name: "import", Description: "匯入轉傳的舊訊息中的檔案", Handler: handleImport})
+	registerCommand(&botCommand{Name: "migrate", Description: "將上傳過的檔案複製或搬移到另一個目的地", Handler: handleMigrate})
+	registerCommand(&botCommand{Name: "categories", Description: "設定 AI 自動分類的資料夾", Handler: handleCategories})
+	registerCommand(&botCommand{Name: "tags", Description: "設定 hashtag 對應的資料夾", Handler: handleTags})
+	registerCommand(&botCommand{Name: "summarize", Description: "以 AI 摘要已上傳的文件", Handler: handleSummarize})
+	registerCommand(&botCommand{Name: "save_sticker_set", Description: "將整個貼圖包存到雲端", Handler: handleSaveStickerSet})
+	registerCommand(&botCommand{Name: "note", Description: "將文字存成筆記", Handler: handleNote})
+	registerCommand(&botCommand{Name: "pdf", Description: "將網頁存成 PDF", Handler: handlePDF})
+	registerCommand(&botCommand{Name: "binding", Description: "將群組綁定到您的儲存空間", Handler: handleBinding})
+	registerCommand(&botCommand{Name: "enable_archive", Description: "開啟群組或頻道的封存模式", Handler: handleEnableArchive})
+	registerCommand(&botCommand{Name: "disable_archive", Description: "關閉封存模式", Handler: handleDisableArchive})
+	registerCommand(&botCommand{Name: "archive_polls", Description: "在封存模式中保存投票結果", Handler: handleArchivePolls})
+	registerCommand(&botCommand{Name: "cancel", Description: "取消進行中的操作", Handler: handleCancel})
+	registerCommand(&botCommand{Name: "export_my_data", Description: "匯出機器人儲存的個人資料", Handler: handleExportMyData})
+	registerCommand(&botCommand{Name: "forget_me", Description: "刪除機器人儲存的所有個人資料", Handler: handleForgetMe})
+	registerCommand(&botCommand{Name: "admin", Description: "管理員指令", Handler: handleAdmin, AdminOnly: true})
+}
+
+// dispatchCommand 執行訊息中的指令
+func dispatchCommand(ctx context.Context, message *tgbotapi.Message) {
+	name := message.Command()
+	c, ok := commandIndex[name]
+	if !ok || (c.AdminOnly && (message.From == nil || !isAdmin(ctx, message.From.ID))) {
+		if strings.HasPrefix(name, "connect_") {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "此機器人尚未啟用這個目的地。")
+			return
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "無法辨識的指令，輸入 /help 查看所有指令。")
+		return
+	}
+	c.Handler(ctx, message)
+}
+
+// 處理 /start 指令
+func handleStart(ctx context.Context, message *tgbotapi.Message) {
+	replyToUser(ctx, message.Chat.ID, message.MessageID, "歡迎使用！請使用 /connect_drive 來授權 Google Drive，輸入 /help 查看所有指令。")
+}
+
+// 處理 /help 指令：依照註冊的指令產生說明，管理員會額外看到管理員指令
+func handleHelp(ctx context.Context, message *tgbotapi.Message) {
+	admin := message.From != nil && isAdmin(ctx, message.From.ID)
+	var sb strings.Builder
+	sb.WriteString("可用的指令：\n")
+	for _, c := range commandList {
+		if c.AdminOnly && !admin {
+			continue
+		}
+		fmt.Fprintf(&sb, "/%s - %s\n", c.Name, c.Description)
+	}
+	sb.WriteString("\n直接傳送檔案或圖片即可上傳。")
+	replyToUser(ctx, message.Chat.ID, message.MessageID, sb.String())
+}
+
+// syncBotCommands 呼叫 setMyCommands，讓 Telegram 用戶端可以自動完成指令
+// 管理員指令只設定在每位管理員的私訊中
+func syncBotCommands(ctx context.Context) error {
+	var public, all []tgbotapi.BotCommand
+	for _, c := range commandList {
+		bc := tgbotapi.BotCommand{Command: c.Name, Description: c.Description}
+		all = append(all, bc)
+		if !c.AdminOnly {
+			public = append(public, bc)
+		}
+	}
+	if _, err := senderFor(ctx).Request(tgbotapi.NewSetMyCommands(public...)); err != nil {
+		return fmt.Errorf("failed to set bot commands: %v", err)
+	}
+	for _, adminID := range configFor(ctx).AdminUserIDs {
+		if _, err := senderFor(ctx).Request(tgbotapi.NewSetMyCommandsWithScope(tgbotapi.NewBotCommandScopeChat(adminID), all...)); err != nil {
+			// 管理員尚未與機器人對話過時會失敗，不影響其他人
+			slog.WarnContext(ctx, "Failed to set admin bot commands", "admin_id", adminID, "error", err)
+		}
+	}
+	return nil
+}
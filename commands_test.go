@@ -0,0 +1,68 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// useCommands 依測試環境中的目的地重新註冊所有指令，測試結束時恢復
+func useCommands(t *testing.T) {
+	previousList, previousIndex := commandList, commandIndex
+	commandList, commandIndex = nil, map[string]*botCommand{}
+	registerCommands()
+	t.Cleanup(func() { commandList, commandIndex = previousList, previousIndex })
+}
+
+// commandMessage 產生使用者在私人對話中傳送指令的訊息
+func commandMessage(userID int64, text string) *tgbotapi.Message {
+	command, _, _ := strings.Cut(text, " ")
+	return &tgbotapi.Message{
+		MessageID: 1,
+		From:      &tgbotapi.User{ID: userID, FirstName: "Tester"},
+		Chat:      &tgbotapi.Chat{ID: userID, Type: "private"},
+		Text:      text,
+		Entities:  []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}},
+	}
+}
+
+func TestDispatchCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		admin     bool
+		wantReply string
+		notReply  string // 回覆中不應出現的文字
+	}{
+		{name: "start", text: "/start", wantReply: "歡迎使用"},
+		{name: "help", text: "/help", wantReply: "/connect_drive - ", notReply: "/admin"},
+		{name: "help for admin", text: "/help", admin: true, wantReply: "/admin - "},
+		{name: "bot username suffix", text: "/start@test_bot", wantReply: "歡迎使用"},
+		{name: "unknown", text: "/nope", wantReply: "無法辨識的指令"},
+		{name: "disabled destination", text: "/connect_dropbox", wantReply: "此機器人尚未啟用這個目的地"},
+		{name: "admin only", text: "/admin stats", wantReply: "無法辨識的指令"},
+		{name: "connect", text: "/connect_drive", wantReply: "https://"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newHandlerEnv(t)
+			useCommands(t)
+			userID := int64(7600 + i)
+			if tt.admin {
+				env.config.AdminUserIDs = []int64{userID}
+			}
+
+			dispatchCommand(env.ctx, commandMessage(userID, tt.text))
+
+			messages := env.sender.messages(userID)
+			if !slices.ContainsFunc(messages, func(m string) bool { return strings.Contains(m, tt.wantReply) }) {
+				t.Errorf("messages = %q, want one containing %q", messages, tt.wantReply)
+			}
+			if tt.notReply != "" && slices.ContainsFunc(messages, func(m string) bool { return strings.Contains(m, tt.notReply) }) {
+				t.Errorf("messages = %q, want none containing %q", messages, tt.notReply)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 上傳前確認 ---
+
+// Firestore 集合名稱
+const pendingUploadCollection = "pending_uploads"
+
+// 等待使用者確認的上傳在多久後失效
+const pendingUploadTTL = 10 * time.Minute
+
+// PendingUpload 是等待使用者確認、選擇 Google 帳號或選擇目的地的上傳
+type PendingUpload struct {
+	UserID        int64          `firestore:"user_id"`
+	Message       string         `firestore:"message"`        // 原始訊息的 JSON，確認後用來重新處理檔案
+	Accounts      []string       `firestore:"accounts"`       // 可選擇的 Google 帳號，不需要選擇時為空
+	Targets       []UploadTarget `firestore:"targets"`        // 可選擇的目的地，不需要選擇時為空
+	DefaultTarget int            `firestore:"default_target"` // 逾時未選擇時使用的目的地
+	ThreadID      int            `firestore:"thread_id"`      // 論壇主題，讓上傳結果回覆到同一個主題
+	ExpireAt      time.Time      `firestore:"expire_at"`
+}
+
+type uploadConfirmedKey struct{}
+
+// withUploadConfirmed 標記使用者已經確認這次上傳，handleFile 不會再次詢問
+func withUploadConfirmed(ctx context.Context) context.Context {
+	return context.WithValue(ctx, uploadConfirmedKey{}, true)
+}
+
+func uploadConfirmed(ctx context.Context) bool {
+	confirmed, _ := ctx.Value(uploadConfirmedKey{}).(bool)
+	return confirmed
+}
+
+// promptUpload 在使用者開啟上傳前確認、開啟「每次上傳時選擇帳號」且連結了多個 Google 帳號，
+// 或開啟原始畫質提醒且傳送的是被壓縮的照片時，回覆檔案資訊與按鈕，等使用者按下後再上傳。回傳 false 時應直接上傳
+func promptUpload(ctx context.Context, message *tgbotapi.Message, settings *UserSettings, dest Destination, file *telegramFile) bool {
+	if uploadConfirmed(ctx) {
+		return false
+	}
+
+	var emails []string
+	if dest.Name() == "drive" && settings.AskDriveAccount {
+		var err error
+		emails, _, err = listGoogleAccounts(ctx, message.From.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list google accounts", "error", err)
+		}
+		if len(emails) < 2 {
+			emails = nil
+		}
+	}
+	photoHint := settings.PhotoQualityHint && file.Type == "photo"
+	if !settings.ConfirmUpload && emails == nil && !photoHint {
+		return false
+	}
+
+	raw, err := json.Marshal(message)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode message", "error", err)
+		return false
+	}
+	var threadID int
+	if topic := forumTopicFromContext(ctx); topic != nil {
+		threadID = topic.ThreadID
+	}
+	b := make([]byte, 9)
+	rand.Read(b)
+	id := base64.RawURLEncoding.EncodeToString(b)
+	err = collection(ctx, pendingUploadCollection).Doc(id).Set(ctx, &PendingUpload{
+		UserID:   message.From.ID,
+		Message:  string(raw),
+		Accounts: emails,
+		ThreadID: threadID,
+		ExpireAt: time.Now().Add(pendingUploadTTL),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to save pending upload", "error", err)
+		return false
+	}
+
+	// 大小未知時 Telegram 回報 0
+	size := "大小未知"
+	if file.Size > 0 {
+		size = formatSize(file.Size)
+	}
+	text := fmt.Sprintf("檔案：%s（%s）\n要上傳到您的 %s 嗎？", file.Name, size, dest.DisplayName())
+	if photoHint {
+		text = fmt.Sprintf("這張照片已被 Telegram 壓縮（%s）。若要保留原始畫質，請以「檔案」方式重新傳送。\n仍要將壓縮後的照片上傳到您的 %s 嗎？", size, dest.DisplayName())
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if emails != nil {
+		text = fmt.Sprintf("檔案：%s（%s）\n要上傳到哪個 Google 帳號？", file.Name, size)
+		if photoHint {
+			text = fmt.Sprintf("這張照片已被 Telegram 壓縮（%s）。若要保留原始畫質，請以「檔案」方式重新傳送。\n仍要上傳的話，要上傳到哪個 Google 帳號？", size)
+		}
+		for i, email := range emails {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData(email, encodeCallbackData("upload", id, strconv.Itoa(i))),
+			))
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("取消", encodeCallbackData("upload", id, "cancel"))))
+	} else {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("上傳", encodeCallbackData("upload", id, "ok")),
+			tgbotapi.NewInlineKeyboardButtonData("取消", encodeCallbackData("upload", id, "cancel")),
+		))
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyToMessageID = message.MessageID
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	if _, err := sendMessage(ctx, msg); err != nil {
+		slog.ErrorContext(ctx, "Failed to send message", "error", err)
+		return false
+	}
+	return true
+}
+
+// handleUploadCallback 處理上傳確認訊息上的按鈕：ok 上傳、cancel 取消，數字代表選擇的 Google 帳號
+func handleUploadCallback(ctx context.Context, query *tgbotapi.CallbackQuery, args []string) {
+	if len(args) != 2 {
+		answerCallback(ctx, query, "")
+		return
+	}
+	ref := collection(ctx, pendingUploadCollection).Doc(args[0])
+	doc, err := ref.Get(ctx)
+	if err != nil {
+		if !errors.Is(err, errDocNotFound) {
+			slog.ErrorContext(ctx, "Failed to load pending upload", "error", err)
+		}
+		answerCallback(ctx, query, "這個檔案已經處理過或已過期，請重新傳送。")
+		return
+	}
+	var pending PendingUpload
+	if err := doc.DataTo(&pending); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode pending upload", "error", err)
+		answerCallback(ctx, query, "發生錯誤，請重新傳送檔案。")
+		return
+	}
+	if pending.UserID != query.From.ID {
+		answerCallback(ctx, query, "只有傳送檔案的人可以操作。")
+		return
+	}
+	if time.Now().After(pending.ExpireAt) {
+		answerCallback(ctx, query, "這個檔案已經處理過或已過期，請重新傳送。")
+		return
+	}
+
+	var email string
+	switch args[1] {
+	case "ok", "cancel":
+	default:
+		i, err := strconv.Atoi(args[1])
+		if err != nil || i < 0 || i >= len(pending.Accounts) {
+			answerCallback(ctx, query, "")
+			return
+		}
+		email = pending.Accounts[i]
+	}
+
+	// 先刪除再上傳，避免重複點擊造成重複上傳
+	if err := ref.Delete(ctx); err != nil {
+		slog.ErrorContext(ctx, "Failed to delete pending upload", "error", err)
+	}
+
+	if args[1] == "cancel" {
+		answerCallback(ctx, query, "已取消")
+		editPrompt(ctx, query, "已取消上傳。")
+		return
+	}
+
+	var message tgbotapi.Message
+	if err := json.Unmarshal([]byte(pending.Message), &message); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode pending message", "error", err)
+		answerCallback(ctx, query, "發生錯誤，請重新傳送檔案。")
+		return
+	}
+	ctx = withUploadConfirmed(ctx)
+	if email != "" {
+		ctx = withGoogleAccount(ctx, email)
+		answerCallback(ctx, query, "上傳到 "+email)
+		editPrompt(ctx, query, "上傳到 "+email+"…")
+	} else {
+		answerCallback(ctx, query, "開始上傳")
+		editPrompt(ctx, query, "上傳中…")
+	}
+	if pending.ThreadID != 0 {
+		ctx = withForumTopic(ctx, &forumTopic{ThreadID: pending.ThreadID})
+	}
+	handleFile(ctx, &message)
+}
+
+// editPrompt 將確認訊息改成處理結果並移除按鈕
+func editPrompt(ctx context.Context, query *tgbotapi.CallbackQuery, text string) {
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	if _, err := sendChattable(ctx, query.Message.Chat.ID, edit); err != nil {
+		slog.WarnContext(ctx, "Failed to update upload prompt", "error", err)
+	}
+}
+
+// 處理 /confirm 指令：切換上傳前是否先確認
+func handleConfirm(ctx context.Context, message *tgbotapi.Message) {
+	on, ok := parseOnOff(message.CommandArguments())
+	if !ok {
+		settings, err := loadUserSettings(ctx, message.From.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load user settings", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取設定時發生錯誤，請稍後再試。")
+			return
+		}
+		state := "關閉"
+		if settings.ConfirmUpload {
+			state = "開啟"
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "上傳前確認目前為"+state+"。\n用法：/confirm on|off")
+		return
+	}
+	if err := updateUserSettings(ctx, message.From.ID, map[string]interface{}{"confirm_upload": on}); err != nil {
+		slog.ErrorContext(ctx, "Failed to update settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "更新設定時發生錯誤，請稍後再試。")
+		return
+	}
+	if on {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "已開啟上傳前確認，之後傳送檔案時會先詢問是否上傳。")
+	} else {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "已關閉上傳前確認。")
+	}
+}
+
+// 處理 /photo_quality 指令：切換收到被壓縮的照片時是否提醒改用檔案傳送
+func handlePhotoQuality(ctx context.Context, message *tgbotapi.Message) {
+	on, ok := parseOnOff(message.CommandArguments())
+	if !ok {
+		settings, err := loadUserSettings(ctx, message.From.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load user settings", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取設定時發生錯誤，請稍後再試。")
+			return
+		}
+		state := "關閉"
+		if settings.PhotoQualityHint {
+			state = "開啟"
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "原始畫質提醒目前為"+state+"。\n用法：/photo_quality on|off")
+		return
+	}
+	if err := updateUserSettings(ctx, message.From.ID, map[string]interface{}{"photo_quality_hint": on}); err != nil {
+		slog.ErrorContext(ctx, "Failed to update settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "更新設定時發生錯誤，請稍後再試。")
+		return
+	}
+	if on {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "已開啟原始畫質提醒，之後傳送照片時會先詢問是否上傳壓縮後的版本。")
+	} else {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "已關閉原始畫質提醒。")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 多步驟對話 ---
+
+// Firestore 集合名稱
+const conversationCollection = "conversations"
+
+// 對話在最後一次回覆後多久失效
+const conversationTTL = 10 * time.Minute
+
+// Conversation 記錄使用者目前進行中的多步驟對話，例如 /connect_webdav 的引導流程
+type Conversation struct {
+	Flow      string            `firestore:"flow"`
+	Step      string            `firestore:"step"`
+	Data      map[string]string `firestore:"data"`
+	UpdatedAt time.Time         `firestore:"updated_at"`
+	ExpireAt  time.Time         `firestore:"expire_at"`
+}
+
+// conversationHandlers 依 Flow 名稱處理對話中的下一則訊息
+var conversationHandlers = map[string]func(ctx context.Context, message *tgbotapi.Message, conv *Conversation){}
+
+// loadConversation 讀取使用者進行中的對話，沒有或已過期時回傳 nil
+func loadConversation(ctx context.Context, userID int64) (*Conversation, error) {
+	doc, err := collection(ctx, conversationCollection).Doc(fmt.Sprintf("%d", userID)).Get(ctx)
+	if err != nil {
+		if errors.Is(err, errDocNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var conv Conversation
+	if err := doc.DataTo(&conv); err != nil {
+		return nil, err
+	}
+	if time.Now().After(conv.ExpireAt) {
+		return nil, nil
+	}
+	return &conv, nil
+}
+
+// saveConversation 儲存對話進度並延長有效期限
+func saveConversation(ctx context.Context, userID int64, conv *Conversation) error {
+	conv.UpdatedAt = time.Now()
+	conv.ExpireAt = conv.UpdatedAt.Add(conversationTTL)
+	err := collection(ctx, conversationCollection).Doc(fmt.Sprintf("%d", userID)).Set(ctx, conv)
+	return err
+}
+
+func clearConversation(ctx context.Context, userID int64) error {
+	err := collection(ctx, conversationCollection).Doc(fmt.Sprintf("%d", userID)).Delete(ctx)
+	return err
+}
+
+// handleConversationMessage 將私訊中的文字交給進行中的對話處理，沒有對話時回傳 false
+func handleConversationMessage(ctx context.Context, message *tgbotapi.Message) bool {
+	if !message.Chat.IsPrivate() || message.From == nil || message.Text == "" {
+		return false
+	}
+	conv, err := loadConversation(ctx, message.From.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load conversation", "error", err)
+		return false
+	}
+	if conv == nil {
+		return false
+	}
+	handler, ok := conversationHandlers[conv.Flow]
+	if !ok {
+		return false
+	}
+	handler(ctx, message, conv)
+	return true
+}
+
+// 處理 /cancel 指令：結束進行中的對話
+func handleCancel(ctx context.Context, message *tgbotapi.Message) {
+	conv, err := loadConversation(ctx, message.From.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load conversation", "error", err)
+	}
+	if conv == nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "目前沒有進行中的操作。")
+		return
+	}
+	if err := clearConversation(ctx, message.From.ID); err != nil {
+		slog.ErrorContext(ctx, "Failed to clear conversation", "error", err)
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, "已取消。")
+}
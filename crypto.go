@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// --- 憑證加密 ---
+
+// decodeCredentialsKey 解碼 CREDENTIALS_ENCRYPTION_KEY，內容為 base64 編碼的 32 位元組 AES-256 金鑰，
+// 用來加密存放在 Firestore 中的第三方憑證（例如 WebDAV 密碼）
+func decodeCredentialsKey(v string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		return nil, fmt.Errorf("CREDENTIALS_ENCRYPTION_KEY is not valid base64: %v", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("CREDENTIALS_ENCRYPTION_KEY must decode to 32 bytes, got %d", len(key))
+	}
+	return key, nil
+}
+
+var errNoCredentialsKey = errors.New("CREDENTIALS_ENCRYPTION_KEY not set")
+
+// encryptSecret 以 AES-256-GCM 加密，回傳 base64 編碼的 nonce 與密文
+func encryptSecret(ctx context.Context, plaintext string) (string, error) {
+	gcm, err := credentialsCipher(ctx)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptSecret 解密 encryptSecret 產生的字串
+func decryptSecret(ctx context.Context, encoded string) (string, error) {
+	gcm, err := credentialsCipher(ctx)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("ciphertext too short")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %v", err)
+	}
+	return string(plaintext), nil
+}
+
+func credentialsCipher(ctx context.Context) (cipher.AEAD, error) {
+	encoded := configFor(ctx).CredentialsEncryptionKey
+	if encoded == "" {
+		return nil, errNoCredentialsKey
+	}
+	key, err := decodeCredentialsKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
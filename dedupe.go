@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Firestore 集合名稱
+const processedUpdateCollection = "processed_updates"
+
+// Telegram 重試 webhook 的時間窗遠小於此值，過期的紀錄可以透過 Firestore TTL 清除
+const processedUpdateTTL = 24 * time.Hour
+
+// claimUpdate 以 update_id 作為文件 ID 建立紀錄，回傳 false 表示這個更新已經處理過
+// Create 在文件已存在時會失敗，因此即使多個執行個體同時收到重試，也只有一個會處理
+func claimUpdate(ctx context.Context, updateID int) (bool, error) {
+	if redisClient != nil {
+		return redisClaimUpdate(ctx, updateID)
+	}
+	now := time.Now()
+	err := collection(ctx, processedUpdateCollection).Doc(fmt.Sprintf("%d", updateID)).Create(ctx, map[string]interface{}{
+		"update_id":  updateID,
+		"created_at": now,
+		"expire_at":  now.Add(processedUpdateTTL),
+	})
+	if err != nil {
+		if errors.Is(err, errDocExists) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
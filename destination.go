@@ -0,0 +1,426 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"golang.org/x/oauth2"
+)
+
+// --- 上傳目的地 ---
+
+// UploadFile 描述一個要上傳到目的地的檔案
+type UploadFile struct {
+	Name     string          // 上傳後的檔名
+	Folders  []string        // 目標資料夾路徑，nil 代表根目錄
+	Body     io.Reader       // 檔案內容
+	MimeType string          // 檔案的 MIME 類型，空字串時由目的地自行判斷
+	Convert  bool            // 轉成目的地的原生格式，例如 Google 文件；目的地不支援時忽略
+	Caption  string          // 訊息的說明文字，目的地支援時寫入檔案的描述
+	Origin   *ForwardOrigin  // 轉傳訊息的原始來源，不是轉傳時為 nil；目的地支援時寫入檔案的描述或中繼資料
+	Source   *TelegramSource // 檔案來自的 Telegram 訊息，打包等沒有單一來源的檔案為 nil
+	Tags     []string        // 說明文字中的 hashtag（小寫、不含 #），目的地支援時寫入標籤
+	Location string          // 照片的拍攝地點，目的地支援時附加在檔案描述最後
+}
+
+// UploadResult 是上傳完成後目的地回傳的資訊
+type UploadResult struct {
+	FileID  string // 目的地中的檔案識別碼
+	Name    string // 實際儲存的檔名（目的地可能會自動改名）
+	Link    string // 檔案的網址，目的地不提供時為空字串
+	Account string // 上傳時使用的帳號，例如 Google 帳號的 email；目的地只有一個帳號時為空字串
+}
+
+// Destination 是雲端儲存目的地，每個實作負責自己的授權流程與上傳方式
+type Destination interface {
+	// Name 是目的地的識別名稱，用於指令與使用者設定，例如 "drive"
+	Name() string
+	// DisplayName 是顯示給使用者的名稱，例如 "Google Drive"
+	DisplayName() string
+	// Connected 回報使用者是否已經連結此目的地
+	Connected(ctx context.Context, userID int64) (bool, error)
+	// Connect 回傳讓使用者授權的連結
+	Connect(ctx context.Context, userID int64) (string, error)
+	// Upload 將檔案上傳到使用者的儲存空間；尚未連結時回傳 errNotConnected
+	Upload(ctx context.Context, userID int64, file *UploadFile) (*UploadResult, error)
+	// Link 回傳已上傳檔案的網址
+	Link(ctx context.Context, userID int64, fileID string) (string, error)
+}
+
+// oauthDestination 是使用 OAuth 授權碼流程的目的地，授權完成後由 /oauth/callback 呼叫 Exchange
+type oauthDestination interface {
+	Destination
+	// Exchange 以授權碼與 PKCE 的 code_verifier 交換權杖並儲存，verifier 可能是空字串
+	Exchange(ctx context.Context, userID int64, code, verifier string) error
+}
+
+// interactiveDestination 是需要在聊天中逐步收集連結資訊的目的地，/connect_<name> 會呼叫 StartConnect
+type interactiveDestination interface {
+	Destination
+	StartConnect(ctx context.Context, message *tgbotapi.Message)
+}
+
+// sharingDestination 是可以產生公開分享連結的目的地，/share 會呼叫 Share
+type sharingDestination interface {
+	Destination
+	Share(ctx context.Context, userID int64, fileID string) (string, error)
+}
+
+// trashDestination 是有垃圾桶的目的地，/trash 與 /restore 會呼叫 SetTrashed
+type trashDestination interface {
+	Destination
+	SetTrashed(ctx context.Context, userID int64, fileID string, trashed bool) error
+}
+
+// renameDestination 是可以重新命名已上傳檔案的目的地，/rename 會呼叫 Rename
+type renameDestination interface {
+	Destination
+	Rename(ctx context.Context, userID int64, fileID, name string) error
+}
+
+// captionDestination 是可以在檔案描述中保存說明文字的目的地，編輯訊息的說明文字時會呼叫 SetCaption
+type captionDestination interface {
+	Destination
+	SetCaption(ctx context.Context, userID int64, fileID, oldCaption, caption string) error
+}
+
+// starDestination 是可以將檔案標記為重要的目的地，/star 與 ⭐ 回應會呼叫 SetStarred
+type starDestination interface {
+	Destination
+	SetStarred(ctx context.Context, userID int64, fileID string, starred bool) error
+}
+
+// mediaDestination 是只接受照片與影片的目的地，例如 Google Photos；
+// 不能作為主要的上傳目的地，使用者以 /media_destination 指定後，照片與影片改上傳到這裡
+type mediaDestination interface {
+	Destination
+	AcceptsMimeType(mimeType string) bool
+}
+
+// RemoteFile 是目的地上的一個檔案
+type RemoteFile struct {
+	ID       string
+	Name     string
+	MimeType string
+	Size     int64  // Google 文件等原生格式沒有大小，為 0
+	Link     string // 在瀏覽器中開啟檔案的網址，目的地沒有提供時為空字串
+}
+
+// fetchDestination 是可以搜尋並下載已上傳檔案的目的地，/get 會用來將檔案傳回 Telegram
+type fetchDestination interface {
+	Destination
+	// Search 依檔名搜尋機器人上傳過的檔案，由新到舊最多回傳 limit 筆
+	Search(ctx context.Context, userID int64, query string, limit int) ([]RemoteFile, error)
+	// FindByProperties 依上傳時寫入的屬性（例如 telegram_file_unique_id）尋找檔案，最多回傳 limit 筆
+	FindByProperties(ctx context.Context, userID int64, props map[string]string, limit int) ([]RemoteFile, error)
+	// Download 回傳檔案資訊與內容，呼叫端負責關閉
+	Download(ctx context.Context, userID int64, fileID string) (*RemoteFile, io.ReadCloser, error)
+}
+
+// uploadErrorDestination 是能將上傳錯誤轉成具體說明的目的地，例如空間不足或授權失效
+type uploadErrorDestination interface {
+	Destination
+	// UploadErrorMessage 回傳給使用者的說明，沒有更具體的說明時回傳空字串
+	UploadErrorMessage(ctx context.Context, userID int64, err error) string
+}
+
+// uploadFailedMessage 回傳上傳失敗時要回覆的訊息，目的地無法說明原因時使用 fallback
+func uploadFailedMessage(ctx context.Context, dest Destination, userID int64, err error, fallback string) string {
+	if errors.Is(err, errServiceDegraded) {
+		return degradedMessage
+	}
+	if d, ok := dest.(uploadErrorDestination); ok {
+		if msg := d.UploadErrorMessage(ctx, userID, err); msg != "" {
+			return msg
+		}
+	}
+	return fallback
+}
+
+// errNotConnected 表示使用者尚未連結目的地
+var errNotConnected = errors.New("destination not connected")
+
+// errConnectNotRequired 表示目的地由管理者設定，使用者不需要另外授權
+var errConnectNotRequired = errors.New("destination does not require connecting")
+
+// errInteractiveConnect 表示目的地透過對話連結，沒有授權連結
+var errInteractiveConnect = errors.New("destination connects through a conversation")
+
+// 預設的上傳目的地
+const defaultDestination = "drive"
+
+// 已啟用的目的地，依名稱索引
+var destinations = map[string]Destination{}
+
+func registerDestination(d Destination) {
+	destinations[d.Name()] = d
+}
+
+// destinationNames 回傳已啟用的目的地名稱，依字母排序
+func destinationNames() []string {
+	names := make([]string, 0, len(destinations))
+	for name := range destinations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// primaryDestinationNames 回傳可以作為主要上傳目的地的名稱，不包含只接受照片與影片的目的地
+func primaryDestinationNames() []string {
+	var names []string
+	for _, name := range destinationNames() {
+		if _, ok := destinations[name].(mediaDestination); !ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// mediaDestinationNames 回傳只接受照片與影片的目的地名稱
+func mediaDestinationNames() []string {
+	var names []string
+	for _, name := range destinationNames() {
+		if _, ok := destinations[name].(mediaDestination); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// checkForcedDestination 檢查 FORCE_DESTINATION 是已啟用的主要目的地，需在所有目的地都註冊後呼叫
+func checkForcedDestination(name string) error {
+	if name == "" {
+		return nil
+	}
+	if _, ok := destinations[name]; !ok {
+		return fmt.Errorf("FORCE_DESTINATION %q is not an enabled destination (enabled: %s)", name, strings.Join(destinationNames(), ", "))
+	}
+	if _, ok := destinations[name].(mediaDestination); ok {
+		return fmt.Errorf("FORCE_DESTINATION %q only accepts photos and videos (use one of: %s)", name, strings.Join(primaryDestinationNames(), ", "))
+	}
+	return nil
+}
+
+// userDestination 回傳使用者偏好的目的地；管理者以 FORCE_DESTINATION 強制指定時優先使用，未設定或已停用時使用預設目的地
+func userDestination(ctx context.Context, settings *UserSettings) Destination {
+	if forced := configFor(ctx).ForceDestination; forced != "" {
+		return destinations[forced]
+	}
+	if d, ok := destinations[settings.Destination]; ok {
+		if _, media := d.(mediaDestination); !media {
+			return d
+		}
+	}
+	return destinations[defaultDestination]
+}
+
+// uploadDestination 回傳檔案要上傳到的目的地：使用者以 /media_destination 指定時，照片與影片上傳到該目的地，
+// 其他檔案與該目的地不支援的格式仍上傳到主要的目的地
+func uploadDestination(ctx context.Context, settings *UserSettings, mimeType string) Destination {
+	if configFor(ctx).ForceDestination == "" {
+		if d, ok := destinations[settings.MediaDestination].(mediaDestination); ok && d.AcceptsMimeType(mimeType) {
+			return d
+		}
+	}
+	return userDestination(ctx, settings)
+}
+
+// connectCommand 回傳連結目的地的指令
+func connectCommand(d Destination) string {
+	return "/connect_" + d.Name()
+}
+
+// state 的有效期限，超過後就算還沒被使用也不能兌換
+const oauthStateTTL = 30 * time.Minute
+
+// newOAuthState 產生一個隨機的 state 字串來防止 CSRF 攻擊，並記錄是哪位使用者要連結哪個目的地
+// 同時產生 PKCE 的 code_verifier 與 state 存在一起，回傳的選項要傳給 AuthCodeURL
+func newOAuthState(ctx context.Context, userID int64, provider string) (string, oauth2.AuthCodeOption, error) {
+	b := make([]byte, 32)
+	rand.Read(b)
+	state := tenantState(ctx, base64.URLEncoding.EncodeToString(b))
+	verifier := oauth2.GenerateVerifier()
+	now := time.Now()
+
+	if redisClient != nil {
+		data := &oauthState{UserID: userID, Provider: provider, CodeVerifier: verifier, ExpireAt: now.Add(oauthStateTTL)}
+		if err := redisSaveOAuthState(ctx, state, data); err != nil {
+			return "", nil, fmt.Errorf("failed to save state to redis: %v", err)
+		}
+		return state, oauth2.S256ChallengeOption(verifier), nil
+	}
+	err := collection(ctx, stateCollection).Doc(state).Set(ctx, map[string]interface{}{
+		"user_id":       userID,
+		"provider":      provider,
+		"code_verifier": verifier,
+		"created_at":    now,
+		"expire_at":     now.Add(oauthStateTTL),
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to save state to firestore: %v", err)
+	}
+	return state, oauth2.S256ChallengeOption(verifier), nil
+}
+
+// verifierOptions 回傳交換授權碼時要帶上的 code_verifier；舊版的 state 沒有 verifier，授權時也沒有送出 challenge
+func verifierOptions(verifier string) []oauth2.AuthCodeOption {
+	if verifier == "" {
+		return nil
+	}
+	return []oauth2.AuthCodeOption{oauth2.VerifierOption(verifier)}
+}
+
+// oauthState 是 oauth_states 中的一筆紀錄
+type oauthState struct {
+	UserID       int64     `firestore:"user_id"`
+	Provider     string    `firestore:"provider"`
+	CodeVerifier string    `firestore:"code_verifier"` // PKCE 的 code_verifier，交換授權碼時送出
+	ExpireAt     time.Time `firestore:"expire_at"`     // 較舊的 state 沒有這個欄位
+}
+
+// errStateConsumed 表示 state 不存在或已過期，可能是偽造的，或已經被使用過
+var errStateConsumed = errors.New("oauth state not found or already used")
+
+// consumeOAuthState 在交易中讀取並刪除 state，讓同一個 state 只能兌換一次
+// 兩個請求同時兌換時，較晚提交的交易會重試並讀不到文件，因此回傳 errStateConsumed
+func consumeOAuthState(ctx context.Context, state string) (*oauthState, error) {
+	if redisClient != nil {
+		return redisConsumeOAuthState(ctx, state)
+	}
+	ref := collection(ctx, stateCollection).Doc(state)
+	var data oauthState
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		doc, err := tx.Get(ref)
+		if errors.Is(err, errDocNotFound) {
+			return errStateConsumed
+		}
+		if err != nil {
+			return err
+		}
+		if err := doc.DataTo(&data); err != nil {
+			return err
+		}
+		// TTL 政策不會立即刪除過期的文件，因此這裡也要檢查；過期的文件留給 TTL 或 /cron/cleanup 刪除
+		if !data.ExpireAt.IsZero() && time.Now().After(data.ExpireAt) {
+			return errStateConsumed
+		}
+		return tx.Delete(ref)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// loadUserToken 從指定的集合讀取使用者的 OAuth 權杖；沒有紀錄時回傳 errNotConnected
+func loadUserToken(ctx context.Context, collection string, userID int64) (*oauth2.Token, error) {
+	userToken, err := loadTokenDoc(ctx, collection, fmt.Sprintf("%d", userID))
+	if err != nil {
+		return nil, err
+	}
+	return userToken.Token(), nil
+}
+
+// loadTokenDoc 讀取指定文件中的權杖紀錄，優先使用快取；沒有紀錄時回傳 errNotConnected
+func loadTokenDoc(ctx context.Context, name, docID string) (*UserToken, error) {
+	// 快取以加上命名空間的集合名稱索引，不同機器人的使用者不會互相影響
+	name = collectionName(ctx, name)
+	if token, ok := userTokenCache.get(name, docID); ok {
+		return token, nil
+	}
+
+	spanCtx, span := startSpan(ctx, "storage.get_token")
+	doc, err := rawCollection(name).Doc(docID).Get(spanCtx)
+	endSpan(span, err)
+	if err != nil {
+		if errors.Is(err, errDocNotFound) {
+			return nil, errNotConnected
+		}
+		return nil, err
+	}
+
+	var userToken UserToken
+	if err := doc.DataTo(&userToken); err != nil {
+		return nil, err
+	}
+	userTokenCache.put(name, docID, &userToken)
+	return &userToken, nil
+}
+
+// saveUserToken 以使用者 ID 作為文件 ID，將權杖存到指定的集合
+func saveUserToken(ctx context.Context, collection string, userID int64, token *oauth2.Token) error {
+	return saveTokenDoc(ctx, collection, fmt.Sprintf("%d", userID), newUserToken(userID, "", token))
+}
+
+// saveTokenDoc 寫入權杖紀錄並更新快取；寫入失敗時無法確定 Firestore 的內容，因此移除快取
+func saveTokenDoc(ctx context.Context, name, docID string, userToken *UserToken) error {
+	name = collectionName(ctx, name)
+	err := rawCollection(name).Doc(docID).Set(ctx, userToken)
+	if err != nil {
+		userTokenCache.invalidate(name, docID)
+		return err
+	}
+	userTokenCache.put(name, docID, userToken)
+	return nil
+}
+
+// newUserToken 建立要存到 Firestore 的權杖紀錄，email 可以是空字串
+func newUserToken(userID int64, email string, token *oauth2.Token) *UserToken {
+	return &UserToken{
+		UserID:       userID,
+		Email:        email,
+		RefreshToken: token.RefreshToken,
+		AccessToken:  token.AccessToken,
+		TokenType:    token.TokenType,
+		Expiry:       token.Expiry,
+		CreatedAt:    time.Now(),
+	}
+}
+
+// 以使用者 ID 作為文件 ID 儲存權杖或連線資訊的集合
+var userCredentialCollections = []string{tokenCollection, dropboxTokenCollection, oneDriveTokenCollection, photosTokenCollection, webDAVCredentialCollection}
+
+// disconnectUser 刪除使用者在所有目的地的權杖、所有 Google 帳號與快取，之後需要重新連結才能上傳
+func disconnectUser(ctx context.Context, userID int64) error {
+	var errs []error
+	docID := fmt.Sprintf("%d", userID)
+	for _, name := range userCredentialCollections {
+		name = collectionName(ctx, name)
+		userTokenCache.invalidate(name, docID)
+		if err := rawCollection(name).Doc(docID).Delete(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete %s: %v", name, err))
+		}
+	}
+	docs, err := collection(ctx, googleAccountCollection).Where("user_id", "==", userID).GetAll(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to list google accounts: %v", err))
+	}
+	for _, doc := range docs {
+		userTokenCache.invalidate(collectionName(ctx, googleAccountCollection), doc.Ref.ID)
+		if err := doc.Ref.Delete(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete google account: %v", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// hasUserToken 回報使用者是否在指定的集合中有權杖
+func hasUserToken(ctx context.Context, collection string, userID int64) (bool, error) {
+	_, err := loadTokenDoc(ctx, collection, fmt.Sprintf("%d", userID))
+	if errors.Is(err, errNotConnected) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 每次上傳時選擇目的地 ---
+
+const (
+	// 使用者在這段時間內沒有選擇時，上傳到預設的目的地
+	destinationPickTimeout = 2 * time.Minute
+	// 選擇目的地時額外列出的資料夾數量上限，避免按鈕過多
+	maxPickFolders = 10
+)
+
+var (
+	errPendingUploadGone  = errors.New("pending upload already handled or expired")
+	errPendingUploadOwner = errors.New("pending upload belongs to another user")
+)
+
+// UploadTarget 是選擇目的地時的一個選項：目的地加上選填的資料夾範本
+type UploadTarget struct {
+	Label       string `firestore:"label"`
+	Destination string `firestore:"destination"`
+	Folder      string `firestore:"folder"` // 資料夾範本，空字串代表使用原本的資料夾範本
+}
+
+type uploadTargetKey struct{}
+
+// withUploadTarget 指定這次上傳的目的地與資料夾，handleFile 不會再次詢問
+func withUploadTarget(ctx context.Context, target *UploadTarget) context.Context {
+	return context.WithValue(ctx, uploadTargetKey{}, target)
+}
+
+func uploadTargetFromContext(ctx context.Context) *UploadTarget {
+	target, _ := ctx.Value(uploadTargetKey{}).(*UploadTarget)
+	return target
+}
+
+// uploadTargets 列出可以選擇的目的地：每個已連結的目的地，加上目前目的地中使用者設定的資料夾
+func uploadTargets(ctx context.Context, userID int64, settings *UserSettings) ([]UploadTarget, error) {
+	current := userDestination(ctx, settings)
+	names := []string{current.Name()}
+	if configFor(ctx).ForceDestination == "" {
+		names = primaryDestinationNames()
+	}
+	var targets []UploadTarget
+	for _, name := range names {
+		dest := destinations[name]
+		connected, err := dest.Connected(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if connected {
+			targets = append(targets, UploadTarget{Label: dest.DisplayName(), Destination: name})
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(settings.PickFolders)) {
+		targets = append(targets, UploadTarget{
+			Label:       current.DisplayName() + " / " + name,
+			Destination: current.Name(),
+			Folder:      settings.PickFolders[name],
+		})
+	}
+	return targets, nil
+}
+
+// promptDestination 在使用者開啟每次選擇目的地、且有兩個以上的選項時，回覆檔案資訊與每個目的地的按鈕，
+// 等使用者按下後再上傳；逾時未選擇時上傳到上次選擇的目的地。回傳 false 時應直接上傳
+// 照片與影片另外指定了目的地（例如 Google Photos）時不會詢問
+func promptDestination(ctx context.Context, message *tgbotapi.Message, settings *UserSettings, dest Destination, file *telegramFile) bool {
+	if !settings.AskDestination || uploadConfirmed(ctx) || uploadTargetFromContext(ctx) != nil {
+		return false
+	}
+	if _, media := dest.(mediaDestination); media {
+		return false
+	}
+	targets, err := uploadTargets(ctx, message.From.ID, settings)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list upload targets", "error", err)
+		return false
+	}
+	if len(targets) < 2 {
+		return false
+	}
+	defaultTarget := max(slices.IndexFunc(targets, func(t UploadTarget) bool { return t.Label == settings.PickDefault }), 0)
+
+	raw, err := json.Marshal(message)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode message", "error", err)
+		return false
+	}
+	var threadID int
+	if topic := forumTopicFromContext(ctx); topic != nil {
+		threadID = topic.ThreadID
+	}
+	b := make([]byte, 9)
+	rand.Read(b)
+	id := base64.RawURLEncoding.EncodeToString(b)
+	err = collection(ctx, pendingUploadCollection).Doc(id).Set(ctx, &PendingUpload{
+		UserID:        message.From.ID,
+		Message:       string(raw),
+		Targets:       targets,
+		DefaultTarget: defaultTarget,
+		ThreadID:      threadID,
+		ExpireAt:      time.Now().Add(pendingUploadTTL),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to save pending upload", "error", err)
+		return false
+	}
+
+	size := "大小未知"
+	if file.Size > 0 {
+		size = formatSize(file.Size)
+	}
+	text := fmt.Sprintf("檔案：%s（%s）\n要上傳到哪裡？%d 分鐘內沒有選擇時會上傳到「%s」。",
+		file.Name, size, int(destinationPickTimeout.Minutes()), targets[defaultTarget].Label)
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, t := range targets {
+		label := t.Label
+		if i == defaultTarget {
+			label += "（預設）"
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(label, encodeCallbackData("pick", id, strconv.Itoa(i))),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("取消", encodeCallbackData("pick", id, "cancel"))))
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyToMessageID = message.MessageID
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	prompt, err := sendMessage(ctx, msg)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to send message", "error", err)
+		return false
+	}
+
+	// 逾時後由同一個執行個體上傳到預設的目的地；執行個體在這之前結束時，按鈕在 pendingUploadTTL 內仍然有效
+	fallbackCtx := context.WithoutCancel(ctx)
+	time.AfterFunc(destinationPickTimeout, func() {
+		uploadToDefaultTarget(fallbackCtx, id, prompt.Chat.ID, prompt.MessageID)
+	})
+	return true
+}
+
+// claimPendingUpload 在交易中讀取並刪除等待中的上傳，確保按鈕與逾時只有一方會上傳
+// userID 不為 0 時，只有傳送檔案的人可以取得
+func claimPendingUpload(ctx context.Context, id string, userID int64) (*PendingUpload, error) {
+	ref := collection(ctx, pendingUploadCollection).Doc(id)
+	var pending PendingUpload
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		doc, err := tx.Get(ref)
+		if errors.Is(err, errDocNotFound) {
+			return errPendingUploadGone
+		}
+		if err != nil {
+			return err
+		}
+		if err := doc.DataTo(&pending); err != nil {
+			return err
+		}
+		if userID != 0 && pending.UserID != userID {
+			return errPendingUploadOwner
+		}
+		if time.Now().After(pending.ExpireAt) {
+			return errPendingUploadGone
+		}
+		return tx.Delete(ref)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &pending, nil
+}
+
+// handlePickCallback 處理選擇目的地的按鈕：數字代表選擇的目的地，cancel 取消
+func handlePickCallback(ctx context.Context, query *tgbotapi.CallbackQuery, args []string) {
+	if len(args) != 2 {
+		answerCallback(ctx, query, "")
+		return
+	}
+	i, err := strconv.Atoi(args[1])
+	if args[1] != "cancel" && (err != nil || i < 0) {
+		answerCallback(ctx, query, "")
+		return
+	}
+	pending, err := claimPendingUpload(ctx, args[0], query.From.ID)
+	switch {
+	case errors.Is(err, errPendingUploadOwner):
+		answerCallback(ctx, query, "只有傳送檔案的人可以操作。")
+		return
+	case errors.Is(err, errPendingUploadGone):
+		answerCallback(ctx, query, "這個檔案已經處理過或已過期，請重新傳送。")
+		return
+	case err != nil:
+		slog.ErrorContext(ctx, "Failed to claim pending upload", "error", err)
+		answerCallback(ctx, query, "發生錯誤，請重新傳送檔案。")
+		return
+	}
+
+	if args[1] == "cancel" {
+		answerCallback(ctx, query, "已取消")
+		editPrompt(ctx, query, "已取消上傳。")
+		return
+	}
+	if i >= len(pending.Targets) {
+		answerCallback(ctx, query, "")
+		return
+	}
+	target := pending.Targets[i]
+	// 記住這次的選擇，下次逾時未選擇時上傳到同一個地方
+	if err := updateUserSettings(ctx, query.From.ID, map[string]interface{}{"pick_default": target.Label}); err != nil {
+		slog.WarnContext(ctx, "Failed to save default upload target", "error", err)
+	}
+	answerCallback(ctx, query, "上傳到 "+target.Label)
+	editPrompt(ctx, query, "上傳到 "+target.Label+"…")
+	uploadPendingTarget(ctx, pending, &target)
+}
+
+// uploadToDefaultTarget 在使用者逾時未選擇時，上傳到預設的目的地
+func uploadToDefaultTarget(ctx context.Context, id string, chatID int64, promptID int) {
+	defer func() {
+		if r := recover(); r != nil {
+			reportPanic(ctx, r)
+		}
+	}()
+	pending, err := claimPendingUpload(ctx, id, 0)
+	if errors.Is(err, errPendingUploadGone) {
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to claim pending upload", "error", err)
+		return
+	}
+	if pending.DefaultTarget < 0 || pending.DefaultTarget >= len(pending.Targets) {
+		return
+	}
+	target := pending.Targets[pending.DefaultTarget]
+	slog.InfoContext(ctx, "Upload target not chosen in time, using default", "target", target.Label)
+	edit := tgbotapi.NewEditMessageText(chatID, promptID, "沒有選擇目的地，上傳到預設的 "+target.Label+"…")
+	if _, err := sendChattable(ctx, chatID, edit); err != nil {
+		slog.WarnContext(ctx, "Failed to update upload prompt", "error", err)
+	}
+	uploadPendingTarget(ctx, pending, &target)
+}
+
+// uploadPendingTarget 以原本的訊息重新執行上傳，選擇目的地視同確認，不再詢問
+func uploadPendingTarget(ctx context.Context, pending *PendingUpload, target *UploadTarget) {
+	var message tgbotapi.Message
+	if err := json.Unmarshal([]byte(pending.Message), &message); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode pending message", "error", err)
+		return
+	}
+	ctx = withUploadTarget(withUploadConfirmed(ctx), target)
+	if pending.ThreadID != 0 {
+		ctx = withForumTopic(ctx, &forumTopic{ThreadID: pending.ThreadID})
+	}
+	handleFile(ctx, &message)
+}
+
+// 處理 /pick_destination 指令：切換每次上傳時是否以按鈕選擇目的地，並管理額外列出的資料夾
+func handlePickDestination(ctx context.Context, message *tgbotapi.Message) {
+	userID := message.From.ID
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 1 {
+		on, ok := parseOnOff(args[0])
+		if ok {
+			if err := updateUserSettings(ctx, userID, map[string]interface{}{"ask_destination": on}); err != nil {
+				slog.ErrorContext(ctx, "Failed to update settings", "error", err)
+				replyToUser(ctx, message.Chat.ID, message.MessageID, "更新設定時發生錯誤，請稍後再試。")
+				return
+			}
+			if on {
+				replyToUser(ctx, message.Chat.ID, message.MessageID, "已開啟每次選擇目的地，連結了多個目的地或設定了資料夾時，傳送檔案後會先詢問要上傳到哪裡。")
+			} else {
+				replyToUser(ctx, message.Chat.ID, message.MessageID, "已關閉每次選擇目的地。")
+			}
+			return
+		}
+	}
+
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的設定時發生錯誤，請稍後再試。")
+		return
+	}
+
+	if len(args) >= 3 && args[0] == "folder" {
+		name := args[1]
+		folder := strings.TrimSpace(strings.SplitN(strings.TrimSpace(message.CommandArguments()), " ", 3)[2])
+		if folder == "off" {
+			if err := updateUserSettings(ctx, userID, map[string]interface{}{"pick_folders": map[string]interface{}{name: deleteField}}); err != nil {
+				slog.ErrorContext(ctx, "Failed to save pick folder", "error", err)
+				replyToUser(ctx, message.Chat.ID, message.MessageID, "儲存設定時發生錯誤，請稍後再試。")
+				return
+			}
+			replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("已移除資料夾「%s」。", name))
+			return
+		}
+		if _, exists := settings.PickFolders[name]; !exists && len(settings.PickFolders) >= maxPickFolders {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("最多只能設定 %d 個資料夾，請先移除不需要的資料夾。", maxPickFolders))
+			return
+		}
+		if err := validateTemplate(folder, folderTemplate); err != nil {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("資料夾範本無效：%v", err))
+			return
+		}
+		if err := updateUserSettings(ctx, userID, map[string]interface{}{"pick_folders": map[string]interface{}{name: folder}}); err != nil {
+			slog.ErrorContext(ctx, "Failed to save pick folder", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "儲存設定時發生錯誤，請稍後再試。")
+			return
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("選擇目的地時會多列出「%s / %s」，上傳到「%s」。", userDestination(ctx, settings).DisplayName(), name, folder))
+		return
+	}
+
+	var sb strings.Builder
+	state := "關閉"
+	if settings.AskDestination {
+		state = "開啟"
+	}
+	fmt.Fprintf(&sb, "每次選擇目的地目前為%s。\n", state)
+	if len(settings.PickFolders) > 0 {
+		fmt.Fprintf(&sb, "\n額外列出的 %s 資料夾：\n", userDestination(ctx, settings).DisplayName())
+		for _, name := range slices.Sorted(maps.Keys(settings.PickFolders)) {
+			fmt.Fprintf(&sb, "• %s → %s\n", name, settings.PickFolders[name])
+		}
+	}
+	if settings.PickDefault != "" {
+		fmt.Fprintf(&sb, "\n逾時未選擇時上傳到：%s\n", settings.PickDefault)
+	}
+	sb.WriteString("\n用法：\n/pick_destination on|off\n/pick_destination folder <名稱> <資料夾範本>\n/pick_destination folder <名稱> off")
+	replyToUser(ctx, message.Chat.ID, message.MessageID, sb.String())
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 上傳摘要 ---
+
+// 摘要的週期，也是 /cron/digest 的 period 參數
+const (
+	digestDaily  = "daily"
+	digestWeekly = "weekly"
+)
+
+var digestPeriods = map[string]time.Duration{
+	digestDaily:  24 * time.Hour,
+	digestWeekly: 7 * 24 * time.Hour,
+}
+
+var digestLabels = map[string]string{
+	"":           "關",
+	digestDaily:  "每日",
+	digestWeekly: "每週",
+}
+
+// 摘要中最多列出的檔案數，其餘只顯示數量，避免超過 Telegram 單則訊息的長度限制
+const maxDigestEntries = 30
+
+// authorizeCron 確認請求帶有正確的 CRON_SECRET，失敗時回應錯誤並回傳 false
+// Cloud Scheduler 呼叫 /cron/ 路由時需要以 Bearer 權杖帶上；未設定時不啟用排程功能
+func authorizeCron(w http.ResponseWriter, r *http.Request) bool {
+	secret := configFor(r.Context()).CronSecret
+	if secret == "" {
+		http.NotFound(w, r)
+		return false
+	}
+	if !hasBearerToken(r, secret) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// 處理 /cron/digest?period=daily|weekly：寄送摘要給選擇了該週期的使用者
+// 在請求中同步完成，讓 Cloud Run 在寄送期間持續配置 CPU，也讓 Cloud Scheduler 能依狀態碼重試
+func cronDigestHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeCron(w, r) {
+		return
+	}
+	ctx := r.Context()
+	period := r.URL.Query().Get("period")
+	if _, ok := digestPeriods[period]; !ok {
+		http.Error(w, "period must be daily or weekly", http.StatusBadRequest)
+		return
+	}
+
+	sent, failed := 0, 0
+	for _, t := range tenants {
+		s, f, err := sendDigests(withTenant(ctx, t), period)
+		sent, failed = sent+s, failed+f
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to iterate digest users", "bot_id", t.ID, "error", err)
+			http.Error(w, "failed to list users", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	slog.InfoContext(ctx, "Digest finished", "period", period, "sent", sent, "failed", failed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"sent": sent, "failed": failed})
+}
+
+// sendDigests 寄送摘要給 ctx 中的機器人所有選擇了該週期的使用者
+func sendDigests(ctx context.Context, period string) (sent, failed int, err error) {
+	docs, err := collection(ctx, settingsCollection).Where("digest", "==", period).GetAll(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, doc := range docs {
+		userID, err := strconv.ParseInt(doc.Ref.ID, 10, 64)
+		if err != nil || !isUserAllowed(ctx, userID) {
+			continue
+		}
+		ok, err := sendDigest(ctx, userID, period)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to send digest", "target_user_id", userID, "error", err)
+			failed++
+			continue
+		}
+		if ok {
+			sent++
+		}
+	}
+	return sent, failed, nil
+}
+
+// sendDigest 整理使用者在這段期間的上傳紀錄並以私訊寄送；期間內沒有上傳時不寄送並回傳 false
+func sendDigest(ctx context.Context, userID int64, period string) (bool, error) {
+	since := time.Now().Add(-digestPeriods[period])
+	docs, err := collection(ctx, uploadCollection).
+		Where("user_id", "==", userID).
+		Where("created_at", ">=", since).
+		OrderBy("created_at", Asc).
+		GetAll(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to query uploads: %v", err)
+	}
+	if len(docs) == 0 {
+		return false, nil
+	}
+
+	var total int64
+	var lines []string
+	for _, doc := range docs {
+		var record UploadRecord
+		if err := doc.DataTo(&record); err != nil {
+			continue
+		}
+		total += record.Size
+		if len(lines) < maxDigestEntries {
+			line := fmt.Sprintf("• %s（%s）", record.Name, formatSize(record.Size))
+			if record.Link != "" {
+				line += "\n  " + record.Link
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	periodLabel := "過去一天"
+	if period == digestWeekly {
+		periodLabel = "過去一週"
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s您上傳了 %d 個檔案，共 %s：\n\n", periodLabel, len(docs), formatSize(total))
+	sb.WriteString(strings.Join(lines, "\n"))
+	if more := len(docs) - len(lines); more > 0 {
+		fmt.Fprintf(&sb, "\n…還有 %d 個檔案", more)
+	}
+	sb.WriteString("\n\n輸入 /digest off 可停止接收摘要。")
+
+	// 私人對話的 chat ID 與使用者 ID 相同
+	msg := tgbotapi.NewMessage(userID, sb.String())
+	msg.DisableWebPagePreview = true
+	if _, err := sendChattable(ctx, userID, msg); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// 處理 /digest 指令：設定是否定期收到上傳摘要
+func handleDigest(ctx context.Context, message *tgbotapi.Message) {
+	if configFor(ctx).CronSecret == "" {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "此機器人尚未啟用上傳摘要。")
+		return
+	}
+	userID := message.From.ID
+	arg := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+	if arg == "" {
+		settings, err := loadUserSettings(ctx, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的設定時發生錯誤，請稍後再試。")
+			return
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("上傳摘要目前為：%s。\n用法：/digest daily、/digest weekly 或 /digest off", digestLabels[settings.Digest]))
+		return
+	}
+
+	period := arg
+	if on, ok := parseOnOff(arg); ok && !on {
+		period = ""
+	} else if _, ok := digestPeriods[arg]; !ok {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "用法：/digest daily、/digest weekly 或 /digest off")
+		return
+	}
+	if err := updateUserSettings(ctx, userID, map[string]interface{}{"digest": period}); err != nil {
+		slog.ErrorContext(ctx, "Failed to update settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "更新設定時發生錯誤，請稍後再試。")
+		return
+	}
+	if period == "" {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "已停止寄送上傳摘要。")
+		return
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("之後會%s以私訊寄送您上傳的檔案摘要。", digestLabels[period]))
+}
+
+// nextDigest 回傳在 /settings 中切換的下一個摘要週期
+func nextDigest(current string) string {
+	switch current {
+	case "":
+		return digestDaily
+	case digestDaily:
+		return digestWeekly
+	}
+	return ""
+}
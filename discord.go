@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/net/websocket"
+)
+
+// --- Discord 機器人 ---
+
+const (
+	discordAPIURL     = "https://discord.com/api/v10"
+	discordGatewayURL = "wss://gateway.discord.gg/?v=10&encoding=json"
+	// Discord 訊息的長度上限
+	discordMaxTextLength = 2000
+	// 需要的 Gateway intents：GUILDS、GUILD_MESSAGES、DIRECT_MESSAGES 與讀取訊息附件所需的 MESSAGE_CONTENT
+	discordIntents = 1<<0 | 1<<9 | 1<<12 | 1<<15
+	// Gateway 斷線後重新連線的等待時間上限
+	discordMaxBackoff = 5 * time.Minute
+)
+
+// Gateway 的 opcode
+const (
+	discordOpDispatch       = 0
+	discordOpHeartbeat      = 1
+	discordOpIdentify       = 2
+	discordOpReconnect      = 7
+	discordOpInvalidSession = 9
+	discordOpHello          = 10
+)
+
+// discord 是設定了 Discord 機器人時的轉接器；未設定時為 nil
+var discord *discordPlatform
+
+var discordClient = &http.Client{
+	Timeout:   30 * time.Second,
+	Transport: otelhttp.NewTransport(http.DefaultTransport),
+}
+
+// discordContentClient 下載附件，大檔案需要較長的時間，由 context 控制逾時
+var discordContentClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+type discordPlatform struct {
+	token    string
+	channels map[string]bool // 會封存附件的頻道；私訊不受限制
+}
+
+func initDiscord(cfg DiscordConfig) {
+	if cfg.BotToken == "" {
+		return
+	}
+	channels := make(map[string]bool, len(cfg.ChannelIDs))
+	for _, id := range cfg.ChannelIDs {
+		channels[id] = true
+	}
+	discord = &discordPlatform{token: cfg.BotToken, channels: channels}
+}
+
+func (*discordPlatform) Name() string        { return "discord" }
+func (*discordPlatform) DisplayName() string { return "Discord" }
+
+// discordPayload 是 Gateway 傳送與接收的訊息
+type discordPayload struct {
+	Op   int             `json:"op"`
+	Data json.RawMessage `json:"d,omitempty"`
+	Seq  *int64          `json:"s,omitempty"`
+	Type string          `json:"t,omitempty"`
+}
+
+// discordMessage 是 MESSAGE_CREATE 事件的內容，只解析需要的欄位
+type discordMessage struct {
+	ID        string    `json:"id"`
+	ChannelID string    `json:"channel_id"`
+	GuildID   string    `json:"guild_id"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+	Author    struct {
+		ID         string `json:"id"`
+		Username   string `json:"username"`
+		GlobalName string `json:"global_name"`
+		Bot        bool   `json:"bot"`
+	} `json:"author"`
+	Attachments []struct {
+		Filename    string `json:"filename"`
+		Size        int64  `json:"size"`
+		URL         string `json:"url"`
+		ContentType string `json:"content_type"`
+	} `json:"attachments"`
+}
+
+// runDiscord 維持與 Discord Gateway 的連線，斷線時以指數退避重新連線，直到 ctx 結束
+// Gateway 是長期連線，部署在 Cloud Run 時需要設定最少一個執行個體並一律配置 CPU
+func runDiscord(ctx context.Context) {
+	ctx = withLogAttrs(ctx, slog.String("platform", "discord"))
+	backoff := time.Second
+	for ctx.Err() == nil {
+		start := time.Now()
+		err := discord.connect(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		// 連線維持了一段時間才中斷時，視為正常的重新連線
+		if time.Since(start) > discordMaxBackoff {
+			backoff = time.Second
+		}
+		slog.WarnContext(ctx, "Discord gateway disconnected", "error", err, "retry_in", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, discordMaxBackoff)
+	}
+}
+
+// connect 連線到 Gateway 並處理事件，連線中斷時回傳
+func (d *discordPlatform) connect(ctx context.Context) error {
+	config, err := websocket.NewConfig(discordGatewayURL, "https://discord.com")
+	if err != nil {
+		return err
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	conn, err := config.DialContext(dialCtx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to dial gateway: %w", err)
+	}
+	defer conn.Close()
+	// ctx 結束時關閉連線，讓讀取中斷
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	var hello discordPayload
+	if err := websocket.JSON.Receive(conn, &hello); err != nil {
+		return fmt.Errorf("failed to read hello: %w", err)
+	}
+	var helloData struct {
+		HeartbeatInterval int64 `json:"heartbeat_interval"`
+	}
+	if hello.Op != discordOpHello || json.Unmarshal(hello.Data, &helloData) != nil || helloData.HeartbeatInterval <= 0 {
+		return fmt.Errorf("unexpected first gateway payload: op %d", hello.Op)
+	}
+
+	identify, _ := json.Marshal(map[string]interface{}{
+		"token":      d.token,
+		"intents":    discordIntents,
+		"properties": map[string]string{"os": "linux", "browser": "tg-helper", "device": "tg-helper"},
+	})
+	if err := websocket.JSON.Send(conn, discordPayload{Op: discordOpIdentify, Data: identify}); err != nil {
+		return fmt.Errorf("failed to identify: %w", err)
+	}
+
+	// 心跳在另一個 goroutine 送出，序號由讀取迴圈更新
+	var seq atomic.Int64
+	heartbeatDone := make(chan struct{})
+	defer close(heartbeatDone)
+	go func() {
+		ticker := time.NewTicker(time.Duration(helloData.HeartbeatInterval) * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-heartbeatDone:
+				return
+			case <-ticker.C:
+				if err := sendDiscordHeartbeat(conn, seq.Load()); err != nil {
+					slog.WarnContext(ctx, "Failed to send discord heartbeat", "error", err)
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		var payload discordPayload
+		if err := websocket.JSON.Receive(conn, &payload); err != nil {
+			return err
+		}
+		if payload.Seq != nil {
+			seq.Store(*payload.Seq)
+		}
+		switch payload.Op {
+		case discordOpDispatch:
+			d.dispatch(ctx, payload.Type, payload.Data)
+		case discordOpHeartbeat:
+			if err := sendDiscordHeartbeat(conn, seq.Load()); err != nil {
+				return err
+			}
+		case discordOpReconnect:
+			return fmt.Errorf("gateway requested reconnect")
+		case discordOpInvalidSession:
+			return fmt.Errorf("gateway invalidated the session")
+		}
+	}
+}
+
+// sendDiscordHeartbeat 送出心跳，附上最後收到的事件序號；還沒收到事件時為 null
+func sendDiscordHeartbeat(conn *websocket.Conn, seq int64) error {
+	data := json.RawMessage("null")
+	if seq > 0 {
+		data, _ = json.Marshal(seq)
+	}
+	return websocket.JSON.Send(conn, discordPayload{Op: discordOpHeartbeat, Data: data})
+}
+
+// dispatch 處理 Gateway 的事件，目前只需要 READY 與 MESSAGE_CREATE
+func (d *discordPlatform) dispatch(ctx context.Context, eventType string, data json.RawMessage) {
+	switch eventType {
+	case "READY":
+		slog.InfoContext(ctx, "Connected to discord gateway")
+	case "MESSAGE_CREATE":
+		var m discordMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			slog.ErrorContext(ctx, "could not decode discord message", "error", err)
+			return
+		}
+		ctx := withLogAttrs(ctx, slog.String("request_id", newRequestID()), slog.String("discord_message_id", m.ID))
+		for _, msg := range d.platformMessages(&m) {
+			handlePlatformMessage(ctx, d, msg)
+		}
+	}
+}
+
+// platformMessages 將私訊與指定頻道中的訊息轉成 PlatformMessage，每個附件各一則；其他訊息回傳 nil
+func (d *discordPlatform) platformMessages(m *discordMessage) []*PlatformMessage {
+	private := m.GuildID == ""
+	if m.Author.Bot || m.Author.ID == "" || (!private && !d.channels[m.ChannelID]) {
+		return nil
+	}
+	base := PlatformMessage{
+		UserID:         platformUserID(d.Name(), m.Author.ID),
+		PlatformUserID: m.Author.ID,
+		SenderName:     cmp.Or(m.Author.GlobalName, m.Author.Username),
+		Private:        private,
+		ChatID:         m.ChannelID,
+		MessageID:      m.ID,
+		Date:           m.Timestamp,
+	}
+	if len(m.Attachments) == 0 {
+		msg := base
+		msg.Text = m.Content
+		return []*PlatformMessage{&msg}
+	}
+	var out []*PlatformMessage
+	for _, a := range m.Attachments {
+		name := strings.NewReplacer("/", "_", "\\", "_").Replace(a.Filename)
+		mimeType, _, _ := strings.Cut(a.ContentType, ";")
+		if mimeType == "" {
+			mimeType = cmp.Or(mimeTypeByExt(strings.ToLower(path.Ext(name))), "application/octet-stream")
+		}
+		msg := base
+		url := a.URL
+		msg.File = &PlatformFile{
+			Name:     name,
+			Type:     discordFileType(mimeType),
+			MimeType: mimeType,
+			Size:     a.Size,
+			Open: func(ctx context.Context) (io.ReadCloser, error) {
+				return d.content(ctx, url)
+			},
+		}
+		out = append(out, &msg)
+	}
+	return out
+}
+
+// discordFileType 依內容類型對應到 Telegram 的 photo、video、voice、document
+func discordFileType(mimeType string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return "photo"
+	case strings.HasPrefix(mimeType, "video/"):
+		return "video"
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "voice"
+	}
+	return "document"
+}
+
+// content 下載附件；附件網址已帶有簽章，不需要機器人的權杖
+func (d *discordPlatform) content(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := discordContentClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("discord attachment download returned %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// Reply 在同一個頻道中回覆原本的訊息，不會提及任何人
+func (d *discordPlatform) Reply(ctx context.Context, msg *PlatformMessage, text string) error {
+	if runes := []rune(text); len(runes) > discordMaxTextLength {
+		text = string(runes[:discordMaxTextLength-1]) + "…"
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"content":           text,
+		"message_reference": map[string]interface{}{"message_id": msg.MessageID, "fail_if_not_exists": false},
+		"allowed_mentions":  map[string]interface{}{"parse": []string{}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discordAPIURL+"/channels/"+msg.ChatID+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bot "+d.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := discordClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("discord API returned %s: %s", resp.Status, msg)
+	}
+	return nil
+}
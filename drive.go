@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+const driveFolderMimeType = "application/vnd.google-apps.folder"
+
+// 可以在上傳時轉換成 Google 文件、試算表與簡報的副檔名
+var driveConversionTypes = map[string]string{
+	".doc":  "application/vnd.google-apps.document",
+	".docx": "application/vnd.google-apps.document",
+	".odt":  "application/vnd.google-apps.document",
+	".rtf":  "application/vnd.google-apps.document",
+	".txt":  "application/vnd.google-apps.document",
+	".md":   "application/vnd.google-apps.document",
+	".html": "application/vnd.google-apps.document",
+	".xls":  "application/vnd.google-apps.spreadsheet",
+	".xlsx": "application/vnd.google-apps.spreadsheet",
+	".ods":  "application/vnd.google-apps.spreadsheet",
+	".csv":  "application/vnd.google-apps.spreadsheet",
+	".ppt":  "application/vnd.google-apps.presentation",
+	".pptx": "application/vnd.google-apps.presentation",
+	".odp":  "application/vnd.google-apps.presentation",
+}
+
+// driveDestination 將檔案上傳到使用者的 Google Drive
+type driveDestination struct{}
+
+func (driveDestination) Name() string        { return "drive" }
+func (driveDestination) DisplayName() string { return "Google Drive" }
+
+func (driveDestination) Connected(ctx context.Context, userID int64) (bool, error) {
+	return hasUserToken(ctx, tokenCollection, userID)
+}
+
+func (driveDestination) Connect(ctx context.Context, userID int64) (string, error) {
+	return googleAuthURL(ctx, userID, baseGoogleScopes(), "")
+}
+
+// Exchange 儲存新連結的 Google 帳號並設為目前使用的帳號；重複連結同一個帳號時會更新它的權杖
+func (driveDestination) Exchange(ctx context.Context, userID int64, code, verifier string) error {
+	token, err := googleOAuth(ctx).Exchange(ctx, code, verifierOptions(verifier)...)
+	if err != nil {
+		return fmt.Errorf("failed to exchange token: %v", err)
+	}
+	email, err := googleAccountEmail(ctx, token)
+	if err != nil {
+		return fmt.Errorf("failed to look up google account: %v", err)
+	}
+	userToken := newUserToken(userID, email, token)
+	userToken.Scopes = grantedScopes(token)
+	return saveGoogleAccount(ctx, userToken)
+}
+
+func (driveDestination) Upload(ctx context.Context, userID int64, file *UploadFile) (*UploadResult, error) {
+	userToken, err := loadDriveToken(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	uploader, err := newDriveUploader(ctx, userToken.Token())
+	if err != nil {
+		return nil, err
+	}
+
+	driveFile := &drive.File{Name: file.Name}
+	if file.Convert {
+		// 指定 Google 文件等原生格式的 mimeType，Drive 會在上傳時轉換；轉換後的檔案不需要副檔名
+		if googleType, ok := driveConversionTypes[strings.ToLower(path.Ext(file.Name))]; ok {
+			driveFile.MimeType = googleType
+			driveFile.Name = strings.TrimSuffix(file.Name, path.Ext(file.Name))
+		}
+	}
+	props := map[string]string{}
+	if file.Source != nil {
+		for k, v := range file.Source.Properties() {
+			props[k] = v
+		}
+	}
+	driveFile.Description = uploadDescription(file.Caption, file.Origin)
+	if file.Location != "" {
+		driveFile.Description = strings.TrimLeft(driveFile.Description+"\n\n"+file.Location, "\n")
+	}
+	if file.Origin != nil {
+		for k, v := range file.Origin.Properties() {
+			props[k] = v
+		}
+	}
+	// 每個 hashtag 各自一個 key，之後可以用 appProperties has { key='tag_tax' and value='true' } 查詢
+	for _, tag := range file.Tags {
+		props[propTagPrefix+tag] = "true"
+	}
+	if len(props) > 0 {
+		driveFile.AppProperties = driveAppProperties(props)
+	}
+	// 未設定資料夾時，檔案會直接上傳到使用者的 "My Drive"
+	if len(file.Folders) > 0 {
+		folderID, err := uploader.EnsureFolder(ctx, file.Folders)
+		if err != nil {
+			return nil, err
+		}
+		driveFile.Parents = []string{folderID}
+	}
+
+	var mediaOptions []googleapi.MediaOption
+	if file.MimeType != "" {
+		mediaOptions = append(mediaOptions, googleapi.ContentType(file.MimeType))
+	}
+
+	spanCtx, span := startSpan(ctx, "drive.upload")
+	created, err := uploader.CreateFile(spanCtx, driveFile, file.Body, mediaOptions...)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return &UploadResult{FileID: created.Id, Name: created.Name, Link: created.WebViewLink, Account: userToken.Email}, nil
+}
+
+func (driveDestination) Link(ctx context.Context, userID int64, fileID string) (string, error) {
+	return "https://drive.google.com/file/d/" + fileID + "/view", nil
+}
+
+// Share 讓任何知道連結的人都能檢視檔案，回傳分享連結
+func (driveDestination) Share(ctx context.Context, userID int64, fileID string) (string, error) {
+	driveService, err := newDriveService(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	_, err = driveService.Permissions.Create(fileID, &drive.Permission{Type: "anyone", Role: "reader"}).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to create permission: %v", err)
+	}
+	file, err := driveService.Files.Get(fileID).Fields("webViewLink").Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	return file.WebViewLink, nil
+}
+
+// SetTrashed 將檔案移到 Drive 的垃圾桶或還原；Drive 會在 30 天後永久刪除垃圾桶中的檔案
+func (driveDestination) SetTrashed(ctx context.Context, userID int64, fileID string, trashed bool) error {
+	driveService, err := newDriveService(ctx, userID)
+	if err != nil {
+		return err
+	}
+	// 還原時 Trashed 為零值，需要以 ForceSendFields 明確送出
+	_, err = driveService.Files.Update(fileID, &drive.File{Trashed: trashed, ForceSendFields: []string{"Trashed"}}).Fields("id").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to update trashed state: %v", err)
+	}
+	return nil
+}
+
+func (driveDestination) Rename(ctx context.Context, userID int64, fileID, name string) error {
+	driveService, err := newDriveService(ctx, userID)
+	if err != nil {
+		return err
+	}
+	_, err = driveService.Files.Update(fileID, &drive.File{Name: name}).Fields("id").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to rename file: %v", err)
+	}
+	return nil
+}
+
+func (driveDestination) SetStarred(ctx context.Context, userID int64, fileID string, starred bool) error {
+	driveService, err := newDriveService(ctx, userID)
+	if err != nil {
+		return err
+	}
+	_, err = driveService.Files.Update(fileID, &drive.File{Starred: starred, ForceSendFields: []string{"Starred"}}).Fields("id").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to update starred state: %v", err)
+	}
+	return nil
+}
+
+// Search 以檔名搜尋，query 為空字串時回傳最近修改的檔案；drive.file 權限只會找到本應用程式上傳的檔案
+func (driveDestination) Search(ctx context.Context, userID int64, query string, limit int) ([]RemoteFile, error) {
+	driveService, err := newDriveService(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	q := fmt.Sprintf("mimeType != '%s' and trashed = false", driveFolderMimeType)
+	if query != "" {
+		q = fmt.Sprintf("name contains '%s' and %s", escapeDriveQuery(query), q)
+	}
+	list, err := driveService.Files.List().Q(q).OrderBy("modifiedTime desc").
+		Fields("files(id,name,mimeType,size,webViewLink)").PageSize(int64(limit)).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to search files: %w", err)
+	}
+	files := make([]RemoteFile, 0, len(list.Files))
+	for _, f := range list.Files {
+		files = append(files, RemoteFile{ID: f.Id, Name: f.Name, MimeType: f.MimeType, Size: f.Size, Link: f.WebViewLink})
+	}
+	return files, nil
+}
+
+// FindByProperties 以 appProperties 查詢檔案，例如找出同一個 Telegram 檔案上傳後的 Drive 檔案
+func (driveDestination) FindByProperties(ctx context.Context, userID int64, props map[string]string, limit int) ([]RemoteFile, error) {
+	driveService, err := newDriveService(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return findDriveFiles(ctx, driveService, props, limit)
+}
+
+// findDriveFiles 查詢 appProperties 符合所有 props 的檔案，由新到舊
+func findDriveFiles(ctx context.Context, driveService *drive.Service, props map[string]string, limit int) ([]RemoteFile, error) {
+	conditions := []string{"trashed = false"}
+	for k, v := range driveAppProperties(props) {
+		conditions = append(conditions, fmt.Sprintf("appProperties has { key='%s' and value='%s' }", escapeDriveQuery(k), escapeDriveQuery(v)))
+	}
+	sort.Strings(conditions)
+	list, err := driveService.Files.List().Q(strings.Join(conditions, " and ")).OrderBy("createdTime desc").
+		Fields("files(id,name,mimeType,size)").PageSize(int64(limit)).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to query files: %w", err)
+	}
+	files := make([]RemoteFile, 0, len(list.Files))
+	for _, f := range list.Files {
+		files = append(files, RemoteFile{ID: f.Id, Name: f.Name, MimeType: f.MimeType, Size: f.Size})
+	}
+	return files, nil
+}
+
+// Download 下載檔案內容；Google 文件等原生格式無法直接下載，回傳 errNativeDriveFile
+func (driveDestination) Download(ctx context.Context, userID int64, fileID string) (*RemoteFile, io.ReadCloser, error) {
+	driveService, err := newDriveService(ctx, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := driveService.Files.Get(fileID).Fields("id,name,mimeType,size").Context(ctx).Do()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get file: %w", err)
+	}
+	file := &RemoteFile{ID: f.Id, Name: f.Name, MimeType: f.MimeType, Size: f.Size}
+	if strings.HasPrefix(f.MimeType, "application/vnd.google-apps.") {
+		return file, nil, errNativeDriveFile
+	}
+	resp, err := driveService.Files.Get(fileID).Context(ctx).Download()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	return file, resp.Body, nil
+}
+
+// errNativeDriveFile 表示檔案是 Google 文件、試算表等沒有原始檔案的格式
+var errNativeDriveFile = errors.New("google native files cannot be downloaded")
+
+// Quota 回傳使用者 Drive 的用量；Google Workspace 等沒有上限的帳號 Limit 為 0
+func (driveDestination) Quota(ctx context.Context, userID int64) (*storageQuota, error) {
+	userToken, err := loadDriveToken(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	uploader, err := newDriveUploader(ctx, userToken.Token())
+	if err != nil {
+		return nil, err
+	}
+	quota, err := uploader.StorageQuota(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage quota: %v", err)
+	}
+	if quota == nil {
+		return &storageQuota{}, nil
+	}
+	return &storageQuota{Used: quota.Usage, Limit: quota.Limit}, nil
+}
+
+// UploadErrorMessage 依 Drive API 的錯誤原因回覆可以採取的行動；授權失效或權限不足時附上重新授權的連結
+func (d driveDestination) UploadErrorMessage(ctx context.Context, userID int64, err error) string {
+	// Refresh Token 被撤銷或過期時，oauth2 在換發 Access Token 時就會失敗
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		return d.reauthMessage(ctx, userID, "您的 Google Drive 授權已失效或已被撤銷，檔案沒有上傳。")
+	}
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return ""
+	}
+	reason := ""
+	if len(apiErr.Errors) > 0 {
+		reason = apiErr.Errors[0].Reason
+	}
+
+	switch {
+	case reason == "storageQuotaExceeded":
+		invalidateQuota(ctx, d, userID)
+		return "您的 Google Drive 空間已滿，檔案沒有上傳。請刪除不需要的檔案或清空垃圾桶後再重新傳送。"
+	case apiErr.Code == 429 || reason == "userRateLimitExceeded" || reason == "rateLimitExceeded":
+		return "Google Drive 暫時限制了上傳頻率，請過幾分鐘後再重新傳送。"
+	case reason == "dailyLimitExceeded":
+		return "本 Bot 今日的 Google Drive API 用量已達上限，請明天再試，或聯絡管理員。"
+	case apiErr.Code == 401:
+		return d.reauthMessage(ctx, userID, "您的 Google Drive 授權已失效，檔案沒有上傳。")
+	case apiErr.Code == 403:
+		return d.reauthMessage(ctx, userID, "本 Bot 沒有足夠的權限存取您的 Google Drive，檔案沒有上傳。")
+	case apiErr.Code == 404:
+		// 資料夾在尋找與上傳之間被刪除，或被移到沒有權限的位置
+		return "找不到要上傳的 Google Drive 資料夾，可能剛被刪除或移動了。請重新傳送一次；若持續發生，請在 /settings 檢查資料夾範本。"
+	}
+	return ""
+}
+
+// reauthMessage 在說明後附上重新授權的連結，產生連結失敗時改為提示連結指令
+func (d driveDestination) reauthMessage(ctx context.Context, userID int64, reason string) string {
+	authURL, err := d.Connect(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create re-auth link", "error", err)
+		return fmt.Sprintf("%s\n請使用 %s 指令重新授權後再傳送一次。", reason, connectCommand(d))
+	}
+	return fmt.Sprintf("%s\n請點擊以下連結重新授權，完成後再傳送一次：\n\n%s", reason, authURL)
+}
+
+// newDriveService 建立一個使用使用者權杖的 Drive 服務
+func newDriveService(ctx context.Context, userID int64) (*drive.Service, error) {
+	userToken, err := loadDriveToken(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return newDriveServiceWithToken(ctx, userToken.Token())
+}
+
+// loadDriveToken 讀取 Google 帳號的權杖
+// ctx 中以 withGoogleAccount 指定了帳號時使用該帳號，否則使用目前使用的帳號
+func loadDriveToken(ctx context.Context, userID int64) (*UserToken, error) {
+	if email := googleAccountFromContext(ctx); email != "" {
+		return loadTokenDoc(ctx, googleAccountCollection, googleAccountDocID(userID, email))
+	}
+	return loadTokenDoc(ctx, tokenCollection, fmt.Sprintf("%d", userID))
+}
+
+// driveEndpoint 不為空時 Drive API 改送到這個位址，測試時指向本機的假伺服器
+var driveEndpoint string
+
+func newDriveServiceWithToken(ctx context.Context, token *oauth2.Token) (*drive.Service, error) {
+	client := withBreaker(googleOAuth(ctx).Client(withTracedHTTPClient(ctx), token), driveBreaker)
+	opts := []option.ClientOption{option.WithHTTPClient(client)}
+	if driveEndpoint != "" {
+		opts = append(opts, option.WithEndpoint(driveEndpoint))
+	}
+	driveService, err := drive.NewService(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create drive service: %v", err)
+	}
+	return driveService, nil
+}
+
+// DriveUploader 是上傳流程用到的 Drive API：建立資料夾、上傳檔案與查詢剩餘空間
+type DriveUploader interface {
+	EnsureFolder(ctx context.Context, segments []string) (string, error)
+	CreateFile(ctx context.Context, file *drive.File, media io.Reader, options ...googleapi.MediaOption) (*drive.File, error)
+	StorageQuota(ctx context.Context) (*drive.AboutStorageQuota, error)
+}
+
+// newDriveUploader 以使用者的權杖建立 DriveUploader，測試時替換成假的實作
+var newDriveUploader = func(ctx context.Context, token *oauth2.Token) (DriveUploader, error) {
+	driveService, err := newDriveServiceWithToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return driveServiceUploader{service: driveService}, nil
+}
+
+// driveServiceUploader 以 Drive API 實作 DriveUploader
+type driveServiceUploader struct {
+	service *drive.Service
+}
+
+func (u driveServiceUploader) EnsureFolder(ctx context.Context, segments []string) (string, error) {
+	return ensureDriveFolder(ctx, u.service, segments)
+}
+
+func (u driveServiceUploader) CreateFile(ctx context.Context, file *drive.File, media io.Reader, options ...googleapi.MediaOption) (*drive.File, error) {
+	return u.service.Files.Create(file).Media(media, options...).Fields("id", "name", "webViewLink").Context(ctx).Do()
+}
+
+func (u driveServiceUploader) StorageQuota(ctx context.Context) (*drive.AboutStorageQuota, error) {
+	about, err := u.service.About.Get().Fields("storageQuota").Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return about.StorageQuota, nil
+}
+
+// ensureDriveFolder 依序尋找或建立路徑上的每一層資料夾，回傳最後一層的資料夾 ID
+// 由於只有 drive.file 權限，只會找到由本應用程式建立的資料夾
+func ensureDriveFolder(ctx context.Context, driveService *drive.Service, segments []string) (string, error) {
+	ctx, span := startSpan(ctx, "drive.ensure_folder")
+	span.SetAttributes(attribute.Int("folder.depth", len(segments)))
+	defer span.End()
+
+	parentID := "root"
+	for _, name := range segments {
+		query := fmt.Sprintf("name = '%s' and mimeType = '%s' and '%s' in parents and trashed = false",
+			escapeDriveQuery(name), driveFolderMimeType, parentID)
+		list, err := driveService.Files.List().Q(query).Fields("files(id)").PageSize(1).Context(ctx).Do()
+		if err != nil {
+			return "", fmt.Errorf("failed to look up folder %q: %w", name, err)
+		}
+		if len(list.Files) > 0 {
+			parentID = list.Files[0].Id
+			continue
+		}
+
+		folder, err := driveService.Files.Create(&drive.File{
+			Name:     name,
+			MimeType: driveFolderMimeType,
+			Parents:  []string{parentID},
+		}).Fields("id").Context(ctx).Do()
+		if err != nil {
+			return "", fmt.Errorf("failed to create folder %q: %w", name, err)
+		}
+		parentID = folder.Id
+	}
+	return parentID, nil
+}
+
+// Drive 的 appProperties 每組 key 與 value 合計不能超過 124 位元組
+const maxDriveAppPropertyBytes = 124
+
+// driveAppProperties 截斷過長的值，避免 Drive 拒絕整個上傳；key 本身就超過上限時捨棄這一組
+func driveAppProperties(props map[string]string) map[string]string {
+	out := make(map[string]string, len(props))
+	for k, v := range props {
+		limit := maxDriveAppPropertyBytes - len(k)
+		if limit <= 0 {
+			continue
+		}
+		for len(v) > limit {
+			_, size := utf8.DecodeLastRuneInString(v)
+			v = v[:len(v)-size]
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// escapeDriveQuery 跳脫 Drive 查詢字串中的特殊字元
+func escapeDriveQuery(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
+
+// setDriveDescription 更新 Drive 檔案的描述，Drive 會將描述納入搜尋
+func setDriveDescription(ctx context.Context, userID int64, fileID, description string) error {
+	driveService, err := newDriveService(ctx, userID)
+	if err != nil {
+		return err
+	}
+	_, err = driveService.Files.Update(fileID, &drive.File{Description: description}).Fields("id").Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("failed to update description: %v", err)
+	}
+	return nil
+}
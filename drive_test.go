@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDriveAppProperties(t *testing.T) {
+	longCJK := strings.Repeat("報", 41)
+	props := driveAppProperties(map[string]string{
+		"tg_chat_id":            "-100123",
+		"tg_caption":            strings.Repeat("字", 60),
+		propTagPrefix + longCJK: "true", // key 本身就超過 124 位元組
+	})
+
+	if got := props["tg_chat_id"]; got != "-100123" {
+		t.Errorf("tg_chat_id = %q, want -100123", got)
+	}
+	caption := props["tg_caption"]
+	if n := len("tg_caption") + len(caption); n > maxDriveAppPropertyBytes {
+		t.Errorf("tg_caption takes %d bytes, want at most %d", n, maxDriveAppPropertyBytes)
+	}
+	if !strings.HasPrefix(strings.Repeat("字", 60), caption) || caption == "" {
+		t.Errorf("tg_caption = %q, want a prefix of whole characters", caption)
+	}
+	if _, ok := props[propTagPrefix+longCJK]; ok {
+		t.Errorf("a key longer than the limit was kept")
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf16"
+
+	"golang.org/x/oauth2"
+)
+
+// Firestore 集合名稱
+const dropboxTokenCollection = "dropbox_tokens"
+
+const (
+	dropboxAPIURL     = "https://api.dropboxapi.com/2"
+	dropboxContentURL = "https://content.dropboxapi.com/2"
+)
+
+// dropboxDestination 透過 Dropbox API v2 將檔案上傳到使用者的 Dropbox
+type dropboxDestination struct {
+	config *oauth2.Config
+}
+
+// newDropboxDestination 在設定了 DROPBOX_APP_KEY 與 DROPBOX_APP_SECRET 時啟用 Dropbox
+// 回呼網址預設與 Google 共用 /oauth/callback，state 中會記錄是哪個目的地
+func newDropboxDestination(cfg OAuthClientConfig) (*dropboxDestination, bool) {
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return nil, false
+	}
+	redirectURL := cfg.RedirectURL
+	if redirectURL == "" {
+		redirectURL = defaultTenant.OAuth.RedirectURL
+	}
+	return &dropboxDestination{config: &oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  redirectURL,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  "https://www.dropbox.com/oauth2/authorize",
+			TokenURL: "https://api.dropboxapi.com/oauth2/token",
+		},
+	}}, true
+}
+
+func (*dropboxDestination) Name() string        { return "dropbox" }
+func (*dropboxDestination) DisplayName() string { return "Dropbox" }
+
+func (*dropboxDestination) Connected(ctx context.Context, userID int64) (bool, error) {
+	return hasUserToken(ctx, dropboxTokenCollection, userID)
+}
+
+func (d *dropboxDestination) Connect(ctx context.Context, userID int64) (string, error) {
+	state, challenge, err := newOAuthState(ctx, userID, d.Name())
+	if err != nil {
+		return "", err
+	}
+	// token_access_type=offline 才會拿到 refresh token
+	return d.config.AuthCodeURL(state, oauth2.SetAuthURLParam("token_access_type", "offline"), challenge), nil
+}
+
+func (d *dropboxDestination) Exchange(ctx context.Context, userID int64, code, verifier string) error {
+	token, err := d.config.Exchange(ctx, code, verifierOptions(verifier)...)
+	if err != nil {
+		return fmt.Errorf("failed to exchange dropbox token: %v", err)
+	}
+	return saveUserToken(ctx, dropboxTokenCollection, userID, token)
+}
+
+// dropboxMetadata 是 Dropbox API 回傳的檔案資訊
+type dropboxMetadata struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	PathDisplay string `json:"path_display"`
+}
+
+func (d *dropboxDestination) Upload(ctx context.Context, userID int64, file *UploadFile) (*UploadResult, error) {
+	client, err := d.client(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	target := "/" + path.Join(append(append([]string{}, file.Folders...), file.Name)...)
+	arg, err := dropboxAPIArg(map[string]interface{}{
+		"path":       target,
+		"mode":       "add",
+		"autorename": true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	spanCtx, span := startSpan(ctx, "dropbox.upload")
+	req, err := http.NewRequestWithContext(spanCtx, http.MethodPost, dropboxContentURL+"/files/upload", file.Body)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Dropbox-API-Arg", arg)
+
+	var meta dropboxMetadata
+	err = doDropboxRequest(client, req, &meta)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return &UploadResult{FileID: meta.ID, Name: meta.Name, Link: dropboxWebLink(meta.PathDisplay)}, nil
+}
+
+func (d *dropboxDestination) Link(ctx context.Context, userID int64, fileID string) (string, error) {
+	client, err := d.client(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	body, _ := json.Marshal(map[string]string{"path": fileID})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dropboxAPIURL+"/files/get_metadata", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var meta dropboxMetadata
+	if err := doDropboxRequest(client, req, &meta); err != nil {
+		return "", err
+	}
+	return dropboxWebLink(meta.PathDisplay), nil
+}
+
+// client 建立一個使用使用者權杖、會自動重新整理 access token 的 HTTP client
+func (d *dropboxDestination) client(ctx context.Context, userID int64) (*http.Client, error) {
+	token, err := loadUserToken(ctx, dropboxTokenCollection, userID)
+	if err != nil {
+		return nil, err
+	}
+	return d.config.Client(withTracedHTTPClient(ctx), token), nil
+}
+
+// doDropboxRequest 送出請求並將 JSON 回應解碼到 out
+func doDropboxRequest(client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("dropbox API %s returned %s: %s", req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// dropboxAPIArg 產生 Dropbox-API-Arg 標頭；HTTP 標頭只能包含 ASCII，非 ASCII 字元需跳脫成 \uXXXX
+func dropboxAPIArg(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, r := range string(b) {
+		if r < 0x80 {
+			sb.WriteRune(r)
+			continue
+		}
+		for _, unit := range utf16.Encode([]rune{r}) {
+			fmt.Fprintf(&sb, `\u%04x`, unit)
+		}
+	}
+	return sb.String(), nil
+}
+
+// dropboxWebLink 回傳在 Dropbox 網頁版預覽檔案的網址
+func dropboxWebLink(pathDisplay string) string {
+	if pathDisplay == "" {
+		return ""
+	}
+	dir, name := path.Split(pathDisplay)
+	return "https://www.dropbox.com/home" + (&url.URL{Path: strings.TrimSuffix(dir, "/")}).EscapedPath() + "?preview=" + url.QueryEscape(name)
+}
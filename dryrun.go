@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// --- 試運行模式 ---
+
+// 試運行時寫入回覆與 UploadResult 的標記
+const dryRunTag = "[dry-run]"
+
+// dryRun 回報是否開啟試運行模式（DRY_RUN）：完整執行下載、範本與授權檢查，但不寫入使用者的儲存空間
+// 讓管理者可以在正式環境中驗證設定與 OAuth，而不會動到使用者的檔案
+func dryRun(ctx context.Context) bool {
+	return configFor(ctx).DryRun
+}
+
+// uploadTo 將檔案上傳到目的地；試運行時讀完檔案內容後只記錄原本要上傳的位置
+func uploadTo(ctx context.Context, dest Destination, userID int64, file *UploadFile) (*UploadResult, error) {
+	if !dryRun(ctx) {
+		return dest.Upload(ctx, userID, file)
+	}
+	// 讀完內容才能發現下載逾時或超過大小上限等錯誤
+	n, err := io.Copy(io.Discard, file.Body)
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Dry run: skipped upload", "destination", dest.Name(), "file_name", file.Name,
+		"folders", strings.Join(file.Folders, "/"), "mime_type", file.MimeType, "size", n)
+	return &UploadResult{FileID: dryRunTag, Name: file.Name}, nil
+}
+
+// dryRunReply 在試運行時於回覆開頭加上標記
+func dryRunReply(ctx context.Context, text string) string {
+	if !dryRun(ctx) {
+		return text
+	}
+	return dryRunTag + " " + text
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// --- 網頁轉換服務的對外連線代理 ---
+
+// egressProxy 是給 Gotenberg 的 Chromium 使用的 HTTP 代理，在撥號時檢查每一個連線實際的位址並拒絕內部位址
+// 網頁由 Chromium 載入，只檢查使用者傳來的網址無法防止轉址、DNS 重新綁定或網頁中的資源連到內部網路，
+// 所有連線都經過這個代理才能在轉換服務的那一端限制對外連線
+type egressProxy struct {
+	dialer    *net.Dialer
+	transport *http.Transport
+}
+
+// hop-by-hop 標頭只屬於與代理之間的連線，不轉送給目標網站
+var hopHeaders = []string{"Connection", "Proxy-Connection", "Proxy-Authenticate", "Proxy-Authorization", "Keep-Alive", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// newEgressProxy 建立以 control 檢查連線位址的代理，正式環境使用 rejectInternalAddress
+func newEgressProxy(control func(network, address string, c syscall.RawConn) error) *egressProxy {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: control}
+	return &egressProxy{
+		dialer: dialer,
+		transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			ResponseHeaderTimeout: pdfRenderTimeout,
+		},
+	}
+}
+
+func (p *egressProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "this is a forward proxy", http.StatusBadRequest)
+		return
+	}
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	for _, h := range hopHeaders {
+		out.Header.Del(h)
+	}
+	// 轉址由 Chromium 自己跟隨，下一個網址會再經過代理檢查一次
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Egress proxy refused or failed a request", "host", r.URL.Host, "error", err)
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	for _, h := range hopHeaders {
+		resp.Header.Del(h)
+	}
+	for k, values := range resp.Header {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// tunnel 處理 HTTPS 的 CONNECT：確認目標位址後才建立通道
+func (p *egressProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	upstream, err := p.dialer.DialContext(r.Context(), "tcp", r.Host)
+	if err != nil {
+		slog.WarnContext(r.Context(), "Egress proxy refused or failed a tunnel", "host", r.Host, "error", err)
+		http.Error(w, "upstream unavailable", http.StatusBadGateway)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "tunneling not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buf, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		client.Close()
+		upstream.Close()
+		return
+	}
+	go func() {
+		defer upstream.Close()
+		// Hijack 前已經讀進緩衝區的資料要先送出
+		if n := buf.Reader.Buffered(); n > 0 {
+			data, _ := buf.Reader.Peek(n)
+			upstream.Write(data)
+		}
+		io.Copy(upstream, client)
+	}()
+	go func() {
+		defer client.Close()
+		io.Copy(client, upstream)
+	}()
+}
+
+// startEgressProxy 在 addr 上啟動代理，讓 Gotenberg 以 --chromium-proxy-server 將所有連線送過來
+func startEgressProxy(addr string) {
+	server := &http.Server{Addr: addr, Handler: newEgressProxy(rejectInternalAddress), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			slog.Error("Egress proxy stopped", "error", err)
+		}
+	}()
+	slog.Info("Egress proxy for the PDF renderer started", "addr", addr)
+}
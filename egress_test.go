@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"syscall"
+	"testing"
+)
+
+func TestEgressProxy(t *testing.T) {
+	// internal 代表雲端的 metadata server 或其他內部服務，任何請求都不應該送到這裡
+	var internalHits atomic.Int32
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		internalHits.Add(1)
+		w.Write([]byte("secret"))
+	}))
+	defer internal.Close()
+	internalTLS := httptest.NewTLSServer(internal.Config.Handler)
+	defer internalTLS.Close()
+
+	// public 是使用者傳來的網址，其中 /redirect 轉址到內部服務
+	public := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, internal.URL+"/computeMetadata/v1/", http.StatusFound)
+			return
+		}
+		w.Write([]byte("article"))
+	}))
+	defer public.Close()
+	publicTLS := httptest.NewTLSServer(public.Config.Handler)
+	defer publicTLS.Close()
+	publicAddrs := map[string]bool{public.Listener.Addr().String(): true, publicTLS.Listener.Addr().String(): true}
+
+	// 測試中的伺服器都在 127.0.0.1，只把 public 視為公開位址
+	proxy := httptest.NewServer(newEgressProxy(func(network, address string, _ syscall.RawConn) error {
+		if !publicAddrs[address] {
+			return fmt.Errorf("address %s is not allowed", address)
+		}
+		return nil
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	}}
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int // 0 代表請求應該失敗
+		wantBody   string
+	}{
+		{"public page", public.URL + "/article", http.StatusOK, "article"},
+		{"public page over https", publicTLS.URL + "/article", http.StatusOK, "article"},
+		{"redirect to internal", public.URL + "/redirect", http.StatusBadGateway, ""},
+		{"https redirect to internal", publicTLS.URL + "/redirect", http.StatusBadGateway, ""},
+		{"internal", internal.URL, http.StatusBadGateway, ""},
+		{"internal over https", internalTLS.URL, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := client.Get(tt.url)
+			if tt.wantStatus == 0 {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("GET %s succeeded, want the tunnel to be refused", tt.url)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GET %s: %v", tt.url, err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantBody != "" {
+				if body, _ := io.ReadAll(resp.Body); string(body) != tt.wantBody {
+					t.Errorf("body = %q, want %q", body, tt.wantBody)
+				}
+			}
+		})
+	}
+	if n := internalHits.Load(); n != 0 {
+		t.Errorf("internal service received %d requests through the proxy", n)
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 郵件上傳 ---
+
+const (
+	// 上傳信箱與使用者的對應，文件 ID 是信箱的識別碼
+	emailAddressCollection = "email_addresses"
+	// 郵件附件上傳到的資料夾
+	emailFolder = "Email Attachments"
+	// 一封郵件的大小上限，超過時拒絕整封郵件
+	inboundEmailMaxBytes = 64 << 20
+	// 解析郵件時放在記憶體中的大小，其餘的附件暫存到磁碟
+	inboundEmailMemory = 16 << 20
+)
+
+// 信箱識別碼使用小寫的 base32，收件者的大小寫被改變時仍能辨識
+var emailTokenEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// inboundEmailDomain 回傳 INBOUND_EMAIL_DOMAIN，以小寫比對收件者；空字串代表不啟用郵件上傳
+func inboundEmailDomain(ctx context.Context) string {
+	return strings.ToLower(configFor(ctx).InboundEmail.Domain)
+}
+
+// 處理 /email_address 指令：顯示使用者專屬的上傳信箱，寄到這個信箱的附件會上傳到使用者的目的地
+// /email_address new 換一個新的信箱（舊的信箱立即失效），/email_address off 停用
+func handleEmailAddress(ctx context.Context, message *tgbotapi.Message) {
+	if !message.Chat.IsPrivate() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請在與機器人的私訊中使用 /email_address。")
+		return
+	}
+	if inboundEmailDomain(ctx) == "" {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "此機器人尚未啟用郵件上傳。")
+		return
+	}
+	userID := message.From.ID
+	token, err := findEmailToken(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to read email address", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的上傳信箱時發生錯誤，請稍後再試。")
+		return
+	}
+
+	switch strings.ToLower(strings.TrimSpace(message.CommandArguments())) {
+	case "":
+	case "new":
+		if token != "" {
+			if err := collection(ctx, emailAddressCollection).Doc(token).Delete(ctx); err != nil {
+				slog.ErrorContext(ctx, "Failed to delete email address", "error", err)
+				replyToUser(ctx, message.Chat.ID, message.MessageID, "更換上傳信箱時發生錯誤，請稍後再試。")
+				return
+			}
+			token = ""
+		}
+	case "off":
+		if token != "" {
+			if err := collection(ctx, emailAddressCollection).Doc(token).Delete(ctx); err != nil {
+				slog.ErrorContext(ctx, "Failed to delete email address", "error", err)
+				replyToUser(ctx, message.Chat.ID, message.MessageID, "停用上傳信箱時發生錯誤，請稍後再試。")
+				return
+			}
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "已停用上傳信箱，寄到原本信箱的郵件不會再被處理。輸入 /email_address 可以取得新的信箱。")
+		return
+	default:
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "用法：/email_address 顯示上傳信箱、/email_address new 換一個新的信箱、/email_address off 停用")
+		return
+	}
+
+	if token == "" {
+		token, err = createEmailToken(ctx, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to create email address", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "建立上傳信箱時發生錯誤，請稍後再試。")
+			return
+		}
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf(
+		"您專屬的上傳信箱：\n%s\n\n寄到這個信箱的郵件附件會上傳到您的儲存空間的「%s」資料夾。任何知道這個信箱的人都能寄送檔案給您，外洩時請以 /email_address new 換一個新的信箱。",
+		emailAddress(ctx, token), emailFolder))
+}
+
+// emailAddress 回傳信箱識別碼對應的完整信箱；其他機器人的信箱會加上機器人 ID 的前綴
+func emailAddress(ctx context.Context, token string) string {
+	return tenantState(ctx, token) + "@" + inboundEmailDomain(ctx)
+}
+
+// findEmailToken 回傳使用者目前的信箱識別碼，尚未建立時回傳空字串
+func findEmailToken(ctx context.Context, userID int64) (string, error) {
+	docs, err := collection(ctx, emailAddressCollection).Where("user_id", "==", userID).Limit(1).GetAll(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(docs) == 0 {
+		return "", nil
+	}
+	return docs[0].Ref.ID, nil
+}
+
+// createEmailToken 建立新的信箱識別碼
+func createEmailToken(ctx context.Context, userID int64) (string, error) {
+	b := make([]byte, 10)
+	rand.Read(b)
+	token := strings.ToLower(emailTokenEncoding.EncodeToString(b))
+	err := collection(ctx, emailAddressCollection).Doc(token).Set(ctx, map[string]interface{}{
+		"user_id":    userID,
+		"created_at": time.Now(),
+	})
+	return token, err
+}
+
+// 處理 /email/inbound/<密鑰>：SendGrid Inbound Parse 或 Mailgun 路由轉送的郵件
+// 郵件在請求中同步處理，處理完才回應，讓 Cloud Run 在上傳期間持續配置 CPU；找不到收件者時也回應 200，避免服務重送
+func inboundEmailHandler(w http.ResponseWriter, r *http.Request) {
+	domain := inboundEmailDomain(r.Context())
+	if domain == "" || subtle.ConstantTimeCompare([]byte(r.PathValue("secret")), []byte(configFor(r.Context()).InboundEmail.Secret)) != 1 {
+		http.NotFound(w, r)
+		return
+	}
+	ctx := withLogAttrs(r.Context(), slog.String("request_id", newRequestID()))
+	r.Body = http.MaxBytesReader(w, r.Body, inboundEmailMaxBytes)
+	if err := r.ParseMultipartForm(inboundEmailMemory); err != nil {
+		slog.WarnContext(ctx, "could not parse inbound email", "error", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+	form := r.MultipartForm
+
+	t, token := inboundEmailRecipient(domain, form.Value)
+	if t == nil {
+		slog.InfoContext(ctx, "Ignoring email without a known recipient")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	ctx = withTenant(ctx, t)
+	doc, err := collection(ctx, emailAddressCollection).Doc(token).Get(ctx)
+	if errors.Is(err, errDocNotFound) {
+		slog.InfoContext(ctx, "Ignoring email to an unknown address")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to read email address", "error", err)
+		http.Error(w, "failed to read address", http.StatusInternalServerError)
+		return
+	}
+	userID, _ := doc.Data()["user_id"].(int64)
+	ctx = withLogAttrs(ctx, slog.Int64("user_id", userID))
+	if !isUserAllowed(ctx, userID) {
+		slog.InfoContext(ctx, "Ignoring email for user not allowed")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	subject := cmp.Or(firstValue(form.Value, "subject"), "（無主旨）")
+	from := firstValue(form.Value, "from")
+	uploadEmailAttachments(ctx, userID, subject, from, form.File)
+	w.WriteHeader(http.StatusOK)
+}
+
+// inboundEmailRecipient 從轉送的欄位中找出寄到 domain 上的上傳信箱的收件者，回傳所屬的機器人與信箱識別碼
+// Mailgun 以 recipient 提供收件者，SendGrid 以 envelope 的 JSON 提供，兩者都沒有時使用 To 標頭
+func inboundEmailRecipient(domain string, values map[string][]string) (*tenant, string) {
+	var candidates []string
+	if v := firstValue(values, "recipient"); v != "" {
+		candidates = append(candidates, strings.Split(v, ",")...)
+	}
+	var envelope struct {
+		To []string `json:"to"`
+	}
+	if json.Unmarshal([]byte(firstValue(values, "envelope")), &envelope) == nil {
+		candidates = append(candidates, envelope.To...)
+	}
+	if list, err := mail.ParseAddressList(firstValue(values, "to")); err == nil {
+		for _, a := range list {
+			candidates = append(candidates, a.Address)
+		}
+	}
+	for _, c := range candidates {
+		local, host, ok := strings.Cut(strings.ToLower(strings.TrimSpace(c)), "@")
+		if !ok || host != domain {
+			continue
+		}
+		t := tenantFromState(local)
+		if t == nil {
+			continue
+		}
+		if _, token, ok := strings.Cut(local, "."); ok {
+			local = token
+		}
+		return t, local
+	}
+	return nil, ""
+}
+
+// uploadEmailAttachments 將郵件的附件上傳到 emailFolder，完成後以 Telegram 通知使用者結果
+func uploadEmailAttachments(ctx context.Context, userID int64, subject, from string, files map[string][]*multipart.FileHeader) {
+	notify := func(text string) {
+		if _, err := sendMessage(ctx, tgbotapi.NewMessage(userID, text)); err != nil {
+			slog.WarnContext(ctx, "Failed to notify email upload", "error", err)
+		}
+	}
+	// 附件欄位的名稱是 attachment1、attachment-1 等，依名稱排序讓處理的順序固定
+	var attachments []*multipart.FileHeader
+	for _, key := range slices.Sorted(maps.Keys(files)) {
+		attachments = append(attachments, files[key]...)
+	}
+	if len(attachments) == 0 {
+		notify(fmt.Sprintf("收到寄到上傳信箱的郵件「%s」，但郵件中沒有附件。", subject))
+		return
+	}
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		notify(fmt.Sprintf("處理郵件「%s」時讀取您的設定發生錯誤，請重新寄送。", subject))
+		return
+	}
+
+	var lines []string
+	uploaded := 0
+	for _, fh := range attachments {
+		name := strings.NewReplacer("/", "_", "\\", "_").Replace(cmp.Or(fh.Filename, "attachment"))
+		mimeType, _, _ := strings.Cut(fh.Header.Get("Content-Type"), ";")
+		if mimeType == "" || mimeType == "application/octet-stream" {
+			mimeType = cmp.Or(mimeTypeByExt(strings.ToLower(path.Ext(name))), "application/octet-stream")
+		}
+		result, err := uploadEmailAttachment(ctx, settings, userID, subject, from, fh, name, mimeType)
+		var limitErr *rateLimitError
+		switch {
+		case errors.As(err, &limitErr):
+			lines = append(lines, fmt.Sprintf("・%s：%s", name, rateLimitMessage(ctx, limitErr)))
+		case err != nil:
+			lines = append(lines, fmt.Sprintf("・%s：%s", name, err))
+		default:
+			uploaded++
+			lines = append(lines, fmt.Sprintf("・%s（%s）", result.Name, formatSize(fh.Size)))
+		}
+	}
+	notify(dryRunReply(ctx, fmt.Sprintf("已處理寄到上傳信箱的郵件「%s」（%s），%d 個附件上傳到「%s」資料夾：\n%s",
+		subject, from, uploaded, emailFolder, strings.Join(lines, "\n"))))
+}
+
+// uploadEmailAttachment 上傳一個附件；回傳的錯誤訊息會直接顯示給使用者
+func uploadEmailAttachment(ctx context.Context, settings *UserSettings, userID int64, subject, from string, fh *multipart.FileHeader, name, mimeType string) (*UploadResult, error) {
+	dest := uploadDestination(ctx, settings, mimeType)
+	connected, err := dest.Connected(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to retrieve token", "error", err)
+		return nil, errors.New("讀取您的授權時發生錯誤")
+	}
+	if !connected {
+		return nil, fmt.Errorf("您的 %s 帳號尚未連結，請使用 %s 指令來連結", dest.DisplayName(), connectCommand(dest))
+	}
+	if fh.Size > configFor(ctx).maxFileSize() {
+		return nil, errors.New(fileTooLargeMessage(ctx, fh.Size))
+	}
+	if err := reserveUpload(ctx, userID, fh.Size); err != nil {
+		var limitErr *rateLimitError
+		if errors.As(err, &limitErr) {
+			return nil, err
+		}
+		slog.ErrorContext(ctx, "Failed to check rate limit", "error", err)
+		return nil, errors.New("檢查上傳額度時發生錯誤")
+	}
+	content, err := fh.Open()
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to open email attachment", "error", err)
+		return nil, errors.New("讀取附件時發生錯誤")
+	}
+	defer content.Close()
+
+	result, err := uploadTo(ctx, dest, userID, &UploadFile{
+		Name:     name,
+		Folders:  []string{emailFolder},
+		Body:     content,
+		MimeType: mimeType,
+		Caption:  fmt.Sprintf("%s\n%s", subject, from),
+	})
+	event := &UploadEvent{UserID: userID, Chat: "Email", Destination: dest.Name(), FileName: name, MimeType: mimeType, Size: fh.Size}
+	if err != nil {
+		event.Event, event.Error = eventUploadFailed, err.Error()
+		emitUploadEvent(ctx, settings, event)
+		reportError(ctx, "Failed to upload email attachment", err, "destination", dest.Name())
+		return nil, errors.New(uploadFailedMessage(ctx, dest, userID, err, fmt.Sprintf("上傳到您的 %s 失敗", dest.DisplayName())))
+	}
+	recordLinkUpload(ctx, settings, dest, result, event)
+	slog.InfoContext(ctx, "Uploaded email attachment", "file_name", result.Name, "size", fh.Size)
+	return result, nil
+}
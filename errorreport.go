@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// --- 錯誤回報 ---
+
+// 帶有這個 @type 的日誌即使沒有堆疊，也會被 Cloud Error Reporting 收錄
+const reportedErrorEventType = "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent"
+
+// initErrorReporting 啟用錯誤回報：SENTRY_DSN 啟用 Sentry，ENABLE_ERROR_REPORTING=true 讓錯誤出現在 Cloud Error Reporting
+// 回傳的函式會送出尚未傳送的事件，需在程式結束前呼叫
+func initErrorReporting(cfg ErrorReportingConfig) (func(timeout time.Duration), error) {
+	if cfg.SentryDSN == "" {
+		return func(time.Duration) {}, nil
+	}
+	err := sentry.Init(sentry.ClientOptions{
+		Dsn:         cfg.SentryDSN,
+		Environment: cfg.SentryEnvironment,
+		Release:     cfg.Revision,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize Sentry: %v", err)
+	}
+	return func(timeout time.Duration) { sentry.Flush(timeout) }, nil
+}
+
+// reportError 記錄錯誤並送到已啟用的錯誤回報服務；args 與 slog 相同，會一併寫入日誌
+func reportError(ctx context.Context, msg string, err error, args ...any) {
+	cfg := configFor(ctx).ErrorReporting
+	args = append(args, "error", err)
+	if cfg.GCP {
+		args = append(args, "@type", reportedErrorEventType, serviceContextAttr(cfg))
+	}
+	slog.ErrorContext(ctx, msg, args...)
+
+	if cfg.SentryDSN != "" {
+		sentryHub(ctx).CaptureException(fmt.Errorf("%s: %v", msg, err))
+	}
+}
+
+// reportPanic 記錄 panic 與呼叫堆疊；stack_trace 欄位使用 Go 的 panic 格式，Error Reporting 會自動彙整
+func reportPanic(ctx context.Context, r any) {
+	slog.ErrorContext(ctx, "Recovered from panic", "panic", fmt.Sprint(r), "stack_trace", fmt.Sprintf("panic: %v\n\n%s", r, debug.Stack()))
+
+	if configFor(ctx).ErrorReporting.SentryDSN != "" {
+		sentryHub(ctx).RecoverWithContext(ctx, r)
+	}
+}
+
+// sentryHub 建立一個帶有日誌欄位（update_id、user_id、chat_id 等）的 Sentry hub，讓事件可以依使用者與更新搜尋
+func sentryHub(ctx context.Context) *sentry.Hub {
+	hub := sentry.CurrentHub().Clone()
+	attrs, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	hub.ConfigureScope(func(scope *sentry.Scope) {
+		for _, a := range attrs {
+			if a.Key == "user_id" {
+				scope.SetUser(sentry.User{ID: a.Value.String()})
+			}
+			scope.SetTag(a.Key, a.Value.String())
+		}
+	})
+	return hub
+}
+
+// serviceContextAttr 產生 Error Reporting 用來區分服務與版本的欄位，在 Cloud Run 上取自 K_SERVICE 與 K_REVISION
+func serviceContextAttr(cfg ErrorReportingConfig) slog.Attr {
+	service := cfg.Service
+	if service == "" {
+		service = "tg-helper"
+	}
+	return slog.Group("serviceContext", slog.String("service", service), slog.String("version", cfg.Revision))
+}
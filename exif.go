@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 依 EXIF 整理照片 ---
+
+// EXIF 中用到的標籤
+const (
+	exifTagDateTimeOriginal   = 0x9003
+	exifTagDateTimeDigitized  = 0x9004
+	exifTagOffsetTimeOriginal = 0x9011
+	exifTagExifIFD            = 0x8769
+	exifTagGPSIFD             = 0x8825
+	exifTagGPSLatitudeRef     = 0x0001
+	exifTagGPSLatitude        = 0x0002
+	exifTagGPSLongitudeRef    = 0x0003
+	exifTagGPSLongitude       = 0x0004
+)
+
+// TIFF 各資料型別的大小：BYTE、ASCII、SHORT、LONG、RATIONAL、UNDEFINED、SLONG、SRATIONAL
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 7: 1, 9: 4, 10: 8}
+
+// PhotoExif 是從照片的 EXIF 讀到的拍攝資訊，沒有的欄位為零值
+type PhotoExif struct {
+	TakenAt     time.Time // 拍攝時間；沒有時區時以伺服器的時區解讀，讓範本顯示相機上的時間
+	HasLocation bool
+	Latitude    float64
+	Longitude   float64
+}
+
+// Location 以「緯度, 經度」與地圖連結描述拍攝地點，寫入檔案描述
+func (e *PhotoExif) Location() string {
+	if !e.HasLocation {
+		return ""
+	}
+	return fmt.Sprintf("拍攝地點：%.6f, %.6f\nhttps://maps.google.com/?q=%.6f,%.6f", e.Latitude, e.Longitude, e.Latitude, e.Longitude)
+}
+
+// readUploadExif 預先讀取 JPEG 的檔頭解析 EXIF，回傳解析結果與包含完整內容的新串流
+func readUploadExif(ctx context.Context, r io.Reader) (*PhotoExif, io.Reader) {
+	br := bufio.NewReaderSize(r, imageHeaderPeekSize)
+	header, _ := br.Peek(imageHeaderPeekSize)
+	exif := parsePhotoExif(header)
+	if exif != nil {
+		slog.InfoContext(ctx, "Read photo EXIF", "taken_at", exif.TakenAt, "has_location", exif.HasLocation)
+	}
+	return exif, br
+}
+
+// jpegExif 從 JPEG 的 APP1 區段取出 EXIF 的 TIFF 結構，沒有時回傳 nil
+func jpegExif(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil
+		}
+		marker := data[i+1]
+		// SOS 之後是影像資料，EXIF 一定在它之前
+		if marker == 0xDA {
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		segment := data[i+4 : min(i+2+length, len(data))]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:]
+		}
+		i += 2 + length
+	}
+	return nil
+}
+
+// parsePhotoExif 解析 JPEG 檔頭中的拍攝時間與 GPS 位置，沒有 EXIF 或兩者都沒有時回傳 nil
+func parsePhotoExif(data []byte) *PhotoExif {
+	t := newTIFFReader(jpegExif(data))
+	if t == nil {
+		return nil
+	}
+	ifd0 := int(t.order.Uint32(t.data[4:]))
+	exif := &PhotoExif{}
+
+	if ifd := t.pointer(ifd0, exifTagExifIFD); ifd > 0 {
+		taken := t.ascii(ifd, exifTagDateTimeOriginal)
+		if taken == "" {
+			taken = t.ascii(ifd, exifTagDateTimeDigitized)
+		}
+		offset := t.ascii(ifd, exifTagOffsetTimeOriginal)
+		var err error
+		if offset != "" {
+			exif.TakenAt, err = time.Parse("2006:01:02 15:04:05-07:00", taken+offset)
+		} else {
+			exif.TakenAt, err = time.ParseInLocation("2006:01:02 15:04:05", taken, time.Local)
+		}
+		// 部分相機沒有設定時間時會寫入 0000:00:00 00:00:00
+		if err != nil || exif.TakenAt.Year() < 1900 {
+			exif.TakenAt = time.Time{}
+		}
+	}
+
+	if ifd := t.pointer(ifd0, exifTagGPSIFD); ifd > 0 {
+		lat, latOK := t.coordinate(ifd, exifTagGPSLatitude, exifTagGPSLatitudeRef, "S")
+		lng, lngOK := t.coordinate(ifd, exifTagGPSLongitude, exifTagGPSLongitudeRef, "W")
+		if latOK && lngOK && lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180 {
+			exif.HasLocation, exif.Latitude, exif.Longitude = true, lat, lng
+		}
+	}
+
+	if exif.TakenAt.IsZero() && !exif.HasLocation {
+		return nil
+	}
+	return exif
+}
+
+// tiffReader 讀取 EXIF 的 TIFF 結構中的 IFD 欄位
+type tiffReader struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+func newTIFFReader(tiff []byte) *tiffReader {
+	if len(tiff) < 8 {
+		return nil
+	}
+	switch string(tiff[:2]) {
+	case "II":
+		return &tiffReader{data: tiff, order: binary.LittleEndian}
+	case "MM":
+		return &tiffReader{data: tiff, order: binary.BigEndian}
+	}
+	return nil
+}
+
+// lookup 回傳 IFD 中指定標籤的原始內容與型別，找不到或超出範圍時回傳 false
+func (t *tiffReader) lookup(ifd int, tag uint16) ([]byte, uint16, bool) {
+	if ifd <= 0 || ifd+2 > len(t.data) {
+		return nil, 0, false
+	}
+	count := int(t.order.Uint16(t.data[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(t.data) {
+			return nil, 0, false
+		}
+		if t.order.Uint16(t.data[entry:]) != tag {
+			continue
+		}
+		typ := t.order.Uint16(t.data[entry+2:])
+		n := int(t.order.Uint32(t.data[entry+4:]))
+		if n <= 0 || n > len(t.data) {
+			return nil, 0, false
+		}
+		size := tiffTypeSizes[typ] * n
+		if size == 0 {
+			return nil, 0, false
+		}
+		// 4 位元組以內的值直接放在欄位中，否則欄位記錄的是位移
+		if size <= 4 {
+			return t.data[entry+8 : entry+8+size], typ, true
+		}
+		offset := int(t.order.Uint32(t.data[entry+8:]))
+		if offset <= 0 || offset+size > len(t.data) {
+			return nil, 0, false
+		}
+		return t.data[offset : offset+size], typ, true
+	}
+	return nil, 0, false
+}
+
+// pointer 讀取指向子 IFD 的 LONG 欄位，沒有時回傳 0
+func (t *tiffReader) pointer(ifd int, tag uint16) int {
+	value, typ, ok := t.lookup(ifd, tag)
+	if !ok || typ != 4 {
+		return 0
+	}
+	return int(t.order.Uint32(value))
+}
+
+// ascii 讀取 ASCII 欄位，去掉結尾的 NUL 與空白
+func (t *tiffReader) ascii(ifd int, tag uint16) string {
+	value, typ, ok := t.lookup(ifd, tag)
+	if !ok || typ != 2 {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(value), "\x00"))
+}
+
+// coordinate 讀取以度、分、秒三個 RATIONAL 記錄的 GPS 座標，refTag 為 negative 時轉成負值
+func (t *tiffReader) coordinate(ifd int, tag, refTag uint16, negative string) (float64, bool) {
+	value, typ, ok := t.lookup(ifd, tag)
+	if !ok || typ != 5 || len(value) < 24 {
+		return 0, false
+	}
+	var dms [3]float64
+	for i := range dms {
+		num, den := t.order.Uint32(value[i*8:]), t.order.Uint32(value[i*8+4:])
+		if den == 0 {
+			return 0, false
+		}
+		dms[i] = float64(num) / float64(den)
+	}
+	deg := dms[0] + dms[1]/60 + dms[2]/3600
+	if strings.EqualFold(t.ascii(ifd, refTag), negative) {
+		deg = -deg
+	}
+	return deg, true
+}
+
+// 處理 /exif 指令：切換依拍攝時間整理照片，以及是否將拍攝地點寫入檔案描述
+func handleExif(ctx context.Context, message *tgbotapi.Message) {
+	userID := message.From.ID
+	args := strings.Fields(message.CommandArguments())
+	field, label := "exif_date", "依拍攝時間整理照片"
+	if len(args) == 2 && args[0] == "location" {
+		field, label = "exif_location", "寫入拍攝地點"
+		args = args[1:]
+	}
+	var on, ok bool
+	if len(args) == 1 {
+		on, ok = parseOnOff(args[0])
+	}
+	if !ok {
+		settings, err := loadUserSettings(ctx, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load user settings", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取設定時發生錯誤，請稍後再試。")
+			return
+		}
+		onOff := func(on bool) string {
+			if on {
+				return "開啟"
+			}
+			return "關閉"
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf(
+			"依拍攝時間整理照片：%s\n寫入拍攝地點：%s\n\n用法：/exif on|off、/exif location on|off\n只適用於以檔案方式傳送的 JPEG 照片，Telegram 壓縮過的照片沒有 EXIF。",
+			onOff(settings.ExifDate), onOff(settings.ExifLocation)))
+		return
+	}
+	if err := updateUserSettings(ctx, userID, map[string]interface{}{field: on}); err != nil {
+		slog.ErrorContext(ctx, "Failed to update settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "更新設定時發生錯誤，請稍後再試。")
+		return
+	}
+	if !on {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "已關閉"+label+"。")
+		return
+	}
+	if field == "exif_date" {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "已開啟依拍攝時間整理照片，檔名與資料夾範本中的 {date}、{year}、{month} 等變數會改用照片的拍攝時間。")
+	} else {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "已開啟寫入拍攝地點，有 GPS 資訊的照片上傳到 Google Drive 時，會在檔案描述加上座標與地圖連結。")
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 取回檔案 ---
+
+const (
+	// Telegram Bot API 傳送檔案的上限
+	maxSendFileSize = 50 * 1024 * 1024
+	// /get 找到多個檔案時最多列出的數量
+	maxGetResults = 8
+)
+
+// 處理 /get 指令：搜尋之前上傳的檔案並傳回 Telegram，找到多個時以按鈕選擇
+// 不帶關鍵字回覆一則 Telegram 檔案訊息時，以上傳時寫入的 telegram_file_unique_id 找出同一個檔案
+func handleGet(ctx context.Context, message *tgbotapi.Message) {
+	query := strings.TrimSpace(message.CommandArguments())
+	var replied *telegramFile
+	if query == "" && message.ReplyToMessage != nil {
+		replied = messageFile(message.ReplyToMessage)
+	}
+	if query == "" && replied == nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "用法：/get <檔名關鍵字>，或回覆一則檔案訊息並輸入 /get")
+		return
+	}
+	dest, ok := fetchUserDestination(ctx, message)
+	if !ok {
+		return
+	}
+
+	var files []RemoteFile
+	var err error
+	if replied != nil {
+		files, err = dest.FindByProperties(ctx, message.From.ID, map[string]string{propTelegramFileUniqueID: replied.UniqueID}, maxGetResults)
+	} else {
+		files, err = dest.Search(ctx, message.From.ID, query, maxGetResults)
+	}
+	if errors.Is(err, errNotConnected) {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("您的 %s 帳號尚未連結，請使用 %s 指令來連結。", dest.DisplayName(), connectCommand(dest)))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to search files", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "搜尋檔案時發生錯誤，請稍後再試。")
+		return
+	}
+	switch len(files) {
+	case 0:
+		if replied != nil {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "找不到這個檔案上傳後的紀錄。")
+		} else {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("找不到檔名包含「%s」的檔案。", query))
+		}
+		return
+	case 1:
+		sendRemoteFile(ctx, dest, message.From.ID, message.Chat.ID, message.MessageID, files[0].ID)
+		return
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, f := range files {
+		label := f.Name
+		if f.Size > 0 {
+			label += "（" + formatSize(f.Size) + "）"
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(label, encodeCallbackData("get", f.ID))))
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, fmt.Sprintf("找到 %d 個檔案，請選擇要取回的檔案：", len(files)))
+	msg.ReplyToMessageID = message.MessageID
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	if _, err := sendMessage(ctx, msg); err != nil {
+		slog.ErrorContext(ctx, "Failed to send message", "error", err)
+	}
+}
+
+// handleGetCallback 處理 /get 的檔案選擇按鈕
+func handleGetCallback(ctx context.Context, query *tgbotapi.CallbackQuery, args []string) {
+	if len(args) != 1 || query.Message == nil {
+		answerCallback(ctx, query, "")
+		return
+	}
+	settings, err := loadUserSettings(ctx, query.From.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		answerCallback(ctx, query, "讀取您的設定時發生錯誤，請稍後再試。")
+		return
+	}
+	dest, ok := userDestination(ctx, settings).(fetchDestination)
+	if !ok {
+		answerCallback(ctx, query, "目前的上傳目的地不支援取回檔案。")
+		return
+	}
+	answerCallback(ctx, query, "正在取回檔案…")
+	sendRemoteFile(ctx, dest, query.From.ID, query.Message.Chat.ID, query.Message.MessageID, args[0])
+}
+
+// fetchUserDestination 回傳使用者目前的上傳目的地；不支援取回檔案時回覆使用者並回傳 false
+func fetchUserDestination(ctx context.Context, message *tgbotapi.Message) (fetchDestination, bool) {
+	settings, err := loadUserSettings(ctx, message.From.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的設定時發生錯誤，請稍後再試。")
+		return nil, false
+	}
+	d := userDestination(ctx, settings)
+	dest, ok := d.(fetchDestination)
+	if !ok {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("%s 目前不支援取回檔案。", d.DisplayName()))
+		return nil, false
+	}
+	return dest, true
+}
+
+// sendRemoteFile 從目的地下載檔案並以文件傳到聊天室
+// 下載在每次送出時重新開始，讓 Telegram 回應 429 後的重試仍然有完整的內容
+func sendRemoteFile(ctx context.Context, dest fetchDestination, userID, chatID int64, replyTo int, fileID string) {
+	var replyText string
+	err := callTelegram(ctx, chatID, func() error {
+		file, body, err := dest.Download(ctx, userID, fileID)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		if file.Size > maxSendFileSize {
+			replyText = fmt.Sprintf("「%s」有 %s，超過 Telegram 機器人 50 MB 的傳送限制，請直接到雲端下載。", file.Name, formatSize(file.Size))
+			return nil
+		}
+		doc := tgbotapi.NewDocument(chatID, tgbotapi.FileReader{Name: file.Name, Reader: body})
+		doc.ReplyToMessageID = replyTo
+		_, err = senderFor(ctx).Send(doc)
+		return err
+	})
+	switch {
+	case errors.Is(err, errNativeDriveFile):
+		replyText = "Google 文件、試算表與簡報無法直接傳送，請到 Google Drive 開啟。"
+	case errors.Is(err, errNotConnected):
+		replyText = fmt.Sprintf("您的 %s 帳號尚未連結，請使用 %s 指令來連結。", dest.DisplayName(), connectCommand(dest))
+	case err != nil:
+		slog.ErrorContext(ctx, "Failed to send file back to Telegram", "file_id", fileID, "error", err)
+		replyText = "取回檔案時發生錯誤，請稍後再試。"
+	default:
+		if replyText == "" {
+			slog.InfoContext(ctx, "Sent file back to Telegram", "file_id", fileID)
+		}
+	}
+	if replyText != "" {
+		replyToUser(ctx, chatID, replyTo, replyText)
+	}
+}
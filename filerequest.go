@@ -0,0 +1,397 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 檔案上傳連結 ---
+
+const (
+	// 尚未使用的上傳連結，文件 ID 是連結中的隨機值
+	fileRequestCollection = "file_requests"
+	// 上傳連結的有效時間
+	fileRequestTTL = 24 * time.Hour
+	// 透過上傳連結收到的檔案放在這個資料夾
+	fileRequestFolder = "File Requests"
+	// 說明文字的長度上限
+	fileRequestMaxNote = 200
+)
+
+// FileRequest 是 /request_file 產生的上傳連結，使用一次後刪除
+type FileRequest struct {
+	UserID   int64     `firestore:"user_id"`
+	Note     string    `firestore:"note"` // 顯示在上傳頁面的說明
+	ExpireAt time.Time `firestore:"expire_at"`
+}
+
+var errFileRequestUsed = errors.New("file request not found or already used")
+
+// 處理 /request_file 指令：產生一次性的網頁上傳連結，沒有 Telegram 的人也能把檔案傳到使用者的目的地
+// 參數是顯示在上傳頁面的說明，例如 /request_file 請上傳報名表
+func handleRequestFile(ctx context.Context, message *tgbotapi.Message) {
+	if !message.Chat.IsPrivate() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請在與機器人的私訊中使用 /request_file。")
+		return
+	}
+	userID := message.From.ID
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的設定時發生錯誤，請稍後再試。")
+		return
+	}
+	dest := userDestination(ctx, settings)
+	connected, err := dest.Connected(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to retrieve token", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的授權時發生錯誤，請稍後再試。")
+		return
+	}
+	if !connected {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("您的 %s 帳號尚未連結，請使用 %s 指令來連結。", dest.DisplayName(), connectCommand(dest)))
+		return
+	}
+
+	note := strings.TrimSpace(message.CommandArguments())
+	if runes := []rune(note); len(runes) > fileRequestMaxNote {
+		note = string(runes[:fileRequestMaxNote])
+	}
+	link, expires, err := createFileRequest(ctx, userID, note)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create file request", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "產生上傳連結時發生錯誤，請稍後再試。")
+		return
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf(
+		"請將以下連結傳給要給您檔案的人，對方不需要 Telegram 帳號就能上傳一個檔案（最大 %s），檔案會存到您的 %s 的「%s」資料夾：\n\n%s\n\n連結只能使用一次，%s 後失效。",
+		formatSize(configFor(ctx).maxFileSize()), dest.DisplayName(), fileRequestFolder, link, expires.Format("2006-01-02 15:04 MST")))
+}
+
+// createFileRequest 記錄一個上傳連結，回傳完整的網址與到期時間
+func createFileRequest(ctx context.Context, userID int64, note string) (string, time.Time, error) {
+	b := make([]byte, 16)
+	rand.Read(b)
+	nonce := hex.EncodeToString(b)
+	expires := time.Now().Add(fileRequestTTL)
+	err := collection(ctx, fileRequestCollection).Doc(nonce).Set(ctx, FileRequest{UserID: userID, Note: note, ExpireAt: expires})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	base, err := serviceURL(ctx)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return base + "/upload/" + newFileRequestToken(currentTenant(ctx), userID, nonce, expires), expires, nil
+}
+
+// serviceURL 由 Google OAuth 的回呼網址推得服務的網址，回呼網址一定指向這個服務
+func serviceURL(ctx context.Context) (string, error) {
+	u, err := url.Parse(googleOAuth(ctx).RedirectURL)
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("cannot derive service URL from redirect URL %q", googleOAuth(ctx).RedirectURL)
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+// fileRequestKey 由機器人權杖衍生簽署上傳連結的金鑰，更換權杖後尚未使用的連結都會失效
+func fileRequestKey(t *tenant) []byte {
+	key := sha256.Sum256([]byte("tg-helper file request:" + t.Bot.Token))
+	return key[:]
+}
+
+// newFileRequestToken 產生 "<機器人 ID>:<使用者 ID>:<到期時間>:<隨機值>" 並附上 HMAC 簽章
+func newFileRequestToken(t *tenant, userID int64, nonce string, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%d:%d:%s", t.ID, userID, expires.Unix(), nonce)))
+	mac := hmac.New(sha256.New, fileRequestKey(t))
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseFileRequestToken 驗證上傳連結的簽章與到期時間，回傳所屬的機器人、使用者與隨機值
+func parseFileRequestToken(token string, now time.Time) (*tenant, int64, string, bool) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, 0, "", false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, 0, "", false
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 4 {
+		return nil, 0, "", false
+	}
+	t, ok := tenants[parts[0]]
+	if !ok {
+		return nil, 0, "", false
+	}
+	mac := hmac.New(sha256.New, fileRequestKey(t))
+	mac.Write([]byte(payload))
+	if !hmac.Equal([]byte(base64.RawURLEncoding.EncodeToString(mac.Sum(nil))), []byte(signature)) {
+		return nil, 0, "", false
+	}
+	userID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, 0, "", false
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || now.After(time.Unix(expires, 0)) {
+		return nil, 0, "", false
+	}
+	return t, userID, parts[3], true
+}
+
+// fileRequestPage 是上傳頁面的內容
+type fileRequestPage struct {
+	Title   string
+	Message string
+	Note    string
+	MaxSize string
+	Form    bool
+}
+
+// 處理 GET /upload/<連結>：顯示上傳表單
+func fileRequestPageHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	t, userID, nonce, ok := parseFileRequestToken(r.PathValue("token"), time.Now())
+	if !ok {
+		renderFileRequestPage(ctx, w, http.StatusNotFound, fileRequestPage{Title: "連結無效", Message: "這個上傳連結無效或已經過期，請向對方索取新的連結。"})
+		return
+	}
+	ctx = withTenant(ctx, t)
+	doc, err := collection(ctx, fileRequestCollection).Doc(nonce).Get(ctx)
+	if errors.Is(err, errDocNotFound) {
+		renderFileRequestPage(ctx, w, http.StatusGone, fileRequestPage{Title: "連結已使用", Message: "這個上傳連結已經使用過了，請向對方索取新的連結。"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to read file request", "user_id", userID, "error", err)
+		renderFileRequestPage(ctx, w, http.StatusInternalServerError, fileRequestPage{Title: "發生錯誤", Message: "讀取上傳連結時發生錯誤，請稍後再試。"})
+		return
+	}
+	var req FileRequest
+	if err := doc.DataTo(&req); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode file request", "user_id", userID, "error", err)
+		renderFileRequestPage(ctx, w, http.StatusInternalServerError, fileRequestPage{Title: "發生錯誤", Message: "讀取上傳連結時發生錯誤，請稍後再試。"})
+		return
+	}
+	renderFileRequestPage(ctx, w, http.StatusOK, fileRequestPage{
+		Title:   "上傳檔案",
+		Message: "選擇一個檔案上傳，這個連結只能使用一次。",
+		Note:    req.Note,
+		MaxSize: formatSize(configFor(ctx).maxFileSize()),
+		Form:    true,
+	})
+}
+
+// 處理 POST /upload/<連結>：兌換連結後將檔案串流上傳到使用者的目的地，不會暫存整個檔案
+// 上傳失敗時會恢復連結，讓對方可以再試一次
+func fileRequestUploadHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := withLogAttrs(r.Context(), slog.String("request_id", newRequestID()))
+	t, userID, nonce, ok := parseFileRequestToken(r.PathValue("token"), time.Now())
+	if !ok {
+		renderFileRequestPage(ctx, w, http.StatusNotFound, fileRequestPage{Title: "連結無效", Message: "這個上傳連結無效或已經過期，請向對方索取新的連結。"})
+		return
+	}
+	ctx = withLogAttrs(withTenant(ctx, t), slog.Int64("user_id", userID))
+	fail := func(code int, message string) {
+		renderFileRequestPage(ctx, w, code, fileRequestPage{Title: "上傳失敗", Message: message})
+	}
+	if !isUserAllowed(ctx, userID) {
+		fail(http.StatusForbidden, "這個上傳連結已無法使用。")
+		return
+	}
+
+	maxSize := configFor(ctx).maxFileSize()
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+1<<20)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		fail(http.StatusBadRequest, "無法讀取上傳的檔案，請重新選擇檔案。")
+		return
+	}
+	var part io.ReadCloser
+	var fileName, mimeType string
+	for {
+		p, err := reader.NextPart()
+		if err != nil {
+			fail(http.StatusBadRequest, "沒有收到檔案，請重新選擇檔案。")
+			return
+		}
+		if p.FormName() == "file" && p.FileName() != "" {
+			part, fileName = p, p.FileName()
+			mimeType, _, _ = mime.ParseMediaType(p.Header.Get("Content-Type"))
+			break
+		}
+	}
+	defer part.Close()
+	name := strings.TrimSpace(sanitizeNameSegment(path.Base(fileName)))
+	// 「.」與「..」在 WebDAV 等以路徑表示檔案的目的地會指向上層資料夾，改用預設的檔名
+	if name == "" || name == "." || name == ".." {
+		name = "upload-" + time.Now().Format("20060102-150405")
+	}
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = cmp.Or(mimeTypeByExt(strings.ToLower(path.Ext(name))), "application/octet-stream")
+	}
+
+	req, err := consumeFileRequest(ctx, nonce)
+	if errors.Is(err, errFileRequestUsed) {
+		renderFileRequestPage(ctx, w, http.StatusGone, fileRequestPage{Title: "連結已使用", Message: "這個上傳連結已經使用過了，請向對方索取新的連結。"})
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to consume file request", "error", err)
+		fail(http.StatusInternalServerError, "讀取上傳連結時發生錯誤，請稍後再試。")
+		return
+	}
+	// 上傳沒有成功時恢復連結
+	uploaded := false
+	defer func() {
+		if uploaded {
+			return
+		}
+		if err := collection(ctx, fileRequestCollection).Doc(nonce).Set(context.WithoutCancel(ctx), req); err != nil {
+			slog.WarnContext(ctx, "Failed to restore file request", "error", err)
+		}
+	}()
+
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		fail(http.StatusInternalServerError, "上傳時發生錯誤，請稍後再試。")
+		return
+	}
+	dest := uploadDestination(ctx, settings, mimeType)
+	if connected, err := dest.Connected(ctx, userID); err != nil || !connected {
+		slog.WarnContext(ctx, "File request destination is not connected", "destination", dest.Name(), "error", err)
+		fail(http.StatusServiceUnavailable, "對方的儲存空間目前無法使用，請通知對方後再試。")
+		return
+	}
+	// 請求的大小包含表單的其他欄位，略大於檔案本身，足以在上傳前擋下超過當日額度的檔案；實際大小在上傳後記錄
+	if err := reserveUpload(ctx, userID, max(r.ContentLength, 0)); err != nil {
+		var limitErr *rateLimitError
+		if errors.As(err, &limitErr) {
+			fail(http.StatusTooManyRequests, "對方目前的上傳額度已用完，請稍後再試。")
+			return
+		}
+		slog.ErrorContext(ctx, "Failed to check rate limit", "error", err)
+		fail(http.StatusInternalServerError, "上傳時發生錯誤，請稍後再試。")
+		return
+	}
+
+	body := newLimitedReader(part, maxSize)
+	result, err := uploadTo(ctx, dest, userID, &UploadFile{
+		Name:     name,
+		Folders:  []string{fileRequestFolder},
+		Body:     body,
+		MimeType: mimeType,
+		Caption:  req.Note,
+	})
+	event := &UploadEvent{UserID: userID, Chat: "File request", Destination: dest.Name(), FileName: name, MimeType: mimeType, Size: body.BytesRead()}
+	if body.Exceeded() {
+		err = errFileTooLarge
+	}
+	if err != nil {
+		event.Event, event.Error = eventUploadFailed, err.Error()
+		emitUploadEvent(ctx, settings, event)
+		if errors.Is(err, errFileTooLarge) {
+			fail(http.StatusRequestEntityTooLarge, fmt.Sprintf("檔案超過 %s 的上限。", formatSize(maxSize)))
+			return
+		}
+		reportError(ctx, "Failed to upload requested file", err, "destination", dest.Name())
+		fail(http.StatusBadGateway, "上傳時發生錯誤，請稍後再試。")
+		return
+	}
+	uploaded = true
+	recordLinkUpload(ctx, settings, dest, result, event)
+	slog.InfoContext(ctx, "Received file through upload link", "file_name", result.Name, "size", event.Size)
+
+	text := fmt.Sprintf("有人透過上傳連結傳送了「%s」（%s），已存到您的 %s 的「%s」資料夾。", result.Name, formatSize(event.Size), dest.DisplayName(), fileRequestFolder)
+	if req.Note != "" {
+		text += "\n連結說明：" + req.Note
+	}
+	if result.Link != "" {
+		text += "\n" + result.Link
+	}
+	if _, err := sendMessage(ctx, tgbotapi.NewMessage(userID, dryRunReply(ctx, text))); err != nil {
+		slog.WarnContext(ctx, "Failed to notify file request upload", "error", err)
+	}
+	renderFileRequestPage(ctx, w, http.StatusOK, fileRequestPage{Title: "上傳完成", Message: fmt.Sprintf("已收到「%s」（%s），謝謝！", result.Name, formatSize(event.Size))})
+}
+
+// consumeFileRequest 在交易中讀取並刪除上傳連結，讓同一個連結只能使用一次
+func consumeFileRequest(ctx context.Context, nonce string) (*FileRequest, error) {
+	ref := collection(ctx, fileRequestCollection).Doc(nonce)
+	var req FileRequest
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		doc, err := tx.Get(ref)
+		if errors.Is(err, errDocNotFound) {
+			return errFileRequestUsed
+		}
+		if err != nil {
+			return err
+		}
+		if err := doc.DataTo(&req); err != nil {
+			return err
+		}
+		if time.Now().After(req.ExpireAt) {
+			return errFileRequestUsed
+		}
+		return tx.Delete(ref)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func renderFileRequestPage(ctx context.Context, w http.ResponseWriter, code int, page fileRequestPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := fileRequestTemplate.Execute(w, page); err != nil {
+		slog.WarnContext(ctx, "Failed to render file request page", "error", err)
+	}
+}
+
+var fileRequestTemplate = template.Must(template.New("upload").Parse(`<!DOCTYPE html>
+<html lang="zh-TW">
+<head>
+` + webPageStyle + `
+<title>{{.Title}}</title>
+</head>
+<body>
+<main>
+<h1>{{.Title}}</h1>
+{{if .Note}}<blockquote>{{.Note}}</blockquote>{{end}}
+<p>{{.Message}}</p>
+{{if .Form}}
+<form method="post" enctype="multipart/form-data">
+<p><input type="file" name="file" required></p>
+<p>檔案大小上限：{{.MaxSize}}</p>
+<p><input type="submit" value="上傳"></p>
+</form>
+{{end}}
+</main>
+</body>
+</html>
+`))
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestParseFileRequestToken(t *testing.T) {
+	bot := &tenant{ID: "123456", Bot: &tgbotapi.BotAPI{Token: testBotToken}}
+	other := &tenant{ID: "654321", Bot: &tgbotapi.BotAPI{Token: "654321:OTHER"}}
+	previous := tenants
+	tenants = map[string]*tenant{bot.ID: bot, other.ID: other}
+	t.Cleanup(func() { tenants = previous })
+
+	now := time.Unix(1700000000, 0)
+	expires := now.Add(time.Hour)
+	valid := newFileRequestToken(bot, 42, "nonce", expires)
+	payload, signature, _ := strings.Cut(valid, ".")
+	otherPayload, _, _ := strings.Cut(newFileRequestToken(other, 42, "nonce", expires), ".")
+
+	tests := []struct {
+		name  string
+		token string
+		now   time.Time
+		ok    bool
+	}{
+		{"valid", valid, now, true},
+		{"valid until expiry", valid, expires, true},
+		{"expired", valid, expires.Add(time.Second), false},
+		{"signed by another bot", otherPayload + "." + signature, now, false},
+		{"unknown bot", newFileRequestToken(&tenant{ID: "999", Bot: &tgbotapi.BotAPI{Token: "999:X"}}, 42, "nonce", expires), now, false},
+		{"missing signature", payload, now, false},
+		{"tampered signature", payload + "." + strings.Repeat("A", len(signature)), now, false},
+		{"not base64", "!!!." + signature, now, false},
+		{"empty", "", now, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, userID, nonce, ok := parseFileRequestToken(tt.token, tt.now)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if ok && (got != bot || userID != 42 || nonce != "nonce") {
+				t.Errorf("got bot %v, user %d, nonce %q", got.ID, userID, nonce)
+			}
+		})
+	}
+}
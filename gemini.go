@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"google.golang.org/api/aiplatform/v1"
+	"google.golang.org/api/option"
+)
+
+// --- Gemini ---
+
+var geminiService *aiplatform.Service
+
+const summarizePrompt = "請以繁體中文，用三到五個重點條列摘要這份文件的內容，每點一行，不要加上前言。"
+
+// initGemini 在 ENABLE_GEMINI 開啟時建立 Vertex AI 用戶端
+func initGemini(ctx context.Context, cfg AIConfig) error {
+	if !cfg.Gemini {
+		return nil
+	}
+	endpoint := fmt.Sprintf("https://%s-aiplatform.googleapis.com/", cfg.GeminiLocation)
+	svc, err := aiplatform.NewService(ctx, option.WithEndpoint(endpoint))
+	if err != nil {
+		return fmt.Errorf("failed to create vertex ai service: %v", err)
+	}
+	geminiService = svc
+	return nil
+}
+
+// geminiModel 回傳模型的完整資源名稱，例如 projects/<專案>/locations/us-central1/publishers/google/models/gemini-2.5-flash
+func geminiModel(ctx context.Context) string {
+	cfg := configFor(ctx)
+	return fmt.Sprintf("projects/%s/locations/%s/publishers/google/models/%s", cfg.GCPProjectID, cfg.AI.GeminiLocation, cfg.AI.GeminiModel)
+}
+
+// generateText 將提示與檔案內容交給 Gemini，回傳產生的文字
+func generateText(ctx context.Context, prompt string, parts ...*aiplatform.GoogleCloudAiplatformV1Part) (string, error) {
+	ctx, span := startSpan(ctx, "gemini.generate_content")
+	resp, err := geminiService.Projects.Locations.Publishers.Models.GenerateContent(geminiModel(ctx), &aiplatform.GoogleCloudAiplatformV1GenerateContentRequest{
+		Contents: []*aiplatform.GoogleCloudAiplatformV1Content{{
+			Role:  "user",
+			Parts: append(parts, &aiplatform.GoogleCloudAiplatformV1Part{Text: prompt}),
+		}},
+	}).Context(ctx).Do()
+	endSpan(span, err)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate content: %v", err)
+	}
+	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil {
+		return "", errors.New("gemini returned no candidates")
+	}
+	var sb strings.Builder
+	for _, part := range resp.Candidates[0].Content.Parts {
+		if !part.Thought {
+			sb.WriteString(part.Text)
+		}
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+// inlinePart 將檔案內容包成 Gemini 的 inline data
+func inlinePart(mimeType string, data []byte) *aiplatform.GoogleCloudAiplatformV1Part {
+	return &aiplatform.GoogleCloudAiplatformV1Part{
+		InlineData: &aiplatform.GoogleCloudAiplatformV1Blob{MimeType: mimeType, Data: base64.StdEncoding.EncodeToString(data)},
+	}
+}
+
+// summarizableMimeType 回傳 Gemini 可以直接讀取並摘要的文件類型
+func summarizableMimeType(mimeType string) bool {
+	return mimeType == "application/pdf" || strings.HasPrefix(mimeType, "text/")
+}
+
+// 處理 /summarize 指令：回覆已上傳的文件或上傳確認訊息，以 Gemini 產生摘要
+func handleSummarize(ctx context.Context, message *tgbotapi.Message) {
+	if geminiService == nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "此機器人尚未啟用 AI 摘要。")
+		return
+	}
+	if message.ReplyToMessage == nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請回覆您上傳的文件或機器人的上傳確認訊息，並輸入 /summarize。")
+		return
+	}
+
+	record, err := findUploadRecord(ctx, message.Chat.ID, message.ReplyToMessage.MessageID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to find upload record", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取上傳紀錄時發生錯誤，請稍後再試。")
+		return
+	}
+	if record == nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "找不到這則訊息的上傳紀錄，請回覆您上傳的檔案或機器人的上傳確認訊息。")
+		return
+	}
+	if record.UserID != message.From.ID {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "只有上傳這個檔案的人可以摘要它。")
+		return
+	}
+	// 在記錄 Telegram 檔案 ID 之前上傳的檔案無法重新下載
+	if record.TelegramFileID == "" || !summarizableMimeType(record.MimeType) {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "目前只能摘要 PDF 與純文字檔案。")
+		return
+	}
+
+	// Telegram 會保留檔案，從 Telegram 重新下載即可，不需要向目的地要求讀取權限
+	content, err := downloadTelegramFile(ctx, record.TelegramFileID)
+	if err != nil {
+		reportError(ctx, "Failed to download file for summary", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "無法下載檔案，請稍後再試。")
+		return
+	}
+	summary, err := generateText(ctx, summarizePrompt, inlinePart(record.MimeType, content))
+	if err != nil {
+		reportError(ctx, "Failed to summarize file", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "產生摘要時發生錯誤，請稍後再試。")
+		return
+	}
+	if summary == "" {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "無法從這個檔案產生摘要。")
+		return
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("「%s」的摘要：\n%s", record.Name, summary))
+}
+
+// downloadTelegramFile 下載 Telegram 上的檔案內容，超過 maxFileSize 時回傳錯誤
+func downloadTelegramFile(ctx context.Context, fileID string) ([]byte, error) {
+	resp, err := openTelegramFileByID(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+)
+
+// --- Gmail 附件 ---
+
+const (
+	// 從 Gmail 複製的附件上傳到的資料夾
+	gmailFolder = "Gmail Attachments"
+	// /gmail_attachments 最多列出幾封郵件
+	gmailListLimit = 10
+	// 一則訊息最多放幾個附件按鈕
+	gmailMaxButtons = 20
+)
+
+// gmailAttachment 是郵件中的一個附件；Gmail 的 attachmentId 太長放不進按鈕，按鈕改記錄 partId
+type gmailAttachment struct {
+	PartID   string
+	ID       string
+	Filename string
+	MimeType string
+	Size     int64
+}
+
+// gmailAttachments 遞迴找出郵件中所有有檔名的附件
+func gmailAttachments(part *gmail.MessagePart) []gmailAttachment {
+	if part == nil {
+		return nil
+	}
+	var out []gmailAttachment
+	if part.Filename != "" && part.Body != nil && part.Body.AttachmentId != "" {
+		out = append(out, gmailAttachment{PartID: part.PartId, ID: part.Body.AttachmentId, Filename: part.Filename, MimeType: part.MimeType, Size: part.Body.Size})
+	}
+	for _, p := range part.Parts {
+		out = append(out, gmailAttachments(p)...)
+	}
+	return out
+}
+
+// gmailHeader 回傳郵件標頭的值，找不到時回傳空字串
+func gmailHeader(part *gmail.MessagePart, name string) string {
+	if part == nil {
+		return ""
+	}
+	for _, h := range part.Headers {
+		if strings.EqualFold(h.Name, name) {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// 處理 /gmail_attachments 指令：列出最近有附件的郵件，點選附件後複製到上傳目的地
+// 參數會加在 Gmail 搜尋條件之後，例如 /gmail_attachments from:bank@example.com
+func handleGmailAttachments(ctx context.Context, message *tgbotapi.Message) {
+	if !message.Chat.IsPrivate() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請在與機器人的私訊中使用 /gmail_attachments。")
+		return
+	}
+	if !requireGoogleFeature(ctx, message, "gmail") {
+		return
+	}
+	service, err := newGmailService(ctx, message.From.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create gmail service", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的授權時發生錯誤，請稍後再試。")
+		return
+	}
+
+	q := strings.TrimSpace("has:attachment " + message.CommandArguments())
+	list, err := service.Users.Messages.List("me").Q(q).MaxResults(gmailListLimit).Context(ctx).Do()
+	if err != nil {
+		reportError(ctx, "Failed to list gmail messages", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取 Gmail 時發生錯誤，請稍後再試。")
+		return
+	}
+	if len(list.Messages) == 0 {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "找不到有附件的郵件。")
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString("最近有附件的郵件，點選附件即可複製到您的儲存空間：\n")
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, m := range list.Messages {
+		msg, err := service.Users.Messages.Get("me", m.Id).Format("full").Fields("id", "internalDate", "payload").Context(ctx).Do()
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to read gmail message", "message_id", m.Id, "error", err)
+			continue
+		}
+		date := time.UnixMilli(msg.InternalDate).UTC().Format(time.DateOnly)
+		fmt.Fprintf(&sb, "\n%d. %s\n   %s・%s\n", i+1, gmailSubject(msg.Payload), gmailHeader(msg.Payload, "From"), date)
+		for _, a := range gmailAttachments(msg.Payload) {
+			if len(rows) >= gmailMaxButtons {
+				break
+			}
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+				fmt.Sprintf("%d. %s（%s）", i+1, a.Filename, formatSize(a.Size)), encodeCallbackData("gmail", msg.Id, a.PartID))))
+		}
+	}
+	if len(rows) == 0 {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "這些郵件中沒有可以複製的附件。")
+		return
+	}
+	reply := tgbotapi.NewMessage(message.Chat.ID, sb.String())
+	reply.ReplyToMessageID = message.MessageID
+	reply.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	if _, err := sendMessage(ctx, reply); err != nil {
+		slog.ErrorContext(ctx, "Failed to send gmail attachment list", "error", err)
+	}
+}
+
+// gmailSubject 回傳郵件的主旨，沒有主旨時回傳「（無主旨）」
+func gmailSubject(part *gmail.MessagePart) string {
+	return cmp.Or(gmailHeader(part, "Subject"), "（無主旨）")
+}
+
+// handleGmailCallback 處理附件按鈕，在背景下載附件並上傳
+func handleGmailCallback(ctx context.Context, query *tgbotapi.CallbackQuery, args []string) {
+	if len(args) != 2 || query.Message == nil {
+		answerCallback(ctx, query, "")
+		return
+	}
+	userID := query.From.ID
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		answerCallback(ctx, query, "讀取您的設定時發生錯誤，請稍後再試。")
+		return
+	}
+	dest := userDestination(ctx, settings)
+	connected, err := dest.Connected(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to retrieve token", "error", err)
+		answerCallback(ctx, query, "讀取您的授權時發生錯誤，請稍後再試。")
+		return
+	}
+	if !connected {
+		answerCallback(ctx, query, fmt.Sprintf("您的 %s 帳號尚未連結，請使用 %s 指令來重新連結。", dest.DisplayName(), connectCommand(dest)))
+		return
+	}
+	if err := reserveUpload(ctx, userID, 0); err != nil {
+		var limitErr *rateLimitError
+		if errors.As(err, &limitErr) {
+			answerCallback(ctx, query, rateLimitMessage(ctx, limitErr))
+			return
+		}
+		slog.ErrorContext(ctx, "Failed to check rate limit", "error", err)
+		answerCallback(ctx, query, "檢查上傳額度時發生錯誤，請稍後再試。")
+		return
+	}
+	answerCallback(ctx, query, "正在複製附件…")
+
+	ctx = withLogAttrs(context.WithoutCancel(ctx), slog.String("gmail_message_id", args[0]))
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				reportPanic(ctx, r)
+			}
+		}()
+		copyGmailAttachment(ctx, settings, dest, userID, query.Message.Chat.ID, query.Message.MessageID, args[0], args[1])
+	}()
+}
+
+// copyGmailAttachment 下載郵件中的一個附件並上傳到 gmailFolder，郵件的主旨與寄件者寫入檔案描述
+func copyGmailAttachment(ctx context.Context, settings *UserSettings, dest Destination, userID, chatID int64, replyTo int, messageID, partID string) {
+	service, err := newGmailService(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create gmail service", "error", err)
+		replyToUser(ctx, chatID, replyTo, "讀取您的授權時發生錯誤，請稍後再試。")
+		return
+	}
+	msg, err := service.Users.Messages.Get("me", messageID).Format("full").Fields("id", "payload").Context(ctx).Do()
+	if err != nil {
+		reportError(ctx, "Failed to read gmail message", err)
+		replyToUser(ctx, chatID, replyTo, "讀取郵件時發生錯誤，郵件可能已被刪除。")
+		return
+	}
+	var att *gmailAttachment
+	for _, a := range gmailAttachments(msg.Payload) {
+		if a.PartID == partID {
+			att = &a
+			break
+		}
+	}
+	if att == nil {
+		replyToUser(ctx, chatID, replyTo, "找不到這個附件，郵件可能已被修改。")
+		return
+	}
+	if att.Size > configFor(ctx).maxFileSize() {
+		replyToUser(ctx, chatID, replyTo, fileTooLargeMessage(ctx, att.Size))
+		return
+	}
+	body, err := service.Users.Messages.Attachments.Get("me", messageID, att.ID).Context(ctx).Do()
+	if err != nil {
+		reportError(ctx, "Failed to download gmail attachment", err)
+		replyToUser(ctx, chatID, replyTo, "下載附件時發生錯誤，請稍後再試。")
+		return
+	}
+	content, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(body.Data, "="))
+	if err != nil {
+		reportError(ctx, "Failed to decode gmail attachment", err)
+		replyToUser(ctx, chatID, replyTo, "下載附件時發生錯誤，請稍後再試。")
+		return
+	}
+
+	mimeType := att.MimeType
+	if mimeType == "" || mimeType == "application/octet-stream" {
+		mimeType = cmp.Or(mimeTypeByExt(strings.ToLower(path.Ext(att.Filename))), mimeType)
+	}
+	name := strings.NewReplacer("/", "_", "\\", "_").Replace(att.Filename)
+	caption := fmt.Sprintf("%s\n%s", gmailSubject(msg.Payload), gmailHeader(msg.Payload, "From"))
+	result, err := uploadTo(ctx, dest, userID, &UploadFile{
+		Name:     name,
+		Folders:  []string{gmailFolder},
+		Body:     bytes.NewReader(content),
+		MimeType: mimeType,
+		Caption:  caption,
+	})
+	event := &UploadEvent{UserID: userID, ChatID: chatID, Destination: dest.Name(), FileName: name, MimeType: mimeType, Size: int64(len(content))}
+	if err != nil {
+		event.Event, event.Error = eventUploadFailed, err.Error()
+		emitUploadEvent(ctx, settings, event)
+		reportError(ctx, "Failed to upload gmail attachment", err, "destination", dest.Name())
+		replyToUser(ctx, chatID, replyTo, uploadFailedMessage(ctx, dest, userID, err, fmt.Sprintf("上傳到您的 %s 失敗。", dest.DisplayName())))
+		return
+	}
+	recordLinkUpload(ctx, settings, dest, result, event)
+	slog.InfoContext(ctx, "Copied gmail attachment", "file_name", result.Name, "size", event.Size)
+	text := fmt.Sprintf("已將附件複製到您的 %s 的「%s/%s」（%s）。", dest.DisplayName(), gmailFolder, result.Name, formatSize(event.Size))
+	if result.Link != "" {
+		text += "\n" + result.Link
+	}
+	replyToUser(ctx, chatID, replyTo, dryRunReply(ctx, text))
+}
+
+// newGmailService 以使用者目前的 Google 帳號建立 Gmail API 的 client
+func newGmailService(ctx context.Context, userID int64) (*gmail.Service, error) {
+	userToken, err := loadDriveToken(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	client := googleOAuth(ctx).Client(withTracedHTTPClient(ctx), userToken.Token())
+	service, err := gmail.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gmail service: %v", err)
+	}
+	return service, nil
+}
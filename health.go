@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// --- 健康檢查 ---
+
+const (
+	readinessTimeout = 5 * time.Second
+	// GetMe 的結果快取的時間，避免每次探測都呼叫 Telegram
+	telegramCheckTTL = time.Minute
+)
+
+// telegramCheckState 記錄一個機器人最近一次 GetMe 的結果；pending 不為 nil 時代表正在檢查，其他探測等待同一次結果
+type telegramCheckState struct {
+	sync.Mutex
+	checkedAt time.Time
+	err       error
+	pending   chan struct{}
+}
+
+// 每個機器人各自的檢查結果，依機器人 ID 索引
+var (
+	telegramChecksMu sync.Mutex
+	telegramChecks   = map[string]*telegramCheckState{}
+)
+
+// 處理 /healthz：只要程序還活著就回傳 200
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok"))
+}
+
+// 處理 /readyz：確認相依服務都可用，任一項失敗就回傳 503 讓平台停止導入流量
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	// 回應只列出各項檢查的狀態，錯誤內容可能包含內部位址等資訊，只寫入日誌
+	checks := map[string]string{}
+	ready := true
+	record := func(name string, err error) {
+		if err != nil {
+			ready = false
+			checks[name] = "error"
+			slog.WarnContext(ctx, "Readiness check failed", "check", name, "error", err)
+			return
+		}
+		checks[name] = "ok"
+	}
+
+	record("storage", checkStorage(ctx))
+	if redisClient != nil {
+		record("redis", redisClient.Ping(ctx).Err())
+	}
+	record("telegram", checkTelegram(ctx))
+	record("oauth", checkOAuthConfig())
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ready":  ready,
+		"checks": checks,
+	})
+}
+
+// checkStorage 讀取一份不存在的文件來確認儲存後端可以連線；errDocNotFound 代表連線正常
+func checkStorage(ctx context.Context) error {
+	if store == nil {
+		return errors.New("storage not initialized")
+	}
+	_, err := collection(ctx, stateCollection).Doc("readiness-probe").Get(ctx)
+	if err != nil && !errors.Is(err, errDocNotFound) {
+		return err
+	}
+	return nil
+}
+
+// checkTelegram 同時確認每個機器人的 Bot Token 仍然有效，任一個失效就回傳錯誤
+func checkTelegram(ctx context.Context) error {
+	if len(tenants) == 0 {
+		return errors.New("bot not initialized")
+	}
+	ids := slices.Sorted(maps.Keys(tenants))
+	errs := make([]error, len(ids))
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := checkBot(ctx, tenants[id]); err != nil {
+				errs[i] = fmt.Errorf("bot %s: %w", id, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// checkBot 呼叫 GetMe 確認機器人的 Bot Token 仍然有效，結果依機器人快取 telegramCheckTTL
+// GetMe 不接受 context，因此在背景呼叫，ctx 到期時不再等待
+func checkBot(ctx context.Context, t *tenant) error {
+	telegramChecksMu.Lock()
+	check, ok := telegramChecks[t.ID]
+	if !ok {
+		check = &telegramCheckState{}
+		telegramChecks[t.ID] = check
+	}
+	telegramChecksMu.Unlock()
+
+	check.Lock()
+	if !check.checkedAt.IsZero() && time.Since(check.checkedAt) < telegramCheckTTL {
+		err := check.err
+		check.Unlock()
+		return err
+	}
+	pending := check.pending
+	if pending == nil {
+		pending = make(chan struct{})
+		check.pending = pending
+		go func() {
+			_, err := t.Bot.GetMe()
+			check.Lock()
+			check.checkedAt, check.err, check.pending = time.Now(), err, nil
+			check.Unlock()
+			close(pending)
+		}()
+	}
+	check.Unlock()
+
+	select {
+	case <-pending:
+		check.Lock()
+		defer check.Unlock()
+		return check.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func checkOAuthConfig() error {
+	if defaultTenant == nil || defaultTenant.OAuth.ClientID == "" {
+		return errors.New("oauth2 config not loaded")
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// useTelegramChecks 清除快取的 GetMe 結果，測試結束時恢復
+func useTelegramChecks(t *testing.T) {
+	previous := telegramChecks
+	telegramChecks = map[string]*telegramCheckState{}
+	t.Cleanup(func() { telegramChecks = previous })
+}
+
+func TestCheckTelegram(t *testing.T) {
+	env := newTestEnv(t)
+	useTelegramChecks(t)
+	if err := checkTelegram(t.Context()); err != nil {
+		t.Fatalf("checkTelegram = %v, want nil", err)
+	}
+
+	// 其他機器人的權杖被撤銷時也視為未就緒；假伺服器只接受 testBotToken
+	revoked := &tgbotapi.BotAPI{Token: "654321:REVOKED", Client: http.DefaultClient}
+	revoked.SetAPIEndpoint(env.telegram.URL + "/bot%s/%s")
+	tenants["654321"] = &tenant{ID: "654321", Bot: revoked}
+
+	err := checkTelegram(t.Context())
+	if err == nil || !strings.Contains(err.Error(), "bot 654321") {
+		t.Fatalf("checkTelegram = %v, want an error for bot 654321", err)
+	}
+	w := httptest.NewRecorder()
+	readyzHandler(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("readyz status = %d, want %d", w.Code, http.StatusServiceUnavailable)
+	}
+}
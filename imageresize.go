@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"golang.org/x/image/draw"
+)
+
+// --- 圖片縮小 ---
+
+const (
+	defaultImageMaxDimension = 2048
+	defaultImageQuality      = 85
+	// 讀取圖片尺寸與 EXIF 時預先讀取的大小，JPEG 的 EXIF 區段最大 64 KB
+	imageHeaderPeekSize = 128 * 1024
+)
+
+// resizableMimeType 回傳可以縮小的圖片格式
+func resizableMimeType(mimeType string) bool {
+	return mimeType == "image/jpeg" || mimeType == "image/png"
+}
+
+// resizeImage 在圖片的長邊超過 maxDimension 時回傳縮小後重新編碼的串流，否則回傳原本的內容
+// 只預先讀取檔頭判斷尺寸與 EXIF 方向，解碼與編碼在背景進行並直接寫給上傳端
+func resizeImage(ctx context.Context, r io.Reader, mimeType string, maxDimension, quality int) io.Reader {
+	br := bufio.NewReaderSize(r, imageHeaderPeekSize)
+	header, _ := br.Peek(imageHeaderPeekSize)
+	config, _, err := image.DecodeConfig(bytes.NewReader(header))
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to read image size, uploading original", "error", err)
+		return br
+	}
+	if config.Width <= maxDimension && config.Height <= maxDimension {
+		return br
+	}
+	orientation := 1
+	if mimeType == "image/jpeg" {
+		orientation = exifOrientation(header)
+	}
+
+	width, height := config.Width, config.Height
+	if width >= height {
+		width, height = maxDimension, height*maxDimension/width
+	} else {
+		width, height = width*maxDimension/height, maxDimension
+	}
+	slog.InfoContext(ctx, "Resizing image", "from", fmt.Sprintf("%dx%d", config.Width, config.Height), "to", fmt.Sprintf("%dx%d", width, height), "orientation", orientation)
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				reportPanic(ctx, r)
+				pw.CloseWithError(fmt.Errorf("image resize panicked: %v", r))
+			}
+		}()
+		src, _, err := image.Decode(br)
+		if err != nil {
+			pw.CloseWithError(fmt.Errorf("failed to decode image: %v", err))
+			return
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, max(width, 1), max(height, 1)))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+		// 重新編碼時不會保留 EXIF，因此直接轉正像素
+		out := applyOrientation(dst, orientation)
+
+		if mimeType == "image/png" {
+			err = png.Encode(pw, out)
+		} else {
+			err = jpeg.Encode(pw, out, &jpeg.Options{Quality: quality})
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// exifOrientation 從 JPEG 的 APP1 區段讀取 EXIF 的方向標籤（0x0112），沒有時回傳 1
+func exifOrientation(data []byte) int {
+	if tiff := jpegExif(data); tiff != nil {
+		return tiffOrientation(tiff)
+	}
+	return 1
+}
+
+// tiffOrientation 在 EXIF 的 TIFF 結構中尋找 IFD0 的方向標籤
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int(order.Uint32(tiff[4:]))
+	if offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if v := int(order.Uint16(tiff[entry+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// applyOrientation 依 EXIF 方向旋轉或翻轉圖片，讓像素本身就是正確的方向
+func applyOrientation(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
+
+// 處理 /resize 指令：設定上傳前是否縮小大圖
+func handleResize(ctx context.Context, message *tgbotapi.Message) {
+	userID := message.From.ID
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 {
+		settings, err := loadUserSettings(ctx, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load user settings", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取設定時發生錯誤，請稍後再試。")
+			return
+		}
+		state := "關閉"
+		if settings.ImageMaxDimension > 0 {
+			state = fmt.Sprintf("開啟（長邊最多 %d px，JPEG 品質 %d）", settings.ImageMaxDimension, imageQuality(settings))
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "縮小大圖目前為"+state+"。\n用法：/resize <長邊像素> [JPEG 品質 1-100]，或 /resize off")
+		return
+	}
+
+	fields := map[string]interface{}{}
+	if on, ok := parseOnOff(args[0]); ok {
+		fields["image_max_dimension"] = 0
+		if on {
+			fields["image_max_dimension"] = defaultImageMaxDimension
+			fields["image_quality"] = defaultImageQuality
+		}
+	} else {
+		dimension, err := strconv.Atoi(args[0])
+		if err != nil || dimension < 256 || dimension > 8192 {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "長邊像素需介於 256 到 8192 之間。")
+			return
+		}
+		quality := defaultImageQuality
+		if len(args) > 1 {
+			quality, err = strconv.Atoi(args[1])
+			if err != nil || quality < 1 || quality > 100 {
+				replyToUser(ctx, message.Chat.ID, message.MessageID, "JPEG 品質需介於 1 到 100 之間。")
+				return
+			}
+		}
+		fields["image_max_dimension"] = dimension
+		fields["image_quality"] = quality
+	}
+
+	if err := updateUserSettings(ctx, userID, fields); err != nil {
+		slog.ErrorContext(ctx, "Failed to update settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "更新設定時發生錯誤，請稍後再試。")
+		return
+	}
+	if fields["image_max_dimension"] == 0 {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "已關閉縮小大圖，圖片會以原始尺寸上傳。")
+		return
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("已開啟縮小大圖：長邊超過 %d px 的 JPEG 與 PNG 圖片會在上傳前縮小（JPEG 品質 %d）。", fields["image_max_dimension"], fields["image_quality"]))
+}
+
+// imageQuality 回傳使用者設定的 JPEG 品質，未設定時使用預設值
+func imageQuality(settings *UserSettings) int {
+	if settings.ImageQuality <= 0 || settings.ImageQuality > 100 {
+		return defaultImageQuality
+	}
+	return settings.ImageQuality
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 匯入聊天紀錄 ---
+
+// Firestore 集合名稱
+const importSessionCollection = "import_sessions"
+
+const (
+	// 匯入模式在最後一次收到檔案後多久失效
+	importSessionTTL = 2 * time.Hour
+	// 每處理幾個檔案更新一次進度訊息，避免一次轉傳大量檔案時不斷編輯同一則訊息
+	importProgressEvery = 5
+)
+
+// 使用者的檔名範本沒有使用日期時，匯入的檔案改用這個範本，讓檔名保留原始的日期
+const importFilenameTemplate = "{date}_{time}_{name}"
+
+// ImportSession 記錄使用者在 /import 模式中轉傳的檔案數量，進度訊息會隨著處理更新
+type ImportSession struct {
+	Processed       int       `firestore:"processed"` // 已處理的檔案數，包含失敗與略過的檔案
+	Uploaded        int       `firestore:"uploaded"`
+	Skipped         int       `firestore:"skipped"` // 之前已經上傳過而略過的檔案
+	Bytes           int64     `firestore:"bytes"`
+	StatusMessageID int       `firestore:"status_message_id"` // 顯示進度的訊息
+	StartedAt       time.Time `firestore:"started_at"`
+	ExpireAt        time.Time `firestore:"expire_at"`
+}
+
+// Failed 回傳上傳失敗的檔案數
+func (s *ImportSession) Failed() int {
+	return max(s.Processed-s.Uploaded-s.Skipped, 0)
+}
+
+func importSessionRef(ctx context.Context, userID int64) *DocRef {
+	return collection(ctx, importSessionCollection).Doc(fmt.Sprintf("%d", userID))
+}
+
+// loadImportSession 讀取使用者進行中的匯入模式，沒有或已過期時回傳 nil
+func loadImportSession(ctx context.Context, userID int64) (*ImportSession, error) {
+	doc, err := importSessionRef(ctx, userID).Get(ctx)
+	if err != nil {
+		if errors.Is(err, errDocNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var session ImportSession
+	if err := doc.DataTo(&session); err != nil {
+		return nil, err
+	}
+	if time.Now().After(session.ExpireAt) {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+// importRun 是匯入模式中一個檔案的處理結果，由 handleFile 填入
+type importRun struct {
+	mu       sync.Mutex
+	uploaded bool
+	skipped  bool
+	size     int64
+}
+
+type importRunKey struct{}
+
+// withImportRun 標記這次上傳來自匯入模式：使用原始的訊息日期、略過重複的檔案、不逐一回覆成功訊息
+func withImportRun(ctx context.Context, run *importRun) context.Context {
+	return context.WithValue(ctx, importRunKey{}, run)
+}
+
+func importRunFromContext(ctx context.Context) *importRun {
+	run, _ := ctx.Value(importRunKey{}).(*importRun)
+	return run
+}
+
+// importing 回報這次上傳是否來自匯入模式
+func importing(ctx context.Context) bool {
+	return importRunFromContext(ctx) != nil
+}
+
+// markImported 記錄匯入的檔案已經上傳，不在匯入模式時不做任何事
+func markImported(ctx context.Context, size int64) {
+	if run := importRunFromContext(ctx); run != nil {
+		run.mu.Lock()
+		run.uploaded, run.size = true, size
+		run.mu.Unlock()
+	}
+}
+
+// markImportSkipped 記錄匯入的檔案之前已經上傳過
+func markImportSkipped(ctx context.Context) {
+	if run := importRunFromContext(ctx); run != nil {
+		run.mu.Lock()
+		run.skipped = true
+		run.mu.Unlock()
+	}
+}
+
+// originalDate 回傳訊息原本的時間：轉傳的訊息使用原始訊息的時間
+func originalDate(message *tgbotapi.Message) time.Time {
+	if message.ForwardDate != 0 {
+		return time.Unix(int64(message.ForwardDate), 0)
+	}
+	return time.Unix(int64(message.Date), 0)
+}
+
+// importProfile 調整匯入時的處理方式：不逐一回覆，檔名範本沒有日期時加上原始日期
+func importProfile(profile ProcessingProfile) ProcessingProfile {
+	profile.Silent = true
+	tmpl := profile.FilenameTemplate
+	if !strings.Contains(tmpl, "{date}") && !strings.Contains(tmpl, "{year}") {
+		profile.FilenameTemplate = importFilenameTemplate
+	}
+	return profile
+}
+
+// 處理 /import 指令：/import 開始匯入或查看進度，/import done 結束並顯示結果
+func handleImport(ctx context.Context, message *tgbotapi.Message) {
+	if !message.Chat.IsPrivate() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請在與機器人的私訊中使用 /import。")
+		return
+	}
+	userID := message.From.ID
+	session, err := loadImportSession(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load import session", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "發生錯誤，請稍後再試。")
+		return
+	}
+
+	switch action := strings.ToLower(strings.TrimSpace(message.CommandArguments())); action {
+	case "":
+		if session != nil {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, importProgressText(session)+"\n\n繼續轉傳檔案，或輸入 /import done 結束匯入。")
+			return
+		}
+		startImport(ctx, message)
+	case "done", "cancel":
+		if session == nil {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "目前沒有進行中的匯入。")
+			return
+		}
+		if err := importSessionRef(ctx, userID).Delete(ctx); err != nil {
+			slog.ErrorContext(ctx, "Failed to delete import session", "error", err)
+		}
+		slog.InfoContext(ctx, "Import finished", "processed", session.Processed, "uploaded", session.Uploaded, "skipped", session.Skipped)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "匯入已結束。\n"+importProgressText(session))
+	default:
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "用法：/import 開始匯入或查看進度、/import done 結束匯入。")
+	}
+}
+
+func startImport(ctx context.Context, message *tgbotapi.Message) {
+	now := time.Now()
+	session := &ImportSession{StartedAt: now, ExpireAt: now.Add(importSessionTTL)}
+	msg := tgbotapi.NewMessage(message.Chat.ID, "已開始匯入模式。請將要封存的舊訊息（可以一次選取多則）轉傳給我，檔案會依原始訊息的日期命名並上傳，重複的檔案會自動略過。\n\n"+
+		"中途中斷時，只要重新轉傳同一批訊息，已經上傳的檔案會被略過。全部轉傳完畢後輸入 /import done 結束。\n\n"+importProgressText(session))
+	msg.ReplyToMessageID = message.MessageID
+	sent, err := sendMessage(ctx, msg)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to send import status", "error", err)
+		return
+	}
+	session.StatusMessageID = sent.MessageID
+	if err := importSessionRef(ctx, message.From.ID).Set(ctx, session); err != nil {
+		slog.ErrorContext(ctx, "Failed to save import session", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "發生錯誤，請稍後再試。")
+	}
+}
+
+// importProgressText 產生匯入進度
+func importProgressText(s *ImportSession) string {
+	text := fmt.Sprintf("匯入進度：已處理 %d 個檔案，上傳 %d 個（%s）", s.Processed, s.Uploaded, formatSize(s.Bytes))
+	if s.Skipped > 0 {
+		text += fmt.Sprintf("，略過重複的 %d 個", s.Skipped)
+	}
+	if failed := s.Failed(); failed > 0 {
+		text += fmt.Sprintf("，失敗 %d 個", failed)
+	}
+	return text + "。"
+}
+
+// handleImportFile 在匯入模式中上傳檔案並更新進度，不在匯入模式時回傳 false
+func handleImportFile(ctx context.Context, message *tgbotapi.Message) bool {
+	if !message.Chat.IsPrivate() || message.From == nil {
+		return false
+	}
+	session, err := loadImportSession(ctx, message.From.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load import session", "error", err)
+		return false
+	}
+	if session == nil {
+		return false
+	}
+
+	run := &importRun{}
+	handleFile(withUploadConfirmed(withImportRun(ctx, run)), message)
+	recordImportResult(ctx, message, run)
+	return true
+}
+
+// recordImportResult 將一個檔案的結果累加到匯入進度，並定期更新進度訊息
+func recordImportResult(ctx context.Context, message *tgbotapi.Message, run *importRun) {
+	run.mu.Lock()
+	updates := []Update{
+		{Path: "processed", Value: increment(1)},
+		{Path: "expire_at", Value: time.Now().Add(importSessionTTL)},
+	}
+	if run.uploaded {
+		updates = append(updates, Update{Path: "uploaded", Value: increment(1)}, Update{Path: "bytes", Value: increment(run.size)})
+	}
+	if run.skipped {
+		updates = append(updates, Update{Path: "skipped", Value: increment(1)})
+	}
+	run.mu.Unlock()
+
+	ref := importSessionRef(ctx, message.From.ID)
+	if err := ref.Update(ctx, updates); err != nil {
+		// 匯入已經結束時文件不存在
+		if !errors.Is(err, errDocNotFound) {
+			slog.ErrorContext(ctx, "Failed to update import progress", "error", err)
+		}
+		return
+	}
+	session, err := loadImportSession(ctx, message.From.ID)
+	if err != nil || session == nil || session.StatusMessageID == 0 || session.Processed%importProgressEvery != 0 {
+		return
+	}
+	edit := tgbotapi.NewEditMessageText(message.Chat.ID, session.StatusMessageID, importProgressText(session)+"\n\n全部轉傳完畢後輸入 /import done 結束。")
+	if _, err := sendChattable(ctx, message.Chat.ID, edit); err != nil {
+		slog.WarnContext(ctx, "Failed to update import status", "error", err)
+	}
+}
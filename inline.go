@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- Inline 模式 ---
+
+const (
+	// inline 查詢最多回傳的結果數
+	maxInlineResults = 20
+	// Telegram 快取 inline 結果的秒數；結果因人而異，只快取在同一位使用者
+	inlineCacheSeconds = 10
+)
+
+// handleInlineQuery 處理 @機器人 <檔名>：搜尋使用者透過機器人上傳到 Google Drive 的檔案，
+// 選擇結果後會在目前的聊天室送出檔名與 Drive 連結，不需要切換到與機器人的私訊
+// 連結沿用檔案原本的共用設定，收到連結的人需要另外取得權限，可以先以 /share 建立公開連結
+func handleInlineQuery(ctx context.Context, query *tgbotapi.InlineQuery) {
+	answer := tgbotapi.InlineConfig{InlineQueryID: query.ID, CacheTime: inlineCacheSeconds, IsPersonal: true}
+
+	dest, ok := destinations[driveDestination{}.Name()].(fetchDestination)
+	if !ok {
+		answerInlineQuery(ctx, answer)
+		return
+	}
+	files, err := dest.Search(ctx, query.From.ID, strings.TrimSpace(query.Query), maxInlineResults)
+	if errors.Is(err, errNotConnected) {
+		answer.SwitchPMText = fmt.Sprintf("請先連結 %s", dest.DisplayName())
+		answer.SwitchPMParameter = "inline"
+		answerInlineQuery(ctx, answer)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to search files for inline query", "error", err)
+		answerInlineQuery(ctx, answer)
+		return
+	}
+
+	for _, f := range files {
+		if f.Link == "" {
+			continue
+		}
+		article := tgbotapi.NewInlineQueryResultArticle(f.ID, f.Name, f.Name+"\n"+f.Link)
+		article.URL = f.Link
+		article.HideURL = true
+		if f.Size > 0 {
+			article.Description = formatSize(f.Size)
+		}
+		answer.Results = append(answer.Results, article)
+	}
+	answerInlineQuery(ctx, answer)
+}
+
+// answerInlineQuery 回應 inline 查詢；沒有結果時也要回應，否則使用者端會一直顯示載入中
+func answerInlineQuery(ctx context.Context, answer tgbotapi.InlineConfig) {
+	if answer.Results == nil {
+		answer.Results = []interface{}{}
+	}
+	if _, err := senderFor(ctx).Request(answer); err != nil {
+		slog.WarnContext(ctx, "Failed to answer inline query", "error", err)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// --- 上傳紀錄試算表 ---
+
+// 自動建立的試算表名稱
+const ledgerTitle = "Telegram 上傳紀錄"
+
+// 試算表的標題列，每次上傳成功後在最後加上一列
+var ledgerHeader = []interface{}{"timestamp", "file_name", "size", "link", "chat"}
+
+var (
+	spreadsheetURLPattern = regexp.MustCompile(`/spreadsheets/d/([a-zA-Z0-9_-]+)`)
+	spreadsheetIDPattern  = regexp.MustCompile(`^[a-zA-Z0-9_-]{20,}$`)
+)
+
+// 處理 /ledger 指令：每次上傳成功後在 Google 試算表加上一列紀錄
+// /ledger on 自動建立試算表，/ledger <試算表網址> 使用既有的試算表，/ledger off 停止記錄
+func handleLedger(ctx context.Context, message *tgbotapi.Message) {
+	if !message.Chat.IsPrivate() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請在與機器人的私訊中使用 /ledger。")
+		return
+	}
+	userID := message.From.ID
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的設定時發生錯誤，請稍後再試。")
+		return
+	}
+
+	arg := strings.TrimSpace(message.CommandArguments())
+	switch strings.ToLower(arg) {
+	case "":
+		text := "上傳紀錄試算表目前為關閉。"
+		if settings.LedgerSpreadsheetID != "" {
+			text = "每次上傳後會在以下試算表加上一列紀錄：\n" + spreadsheetLink(settings.LedgerSpreadsheetID)
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, text+"\n\n用法：/ledger on 自動建立試算表、/ledger <試算表網址> 使用既有的試算表、/ledger off 停止記錄")
+		return
+	case "off":
+		if err := updateUserSettings(ctx, userID, map[string]interface{}{"ledger_spreadsheet_id": ""}); err != nil {
+			slog.ErrorContext(ctx, "Failed to save settings", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "儲存設定時發生錯誤，請稍後再試。")
+			return
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "已停止記錄，試算表本身不會被刪除。")
+		return
+	}
+
+	var spreadsheetID string
+	if strings.ToLower(arg) == "on" {
+		// 機器人自己建立的試算表只需要 drive.file 權限
+		if !requireGoogleFeature(ctx, message, featureUpload) {
+			return
+		}
+		spreadsheetID, err = createLedger(ctx, userID)
+		if err != nil {
+			reportError(ctx, "Failed to create ledger spreadsheet", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "建立試算表時發生錯誤，請稍後再試。")
+			return
+		}
+	} else {
+		spreadsheetID = parseSpreadsheetID(arg)
+		if spreadsheetID == "" {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "無法辨識試算表的網址，請貼上 https://docs.google.com/spreadsheets/d/... 格式的網址。")
+			return
+		}
+		// 寫入使用者自己建立的試算表需要額外的權限
+		if !requireGoogleFeature(ctx, message, "ledger") {
+			return
+		}
+		if err := prepareLedger(ctx, userID, spreadsheetID); err != nil {
+			slog.WarnContext(ctx, "Failed to prepare ledger spreadsheet", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "無法寫入這個試算表，請確認網址正確，且目前的 Google 帳號有編輯權限。")
+			return
+		}
+	}
+	if err := updateUserSettings(ctx, userID, map[string]interface{}{"ledger_spreadsheet_id": spreadsheetID}); err != nil {
+		slog.ErrorContext(ctx, "Failed to save settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "儲存設定時發生錯誤，請稍後再試。")
+		return
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, "之後每次上傳成功都會在試算表加上一列（時間、檔名、大小、連結、聊天室）：\n"+spreadsheetLink(spreadsheetID))
+}
+
+// parseSpreadsheetID 從試算表網址或 ID 取出試算表 ID，無法辨識時回傳空字串
+func parseSpreadsheetID(s string) string {
+	if m := spreadsheetURLPattern.FindStringSubmatch(s); m != nil {
+		return m[1]
+	}
+	if spreadsheetIDPattern.MatchString(s) {
+		return s
+	}
+	return ""
+}
+
+func spreadsheetLink(id string) string {
+	return "https://docs.google.com/spreadsheets/d/" + id + "/edit"
+}
+
+// recordLedger 在背景將上傳成功的事件寫入使用者的試算表；試算表被刪除時自動建立新的
+func recordLedger(ctx context.Context, settings *UserSettings, event *UploadEvent) {
+	if settings.LedgerSpreadsheetID == "" || event.Event != eventUploadCompleted || dryRun(ctx) {
+		return
+	}
+	chat := event.Chat
+	if chat == "" {
+		chat = fmt.Sprintf("%d", event.ChatID)
+	}
+	row := []interface{}{event.Timestamp.Format(time.RFC3339), event.FileName, event.Size, event.Link, chat}
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				reportPanic(ctx, r)
+			}
+		}()
+		err := appendLedgerValues(ctx, event.UserID, settings.LedgerSpreadsheetID, row)
+		var apiErr *googleapi.Error
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+			slog.InfoContext(ctx, "Ledger spreadsheet is missing, creating a new one", "spreadsheet_id", settings.LedgerSpreadsheetID)
+			var id string
+			if id, err = createLedger(ctx, event.UserID); err == nil {
+				if err = updateUserSettings(ctx, event.UserID, map[string]interface{}{"ledger_spreadsheet_id": id}); err == nil {
+					err = appendLedgerValues(ctx, event.UserID, id, row)
+				}
+			}
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to append ledger row", "error", err)
+		}
+	}()
+}
+
+// createLedger 在使用者的 Google Drive 建立只有標題列的試算表，回傳試算表 ID
+func createLedger(ctx context.Context, userID int64) (string, error) {
+	service, err := newSheetsService(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	created, err := service.Spreadsheets.Create(&sheets.Spreadsheet{
+		Properties: &sheets.SpreadsheetProperties{Title: ledgerTitle},
+		Sheets: []*sheets.Sheet{{
+			Properties: &sheets.SheetProperties{GridProperties: &sheets.GridProperties{FrozenRowCount: 1}},
+			Data:       []*sheets.GridData{{RowData: []*sheets.RowData{{Values: ledgerHeaderCells()}}}},
+		}},
+	}).Fields("spreadsheetId").Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	slog.InfoContext(ctx, "Created ledger spreadsheet", "spreadsheet_id", created.SpreadsheetId)
+	return created.SpreadsheetId, nil
+}
+
+func ledgerHeaderCells() []*sheets.CellData {
+	cells := make([]*sheets.CellData, len(ledgerHeader))
+	for i, v := range ledgerHeader {
+		s := v.(string)
+		cells[i] = &sheets.CellData{UserEnteredValue: &sheets.ExtendedValue{StringValue: &s}}
+	}
+	return cells
+}
+
+// prepareLedger 確認可以存取使用者指定的試算表，第一列是空的時寫入標題列
+func prepareLedger(ctx context.Context, userID int64, spreadsheetID string) error {
+	service, err := newSheetsService(ctx, userID)
+	if err != nil {
+		return err
+	}
+	first, err := service.Spreadsheets.Values.Get(spreadsheetID, "A1:E1").Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+	if len(first.Values) > 0 {
+		return nil
+	}
+	return appendLedgerValues(ctx, userID, spreadsheetID, ledgerHeader)
+}
+
+// appendLedgerValues 在試算表第一個工作表的最後加上一列；以 RAW 寫入，檔名不會被當成公式
+func appendLedgerValues(ctx context.Context, userID int64, spreadsheetID string, row []interface{}) error {
+	service, err := newSheetsService(ctx, userID)
+	if err != nil {
+		return err
+	}
+	_, err = service.Spreadsheets.Values.Append(spreadsheetID, "A:E", &sheets.ValueRange{Values: [][]interface{}{row}}).
+		ValueInputOption("RAW").InsertDataOption("INSERT_ROWS").Context(ctx).Do()
+	return err
+}
+
+// newSheetsService 以使用者目前的 Google 帳號建立 Sheets API 的 client
+func newSheetsService(ctx context.Context, userID int64) (*sheets.Service, error) {
+	userToken, err := loadDriveToken(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	client := googleOAuth(ctx).Client(withTracedHTTPClient(ctx), userToken.Token())
+	service, err := sheets.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sheets service: %v", err)
+	}
+	return service, nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// --- LINE Messaging API ---
+
+const (
+	lineAPIURL     = "https://api.line.me/v2/bot"
+	lineDataAPIURL = "https://api-data.line.me/v2/bot"
+	// LINE 文字訊息的長度上限
+	lineMaxTextLength = 5000
+	// reply token 大約一分鐘後失效，超過時改用主動推送
+	lineReplyTokenTTL = 50 * time.Second
+)
+
+// line 是設定了 LINE 頻道時的轉接器；未設定時為 nil，/line/webhook 回傳 404
+var line *linePlatform
+
+var lineClient = &http.Client{
+	Timeout:   30 * time.Second,
+	Transport: otelhttp.NewTransport(http.DefaultTransport),
+}
+
+// lineContentClient 下載使用者傳送的檔案，大檔案需要較長的時間，由 context 控制逾時
+var lineContentClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+type linePlatform struct {
+	channelSecret string
+	accessToken   string
+}
+
+func initLine(cfg LineConfig) {
+	if cfg.ChannelSecret == "" {
+		return
+	}
+	line = &linePlatform{channelSecret: cfg.ChannelSecret, accessToken: cfg.ChannelAccessToken}
+}
+
+func (*linePlatform) Name() string        { return "line" }
+func (*linePlatform) DisplayName() string { return "LINE" }
+
+// lineWebhook 是 LINE 送到 webhook 的內容，只解析需要的欄位
+type lineWebhook struct {
+	Events []lineEvent `json:"events"`
+}
+
+type lineEvent struct {
+	Type       string `json:"type"`
+	Timestamp  int64  `json:"timestamp"`
+	ReplyToken string `json:"replyToken"`
+	Source     struct {
+		Type   string `json:"type"`
+		UserID string `json:"userId"`
+	} `json:"source"`
+	Message *struct {
+		ID              string `json:"id"`
+		Type            string `json:"type"`
+		Text            string `json:"text"`
+		FileName        string `json:"fileName"`
+		FileSize        int64  `json:"fileSize"`
+		ContentProvider struct {
+			Type string `json:"type"`
+		} `json:"contentProvider"`
+	} `json:"message"`
+}
+
+// 處理 /line/webhook：驗證簽章後，將使用者私訊機器人的訊息交給共用的核心處理
+func lineWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if line == nil {
+		http.NotFound(w, r)
+		return
+	}
+	ctx := withLogAttrs(r.Context(), slog.String("request_id", newRequestID()))
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not read LINE webhook", "error", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if !line.verifySignature(body, r.Header.Get("X-Line-Signature")) {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	var webhook lineWebhook
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		slog.ErrorContext(ctx, "could not decode LINE webhook", "error", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	for _, event := range webhook.Events {
+		if msg := line.platformMessage(ctx, &event); msg != nil {
+			handlePlatformMessage(ctx, line, msg)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// verifySignature 檢查 X-Line-Signature 是否為以頻道密鑰計算的 HMAC-SHA256
+func (l *linePlatform) verifySignature(body []byte, signature string) bool {
+	got, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(l.channelSecret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// platformMessage 將使用者私訊的文字、圖片、影片、語音與檔案轉成 PlatformMessage，其他事件回傳 nil
+func (l *linePlatform) platformMessage(ctx context.Context, event *lineEvent) *PlatformMessage {
+	if event.Type != "message" || event.Message == nil || event.Source.Type != "user" || event.Source.UserID == "" {
+		return nil
+	}
+	m := event.Message
+	msg := &PlatformMessage{
+		UserID:         platformUserID(l.Name(), event.Source.UserID),
+		PlatformUserID: event.Source.UserID,
+		Private:        true,
+		ChatID:         event.Source.UserID,
+		MessageID:      m.ID,
+		Text:           m.Text,
+		Date:           time.UnixMilli(event.Timestamp),
+		ReplyToken:     event.ReplyToken,
+	}
+	// LINE 沒有提供圖片、影片與語音的檔名，以訊息 ID 命名
+	var file *PlatformFile
+	switch m.Type {
+	case "text":
+		return msg
+	case "image":
+		file = &PlatformFile{Name: m.ID + ".jpg", Type: "photo", MimeType: "image/jpeg"}
+	case "video":
+		file = &PlatformFile{Name: m.ID + ".mp4", Type: "video", MimeType: "video/mp4"}
+	case "audio":
+		file = &PlatformFile{Name: m.ID + ".m4a", Type: "voice", MimeType: "audio/mp4"}
+	case "file":
+		name := strings.NewReplacer("/", "_", "\\", "_").Replace(m.FileName)
+		if name == "" {
+			name = m.ID
+		}
+		file = &PlatformFile{Name: name, Type: "document", MimeType: mimeTypeByExt(strings.ToLower(path.Ext(name))), Size: m.FileSize}
+		if file.MimeType == "" {
+			file.MimeType = "application/octet-stream"
+		}
+	default:
+		return nil
+	}
+	// 從外部網址分享的圖片與影片不在 LINE 的伺服器上，無法下載
+	if m.ContentProvider.Type != "" && m.ContentProvider.Type != "line" {
+		return nil
+	}
+	messageID := m.ID
+	file.Open = func(ctx context.Context) (io.ReadCloser, error) {
+		return l.content(ctx, messageID)
+	}
+	msg.File = file
+	msg.SenderName = l.displayName(ctx, event.Source.UserID)
+	return msg
+}
+
+// content 下載訊息中的檔案內容
+func (l *linePlatform) content(ctx context.Context, messageID string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, lineDataAPIURL+"/message/"+messageID+"/content", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+l.accessToken)
+	resp, err := lineContentClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("LINE content API returned %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// displayName 回傳使用者的 LINE 名稱，用於檔名範本的 {sender}；失敗時回傳空字串
+func (l *linePlatform) displayName(ctx context.Context, userID string) string {
+	var profile struct {
+		DisplayName string `json:"displayName"`
+	}
+	if err := l.call(ctx, http.MethodGet, "/profile/"+userID, nil, &profile); err != nil {
+		slog.WarnContext(ctx, "Failed to read LINE profile", "error", err)
+		return ""
+	}
+	return profile.DisplayName
+}
+
+// Reply 在 reply token 仍有效時以回覆訊息送出，reply token 只能使用一次，之後改用主動推送
+func (l *linePlatform) Reply(ctx context.Context, msg *PlatformMessage, text string) error {
+	if runes := []rune(text); len(runes) > lineMaxTextLength {
+		text = string(runes[:lineMaxTextLength-1]) + "…"
+	}
+	messages := []map[string]string{{"type": "text", "text": text}}
+	if token := msg.ReplyToken; token != "" && time.Since(msg.Date) < lineReplyTokenTTL {
+		msg.ReplyToken = ""
+		err := l.call(ctx, http.MethodPost, "/message/reply", map[string]interface{}{"replyToken": token, "messages": messages}, nil)
+		if err == nil {
+			return nil
+		}
+		slog.WarnContext(ctx, "Failed to reply on LINE, pushing instead", "error", err)
+	}
+	return l.call(ctx, http.MethodPost, "/message/push", map[string]interface{}{"to": msg.PlatformUserID, "messages": messages}, nil)
+}
+
+// call 呼叫 Messaging API，out 不為 nil 時解析回應的 JSON
+func (l *linePlatform) call(ctx context.Context, method, endpoint string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, lineAPIURL+endpoint, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+l.accessToken)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := lineClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("LINE API %s returned %s: %s", endpoint, resp.Status, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+)
+
+func TestLineVerifySignature(t *testing.T) {
+	l := &linePlatform{channelSecret: "channel-secret"}
+	body := []byte(`{"events":[]}`)
+	sign := func(secret string, body []byte) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+	tests := []struct {
+		name      string
+		body      []byte
+		signature string
+		want      bool
+	}{
+		{"valid", body, sign("channel-secret", body), true},
+		{"other secret", body, sign("other-secret", body), false},
+		{"modified body", []byte(`{"events":[{}]}`), sign("channel-secret", body), false},
+		{"empty signature", body, "", false},
+		{"not base64", body, "%%%", false},
+	}
+	for _, tt := range tests {
+		if got := l.verifySignature(tt.body, tt.signature); got != tt.want {
+			t.Errorf("%s: verifySignature = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
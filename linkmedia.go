@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 社群連結 ---
+
+const (
+	// yt-dlp 解析一個連結的時限
+	linkExtractTimeout = time.Minute
+	// 一則貼文最多上傳的媒體數
+	maxLinkMedia = 10
+	// 只選擇可以直接以 HTTP 下載的單一檔案，不需要合併影音或下載 HLS 分段
+	linkMediaFormat = "b[protocol^=http][protocol!*=dash]"
+)
+
+// linkSite 是可以從連結下載媒體的網站，使用者可以在 /settings 中個別開關
+type linkSite struct {
+	Name    string // 設定中使用的名稱，也是檔名的前綴
+	Label   string
+	Domains []string
+	OptIn   bool // 預設關閉，使用者需要自行開啟
+}
+
+var linkSites = []linkSite{
+	{Name: "instagram", Label: "Instagram", Domains: []string{"instagram.com", "instagr.am"}},
+	{Name: "x", Label: "X (Twitter)", Domains: []string{"x.com", "twitter.com"}},
+	{Name: youtubeSite, Label: "YouTube", Domains: []string{"youtube.com", "youtu.be"}, OptIn: true},
+}
+
+// initLinkMedia 確認 YTDLP_PATH 設定的 yt-dlp 可以執行；未設定時不從連結下載媒體
+// Instagram 與 X 的 oEmbed 只提供嵌入用的 HTML，沒有媒體的網址，因此改用 yt-dlp 解析
+func initLinkMedia(cfg *Config) error {
+	if cfg.YtDlpPath == "" {
+		return nil
+	}
+	path, err := exec.LookPath(cfg.YtDlpPath)
+	if err != nil {
+		return fmt.Errorf("yt-dlp not found: %v", err)
+	}
+	slog.Info("Link media extraction enabled", "ytdlp_path", path, "youtube", !cfg.DisableYouTube)
+	return nil
+}
+
+// linkSiteEnabled 回報使用者是否開啟了這個網站的連結下載
+func linkSiteEnabled(settings *UserSettings, site *linkSite) bool {
+	if site.OptIn {
+		return slices.Contains(settings.LinkSitesEnabled, site.Name)
+	}
+	return !slices.Contains(settings.LinkSitesDisabled, site.Name)
+}
+
+// availableLinkSites 回傳管理者提供的網站；設定 DISABLE_YOUTUBE 時不包含 YouTube
+func availableLinkSites(ctx context.Context) []linkSite {
+	if !configFor(ctx).DisableYouTube {
+		return linkSites
+	}
+	return slices.DeleteFunc(slices.Clone(linkSites), func(s linkSite) bool { return s.Name == youtubeSite })
+}
+
+// linkSiteByName 依名稱找出網站，找不到或管理者不提供時回傳 nil
+func linkSiteByName(ctx context.Context, name string) *linkSite {
+	sites := availableLinkSites(ctx)
+	for i := range sites {
+		if sites[i].Name == name {
+			return &sites[i]
+		}
+	}
+	return nil
+}
+
+// matchLinkSite 回傳網址所屬的網站，子網域（例如 www.、mobile.）視為同一個網站
+func matchLinkSite(ctx context.Context, u *url.URL) *linkSite {
+	host := strings.ToLower(u.Hostname())
+	sites := availableLinkSites(ctx)
+	for i, site := range sites {
+		for _, d := range site.Domains {
+			if host == d || strings.HasSuffix(host, "."+d) {
+				return &sites[i]
+			}
+		}
+	}
+	return nil
+}
+
+// findSocialLink 找出文字中第一個支援的網站連結
+func findSocialLink(ctx context.Context, text string) (*linkSite, string) {
+	for _, field := range strings.Fields(text) {
+		u, err := url.Parse(field)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+			continue
+		}
+		if site := matchLinkSite(ctx, u); site != nil {
+			return site, u.String()
+		}
+	}
+	return nil, ""
+}
+
+// linkMediaInfo 是 yt-dlp --dump-single-json 輸出中用到的欄位；多張媒體的貼文會放在 Entries 中
+type linkMediaInfo struct {
+	ID          string            `json:"id"`
+	Title       string            `json:"title"`
+	Uploader    string            `json:"uploader"`
+	Timestamp   int64             `json:"timestamp"`
+	Ext         string            `json:"ext"`
+	URL         string            `json:"url"`
+	HTTPHeaders map[string]string `json:"http_headers"`
+	Filesize    int64             `json:"filesize"`
+	Entries     []*linkMediaInfo  `json:"entries"`
+}
+
+// media 回傳貼文中可以下載的媒體，項目缺少的標題、作者與時間沿用貼文的值
+func (i *linkMediaInfo) media() []*linkMediaInfo {
+	if len(i.Entries) == 0 {
+		if i.URL == "" {
+			return nil
+		}
+		return []*linkMediaInfo{i}
+	}
+	var media []*linkMediaInfo
+	for _, e := range i.Entries {
+		if e == nil || e.URL == "" {
+			continue
+		}
+		e.Title = cmp.Or(e.Title, i.Title)
+		e.Uploader = cmp.Or(e.Uploader, i.Uploader)
+		if e.Timestamp == 0 {
+			e.Timestamp = i.Timestamp
+		}
+		media = append(media, e)
+	}
+	return media
+}
+
+var (
+	errNoLinkMedia      = errors.New("no downloadable media")
+	errLinkLoginNeeded  = errors.New("login required")
+	errLinkNotFound     = errors.New("post not found")
+	errLinkUnsupported  = errors.New("unsupported format")
+	errLinkExtractLimit = errors.New("extraction timed out")
+)
+
+// extractLinkMedia 以 yt-dlp 解析連結，只取得媒體的網址而不下載
+func extractLinkMedia(ctx context.Context, link string) (*linkMediaInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, linkExtractTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, configFor(ctx).YtDlpPath, "--dump-single-json", "--no-warnings", "--no-progress", "--ignore-no-formats-error", "-f", linkMediaFormat, "--", link)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, errLinkExtractLimit
+	}
+	if err != nil {
+		return nil, classifyLinkError(stderr.String(), err)
+	}
+	var info linkMediaInfo
+	if err := json.Unmarshal(out, &info); err != nil {
+		return nil, fmt.Errorf("failed to parse yt-dlp output: %v", err)
+	}
+	return &info, nil
+}
+
+// classifyLinkError 依 yt-dlp 的錯誤訊息判斷失敗的原因，讓回覆可以說明使用者能怎麼做
+func classifyLinkError(stderr string, err error) error {
+	msg := strings.ToLower(stderr)
+	switch {
+	case strings.Contains(msg, "no video could be found"), strings.Contains(msg, "there's no video"), strings.Contains(msg, "no media"):
+		return errNoLinkMedia
+	case strings.Contains(msg, "login"), strings.Contains(msg, "private"), strings.Contains(msg, "age-restricted"), strings.Contains(msg, "sensitive"):
+		return errLinkLoginNeeded
+	case strings.Contains(msg, "404"), strings.Contains(msg, "not found"), strings.Contains(msg, "does not exist"):
+		return errLinkNotFound
+	case strings.Contains(msg, "requested format is not available"):
+		return errLinkUnsupported
+	}
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	return fmt.Errorf("yt-dlp failed: %v: %s", err, lines[len(lines)-1])
+}
+
+// linkErrorMessage 回傳告知使用者無法下載的原因
+func linkErrorMessage(site *linkSite, err error) string {
+	switch {
+	case errors.Is(err, errNoLinkMedia):
+		return fmt.Sprintf("這則 %s 貼文沒有可以下載的影片或 GIF。圖片貼文目前無法從連結下載，請直接把圖片傳給我。", site.Label)
+	case errors.Is(err, errLinkLoginNeeded):
+		return fmt.Sprintf("這則 %s 貼文需要登入才能查看（私人帳號或受限的內容），無法下載。", site.Label)
+	case errors.Is(err, errLinkNotFound):
+		return fmt.Sprintf("找不到這則 %s 貼文，可能已經被刪除。", site.Label)
+	case errors.Is(err, errLinkUnsupported):
+		return fmt.Sprintf("這則 %s 貼文的影片需要合併影音或以串流播放，目前無法下載。", site.Label)
+	case errors.Is(err, errLinkExtractLimit):
+		return fmt.Sprintf("解析 %s 連結逾時，請稍後再試。", site.Label)
+	}
+	return fmt.Sprintf("無法從這個 %s 連結取得媒體，網站可能暫時限制了存取，請稍後再試。", site.Label)
+}
+
+// linkMediaType 依副檔名決定檔名範本中的 {type}
+func linkMediaType(ext string) string {
+	switch strings.ToLower(ext) {
+	case "mp4", "webm", "mov", "m4v":
+		return "video"
+	case "jpg", "jpeg", "png", "webp":
+		return "photo"
+	case "gif":
+		return "animation"
+	}
+	return "document"
+}
+
+// openLinkMedia 開始下載媒體，呼叫端需關閉 body
+func openLinkMedia(ctx context.Context, m *linkMediaInfo) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// 部分網站的媒體網址需要與解析時相同的標頭（例如 User-Agent、Referer）
+	for k, v := range m.HTTPHeaders {
+		req.Header.Set(k, v)
+	}
+	resp, err := instrumentedClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// handleLinkMedia 在私訊中收到支援的網站連結時下載貼文中的媒體並上傳，不是支援的連結或使用者關閉該網站時回傳 false
+func handleLinkMedia(ctx context.Context, message *tgbotapi.Message) bool {
+	if configFor(ctx).YtDlpPath == "" || !message.Chat.IsPrivate() || message.From == nil || message.Text == "" {
+		return false
+	}
+	site, link := findSocialLink(ctx, message.Text)
+	if site == nil {
+		return false
+	}
+	userID := message.From.ID
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		return false
+	}
+	if !linkSiteEnabled(settings, site) {
+		return false
+	}
+	ctx = withLogAttrs(ctx, slog.String("link_site", site.Name))
+
+	dest := userDestination(ctx, settings)
+	connected, err := dest.Connected(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to retrieve token", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的授權時發生錯誤，請稍後再試。")
+		return true
+	}
+	if !connected {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("您的 %s 帳號尚未連結，請使用 %s 指令來重新連結。", dest.DisplayName(), connectCommand(dest)))
+		return true
+	}
+	if site.Name == youtubeSite {
+		promptYouTubeQuality(ctx, message, link)
+		return true
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("正在從 %s 取得媒體…", site.Label))
+
+	// 解析與下載可能需要數十秒，在背景進行以免 webhook 逾時
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				reportPanic(ctx, r)
+			}
+		}()
+		saveLinkMedia(ctx, message, settings, dest, site, link)
+	}()
+	return true
+}
+
+// saveLinkMedia 解析連結並依序上傳貼文中的媒體，最後回覆結果
+func saveLinkMedia(ctx context.Context, message *tgbotapi.Message, settings *UserSettings, dest Destination, site *linkSite, link string) {
+	userID := message.From.ID
+	info, err := extractLinkMedia(ctx, link)
+	if err == nil && len(info.media()) == 0 {
+		err = errNoLinkMedia
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to extract link media", "link", link, "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, linkErrorMessage(site, err))
+		return
+	}
+	media := info.media()
+	if len(media) > maxLinkMedia {
+		media = media[:maxLinkMedia]
+	}
+
+	profile := settings.Profile()
+	var uploaded []string
+	var total int64
+	for i, m := range media {
+		if err := reserveUpload(ctx, userID, m.Filesize); err != nil {
+			var limitErr *rateLimitError
+			if errors.As(err, &limitErr) {
+				replyToUser(ctx, message.Chat.ID, message.MessageID, rateLimitMessage(ctx, limitErr))
+				break
+			}
+			slog.ErrorContext(ctx, "Failed to check rate limit", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "檢查上傳額度時發生錯誤，請稍後再試。")
+			break
+		}
+		date := time.Unix(int64(message.Date), 0)
+		if m.Timestamp > 0 {
+			date = time.Unix(m.Timestamp, 0)
+		}
+		name := fmt.Sprintf("%s_%s", site.Name, m.ID)
+		if len(media) > 1 && m.ID == info.ID {
+			name = fmt.Sprintf("%s_%d", name, i+1)
+		}
+		meta := &UploadMeta{
+			Name:      name,
+			Ext:       m.Ext,
+			Size:      m.Filesize,
+			Type:      linkMediaType(m.Ext),
+			Sender:    m.Uploader,
+			Chat:      site.Label,
+			Caption:   m.Title,
+			Date:      date,
+			CreatedAt: time.Now(),
+		}
+		result, size, err := uploadLinkMedia(ctx, dest, userID, m, meta, profile, link)
+		event := &UploadEvent{UserID: userID, ChatID: message.Chat.ID, Destination: dest.Name(), FileName: joinExt(name, m.Ext), MimeType: mimeTypeByExt("." + m.Ext), Size: size}
+		if err != nil {
+			event.Event, event.Error = eventUploadFailed, err.Error()
+			emitUploadEvent(ctx, settings, event)
+			if errors.Is(err, errFileTooLarge) {
+				replyToUser(ctx, message.Chat.ID, message.MessageID, fileTooLargeMessage(ctx, 0))
+				continue
+			}
+			reportError(ctx, "Failed to upload link media", err, "destination", dest.Name())
+			replyToUser(ctx, message.Chat.ID, message.MessageID, uploadFailedMessage(ctx, dest, userID, err, fmt.Sprintf("上傳到您的 %s 失敗。", dest.DisplayName())))
+			continue
+		}
+		uploaded = append(uploaded, result.Name)
+		total += size
+		recordLinkUpload(ctx, settings, dest, result, event)
+	}
+	if len(uploaded) == 0 {
+		return
+	}
+	slog.InfoContext(ctx, "Link media uploaded", "link", link, "files", len(uploaded), "size", total)
+	text := fmt.Sprintf("已將 %s 貼文中的 %d 個檔案（%s）上傳到您的 %s：\n%s", site.Label, len(uploaded), formatSize(total), dest.DisplayName(), strings.Join(uploaded, "\n"))
+	if len(info.media()) > maxLinkMedia {
+		text += fmt.Sprintf("\n\n貼文中有超過 %d 個媒體，只上傳了前 %d 個。", maxLinkMedia, maxLinkMedia)
+	}
+	if dryRun(ctx) {
+		text = dryRunReply(ctx, fmt.Sprintf("已從 %s 貼文取得 %d 個檔案（%s），試運行模式不會實際上傳到您的 %s。", site.Label, len(uploaded), formatSize(total), dest.DisplayName()))
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, text)
+}
+
+// recordLinkUpload 記錄從連結上傳的檔案的用量並送出上傳事件，試運行時不記錄
+func recordLinkUpload(ctx context.Context, settings *UserSettings, dest Destination, result *UploadResult, event *UploadEvent) {
+	if dryRun(ctx) {
+		return
+	}
+	addQuotaUsage(ctx, dest, event.UserID, event.Size)
+	if err := recordUploadBytes(ctx, event.UserID, event.Size); err != nil {
+		slog.ErrorContext(ctx, "Failed to record upload bytes", "error", err)
+	}
+	if err := recordUploadStats(ctx, event.Size); err != nil {
+		slog.ErrorContext(ctx, "Failed to record upload stats", "error", err)
+	}
+	if err := recordUserUsage(ctx, event.UserID, result.Name, event.Size); err != nil {
+		slog.ErrorContext(ctx, "Failed to record user usage", "error", err)
+	}
+	event.Event = eventUploadCompleted
+	event.FileName, event.FileID, event.Link, event.Account = result.Name, result.FileID, result.Link, result.Account
+	emitUploadEvent(ctx, settings, event)
+}
+
+// uploadLinkMedia 下載一個媒體並串流上傳，回傳上傳結果與實際的大小
+func uploadLinkMedia(ctx context.Context, dest Destination, userID int64, m *linkMediaInfo, meta *UploadMeta, profile ProcessingProfile, link string) (*UploadResult, int64, error) {
+	resp, err := openLinkMedia(ctx, m)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to download media: %w", err)
+	}
+	defer resp.Close()
+	body := newLimitedReader(resp, configFor(ctx).maxFileSize())
+	caption := link
+	if meta.Caption != "" {
+		caption = meta.Caption + "\n\n" + link
+	}
+	result, err := uploadTo(ctx, dest, userID, &UploadFile{
+		Name:     renderFileName(profile.FilenameTemplate, meta),
+		Folders:  renderFolderPath(profile.FolderTemplate, meta),
+		Body:     body,
+		MimeType: mimeTypeByExt("." + meta.Ext),
+		Caption:  caption,
+	})
+	if body.Exceeded() {
+		return nil, body.BytesRead(), errFileTooLarge
+	}
+	return result, body.BytesRead(), err
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+)
+
+// --- 結構化日誌 ---
+
+type logAttrsKey struct{}
+
+// contextHandler 會把存放在 context 中的欄位（update_id、user_id、chat_id、request_id）
+// 加到每一行日誌上，讓 Cloud Logging 可以追蹤同一次上傳的完整流程
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(logAttrsKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
+	}
+	if traceID, spanID, ok := traceLogAttrs(ctx); ok {
+		r.AddAttrs(slog.String("logging.googleapis.com/trace", traceID), slog.String("logging.googleapis.com/spanId", spanID))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// initLogger 設定 JSON 格式的預設 logger，欄位名稱對應 Cloud Logging 的結構化日誌格式
+func initLogger() {
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) > 0 {
+				return a
+			}
+			switch a.Key {
+			case slog.LevelKey:
+				a.Key = "severity"
+			case slog.MessageKey:
+				a.Key = "message"
+			}
+			return a
+		},
+	})
+	slog.SetDefault(slog.New(contextHandler{handler}))
+}
+
+// withLogAttrs 回傳一個帶有額外日誌欄位的 context
+func withLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	merged := make([]slog.Attr, 0, len(existing)+len(attrs))
+	merged = append(merged, existing...)
+	merged = append(merged, attrs...)
+	return context.WithValue(ctx, logAttrsKey{}, merged)
+}
+
+// newRequestID 產生用來串連同一個請求所有日誌的隨機 ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -1,32 +1,27 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
 	"context"
-	"crypto/rand"
-	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
-	"cloud.google.com/go/firestore"
 	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
-	"google.golang.org/api/drive/v3"
-	"google.golang.org/api/option"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
-)
-
-// --- 全域變數 ---
-var (
-	bot             *tgbotapi.BotAPI
-	oauth2Config    *oauth2.Config
-	firestoreClient *firestore.Client
-	gcpProjectID    string
 )
 
 const (
@@ -37,284 +32,851 @@ const (
 
 // UserToken 用來儲存在 Firestore 中的使用者權杖
 type UserToken struct {
-	UserID       int64         `firestore:"user_id"`
-	RefreshToken string        `firestore:"refresh_token"`
-	TokenType    string        `firestore:"token_type"`
-	Expiry       time.Time     `firestore:"expiry"`
-	AccessToken  string        `firestore:"access_token"`
-	CreatedAt    time.Time     `firestore:"created_at"`
+	UserID       int64     `firestore:"user_id"`
+	Email        string    `firestore:"email,omitempty"` // Google 帳號的 email，其他目的地為空字串
+	RefreshToken string    `firestore:"refresh_token"`
+	TokenType    string    `firestore:"token_type"`
+	Expiry       time.Time `firestore:"expiry"`
+	AccessToken  string    `firestore:"access_token"`
+	Scopes       []string  `firestore:"scopes,omitempty"` // Google 已授權的權限，其他目的地為空
+	CreatedAt    time.Time `firestore:"created_at"`
 }
 
-// --- 初始化 ---
-func initFirestore(ctx context.Context) error {
-	gcpProjectID = os.Getenv("GCP_PROJECT_ID")
-	if gcpProjectID == "" {
-		return fmt.Errorf("GCP_PROJECT_ID environment variable not set")
-	}
-
-	var err error
-	firestoreClient, err = firestore.NewClient(ctx, gcpProjectID)
-	if err != nil {
-		return fmt.Errorf("failed to create firestore client: %v", err)
+// Token 轉換成 oauth2 的權杖
+func (t *UserToken) Token() *oauth2.Token {
+	return &oauth2.Token{
+		AccessToken:  t.AccessToken,
+		TokenType:    t.TokenType,
+		RefreshToken: t.RefreshToken,
+		Expiry:       t.Expiry,
 	}
-	return nil
 }
 
-func initOAuth2Config() error {
-	clientID := os.Getenv("GOOGLE_CLIENT_ID")
-	clientSecret := os.Getenv("GOOGLE_CLIENT_SECRET")
-	redirectURL := os.Getenv("GOOGLE_REDIRECT_URL") // e.g., https://your-service.run.app/oauth/callback
+// --- 主要邏輯 ---
 
-	if clientID == "" || clientSecret == "" || redirectURL == "" {
-		return fmt.Errorf("GOOGLE_CLIENT_ID, GOOGLE_CLIENT_SECRET, or GOOGLE_REDIRECT_URL not set")
+// 處理 /connect_drive、/connect_dropbox 等連結目的地的指令
+func handleConnect(ctx context.Context, message *tgbotapi.Message, dest Destination) {
+	if d, ok := dest.(interactiveDestination); ok {
+		d.StartConnect(ctx, message)
+		return
 	}
-
-	oauth2Config = &oauth2.Config{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		RedirectURL:  redirectURL,
-		Scopes:       []string{drive.DriveFileScope}, // 只要求上傳權限
-		Endpoint:     google.Endpoint,
+	authURL, err := dest.Connect(ctx, message.From.ID)
+	if errors.Is(err, errConnectNotRequired) {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("%s 由管理者設定，不需要另外連結，使用 /destination %s 即可切換。", dest.DisplayName(), dest.Name()))
+		return
 	}
-	return nil
-}
-
-// --- 主要邏輯 ---
-
-// 處理 /connect_drive 指令
-func handleConnectDrive(message *tgbotapi.Message) {
-	// 產生一個隨機的 state 字串來防止 CSRF 攻擊
-	b := make([]byte, 32)
-	rand.Read(b)
-	state := base64.URLEncoding.EncodeToString(b)
-
-	// 將 state 和使用者 ID 存到 Firestore，設定一個短的過期時間
-	ctx := context.Background()
-	_, err := firestoreClient.Collection(stateCollection).Doc(state).Set(ctx, map[string]interface{}{
-		"user_id":    message.From.ID,
-		"created_at": time.Now(),
-	})
 	if err != nil {
-		log.Printf("Failed to save state to firestore: %v", err)
-		replyToUser(message.Chat.ID, message.MessageID, "產生授權連結時發生錯誤，請稍後再試。")
+		slog.ErrorContext(ctx, "Failed to create authorization link", "destination", dest.Name(), "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "產生授權連結時發生錯誤，請稍後再試。")
 		return
 	}
-
-	// 產生授權 URL
-	authURL := oauth2Config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce)
-	replyToUser(message.Chat.ID, message.MessageID, "請點擊以下連結授權本 Bot 存取您的 Google Drive (僅限上傳權限)：\n\n"+authURL)
+	if dest.Name() == "drive" {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請點擊以下連結授權本 Bot 存取您的 Google Drive (僅限上傳權限)：\n\n"+authURL)
+		return
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("請點擊以下連結授權本 Bot 存取您的 %s：\n\n%s", dest.DisplayName(), authURL))
 }
 
-// 處理來自 Google 的 OAuth 回呼
+// 處理來自 Google、Dropbox 等目的地的 OAuth 回呼
 func oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := context.Background()
+	ctx := withLogAttrs(r.Context(), slog.String("request_id", newRequestID()))
 	state := r.URL.Query().Get("state")
 	code := r.URL.Query().Get("code")
 
-	// 1. 驗證 state
-	doc, err := firestoreClient.Collection(stateCollection).Doc(state).Get(ctx)
-	if err != nil {
-		http.Error(w, "Invalid state parameter. Please try again.", http.StatusBadRequest)
+	// 1. 驗證 state；state 的前綴決定是哪個機器人的授權
+	t := tenantFromState(state)
+	if t == nil {
+		renderOAuthPage(ctx, w, r, http.StatusBadRequest, oauthPageInvalidState, "")
 		return
 	}
-	// 驗證後立即刪除 state，防止重複使用
-	defer doc.Ref.Delete(ctx)
-
-	var stateData struct {
-		UserID int64 `firestore:"user_id"`
+	ctx = withTenant(ctx, t)
+	// 以交易讀取並刪除 state，同一個授權連結只能使用一次
+	stateData, err := consumeOAuthState(ctx, state)
+	if errors.Is(err, errStateConsumed) {
+		slog.WarnContext(ctx, "OAuth state not found or already used")
+		renderOAuthPage(ctx, w, r, http.StatusBadRequest, oauthPageStateUsed, "")
+		return
+	}
+	if err != nil {
+		reportError(ctx, "Failed to consume OAuth state", err)
+		renderOAuthPage(ctx, w, r, http.StatusInternalServerError, oauthPageServerError, "")
+		return
 	}
-	doc.DataTo(&stateData)
 	userID := stateData.UserID
+	// 舊版的 state 沒有記錄目的地，一律視為 Google Drive
+	if stateData.Provider == "" {
+		stateData.Provider = defaultDestination
+	}
+	ctx = withLogAttrs(ctx, slog.Int64("user_id", userID), slog.String("destination", stateData.Provider))
+	lang := oauthPageLanguage(ctx, userID)
 
-	// 2. 用授權碼交換權杖
-	token, err := oauth2Config.Exchange(ctx, code)
-	if err != nil {
-		log.Printf("Failed to exchange token: %v", err)
-		http.Error(w, "Failed to exchange token.", http.StatusInternalServerError)
+	dest, ok := destinations[stateData.Provider].(oauthDestination)
+	if !ok {
+		slog.ErrorContext(ctx, "Unknown OAuth destination in state")
+		renderOAuthPage(ctx, w, r, http.StatusBadRequest, oauthPageInvalidState, lang)
 		return
 	}
 
-	// 3. 將 Refresh Token 存到 Firestore
-	userToken := &UserToken{
-		UserID:       userID,
-		RefreshToken: token.RefreshToken,
-		AccessToken:  token.AccessToken,
-		TokenType:    token.TokenType,
-		Expiry:       token.Expiry,
-		CreatedAt:    time.Now(),
+	// 使用者拒絕授權或授權失敗時，回呼只會帶上 error 而沒有授權碼；state 已經在上面刪除
+	if oauthErr := r.URL.Query().Get("error"); oauthErr != "" {
+		slog.WarnContext(ctx, "OAuth authorization was not granted", "oauth_error", oauthErr, "description", r.URL.Query().Get("error_description"))
+		kind, text := oauthPageCancelled, fmt.Sprintf("已取消連結 %s，您可以隨時再輸入 %s 重新連結。", dest.DisplayName(), connectCommand(dest))
+		if oauthErr != "access_denied" {
+			kind, text = oauthPageExchangeError, fmt.Sprintf("連結 %s 失敗（%s），請輸入 %s 再試一次。", dest.DisplayName(), oauthErr, connectCommand(dest))
+		}
+		// 私人對話的 chat ID 與使用者 ID 相同
+		if _, err := sendMessage(ctx, tgbotapi.NewMessage(userID, text)); err != nil {
+			slog.WarnContext(ctx, "Failed to notify user about OAuth error", "error", err)
+		}
+		renderOAuthPage(ctx, w, r, http.StatusOK, kind, lang)
+		return
 	}
 
-	// 使用 UserID 作為文件 ID
-	_, err = firestoreClient.Collection(tokenCollection).Doc(fmt.Sprintf("%d", userID)).Set(ctx, userToken)
-	if err != nil {
-		log.Printf("Failed to save token to firestore: %v", err)
-		http.Error(w, "Failed to save token.", http.StatusInternalServerError)
+	// 2. 用授權碼交換權杖，並將 Refresh Token 存到 Firestore
+	if err := dest.Exchange(ctx, userID, code, stateData.CodeVerifier); err != nil {
+		reportError(ctx, "Failed to exchange token", err)
+		renderOAuthPage(ctx, w, r, http.StatusInternalServerError, oauthPageExchangeError, lang)
 		return
 	}
 
-	log.Printf("Successfully saved token for user %d", userID)
-	fmt.Fprintf(w, "授權成功！您現在可以回到 Telegram 傳送檔案給機器人了。")
+	// 3. 連結 Google Drive 以外的目的地時，將它設為使用者的上傳目的地；Google Photos 等只接受照片與影片的目的地改設為照片與影片的目的地
+	field := "destination"
+	if _, ok := dest.(mediaDestination); ok {
+		field = "media_destination"
+	}
+	if dest.Name() != defaultDestination {
+		if err := updateUserSettings(ctx, userID, map[string]interface{}{field: dest.Name()}); err != nil {
+			slog.ErrorContext(ctx, "Failed to update destination preference", "error", err)
+		}
+	}
+
+	slog.InfoContext(ctx, "Successfully saved token")
+	renderOAuthPage(ctx, w, r, http.StatusOK, oauthPageSuccess, lang)
 }
 
 // 處理檔案上傳
-func handleFile(message *tgbotapi.Message) {
-	ctx := context.Background()
-	userID := message.From.ID
+func handleFile(ctx context.Context, message *tgbotapi.Message) {
+	// 頻道貼文沒有 From，上傳者一律是封存擁有者
+	var userID int64
+	if message.From != nil {
+		userID = message.From.ID
+	}
 
-	// 1. 從 Firestore 取得使用者的權杖
-	doc, err := firestoreClient.Collection(tokenCollection).Doc(fmt.Sprintf("%d", userID)).Get(ctx)
+	file := messageFile(message)
+	if file == nil {
+		return
+	}
+	if message.Sticker != nil {
+		ext := stickerExt(ctx, message.Sticker)
+		file.Name = stickerFileName(message.Sticker, ext)
+		file.MimeType = mimeTypeByExt(ext)
+	}
+
+	// 錯誤與確認訊息回覆到原本的聊天室；頻道中不適合出現機器人的訊息，改以私訊通知封存擁有者
+	notifyChatID, replyTo := message.Chat.ID, message.MessageID
+
+	// 群組或頻道開啟封存模式時，所有成員傳送的檔案都上傳到封存擁有者的儲存空間
+	archive, err := loadArchiveSettings(ctx, message.Chat)
 	if err != nil {
-		if status.Code(err) == codes.NotFound {
-			log.Printf("Token not found for user %d: %v", userID, err)
-			replyToUser(message.Chat.ID, message.MessageID, "您的 Google Drive 帳號尚未連結，請使用 /connect_drive 指令來重新連結。")
-		} else {
-			log.Printf("Failed to retrieve token for user %d: %v", userID, err)
-			replyToUser(message.Chat.ID, message.MessageID, "讀取您的授權時發生錯誤，請稍後再試。")
+		slog.ErrorContext(ctx, "Failed to load chat settings", "error", err)
+		if message.Chat.IsChannel() {
+			return
 		}
+		replyToUser(ctx, notifyChatID, replyTo, "讀取聊天室設定時發生錯誤，請稍後再試。")
+		return
+	}
+	if archive != nil {
+		userID = archive.ArchiveOwnerID
+		ctx = withLogAttrs(ctx, slog.Int64("archive_owner_id", userID))
+		if message.Chat.IsChannel() {
+			notifyChatID, replyTo = userID, 0
+		}
+	}
+	if userID == 0 {
 		return
 	}
 
-	var userToken UserToken
-	doc.DataTo(&userToken)
-
-	// 2. 建立一個使用使用者權杖的 HTTP client
-	token := &oauth2.Token{
-		AccessToken:  userToken.AccessToken,
-		TokenType:    userToken.TokenType,
-		RefreshToken: userToken.RefreshToken,
-		Expiry:       userToken.Expiry,
+	// 同一位使用者一次傳送多個檔案時依序上傳，避免同時存取 Firestore 與目的地、回覆交錯
+	release, err := acquireUploadSlot(ctx, userID)
+	if err != nil {
+		slog.WarnContext(ctx, "Gave up waiting for upload slot", "error", err)
+		return
 	}
-	client := oauth2Config.Client(ctx, token)
+	defer release()
 
-	// 3. 建立 Drive 服務
-	driveService, err := drive.NewService(ctx, option.WithHTTPClient(client))
+	// 1. 讀取使用者設定，決定上傳目的地與處理方式
+	settings, err := loadUserSettings(ctx, userID)
 	if err != nil {
-		log.Printf("Failed to create drive service for user %d: %v", userID, err)
-		replyToUser(message.Chat.ID, message.MessageID, "建立 Google Drive 連線時發生錯誤。")
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		replyToUser(ctx, notifyChatID, replyTo, "讀取您的設定時發生錯誤，請稍後再試。")
 		return
 	}
+	dest := uploadDestination(ctx, settings, file.MimeType)
+
+	// 壓縮的照片依使用者設定選擇尺寸
+	if file.Type == "photo" && len(message.Photo) > 0 {
+		photo := selectPhotoSize(message.Photo, settings.PhotoSize)
+		file = photoFile(photo)
+		slog.InfoContext(ctx, "Selected photo size", "strategy", cmp.Or(settings.PhotoSize, photoSizeLargest), "width", photo.Width, "height", photo.Height, "file_size", photo.FileSize, "variants", len(message.Photo))
+	}
 
-	// --- 以下與之前的檔案上傳邏輯相同 ---
-	var fileID string
-	var fileName string
-	var fileSize int64 // 使用 int64 來儲存檔案大小
+	// 說明文字中的 hashtag 設定了資料夾時直接上傳到該資料夾，不需要再選擇目的地
+	tags := captionHashtags(message.Caption)
+	tagFolder := hashtagFolder(settings, tags)
 
-	if message.Document != nil {
-		fileID, fileName, fileSize = message.Document.FileID, message.Document.FileName, int64(message.Document.FileSize)
-	} else if len(message.Photo) > 0 {
-		photo := message.Photo[len(message.Photo)-1]
-		fileID, fileName, fileSize = photo.FileID, fmt.Sprintf("%s.jpg", photo.FileID), int64(photo.FileSize)
-	} else {
+	// 開啟每次選擇目的地時先詢問使用者，按下按鈕或逾時後會帶著選擇的目的地再回到這裡
+	if archive == nil && tagFolder == "" && promptDestination(ctx, message, settings, dest, file) {
 		return
 	}
+	target := uploadTargetFromContext(ctx)
+	if target != nil {
+		if d, ok := destinations[target.Destination]; ok {
+			dest = d
+		}
+	}
+	ctx = withLogAttrs(ctx, slog.String("destination", dest.Name()))
 
-	// 新增：檢查檔案大小是否超過 Telegram Bot API 的 20MB 下載限制
-	const maxFileSize = 20 * 1024 * 1024 // 20 MB
-	if fileSize > maxFileSize {
-		log.Printf("File size %d exceeds the 20MB limit for user %d.", fileSize, userID)
-		replyToUser(message.Chat.ID, message.MessageID, fmt.Sprintf("檔案大小為 %.2f MB，已超過 Telegram 機器人 20 MB 的下載限制，無法處理。", float64(fileSize)/1024/1024))
+	profile, err := resolveProfile(ctx, message, settings, archive)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to resolve chat binding", "error", err)
+		replyToUser(ctx, notifyChatID, replyTo, "讀取聊天室設定時發生錯誤，請稍後再試。")
 		return
 	}
+	if importing(ctx) {
+		profile = importProfile(profile)
+	}
+	if tagFolder != "" {
+		profile.FolderTemplate = tagFolder
+	}
+	if target != nil && target.Folder != "" {
+		profile.FolderTemplate = target.Folder
+	}
 
-	fileURL, err := bot.GetFileDirectURL(fileID)
+	// 2. 確認使用者已連結目的地
+	connected, err := dest.Connected(ctx, userID)
 	if err != nil {
-		log.Printf("Failed to get file URL: %v", err)
-		replyToUser(message.Chat.ID, message.MessageID, "無法取得檔案，請稍後再試。")
+		slog.ErrorContext(ctx, "Failed to retrieve token", "error", err)
+		replyToUser(ctx, notifyChatID, replyTo, "讀取您的授權時發生錯誤，請稍後再試。")
+		return
+	}
+	if !connected {
+		slog.WarnContext(ctx, "Token not found")
+		if archive != nil {
+			replyToUser(ctx, notifyChatID, replyTo, fmt.Sprintf("封存模式的擁有者尚未連結 %s，檔案無法封存。", dest.DisplayName()))
+			return
+		}
+		replyToUser(ctx, notifyChatID, replyTo, fmt.Sprintf("您的 %s 帳號尚未連結，請使用 %s 指令來重新連結。", dest.DisplayName(), connectCommand(dest)))
 		return
 	}
 
-	resp, err := http.Get(fileURL)
+	// 3. 檢查檔案大小是否超過設定的下載上限
+	// 部分訊息不會提供 FileSize（值為 0），此時改在串流時檢查
+	maxSize := configFor(ctx).maxFileSize()
+	if file.Size > maxSize {
+		slog.WarnContext(ctx, "File size exceeds the limit", "file_size", file.Size, "limit", maxSize)
+		replyToUser(ctx, notifyChatID, replyTo, fileTooLargeMessage(ctx, file.Size))
+		return
+	}
+
+	// 開啟略過重複檔案時，同一個檔案已經上傳過就直接回覆之前的結果
+	// 匯入時一律略過，重新轉傳同一批訊息就能從中斷的地方繼續
+	if settings.SkipDuplicates || importing(ctx) {
+		previous, err := findUploadByFile(ctx, userID, dest.Name(), file.UniqueID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to look up previous upload", "error", err)
+		} else if previous != nil {
+			slog.InfoContext(ctx, "Skipping duplicate file", "file_id", previous.FileID)
+			markImportSkipped(ctx)
+			if !profile.Silent {
+				reply := fmt.Sprintf("這個檔案之前已經上傳過了：%s", previous.Name)
+				if previous.Link != "" {
+					reply += "\n" + previous.Link
+				}
+				replyToUser(ctx, notifyChatID, replyTo, reply)
+			}
+			return
+		}
+	}
+
+	// 開啟上傳前確認或逐次選擇 Google 帳號時，先詢問使用者，按下按鈕後會再回到這裡
+	if archive == nil && googleAccountFromContext(ctx) == "" && promptUpload(ctx, message, settings, dest, file) {
+		return
+	}
+
+	// 在下載開始前確認目的地還有足夠的空間，避免上傳到一半才收到 Drive 的錯誤
+	quota, err := loadQuota(ctx, dest, userID)
 	if err != nil {
-		log.Printf("Failed to download file: %v", err)
-		replyToUser(message.Chat.ID, message.MessageID, "無法下載檔案，請稍後再試。")
+		slog.WarnContext(ctx, "Failed to check storage quota", "error", err)
+	}
+	if quota != nil && quota.Remaining() >= 0 && quota.Remaining() < max(file.Size, 1) {
+		slog.WarnContext(ctx, "Storage quota exceeded", "used", quota.Used, "limit", quota.Limit, "file_size", file.Size)
+		replyToUser(ctx, notifyChatID, replyTo, fmt.Sprintf("您的 %s 空間不足（已使用 %s / %s），無法上傳這個 %s 的檔案。請清出空間後再重新傳送。",
+			dest.DisplayName(), formatSize(quota.Used), formatSize(quota.Limit), formatSize(file.Size)))
 		return
 	}
+
+	// 在下載開始前檢查使用者的上傳額度
+	if err := reserveUpload(ctx, userID, file.Size); err != nil {
+		var limitErr *rateLimitError
+		if errors.As(err, &limitErr) {
+			slog.WarnContext(ctx, "Upload rate limited", "reason", limitErr.Reason, "retry_at", limitErr.RetryAt)
+			replyToUser(ctx, notifyChatID, replyTo, rateLimitMessage(ctx, limitErr))
+			return
+		}
+		slog.ErrorContext(ctx, "Failed to check rate limit", "error", err)
+		replyToUser(ctx, notifyChatID, replyTo, "檢查上傳額度時發生錯誤，請稍後再試。")
+		return
+	}
+
+	// 記錄進行中的上傳，執行個體在上傳途中結束時可以重新開始
+	job := startUploadJob(ctx, message, file.Name, notifyChatID, replyTo)
+	defer job.finish(ctx)
+
+	// 4. 從 Telegram 下載檔案，設定 MTProto 時先以 MTProto 下載；由訊息內容產生的檔案不需要下載
+	resp := &telegramDownload{Body: io.NopCloser(bytes.NewReader(file.Content)), ContentLength: file.Size}
+	if file.Content == nil {
+		if download, ok := openMTProtoFile(ctx, file.ID); ok {
+			resp = download
+		} else {
+			_, span := startSpan(ctx, "telegram.get_file")
+			tgFile, err := getTelegramFile(ctx, file.ID)
+			endSpan(span, err)
+			if err != nil {
+				reportError(ctx, "Failed to get file URL", err)
+				replyToUser(ctx, notifyChatID, replyTo, "無法取得檔案，請稍後再試。")
+				return
+			}
+
+			// 下載與上傳是串流進行的，Telegram 下載的 span 會在 body 讀取完畢時結束
+			if resp, err = openTelegramFile(ctx, tgFile); err != nil {
+				reportError(ctx, "Failed to download file", err)
+				replyToUser(ctx, notifyChatID, replyTo, "無法下載檔案，請稍後再試。")
+				return
+			}
+		}
+	}
 	defer resp.Body.Close()
+	if resp.ContentLength > maxSize {
+		slog.WarnContext(ctx, "Content length exceeds the limit", "content_length", resp.ContentLength, "limit", maxSize)
+		replyToUser(ctx, notifyChatID, replyTo, fileTooLargeMessage(ctx, resp.ContentLength))
+		return
+	}
+
+	// 5. 讀取內容開頭判斷 MIME 類型，檔名沒有副檔名時依類型補上
+	content := bufio.NewReaderSize(resp.Body, mimeSniffLen)
+	head, _ := content.Peek(mimeSniffLen)
+	file.MimeType = detectMimeType(file.MimeType, file.Name, head)
+	file.Name = withMimeExtension(file.Name, file.MimeType)
+
+	// 開啟自動分類時先讀完整個檔案交給 Gemini 判斷類別，再從記憶體上傳
+	var source io.Reader = content
+	// 開啟 EXIF 整理時，從 JPEG 的檔頭讀取拍攝時間與位置；Telegram 壓縮過的照片沒有 EXIF
+	var exif *PhotoExif
+	if (settings.ExifDate || settings.ExifLocation) && file.MimeType == "image/jpeg" {
+		exif, source = readUploadExif(ctx, source)
+	}
+	var category string
+	if geminiService != nil && settings.AutoCategorize && archive == nil && tagFolder == "" {
+		// 下載的 body 已經限制了大小，超過時 ReadAll 會回傳 errFileTooLarge
+		data, err := io.ReadAll(source)
+		if errors.Is(err, errFileTooLarge) {
+			slog.WarnContext(ctx, "Stream exceeded the limit", "bytes_read", len(data), "limit", maxSize)
+			replyToUser(ctx, notifyChatID, replyTo, fileTooLargeMessage(ctx, 0))
+			return
+		}
+		if errors.Is(err, errDownloadStalled) {
+			slog.WarnContext(ctx, "Telegram download stalled", "bytes_read", len(data))
+			replyToUser(ctx, notifyChatID, replyTo, "從 Telegram 下載檔案逾時，請稍後重新傳送。")
+			return
+		}
+		if err != nil {
+			reportError(ctx, "Failed to download file", err)
+			replyToUser(ctx, notifyChatID, replyTo, "無法下載檔案，請稍後再試。")
+			return
+		}
+		// 分類失敗時照原本的資料夾範本上傳
+		if category, err = categorizeFile(ctx, file, message.Caption, data); err != nil {
+			reportError(ctx, "Failed to categorize file", err)
+		}
+		ctx = withLogAttrs(ctx, slog.String("category", category))
+		source = bytes.NewReader(data)
+	}
 
-	// 注意：這裡不再需要 Parents，因為檔案會直接上傳到使用者的 "My Drive"
-	driveFile := &drive.File{Name: fileName}
+	// 6. 依照範本決定檔名與目標資料夾，並上傳到目的地
+	name, ext := splitFileName(file.Name)
+	sender := userDisplayName(message.From)
+	if sender == "" {
+		// 頻道貼文只有作者署名（需在頻道設定中開啟）
+		sender = message.AuthorSignature
+	}
+	meta := &UploadMeta{
+		Name:      name,
+		Ext:       ext,
+		Size:      file.Size,
+		Type:      file.Type,
+		Sender:    sender,
+		Chat:      chatDisplayName(message.Chat),
+		Topic:     forumTopicName(ctx, message.Chat.ID),
+		Caption:   message.Caption,
+		Date:      time.Unix(int64(message.Date), 0),
+		CreatedAt: time.Now(),
+	}
+	// 匯入的檔案以原始訊息的時間命名
+	if importing(ctx) {
+		meta.Date = originalDate(message)
+	}
+	// 轉傳或補傳的舊照片以拍攝時間命名與分資料夾，比訊息時間更準確
+	if settings.ExifDate && exif != nil && !exif.TakenAt.IsZero() {
+		meta.Date = exif.TakenAt
+	}
+
+	// 開啟縮小大圖時，長邊超過設定的圖片在上傳前縮小
+	if settings.ImageMaxDimension > 0 && resizableMimeType(file.MimeType) {
+		source = resizeImage(ctx, source, file.MimeType, settings.ImageMaxDimension, imageQuality(settings))
+	}
 
-	_, err = driveService.Files.Create(driveFile).Media(resp.Body).Do()
+	folders := renderFolderPath(profile.FolderTemplate, meta)
+	if category != "" && category != categoryOther {
+		folders = renderFolderPath(categoryFolder(settings, category), meta)
+	}
+
+	body := newLimitedReader(source, maxSize)
+	upload := &UploadFile{
+		Name:     renderFileName(profile.FilenameTemplate, meta),
+		Folders:  folders,
+		Body:     body,
+		MimeType: file.MimeType,
+		Convert:  profile.Convert,
+		Caption:  message.Caption,
+		Origin:   forwardOrigin(message),
+		Source: &TelegramSource{
+			ChatID:       message.Chat.ID,
+			MessageID:    message.MessageID,
+			Sender:       sender,
+			FileUniqueID: file.UniqueID,
+		},
+		Tags: tags,
+	}
+	if settings.ExifLocation && exif != nil {
+		upload.Location = exif.Location()
+	}
+	// 上傳後的處理步驟需要檔案內容時，串流上傳的同時保留一份
+	var captured *bytes.Buffer
+	if wantsContent(settings, file) {
+		captured = &bytes.Buffer{}
+		upload.Body = io.TeeReader(body, captured)
+	}
+	spanCtx, span := startSpan(ctx, "destination.upload")
+	var (
+		result *UploadResult
+		parts  int
+	)
+	if partSize := configFor(ctx).splitPartSize(); partSize > 0 && file.Size > partSize {
+		result, parts, err = uploadInParts(spanCtx, dest, userID, upload, partSize)
+	} else {
+		result, err = uploadTo(spanCtx, dest, userID, upload)
+	}
+	span.SetAttributes(attribute.String("destination", dest.Name()), attribute.Int64("file.size", body.BytesRead()))
+	endSpan(span, err)
+	event := &UploadEvent{
+		UserID:      userID,
+		ChatID:      message.Chat.ID,
+		Chat:        meta.Chat,
+		Destination: dest.Name(),
+		FileName:    upload.Name,
+		MimeType:    file.MimeType,
+		Size:        body.BytesRead(),
+	}
+	// 下載的 body 也有同樣的上限，先超過的一方回傳的 errFileTooLarge 可能經由目的地的錯誤傳回來
+	if body.Exceeded() || errors.Is(err, errFileTooLarge) {
+		slog.WarnContext(ctx, "Stream exceeded the limit", "bytes_read", body.BytesRead(), "limit", maxSize)
+		replyToUser(ctx, notifyChatID, replyTo, fileTooLargeMessage(ctx, 0))
+		event.Event, event.Error = eventUploadFailed, errFileTooLarge.Error()
+		emitUploadEvent(ctx, settings, event)
+		return
+	}
+	if errors.Is(err, errDownloadStalled) {
+		slog.WarnContext(ctx, "Telegram download stalled", "bytes_read", body.BytesRead())
+		replyToUser(ctx, notifyChatID, replyTo, "從 Telegram 下載檔案逾時，請稍後重新傳送。")
+		event.Event, event.Error = eventUploadFailed, err.Error()
+		emitUploadEvent(ctx, settings, event)
+		return
+	}
 	if err != nil {
-		log.Printf("Failed to upload to Drive for user %d: %v", userID, err)
-		replyToUser(message.Chat.ID, message.MessageID, "上傳到您的 Google Drive 失敗。")
+		event.Event, event.Error = eventUploadFailed, err.Error()
+		emitUploadEvent(ctx, settings, event)
+		reportError(ctx, "Failed to upload to destination", err, "destination", dest.Name())
+		replyToUser(ctx, notifyChatID, replyTo, uploadFailedMessage(ctx, dest, userID, err, fmt.Sprintf("上傳到您的 %s 失敗。", dest.DisplayName())))
 		return
 	}
+	meta.Size = body.BytesRead()
+	markImported(ctx, meta.Size)
+	// 試運行時沒有實際上傳，不記錄用量與上傳紀錄，也不執行後續處理
+	if dryRun(ctx) {
+		if !profile.Silent {
+			replyToUser(ctx, notifyChatID, replyTo, dryRunReply(ctx, fmt.Sprintf("檔案 '%s'（%s）會上傳到您的 %s 的「%s」，試運行模式不會實際寫入。",
+				result.Name, formatSize(meta.Size), dest.DisplayName(), strings.Join(append(folders, result.Name), "/"))))
+		}
+		return
+	}
+	addQuotaUsage(ctx, dest, userID, meta.Size)
+	if err := recordUploadBytes(ctx, userID, meta.Size); err != nil {
+		slog.ErrorContext(ctx, "Failed to record upload bytes", "error", err)
+	}
+	if err := recordUploadStats(ctx, meta.Size); err != nil {
+		slog.ErrorContext(ctx, "Failed to record upload stats", "error", err)
+	}
+	if err := recordUserUsage(ctx, userID, result.Name, meta.Size); err != nil {
+		slog.ErrorContext(ctx, "Failed to record user usage", "error", err)
+	}
+
+	// 記錄最後一次上傳的檔案資訊，供範本預覽使用
+	if err := updateUserSettings(ctx, userID, map[string]interface{}{"last_upload": meta}); err != nil {
+		slog.ErrorContext(ctx, "Failed to record last upload", "error", err)
+	}
 
-	log.Printf("Successfully uploaded file '%s' to Drive for user %d.", fileName, userID)
-	replyToUser(message.Chat.ID, message.MessageID, fmt.Sprintf("檔案 '%s' 已成功上傳到您的 Google Drive！", fileName))
+	slog.InfoContext(ctx, "Successfully uploaded file", "file_name", result.Name, "file_id", result.FileID, "size", meta.Size)
+	record := &UploadRecord{
+		UserID:               userID,
+		ChatID:               message.Chat.ID,
+		MessageID:            message.MessageID,
+		Destination:          dest.Name(),
+		TelegramFileUniqueID: file.UniqueID,
+		TelegramFileID:       file.ID,
+		MimeType:             file.MimeType,
+		Account:              result.Account,
+		FileID:               result.FileID,
+		Name:                 result.Name,
+		Link:                 result.Link,
+		Size:                 meta.Size,
+		Meta:                 meta,
+		FilenameTemplate:     profile.FilenameTemplate,
+		CreatedAt:            time.Now(),
+	}
+	if profile.RetentionDays > 0 {
+		record.TrashAt = record.CreatedAt.AddDate(0, 0, profile.RetentionDays)
+	}
+	if !profile.Silent {
+		text := fmt.Sprintf("檔案 '%s'（%s）已成功上傳到您的 %s！", result.Name, formatSize(meta.Size), dest.DisplayName())
+		if parts > 0 {
+			text = fmt.Sprintf("檔案 '%s'（%s）已分割成 %d 個部分上傳到您的 %s，合併方式記錄在 '%s'，也可以使用 /join 查看。",
+				upload.Name, formatSize(meta.Size), parts, dest.DisplayName(), result.Name)
+		}
+		if category != "" && category != categoryOther {
+			text += fmt.Sprintf("\n已自動分類到「%s」。", strings.Join(folders, "/"))
+		}
+		if quota != nil && quota.NearlyFull() {
+			text += fmt.Sprintf("\n⚠️ 您的 %s 空間即將用完（已使用 %s / %s），請盡快清出空間。", dest.DisplayName(), formatSize(quota.Used+meta.Size), formatSize(quota.Limit))
+		}
+		reply := tgbotapi.NewMessage(notifyChatID, text)
+		reply.ReplyToMessageID = replyTo
+		sent, err := sendMessage(ctx, reply)
+		if err != nil {
+			slog.ErrorContext(ctx, "could not send reply message", "error", err)
+		} else {
+			record.ReplyMessageID = sent.MessageID
+		}
+	}
+	if err := saveUploadRecord(ctx, record); err != nil {
+		slog.ErrorContext(ctx, "Failed to save upload record", "error", err)
+	}
+	event.Event = eventUploadCompleted
+	event.FileName, event.FileID, event.Link, event.Account = result.Name, result.FileID, result.Link, result.Account
+	emitUploadEvent(ctx, settings, event)
+
+	if captured != nil {
+		runPostProcessors(ctx, &uploadedFile{
+			UserID:       userID,
+			Dest:         dest,
+			Settings:     settings,
+			File:         file,
+			Upload:       upload,
+			Result:       result,
+			Content:      captured.Bytes(),
+			NotifyChatID: notifyChatID,
+			ReplyTo:      replyTo,
+			Silent:       profile.Silent,
+		})
+	}
 }
 
 // --- Webhook 和主函式 ---
 func webhookHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := withLogAttrs(r.Context(), slog.String("request_id", newRequestID()))
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not read incoming update", "error", err)
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
 	var update tgbotapi.Update
-	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
-		log.Printf("could not decode incoming update: %v", err)
+	if err := json.Unmarshal(body, &update); err != nil {
+		slog.ErrorContext(ctx, "could not decode incoming update", "error", err)
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
+	ctx = withLogAttrs(ctx, slog.Int("update_id", update.UpdateID))
+
+	// Telegram 在回應太慢時會重送同一個更新，已處理過的更新直接略過，避免重複上傳
+	// 若 Firestore 暫時無法使用，寧可處理也不要遺漏使用者的訊息
+	// inline 查詢只是唯讀的搜尋，重複回應也無妨，不必在每次輸入時都寫入一筆紀錄
+	if update.InlineQuery == nil {
+		first, err := claimUpdate(ctx, update.UpdateID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to record processed update", "error", err)
+		} else if !first {
+			slog.InfoContext(ctx, "Skipping duplicate update")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
 
-	if update.Message == nil {
-		w.WriteHeader(http.StatusOK)
-		return
+	handleUpdate(ctx, &update, body)
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleMessage 處理一般訊息：指令、檔案與文字
+// body 是原始的 update，用來解析 telegram-bot-api 尚未支援的欄位
+func handleMessage(ctx context.Context, message *tgbotapi.Message, body []byte) {
+	// 論壇主題中的訊息要回覆到同一個主題
+	if topic := parseForumTopic(body); topic != nil {
+		ctx = withLogAttrs(withForumTopic(ctx, topic), slog.Int("thread_id", topic.ThreadID))
+		if topic.Name != "" {
+			rememberForumTopic(ctx, message.Chat.ID, topic)
+		}
 	}
 
-	if update.Message.IsCommand() {
-		switch update.Message.Command() {
-		case "start":
-			replyToUser(update.Message.Chat.ID, update.Message.MessageID, "歡迎使用！請使用 /connect_drive 來授權 Google Drive。")
-		case "connect_drive":
-			handleConnectDrive(update.Message)
-		default:
-			replyToUser(update.Message.Chat.ID, update.Message.MessageID, "無法辨識的指令。")
+	if message.IsCommand() {
+		dispatchCommand(ctx, message)
+	} else if messageFile(message) != nil {
+		if !handleZipFile(ctx, message) && !handleImportFile(ctx, message) {
+			handleFile(ctx, message)
 		}
-	} else if update.Message.Document != nil || len(update.Message.Photo) > 0 {
-		handleFile(update.Message)
-	} else {
-		replyToUser(update.Message.Chat.ID, update.Message.MessageID, "請傳送檔案或使用 /connect_drive 指令。")
+	} else if handleConversationMessage(ctx, message) {
+		// 訊息已由進行中的對話處理
+	} else if handleLinkMedia(ctx, message) {
+		// 社群連結中的媒體已在背景下載
+	} else if handleWebArchive(ctx, message) {
+		// 網頁已在背景存成 PDF
+	} else if handlePollMessage(ctx, message) {
+		// 封存群組中的投票，結束時會存下結果
+	} else if handleAutoNote(ctx, message) {
+		// 已開啟自動筆記，文字訊息已存成筆記
+	} else if reply, ok := unsupportedContentMessage(message); ok {
+		slog.InfoContext(ctx, "Received unsupported content", "content_type", messageContentType(message))
+		replyToUser(ctx, message.Chat.ID, message.MessageID, reply)
 	}
 
-	w.WriteHeader(http.StatusOK)
 }
 
 func main() {
 	ctx := context.Background()
-	log.Println("Starting bot application with OAuth flow...")
+	initLogger()
+	slog.Info("Starting bot application with OAuth flow...")
 
-	var err error
-	bot, err = tgbotapi.NewBotAPI(os.Getenv("TELEGRAM_BOT_TOKEN"))
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to an optional YAML config file")
+	flag.Parse()
+	cfg, err := loadConfig(*configPath)
 	if err != nil {
-		log.Fatalf("FATAL: Failed to create bot API: %v", err)
+		fatal("Failed to load config", err)
+	}
+	if err := loadSecrets(ctx, cfg); err != nil {
+		fatal("Failed to load secrets", err)
+	}
+	if err := cfg.validate(); err != nil {
+		fatal("Invalid config", err)
 	}
 
-	if err := initFirestore(ctx); err != nil {
-		log.Fatalf("FATAL: Failed to initialize Firestore: %v", err)
+	if err := initTenants(cfg); err != nil {
+		fatal("Failed to initialize bots", err)
+	}
+	if cfg.DryRun {
+		slog.Warn("Dry run enabled, uploads will not be written to destinations")
 	}
 
-	if err := initOAuth2Config(); err != nil {
-		log.Fatalf("FATAL: Failed to initialize OAuth2 config: %v", err)
+	if err := initStore(ctx, cfg); err != nil {
+		fatal("Failed to initialize storage", err)
+	}
+	if err := initRedis(ctx, cfg.RedisURL); err != nil {
+		fatal("Failed to initialize Redis", err)
+	}
+	if err := initLinkMedia(cfg); err != nil {
+		fatal("Failed to initialize link media", err)
+	}
+	initWebArchive(cfg)
+	if cfg.FirestoreTTLSetup {
+		go setupTTLPolicies(ctx)
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	registerDestination(driveDestination{})
+	if dropbox, ok := newDropboxDestination(cfg.Dropbox); ok {
+		registerDestination(dropbox)
+	}
+	if oneDrive, ok := newOneDriveDestination(cfg.OneDrive); ok {
+		registerDestination(oneDrive)
+	}
+	s3, ok, err := newS3Destination(cfg.S3)
+	if err != nil {
+		fatal("Failed to initialize S3 destination", err)
+	}
+	if ok {
+		registerDestination(s3)
+	}
+	if cfg.GooglePhotos {
+		registerDestination(photosDestination{})
+	}
+	// WebDAV 密碼需要加密儲存，未設定金鑰時不啟用；金鑰的格式已在 validate 中檢查
+	if cfg.CredentialsEncryptionKey != "" {
+		registerDestination(webDAVDestination{})
+		conversationHandlers["connect_webdav"] = continueConnectWebDAV
+	}
+	if err := checkForcedDestination(cfg.ForceDestination); err != nil {
+		fatal("Failed to initialize destinations", err)
 	}
 
+	registerCommands()
+
+	// 內嵌按鈕與其他非訊息更新的處理函式
+	registerCallback("accounts", handleAccountsCallback)
+	registerCallback("upload", handleUploadCallback)
+	registerCallback("pick", handlePickCallback)
+	registerCallback("settings", handleSettingsCallback)
+	registerCallback("forget", handleForgetMeCallback)
+	registerCallback("get", handleGetCallback)
+	registerCallback("youtube", handleYouTubeCallback)
+	registerCallback("gmail", handleGmailCallback)
+	registerCallback("trash", handleTrashCallback(true))
+	registerCallback("restore", handleTrashCallback(false))
+	conversationHandlers["settings_template"] = continueSettingsTemplate
+	onEditedMessage(handleEditedMedia)
+	onMyChatMember(handleBotMembership)
+
+	// 指令清單只影響用戶端的自動完成，更新失敗時仍繼續啟動
+	for _, t := range tenants {
+		if err := syncBotCommands(withTenant(ctx, t)); err != nil {
+			slog.WarnContext(ctx, "Failed to sync bot commands", "bot_id", t.ID, "error", err)
+		}
+	}
+
+	initLine(cfg.Line)
+	initDiscord(cfg.Discord)
+	initTokenCache(cfg.TokenCache)
+	if err := initOCR(ctx, cfg.AI); err != nil {
+		fatal("Failed to initialize OCR", err)
+	}
+	if err := initTranscription(ctx, cfg.AI); err != nil {
+		fatal("Failed to initialize transcription", err)
+	}
+	if err := initGemini(ctx, cfg.AI); err != nil {
+		fatal("Failed to initialize Gemini", err)
+	}
+
+	shutdownTracing, err := initTracing(ctx, cfg.GCPProjectID, cfg.Tracing)
+	if err != nil {
+		fatal("Failed to initialize tracing", err)
+	}
+	flushErrorReports, err := initErrorReporting(cfg.ErrorReporting)
+	if err != nil {
+		fatal("Failed to initialize error reporting", err)
+	}
+
+	port := cfg.Port
+
 	// 新增 /oauth/callback 路由
-	http.HandleFunc("/oauth/callback", oauthCallbackHandler)
-	// Telegram Webhook 路由
-	http.HandleFunc("/", webhookHandler)
+	http.Handle("/oauth/callback", otelhttp.NewHandler(http.HandlerFunc(oauthCallbackHandler), "oauth.callback"))
+	// Cloud Scheduler 觸發的排程路由
+	http.Handle("/cron/digest", otelhttp.NewHandler(http.HandlerFunc(cronDigestHandler), "cron.digest"))
+	http.Handle("/cron/cleanup", otelhttp.NewHandler(http.HandlerFunc(cronCleanupHandler), "cron.cleanup"))
+	http.Handle("/cron/resume_uploads", otelhttp.NewHandler(http.HandlerFunc(cronResumeUploadsHandler), "cron.resume_uploads"))
+	http.Handle("/cron/retention", otelhttp.NewHandler(http.HandlerFunc(cronRetentionHandler), "cron.retention"))
+	// 管理 API 路由
+	http.Handle("/api/v1/", otelhttp.NewHandler(apiHandler(), "api"))
+	// 網頁版路由
+	http.Handle("/web/", otelhttp.NewHandler(webHandler(), "web"))
+	// LINE Messaging API 的 webhook 路由
+	http.Handle("/line/webhook", otelhttp.NewHandler(http.HandlerFunc(lineWebhookHandler), "line.webhook"))
+	// 郵件上傳的轉送路由
+	http.Handle("/email/inbound/{secret}", otelhttp.NewHandler(http.HandlerFunc(inboundEmailHandler), "email.inbound"))
+	// 檔案上傳連結的網頁
+	http.Handle("GET /upload/{token}", otelhttp.NewHandler(http.HandlerFunc(fileRequestPageHandler), "upload.page"))
+	http.Handle("POST /upload/{token}", otelhttp.NewHandler(http.HandlerFunc(fileRequestUploadHandler), "upload.file"))
+	// 健康檢查路由
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
+	// Telegram Webhook 路由；其他機器人的 webhook 設定在 /webhook/<機器人 ID>
+	http.Handle("/webhook/{botID}", otelhttp.NewHandler(http.HandlerFunc(tenantWebhookHandler), "telegram.webhook"))
+	http.Handle("/", otelhttp.NewHandler(http.HandlerFunc(webhookHandler), "telegram.webhook"))
+
+	server := &http.Server{Addr: ":" + port}
+	go func() {
+		// Cloud Run 在停止執行個體前會送出 SIGTERM
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGTERM, os.Interrupt)
+		<-sigCh
+		slog.Info("Shutting down server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	// 重新上傳前一個執行個體中斷的上傳
+	go resumeAllUploadJobs(ctx)
+	go resumeAllMigrations(ctx)
+	// Discord 的訊息透過 Gateway 連線接收
+	if discord != nil {
+		go runDiscord(ctx)
+	}
+
+	slog.Info("Server starting", "port", port)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fatal("failed to start server", err)
+	}
 
-	log.Printf("Server starting on port %s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatalf("FATAL: failed to start server: %v", err)
+	// 送出尚未匯出的 span
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
 	}
+	flushErrorReports(5 * time.Second)
+}
+
+// userDisplayName 回傳使用者的顯示名稱，優先使用 username
+func userDisplayName(user *tgbotapi.User) string {
+	if user == nil {
+		return ""
+	}
+	if user.UserName != "" {
+		return user.UserName
+	}
+	return strings.TrimSpace(user.FirstName + " " + user.LastName)
+}
+
+// chatDisplayName 回傳聊天室的顯示名稱，私人對話則使用對方的名稱
+func chatDisplayName(chat *tgbotapi.Chat) string {
+	if chat == nil {
+		return ""
+	}
+	if chat.Title != "" {
+		return chat.Title
+	}
+	if chat.UserName != "" {
+		return chat.UserName
+	}
+	return strings.TrimSpace(chat.FirstName + " " + chat.LastName)
+}
+
+// fatal 記錄錯誤後結束程式
+func fatal(msg string, err error) {
+	slog.Error("FATAL: "+msg, "error", err)
+	os.Exit(1)
 }
 
-func replyToUser(chatID int64, replyToMessageID int, text string) {
+func replyToUser(ctx context.Context, chatID int64, replyToMessageID int, text string) {
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ReplyToMessageID = replyToMessageID
-	if _, err := bot.Send(msg); err != nil {
-		log.Printf("ERROR: could not send reply message: %v", err)
+	if _, err := sendMessage(ctx, msg); err != nil {
+		slog.ErrorContext(ctx, "could not send reply message", "error", err)
 	}
 }
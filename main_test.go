@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+func TestOAuthCallback(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      func(state string) url.Values
+		wantStatus int
+		wantToken  bool
+		wantNotice string // 私訊給使用者的訊息需包含的文字
+	}{
+		{
+			name:       "success",
+			query:      func(state string) url.Values { return url.Values{"state": {state}, "code": {validAuthCode}} },
+			wantStatus: http.StatusOK,
+			wantToken:  true,
+		},
+		{
+			name:       "invalid code",
+			query:      func(state string) url.Values { return url.Values{"state": {state}, "code": {"bad-code"}} },
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "access denied",
+			query:      func(state string) url.Values { return url.Values{"state": {state}, "error": {"access_denied"}} },
+			wantStatus: http.StatusOK,
+			wantNotice: "已取消連結 Google Drive",
+		},
+		{
+			name:       "unknown state",
+			query:      func(string) url.Values { return url.Values{"state": {"123456:forged"}, "code": {validAuthCode}} },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "state without bot",
+			query:      func(string) url.Values { return url.Values{"state": {"forged"}, "code": {validAuthCode}} },
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			userID := int64(7000 + i)
+			ctx := withTenant(t.Context(), env.tenant)
+			state, _, err := newOAuthState(ctx, userID, "drive")
+			if err != nil {
+				t.Fatalf("newOAuthState: %v", err)
+			}
+
+			w := httptest.NewRecorder()
+			oauthCallbackHandler(w, httptest.NewRequest(http.MethodGet, "/oauth/callback?"+tt.query(state).Encode(), nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			connected, err := hasUserToken(ctx, tokenCollection, userID)
+			if err != nil {
+				t.Fatalf("hasUserToken: %v", err)
+			}
+			if connected != tt.wantToken {
+				t.Errorf("connected = %v, want %v", connected, tt.wantToken)
+			}
+			if tt.wantNotice != "" && !slices.ContainsFunc(env.telegram.messages(userID), func(m string) bool { return strings.Contains(m, tt.wantNotice) }) {
+				t.Errorf("messages = %q, want one containing %q", env.telegram.messages(userID), tt.wantNotice)
+			}
+		})
+	}
+}
+
+func TestOAuthCallbackStateIsSingleUse(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := withTenant(t.Context(), env.tenant)
+	state, _, err := newOAuthState(ctx, 7100, "drive")
+	if err != nil {
+		t.Fatalf("newOAuthState: %v", err)
+	}
+	target := "/oauth/callback?" + url.Values{"state": {state}, "code": {validAuthCode}}.Encode()
+
+	for _, want := range []int{http.StatusOK, http.StatusBadRequest} {
+		w := httptest.NewRecorder()
+		oauthCallbackHandler(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != want {
+			t.Errorf("status = %d, want %d", w.Code, want)
+		}
+	}
+}
+
+// postUpdate 將 update 送到 webhook，與 Telegram 呼叫 webhook 的方式相同
+func postUpdate(t *testing.T, update map[string]interface{}) {
+	t.Helper()
+	body, err := json.Marshal(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	webhookHandler(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("webhook status = %d", w.Code)
+	}
+}
+
+// documentUpdate 產生使用者在私人對話中傳送文件的 update
+func documentUpdate(updateID int, userID int64, fileID, fileName string, size int) map[string]interface{} {
+	return map[string]interface{}{
+		"update_id": updateID,
+		"message": map[string]interface{}{
+			"message_id": updateID,
+			"date":       time.Now().Unix(),
+			"from":       map[string]interface{}{"id": userID, "is_bot": false, "first_name": "Tester"},
+			"chat":       map[string]interface{}{"id": userID, "type": "private"},
+			"document": map[string]interface{}{
+				"file_id":        fileID,
+				"file_unique_id": "u-" + fileID,
+				"file_name":      fileName,
+				"mime_type":      "application/pdf",
+				"file_size":      size,
+			},
+		},
+	}
+}
+
+func TestUploadFlow(t *testing.T) {
+	env := newTestEnv(t)
+	const userID = 7200
+	env.connectDrive(t, userID)
+	content := []byte("%PDF-1.4 test document")
+	env.telegram.addFile("doc-1", content)
+
+	postUpdate(t, documentUpdate(1, userID, "doc-1", "report.pdf", len(content)))
+
+	uploads := env.google.uploaded()
+	if len(uploads) != 1 {
+		t.Fatalf("uploaded %d files, want 1", len(uploads))
+	}
+	if uploads[0].Name != "report.pdf" || !bytes.Equal(uploads[0].Content, content) {
+		t.Errorf("uploaded %q with %q, want report.pdf with %q", uploads[0].Name, uploads[0].Content, content)
+	}
+	messages := env.telegram.messages(userID)
+	if !slices.ContainsFunc(messages, func(m string) bool { return strings.Contains(m, "已成功上傳到您的 Google Drive") }) {
+		t.Errorf("messages = %q, want a success reply", messages)
+	}
+
+	ctx := withTenant(t.Context(), env.tenant)
+	record, err := findUploadByFile(ctx, userID, "drive", "u-doc-1")
+	if err != nil {
+		t.Fatalf("findUploadByFile: %v", err)
+	}
+	if record == nil || record.Name != "report.pdf" || record.Size != int64(len(content)) {
+		t.Errorf("upload record = %+v", record)
+	}
+
+	// Telegram 重送同一個 update 時不會再上傳一次
+	postUpdate(t, documentUpdate(1, userID, "doc-1", "report.pdf", len(content)))
+	if n := len(env.google.uploaded()); n != 1 {
+		t.Errorf("uploaded %d files after a redelivered update, want 1", n)
+	}
+}
+
+func TestUploadFlowNotConnected(t *testing.T) {
+	env := newTestEnv(t)
+	const userID = 7300
+	env.telegram.addFile("doc-2", []byte("data"))
+
+	postUpdate(t, documentUpdate(2, userID, "doc-2", "notes.pdf", 4))
+
+	if n := len(env.google.uploaded()); n != 0 {
+		t.Errorf("uploaded %d files, want 0", n)
+	}
+	messages := env.telegram.messages(userID)
+	if !slices.ContainsFunc(messages, func(m string) bool { return strings.Contains(m, "/connect_drive") }) {
+		t.Errorf("messages = %q, want a hint to run /connect_drive", messages)
+	}
+}
+
+// documentMessage 產生使用者在私人對話中傳送文件的訊息
+func documentMessage(userID int64, fileID, fileName string, size int) *tgbotapi.Message {
+	return &tgbotapi.Message{
+		MessageID: 1,
+		Date:      int(time.Now().Unix()),
+		From:      &tgbotapi.User{ID: userID, FirstName: "Tester"},
+		Chat:      &tgbotapi.Chat{ID: userID, Type: "private"},
+		Document: &tgbotapi.Document{
+			FileID:       fileID,
+			FileUniqueID: "u-" + fileID,
+			FileName:     fileName,
+			MimeType:     "application/pdf",
+			FileSize:     size,
+		},
+	}
+}
+
+func TestHandleFile(t *testing.T) {
+	content := []byte("%PDF-1.4 test document")
+	tests := []struct {
+		name        string
+		setup       func(env *handlerEnv)
+		notLinked   bool
+		size        int // 訊息中宣告的檔案大小
+		wantReply   string
+		wantUploads int
+	}{
+		{
+			name:        "success",
+			size:        len(content),
+			wantReply:   "已成功上傳到您的 Google Drive",
+			wantUploads: 1,
+		},
+		{
+			name:      "not connected",
+			notLinked: true,
+			size:      len(content),
+			wantReply: "請使用 /connect_drive 指令",
+		},
+		{
+			name:      "declared size too large",
+			size:      cloudMaxFileSizeMB<<20 + 1,
+			wantReply: "已超過機器人",
+		},
+		{
+			name:      "get file fails",
+			setup:     func(env *handlerEnv) { env.files.getFileErr = errors.New("telegram is down") },
+			size:      len(content),
+			wantReply: "無法取得檔案",
+		},
+		{
+			name:      "download fails",
+			setup:     func(env *handlerEnv) { env.files.missing["doc"] = true },
+			size:      len(content),
+			wantReply: "無法下載檔案",
+		},
+		{
+			name: "downloads with mtproto",
+			setup: func(env *handlerEnv) {
+				env.useMTProto(&fakeMTProto{files: map[string][]byte{"doc": content}})
+				env.files.getFileErr = errors.New("bot api should not be used")
+			},
+			size:        len(content),
+			wantReply:   "已成功上傳到您的 Google Drive",
+			wantUploads: 1,
+		},
+		{
+			name:        "mtproto allows files over the bot api limit",
+			setup:       func(env *handlerEnv) { env.useMTProto(&fakeMTProto{files: map[string][]byte{"doc": content}}) },
+			size:        cloudMaxFileSizeMB<<20 + 1,
+			wantReply:   "已成功上傳到您的 Google Drive",
+			wantUploads: 1,
+		},
+		{
+			name:        "mtproto fails and falls back to the bot api",
+			setup:       func(env *handlerEnv) { env.useMTProto(&fakeMTProto{openErr: errors.New("FILE_REFERENCE_EXPIRED")}) },
+			size:        len(content),
+			wantReply:   "已成功上傳到您的 Google Drive",
+			wantUploads: 1,
+		},
+		{
+			name:      "storage full before download",
+			setup:     func(env *handlerEnv) { env.drive.quota = &drive.AboutStorageQuota{Usage: 100, Limit: 100} },
+			size:      len(content),
+			wantReply: "空間不足",
+		},
+		{
+			name: "drive rejects upload",
+			setup: func(env *handlerEnv) {
+				env.drive.createErr = &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "storageQuotaExceeded"}}}
+			},
+			size:      len(content),
+			wantReply: "您的 Google Drive 空間已滿",
+		},
+		{
+			name:      "drive fails",
+			setup:     func(env *handlerEnv) { env.drive.createErr = errors.New("connection reset") },
+			size:      len(content),
+			wantReply: "上傳到您的 Google Drive 失敗",
+		},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newHandlerEnv(t)
+			userID := int64(7400 + i)
+			if !tt.notLinked {
+				env.connectDrive(t, userID)
+			}
+			env.files.addFile("doc", content)
+			if tt.setup != nil {
+				tt.setup(env)
+			}
+
+			handleFile(env.ctx, documentMessage(userID, "doc", "report.pdf", tt.size))
+
+			messages := env.sender.messages(userID)
+			if !slices.ContainsFunc(messages, func(m string) bool { return strings.Contains(m, tt.wantReply) }) {
+				t.Errorf("messages = %q, want one containing %q", messages, tt.wantReply)
+			}
+			if n := len(env.drive.uploaded()); n != tt.wantUploads {
+				t.Errorf("uploaded %d files, want %d", n, tt.wantUploads)
+			}
+		})
+	}
+}
+
+func TestHandleFileStreamTooLarge(t *testing.T) {
+	env := newHandlerEnv(t)
+	const userID = 7500
+	env.connectDrive(t, userID)
+	env.config.MaxFileSizeMB = 1
+	env.files.addFile("big", bytes.Repeat([]byte("x"), 1<<20+64))
+
+	// 訊息沒有宣告大小時，超過上限要在串流時才會發現
+	handleFile(env.ctx, documentMessage(userID, "big", "big.pdf", 0))
+
+	if n := len(env.drive.uploaded()); n != 0 {
+		t.Errorf("uploaded %d files, want 0", n)
+	}
+	messages := env.sender.messages(userID)
+	if !slices.ContainsFunc(messages, func(m string) bool { return strings.Contains(m, "已超過機器人") }) {
+		t.Errorf("messages = %q, want a file too large reply", messages)
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 媒體類型 ---
+
+// telegramFile 是訊息中要上傳的檔案
+type telegramFile struct {
+	ID       string
+	UniqueID string
+	Name     string
+	Type     string // 內容類型，同時是檔名範本中的 {type}
+	Size     int64  // Telegram 提供的檔案大小，未知時為 0
+	MimeType string // Telegram 提供的 MIME 類型，未知時為空字串
+	Duration int    // 語音訊息的長度（秒），其他類型為 0
+	Content  []byte // 由訊息內容產生的檔案（聯絡人、位置），直接上傳而不從 Telegram 下載；ID 為空字串
+}
+
+// messageFile 取出訊息中可以上傳的檔案，沒有時回傳 nil
+func messageFile(message *tgbotapi.Message) *telegramFile {
+	switch {
+	// GIF 動畫的訊息為了相容舊版用戶端也會帶有 Document，因此要先檢查 Animation
+	case message.Animation != nil:
+		a := message.Animation
+		return &telegramFile{ID: a.FileID, UniqueID: a.FileUniqueID, Name: animationFileName(a), Type: "animation", Size: int64(a.FileSize), MimeType: "video/mp4"}
+	case message.Document != nil:
+		d := message.Document
+		name := d.FileName
+		if name == "" {
+			// 部分用戶端傳送的檔案沒有檔名，上傳時會依 MIME 類型補上副檔名
+			name = d.FileID
+		}
+		return &telegramFile{ID: d.FileID, UniqueID: d.FileUniqueID, Name: name, Type: "document", Size: int64(d.FileSize), MimeType: d.MimeType}
+	case len(message.Photo) > 0:
+		// 預設取最大的尺寸，上傳時會再依使用者設定的 PhotoSize 選擇
+		return photoFile(selectPhotoSize(message.Photo, photoSizeLargest))
+	case message.Sticker != nil:
+		s := message.Sticker
+		// 實際的格式要查詢檔案路徑才能確定，見 stickerExt
+		return &telegramFile{ID: s.FileID, UniqueID: s.FileUniqueID, Name: stickerFileName(s, guessStickerExt(s)), Type: "sticker", Size: int64(s.FileSize)}
+	case message.VideoNote != nil:
+		v := message.VideoNote
+		// 圓形影片沒有檔名，內容一律是 MP4
+		return &telegramFile{ID: v.FileID, UniqueID: v.FileUniqueID, Name: v.FileID + ".mp4", Type: "video_note", Size: int64(v.FileSize), MimeType: "video/mp4"}
+	case message.Voice != nil:
+		v := message.Voice
+		// 語音訊息是 Opus 編碼的 OGG
+		mimeType := v.MimeType
+		if mimeType == "" {
+			mimeType = "audio/ogg"
+		}
+		return &telegramFile{ID: v.FileID, UniqueID: v.FileUniqueID, Name: v.FileID + ".ogg", Type: "voice", Size: int64(v.FileSize), MimeType: mimeType, Duration: v.Duration}
+	case message.Contact != nil:
+		return contactFile(message.Contact)
+	// 地點訊息同時帶有 Location，gpxFile 會優先使用 Venue
+	case message.Location != nil:
+		return gpxFile(message)
+	}
+	return nil
+}
+
+// 可以上傳的內容類型，與 messageFile 支援的類型一致
+const supportedContentTypes = "文件、照片、GIF 動畫、貼圖、圓形影片、語音訊息、聯絡人與位置"
+
+// 無法上傳的內容類型，依 messageContentType 回傳的種類索引
+var unsupportedContentLabels = map[string]string{
+	"text":    "文字訊息",
+	"video":   "影片",
+	"audio":   "音樂",
+	"poll":    "投票",
+	"dice":    "骰子",
+	"game":    "遊戲",
+	"invoice": "帳單",
+	"unknown": "這種訊息",
+}
+
+// messageContentType 判斷 messageFile 無法處理的訊息內容；系統訊息（成員加入、置頂等）回傳空字串
+func messageContentType(message *tgbotapi.Message) string {
+	switch {
+	case message.Video != nil:
+		return "video"
+	case message.Audio != nil:
+		return "audio"
+	case message.Poll != nil:
+		return "poll"
+	case message.Dice != nil:
+		return "dice"
+	case message.Game != nil:
+		return "game"
+	case message.Invoice != nil:
+		return "invoice"
+	case message.Text != "":
+		return "text"
+	case isServiceMessage(message):
+		return ""
+	}
+	return "unknown"
+}
+
+// isServiceMessage 判斷是否為 Telegram 自動產生的系統訊息，這些訊息不需要回覆
+func isServiceMessage(message *tgbotapi.Message) bool {
+	return len(message.NewChatMembers) > 0 || message.LeftChatMember != nil ||
+		message.NewChatTitle != "" || len(message.NewChatPhoto) > 0 || message.DeleteChatPhoto ||
+		message.GroupChatCreated || message.SuperGroupChatCreated || message.ChannelChatCreated ||
+		message.MessageAutoDeleteTimerChanged != nil || message.MigrateToChatID != 0 || message.MigrateFromChatID != 0 ||
+		message.PinnedMessage != nil || message.SuccessfulPayment != nil || message.ConnectedWebsite != "" ||
+		message.PassportData != nil || message.ProximityAlertTriggered != nil ||
+		message.VoiceChatScheduled != nil || message.VoiceChatStarted != nil || message.VoiceChatEnded != nil ||
+		message.VoiceChatParticipantsInvited != nil
+}
+
+// unsupportedContentMessage 回傳說明收到的內容無法上傳的回覆，系統訊息回傳 false
+func unsupportedContentMessage(message *tgbotapi.Message) (string, bool) {
+	kind := messageContentType(message)
+	switch kind {
+	case "":
+		return "", false
+	case "text":
+		return fmt.Sprintf("收到的是文字訊息。請傳送要上傳的檔案（支援%s），或使用 /note 將文字存成筆記；尚未連結時請使用 /connect_drive 指令。", supportedContentTypes), true
+	case "video", "audio":
+		return fmt.Sprintf("收到的是%s，目前還不支援直接上傳，請改以「檔案」方式重新傳送。\n可以上傳的類型：%s。", unsupportedContentLabels[kind], supportedContentTypes), true
+	}
+	return fmt.Sprintf("收到的是%s，目前還不支援上傳。\n可以上傳的類型：%s。", unsupportedContentLabels[kind], supportedContentTypes), true
+}
+
+// Telegram 傳送照片時一律會壓縮成 JPEG，並附上由小到大排列的多個尺寸
+const (
+	photoSizeLargest  = "largest"
+	photoSizeMedium   = "medium"
+	photoSizeSmallest = "smallest"
+)
+
+// 可以在 /settings 中選擇的照片尺寸，依序切換；空字串與 largest 相同
+var photoSizes = []string{photoSizeLargest, photoSizeMedium, photoSizeSmallest}
+
+var photoSizeLabels = map[string]string{
+	"":                "最大",
+	photoSizeLargest:  "最大",
+	photoSizeMedium:   "中等",
+	photoSizeSmallest: "最小",
+}
+
+// selectPhotoSize 依策略從訊息的照片尺寸中選擇一個；中等取排在中間的尺寸
+func selectPhotoSize(photos []tgbotapi.PhotoSize, strategy string) tgbotapi.PhotoSize {
+	switch strategy {
+	case photoSizeSmallest:
+		return photos[0]
+	case photoSizeMedium:
+		return photos[len(photos)/2]
+	}
+	return photos[len(photos)-1]
+}
+
+func photoFile(photo tgbotapi.PhotoSize) *telegramFile {
+	return &telegramFile{ID: photo.FileID, UniqueID: photo.FileUniqueID, Name: photo.FileID + ".jpg", Type: "photo", Size: int64(photo.FileSize), MimeType: "image/jpeg"}
+}
+
+// nextPhotoSize 回傳在 /settings 中切換的下一個照片尺寸
+func nextPhotoSize(current string) string {
+	for i, s := range photoSizes {
+		if s == current {
+			return photoSizes[(i+1)%len(photoSizes)]
+		}
+	}
+	return photoSizes[1]
+}
+
+// 處理 /photo_size 指令：設定壓縮照片要上傳的尺寸，以畫質換取雲端空間
+func handlePhotoSize(ctx context.Context, message *tgbotapi.Message) {
+	arg := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+	if arg == "" {
+		settings, err := loadUserSettings(ctx, message.From.ID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的設定時發生錯誤，請稍後再試。")
+			return
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("上傳的照片尺寸目前為：%s。\n用法：/photo_size largest、/photo_size medium 或 /photo_size smallest", photoSizeLabels[settings.PhotoSize]))
+		return
+	}
+	if _, ok := photoSizeLabels[arg]; !ok || arg == "" {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "用法：/photo_size largest、/photo_size medium 或 /photo_size smallest")
+		return
+	}
+	if err := updateUserSettings(ctx, message.From.ID, map[string]interface{}{"photo_size": arg}); err != nil {
+		slog.ErrorContext(ctx, "Failed to update settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "更新設定時發生錯誤，請稍後再試。")
+		return
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("之後傳送的照片會上傳%s的尺寸。以檔案傳送的照片不受影響。", photoSizeLabels[arg]))
+}
+
+// animationFileName 產生動畫的檔名；Telegram 會把 GIF 轉成 MP4，原始檔名卻可能仍是 .gif
+func animationFileName(a *tgbotapi.Animation) string {
+	name := a.FileName
+	if name == "" {
+		name = a.FileID
+	}
+	if a.MimeType == "" || a.MimeType == "video/mp4" {
+		name = strings.TrimSuffix(name, path.Ext(name)) + ".mp4"
+	}
+	return name
+}
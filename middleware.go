@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"golang.org/x/time/rate"
+)
+
+// --- 更新中介層 ---
+
+// updateHandler 處理一個 update；body 是原始的 update，用來解析 telegram-bot-api 尚未支援的欄位
+type updateHandler func(ctx context.Context, update *tgbotapi.Update, body []byte)
+
+// middleware 包裝 updateHandler，讓日誌、存取控制等每個更新都需要的邏輯不必寫在各個指令中
+type middleware func(next updateHandler) updateHandler
+
+// chainMiddleware 依序套用中介層，第一個中介層在最外層
+func chainMiddleware(handler updateHandler, middlewares ...middleware) updateHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// handleUpdate 是 webhook 收到新的 update 後的進入點
+var handleUpdate = chainMiddleware(routeUpdate,
+	logUpdates,
+	recoverPanics,
+	requireAllowedUser,
+	limitUpdateRate,
+	rejectWhenDegraded,
+	resolveLanguage,
+)
+
+// updateUser 回傳觸發 update 的使用者，頻道貼文等沒有使用者的更新回傳 nil
+func updateUser(update *tgbotapi.Update) *tgbotapi.User {
+	switch {
+	case update.Message != nil:
+		return update.Message.From
+	case update.EditedMessage != nil:
+		return update.EditedMessage.From
+	case update.CallbackQuery != nil:
+		return update.CallbackQuery.From
+	case update.InlineQuery != nil:
+		return update.InlineQuery.From
+	case update.MyChatMember != nil:
+		return &update.MyChatMember.From
+	}
+	return nil
+}
+
+// updateChat 回傳 update 發生的聊天室，無法得知時回傳 nil
+func updateChat(update *tgbotapi.Update) *tgbotapi.Chat {
+	switch {
+	case update.Message != nil:
+		return update.Message.Chat
+	case update.EditedMessage != nil:
+		return update.EditedMessage.Chat
+	case update.ChannelPost != nil:
+		return update.ChannelPost.Chat
+	case update.EditedChannelPost != nil:
+		return update.EditedChannelPost.Chat
+	case update.CallbackQuery != nil && update.CallbackQuery.Message != nil:
+		return update.CallbackQuery.Message.Chat
+	case update.MyChatMember != nil:
+		return &update.MyChatMember.Chat
+	}
+	return nil
+}
+
+// updateType 回傳 update 的種類，用於日誌
+func updateType(update *tgbotapi.Update) string {
+	switch {
+	case update.Message != nil:
+		return "message"
+	case update.EditedMessage != nil:
+		return "edited_message"
+	case update.ChannelPost != nil:
+		return "channel_post"
+	case update.EditedChannelPost != nil:
+		return "edited_channel_post"
+	case update.CallbackQuery != nil:
+		return "callback_query"
+	case update.InlineQuery != nil:
+		return "inline_query"
+	case update.MyChatMember != nil:
+		return "my_chat_member"
+	case update.Poll != nil:
+		return "poll"
+	}
+	return "other"
+}
+
+// logUpdates 將聊天室與使用者加入日誌欄位，並在處理完後記錄花費的時間
+func logUpdates(next updateHandler) updateHandler {
+	return func(ctx context.Context, update *tgbotapi.Update, body []byte) {
+		if chat := updateChat(update); chat != nil {
+			ctx = withLogAttrs(ctx, slog.Int64("chat_id", chat.ID))
+		}
+		if user := updateUser(update); user != nil {
+			ctx = withLogAttrs(ctx, slog.Int64("user_id", user.ID))
+		}
+		start := time.Now()
+		next(ctx, update, body)
+		slog.InfoContext(ctx, "Update handled", "update_type", updateType(update), "duration", time.Since(start).String())
+	}
+}
+
+// recoverPanics 攔截處理過程中的 panic，避免整個程式中止；webhook 仍會回應 200，Telegram 才不會一直重送同一個 update
+func recoverPanics(next updateHandler) updateHandler {
+	return func(ctx context.Context, update *tgbotapi.Update, body []byte) {
+		defer func() {
+			if r := recover(); r != nil {
+				reportPanic(ctx, r)
+				notifyInternalError(ctx, update)
+			}
+		}()
+		next(ctx, update, body)
+	}
+}
+
+// notifyInternalError 告知使用者處理失敗，只回覆訊息與按鈕點擊
+func notifyInternalError(ctx context.Context, update *tgbotapi.Update) {
+	const text = "處理您的訊息時發生內部錯誤，請稍後再試。"
+	switch {
+	case update.Message != nil:
+		replyToUser(ctx, update.Message.Chat.ID, update.Message.MessageID, text)
+	case update.CallbackQuery != nil:
+		answerCallback(ctx, update.CallbackQuery, text)
+	}
+}
+
+// requireAllowedUser 略過不在允許清單中的使用者所觸發的更新
+// 機器人被移出群組的通知不受限制，否則無法清除該群組的綁定
+func requireAllowedUser(next updateHandler) updateHandler {
+	return func(ctx context.Context, update *tgbotapi.Update, body []byte) {
+		user := updateUser(update)
+		if user == nil || update.MyChatMember != nil || isUserAllowed(ctx, user.ID) {
+			next(ctx, update, body)
+			return
+		}
+		slog.InfoContext(ctx, "Rejected update from user not allowed")
+		switch {
+		case update.Message != nil:
+			// 只在私訊或下指令時回覆，避免在群組中對每則訊息都回覆
+			message := update.Message
+			if message.Chat.IsPrivate() || message.IsCommand() {
+				replyToUser(ctx, message.Chat.ID, message.MessageID, "抱歉，此機器人目前僅開放給特定使用者使用。")
+			}
+		case update.CallbackQuery != nil:
+			answerCallback(ctx, update.CallbackQuery, "")
+		case update.InlineQuery != nil:
+			answerInlineQuery(ctx, tgbotapi.InlineConfig{InlineQueryID: update.InlineQuery.ID})
+		}
+	}
+}
+
+type userRateLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+	notified bool // 已告知使用者被限制，恢復前不再重複提示
+}
+
+var (
+	updateLimitersMu sync.Mutex
+	updateLimiters   = map[int64]*userRateLimiter{}
+)
+
+// 超過這段時間沒有更新的使用者會被移除，避免 map 無限成長
+const updateLimiterIdle = 10 * time.Minute
+
+// allowUpdate 判斷使用者是否還有額度，第二個回傳值表示是否需要告知使用者
+// perMinute 是每位使用者每分鐘最多可觸發的更新數；與上傳限制不同，只在單一執行個體的記憶體中計算
+func allowUpdate(userID int64, perMinute int) (bool, bool) {
+	updateLimitersMu.Lock()
+	defer updateLimitersMu.Unlock()
+
+	now := time.Now()
+	l, ok := updateLimiters[userID]
+	if !ok {
+		for id, other := range updateLimiters {
+			if now.Sub(other.lastSeen) > updateLimiterIdle {
+				delete(updateLimiters, id)
+			}
+		}
+		l = &userRateLimiter{limiter: rate.NewLimiter(rate.Every(time.Minute/time.Duration(perMinute)), perMinute)}
+		updateLimiters[userID] = l
+	}
+	l.lastSeen = now
+	if l.limiter.AllowN(now, 1) {
+		l.notified = false
+		return true, false
+	}
+	notify := !l.notified
+	l.notified = true
+	return false, notify
+}
+
+// limitUpdateRate 略過傳送過於頻繁的使用者所觸發的更新
+func limitUpdateRate(next updateHandler) updateHandler {
+	return func(ctx context.Context, update *tgbotapi.Update, body []byte) {
+		user := updateUser(update)
+		perMinute := configFor(ctx).RateLimits.UpdatesPerMinute
+		if perMinute == 0 || user == nil || update.MyChatMember != nil {
+			next(ctx, update, body)
+			return
+		}
+		allowed, notify := allowUpdate(user.ID, perMinute)
+		if allowed {
+			next(ctx, update, body)
+			return
+		}
+		slog.InfoContext(ctx, "Rate limited update")
+		if !notify {
+			return
+		}
+		text := fmt.Sprintf("您傳送得太快了（每分鐘最多 %d 則），請稍後再試。", perMinute)
+		switch {
+		case update.Message != nil && update.Message.Chat.IsPrivate():
+			replyToUser(ctx, update.Message.Chat.ID, update.Message.MessageID, text)
+		case update.CallbackQuery != nil:
+			answerCallback(ctx, update.CallbackQuery, text)
+		}
+	}
+}
+
+type languageKey struct{}
+
+// 使用者沒有提供語言時使用的預設值
+const defaultLanguage = "zh-TW"
+
+// resolveLanguage 決定回覆使用的語言：優先使用 /settings 中選擇的語言，其次是使用者的 Telegram 用戶端語言
+func resolveLanguage(next updateHandler) updateHandler {
+	return func(ctx context.Context, update *tgbotapi.Update, body []byte) {
+		lang := defaultLanguage
+		if user := updateUser(update); user != nil {
+			if user.LanguageCode != "" {
+				lang = user.LanguageCode
+			}
+			settings, err := loadUserSettings(ctx, user.ID)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to load language preference", "error", err)
+			} else if settings.Language != "" {
+				lang = settings.Language
+			}
+		}
+		ctx = withLogAttrs(context.WithValue(ctx, languageKey{}, lang), slog.String("language", lang))
+		next(ctx, update, body)
+	}
+}
+
+// userLanguage 回傳 resolveLanguage 決定的語言
+func userLanguage(ctx context.Context) string {
+	if lang, ok := ctx.Value(languageKey{}).(string); ok {
+		return lang
+	}
+	return defaultLanguage
+}
@@ -0,0 +1,607 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 在目的地之間遷移檔案 ---
+
+// Firestore 集合名稱
+const migrationCollection = "migrations"
+
+const (
+	// 每次從上傳紀錄讀取的筆數
+	migrationBatchSize = 50
+	// 遷移進行中時更新心跳的間隔
+	migrationHeartbeat = time.Minute
+	// 超過這段時間沒有心跳的遷移視為執行個體已經結束，由下一個執行個體接手
+	migrationStaleAfter = 5 * migrationHeartbeat
+	// 每處理幾個檔案更新一次進度訊息
+	migrationProgressEvery = 10
+	// 遷移結束後保留結果的時間，期間輸入 /migrate 可以再次查看驗證結果
+	migrationTTL = 7 * 24 * time.Hour
+	// 驗證結果最多列出的檔案數
+	maxMigrationIssues = 10
+)
+
+// Migration 記錄 /migrate 的進度：依上傳紀錄由舊到新，將來源目的地的檔案複製到新的目的地
+// Cursor 是最後處理完的上傳紀錄的上傳時間，中斷後從下一筆繼續；以使用者 ID 作為文件 ID，同時只能有一個遷移
+type Migration struct {
+	UserID          int64     `firestore:"user_id"`
+	From            string    `firestore:"from"`
+	To              string    `firestore:"to"`
+	Move            bool      `firestore:"move"` // 驗證後將來源的檔案移到垃圾桶，並讓上傳紀錄改指向新的位置
+	ChatID          int64     `firestore:"chat_id"`
+	ReplyTo         int       `firestore:"reply_to"`
+	StatusMessageID int       `firestore:"status_message_id"` // 顯示進度的訊息
+	Cursor          time.Time `firestore:"cursor"`
+	Copied          int       `firestore:"copied"`
+	Moved           int       `firestore:"moved"`      // 來源已移到垃圾桶的檔案數
+	Skipped         int       `firestore:"skipped"`    // 已在垃圾桶或新的目的地已有相同檔案
+	Failed          int       `firestore:"failed"`     // 無法下載或上傳
+	Mismatched      int       `firestore:"mismatched"` // 複製的大小與來源不符，搬移時保留來源的檔案
+	Bytes           int64     `firestore:"bytes"`
+	Issues          []string  `firestore:"issues"`      // 需要使用者注意的檔案與原因，最多 maxMigrationIssues 筆
+	IssueCount      int       `firestore:"issue_count"` // 需要使用者注意的檔案總數，包含沒有列在 Issues 中的檔案
+	Error           string    `firestore:"error"`       // 中止遷移的原因，以同樣的參數再次執行時從 Cursor 繼續
+	Done            bool      `firestore:"done"`
+	StartedAt       time.Time `firestore:"started_at"`
+	HeartbeatAt     time.Time `firestore:"heartbeat_at"`
+	ExpireAt        time.Time `firestore:"expire_at"`
+}
+
+// Processed 回傳已處理的上傳紀錄數
+func (m *Migration) Processed() int {
+	return m.Copied + m.Skipped + m.Failed + m.Mismatched
+}
+
+// addIssue 記錄一個需要使用者注意的檔案，超過上限時只計數
+func (m *Migration) addIssue(name, reason string) {
+	m.IssueCount++
+	if len(m.Issues) < maxMigrationIssues {
+		m.Issues = append(m.Issues, fmt.Sprintf("%s：%s", name, reason))
+	}
+}
+
+// progressUpdates 回傳寫入目前進度的欄位
+func (m *Migration) progressUpdates() []Update {
+	return []Update{
+		{Path: "cursor", Value: m.Cursor},
+		{Path: "copied", Value: m.Copied},
+		{Path: "moved", Value: m.Moved},
+		{Path: "skipped", Value: m.Skipped},
+		{Path: "failed", Value: m.Failed},
+		{Path: "mismatched", Value: m.Mismatched},
+		{Path: "bytes", Value: m.Bytes},
+		{Path: "issues", Value: m.Issues},
+		{Path: "issue_count", Value: m.IssueCount},
+		{Path: "heartbeat_at", Value: time.Now()},
+	}
+}
+
+func migrationRef(ctx context.Context, userID int64) *DocRef {
+	return collection(ctx, migrationCollection).Doc(fmt.Sprintf("%d", userID))
+}
+
+// loadMigration 讀取使用者最近一次的遷移，沒有時回傳 nil
+func loadMigration(ctx context.Context, userID int64) (*Migration, error) {
+	doc, err := migrationRef(ctx, userID).Get(ctx)
+	if errors.Is(err, errDocNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m Migration
+	if err := doc.DataTo(&m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+const migrateUsage = "用法：/migrate <來源> <目的地> [move]，例如 /migrate drive onedrive。\n" +
+	"預設只複製，加上 move 時會在確認複製的大小相符後，將來源的檔案移到垃圾桶。輸入 /migrate 查看進度，/migrate cancel 取消。"
+
+// 處理 /migrate 指令：依上傳紀錄將機器人上傳到一個目的地的檔案複製或搬移到另一個目的地
+func handleMigrate(ctx context.Context, message *tgbotapi.Message) {
+	if !message.Chat.IsPrivate() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請在與機器人的私訊中使用 /migrate。")
+		return
+	}
+	userID := message.From.ID
+	args := strings.Fields(strings.ToLower(message.CommandArguments()))
+	switch {
+	case len(args) == 0:
+		m, err := loadMigration(ctx, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load migration", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "發生錯誤，請稍後再試。")
+			return
+		}
+		if m == nil {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, migrateUsage)
+			return
+		}
+		if m.Done {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, migrationReport(m))
+			return
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, migrationProgressText(m)+"\n\n輸入 /migrate cancel 可以取消。")
+	case len(args) == 1 && args[0] == "cancel":
+		m, err := loadMigration(ctx, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load migration", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "發生錯誤，請稍後再試。")
+			return
+		}
+		if m == nil || m.Done {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "目前沒有進行中的遷移。")
+			return
+		}
+		if err := migrationRef(ctx, userID).Delete(ctx); err != nil {
+			slog.ErrorContext(ctx, "Failed to delete migration", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "發生錯誤，請稍後再試。")
+			return
+		}
+		slog.InfoContext(ctx, "Migration canceled", "from", m.From, "to", m.To, "copied", m.Copied)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("已取消遷移，已經複製的 %d 個檔案會保留在新的目的地。", m.Copied))
+	case len(args) == 2 || (len(args) == 3 && (args[2] == "move" || args[2] == "copy")):
+		startMigration(ctx, message, args[0], args[1], len(args) == 3 && args[2] == "move")
+	default:
+		replyToUser(ctx, message.Chat.ID, message.MessageID, migrateUsage)
+	}
+}
+
+// startMigration 檢查來源與目的地後記錄遷移，並在背景開始複製
+func startMigration(ctx context.Context, message *tgbotapi.Message, fromName, toName string, move bool) {
+	userID := message.From.ID
+	from, ok := destinations[fromName]
+	if !ok {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("找不到目的地「%s」，可以使用：%s。", fromName, strings.Join(destinationNames(), "、")))
+		return
+	}
+	to, ok := destinations[toName]
+	if !ok {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("找不到目的地「%s」，可以使用：%s。", toName, strings.Join(destinationNames(), "、")))
+		return
+	}
+	if from.Name() == to.Name() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "來源與目的地不能相同。")
+		return
+	}
+	if _, ok := from.(trashDestination); move && !ok {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("%s 不支援垃圾桶，只能複製：/migrate %s %s", from.DisplayName(), from.Name(), to.Name()))
+		return
+	}
+	connected, err := to.Connected(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to retrieve token", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的授權時發生錯誤，請稍後再試。")
+		return
+	}
+	if !connected {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("您的 %s 帳號尚未連結，請先使用 %s 指令連結。", to.DisplayName(), connectCommand(to)))
+		return
+	}
+
+	now := time.Now()
+	m := &Migration{UserID: userID, From: from.Name(), To: to.Name(), Move: move, ChatID: message.Chat.ID, ReplyTo: message.MessageID}
+	ref := migrationRef(ctx, userID)
+	resumed := false
+	err = store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		resumed = false
+		doc, err := tx.Get(ref)
+		if err != nil && !errors.Is(err, errDocNotFound) {
+			return err
+		}
+		if err == nil {
+			var existing Migration
+			if err := doc.DataTo(&existing); err != nil {
+				return err
+			}
+			if !existing.Done {
+				return errMigrationRunning
+			}
+			// 上次因錯誤中止的同一個遷移從中斷的地方繼續，累計的結果一併保留
+			if existing.Error != "" && existing.From == m.From && existing.To == m.To && existing.Move == m.Move {
+				m.Cursor, m.Copied, m.Moved, m.Skipped, m.Failed, m.Mismatched = existing.Cursor, existing.Copied, existing.Moved, existing.Skipped, existing.Failed, existing.Mismatched
+				m.Bytes, m.Issues, m.IssueCount, m.StartedAt = existing.Bytes, existing.Issues, existing.IssueCount, existing.StartedAt
+				resumed = true
+			}
+		}
+		if !resumed {
+			m.StartedAt = now
+		}
+		m.HeartbeatAt, m.ExpireAt = now, now.Add(migrationTTL)
+		return tx.Set(ref, m)
+	})
+	if errors.Is(err, errMigrationRunning) {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "已有進行中的遷移，輸入 /migrate 查看進度，或 /migrate cancel 取消。")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to save migration", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "發生錯誤，請稍後再試。")
+		return
+	}
+
+	action := "複製"
+	if move {
+		action = "搬移"
+	}
+	text := fmt.Sprintf("開始將上傳到 %s 的檔案%s到 %s，完成後會回報驗證結果。", from.DisplayName(), action, to.DisplayName())
+	if resumed {
+		text = fmt.Sprintf("從上次中斷的地方繼續將 %s 的檔案%s到 %s。", from.DisplayName(), action, to.DisplayName())
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, dryRunReply(ctx, text+"\n\n"+migrationProgressText(m)))
+	msg.ReplyToMessageID = message.MessageID
+	if sent, err := sendMessage(ctx, msg); err != nil {
+		slog.ErrorContext(ctx, "Failed to send migration status", "error", err)
+	} else {
+		m.StatusMessageID = sent.MessageID
+		if err := ref.Update(ctx, []Update{{Path: "status_message_id", Value: sent.MessageID}}); err != nil {
+			slog.WarnContext(ctx, "Failed to save migration status message", "error", err)
+		}
+	}
+	slog.InfoContext(ctx, "Migration started", "from", m.From, "to", m.To, "move", move, "resumed", resumed)
+
+	// 檔案可能很多，在背景處理以免 webhook 逾時；執行個體中途結束時由 resumeMigrations 接手
+	ctx = context.WithoutCancel(ctx)
+	go runMigration(ctx, m)
+}
+
+var (
+	errMigrationRunning  = errors.New("migration already running")
+	errMigrationNoSource = errors.New("no downloadable copy of the file")
+)
+
+// runMigration 從 Cursor 之後的上傳紀錄開始逐一複製，每個檔案處理完就寫入進度；文件被 /migrate cancel 刪除時停止
+func runMigration(ctx context.Context, m *Migration) {
+	defer func() {
+		if r := recover(); r != nil {
+			reportPanic(ctx, r)
+		}
+	}()
+	ctx = withLogAttrs(ctx, slog.Int64("user_id", m.UserID), slog.String("from", m.From), slog.String("to", m.To))
+	ref := migrationRef(ctx, m.UserID)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		ticker := time.NewTicker(migrationHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := ref.Update(ctx, []Update{{Path: "heartbeat_at", Value: time.Now()}}); err != nil && !errors.Is(err, errDocNotFound) {
+					slog.WarnContext(ctx, "Failed to update migration heartbeat", "error", err)
+				}
+			}
+		}
+	}()
+
+	from, to := destinations[m.From], destinations[m.To]
+	if from == nil || to == nil {
+		finishMigration(ctx, m, "這個目的地已經停用。")
+		return
+	}
+	settings, err := loadUserSettings(ctx, m.UserID)
+	if err != nil {
+		// 留下未完成的紀錄，由下一次 resumeMigrations 重試
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		return
+	}
+
+	for {
+		q := collection(ctx, uploadCollection).Where("user_id", "==", m.UserID).Where("destination", "==", m.From)
+		if !m.Cursor.IsZero() {
+			q = q.Where("created_at", ">", m.Cursor)
+		}
+		docs, err := q.OrderBy("created_at", Asc).Limit(migrationBatchSize).GetAll(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to list uploads for migration", "error", err)
+			return
+		}
+		if len(docs) == 0 {
+			break
+		}
+		for _, doc := range docs {
+			var record UploadRecord
+			if err := doc.DataTo(&record); err != nil {
+				slog.WarnContext(ctx, "Failed to decode upload record", "doc_id", doc.Ref.ID, "error", err)
+				continue
+			}
+			abort := migrateUpload(ctx, m, from, to, settings, doc.Ref, &record)
+			if abort != "" {
+				finishMigration(ctx, m, abort)
+				return
+			}
+			m.Cursor = record.CreatedAt
+			if err := ref.Update(ctx, m.progressUpdates()); err != nil {
+				if errors.Is(err, errDocNotFound) {
+					slog.InfoContext(ctx, "Migration stopped after cancel")
+					return
+				}
+				slog.ErrorContext(ctx, "Failed to save migration progress", "error", err)
+			}
+			if m.StatusMessageID != 0 && m.Processed()%migrationProgressEvery == 0 {
+				edit := tgbotapi.NewEditMessageText(m.ChatID, m.StatusMessageID, dryRunReply(ctx, migrationProgressText(m)))
+				if _, err := sendChattable(ctx, m.ChatID, edit); err != nil {
+					slog.WarnContext(ctx, "Failed to update migration status", "error", err)
+				}
+			}
+		}
+	}
+	finishMigration(ctx, m, "")
+}
+
+// migrateUpload 將一筆上傳紀錄的檔案複製到新的目的地並驗證大小，搬移時再將來源移到垃圾桶並更新紀錄
+// 結果累加到 m；新的目的地無法使用、繼續下去每個檔案都會失敗時回傳中止的原因
+func migrateUpload(ctx context.Context, m *Migration, from, to Destination, settings *UserSettings, recordRef *DocRef, record *UploadRecord) string {
+	if record.Trashed {
+		m.Skipped++
+		return ""
+	}
+	if record.TelegramFileUniqueID != "" {
+		existing, err := findUploadByFile(ctx, m.UserID, m.To, record.TelegramFileUniqueID)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to check existing upload", "error", err)
+		} else if existing != nil {
+			m.Skipped++
+			return ""
+		}
+	}
+	if d, ok := to.(mediaDestination); ok && !d.AcceptsMimeType(record.MimeType) {
+		m.Failed++
+		m.addIssue(record.Name, fmt.Sprintf("%s 不接受這個格式", to.DisplayName()))
+		return ""
+	}
+
+	body, expected, err := openMigrationSource(ctx, from, m.UserID, record)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to download file for migration", "file_id", record.FileID, "error", err)
+		m.Failed++
+		m.addIssue(record.Name, "無法從來源或 Telegram 下載")
+		return ""
+	}
+	defer body.Close()
+
+	var folders []string
+	if record.Meta != nil {
+		folders = renderFolderPath(settings.Profile().FolderTemplate, record.Meta)
+	}
+	counted := newLimitedReader(body, math.MaxInt64)
+	upload := &UploadFile{
+		Name:     record.Name,
+		Folders:  folders,
+		Body:     counted,
+		MimeType: record.MimeType,
+		Source: &TelegramSource{
+			ChatID:       record.ChatID,
+			MessageID:    record.MessageID,
+			FileUniqueID: record.TelegramFileUniqueID,
+		},
+	}
+	if record.Meta != nil {
+		upload.Caption = record.Meta.Caption
+	}
+	result, err := uploadTo(ctx, to, m.UserID, upload)
+	if errors.Is(err, errNotConnected) {
+		return fmt.Sprintf("%s 的授權已失效，請重新連結後以同樣的指令繼續。", to.DisplayName())
+	}
+	if err != nil {
+		reportError(ctx, "Failed to upload file for migration", err, "destination", to.Name())
+		m.Failed++
+		m.addIssue(record.Name, "上傳失敗")
+		return ""
+	}
+	size := counted.BytesRead()
+	if expected > 0 && size != expected {
+		slog.WarnContext(ctx, "Migrated file size mismatch", "file_id", record.FileID, "expected", expected, "copied", size)
+		m.Mismatched++
+		m.addIssue(record.Name, fmt.Sprintf("大小不符（來源 %s，複製 %s）", formatSize(expected), formatSize(size)))
+		return ""
+	}
+	m.Copied++
+	m.Bytes += size
+	if !m.Move || dryRun(ctx) {
+		return ""
+	}
+
+	// 先確認複製成功才移除來源；移到垃圾桶失敗時上傳紀錄仍指向新的位置，來源的檔案留給使用者自行處理
+	sctx := ctx
+	if record.Account != "" {
+		sctx = withGoogleAccount(ctx, record.Account)
+	}
+	if err := from.(trashDestination).SetTrashed(sctx, m.UserID, record.FileID, true); err != nil {
+		slog.WarnContext(ctx, "Failed to trash migrated file", "file_id", record.FileID, "error", err)
+		m.addIssue(record.Name, fmt.Sprintf("已複製，但無法將 %s 上的檔案移到垃圾桶", from.DisplayName()))
+	} else {
+		m.Moved++
+	}
+	err = recordRef.Update(ctx, []Update{
+		{Path: "destination", Value: to.Name()},
+		{Path: "account", Value: result.Account},
+		{Path: "file_id", Value: result.FileID},
+		{Path: "name", Value: result.Name},
+		{Path: "link", Value: result.Link},
+		{Path: "starred", Value: false},
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to update upload record", "doc_id", recordRef.ID, "error", err)
+	}
+	return ""
+}
+
+// openMigrationSource 開始下載上傳紀錄的檔案，回傳內容與預期的大小（未知時為 0），呼叫端負責關閉
+// 來源可以下載時以來源為準；來源不支援下載、帳號已不再連結或是無法下載的原生格式時，改從 Telegram 重新下載原始檔案
+func openMigrationSource(ctx context.Context, from Destination, userID int64, record *UploadRecord) (io.ReadCloser, int64, error) {
+	if d, ok := from.(fetchDestination); ok {
+		sctx := ctx
+		if record.Account != "" {
+			sctx = withGoogleAccount(ctx, record.Account)
+		}
+		file, body, err := d.Download(sctx, userID, record.FileID)
+		if err == nil {
+			expected := file.Size
+			if expected == 0 {
+				expected = record.Size
+			}
+			return body, expected, nil
+		}
+		if !errors.Is(err, errNotConnected) && !errors.Is(err, errNativeDriveFile) {
+			return nil, 0, err
+		}
+	}
+	if record.TelegramFileID == "" {
+		return nil, 0, errMigrationNoSource
+	}
+	download, err := openTelegramFileByID(ctx, record.TelegramFileID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return download.Body, record.Size, nil
+}
+
+// finishMigration 記錄遷移結束並回覆驗證結果，reason 不是空字串時代表遷移中止
+func finishMigration(ctx context.Context, m *Migration, reason string) {
+	m.Done, m.Error = true, reason
+	updates := append(m.progressUpdates(),
+		Update{Path: "done", Value: true},
+		Update{Path: "error", Value: reason},
+		Update{Path: "expire_at", Value: time.Now().Add(migrationTTL)},
+	)
+	if err := migrationRef(ctx, m.UserID).Update(ctx, updates); err != nil {
+		if errors.Is(err, errDocNotFound) {
+			return
+		}
+		slog.ErrorContext(ctx, "Failed to save migration result", "error", err)
+	}
+	slog.InfoContext(ctx, "Migration finished", "copied", m.Copied, "moved", m.Moved, "skipped", m.Skipped,
+		"failed", m.Failed, "mismatched", m.Mismatched, "bytes", m.Bytes, "error", reason)
+	replyToUser(ctx, m.ChatID, m.ReplyTo, dryRunReply(ctx, migrationReport(m)))
+}
+
+// migrationProgressText 產生遷移進度
+func migrationProgressText(m *Migration) string {
+	text := fmt.Sprintf("遷移進度：已處理 %d 個檔案，複製 %d 個（%s）", m.Processed(), m.Copied, formatSize(m.Bytes))
+	if m.Skipped > 0 {
+		text += fmt.Sprintf("，略過 %d 個", m.Skipped)
+	}
+	if failed := m.Failed + m.Mismatched; failed > 0 {
+		text += fmt.Sprintf("，失敗 %d 個", failed)
+	}
+	return text + "。"
+}
+
+// migrationReport 產生遷移結束時的驗證結果
+func migrationReport(m *Migration) string {
+	var sb strings.Builder
+	title := "遷移完成"
+	if m.Error != "" {
+		title = "遷移已中止：" + m.Error
+	}
+	fmt.Fprintf(&sb, "%s（%s → %s）\n", title, destinationDisplayName(m.From), destinationDisplayName(m.To))
+	fmt.Fprintf(&sb, "已處理 %d 筆上傳紀錄：\n", m.Processed())
+	fmt.Fprintf(&sb, "✅ 複製並確認大小相符：%d 個（%s）\n", m.Copied, formatSize(m.Bytes))
+	if m.Move {
+		fmt.Fprintf(&sb, "🗑 來源已移到垃圾桶：%d 個\n", m.Moved)
+	}
+	fmt.Fprintf(&sb, "⏭ 略過（已在垃圾桶或目的地已有相同檔案）：%d 個\n", m.Skipped)
+	fmt.Fprintf(&sb, "⚠️ 大小不符：%d 個\n", m.Mismatched)
+	fmt.Fprintf(&sb, "❌ 失敗：%d 個", m.Failed)
+	if m.Move && m.Mismatched+m.Failed > 0 {
+		sb.WriteString("\n大小不符或失敗的檔案仍保留在來源。")
+	}
+	if len(m.Issues) > 0 {
+		sb.WriteString("\n\n需要注意的檔案：")
+		for _, issue := range m.Issues {
+			sb.WriteString("\n- " + issue)
+		}
+		if more := m.IssueCount - len(m.Issues); more > 0 {
+			fmt.Fprintf(&sb, "\n…另有 %d 個", more)
+		}
+	}
+	return sb.String()
+}
+
+// destinationDisplayName 回傳目的地的顯示名稱，目的地已停用時回傳識別名稱
+func destinationDisplayName(name string) string {
+	if d, ok := destinations[name]; ok {
+		return d.DisplayName()
+	}
+	return name
+}
+
+// claimStaleMigration 在交易中確認遷移仍然停滯並更新心跳，回傳 nil 表示已結束或已被其他執行個體接手
+func claimStaleMigration(ctx context.Context, ref *DocRef, cutoff time.Time) (*Migration, error) {
+	var m *Migration
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		m = nil
+		doc, err := tx.Get(ref)
+		if errors.Is(err, errDocNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var data Migration
+		if err := doc.DataTo(&data); err != nil {
+			return err
+		}
+		if data.Done || data.HeartbeatAt.After(cutoff) {
+			return nil
+		}
+		m = &data
+		return tx.Update(ref, []Update{{Path: "heartbeat_at", Value: time.Now()}})
+	})
+	return m, err
+}
+
+// resumeMigrations 在背景繼續 ctx 中的機器人所有停滯的遷移，回傳繼續的數量
+func resumeMigrations(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-migrationStaleAfter)
+	docs, err := collection(ctx, migrationCollection).Where("heartbeat_at", "<", cutoff).GetAll(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list migrations: %v", err)
+	}
+	resumed := 0
+	for _, doc := range docs {
+		m, err := claimStaleMigration(ctx, doc.Ref, cutoff)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to claim migration", "user_id", doc.Ref.ID, "error", err)
+			continue
+		}
+		if m == nil {
+			continue
+		}
+		slog.InfoContext(ctx, "Resuming interrupted migration", "user_id", m.UserID, "cursor", m.Cursor)
+		go runMigration(context.WithoutCancel(ctx), m)
+		resumed++
+	}
+	return resumed, nil
+}
+
+// resumeAllMigrations 在啟動時繼續所有機器人停滯的遷移
+func resumeAllMigrations(ctx context.Context) {
+	for _, t := range tenants {
+		n, err := resumeMigrations(withTenant(ctx, t))
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to resume migrations", "bot_id", t.ID, "error", err)
+			continue
+		}
+		if n > 0 {
+			slog.InfoContext(ctx, "Resumed interrupted migrations", "bot_id", t.ID, "resumed", n)
+		}
+	}
+}
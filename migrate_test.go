@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// archiveDestination 是可以下載與移到垃圾桶的假目的地，作為 /migrate 的來源
+type archiveDestination struct {
+	name string
+
+	mu      sync.Mutex
+	files   map[string][]byte // file_id → 內容
+	trashed map[string]bool
+}
+
+func newArchiveDestination(name string) *archiveDestination {
+	return &archiveDestination{name: name, files: map[string][]byte{}, trashed: map[string]bool{}}
+}
+
+func (d *archiveDestination) Name() string        { return d.name }
+func (d *archiveDestination) DisplayName() string { return "Archive " + d.name }
+
+func (d *archiveDestination) Connected(ctx context.Context, userID int64) (bool, error) {
+	return true, nil
+}
+
+func (d *archiveDestination) Connect(ctx context.Context, userID int64) (string, error) {
+	return "", errConnectNotRequired
+}
+
+func (d *archiveDestination) Upload(ctx context.Context, userID int64, file *UploadFile) (*UploadResult, error) {
+	content, err := io.ReadAll(file.Body)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id := fmt.Sprintf("%s-%d", d.name, len(d.files)+1)
+	d.files[id] = content
+	return &UploadResult{FileID: id, Name: file.Name}, nil
+}
+
+func (d *archiveDestination) Link(ctx context.Context, userID int64, fileID string) (string, error) {
+	return "", nil
+}
+
+func (d *archiveDestination) Search(ctx context.Context, userID int64, query string, limit int) ([]RemoteFile, error) {
+	return nil, nil
+}
+
+func (d *archiveDestination) FindByProperties(ctx context.Context, userID int64, props map[string]string, limit int) ([]RemoteFile, error) {
+	return nil, nil
+}
+
+func (d *archiveDestination) Download(ctx context.Context, userID int64, fileID string) (*RemoteFile, io.ReadCloser, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	content, ok := d.files[fileID]
+	if !ok {
+		return nil, nil, fmt.Errorf("file %s not found", fileID)
+	}
+	return &RemoteFile{ID: fileID, Size: int64(len(content))}, io.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (d *archiveDestination) SetTrashed(ctx context.Context, userID int64, fileID string, trashed bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.trashed[fileID] = trashed
+	return nil
+}
+
+// plainDestination 是不支援下載的假目的地，/migrate 只能從 Telegram 重新下載它的檔案
+type plainDestination struct{ name string }
+
+func (d *plainDestination) Name() string        { return d.name }
+func (d *plainDestination) DisplayName() string { return "Plain " + d.name }
+
+func (d *plainDestination) Connected(ctx context.Context, userID int64) (bool, error) {
+	return true, nil
+}
+
+func (d *plainDestination) Connect(ctx context.Context, userID int64) (string, error) {
+	return "", errConnectNotRequired
+}
+
+func (d *plainDestination) Upload(ctx context.Context, userID int64, file *UploadFile) (*UploadResult, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (d *plainDestination) Link(ctx context.Context, userID int64, fileID string) (string, error) {
+	return "", nil
+}
+
+// saveMigrationRecords 替使用者存入上傳紀錄，依序相隔一分鐘
+func saveMigrationRecords(t *testing.T, ctx context.Context, records ...*UploadRecord) {
+	t.Helper()
+	base := time.Now().Add(-time.Hour)
+	for i, record := range records {
+		record.MessageID = i + 1
+		record.CreatedAt = base.Add(time.Duration(i) * time.Minute)
+		if err := saveUploadRecord(ctx, record); err != nil {
+			t.Fatalf("saveUploadRecord: %v", err)
+		}
+	}
+}
+
+// runTestMigration 記錄一個遷移並在目前的 goroutine 中執行到結束，回傳儲存的結果
+func runTestMigration(t *testing.T, env *handlerEnv, m *Migration) *Migration {
+	t.Helper()
+	m.ChatID, m.HeartbeatAt = m.UserID, time.Now()
+	if err := migrationRef(env.ctx, m.UserID).Set(env.ctx, m); err != nil {
+		t.Fatalf("save migration: %v", err)
+	}
+	runMigration(env.ctx, m)
+	saved, err := loadMigration(env.ctx, m.UserID)
+	if err != nil || saved == nil {
+		t.Fatalf("loadMigration = %v, %v", saved, err)
+	}
+	return saved
+}
+
+func TestRunMigrationMove(t *testing.T) {
+	env := newHandlerEnv(t)
+	vault := newArchiveDestination("vault")
+	registerDestination(vault)
+	userID := int64(7800)
+	env.connectDrive(t, userID)
+	vault.files["a"] = []byte("first file")
+	vault.files["b"] = []byte("second file")
+	saveMigrationRecords(t, env.ctx,
+		&UploadRecord{UserID: userID, ChatID: userID, Destination: "vault", FileID: "a", Name: "a.txt", MimeType: "text/plain", Size: 10},
+		&UploadRecord{UserID: userID, ChatID: userID, Destination: "vault", FileID: "old", Name: "old.txt", Trashed: true},
+		&UploadRecord{UserID: userID, ChatID: userID, Destination: "vault", FileID: "b", Name: "b.txt", MimeType: "text/plain", Size: 11},
+		&UploadRecord{UserID: userID + 1, ChatID: userID + 1, Destination: "vault", FileID: "a", Name: "other.txt"},
+	)
+
+	m := runTestMigration(t, env, &Migration{UserID: userID, From: "vault", To: "drive", Move: true})
+
+	if !m.Done || m.Error != "" || m.Copied != 2 || m.Moved != 2 || m.Skipped != 1 || m.Failed != 0 {
+		t.Errorf("migration = %+v, want 2 copied and moved, 1 skipped", m)
+	}
+	uploads := env.drive.uploaded()
+	if len(uploads) != 2 || string(uploads[0].Content) != "first file" || string(uploads[1].Content) != "second file" {
+		t.Fatalf("drive uploads = %+v", uploads)
+	}
+	if !vault.trashed["a"] || !vault.trashed["b"] || vault.trashed["old"] {
+		t.Errorf("trashed = %v, want a and b", vault.trashed)
+	}
+	record, err := findUploadRecord(env.ctx, userID, 1)
+	if err != nil || record == nil {
+		t.Fatalf("findUploadRecord = %v, %v", record, err)
+	}
+	if record.Destination != "drive" || record.FileID != "file-1" {
+		t.Errorf("record = %+v, want it to point to the Drive copy", record)
+	}
+	messages := env.sender.messages(userID)
+	if !slices.ContainsFunc(messages, func(s string) bool { return strings.Contains(s, "複製並確認大小相符：2 個") }) {
+		t.Errorf("messages = %q, want a verification report", messages)
+	}
+}
+
+func TestRunMigrationResumesAfterCursor(t *testing.T) {
+	env := newHandlerEnv(t)
+	vault := newArchiveDestination("vault")
+	registerDestination(vault)
+	userID := int64(7801)
+	env.connectDrive(t, userID)
+	vault.files["a"] = []byte("first file")
+	vault.files["b"] = []byte("second file")
+	saveMigrationRecords(t, env.ctx,
+		&UploadRecord{UserID: userID, ChatID: userID, Destination: "vault", FileID: "a", Name: "a.txt", Size: 10},
+		&UploadRecord{UserID: userID, ChatID: userID, Destination: "vault", FileID: "b", Name: "b.txt", Size: 11},
+	)
+	first, err := findUploadRecord(env.ctx, userID, 1)
+	if err != nil || first == nil {
+		t.Fatalf("findUploadRecord = %v, %v", first, err)
+	}
+
+	// 前一個執行個體已經複製完第一個檔案
+	m := runTestMigration(t, env, &Migration{UserID: userID, From: "vault", To: "drive", Cursor: first.CreatedAt, Copied: 1, Bytes: 10})
+
+	if !m.Done || m.Copied != 2 || m.Bytes != 21 {
+		t.Errorf("migration = %+v, want 2 copied, 21 bytes", m)
+	}
+	if uploads := env.drive.uploaded(); len(uploads) != 1 || uploads[0].Name != "b.txt" {
+		t.Errorf("drive uploads = %+v, want only b.txt", uploads)
+	}
+	// 只複製時上傳紀錄與來源保持不變
+	if record, _ := findUploadRecord(env.ctx, userID, 2); record == nil || record.Destination != "vault" {
+		t.Errorf("record = %+v, want it to stay on vault", record)
+	}
+	if len(vault.trashed) != 0 {
+		t.Errorf("trashed = %v, want none", vault.trashed)
+	}
+}
+
+func TestRunMigrationFromTelegram(t *testing.T) {
+	env := newHandlerEnv(t)
+	registerDestination(&plainDestination{name: "plain"})
+	userID := int64(7802)
+	env.connectDrive(t, userID)
+	env.files.addFile("tg-a", []byte("telegram copy"))
+	env.files.addFile("tg-b", []byte("short"))
+	saveMigrationRecords(t, env.ctx,
+		&UploadRecord{UserID: userID, ChatID: userID, Destination: "plain", FileID: "a", Name: "a.txt", TelegramFileID: "tg-a", Size: 13},
+		&UploadRecord{UserID: userID, ChatID: userID, Destination: "plain", FileID: "b", Name: "b.txt", TelegramFileID: "tg-b", Size: 100},
+		&UploadRecord{UserID: userID, ChatID: userID, Destination: "plain", FileID: "c", Name: "c.txt"},
+	)
+
+	m := runTestMigration(t, env, &Migration{UserID: userID, From: "plain", To: "drive"})
+
+	if m.Copied != 1 || m.Mismatched != 1 || m.Failed != 1 || m.IssueCount != 2 {
+		t.Errorf("migration = %+v, want 1 copied, 1 mismatched, 1 failed", m)
+	}
+	if uploads := env.drive.uploaded(); len(uploads) != 2 || string(uploads[0].Content) != "telegram copy" {
+		t.Errorf("drive uploads = %+v", uploads)
+	}
+	report := migrationReport(m)
+	for _, want := range []string{"b.txt：大小不符", "c.txt：無法從來源或 Telegram 下載"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report = %q, want it to contain %q", report, want)
+		}
+	}
+}
+
+func TestRunMigrationStopsAfterCancel(t *testing.T) {
+	env := newHandlerEnv(t)
+	vault := newArchiveDestination("vault")
+	registerDestination(vault)
+	userID := int64(7803)
+	env.connectDrive(t, userID)
+	vault.files["a"] = []byte("first file")
+	saveMigrationRecords(t, env.ctx,
+		&UploadRecord{UserID: userID, ChatID: userID, Destination: "vault", FileID: "a", Name: "a.txt"},
+		&UploadRecord{UserID: userID, ChatID: userID, Destination: "vault", FileID: "a", Name: "a2.txt"},
+	)
+
+	// 沒有遷移紀錄代表已經以 /migrate cancel 取消
+	runMigration(env.ctx, &Migration{UserID: userID, From: "vault", To: "drive"})
+
+	if uploads := env.drive.uploaded(); len(uploads) != 1 {
+		t.Errorf("drive uploads = %d, want the migration to stop after the first file", len(uploads))
+	}
+}
+
+func TestHandleMigrate(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		connect   bool
+		existing  *Migration
+		wantReply string
+	}{
+		{name: "usage", text: "/migrate", wantReply: "用法：/migrate"},
+		{name: "unknown destination", text: "/migrate vault box", connect: true, wantReply: "找不到目的地「box」"},
+		{name: "same destination", text: "/migrate drive drive", connect: true, wantReply: "來源與目的地不能相同"},
+		{name: "not connected", text: "/migrate vault drive", wantReply: "尚未連結"},
+		{name: "move without trash", text: "/migrate plain drive move", connect: true, wantReply: "不支援垃圾桶"},
+		{name: "already running", text: "/migrate vault drive", connect: true, existing: &Migration{From: "vault", To: "drive"}, wantReply: "已有進行中的遷移"},
+		{name: "progress", text: "/migrate", existing: &Migration{From: "vault", To: "drive", Copied: 3}, wantReply: "複製 3 個"},
+		{name: "report", text: "/migrate", existing: &Migration{From: "vault", To: "drive", Copied: 3, Done: true}, wantReply: "遷移完成"},
+		{name: "cancel", text: "/migrate cancel", existing: &Migration{From: "vault", To: "drive", Copied: 3}, wantReply: "已取消遷移"},
+		{name: "cancel without migration", text: "/migrate cancel", wantReply: "目前沒有進行中的遷移"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newHandlerEnv(t)
+			registerDestination(newArchiveDestination("vault"))
+			registerDestination(&plainDestination{name: "plain"})
+			userID := int64(7810 + i)
+			if tt.connect {
+				env.connectDrive(t, userID)
+			}
+			if tt.existing != nil {
+				tt.existing.UserID = userID
+				if err := migrationRef(env.ctx, userID).Set(env.ctx, tt.existing); err != nil {
+					t.Fatalf("save migration: %v", err)
+				}
+			}
+
+			handleMigrate(env.ctx, commandMessage(userID, tt.text))
+
+			messages := env.sender.messages(userID)
+			if !slices.ContainsFunc(messages, func(m string) bool { return strings.Contains(m, tt.wantReply) }) {
+				t.Errorf("messages = %q, want one containing %q", messages, tt.wantReply)
+			}
+		})
+	}
+}
+
+func TestClaimStaleMigration(t *testing.T) {
+	env := newHandlerEnv(t)
+	cutoff := time.Now().Add(-migrationStaleAfter)
+	tests := []struct {
+		name      string
+		migration Migration
+		want      bool
+	}{
+		{name: "stale", migration: Migration{HeartbeatAt: cutoff.Add(-time.Minute)}, want: true},
+		{name: "running", migration: Migration{HeartbeatAt: time.Now()}},
+		{name: "done", migration: Migration{HeartbeatAt: cutoff.Add(-time.Minute), Done: true}},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := int64(7830 + i)
+			tt.migration.UserID = userID
+			ref := migrationRef(env.ctx, userID)
+			if err := ref.Set(env.ctx, &tt.migration); err != nil {
+				t.Fatalf("save migration: %v", err)
+			}
+
+			m, err := claimStaleMigration(env.ctx, ref, cutoff)
+			if err != nil {
+				t.Fatalf("claimStaleMigration: %v", err)
+			}
+			if (m != nil) != tt.want {
+				t.Fatalf("claimStaleMigration = %+v, want claimed %v", m, tt.want)
+			}
+			if m == nil {
+				return
+			}
+			// 接手後更新心跳，其他執行個體不會重複處理
+			if again, err := claimStaleMigration(env.ctx, ref, cutoff); err != nil || again != nil {
+				t.Errorf("second claim = %+v, %v, want nil", again, err)
+			}
+		})
+	}
+}
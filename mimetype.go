@@ -0,0 +1,84 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// --- MIME 類型 ---
+
+// http.DetectContentType 最多只看前 512 個位元組
+const mimeSniffLen = 512
+
+// 常見類型的副檔名；mime.ExtensionsByType 的結果依系統的 mime.types 而定，且順序不固定
+var mimeExtensions = map[string]string{
+	"application/pdf":                         ".pdf",
+	"application/zip":                         ".zip",
+	"application/x-gzip":                      ".gz",
+	"application/x-tgsticker":                 ".tgs",
+	"application/json":                        ".json",
+	"application/vnd.android.package-archive": ".apk",
+	"audio/mpeg":                              ".mp3",
+	"audio/ogg":                               ".ogg",
+	"audio/wave":                              ".wav",
+	"image/bmp":                               ".bmp",
+	"image/gif":                               ".gif",
+	"image/heic":                              ".heic",
+	"image/jpeg":                              ".jpg",
+	"image/png":                               ".png",
+	"image/webp":                              ".webp",
+	"text/plain":                              ".txt",
+	"video/mp4":                               ".mp4",
+	"video/quicktime":                         ".mov",
+	"video/webm":                              ".webm",
+}
+
+// detectMimeType 決定上傳時的 MIME 類型：優先使用 Telegram 提供的類型，
+// 沒有或只是 application/octet-stream 時改從內容開頭判斷，仍無法判斷時再依副檔名猜測；
+// 都無法判斷時回傳空字串，交給目的地自行判斷
+func detectMimeType(telegramType, name string, head []byte) string {
+	if t := baseMimeType(telegramType); t != "" && t != "application/octet-stream" {
+		return t
+	}
+	if len(head) > 0 {
+		if t := baseMimeType(http.DetectContentType(head)); t != "application/octet-stream" {
+			return t
+		}
+	}
+	if t := baseMimeType(mime.TypeByExtension(path.Ext(name))); t != "" {
+		return t
+	}
+	return ""
+}
+
+// baseMimeType 去掉 charset 等參數，例如 "text/plain; charset=utf-8" 會變成 "text/plain"
+func baseMimeType(t string) string {
+	t, _, _ = strings.Cut(t, ";")
+	return strings.ToLower(strings.TrimSpace(t))
+}
+
+// withMimeExtension 在檔名沒有副檔名時依 MIME 類型補上，讓目的地能正確預覽檔案
+func withMimeExtension(name, mimeType string) string {
+	if path.Ext(name) != "" {
+		return name
+	}
+	if ext, ok := mimeExtensions[mimeType]; ok {
+		return name + ext
+	}
+	if exts, err := mime.ExtensionsByType(mimeType); err == nil && len(exts) > 0 {
+		return name + exts[0]
+	}
+	return name
+}
+
+// mimeTypeByExt 依副檔名回傳 MIME 類型，找不到時回傳空字串
+func mimeTypeByExt(ext string) string {
+	for t, e := range mimeExtensions {
+		if e == ext {
+			return t
+		}
+	}
+	return baseMimeType(mime.TypeByExtension(ext))
+}
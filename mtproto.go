@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/fileid"
+	"github.com/gotd/td/session"
+	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/telegram/downloader"
+	"github.com/gotd/td/tg"
+)
+
+// --- MTProto 下載 ---
+
+// Firestore 集合名稱
+const mtprotoSessionCollection = "mtproto_sessions"
+
+// 連線、登入到收到第一個區塊的時限，超過時改用 Bot API
+const mtprotoStartTimeout = 30 * time.Second
+
+// FileDownloader 以 Bot API 的 file_id 直接下載檔案，不經過 getFile，因此不受 Bot API 20 MB 的下載上限限制
+type FileDownloader interface {
+	// Open 開始下載檔案，呼叫端負責關閉；無法下載時回傳錯誤，呼叫端可以改用 Bot API
+	Open(ctx context.Context, fileID string) (io.ReadCloser, error)
+}
+
+// mtprotoDownloader 以機器人的權杖登入 MTProto 下載檔案
+// Bot API 的 file_id 帶有只對同一個機器人有效的 access hash，使用者帳號也讀不到別人與機器人的私訊，因此以機器人的身分登入；
+// 授權金鑰存在 mtproto_sessions 集合中，重新啟動後不需要重新登入
+type mtprotoDownloader struct {
+	appID   int
+	appHash string
+	token   string
+	session session.Storage
+}
+
+func newMTProtoDownloader(cfg MTProtoConfig, token string, ref *DocRef) *mtprotoDownloader {
+	return &mtprotoDownloader{appID: cfg.APIID, appHash: cfg.APIHash, token: token, session: mtprotoSessionStorage{ref: ref}}
+}
+
+// Open 每次下載都建立一條新的連線，下載結束或 body 關閉時中斷；收到第一個區塊才回傳，
+// 讓 file_id 無法解析、檔案參照過期或連不上 Telegram 等錯誤可以在開始上傳前改用 Bot API
+func (d *mtprotoDownloader) Open(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	location, err := mtprotoFileLocation(fileID)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	started := &startedWriter{w: pw, started: make(chan struct{})}
+	done := make(chan error, 1)
+	client := telegram.NewClient(d.appID, d.appHash, telegram.Options{SessionStorage: d.session, NoUpdates: true})
+	go func() {
+		err := client.Run(ctx, func(ctx context.Context) error {
+			if err := d.authorize(ctx, client); err != nil {
+				return err
+			}
+			_, err := downloader.NewDownloader().Download(client.API(), location).Stream(ctx, started)
+			return err
+		})
+		pw.CloseWithError(err)
+		done <- err
+	}()
+
+	timer := time.NewTimer(mtprotoStartTimeout)
+	defer timer.Stop()
+	select {
+	case <-started.started:
+		return &mtprotoBody{PipeReader: pr, cancel: cancel}, nil
+	case err := <-done:
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("mtproto download failed: %w", err)
+		}
+		// 空的檔案沒有任何區塊
+		return &mtprotoBody{PipeReader: pr, cancel: cancel}, nil
+	case <-timer.C:
+		cancel()
+		return nil, fmt.Errorf("mtproto download did not start within %s", mtprotoStartTimeout)
+	}
+}
+
+// authorize 在 session 尚未登入時以機器人的權杖登入
+func (d *mtprotoDownloader) authorize(ctx context.Context, client *telegram.Client) error {
+	status, err := client.Auth().Status(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check auth status: %w", err)
+	}
+	if status.Authorized {
+		return nil
+	}
+	if _, err := client.Auth().Bot(ctx, d.token); err != nil {
+		return fmt.Errorf("failed to log in as bot: %w", err)
+	}
+	return nil
+}
+
+// mtprotoFileLocation 將 Bot API 的 file_id 轉成 MTProto 的檔案位置
+func mtprotoFileLocation(fileID string) (tg.InputFileLocationClass, error) {
+	id, err := fileid.DecodeFileID(fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode file_id: %w", err)
+	}
+	location, ok := id.AsInputFileLocation()
+	if !ok {
+		return nil, fmt.Errorf("file type %s cannot be downloaded with mtproto", id.Type)
+	}
+	return location, nil
+}
+
+// startedWriter 在第一次寫入時關閉 started
+type startedWriter struct {
+	w       io.Writer
+	once    sync.Once
+	started chan struct{}
+}
+
+func (s *startedWriter) Write(p []byte) (int, error) {
+	s.once.Do(func() { close(s.started) })
+	return s.w.Write(p)
+}
+
+// mtprotoBody 是下載中的內容，關閉時一併中斷連線
+type mtprotoBody struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (b *mtprotoBody) Close() error {
+	b.cancel()
+	return b.PipeReader.Close()
+}
+
+// mtprotoSessionStorage 將 MTProto 的 session 存在 Firestore，授權金鑰等同機器人的權杖
+type mtprotoSessionStorage struct {
+	ref *DocRef
+}
+
+// MTProtoSession 是儲存的 session，Data 是 gotd 序列化後的 JSON
+type MTProtoSession struct {
+	Data      string    `firestore:"data"`
+	UpdatedAt time.Time `firestore:"updated_at"`
+}
+
+func (s mtprotoSessionStorage) LoadSession(ctx context.Context) ([]byte, error) {
+	doc, err := s.ref.Get(ctx)
+	if errors.Is(err, errDocNotFound) {
+		return nil, session.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var data MTProtoSession
+	if err := doc.DataTo(&data); err != nil {
+		return nil, err
+	}
+	if data.Data == "" {
+		return nil, session.ErrNotFound
+	}
+	return []byte(data.Data), nil
+}
+
+func (s mtprotoSessionStorage) StoreSession(ctx context.Context, data []byte) error {
+	return s.ref.Set(ctx, &MTProtoSession{Data: string(data), UpdatedAt: time.Now()})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/gotd/td/fileid"
+	"github.com/gotd/td/session"
+	"github.com/gotd/td/tg"
+)
+
+func TestMTProtoFileLocation(t *testing.T) {
+	fileID, err := fileid.EncodeFileID(fileid.FileID{Type: fileid.Document, DC: 2, ID: 42, AccessHash: 7, FileReference: []byte{1, 2, 3}})
+	if err != nil {
+		t.Fatalf("EncodeFileID: %v", err)
+	}
+	location, err := mtprotoFileLocation(fileID)
+	if err != nil {
+		t.Fatalf("mtprotoFileLocation: %v", err)
+	}
+	doc, ok := location.(*tg.InputDocumentFileLocation)
+	if !ok {
+		t.Fatalf("location = %T, want *tg.InputDocumentFileLocation", location)
+	}
+	if doc.ID != 42 || doc.AccessHash != 7 {
+		t.Errorf("location = %+v, want ID 42 and AccessHash 7", doc)
+	}
+
+	if _, err := mtprotoFileLocation("not-a-file-id"); err == nil {
+		t.Error("mtprotoFileLocation(invalid) = nil error, want an error")
+	}
+}
+
+func TestMTProtoSessionStorage(t *testing.T) {
+	env := newHandlerEnv(t)
+	storage := mtprotoSessionStorage{ref: rawCollection(mtprotoSessionCollection).Doc("123456")}
+
+	if _, err := storage.LoadSession(env.ctx); !errors.Is(err, session.ErrNotFound) {
+		t.Fatalf("LoadSession before login = %v, want session.ErrNotFound", err)
+	}
+	if err := storage.StoreSession(env.ctx, []byte(`{"Version":1}`)); err != nil {
+		t.Fatalf("StoreSession: %v", err)
+	}
+	data, err := storage.LoadSession(env.ctx)
+	if err != nil {
+		t.Fatalf("LoadSession: %v", err)
+	}
+	if string(data) != `{"Version":1}` {
+		t.Errorf("LoadSession = %q, want the stored session", data)
+	}
+}
+
+func TestOpenTelegramFileByID(t *testing.T) {
+	tests := []struct {
+		name    string
+		mtproto *fakeMTProto
+		want    string
+	}{
+		{name: "bot api", want: "from bot api"},
+		{name: "mtproto", mtproto: &fakeMTProto{files: map[string][]byte{"doc": []byte("from mtproto")}}, want: "from mtproto"},
+		{name: "mtproto fails", mtproto: &fakeMTProto{openErr: errors.New("connection refused")}, want: "from bot api"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newHandlerEnv(t)
+			env.files.addFile("doc", []byte("from bot api"))
+			if tt.mtproto != nil {
+				env.useMTProto(tt.mtproto)
+			}
+
+			download, err := openTelegramFileByID(env.ctx, "doc")
+			if err != nil {
+				t.Fatalf("openTelegramFileByID: %v", err)
+			}
+			defer download.Body.Close()
+			content, err := io.ReadAll(download.Body)
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			if string(content) != tt.want {
+				t.Errorf("content = %q, want %q", content, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxFileSizeWithMTProto(t *testing.T) {
+	cfg := defaultConfig()
+	if got := cfg.maxFileSize(); got != cloudMaxFileSizeMB<<20 {
+		t.Errorf("maxFileSize() = %d, want the Bot API limit", got)
+	}
+	cfg.MTProto = MTProtoConfig{APIID: 12345, APIHash: "hash"}
+	if got := cfg.maxFileSize(); got != localMaxFileSizeMB<<20 {
+		t.Errorf("maxFileSize() with MTProto = %d, want %d", got, localMaxFileSizeMB<<20)
+	}
+}
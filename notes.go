@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"google.golang.org/api/drive/v3"
+)
+
+// --- 文字筆記 ---
+
+// 筆記存放的 Drive 資料夾，每天一個 Markdown 檔案
+const notesFolder = "Telegram Notes"
+
+const noteMimeType = "text/markdown"
+
+// 處理 /note 指令：將文字存成筆記，或切換是否自動儲存私訊中的文字訊息
+func handleNote(ctx context.Context, message *tgbotapi.Message) {
+	args := strings.TrimSpace(message.CommandArguments())
+
+	if fields := strings.Fields(args); len(fields) == 2 && fields[0] == "auto" {
+		on, ok := parseOnOff(fields[1])
+		if !ok {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, noteUsage)
+			return
+		}
+		if err := updateUserSettings(ctx, message.From.ID, map[string]interface{}{"note_auto": on}); err != nil {
+			slog.ErrorContext(ctx, "Failed to update settings", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "更新設定時發生錯誤，請稍後再試。")
+			return
+		}
+		if on {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "已開啟自動筆記，之後在私訊中傳送的文字都會存到 Google Drive 的「"+notesFolder+"」資料夾。")
+		} else {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "已關閉自動筆記。")
+		}
+		return
+	}
+
+	// 回覆一則文字訊息並輸入 /note 時，儲存被回覆的訊息
+	text := args
+	if text == "" && message.ReplyToMessage != nil {
+		text = message.ReplyToMessage.Text
+	}
+	if text == "" {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, noteUsage)
+		return
+	}
+	saveNoteAndReply(ctx, message, text)
+}
+
+const noteUsage = "用法：\n/note <文字> - 將文字存成筆記\n回覆一則訊息並輸入 /note - 儲存該則訊息\n/note auto on|off - 自動儲存私訊中的所有文字訊息"
+
+// handleAutoNote 在使用者開啟自動筆記時儲存私訊中的文字訊息，未開啟時回傳 false
+func handleAutoNote(ctx context.Context, message *tgbotapi.Message) bool {
+	if !message.Chat.IsPrivate() || message.From == nil || message.Text == "" {
+		return false
+	}
+	settings, err := loadUserSettings(ctx, message.From.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load user settings", "error", err)
+		return false
+	}
+	if !settings.NoteAuto {
+		return false
+	}
+	saveNoteAndReply(ctx, message, message.Text)
+	return true
+}
+
+func saveNoteAndReply(ctx context.Context, message *tgbotapi.Message, text string) {
+	file, err := saveNote(ctx, message, text)
+	if errors.Is(err, errNotConnected) {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "筆記會存到 Google Drive，請先使用 /connect_drive 連結帳號。")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to save note", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "儲存筆記時發生錯誤，請稍後再試。")
+		return
+	}
+	slog.InfoContext(ctx, "Note saved", "file_id", file.Id)
+	reply := dryRunReply(ctx, fmt.Sprintf("已將筆記存到「%s/%s」。", notesFolder, file.Name))
+	if file.WebViewLink != "" {
+		reply += "\n" + file.WebViewLink
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, reply)
+}
+
+// saveNote 將文字附加到當天的筆記檔案，檔案不存在時建立新檔
+func saveNote(ctx context.Context, message *tgbotapi.Message, text string) (*drive.File, error) {
+	driveService, err := newDriveService(ctx, message.From.ID)
+	if err != nil {
+		return nil, err
+	}
+	if dryRun(ctx) {
+		name := time.Unix(int64(message.Date), 0).Format("2006-01-02") + ".md"
+		slog.InfoContext(ctx, "Dry run: skipped saving note", "file_name", name)
+		return &drive.File{Name: name}, nil
+	}
+	folderID, err := ensureDriveFolder(ctx, driveService, []string{notesFolder})
+	if err != nil {
+		return nil, err
+	}
+
+	date := time.Unix(int64(message.Date), 0)
+	name := date.Format("2006-01-02") + ".md"
+	entry := formatNoteEntry(message, date, text)
+
+	query := fmt.Sprintf("name = '%s' and '%s' in parents and trashed = false", escapeDriveQuery(name), folderID)
+	list, err := driveService.Files.List().Q(query).Fields("files(id)").PageSize(1).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up note file: %v", err)
+	}
+
+	spanCtx, span := startSpan(ctx, "drive.save_note")
+	defer span.End()
+
+	if len(list.Files) == 0 {
+		content := "# " + date.Format("2006-01-02") + "\n\n" + entry
+		return driveService.Files.Create(&drive.File{Name: name, MimeType: noteMimeType, Parents: []string{folderID}}).
+			Media(strings.NewReader(content)).Fields("id", "name", "webViewLink").Context(spanCtx).Do()
+	}
+
+	// Drive 無法直接附加內容，因此讀出原本的內容後整份更新
+	fileID := list.Files[0].Id
+	resp, err := driveService.Files.Get(fileID).Context(spanCtx).Download()
+	if err != nil {
+		return nil, fmt.Errorf("failed to download note file: %v", err)
+	}
+	existing, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read note file: %v", err)
+	}
+	content := strings.TrimRight(string(existing), "\n") + "\n\n" + entry
+	return driveService.Files.Update(fileID, &drive.File{}).
+		Media(strings.NewReader(content)).Fields("id", "name", "webViewLink").Context(spanCtx).Do()
+}
+
+// formatNoteEntry 產生一則筆記的 Markdown，群組中的筆記會標示傳送者與聊天室
+func formatNoteEntry(message *tgbotapi.Message, date time.Time, text string) string {
+	heading := "## " + date.Format("15:04")
+	if !message.Chat.IsPrivate() {
+		heading += fmt.Sprintf(" — %s（%s）", userDisplayName(message.From), chatDisplayName(message.Chat))
+	}
+	return heading + "\n\n" + strings.TrimSpace(text) + "\n"
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// --- OAuth 回呼頁面 ---
+
+// 回呼頁面的種類，對應 oauthPageTexts 中的文字
+const (
+	oauthPageSuccess       = "success"
+	oauthPageCancelled     = "cancelled"
+	oauthPageInvalidState  = "invalid_state"
+	oauthPageStateUsed     = "state_used"
+	oauthPageExchangeError = "exchange_failed"
+	oauthPageServerError   = "server_error"
+)
+
+// oauthPageTexts 依語言列出每種頁面的標題與說明；沒有對應語言時使用 defaultLanguage
+var oauthPageTexts = map[string]map[string][2]string{
+	"zh-TW": {
+		oauthPageSuccess:       {"授權成功", "您現在可以回到 Telegram 傳送檔案給機器人了。"},
+		oauthPageCancelled:     {"已取消授權", "您沒有同意授權，因此沒有連結任何帳號。需要時可以回到 Telegram 重新連結。"},
+		oauthPageInvalidState:  {"授權連結無效", "這個授權連結無法辨識，請回到 Telegram 重新輸入連結指令取得新的連結。"},
+		oauthPageStateUsed:     {"授權連結已失效", "這個授權連結無效或已經使用過了，請回到 Telegram 重新輸入連結指令取得新的連結。"},
+		oauthPageExchangeError: {"授權失敗", "無法完成授權，請回到 Telegram 重新連結一次。"},
+		oauthPageServerError:   {"發生錯誤", "驗證授權時發生錯誤，請稍後再試。"},
+	},
+	"en": {
+		oauthPageSuccess:       {"Authorization complete", "You can go back to Telegram and send files to the bot."},
+		oauthPageCancelled:     {"Authorization cancelled", "You did not grant access, so no account was connected. You can connect again from Telegram at any time."},
+		oauthPageInvalidState:  {"Invalid link", "This authorization link is not recognized. Go back to Telegram and run the connect command again to get a new link."},
+		oauthPageStateUsed:     {"Link expired", "This authorization link is invalid or has already been used. Go back to Telegram and run the connect command again to get a new link."},
+		oauthPageExchangeError: {"Authorization failed", "The authorization could not be completed. Go back to Telegram and try connecting again."},
+		oauthPageServerError:   {"Something went wrong", "An error occurred while verifying the authorization. Please try again later."},
+	},
+}
+
+var oauthPageButtons = map[string]string{
+	"zh-TW": "回到 Telegram",
+	"en":    "Back to Telegram",
+}
+
+var oauthPageTemplate = template.Must(template.New("oauth").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", "Noto Sans TC", sans-serif; background: #f4f6f8; color: #222; margin: 0; display: flex; min-height: 100vh; align-items: center; justify-content: center; }
+main { background: #fff; border-radius: 12px; box-shadow: 0 2px 12px rgba(0,0,0,.08); padding: 32px; max-width: 420px; margin: 16px; text-align: center; }
+.icon { font-size: 48px; }
+h1 { font-size: 22px; margin: 12px 0; }
+p { line-height: 1.6; color: #555; }
+a.button { display: inline-block; margin-top: 16px; padding: 12px 24px; border-radius: 8px; background: #2aabee; color: #fff; text-decoration: none; font-weight: 600; }
+</style>
+</head>
+<body>
+<main>
+<div class="icon">{{if .Success}}✅{{else}}⚠️{{end}}</div>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+{{if .BotURL}}<a class="button" href="{{.BotURL}}">{{.Button}}</a>{{end}}
+</main>
+</body>
+</html>
+`))
+
+type oauthPage struct {
+	Lang    string
+	Title   string
+	Message string
+	Button  string
+	BotURL  string
+	Success bool
+}
+
+// renderOAuthPage 回應授權結果的 HTML 頁面，附上回到機器人聊天室的 t.me 連結
+// lang 為空字串時依瀏覽器的 Accept-Language 決定語言
+func renderOAuthPage(ctx context.Context, w http.ResponseWriter, r *http.Request, status int, kind, lang string) {
+	if lang == "" {
+		lang = acceptLanguage(r)
+	}
+	lang = pageLanguage(lang)
+	texts := oauthPageTexts[lang]
+	page := oauthPage{
+		Lang:    lang,
+		Title:   texts[kind][0],
+		Message: texts[kind][1],
+		Button:  oauthPageButtons[lang],
+		Success: kind == oauthPageSuccess,
+	}
+	if bot := botFor(ctx); bot != nil && bot.Self.UserName != "" {
+		page.BotURL = "https://t.me/" + bot.Self.UserName
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := oauthPageTemplate.Execute(w, page); err != nil {
+		slog.WarnContext(ctx, "Failed to render OAuth page", "error", err)
+	}
+}
+
+// pageLanguage 將語言代碼對應到有翻譯的語言：zh 開頭使用繁體中文，en 開頭使用英文
+func pageLanguage(lang string) string {
+	lang = strings.ToLower(lang)
+	switch {
+	case strings.HasPrefix(lang, "en"):
+		return "en"
+	case strings.HasPrefix(lang, "zh"):
+		return "zh-TW"
+	}
+	return defaultLanguage
+}
+
+// acceptLanguage 回傳 Accept-Language 標頭中的第一個語言
+func acceptLanguage(r *http.Request) string {
+	lang, _, _ := strings.Cut(r.Header.Get("Accept-Language"), ",")
+	lang, _, _ = strings.Cut(lang, ";")
+	return strings.TrimSpace(lang)
+}
+
+// oauthPageLanguage 回傳使用者在 /settings 中選擇的語言，沒有選擇時回傳空字串
+func oauthPageLanguage(ctx context.Context, userID int64) string {
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to load language preference", "error", err)
+		return ""
+	}
+	return settings.Language
+}
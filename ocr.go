@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"google.golang.org/api/vision/v1"
+)
+
+// --- 文字辨識 ---
+
+// Cloud Vision 直接傳送內容時，圖片最大 10 MB
+const maxOCRImageSize = 10 * 1024 * 1024
+
+// Drive 檔案描述的長度上限，超過時改存成 .txt
+const maxDriveDescriptionRunes = 25000
+
+var visionService *vision.Service
+
+// initOCR 在 ENABLE_OCR 開啟時建立 Cloud Vision 用戶端，並註冊上傳後的文字辨識步驟
+func initOCR(ctx context.Context, cfg AIConfig) error {
+	if !cfg.OCR {
+		return nil
+	}
+	svc, err := vision.NewService(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create vision service: %v", err)
+	}
+	visionService = svc
+	registerPostProcessor(&postProcessor{
+		name: "ocr",
+		wants: func(settings *UserSettings, file *telegramFile) bool {
+			return settings.OCR && strings.HasPrefix(file.MimeType, "image/") && file.Size <= maxOCRImageSize
+		},
+		process: processOCR,
+	})
+	return nil
+}
+
+// processOCR 辨識圖片中的文字：Google Drive 寫入檔案描述讓檔案可以被搜尋，其他目的地存成同名的 .txt
+func processOCR(ctx context.Context, u *uploadedFile) error {
+	if len(u.Content) == 0 || len(u.Content) > maxOCRImageSize {
+		return nil
+	}
+	text, err := detectText(ctx, u.Content)
+	if err != nil {
+		return err
+	}
+	if text == "" {
+		return nil
+	}
+
+	if u.Dest.Name() == "drive" {
+		description := text
+		if prefix := uploadDescription(u.Upload.Caption, u.Upload.Origin); prefix != "" {
+			description = prefix + "\n\n" + text
+		}
+		if utf8.RuneCountInString(description) <= maxDriveDescriptionRunes {
+			if u.Result.Account != "" {
+				ctx = withGoogleAccount(ctx, u.Result.Account)
+			}
+			return setDriveDescription(ctx, u.UserID, u.Result.FileID, description)
+		}
+	}
+	_, err = saveSidecarText(ctx, u, text)
+	return err
+}
+
+// detectText 以 Cloud Vision 的 DOCUMENT_TEXT_DETECTION 辨識圖片中的文字，沒有文字時回傳空字串
+func detectText(ctx context.Context, image []byte) (string, error) {
+	ctx, span := startSpan(ctx, "vision.annotate")
+	resp, err := visionService.Images.Annotate(&vision.BatchAnnotateImagesRequest{
+		Requests: []*vision.AnnotateImageRequest{{
+			Image:    &vision.Image{Content: base64.StdEncoding.EncodeToString(image)},
+			Features: []*vision.Feature{{Type: "DOCUMENT_TEXT_DETECTION"}},
+		}},
+	}).Context(ctx).Do()
+	endSpan(span, err)
+	if err != nil {
+		return "", fmt.Errorf("failed to annotate image: %v", err)
+	}
+	if len(resp.Responses) == 0 {
+		return "", nil
+	}
+	r := resp.Responses[0]
+	if r.Error != nil {
+		return "", fmt.Errorf("failed to annotate image: %s", r.Error.Message)
+	}
+	if r.FullTextAnnotation == nil {
+		return "", nil
+	}
+	return strings.TrimSpace(r.FullTextAnnotation.Text), nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/microsoft"
+)
+
+// Firestore 集合名稱
+const oneDriveTokenCollection = "onedrive_tokens"
+
+const graphAPIURL = "https://graph.microsoft.com/v1.0"
+
+// 上傳工作階段每個片段的大小，Graph API 要求必須是 320 KiB 的倍數
+const oneDriveChunkSize = 320 * 1024 * 32 // 10 MiB
+
+// oneDriveDestination 透過 Microsoft Graph 的上傳工作階段將檔案上傳到使用者的 OneDrive
+// 只要求 Files.ReadWrite.AppFolder 權限，檔案會放在 OneDrive 的「應用程式/<App 名稱>」資料夾中
+type oneDriveDestination struct {
+	config *oauth2.Config
+}
+
+// newOneDriveDestination 在設定了 MICROSOFT_CLIENT_ID 與 MICROSOFT_CLIENT_SECRET 時啟用 OneDrive
+func newOneDriveDestination(cfg OneDriveConfig) (*oneDriveDestination, bool) {
+	if cfg.ClientID == "" || cfg.ClientSecret == "" {
+		return nil, false
+	}
+	redirectURL := cfg.RedirectURL
+	if redirectURL == "" {
+		redirectURL = defaultTenant.OAuth.RedirectURL
+	}
+	return &oneDriveDestination{config: &oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"Files.ReadWrite.AppFolder", "offline_access"},
+		Endpoint:     microsoft.AzureADEndpoint(cfg.Tenant),
+	}}, true
+}
+
+func (*oneDriveDestination) Name() string        { return "onedrive" }
+func (*oneDriveDestination) DisplayName() string { return "OneDrive" }
+
+func (*oneDriveDestination) Connected(ctx context.Context, userID int64) (bool, error) {
+	return hasUserToken(ctx, oneDriveTokenCollection, userID)
+}
+
+func (d *oneDriveDestination) Connect(ctx context.Context, userID int64) (string, error) {
+	state, challenge, err := newOAuthState(ctx, userID, d.Name())
+	if err != nil {
+		return "", err
+	}
+	return d.config.AuthCodeURL(state, challenge), nil
+}
+
+func (d *oneDriveDestination) Exchange(ctx context.Context, userID int64, code, verifier string) error {
+	token, err := d.config.Exchange(ctx, code, verifierOptions(verifier)...)
+	if err != nil {
+		return fmt.Errorf("failed to exchange microsoft token: %v", err)
+	}
+	return saveUserToken(ctx, oneDriveTokenCollection, userID, token)
+}
+
+// graphDriveItem 是 Graph API 回傳的檔案資訊
+type graphDriveItem struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	WebURL string `json:"webUrl"`
+}
+
+func (d *oneDriveDestination) Upload(ctx context.Context, userID int64, file *UploadFile) (*UploadResult, error) {
+	client, err := d.client(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 上傳工作階段的每個片段都必須帶上檔案總大小，因此先讀入記憶體；檔案大小已受 maxFileSize 限制
+	content, err := io.ReadAll(file.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := append(append([]string{}, file.Folders...), file.Name)
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	itemURL := graphAPIURL + "/me/drive/special/approot:/" + strings.Join(segments, "/")
+
+	// 上傳工作階段不接受空檔案，改用一般的上傳方式
+	var item graphDriveItem
+	if len(content) == 0 {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, itemURL+":/content?@microsoft.graph.conflictBehavior=rename", http.NoBody)
+		if err != nil {
+			return nil, err
+		}
+		if err := doGraphRequest(client, req, &item); err != nil {
+			return nil, err
+		}
+		return &UploadResult{FileID: item.ID, Name: item.Name, Link: item.WebURL}, nil
+	}
+
+	// 1. 建立上傳工作階段，檔名衝突時由 OneDrive 自動改名
+	sessionItem := map[string]string{"@microsoft.graph.conflictBehavior": "rename"}
+	if description := uploadDescription(file.Caption, file.Origin); description != "" {
+		sessionItem["description"] = description
+	}
+	body, _ := json.Marshal(map[string]interface{}{"item": sessionItem})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, itemURL+":/createUploadSession", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var session struct {
+		UploadURL string `json:"uploadUrl"`
+	}
+	if err := doGraphRequest(client, req, &session); err != nil {
+		return nil, err
+	}
+
+	// 2. 依序上傳每個片段；uploadUrl 已包含授權資訊，不能再帶 Authorization 標頭
+	total := len(content)
+	for start := 0; start < total; start += oneDriveChunkSize {
+		end := min(start+oneDriveChunkSize, total)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, session.UploadURL, bytes.NewReader(content[start:end]))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, total))
+		if err := doGraphRequest(instrumentedClient, req, &item); err != nil {
+			return nil, err
+		}
+	}
+	return &UploadResult{FileID: item.ID, Name: item.Name, Link: item.WebURL}, nil
+}
+
+func (d *oneDriveDestination) Link(ctx context.Context, userID int64, fileID string) (string, error) {
+	client, err := d.client(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, graphAPIURL+"/me/drive/items/"+url.PathEscape(fileID)+"?$select=webUrl", nil)
+	if err != nil {
+		return "", err
+	}
+	var item graphDriveItem
+	if err := doGraphRequest(client, req, &item); err != nil {
+		return "", err
+	}
+	return item.WebURL, nil
+}
+
+// client 建立一個使用使用者權杖、會自動重新整理 access token 的 HTTP client
+func (d *oneDriveDestination) client(ctx context.Context, userID int64) (*http.Client, error) {
+	token, err := loadUserToken(ctx, oneDriveTokenCollection, userID)
+	if err != nil {
+		return nil, err
+	}
+	return d.config.Client(withTracedHTTPClient(ctx), token), nil
+}
+
+// doGraphRequest 送出請求並將 JSON 回應解碼到 out；202 Accepted（片段已接收）不解碼
+func doGraphRequest(client *http.Client, req *http.Request, out interface{}) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return json.NewDecoder(resp.Body).Decode(out)
+	case http.StatusAccepted:
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("graph API %s returned %s: %s", req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"golang.org/x/oauth2"
+)
+
+// --- Google Photos ---
+
+// Firestore 集合名稱
+const photosTokenCollection = "photos_tokens"
+
+const photosAPIURL = "https://photoslibrary.googleapis.com/v1"
+
+// Google Photos 只需要新增媒體與讀取機器人自己建立的相簿，不會讀取使用者既有的照片
+var photosScopes = []string{
+	"https://www.googleapis.com/auth/photoslibrary.appendonly",
+	"https://www.googleapis.com/auth/photoslibrary.readonly.appcreateddata",
+}
+
+// 媒體描述的長度上限
+const maxPhotosDescription = 1000
+
+// photosDestination 透過 Photos Library API 將照片與影片上傳到使用者的 Google Photos
+// 資料夾路徑會對應到同名的相簿；權杖與 Google Drive 分開存放，連結時另外授權
+type photosDestination struct{}
+
+func (photosDestination) Name() string        { return "photos" }
+func (photosDestination) DisplayName() string { return "Google Photos" }
+
+// AcceptsMimeType 回報 Google Photos 是否接受這種檔案；SVG 雖然是圖片但 Photos 不支援
+func (photosDestination) AcceptsMimeType(mimeType string) bool {
+	if mimeType == "image/svg+xml" {
+		return false
+	}
+	return strings.HasPrefix(mimeType, "image/") || strings.HasPrefix(mimeType, "video/")
+}
+
+func (photosDestination) Connected(ctx context.Context, userID int64) (bool, error) {
+	return hasUserToken(ctx, photosTokenCollection, userID)
+}
+
+func (d photosDestination) Connect(ctx context.Context, userID int64) (string, error) {
+	state, challenge, err := newOAuthState(ctx, userID, d.Name())
+	if err != nil {
+		return "", err
+	}
+	return photosOAuth(ctx).AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce, challenge), nil
+}
+
+func (photosDestination) Exchange(ctx context.Context, userID int64, code, verifier string) error {
+	token, err := photosOAuth(ctx).Exchange(ctx, code, verifierOptions(verifier)...)
+	if err != nil {
+		return fmt.Errorf("failed to exchange token: %v", err)
+	}
+	return saveUserToken(ctx, photosTokenCollection, userID, token)
+}
+
+// photosMediaItem 是 Photos Library API 回傳的媒體資訊
+type photosMediaItem struct {
+	ID         string `json:"id"`
+	ProductURL string `json:"productUrl"`
+	Filename   string `json:"filename"`
+}
+
+// Upload 先以 raw 方式上傳內容取得 upload token，再建立媒體項目並加入資料夾對應的相簿
+func (d photosDestination) Upload(ctx context.Context, userID int64, file *UploadFile) (*UploadResult, error) {
+	client, err := d.client(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	mimeType := file.MimeType
+	if mimeType == "" {
+		mimeType = mimeTypeByExt(path.Ext(file.Name))
+	}
+
+	// 1. 上傳內容，回應的內容就是 upload token
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, photosAPIURL+"/uploads", file.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Goog-Upload-Content-Type", mimeType)
+	req.Header.Set("X-Goog-Upload-Protocol", "raw")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	uploadToken, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("photos API /uploads returned %s: %s", resp.Status, strings.TrimSpace(string(uploadToken)))
+	}
+
+	// 2. 建立媒體項目；相簿只能是機器人自己建立的
+	newItem := map[string]interface{}{
+		"simpleMediaItem": map[string]string{"uploadToken": string(uploadToken), "fileName": file.Name},
+	}
+	if description := uploadDescription(file.Caption, file.Origin); description != "" {
+		if r := []rune(description); len(r) > maxPhotosDescription {
+			description = string(r[:maxPhotosDescription])
+		}
+		newItem["description"] = description
+	}
+	batch := map[string]interface{}{"newMediaItems": []interface{}{newItem}}
+	if len(file.Folders) > 0 {
+		albumID, err := d.ensureAlbum(ctx, client, strings.Join(file.Folders, "/"))
+		if err != nil {
+			return nil, err
+		}
+		batch["albumId"] = albumID
+	}
+	var created struct {
+		NewMediaItemResults []struct {
+			Status struct {
+				Code    int    `json:"code"`
+				Message string `json:"message"`
+			} `json:"status"`
+			MediaItem photosMediaItem `json:"mediaItem"`
+		} `json:"newMediaItemResults"`
+	}
+	if err := doPhotosRequest(ctx, client, http.MethodPost, "/mediaItems:batchCreate", batch, &created); err != nil {
+		return nil, err
+	}
+	if len(created.NewMediaItemResults) == 0 {
+		return nil, fmt.Errorf("photos API returned no media item")
+	}
+	result := created.NewMediaItemResults[0]
+	if result.MediaItem.ID == "" {
+		return nil, fmt.Errorf("failed to create media item: %s", result.Status.Message)
+	}
+	return &UploadResult{FileID: result.MediaItem.ID, Name: result.MediaItem.Filename, Link: result.MediaItem.ProductURL}, nil
+}
+
+func (d photosDestination) Link(ctx context.Context, userID int64, fileID string) (string, error) {
+	client, err := d.client(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	var item photosMediaItem
+	if err := doPhotosRequest(ctx, client, http.MethodGet, "/mediaItems/"+url.PathEscape(fileID), nil, &item); err != nil {
+		return "", err
+	}
+	return item.ProductURL, nil
+}
+
+// ensureAlbum 尋找機器人建立過的同名相簿，沒有時建立一個，回傳相簿 ID
+func (photosDestination) ensureAlbum(ctx context.Context, client *http.Client, title string) (string, error) {
+	pageToken := ""
+	for {
+		var list struct {
+			Albums []struct {
+				ID    string `json:"id"`
+				Title string `json:"title"`
+			} `json:"albums"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		query := url.Values{"pageSize": {"50"}, "excludeNonAppCreatedData": {"true"}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		if err := doPhotosRequest(ctx, client, http.MethodGet, "/albums?"+query.Encode(), nil, &list); err != nil {
+			return "", fmt.Errorf("failed to list albums: %w", err)
+		}
+		for _, a := range list.Albums {
+			if a.Title == title {
+				return a.ID, nil
+			}
+		}
+		if list.NextPageToken == "" {
+			break
+		}
+		pageToken = list.NextPageToken
+	}
+
+	var album struct {
+		ID string `json:"id"`
+	}
+	if err := doPhotosRequest(ctx, client, http.MethodPost, "/albums", map[string]interface{}{"album": map[string]string{"title": title}}, &album); err != nil {
+		return "", fmt.Errorf("failed to create album %q: %w", title, err)
+	}
+	slog.InfoContext(ctx, "Created Google Photos album", "album_id", album.ID)
+	return album.ID, nil
+}
+
+// client 建立一個使用使用者權杖、會自動重新整理 access token 的 HTTP client
+func (photosDestination) client(ctx context.Context, userID int64) (*http.Client, error) {
+	token, err := loadUserToken(ctx, photosTokenCollection, userID)
+	if err != nil {
+		return nil, err
+	}
+	return photosOAuth(ctx).Client(withTracedHTTPClient(ctx), token), nil
+}
+
+// photosOAuth 回傳只要求 Google Photos 權限的 OAuth 設定，用戶端沿用這個機器人的 Google OAuth 應用程式
+func photosOAuth(ctx context.Context) *oauth2.Config {
+	cfg := *googleOAuth(ctx)
+	cfg.Scopes = photosScopes
+	return &cfg
+}
+
+// doPhotosRequest 以 JSON 送出請求並將回應解碼到 out，body 為 nil 時不送出內容
+func doPhotosRequest(ctx context.Context, client *http.Client, method, endpoint string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, photosAPIURL+endpoint, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("photos API %s returned %s: %s", req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// 處理 /media_destination 指令：指定照片與影片要上傳到哪裡，其他檔案仍上傳到主要的目的地
+func handleMediaDestination(ctx context.Context, message *tgbotapi.Message) {
+	userID := message.From.ID
+	names := mediaDestinationNames()
+	if len(names) == 0 {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "此機器人沒有啟用 Google Photos 等只存放照片與影片的目的地。")
+		return
+	}
+	if configFor(ctx).ForceDestination != "" {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "此機器人的管理者已將所有上傳固定到同一個目的地，無法另外指定照片與影片的目的地。")
+		return
+	}
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的設定時發生錯誤，請稍後再試。")
+		return
+	}
+
+	arg := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+	switch arg {
+	case "":
+		current := "與其他檔案相同（" + userDestination(ctx, settings).DisplayName() + "）"
+		if d, ok := destinations[settings.MediaDestination].(mediaDestination); ok {
+			current = d.DisplayName()
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf(
+			"照片與影片目前上傳到：%s\n\n切換方式：/media_destination <%s|off>", current, strings.Join(names, "|")))
+		return
+	case "off":
+		if err := updateUserSettings(ctx, userID, map[string]interface{}{"media_destination": ""}); err != nil {
+			slog.ErrorContext(ctx, "Failed to save media destination", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "儲存設定時發生錯誤，請稍後再試。")
+			return
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("照片與影片會和其他檔案一樣上傳到 %s。", userDestination(ctx, settings).DisplayName()))
+		return
+	}
+
+	dest, ok := destinations[arg].(mediaDestination)
+	if !ok {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("不支援的目的地「%s」，可用的目的地：%s", arg, strings.Join(names, "、")))
+		return
+	}
+	connected, err := dest.Connected(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to check destination connection", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的授權時發生錯誤，請稍後再試。")
+		return
+	}
+	if !connected {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("您尚未連結 %s，請先使用 %s 指令。", dest.DisplayName(), connectCommand(dest)))
+		return
+	}
+	if err := updateUserSettings(ctx, userID, map[string]interface{}{"media_destination": dest.Name()}); err != nil {
+		slog.ErrorContext(ctx, "Failed to save media destination", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "儲存設定時發生錯誤，請稍後再試。")
+		return
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("之後的照片與影片會上傳到 %s，其他檔案仍上傳到 %s。", dest.DisplayName(), userDestination(ctx, settings).DisplayName()))
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+)
+
+// --- 其他聊天平台 ---
+
+// Platform 是 Telegram 以外的聊天平台；轉接器負責接收訊息與回覆，上傳、授權與使用者設定沿用同一套核心
+// Telegram 的群組封存、/settings 等功能仍只在 Telegram 提供
+type Platform interface {
+	// Name 是平台的識別名稱，例如 "line"，用於對應使用者 ID
+	Name() string
+	// DisplayName 是顯示給使用者的名稱，例如 "LINE"
+	DisplayName() string
+	// Reply 回覆使用者傳來的訊息；上傳可能需要較久，轉接器需要在平台的回覆期限過後改用主動推送
+	Reply(ctx context.Context, msg *PlatformMessage, text string) error
+}
+
+// PlatformMessage 是從其他平台收到的一則訊息
+type PlatformMessage struct {
+	UserID         int64  // 核心使用的使用者 ID，由 platformUserID 對應而來
+	PlatformUserID string // 平台上的使用者 ID
+	SenderName     string // 傳送者名稱，用於檔名範本的 {sender}
+	Private        bool   // 是否為與機器人的私訊
+	ChatID         string // 訊息所在的聊天室或頻道，意義由轉接器決定
+	ChatName       string // 聊天室名稱，用於檔名範本的 {chat}，未設定時為平台名稱
+	MessageID      string
+	Text           string
+	File           *PlatformFile // 不是檔案時為 nil
+	Date           time.Time
+	ReplyToken     string // 平台回覆訊息時需要的識別碼，意義由轉接器決定
+}
+
+// PlatformFile 是訊息中的檔案，內容在上傳時才下載
+type PlatformFile struct {
+	Name     string // 含副檔名的檔名
+	Type     string // 內容類型，與 Telegram 的 photo、video、voice、document 對應
+	MimeType string
+	Size     int64 // 未知時為 0
+	Open     func(ctx context.Context) (io.ReadCloser, error)
+}
+
+// platformUserID 將其他平台的使用者 ID 對應到核心使用的 int64 ID
+// 結果一定是負數，不會與 Telegram 的使用者 ID 重疊；同一個平台使用者每次都得到相同的 ID
+func platformUserID(platform, id string) int64 {
+	sum := sha256.Sum256([]byte(platform + ":" + id))
+	return -int64(binary.BigEndian.Uint64(sum[:8])>>2) - 1
+}
+
+// handlePlatformMessage 處理其他平台收到的訊息：檔案直接上傳到使用者的目的地，文字支援連結目的地的指令
+func handlePlatformMessage(ctx context.Context, p Platform, msg *PlatformMessage) {
+	ctx = withLogAttrs(ctx, slog.String("platform", p.Name()), slog.Int64("user_id", msg.UserID))
+	if !isUserAllowed(ctx, msg.UserID) {
+		slog.InfoContext(ctx, "Rejected platform message from user not allowed")
+		// 頻道中其他人的訊息很多，只在私訊中回覆
+		if msg.Private {
+			platformReply(ctx, p, msg, fmt.Sprintf("抱歉，此機器人目前僅開放給特定使用者使用。（使用者 ID：%d）", msg.UserID))
+		}
+		return
+	}
+	if msg.File != nil {
+		uploadPlatformFile(ctx, p, msg)
+		return
+	}
+	command, _, _ := strings.Cut(strings.TrimSpace(msg.Text), " ")
+	command = strings.ToLower(command)
+	if name, ok := strings.CutPrefix(command, "/connect_"); ok {
+		// 授權連結會綁定到傳送指令的使用者，不能公開在頻道中
+		if !msg.Private {
+			platformReply(ctx, p, msg, fmt.Sprintf("請私訊我 %s 來連結，授權連結不應該公開在頻道中。", command))
+			return
+		}
+		connectPlatformUser(ctx, p, msg, name)
+		return
+	}
+	if msg.Private {
+		platformReply(ctx, p, msg, platformHelp(p))
+	}
+}
+
+// platformHelp 列出其他平台可以使用的功能
+func platformHelp(p Platform) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "傳送圖片、影片、語音或檔案給我，就會上傳到您的雲端儲存空間。\n\n請先連結儲存空間：\n")
+	for _, name := range primaryDestinationNames() {
+		dest := destinations[name]
+		if _, ok := dest.(interactiveDestination); ok {
+			continue
+		}
+		fmt.Fprintf(&sb, "%s：%s\n", connectCommand(dest), dest.DisplayName())
+	}
+	fmt.Fprintf(&sb, "\n檔名範本、目的地切換等設定目前只能在 Telegram 中使用。")
+	return sb.String()
+}
+
+// connectPlatformUser 回覆連結目的地的授權連結；需要在對話中輸入資訊的目的地只支援 Telegram
+func connectPlatformUser(ctx context.Context, p Platform, msg *PlatformMessage, name string) {
+	dest, ok := destinations[name]
+	if !ok {
+		platformReply(ctx, p, msg, "此機器人尚未啟用這個目的地。")
+		return
+	}
+	if _, ok := dest.(interactiveDestination); ok {
+		platformReply(ctx, p, msg, fmt.Sprintf("%s 目前只能在 Telegram 中連結。", dest.DisplayName()))
+		return
+	}
+	authURL, err := dest.Connect(ctx, msg.UserID)
+	if errors.Is(err, errConnectNotRequired) {
+		platformReply(ctx, p, msg, fmt.Sprintf("%s 由管理者設定，不需要另外連結。", dest.DisplayName()))
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create authorization link", "destination", dest.Name(), "error", err)
+		platformReply(ctx, p, msg, "產生授權連結時發生錯誤，請稍後再試。")
+		return
+	}
+	platformReply(ctx, p, msg, fmt.Sprintf("請點擊以下連結授權本 Bot 存取您的 %s：\n\n%s", dest.DisplayName(), authURL))
+}
+
+// uploadPlatformFile 確認使用者已連結目的地後，在背景下載檔案並上傳
+func uploadPlatformFile(ctx context.Context, p Platform, msg *PlatformMessage) {
+	settings, err := loadUserSettings(ctx, msg.UserID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		platformReply(ctx, p, msg, "讀取您的設定時發生錯誤，請稍後再試。")
+		return
+	}
+	dest := uploadDestination(ctx, settings, msg.File.MimeType)
+	connected, err := dest.Connected(ctx, msg.UserID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to retrieve token", "error", err)
+		platformReply(ctx, p, msg, "讀取您的授權時發生錯誤，請稍後再試。")
+		return
+	}
+	if !connected {
+		platformReply(ctx, p, msg, fmt.Sprintf("您的 %s 帳號尚未連結，請傳送 %s 來連結。", dest.DisplayName(), connectCommand(dest)))
+		return
+	}
+	if msg.File.Size > configFor(ctx).maxFileSize() {
+		platformReply(ctx, p, msg, fileTooLargeMessage(ctx, msg.File.Size))
+		return
+	}
+	if err := reserveUpload(ctx, msg.UserID, msg.File.Size); err != nil {
+		var limitErr *rateLimitError
+		if errors.As(err, &limitErr) {
+			platformReply(ctx, p, msg, rateLimitMessage(ctx, limitErr))
+			return
+		}
+		slog.ErrorContext(ctx, "Failed to check rate limit", "error", err)
+		platformReply(ctx, p, msg, "檢查上傳額度時發生錯誤，請稍後再試。")
+		return
+	}
+
+	// 平台的 webhook 需要盡快回應，下載與上傳在背景進行
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				reportPanic(ctx, r)
+			}
+		}()
+		savePlatformFile(ctx, p, msg, settings, dest)
+	}()
+}
+
+func savePlatformFile(ctx context.Context, p Platform, msg *PlatformMessage, settings *UserSettings, dest Destination) {
+	file := msg.File
+	ext := strings.TrimPrefix(path.Ext(file.Name), ".")
+	meta := &UploadMeta{
+		Name:      strings.TrimSuffix(file.Name, path.Ext(file.Name)),
+		Ext:       ext,
+		Type:      file.Type,
+		Sender:    msg.SenderName,
+		Chat:      cmp.Or(msg.ChatName, p.DisplayName()),
+		Date:      msg.Date,
+		CreatedAt: time.Now(),
+	}
+	content, err := file.Open(ctx)
+	if err != nil {
+		reportError(ctx, "Failed to download platform file", err)
+		platformReply(ctx, p, msg, fmt.Sprintf("從 %s 下載檔案時發生錯誤，請稍後重新傳送。", p.DisplayName()))
+		return
+	}
+	defer content.Close()
+
+	body := newLimitedReader(content, configFor(ctx).maxFileSize())
+	name := renderFileName(settings.FilenameTemplate, meta)
+	result, err := uploadTo(ctx, dest, msg.UserID, &UploadFile{
+		Name:     name,
+		Folders:  renderFolderPath(settings.FolderTemplate, meta),
+		Body:     body,
+		MimeType: file.MimeType,
+	})
+	event := &UploadEvent{UserID: msg.UserID, Chat: meta.Chat, Destination: dest.Name(), FileName: name, MimeType: file.MimeType, Size: body.BytesRead()}
+	if body.Exceeded() {
+		err = errFileTooLarge
+	}
+	if err != nil {
+		event.Event, event.Error = eventUploadFailed, err.Error()
+		emitUploadEvent(ctx, settings, event)
+		if errors.Is(err, errFileTooLarge) {
+			platformReply(ctx, p, msg, fileTooLargeMessage(ctx, 0))
+			return
+		}
+		reportError(ctx, "Failed to upload platform file", err, "destination", dest.Name())
+		platformReply(ctx, p, msg, uploadFailedMessage(ctx, dest, msg.UserID, err, fmt.Sprintf("上傳到您的 %s 失敗。", dest.DisplayName())))
+		return
+	}
+	recordLinkUpload(ctx, settings, dest, result, event)
+	slog.InfoContext(ctx, "Successfully uploaded platform file", "file_name", result.Name, "size", event.Size)
+	platformReply(ctx, p, msg, dryRunReply(ctx, fmt.Sprintf("檔案 '%s'（%s）已成功上傳到您的 %s！", result.Name, formatSize(event.Size), dest.DisplayName())))
+}
+
+// platformReply 回覆訊息，失敗時只記錄日誌
+func platformReply(ctx context.Context, p Platform, msg *PlatformMessage, text string) {
+	if err := p.Reply(ctx, msg, text); err != nil {
+		slog.WarnContext(ctx, "Failed to reply on platform", "error", err)
+	}
+}
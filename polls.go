@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 投票結果封存 ---
+
+// Firestore 集合名稱
+const pollCollection = "polls"
+
+// 投票結束前最多記住多久，超過後由 TTL 清除
+const pollTTL = 90 * 24 * time.Hour
+
+// TrackedPoll 記錄群組中的投票來自哪個聊天室；投票結束的更新只有投票本身，沒有聊天室
+type TrackedPoll struct {
+	ChatID    int64     `firestore:"chat_id"`
+	ChatTitle string    `firestore:"chat_title"`
+	MessageID int       `firestore:"message_id"`
+	Question  string    `firestore:"question"`
+	CreatedAt time.Time `firestore:"created_at"`
+	ExpireAt  time.Time `firestore:"expire_at"`
+}
+
+// 處理 /archive_polls 指令：群組管理員在封存模式中開關投票結果的封存
+func handleArchivePolls(ctx context.Context, message *tgbotapi.Message) {
+	if message.Chat.IsPrivate() || message.Chat.IsChannel() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請在群組中使用 /archive_polls。")
+		return
+	}
+	if !requireChatAdmin(ctx, message, message.Chat.ID, "/archive_polls") {
+		return
+	}
+	settings, err := loadArchiveSettings(ctx, message.Chat)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load chat settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取聊天室設定時發生錯誤，請稍後再試。")
+		return
+	}
+	if settings == nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "投票結果會存到封存模式的資料夾，請先使用 /enable_archive 開啟封存模式。")
+		return
+	}
+
+	var on bool
+	switch strings.ToLower(strings.TrimSpace(message.CommandArguments())) {
+	case "on":
+		on = true
+	case "off":
+	default:
+		state := "關閉"
+		if settings.ArchivePolls {
+			state = "開啟"
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("投票結果封存目前為%s。\n使用 /archive_polls on 開啟、/archive_polls off 關閉。", state))
+		return
+	}
+	err = collection(ctx, chatSettingsCollection).Doc(fmt.Sprintf("%d", message.Chat.ID)).Merge(ctx, map[string]interface{}{
+		"archive_polls": on,
+		"updated_at":    time.Now(),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to save chat settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "儲存設定時發生錯誤，請稍後再試。")
+		return
+	}
+	if !on {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "已關閉投票結果封存。")
+		return
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, "已開啟投票結果封存，之後在群組中建立的投票被手動結束時，題目、選項與票數會存成 CSV 檔放到封存資料夾。")
+}
+
+// handlePollMessage 記住封存群組中新建立的投票，之後投票結束時才知道要存到哪裡
+// 不是投票或聊天室沒有開啟投票封存時回傳 false，照原本的方式回覆
+func handlePollMessage(ctx context.Context, message *tgbotapi.Message) bool {
+	if message.Poll == nil {
+		return false
+	}
+	settings, err := loadArchiveSettings(ctx, message.Chat)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load chat settings", "error", err)
+		return false
+	}
+	if settings == nil || !settings.ArchivePolls {
+		return false
+	}
+	now := time.Now()
+	err = collection(ctx, pollCollection).Doc(message.Poll.ID).Set(ctx, &TrackedPoll{
+		ChatID:    message.Chat.ID,
+		ChatTitle: chatDisplayName(message.Chat),
+		MessageID: message.MessageID,
+		Question:  message.Poll.Question,
+		CreatedAt: now,
+		ExpireAt:  now.Add(pollTTL),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to track poll", "poll_id", message.Poll.ID, "error", err)
+		return true
+	}
+	slog.InfoContext(ctx, "Tracking poll", "poll_id", message.Poll.ID)
+	return true
+}
+
+// handlePollUpdate 在記住的投票結束時，將結果存成 CSV 上傳到封存擁有者的儲存空間
+// Telegram 只會送出機器人自己傳送的投票，以及被手動結束的投票的更新
+func handlePollUpdate(ctx context.Context, poll *tgbotapi.Poll) {
+	if !poll.IsClosed {
+		return
+	}
+	ctx = withLogAttrs(ctx, slog.String("poll_id", poll.ID))
+	ref := collection(ctx, pollCollection).Doc(poll.ID)
+	doc, err := ref.Get(ctx)
+	if errors.Is(err, errDocNotFound) {
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load tracked poll", "error", err)
+		return
+	}
+	var tracked TrackedPoll
+	if err := doc.DataTo(&tracked); err != nil {
+		slog.ErrorContext(ctx, "Failed to decode tracked poll", "error", err)
+		return
+	}
+	// 先刪除紀錄，同一個投票的結束更新重送時不會重複上傳
+	if err := ref.Delete(ctx); err != nil {
+		slog.ErrorContext(ctx, "Failed to delete tracked poll", "error", err)
+		return
+	}
+
+	settings, err := loadChatSettings(ctx, tracked.ChatID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load chat settings", "error", err)
+		return
+	}
+	if settings == nil || !settings.ArchiveEnabled || !settings.ArchivePolls {
+		return
+	}
+	ownerID := settings.ArchiveOwnerID
+	owner, err := loadUserSettings(ctx, ownerID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		return
+	}
+	dest := userDestination(ctx, owner)
+
+	now := time.Now()
+	meta := &UploadMeta{
+		Name:      "poll_" + sanitizeGeneratedName(poll.Question),
+		Ext:       "csv",
+		Type:      "poll",
+		Chat:      tracked.ChatTitle,
+		Caption:   poll.Question,
+		Date:      tracked.CreatedAt,
+		CreatedAt: now,
+	}
+	content := pollCSV(poll)
+	meta.Size = int64(len(content))
+	result, err := uploadTo(ctx, dest, ownerID, &UploadFile{
+		Name:     fmt.Sprintf("%s_%s.csv", meta.Name, now.Format("20060102_150405")),
+		Folders:  renderFolderPath(settings.ArchiveFolder, meta),
+		Body:     bytes.NewReader(content),
+		MimeType: "text/csv",
+		Caption:  poll.Question,
+		Source:   &TelegramSource{ChatID: tracked.ChatID, MessageID: tracked.MessageID},
+	})
+	if err != nil {
+		reportError(ctx, "Failed to upload poll results", err, "destination", dest.Name())
+		// 與頻道封存相同，失敗時以私訊通知封存擁有者而不在群組中發言
+		replyToUser(ctx, ownerID, 0, uploadFailedMessage(ctx, dest, ownerID, err, fmt.Sprintf("「%s」的投票結果「%s」上傳到您的 %s 失敗。", tracked.ChatTitle, poll.Question, dest.DisplayName())))
+		return
+	}
+	if !dryRun(ctx) {
+		addQuotaUsage(ctx, dest, ownerID, meta.Size)
+		if err := recordUploadStats(ctx, meta.Size); err != nil {
+			slog.ErrorContext(ctx, "Failed to record upload stats", "error", err)
+		}
+	}
+	slog.InfoContext(ctx, "Archived poll results", "file_name", result.Name, "voters", poll.TotalVoterCount)
+}
+
+// pollCSV 產生投票結果的 CSV：每個選項一列，最後一列是投票人數
+func pollCSV(poll *tgbotapi.Poll) []byte {
+	var buf bytes.Buffer
+	// 加上 BOM，讓 Excel 以 UTF-8 開啟中文
+	buf.WriteString("\ufeff")
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"question", "option", "votes", "percent", "correct"})
+	for i, o := range poll.Options {
+		percent := "0"
+		if poll.TotalVoterCount > 0 {
+			percent = strconv.FormatFloat(float64(o.VoterCount)*100/float64(poll.TotalVoterCount), 'f', 1, 64)
+		}
+		correct := ""
+		if poll.Type == "quiz" && i == poll.CorrectOptionID {
+			correct = "yes"
+		}
+		w.Write([]string{poll.Question, o.Text, strconv.Itoa(o.VoterCount), percent, correct})
+	}
+	w.Write([]string{poll.Question, "(total voters)", strconv.Itoa(poll.TotalVoterCount), "", ""})
+	w.Flush()
+	return buf.Bytes()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// --- 上傳後處理 ---
+
+// uploadedFile 是上傳完成後交給處理步驟的資訊，Content 是串流上傳時保留的檔案內容
+type uploadedFile struct {
+	UserID       int64
+	Dest         Destination
+	Settings     *UserSettings
+	File         *telegramFile
+	Upload       *UploadFile
+	Result       *UploadResult
+	Content      []byte
+	NotifyChatID int64
+	ReplyTo      int
+	Silent       bool // 聊天室開啟靜默模式時，處理結果不回覆到聊天室
+}
+
+// postProcessor 是上傳後的處理步驟；wants 在下載前判斷是否需要保留檔案內容，process 在上傳成功後執行
+type postProcessor struct {
+	name    string
+	wants   func(settings *UserSettings, file *telegramFile) bool
+	process func(ctx context.Context, u *uploadedFile) error
+}
+
+// 依註冊順序執行的處理步驟，只有在對應的服務啟用時才會註冊
+var postProcessors []*postProcessor
+
+func registerPostProcessor(p *postProcessor) {
+	postProcessors = append(postProcessors, p)
+}
+
+// wantsContent 回傳是否有任何處理步驟需要這個檔案的內容
+func wantsContent(settings *UserSettings, file *telegramFile) bool {
+	for _, p := range postProcessors {
+		if p.wants(settings, file) {
+			return true
+		}
+	}
+	return false
+}
+
+// runPostProcessors 在背景依序執行需要的處理步驟，單一步驟失敗不影響其他步驟
+func runPostProcessors(ctx context.Context, u *uploadedFile) {
+	var steps []*postProcessor
+	for _, p := range postProcessors {
+		if p.wants(u.Settings, u.File) {
+			steps = append(steps, p)
+		}
+	}
+	if len(steps) == 0 {
+		return
+	}
+
+	// 辨識與轉錄可能需要數秒，不阻塞 webhook 的回應
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				reportPanic(ctx, r)
+			}
+		}()
+		for _, p := range steps {
+			if err := p.process(ctx, u); err != nil {
+				reportError(ctx, "Post-processing failed", err, "step", p.name)
+			}
+		}
+	}()
+}
+
+// saveSidecarText 將文字存成與檔案同一個資料夾的 <檔名>.txt
+func saveSidecarText(ctx context.Context, u *uploadedFile, text string) (*UploadResult, error) {
+	return u.Dest.Upload(ctx, u.UserID, &UploadFile{
+		Name:     u.Result.Name + ".txt",
+		Folders:  u.Upload.Folders,
+		Body:     strings.NewReader(text),
+		MimeType: "text/plain",
+	})
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 個人資料 ---
+
+const googleRevokeURL = "https://oauth2.googleapis.com/revoke"
+
+// 以使用者 ID 作為文件 ID 的個人資料集合；權杖另外由 disconnectUser 處理
+var userDocCollections = []string{settingsCollection, conversationCollection, zipSessionCollection, importSessionCollection, migrationCollection, rateLimitCollection}
+
+// userField 是以欄位記錄使用者 ID 的集合
+type userField struct {
+	name  string
+	field string
+}
+
+// 以欄位記錄使用者的集合，依欄位查詢後刪除
+var userFieldCollections = []userField{
+	{uploadCollection, "user_id"},
+	{pendingUploadCollection, "user_id"},
+	{stateCollection, "user_id"},
+	{bindingCollection, "owner_id"},
+	{usageCollection, "user_id"},
+	{uploadJobCollection, "user_id"},
+	{emailAddressCollection, "user_id"},
+	{fileRequestCollection, "user_id"},
+}
+
+// 匯出時以 [redacted] 取代的欄位：權杖、密碼與簽章密鑰不應該出現在聊天紀錄中
+var redactedExportFields = map[string]bool{
+	"refresh_token":      true,
+	"access_token":       true,
+	"encrypted_password": true,
+	"webhook_secret":     true,
+	"code_verifier":      true,
+}
+
+// 處理 /forget_me 指令：先以按鈕確認，避免誤觸後無法復原
+func handleForgetMe(ctx context.Context, message *tgbotapi.Message) {
+	if !message.Chat.IsPrivate() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請在與機器人的私訊中使用 /forget_me。")
+		return
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, "這會撤銷本 Bot 對您 Google 帳號的授權，並刪除機器人儲存的所有資料：權杖、設定、上傳紀錄與進行中的操作。已經上傳到雲端的檔案不會被刪除。\n\n確定要刪除嗎？此操作無法復原。")
+	msg.ReplyToMessageID = message.MessageID
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("刪除我的資料", encodeCallbackData("forget", "confirm")),
+		tgbotapi.NewInlineKeyboardButtonData("取消", encodeCallbackData("forget", "cancel")),
+	))
+	if _, err := sendMessage(ctx, msg); err != nil {
+		slog.ErrorContext(ctx, "Failed to send message", "error", err)
+	}
+}
+
+// handleForgetMeCallback 處理 /forget_me 的確認按鈕
+func handleForgetMeCallback(ctx context.Context, query *tgbotapi.CallbackQuery, args []string) {
+	if len(args) != 1 || query.Message == nil {
+		answerCallback(ctx, query, "")
+		return
+	}
+	text := "已取消，您的資料沒有被刪除。"
+	if args[0] == "confirm" {
+		text = "已刪除您的所有資料並撤銷授權。若之後想再使用，請重新輸入 /connect_drive。"
+		if err := deleteUserData(ctx, query.From.ID); err != nil {
+			reportError(ctx, "Failed to delete user data", err)
+			text = "刪除資料時發生錯誤，部分資料可能尚未刪除，請稍後再試一次。"
+		} else {
+			slog.InfoContext(ctx, "User data deleted")
+		}
+	}
+	answerCallback(ctx, query, "")
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, text)
+	if _, err := sendChattable(ctx, query.Message.Chat.ID, edit); err != nil {
+		slog.WarnContext(ctx, "Failed to update forget_me message", "error", err)
+	}
+}
+
+// deleteUserData 撤銷使用者的 Google 授權，並刪除權杖、設定、上傳紀錄與進行中的狀態
+// 已上傳到雲端的檔案屬於使用者，不會被刪除；使用者擁有的群組封存模式會被關閉
+func deleteUserData(ctx context.Context, userID int64) error {
+	// 撤銷需要 Refresh Token，必須在刪除權杖之前進行；撤銷失敗不影響刪除
+	revokeGoogleGrants(ctx, userID)
+
+	errs := []error{disconnectUser(ctx, userID)}
+	docID := fmt.Sprintf("%d", userID)
+	for _, name := range userDocCollections {
+		if err := collection(ctx, name).Doc(docID).Delete(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete %s: %v", name, err))
+		}
+	}
+	for _, c := range userFieldCollections {
+		if err := deleteWhere(ctx, c.name, c.field, userID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if redisClient != nil {
+		errs = append(errs, redisDeleteUser(ctx, userID))
+	}
+
+	docs, err := collection(ctx, chatSettingsCollection).Where("archive_owner_id", "==", userID).GetAll(ctx)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to list archive chats: %v", err))
+	}
+	for _, doc := range docs {
+		if err := doc.Ref.Update(ctx, []Update{
+			{Path: "archive_enabled", Value: false},
+			{Path: "archive_owner_id", Value: deleteField},
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to disable archive: %v", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deleteWhere 刪除集合中欄位等於 userID 的所有文件
+func deleteWhere(ctx context.Context, name, field string, userID int64) error {
+	docs, err := collection(ctx, name).Where(field, "==", userID).GetAll(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list %s: %v", name, err)
+	}
+	var errs []error
+	for _, doc := range docs {
+		if err := doc.Ref.Delete(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete %s/%s: %v", name, doc.Ref.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// revokeGoogleGrants 撤銷使用者所有 Google 帳號的授權，讓權杖在 Google 端也失效
+func revokeGoogleGrants(ctx context.Context, userID int64) {
+	var tokens []*UserToken
+	if active, err := loadTokenDoc(ctx, tokenCollection, fmt.Sprintf("%d", userID)); err == nil {
+		tokens = append(tokens, active)
+	}
+	docs, err := collection(ctx, googleAccountCollection).Where("user_id", "==", userID).GetAll(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to list google accounts for revocation", "error", err)
+	}
+	for _, doc := range docs {
+		var account UserToken
+		if err := doc.DataTo(&account); err == nil {
+			tokens = append(tokens, &account)
+		}
+	}
+
+	revoked := map[string]bool{}
+	for _, t := range tokens {
+		if t.RefreshToken == "" || revoked[t.RefreshToken] {
+			continue
+		}
+		revoked[t.RefreshToken] = true
+		if err := revokeGoogleToken(ctx, t.RefreshToken); err != nil {
+			slog.WarnContext(ctx, "Failed to revoke google token", "error", err)
+		}
+	}
+}
+
+func revokeGoogleToken(ctx context.Context, token string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, googleRevokeURL, strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := instrumentedClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	// 權杖已經失效時 Google 回傳 400 invalid_token，結果相同
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusBadRequest {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// 處理 /export_my_data 指令：將機器人儲存的個人資料匯出成 JSON 檔案傳給使用者
+func handleExportMyData(ctx context.Context, message *tgbotapi.Message) {
+	if !message.Chat.IsPrivate() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請在與機器人的私訊中使用 /export_my_data。")
+		return
+	}
+	data, err := exportUserData(ctx, message.From.ID)
+	if err != nil {
+		reportError(ctx, "Failed to export user data", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "匯出資料時發生錯誤，請稍後再試。")
+		return
+	}
+	doc := tgbotapi.NewDocument(message.Chat.ID, tgbotapi.FileBytes{
+		Name:  fmt.Sprintf("tg-helper-%d-%s.json", message.From.ID, time.Now().Format("20060102")),
+		Bytes: data,
+	})
+	doc.ReplyToMessageID = message.MessageID
+	doc.Caption = "這是機器人儲存的所有個人資料。權杖與密鑰已經遮蔽；輸入 /forget_me 可以刪除這些資料。"
+	if _, err := sendChattable(ctx, message.Chat.ID, doc); err != nil {
+		slog.ErrorContext(ctx, "Failed to send data export", "error", err)
+	}
+}
+
+// exportUserData 以 JSON 匯出使用者在各集合中的文件，依集合名稱分組，敏感欄位會被遮蔽
+func exportUserData(ctx context.Context, userID int64) ([]byte, error) {
+	export := map[string]interface{}{
+		"user_id":     userID,
+		"exported_at": time.Now().UTC(),
+	}
+	add := func(name string, data map[string]interface{}) {
+		list, _ := export[name].([]map[string]interface{})
+		export[name] = append(list, redactExport(data))
+	}
+
+	docID := fmt.Sprintf("%d", userID)
+	for _, name := range append(append([]string{}, userCredentialCollections...), userDocCollections...) {
+		doc, err := collection(ctx, name).Doc(docID).Get(ctx)
+		if errors.Is(err, errDocNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		add(name, doc.Data())
+	}
+	fields := append([]userField{
+		{googleAccountCollection, "user_id"},
+		{chatSettingsCollection, "archive_owner_id"},
+	}, userFieldCollections...)
+	for _, c := range fields {
+		docs, err := collection(ctx, c.name).Where(c.field, "==", userID).GetAll(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %v", c.name, err)
+		}
+		for _, doc := range docs {
+			add(c.name, doc.Data())
+		}
+	}
+	return json.MarshalIndent(export, "", "  ")
+}
+
+func redactExport(data map[string]interface{}) map[string]interface{} {
+	for k, v := range data {
+		if s, ok := v.(string); ok && redactedExportFields[k] && s != "" {
+			data[k] = "[redacted]"
+		}
+	}
+	return data
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 轉傳來源 ---
+
+// ForwardOrigin 記錄轉傳訊息的原始來源，上傳時會寫入檔案的描述與屬性，讓封存的檔案保留出處
+type ForwardOrigin struct {
+	Sender    string    // 原始傳送者名稱，對方隱藏帳號時為 Telegram 提供的顯示名稱
+	SenderID  int64     // 原始傳送者 ID，未知時為 0
+	Chat      string    // 來源頻道或群組名稱
+	ChatID    int64     // 來源頻道或群組 ID，未知時為 0
+	Username  string    // 公開頻道的使用者名稱（不含 @），用來產生原始貼文的連結
+	MessageID int       // 來源頻道中的訊息 ID，未知時為 0
+	Date      time.Time // 原始訊息的時間
+}
+
+// forwardOrigin 從訊息的 Forward* 欄位取出來源，不是轉傳的訊息回傳 nil
+func forwardOrigin(message *tgbotapi.Message) *ForwardOrigin {
+	if message.ForwardDate == 0 {
+		return nil
+	}
+	origin := &ForwardOrigin{
+		Sender:    message.ForwardSenderName,
+		MessageID: message.ForwardFromMessageID,
+		Date:      time.Unix(int64(message.ForwardDate), 0),
+	}
+	if message.ForwardFrom != nil {
+		origin.Sender = userDisplayName(message.ForwardFrom)
+		origin.SenderID = message.ForwardFrom.ID
+	}
+	if message.ForwardFromChat != nil {
+		origin.Chat = chatDisplayName(message.ForwardFromChat)
+		origin.ChatID = message.ForwardFromChat.ID
+		origin.Username = message.ForwardFromChat.UserName
+		// 頻道貼文的作者署名
+		if origin.Sender == "" {
+			origin.Sender = message.ForwardSignature
+		}
+	}
+	return origin
+}
+
+// Description 產生顯示在檔案描述中的來源說明
+func (o *ForwardOrigin) Description() string {
+	var from []string
+	if o.Chat != "" {
+		from = append(from, o.Chat)
+	}
+	if o.Sender != "" {
+		from = append(from, o.Sender)
+	}
+	if len(from) == 0 {
+		from = append(from, "未知來源")
+	}
+	desc := fmt.Sprintf("轉傳自 %s，原始訊息時間 %s", strings.Join(from, " / "), o.Date.Format("2006-01-02 15:04:05"))
+	if link := o.Link(); link != "" {
+		desc += "\n" + link
+	}
+	return desc
+}
+
+// Link 回傳公開頻道原始貼文的網址，無法得知時回傳空字串
+func (o *ForwardOrigin) Link() string {
+	if o.MessageID == 0 || o.Username == "" {
+		return ""
+	}
+	return fmt.Sprintf("https://t.me/%s/%d", o.Username, o.MessageID)
+}
+
+// Properties 產生機器可讀的來源屬性，未知的欄位會省略
+func (o *ForwardOrigin) Properties() map[string]string {
+	props := map[string]string{
+		"forward_date": o.Date.UTC().Format(time.RFC3339),
+	}
+	if o.Sender != "" {
+		props["forward_from"] = o.Sender
+	}
+	if o.SenderID != 0 {
+		props["forward_from_id"] = fmt.Sprintf("%d", o.SenderID)
+	}
+	if o.Chat != "" {
+		props["forward_from_chat"] = o.Chat
+	}
+	if o.ChatID != 0 {
+		props["forward_from_chat_id"] = fmt.Sprintf("%d", o.ChatID)
+	}
+	if o.MessageID != 0 {
+		props["forward_message_id"] = fmt.Sprintf("%d", o.MessageID)
+	}
+	return props
+}
+
+// TelegramSource 記錄檔案來自哪一則 Telegram 訊息，上傳到 Google Drive 時寫入 appProperties，
+// 讓之後不必依賴 Firestore 的上傳紀錄也能以 Drive 查詢找回檔案
+type TelegramSource struct {
+	ChatID       int64
+	MessageID    int
+	Sender       string
+	FileUniqueID string
+}
+
+// appProperties 中的 Telegram 來源屬性名稱
+const (
+	propTelegramChatID       = "telegram_chat_id"
+	propTelegramMessageID    = "telegram_message_id"
+	propTelegramSender       = "telegram_sender"
+	propTelegramFileUniqueID = "telegram_file_unique_id"
+)
+
+// Properties 產生機器可讀的 Telegram 來源屬性，未知的欄位會省略
+func (s *TelegramSource) Properties() map[string]string {
+	props := map[string]string{
+		propTelegramChatID:    fmt.Sprintf("%d", s.ChatID),
+		propTelegramMessageID: fmt.Sprintf("%d", s.MessageID),
+	}
+	if s.Sender != "" {
+		props[propTelegramSender] = s.Sender
+	}
+	if s.FileUniqueID != "" {
+		props[propTelegramFileUniqueID] = s.FileUniqueID
+	}
+	return props
+}
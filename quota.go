@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// --- 儲存空間配額 ---
+
+// quotaDestination 是可以查詢剩餘空間的目的地，上傳前會先檢查空間是否足夠
+type quotaDestination interface {
+	Destination
+	Quota(ctx context.Context, userID int64) (*storageQuota, error)
+}
+
+// storageQuota 是使用者儲存空間的用量，Limit 為 0 代表沒有上限
+type storageQuota struct {
+	Used  int64
+	Limit int64
+}
+
+const (
+	// 查詢結果的快取時間，避免每次上傳都多呼叫一次 API
+	quotaCacheTTL = 5 * time.Minute
+	// 用量超過這個比例時，上傳成功的訊息會附上空間即將用完的提醒
+	quotaWarnRatio = 0.95
+)
+
+type quotaCacheEntry struct {
+	quota    storageQuota
+	expireAt time.Time
+}
+
+var (
+	quotaCacheMu sync.Mutex
+	// 以 "<機器人 ID>/<目的地>/<使用者 ID>/<帳號>" 索引
+	quotaCache = map[string]*quotaCacheEntry{}
+)
+
+func quotaCacheKey(ctx context.Context, dest Destination, userID int64) string {
+	return fmt.Sprintf("%s/%s/%d/%s", currentTenant(ctx).ID, dest.Name(), userID, googleAccountFromContext(ctx))
+}
+
+// loadQuota 回傳目的地的用量，優先使用快取；目的地不支援查詢時回傳 nil
+func loadQuota(ctx context.Context, dest Destination, userID int64) (*storageQuota, error) {
+	qd, ok := dest.(quotaDestination)
+	if !ok {
+		return nil, nil
+	}
+	key := quotaCacheKey(ctx, dest, userID)
+	quotaCacheMu.Lock()
+	entry, ok := quotaCache[key]
+	quotaCacheMu.Unlock()
+	if ok && time.Now().Before(entry.expireAt) {
+		q := entry.quota
+		return &q, nil
+	}
+
+	q, err := qd.Quota(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	quotaCacheMu.Lock()
+	defer quotaCacheMu.Unlock()
+	for k, e := range quotaCache {
+		if time.Now().After(e.expireAt) {
+			delete(quotaCache, k)
+		}
+	}
+	quotaCache[key] = &quotaCacheEntry{quota: *q, expireAt: time.Now().Add(quotaCacheTTL)}
+	return q, nil
+}
+
+// addQuotaUsage 在上傳成功後更新快取中的用量，讓連續上傳時的檢查不會落後太多
+func addQuotaUsage(ctx context.Context, dest Destination, userID int64, size int64) {
+	quotaCacheMu.Lock()
+	defer quotaCacheMu.Unlock()
+	if entry, ok := quotaCache[quotaCacheKey(ctx, dest, userID)]; ok {
+		entry.quota.Used += size
+	}
+}
+
+// invalidateQuota 移除快取的用量，下次上傳時會重新查詢
+func invalidateQuota(ctx context.Context, dest Destination, userID int64) {
+	quotaCacheMu.Lock()
+	defer quotaCacheMu.Unlock()
+	delete(quotaCache, quotaCacheKey(ctx, dest, userID))
+}
+
+// Remaining 回傳剩餘空間，沒有上限時回傳 -1
+func (q *storageQuota) Remaining() int64 {
+	if q.Limit <= 0 {
+		return -1
+	}
+	return max(q.Limit-q.Used, 0)
+}
+
+// NearlyFull 回報用量是否已超過提醒的比例
+func (q *storageQuota) NearlyFull() bool {
+	return q.Limit > 0 && float64(q.Used) >= float64(q.Limit)*quotaWarnRatio
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Firestore 集合名稱
+const rateLimitCollection = "rate_limits"
+
+// rateLimitCounter 是儲存在 Firestore 中的使用者上傳計數
+type rateLimitCounter struct {
+	WindowStart time.Time `firestore:"window_start"` // 目前一分鐘時間窗的起點
+	WindowCount int       `firestore:"window_count"` // 時間窗內的上傳次數
+	Day         string    `firestore:"day"`          // 每日計數對應的日期 (UTC)
+	DayBytes    int64     `firestore:"day_bytes"`    // 當日已上傳的位元組數
+}
+
+// rateLimitError 表示使用者超過上傳限制，RetryAt 為可以再次上傳的時間
+type rateLimitError struct {
+	Reason  string
+	RetryAt time.Time
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("rate limited: %s until %s", e.Reason, e.RetryAt.Format(time.RFC3339))
+}
+
+// dailyUploadBytes 是每位使用者每天可上傳的位元組數，0 代表不限制
+func (c RateLimitConfig) dailyUploadBytes() int64 {
+	return c.UploadDailyLimitMB * 1024 * 1024
+}
+
+func (c RateLimitConfig) uploadLimitsEnabled() bool {
+	return c.UploadsPerMinute > 0 || c.UploadDailyLimitMB > 0
+}
+
+// reserveUpload 在下載開始前檢查並佔用一次上傳額度；超過限制時回傳 *rateLimitError
+// declaredSize 為 Telegram 提供的檔案大小，未知時為 0，實際大小會在上傳後以 recordUploadBytes 補上
+func reserveUpload(ctx context.Context, userID int64, declaredSize int64) error {
+	limits := configFor(ctx).RateLimits
+	if !limits.uploadLimitsEnabled() {
+		return nil
+	}
+	if redisClient != nil {
+		return redisReserveUpload(ctx, limits, userID, declaredSize)
+	}
+	ref := collection(ctx, rateLimitCollection).Doc(fmt.Sprintf("%d", userID))
+	return store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		counter, err := readRateLimitCounter(tx, ref)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		if now.Sub(counter.WindowStart) >= time.Minute {
+			counter.WindowStart, counter.WindowCount = now, 0
+		}
+		if today := now.Format("2006-01-02"); counter.Day != today {
+			counter.Day, counter.DayBytes = today, 0
+		}
+
+		if limits.UploadsPerMinute > 0 && counter.WindowCount >= limits.UploadsPerMinute {
+			return &rateLimitError{Reason: "per-minute upload count", RetryAt: counter.WindowStart.Add(time.Minute)}
+		}
+		if daily := limits.dailyUploadBytes(); daily > 0 && counter.DayBytes+declaredSize > daily {
+			return &rateLimitError{Reason: "daily upload bytes", RetryAt: nextUTCMidnight(now)}
+		}
+
+		counter.WindowCount++
+		return tx.Set(ref, counter)
+	})
+}
+
+// recordUploadBytes 將實際上傳的位元組數累加到當日的計數
+func recordUploadBytes(ctx context.Context, userID int64, size int64) error {
+	if configFor(ctx).RateLimits.dailyUploadBytes() == 0 {
+		return nil
+	}
+	if redisClient != nil {
+		return redisRecordUploadBytes(ctx, userID, size)
+	}
+	ref := collection(ctx, rateLimitCollection).Doc(fmt.Sprintf("%d", userID))
+	return store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		counter, err := readRateLimitCounter(tx, ref)
+		if err != nil {
+			return err
+		}
+		if today := time.Now().UTC().Format("2006-01-02"); counter.Day != today {
+			counter.Day, counter.DayBytes = today, 0
+		}
+		counter.DayBytes += size
+		return tx.Set(ref, counter)
+	})
+}
+
+func readRateLimitCounter(tx Tx, ref *DocRef) (*rateLimitCounter, error) {
+	counter := &rateLimitCounter{}
+	doc, err := tx.Get(ref)
+	if err != nil {
+		if errors.Is(err, errDocNotFound) {
+			return counter, nil
+		}
+		return nil, err
+	}
+	if err := doc.DataTo(counter); err != nil {
+		return nil, err
+	}
+	return counter, nil
+}
+
+func nextUTCMidnight(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// rateLimitMessage 產生告知使用者何時可以再試的訊息
+func rateLimitMessage(ctx context.Context, e *rateLimitError) string {
+	limits := configFor(ctx).RateLimits
+	wait := time.Until(e.RetryAt).Round(time.Second)
+	if wait < time.Second {
+		wait = time.Second
+	}
+	if e.Reason == "daily upload bytes" {
+		return fmt.Sprintf("您今天的上傳量已達上限（%s），額度將在 %s 後重置，請屆時再試。", formatSize(limits.dailyUploadBytes()), wait)
+	}
+	return fmt.Sprintf("您上傳得太快了（每分鐘最多 %d 個檔案），請在 %s 後再試。", limits.UploadsPerMinute, wait)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// --- Redis ---
+
+// redisClient 在設定 REDIS_URL 時取代 Firestore 儲存短期、頻繁變動的資料：
+// OAuth state、已處理的 update ID 與上傳限制計數；權杖與設定等長期資料仍存放在 Firestore
+var redisClient *redis.Client
+
+// 所有 key 共用的前綴，讓機器人可以和其他服務共用同一個 Redis
+const redisKeyPrefix = "tg-helper:"
+
+// 每日上傳量的 key 保留到隔天結束，讓跨日時仍能讀到前一天的紀錄
+const redisDayCounterTTL = 48 * time.Hour
+
+// initRedis 連線到 REDIS_URL 指定的 Redis，未設定時維持使用 Firestore
+func initRedis(ctx context.Context, url string) error {
+	if url == "" {
+		return nil
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return fmt.Errorf("invalid REDIS_URL: %v", err)
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return fmt.Errorf("failed to connect to redis: %v", err)
+	}
+	redisClient = client
+	return nil
+}
+
+// redisKey 回傳加上機器人命名空間的 key，例如 tg-helper:team-a_oauth_states:<state>
+func redisKey(ctx context.Context, name string, parts ...string) string {
+	key := redisKeyPrefix + collectionName(ctx, name)
+	for _, p := range parts {
+		key += ":" + p
+	}
+	return key
+}
+
+// 記錄使用者進行中的 state，讓 /forget_me 可以一併刪除
+func redisUserStatesKey(ctx context.Context, userID int64) string {
+	return redisKey(ctx, stateCollection, "user", fmt.Sprintf("%d", userID))
+}
+
+func redisSaveOAuthState(ctx context.Context, state string, data *oauthState) error {
+	value, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(data.ExpireAt)
+	userStates := redisUserStatesKey(ctx, data.UserID)
+	_, err = redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, redisKey(ctx, stateCollection, state), value, ttl)
+		pipe.SAdd(ctx, userStates, state)
+		pipe.Expire(ctx, userStates, ttl)
+		return nil
+	})
+	return err
+}
+
+// redisConsumeOAuthState 以 GETDEL 讀取並刪除 state，同一個 state 只能兌換一次
+func redisConsumeOAuthState(ctx context.Context, state string) (*oauthState, error) {
+	value, err := redisClient.GetDel(ctx, redisKey(ctx, stateCollection, state)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, errStateConsumed
+	}
+	if err != nil {
+		return nil, err
+	}
+	var data oauthState
+	if err := json.Unmarshal(value, &data); err != nil {
+		return nil, err
+	}
+	redisClient.SRem(ctx, redisUserStatesKey(ctx, data.UserID), state)
+	return &data, nil
+}
+
+// redisClaimUpdate 以 SET NX 記錄 update_id，回傳 false 表示這個更新已經處理過
+func redisClaimUpdate(ctx context.Context, updateID int) (bool, error) {
+	return redisClient.SetNX(ctx, redisKey(ctx, processedUpdateCollection, fmt.Sprintf("%d", updateID)), 1, processedUpdateTTL).Result()
+}
+
+func redisMinuteCounterKey(ctx context.Context, userID int64) string {
+	return redisKey(ctx, rateLimitCollection, fmt.Sprintf("%d", userID), "minute")
+}
+
+func redisDayCounterKey(ctx context.Context, userID int64, now time.Time) string {
+	return redisKey(ctx, rateLimitCollection, fmt.Sprintf("%d", userID), now.UTC().Format("2006-01-02"))
+}
+
+// 檢查兩種限制後才佔用一次額度，回傳 {0} 表示成功、{1, 剩餘毫秒} 表示超過每分鐘次數、{2} 表示超過每日用量
+var reserveUploadScript = redis.NewScript(`
+local count = tonumber(redis.call('GET', KEYS[1]) or '0')
+if tonumber(ARGV[1]) > 0 and count >= tonumber(ARGV[1]) then
+	return {1, redis.call('PTTL', KEYS[1])}
+end
+local bytes = tonumber(redis.call('GET', KEYS[2]) or '0')
+if tonumber(ARGV[2]) > 0 and bytes + tonumber(ARGV[3]) > tonumber(ARGV[2]) then
+	return {2, 0}
+end
+if redis.call('INCR', KEYS[1]) == 1 then
+	redis.call('PEXPIRE', KEYS[1], 60000)
+end
+return {0, 0}
+`)
+
+// redisReserveUpload 與 reserveUpload 相同，但以 Lua 腳本在 Redis 中原子地檢查與計數
+func redisReserveUpload(ctx context.Context, limits RateLimitConfig, userID int64, declaredSize int64) error {
+	now := time.Now().UTC()
+	keys := []string{redisMinuteCounterKey(ctx, userID), redisDayCounterKey(ctx, userID, now)}
+	result, err := reserveUploadScript.Run(ctx, redisClient, keys, limits.UploadsPerMinute, limits.dailyUploadBytes(), declaredSize).Int64Slice()
+	if err != nil {
+		return err
+	}
+	switch result[0] {
+	case 1:
+		return &rateLimitError{Reason: "per-minute upload count", RetryAt: now.Add(time.Duration(max(result[1], 0)) * time.Millisecond)}
+	case 2:
+		return &rateLimitError{Reason: "daily upload bytes", RetryAt: nextUTCMidnight(now)}
+	}
+	return nil
+}
+
+func redisRecordUploadBytes(ctx context.Context, userID int64, size int64) error {
+	key := redisDayCounterKey(ctx, userID, time.Now())
+	_, err := redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.IncrBy(ctx, key, size)
+		pipe.Expire(ctx, key, redisDayCounterTTL)
+		return nil
+	})
+	return err
+}
+
+func redisDailyUploadBytes(ctx context.Context, userID int64) (int64, error) {
+	n, err := redisClient.Get(ctx, redisDayCounterKey(ctx, userID, time.Now())).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return n, err
+}
+
+// redisDeleteUser 刪除使用者在 Redis 中的上傳計數與進行中的 state
+func redisDeleteUser(ctx context.Context, userID int64) error {
+	userStates := redisUserStatesKey(ctx, userID)
+	states, err := redisClient.SMembers(ctx, userStates).Result()
+	if err != nil {
+		return fmt.Errorf("failed to list redis oauth states: %v", err)
+	}
+	keys := []string{userStates, redisMinuteCounterKey(ctx, userID), redisDayCounterKey(ctx, userID, time.Now())}
+	for _, state := range states {
+		keys = append(keys, redisKey(ctx, stateCollection, state))
+	}
+	if err := redisClient.Del(ctx, keys...).Err(); err != nil {
+		return fmt.Errorf("failed to delete redis keys: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+// --- Google 日曆提醒 ---
+
+// 有時間的提醒在日曆上佔用的長度
+const reminderDuration = 30 * time.Minute
+
+var (
+	relativeTimePattern = regexp.MustCompile(`^\+(\d+)([mhd])$`)
+	clockPattern        = regexp.MustCompile(`^(\d{1,2}):(\d{2})$`)
+)
+
+var errReminderTime = errors.New("invalid reminder time")
+
+// 處理 /remind 指令：在使用者的 Google 日曆建立提醒
+// 用法：/remind <時間> <內容>，回覆一則訊息時可以省略內容，改用該訊息的文字
+func handleRemind(ctx context.Context, message *tgbotapi.Message) {
+	if !message.Chat.IsPrivate() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請在與機器人的私訊中使用 /remind。")
+		return
+	}
+	usage := "用法：/remind <時間> <內容>\n時間可以是 +30m、+2h、+1d、15:00、明天 9:00、2025-01-31 14:00，或只有日期的 2025-01-31（整天）。\n回覆一則訊息時可以省略內容。"
+	args := strings.TrimSpace(message.CommandArguments())
+	if args == "" {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, usage)
+		return
+	}
+	if !requireGoogleFeature(ctx, message, "calendar") {
+		return
+	}
+
+	service, err := newCalendarService(ctx, message.From.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create calendar service", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的授權時發生錯誤，請稍後再試。")
+		return
+	}
+	// 相對時間與「明天」都以使用者日曆的時區計算
+	loc := time.UTC
+	if tz, err := service.Settings.Get("timezone").Context(ctx).Do(); err != nil {
+		slog.WarnContext(ctx, "Failed to read calendar time zone", "error", err)
+	} else if l, err := time.LoadLocation(tz.Value); err == nil {
+		loc = l
+	}
+
+	start, allDay, text, err := parseReminder(args, time.Unix(int64(message.Date), 0).In(loc))
+	if err != nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "無法辨識提醒的時間。\n\n"+usage)
+		return
+	}
+	if text == "" && message.ReplyToMessage != nil {
+		text = messageText(message.ReplyToMessage)
+	}
+	if text == "" {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請輸入提醒的內容。\n\n"+usage)
+		return
+	}
+
+	summary, description := text, ""
+	if first, rest, ok := strings.Cut(text, "\n"); ok {
+		summary, description = first, strings.TrimSpace(rest)
+	}
+	event := &calendar.Event{
+		Summary:     summary,
+		Description: description,
+		Reminders: &calendar.EventReminders{
+			Overrides: []*calendar.EventReminder{{Method: "popup", Minutes: 0, ForceSendFields: []string{"Minutes"}}},
+			// 只使用上面的提醒，不套用日曆的預設提醒
+			ForceSendFields: []string{"UseDefault"},
+		},
+	}
+	if allDay {
+		event.Start = &calendar.EventDateTime{Date: start.Format(time.DateOnly)}
+		event.End = &calendar.EventDateTime{Date: start.AddDate(0, 0, 1).Format(time.DateOnly)}
+	} else {
+		event.Start = &calendar.EventDateTime{DateTime: start.Format(time.RFC3339), TimeZone: loc.String()}
+		event.End = &calendar.EventDateTime{DateTime: start.Add(reminderDuration).Format(time.RFC3339), TimeZone: loc.String()}
+	}
+	created, err := service.Events.Insert("primary", event).Context(ctx).Do()
+	if err != nil {
+		reportError(ctx, "Failed to create calendar event", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "建立日曆提醒時發生錯誤，請稍後再試。")
+		return
+	}
+	slog.InfoContext(ctx, "Created calendar reminder", "event_id", created.Id, "all_day", allDay)
+
+	when := start.Format("2006-01-02 15:04 MST")
+	if allDay {
+		when = start.Format(time.DateOnly) + "（整天）"
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("已在 Google 日曆建立提醒「%s」：%s\n%s", summary, when, created.HtmlLink))
+}
+
+// messageText 回傳訊息的文字，沒有文字時回傳說明文字
+func messageText(message *tgbotapi.Message) string {
+	if message.Text != "" {
+		return message.Text
+	}
+	return message.Caption
+}
+
+// parseReminder 從參數開頭解析提醒的時間，回傳時間、是否為整天的提醒與剩下的內容
+// 支援 +30m、+2h、+1d、15:00、明天 9:00、2025-01-31 14:00 與只有日期的 2025-01-31
+func parseReminder(args string, now time.Time) (time.Time, bool, string, error) {
+	fields := strings.Fields(args)
+	// 剩下的內容保留原本的換行
+	rest := func(n int) string {
+		s := args
+		for _, f := range fields[:n] {
+			s = strings.TrimPrefix(strings.TrimSpace(s), f)
+		}
+		return strings.TrimSpace(s)
+	}
+
+	if m := relativeTimePattern.FindStringSubmatch(fields[0]); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		unit := map[string]time.Duration{"m": time.Minute, "h": time.Hour, "d": 24 * time.Hour}[m[2]]
+		return now.Add(time.Duration(n) * unit), false, rest(1), nil
+	}
+
+	day, consumed := now, 0
+	switch strings.ToLower(fields[0]) {
+	case "今天", "today":
+		consumed = 1
+	case "明天", "tomorrow":
+		day, consumed = now.AddDate(0, 0, 1), 1
+	case "後天":
+		day, consumed = now.AddDate(0, 0, 2), 1
+	default:
+		if d, err := time.ParseInLocation(time.DateOnly, fields[0], now.Location()); err == nil {
+			day, consumed = d, 1
+		}
+	}
+	if consumed < len(fields) {
+		if m := clockPattern.FindStringSubmatch(fields[consumed]); m != nil {
+			hour, _ := strconv.Atoi(m[1])
+			minute, _ := strconv.Atoi(m[2])
+			if hour > 23 || minute > 59 {
+				return time.Time{}, false, "", errReminderTime
+			}
+			start := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, now.Location())
+			// 只有時間且已經過了時，視為明天的同一時間
+			if consumed == 0 && !start.After(now) {
+				start = start.AddDate(0, 0, 1)
+			}
+			return start, false, rest(consumed + 1), nil
+		}
+	}
+	if consumed == 0 {
+		return time.Time{}, false, "", errReminderTime
+	}
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, now.Location())
+	return start, true, rest(consumed), nil
+}
+
+// newCalendarService 以使用者目前的 Google 帳號建立 Calendar API 的 client
+func newCalendarService(ctx context.Context, userID int64) (*calendar.Service, error) {
+	userToken, err := loadDriveToken(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	client := googleOAuth(ctx).Client(withTracedHTTPClient(ctx), userToken.Token())
+	service, err := calendar.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create calendar service: %v", err)
+	}
+	return service, nil
+}
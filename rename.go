@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 處理 /rename 指令：回覆上傳的檔案或確認訊息並輸入 /rename <新檔名>，重新命名目的地上的檔案
+// 新檔名沒有副檔名時沿用原本的副檔名
+func handleRename(ctx context.Context, message *tgbotapi.Message) {
+	name := strings.TrimSpace(sanitizeNameSegment(message.CommandArguments()))
+	if message.ReplyToMessage == nil || name == "" {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "用法：回覆已上傳的檔案或上傳確認訊息並輸入 /rename <新檔名>")
+		return
+	}
+
+	record, err := findUploadRecord(ctx, message.Chat.ID, message.ReplyToMessage.MessageID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to find upload record", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取上傳紀錄時發生錯誤，請稍後再試。")
+		return
+	}
+	if record == nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "找不到這則訊息的上傳紀錄，請回覆您上傳的檔案或機器人的上傳確認訊息。")
+		return
+	}
+	if record.UserID != message.From.ID {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "只有上傳這個檔案的人可以重新命名它。")
+		return
+	}
+	dest, ok := destinations[record.Destination].(renameDestination)
+	if !ok {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "這個檔案所在的目的地不支援重新命名。")
+		return
+	}
+
+	if _, ext := splitFileName(name); ext == "" {
+		_, oldExt := splitFileName(record.Name)
+		name = joinExt(name, oldExt)
+	}
+	if record.Account != "" {
+		ctx = withGoogleAccount(ctx, record.Account)
+	}
+	err = dest.Rename(ctx, record.UserID, record.FileID, name)
+	if errors.Is(err, errNotConnected) {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "上傳這個檔案的帳號已不再連結，無法重新命名。")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to rename file", "destination", record.Destination, "file_id", record.FileID, "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "重新命名時發生錯誤，請稍後再試。")
+		return
+	}
+
+	err = collection(ctx, uploadCollection).Doc(uploadRecordDocID(record.ChatID, record.MessageID)).Update(ctx, []Update{{Path: "name", Value: name}})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to update upload record", "error", err)
+	}
+	slog.InfoContext(ctx, "Renamed file", "destination", record.Destination, "file_id", record.FileID)
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("已將「%s」重新命名為「%s」。", record.Name, name))
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 中斷的上傳 ---
+
+// Firestore 集合名稱
+const uploadJobCollection = "upload_jobs"
+
+const (
+	// 上傳進行中時更新心跳的間隔
+	uploadJobHeartbeat = time.Minute
+	// 超過這段時間沒有心跳的上傳視為執行個體已經結束
+	uploadJobStaleAfter = 5 * uploadJobHeartbeat
+	// 同一個檔案最多重新上傳的次數，超過時放棄並通知使用者
+	maxUploadJobAttempts = 3
+	// 無法重新上傳的紀錄保留多久後由 TTL 清除
+	uploadJobTTL = 24 * time.Hour
+)
+
+// UploadJob 是正在進行的上傳；上傳結束（無論成功或失敗）時刪除，留下來的代表執行個體在上傳途中結束
+// Drive 的可續傳上傳工作階段不會對外公開，因此重新上傳時會從 Telegram 重新下載整個檔案
+type UploadJob struct {
+	UserID       int64         `firestore:"user_id"`
+	Message      string        `firestore:"message"`        // 原始訊息的 JSON，用來重新處理檔案
+	FileName     string        `firestore:"file_name"`      // 通知使用者時顯示的檔名
+	Account      string        `firestore:"account"`        // 使用者選擇的 Google 帳號，未選擇時為空字串
+	Target       *UploadTarget `firestore:"target"`         // 使用者選擇的目的地，未選擇時為 nil
+	ThreadID     int           `firestore:"thread_id"`      // 論壇主題，讓上傳結果回覆到同一個主題
+	NotifyChatID int64         `firestore:"notify_chat_id"` // 中斷時通知的聊天室
+	ReplyTo      int           `firestore:"reply_to"`
+	Import       bool          `firestore:"import"`   // 來自 /import 的匯入，重新上傳時同樣計入匯入進度
+	Attempts     int           `firestore:"attempts"` // 已經重新上傳的次數
+	StartedAt    time.Time     `firestore:"started_at"`
+	HeartbeatAt  time.Time     `firestore:"heartbeat_at"`
+	ExpireAt     time.Time     `firestore:"expire_at"`
+}
+
+// uploadJob 是 handleFile 中正在進行的上傳，finish 停止心跳並刪除紀錄
+type uploadJob struct {
+	ref  *DocRef
+	stop chan struct{}
+}
+
+// startUploadJob 記錄開始上傳的檔案，並在上傳期間定期更新心跳；記錄失敗時仍然上傳，只是無法在中斷後重新開始
+// 以聊天室與訊息 ID 作為文件 ID，重新上傳時覆寫同一份紀錄並保留已重試的次數
+func startUploadJob(ctx context.Context, message *tgbotapi.Message, fileName string, notifyChatID int64, replyTo int) *uploadJob {
+	raw, err := json.Marshal(message)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to encode message for upload job", "error", err)
+		return nil
+	}
+	var threadID int
+	if topic := forumTopicFromContext(ctx); topic != nil {
+		threadID = topic.ThreadID
+	}
+	var userID int64
+	if message.From != nil {
+		userID = message.From.ID
+	}
+	now := time.Now()
+	ref := collection(ctx, uploadJobCollection).Doc(uploadRecordDocID(message.Chat.ID, message.MessageID))
+	err = ref.Merge(ctx, map[string]interface{}{
+		"user_id":        userID,
+		"message":        string(raw),
+		"file_name":      fileName,
+		"account":        googleAccountFromContext(ctx),
+		"target":         uploadTargetFromContext(ctx),
+		"thread_id":      threadID,
+		"notify_chat_id": notifyChatID,
+		"reply_to":       replyTo,
+		"import":         importing(ctx),
+		"started_at":     now,
+		"heartbeat_at":   now,
+		"expire_at":      now.Add(uploadJobTTL),
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to record upload job", "error", err)
+		return nil
+	}
+
+	job := &uploadJob{ref: ref, stop: make(chan struct{})}
+	// 心跳不受 webhook 請求結束影響，只在 finish 時停止
+	hctx := context.WithoutCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(uploadJobHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-job.stop:
+				return
+			case <-ticker.C:
+				if err := ref.Update(hctx, []Update{{Path: "heartbeat_at", Value: time.Now()}}); err != nil {
+					slog.WarnContext(hctx, "Failed to update upload job heartbeat", "error", err)
+				}
+			}
+		}
+	}()
+	return job
+}
+
+// finish 在上傳結束時刪除紀錄，job 為 nil 時不做任何事
+func (j *uploadJob) finish(ctx context.Context) {
+	if j == nil {
+		return
+	}
+	close(j.stop)
+	if err := j.ref.Delete(context.WithoutCancel(ctx)); err != nil {
+		slog.WarnContext(ctx, "Failed to delete upload job", "error", err)
+	}
+}
+
+// claimStaleUploadJob 在交易中確認上傳仍然停滯並更新心跳，讓同時執行的其他執行個體不會重複處理
+// 回傳 nil 表示已被其他執行個體處理
+func claimStaleUploadJob(ctx context.Context, ref *DocRef, cutoff time.Time) (*UploadJob, error) {
+	var job *UploadJob
+	err := store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		job = nil
+		doc, err := tx.Get(ref)
+		if errors.Is(err, errDocNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var data UploadJob
+		if err := doc.DataTo(&data); err != nil {
+			return err
+		}
+		if data.HeartbeatAt.After(cutoff) {
+			return nil
+		}
+		job = &data
+		if data.Attempts >= maxUploadJobAttempts {
+			return tx.Delete(ref)
+		}
+		return tx.Update(ref, []Update{
+			{Path: "attempts", Value: data.Attempts + 1},
+			{Path: "heartbeat_at", Value: time.Now()},
+		})
+	})
+	return job, err
+}
+
+// resumeUploadJobs 重新上傳 ctx 中的機器人所有停滯的上傳，回傳重新上傳與放棄的數量
+// 上傳會依序在呼叫端執行，呼叫端需要給足夠的時間
+func resumeUploadJobs(ctx context.Context) (resumed, abandoned int, err error) {
+	cutoff := time.Now().Add(-uploadJobStaleAfter)
+	docs, err := collection(ctx, uploadJobCollection).Where("heartbeat_at", "<", cutoff).GetAll(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to list upload jobs: %v", err)
+	}
+	for _, doc := range docs {
+		job, err := claimStaleUploadJob(ctx, doc.Ref, cutoff)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to claim upload job", "job_id", doc.Ref.ID, "error", err)
+			continue
+		}
+		if job == nil {
+			continue
+		}
+		jctx := withLogAttrs(ctx, slog.String("job_id", doc.Ref.ID), slog.Int64("user_id", job.UserID))
+		if job.Attempts >= maxUploadJobAttempts {
+			slog.WarnContext(jctx, "Abandoned interrupted upload", "attempts", job.Attempts)
+			replyToUser(jctx, job.NotifyChatID, job.ReplyTo, fmt.Sprintf("檔案 '%s' 的上傳多次中斷，已停止重試，請重新傳送。", job.FileName))
+			abandoned++
+			continue
+		}
+		var message tgbotapi.Message
+		if err := json.Unmarshal([]byte(job.Message), &message); err != nil {
+			slog.ErrorContext(jctx, "Failed to decode upload job message", "error", err)
+			continue
+		}
+		slog.InfoContext(jctx, "Resuming interrupted upload", "attempt", job.Attempts+1)
+		replyToUser(jctx, job.NotifyChatID, job.ReplyTo, fmt.Sprintf("檔案 '%s' 的上傳因服務重新啟動而中斷，正在重新上傳…", job.FileName))
+		resumeUploadJob(jctx, job, &message)
+		resumed++
+	}
+	return resumed, abandoned, nil
+}
+
+// resumeUploadJob 以原本的訊息重新執行上傳，不再詢問確認
+func resumeUploadJob(ctx context.Context, job *UploadJob, message *tgbotapi.Message) {
+	defer func() {
+		if r := recover(); r != nil {
+			reportPanic(ctx, r)
+		}
+	}()
+	ctx = withUploadConfirmed(ctx)
+	if job.Account != "" {
+		ctx = withGoogleAccount(ctx, job.Account)
+	}
+	if job.Target != nil {
+		ctx = withUploadTarget(ctx, job.Target)
+	}
+	if job.ThreadID != 0 {
+		ctx = withForumTopic(ctx, &forumTopic{ThreadID: job.ThreadID})
+	}
+	if job.Import {
+		run := &importRun{}
+		handleFile(withImportRun(ctx, run), message)
+		recordImportResult(ctx, message, run)
+		return
+	}
+	handleFile(ctx, message)
+}
+
+// resumeAllUploadJobs 在啟動時重新上傳所有機器人停滯的上傳
+func resumeAllUploadJobs(ctx context.Context) {
+	for _, t := range tenants {
+		resumed, abandoned, err := resumeUploadJobs(withTenant(ctx, t))
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to resume upload jobs", "bot_id", t.ID, "error", err)
+			continue
+		}
+		if resumed > 0 || abandoned > 0 {
+			slog.InfoContext(ctx, "Resumed interrupted uploads", "bot_id", t.ID, "resumed", resumed, "abandoned", abandoned)
+		}
+	}
+}
+
+// 處理 /cron/resume_uploads：重新上傳所有機器人停滯的上傳，並在背景繼續停滯的 /migrate
+func cronResumeUploadsHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeCron(w, r) {
+		return
+	}
+	ctx := r.Context()
+	resumed, abandoned, migrations := 0, 0, 0
+	for _, t := range tenants {
+		tctx := withTenant(ctx, t)
+		n, a, err := resumeUploadJobs(tctx)
+		resumed, abandoned = resumed+n, abandoned+a
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to resume upload jobs", "bot_id", t.ID, "error", err)
+			http.Error(w, "failed to list upload jobs", http.StatusInternalServerError)
+			return
+		}
+		m, err := resumeMigrations(tctx)
+		migrations += m
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to resume migrations", "bot_id", t.ID, "error", err)
+			http.Error(w, "failed to list migrations", http.StatusInternalServerError)
+			return
+		}
+	}
+	slog.InfoContext(ctx, "Resume uploads finished", "resumed", resumed, "abandoned", abandoned, "migrations", migrations)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"resumed": resumed, "abandoned": abandoned, "migrations": migrations})
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// --- 綁定的保留期限 ---
+
+// 每個機器人每次最多處理的到期檔案數
+const maxRetentionPerRun = 500
+
+// trashExpiredUploads 將 ctx 中的機器人已經超過保留期限的檔案移到目的地的垃圾桶，回傳移動的數量
+// 成功、檔案已在垃圾桶或帳號已不再連結時移除紀錄上的 trash_at，暫時性的錯誤留到下次再試
+func trashExpiredUploads(ctx context.Context, now time.Time) (int, error) {
+	docs, err := collection(ctx, uploadCollection).Where("trash_at", "<=", now).Limit(maxRetentionPerRun).GetAll(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list expired uploads: %v", err)
+	}
+	trashed := 0
+	for _, doc := range docs {
+		var record UploadRecord
+		if err := doc.DataTo(&record); err != nil {
+			slog.WarnContext(ctx, "Failed to decode upload record", "doc_id", doc.Ref.ID, "error", err)
+			continue
+		}
+		updates := []Update{{Path: "trash_at", Value: deleteField}}
+		if !record.Trashed {
+			dest, ok := destinations[record.Destination].(trashDestination)
+			if ok {
+				tctx := ctx
+				if record.Account != "" {
+					tctx = withGoogleAccount(ctx, record.Account)
+				}
+				err := dest.SetTrashed(tctx, record.UserID, record.FileID, true)
+				if err != nil && !errors.Is(err, errNotConnected) {
+					slog.WarnContext(ctx, "Failed to trash expired upload", "file_id", record.FileID, "error", err)
+					continue
+				}
+				if err == nil {
+					updates = append(updates, Update{Path: "trashed", Value: true})
+					trashed++
+				}
+			}
+		}
+		if err := doc.Ref.Update(ctx, updates); err != nil {
+			slog.WarnContext(ctx, "Failed to update upload record", "doc_id", doc.Ref.ID, "error", err)
+		}
+	}
+	return trashed, nil
+}
+
+// 處理 /cron/retention：將所有機器人超過綁定保留期限的檔案移到垃圾桶
+func cronRetentionHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeCron(w, r) {
+		return
+	}
+	ctx := r.Context()
+	now := time.Now()
+	trashed := 0
+	for _, t := range tenants {
+		n, err := trashExpiredUploads(withTenant(ctx, t), now)
+		trashed += n
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to trash expired uploads", "bot_id", t.ID, "error", err)
+			http.Error(w, "failed to trash expired uploads", http.StatusInternalServerError)
+			return
+		}
+	}
+	slog.InfoContext(ctx, "Retention finished", "trashed", trashed)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"trashed": trashed})
+}
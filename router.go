@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 更新分派 ---
+
+// callbackHandler 處理內嵌按鈕的點擊，args 是 callback data 中種類之後的參數
+type callbackHandler func(ctx context.Context, query *tgbotapi.CallbackQuery, args []string)
+
+// 依 callback data 的種類索引
+var callbackHandlers = map[string]callbackHandler{}
+
+// 收到 edited_message 與 my_chat_member 更新時依序呼叫
+var (
+	editedMessageHandlers []func(ctx context.Context, message *tgbotapi.Message)
+	myChatMemberHandlers  []func(ctx context.Context, update *tgbotapi.ChatMemberUpdated)
+)
+
+func registerCallback(kind string, handler callbackHandler) {
+	callbackHandlers[kind] = handler
+}
+
+func onEditedMessage(handler func(ctx context.Context, message *tgbotapi.Message)) {
+	editedMessageHandlers = append(editedMessageHandlers, handler)
+}
+
+func onMyChatMember(handler func(ctx context.Context, update *tgbotapi.ChatMemberUpdated)) {
+	myChatMemberHandlers = append(myChatMemberHandlers, handler)
+}
+
+// Telegram 限制 callback data 最多 64 位元組
+const maxCallbackDataBytes = 64
+
+// encodeCallbackData 產生 "<種類>:<參數>..." 格式的 callback data；參數不可包含「:」
+func encodeCallbackData(kind string, args ...string) string {
+	data := strings.Join(append([]string{kind}, args...), ":")
+	if len(data) > maxCallbackDataBytes {
+		// 按鈕會被 Telegram 拒絕，這是程式錯誤而不是使用者輸入的問題
+		panic(fmt.Sprintf("callback data %q exceeds %d bytes", data, maxCallbackDataBytes))
+	}
+	return data
+}
+
+// decodeCallbackData 拆出 callback data 的種類與參數
+func decodeCallbackData(data string) (string, []string) {
+	parts := strings.Split(data, ":")
+	return parts[0], parts[1:]
+}
+
+// routeUpdate 將 update 分派給對應的處理函式，沒有處理的更新類型直接忽略
+// 日誌欄位與存取控制已由 handleUpdate 的中介層處理
+func routeUpdate(ctx context.Context, update *tgbotapi.Update, body []byte) {
+	switch {
+	case update.Message != nil:
+		handleMessage(ctx, update.Message, body)
+	case update.CallbackQuery != nil:
+		handleCallbackQuery(ctx, update.CallbackQuery)
+	case update.InlineQuery != nil:
+		handleInlineQuery(ctx, update.InlineQuery)
+	case update.EditedMessage != nil:
+		for _, handler := range editedMessageHandlers {
+			handler(ctx, update.EditedMessage)
+		}
+	case update.ChannelPost != nil:
+		handleChannelPost(ctx, update.ChannelPost)
+	case update.EditedChannelPost != nil:
+		handleEditedMedia(ctx, update.EditedChannelPost)
+	case update.MyChatMember != nil:
+		for _, handler := range myChatMemberHandlers {
+			handler(ctx, update.MyChatMember)
+		}
+	case update.Poll != nil:
+		handlePollUpdate(ctx, update.Poll)
+	default:
+		// telegram-bot-api 尚未支援的更新類型，從原始內容解析
+		if reaction := parseMessageReaction(body); reaction != nil {
+			handleMessageReaction(ctx, reaction)
+		}
+	}
+}
+
+// handleCallbackQuery 依 callback data 的種類分派按鈕點擊
+func handleCallbackQuery(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	if query.Message == nil {
+		answerCallback(ctx, query, "")
+		return
+	}
+
+	kind, args := decodeCallbackData(query.Data)
+	handler, ok := callbackHandlers[kind]
+	if !ok {
+		slog.WarnContext(ctx, "Unknown callback data", "data", query.Data)
+		answerCallback(ctx, query, "")
+		return
+	}
+	handler(ctx, query, args)
+}
+
+// answerCallback 回應按鈕點擊，text 非空時會在使用者畫面上短暫顯示
+func answerCallback(ctx context.Context, query *tgbotapi.CallbackQuery, text string) {
+	if _, err := senderFor(ctx).Request(tgbotapi.NewCallback(query.ID, text)); err != nil {
+		slog.WarnContext(ctx, "Failed to answer callback query", "error", err)
+	}
+}
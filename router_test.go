@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncodeCallbackData(t *testing.T) {
+	tests := []struct {
+		kind     string
+		args     []string
+		want     string
+		wantArgs []string
+	}{
+		{"dest", []string{"drive"}, "dest:drive", []string{"drive"}},
+		{"confirm", []string{"42", "yes"}, "confirm:42:yes", []string{"42", "yes"}},
+		{"noop", nil, "noop", []string{}},
+		{"pad", []string{strings.Repeat("x", maxCallbackDataBytes-4)}, "pad:" + strings.Repeat("x", maxCallbackDataBytes-4), []string{strings.Repeat("x", maxCallbackDataBytes-4)}},
+	}
+	for _, tt := range tests {
+		got := encodeCallbackData(tt.kind, tt.args...)
+		if got != tt.want {
+			t.Errorf("encodeCallbackData(%q, %q) = %q, want %q", tt.kind, tt.args, got, tt.want)
+		}
+		kind, args := decodeCallbackData(got)
+		if kind != tt.kind || !reflect.DeepEqual(args, tt.wantArgs) {
+			t.Errorf("decodeCallbackData(%q) = %q, %q, want %q, %q", got, kind, args, tt.kind, tt.wantArgs)
+		}
+	}
+}
+
+func TestEncodeCallbackDataTooLong(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("encodeCallbackData did not panic on data longer than 64 bytes")
+		}
+	}()
+	encodeCallbackData("pad", strings.Repeat("x", maxCallbackDataBytes-3))
+}
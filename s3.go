@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// 預簽章下載連結的有效時間
+const s3LinkExpiry = 24 * time.Hour
+
+// s3Destination 將檔案上傳到由管理者設定的 S3 相容儲存空間（AWS S3、MinIO、GCS 互通性 API 等）
+// 所有使用者共用同一個 bucket，物件會依使用者 ID 分開存放
+type s3Destination struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// newS3Destination 在設定了 S3_BUCKET 時啟用 S3 目的地
+// 未設定 S3_ACCESS_KEY_ID 時，依序嘗試 AWS/MinIO 環境變數、憑證檔與 IAM 角色（包含 Web Identity）
+func newS3Destination(cfg S3Config) (*s3Destination, bool, error) {
+	if cfg.Bucket == "" {
+		return nil, false, nil
+	}
+
+	var creds *credentials.Credentials
+	if cfg.AccessKeyID != "" {
+		creds = credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, "")
+	} else {
+		creds = credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		})
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:     creds,
+		Secure:    !cfg.Insecure,
+		Region:    cfg.Region,
+		Transport: instrumentedClient.Transport,
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create S3 client: %v", err)
+	}
+	return &s3Destination{
+		client: client,
+		bucket: cfg.Bucket,
+		prefix: strings.Trim(cfg.Prefix, "/"),
+	}, true, nil
+}
+
+func (*s3Destination) Name() string        { return "s3" }
+func (*s3Destination) DisplayName() string { return "雲端儲存空間 (S3)" }
+
+// Connected 永遠回傳 true，S3 由管理者設定，使用者不需要另外授權
+func (*s3Destination) Connected(ctx context.Context, userID int64) (bool, error) {
+	return true, nil
+}
+
+func (*s3Destination) Connect(ctx context.Context, userID int64) (string, error) {
+	return "", errConnectNotRequired
+}
+
+func (d *s3Destination) Upload(ctx context.Context, userID int64, file *UploadFile) (*UploadResult, error) {
+	key, err := d.availableKey(ctx, userID, file)
+	if err != nil {
+		return nil, err
+	}
+
+	// 大小未知（-1）時 minio 會以分段上傳的方式串流
+	spanCtx, span := startSpan(ctx, "s3.upload")
+	opts := minio.PutObjectOptions{ContentType: file.MimeType}
+	if file.Origin != nil {
+		// 物件中繼資料會放在 HTTP 標頭中，只能使用 ASCII，因此以 URL 編碼儲存
+		opts.UserMetadata = map[string]string{}
+		for k, v := range file.Origin.Properties() {
+			opts.UserMetadata[strings.ReplaceAll(k, "_", "-")] = url.QueryEscape(v)
+		}
+	}
+	info, err := d.client.PutObject(spanCtx, d.bucket, key, file.Body, -1, opts)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := d.Link(ctx, userID, info.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &UploadResult{FileID: info.Key, Name: path.Base(info.Key), Link: link}, nil
+}
+
+// Link 回傳有效期限為 s3LinkExpiry 的預簽章下載連結
+func (d *s3Destination) Link(ctx context.Context, userID int64, fileID string) (string, error) {
+	u, err := d.client.PresignedGetObject(ctx, d.bucket, fileID, s3LinkExpiry, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+// Share 回傳預簽章下載連結；bucket 本身不會被公開
+func (d *s3Destination) Share(ctx context.Context, userID int64, fileID string) (string, error) {
+	return d.Link(ctx, userID, fileID)
+}
+
+// availableKey 產生物件的 key；S3 會直接覆寫同名物件，因此遇到衝突時在檔名後加上編號
+func (d *s3Destination) availableKey(ctx context.Context, userID int64, file *UploadFile) (string, error) {
+	dir := path.Join(append([]string{d.prefix, fmt.Sprintf("%d", userID)}, file.Folders...)...)
+	name, ext := splitFileName(file.Name)
+	for i := 0; i < 100; i++ {
+		candidate := file.Name
+		if i > 0 {
+			candidate = joinExt(fmt.Sprintf("%s (%d)", name, i), ext)
+		}
+		key := path.Join(dir, candidate)
+		_, err := d.client.StatObject(ctx, d.bucket, key, minio.StatObjectOptions{})
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				return key, nil
+			}
+			return "", fmt.Errorf("failed to check object %q: %v", key, err)
+		}
+	}
+	return "", errors.New("too many objects with the same name")
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/sheets/v4"
+)
+
+// --- Google 權限 ---
+
+// googleFeature 是需要特定 Google 權限的功能；連結時只要求基本功能的權限，其他功能在第一次使用時才請使用者追加授權
+type googleFeature struct {
+	Name        string
+	Description string
+	Scopes      []string
+}
+
+// 基本功能，連結 Google Drive 時一律要求
+const featureUpload = "upload"
+
+// googleFeatures 列出所有功能需要的權限
+var googleFeatures = []googleFeature{
+	{featureUpload, "上傳檔案到機器人建立的資料夾", []string{drive.DriveFileScope}},
+	{"browse", "讀取 Drive 中既有資料夾的名稱，以便選擇上傳位置", []string{drive.DriveMetadataReadonlyScope}},
+	{"ledger", "將上傳紀錄寫入您自己建立的 Google 試算表", []string{sheets.SpreadsheetsScope}},
+	{"gmail", "讀取 Gmail 郵件與附件，以便用 /gmail_attachments 複製附件", []string{gmail.GmailReadonlyScope}},
+	{"calendar", "以 /remind 在 Google 日曆建立提醒，並讀取日曆的時區", []string{calendar.CalendarEventsScope, calendar.CalendarSettingsReadonlyScope}},
+}
+
+func findGoogleFeature(name string) (googleFeature, bool) {
+	for _, f := range googleFeatures {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return googleFeature{}, false
+}
+
+// baseGoogleScopes 回傳第一次連結時要求的權限
+func baseGoogleScopes() []string {
+	f, _ := findGoogleFeature(featureUpload)
+	return f.Scopes
+}
+
+// grantedScopes 解析權杖回應中的 scope 欄位；Google 在 include_granted_scopes 時會列出所有已授權的權限
+func grantedScopes(token *oauth2.Token) []string {
+	scope, _ := token.Extra("scope").(string)
+	return strings.Fields(scope)
+}
+
+// GrantedScopes 回傳這個權杖已授權的權限；記錄權限之前連結的帳號只有 drive.file
+func (t *UserToken) GrantedScopes() []string {
+	if len(t.Scopes) == 0 {
+		return baseGoogleScopes()
+	}
+	return t.Scopes
+}
+
+// HasFeature 回報這個權杖是否擁有功能需要的所有權限
+func (t *UserToken) HasFeature(f googleFeature) bool {
+	granted := map[string]bool{}
+	for _, s := range t.GrantedScopes() {
+		granted[s] = true
+	}
+	for _, s := range f.Scopes {
+		if !granted[s] {
+			return false
+		}
+	}
+	return true
+}
+
+// googleAuthURL 回傳要求指定權限的授權連結，include_granted_scopes 讓新的權杖同時保有之前授權的權限
+// email 不為空字串時帶入 login_hint，讓追加授權時選到同一個 Google 帳號
+func googleAuthURL(ctx context.Context, userID int64, scopes []string, email string) (string, error) {
+	state, challenge, err := newOAuthState(ctx, userID, defaultDestination)
+	if err != nil {
+		return "", err
+	}
+	cfg := *googleOAuth(ctx)
+	cfg.Scopes = scopes
+	opts := []oauth2.AuthCodeOption{
+		oauth2.AccessTypeOffline,
+		oauth2.ApprovalForce,
+		oauth2.SetAuthURLParam("include_granted_scopes", "true"),
+		challenge,
+	}
+	if email != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("login_hint", email))
+	}
+	return cfg.AuthCodeURL(state, opts...), nil
+}
+
+// requireGoogleFeature 確認使用者目前的 Google 帳號擁有功能需要的權限；
+// 沒有時回覆追加授權的連結並回傳 false，功能應該在使用者授權後再執行一次
+func requireGoogleFeature(ctx context.Context, message *tgbotapi.Message, name string) bool {
+	f, ok := findGoogleFeature(name)
+	if !ok {
+		slog.ErrorContext(ctx, "Unknown google feature", "feature", name)
+		return false
+	}
+	userToken, err := loadDriveToken(ctx, message.From.ID)
+	if errors.Is(err, errNotConnected) {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "您的 Google Drive 帳號尚未連結，請使用 /connect_drive 指令來連結。")
+		return false
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to retrieve token", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的授權時發生錯誤，請稍後再試。")
+		return false
+	}
+	if userToken.HasFeature(f) {
+		return true
+	}
+	authURL, err := googleAuthURL(ctx, message.From.ID, f.Scopes, userToken.Email)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create auth URL", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "產生授權連結時發生錯誤，請稍後再試。")
+		return false
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("這個功能需要額外的 Google 權限：%s。\n請點擊以下連結追加授權，完成後再試一次：\n%s", f.Description, authURL))
+	return false
+}
+
+// 處理 /permissions 指令：列出目前 Google 帳號的權限，或以 /permissions <功能> 追加授權
+func handlePermissions(ctx context.Context, message *tgbotapi.Message) {
+	if !message.Chat.IsPrivate() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請在與機器人的私訊中使用 /permissions。")
+		return
+	}
+	if name := strings.TrimSpace(message.CommandArguments()); name != "" {
+		if _, ok := findGoogleFeature(name); !ok {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("找不到功能 %s，輸入 /permissions 查看所有功能。", name))
+			return
+		}
+		if requireGoogleFeature(ctx, message, name) {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "您已經授權這個功能需要的權限了。")
+		}
+		return
+	}
+
+	userToken, err := loadDriveToken(ctx, message.From.ID)
+	if errors.Is(err, errNotConnected) {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "您的 Google Drive 帳號尚未連結，請使用 /connect_drive 指令來連結。")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to retrieve token", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的授權時發生錯誤，請稍後再試。")
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString("Google 權限：\n")
+	for _, f := range googleFeatures {
+		mark := "❌"
+		if userToken.HasFeature(f) {
+			mark = "✅"
+		}
+		fmt.Fprintf(&sb, "%s %s：%s\n", mark, f.Name, f.Description)
+	}
+	sb.WriteString("\n輸入 /permissions <功能> 可以追加授權。")
+	replyToUser(ctx, message.Chat.ID, message.MessageID, sb.String())
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	secretmanager "cloud.google.com/go/secretmanager/apiv1"
+	"cloud.google.com/go/secretmanager/apiv1/secretmanagerpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// --- Secret Manager ---
+
+// secretFields 回傳可以改由 Secret Manager 提供的設定，以對應的環境變數名稱索引
+func secretFields(cfg *Config) map[string]*string {
+	return map[string]*string{
+		"TELEGRAM_BOT_TOKEN":         &cfg.TelegramBotToken,
+		"TELEGRAM_API_HASH":          &cfg.MTProto.APIHash,
+		"GOOGLE_CLIENT_SECRET":       &cfg.Google.ClientSecret,
+		"DROPBOX_APP_SECRET":         &cfg.Dropbox.ClientSecret,
+		"MICROSOFT_CLIENT_SECRET":    &cfg.OneDrive.ClientSecret,
+		"S3_SECRET_ACCESS_KEY":       &cfg.S3.SecretAccessKey,
+		"CREDENTIALS_ENCRYPTION_KEY": &cfg.CredentialsEncryptionKey,
+		"SENTRY_DSN":                 &cfg.ErrorReporting.SentryDSN,
+		"CRON_SECRET":                &cfg.CronSecret,
+		"API_TOKEN":                  &cfg.APIToken,
+		"EVENT_WEBHOOK_SECRET":       &cfg.EventWebhook.Secret,
+		"REDIS_URL":                  &cfg.RedisURL,
+		"DATABASE_URL":               &cfg.DatabaseURL,
+		"LINE_CHANNEL_SECRET":        &cfg.Line.ChannelSecret,
+		"LINE_CHANNEL_ACCESS_TOKEN":  &cfg.Line.ChannelAccessToken,
+		"DISCORD_BOT_TOKEN":          &cfg.Discord.BotToken,
+		"INBOUND_EMAIL_SECRET":       &cfg.InboundEmail.Secret,
+	}
+}
+
+// loadSecrets 在設定了 SECRET_MANAGER_PREFIX 時，讀取名為 <prefix><變數名稱> 的 secret 的最新版本補上尚未設定的密鑰
+// 環境變數或設定檔已有值時以它們為準；secret 不存在時略過，但沒有權限等其他錯誤會讓程式無法啟動
+// secret 只在啟動時讀取一次，執行期間不會重新讀取
+func loadSecrets(ctx context.Context, cfg *Config) error {
+	prefix := cfg.SecretManagerPrefix
+	if prefix == "" {
+		return nil
+	}
+	if cfg.GCPProjectID == "" {
+		return fmt.Errorf("GCP_PROJECT_ID must be set to use SECRET_MANAGER_PREFIX")
+	}
+
+	client, err := secretmanager.NewClient(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create Secret Manager client: %v", err)
+	}
+	defer client.Close()
+
+	loaded := 0
+	for name, field := range secretFields(cfg) {
+		if *field != "" {
+			continue
+		}
+		secretName := fmt.Sprintf("projects/%s/secrets/%s%s/versions/latest", cfg.GCPProjectID, prefix, name)
+		resp, err := client.AccessSecretVersion(ctx, &secretmanagerpb.AccessSecretVersionRequest{Name: secretName})
+		if status.Code(err) == codes.NotFound {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to access secret %s%s: %v", prefix, name, err)
+		}
+		// 用 echo 建立的 secret 常會帶有結尾換行
+		*field = strings.TrimSpace(string(resp.Payload.Data))
+		loaded++
+	}
+	slog.Info("Loaded secrets from Secret Manager", "count", loaded)
+	return nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"golang.org/x/time/rate"
+)
+
+// --- 送出限速 ---
+
+// Telegram 建議全域每秒不超過 30 則、同一個聊天室每秒 1 則、同一個群組每分鐘 20 則
+// 短時間內允許少量突發，超過時呼叫端會排隊等待，而不是直接被 Telegram 以 429 拒絕
+var (
+	globalSendLimiter = rate.NewLimiter(30, 30)
+
+	chatSendLimitersMu sync.Mutex
+	chatSendLimiters   = map[int64]*chatSendLimiter{}
+)
+
+type chatSendLimiter struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+const (
+	// 遇到 429 時最多重試的次數
+	maxSendRetries = 3
+	// retry_after 超過這個時間就不再等待，避免 webhook 卡住太久
+	maxSendRetryWait = time.Minute
+	// 超過這段時間沒有送出訊息的聊天室會被移除，避免 map 無限成長
+	chatSendLimiterIdle = 10 * time.Minute
+)
+
+// chatLimiter 回傳聊天室的限速器；chat ID 為負數時是群組或頻道
+func chatLimiter(chatID int64) *rate.Limiter {
+	chatSendLimitersMu.Lock()
+	defer chatSendLimitersMu.Unlock()
+
+	now := time.Now()
+	l, ok := chatSendLimiters[chatID]
+	if !ok {
+		for id, other := range chatSendLimiters {
+			if now.Sub(other.lastUsed) > chatSendLimiterIdle {
+				delete(chatSendLimiters, id)
+			}
+		}
+		every := time.Second
+		if chatID < 0 {
+			every = time.Minute / 20
+		}
+		l = &chatSendLimiter{limiter: rate.NewLimiter(rate.Every(every), 3)}
+		chatSendLimiters[chatID] = l
+	}
+	l.lastUsed = now
+	return l.limiter
+}
+
+// callTelegram 等到全域與聊天室都有額度後才呼叫 call；Telegram 回應 429 時依 retry_after 等待後重試
+func callTelegram(ctx context.Context, chatID int64, call func() error) error {
+	for attempt := 0; ; attempt++ {
+		if err := globalSendLimiter.Wait(ctx); err != nil {
+			return err
+		}
+		if err := chatLimiter(chatID).Wait(ctx); err != nil {
+			return err
+		}
+
+		err := call()
+		var apiErr *tgbotapi.Error
+		if !errors.As(err, &apiErr) || apiErr.RetryAfter == 0 || attempt >= maxSendRetries {
+			return err
+		}
+		wait := time.Duration(apiErr.RetryAfter) * time.Second
+		if wait > maxSendRetryWait {
+			return err
+		}
+		slog.WarnContext(ctx, "Rate limited by Telegram, retrying", "retry_after", wait.String(), "attempt", attempt+1)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// sendChattable 以限速的方式送出訊息或編輯訊息
+func sendChattable(ctx context.Context, chatID int64, c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	var sent tgbotapi.Message
+	err := callTelegram(ctx, chatID, func() error {
+		var err error
+		sent, err = senderFor(ctx).Send(c)
+		return err
+	})
+	return sent, err
+}
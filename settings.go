@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Firestore 集合名稱
+const settingsCollection = "user_settings"
+
+// UserSettings 用來儲存在 Firestore 中的使用者偏好設定
+type UserSettings struct {
+	FilenameTemplate    string            `firestore:"filename_template"`
+	FolderTemplate      string            `firestore:"folder_template"`
+	Destination         string            `firestore:"destination"`           // 上傳目的地，空字串代表預設的 Google Drive
+	AskDriveAccount     bool              `firestore:"ask_drive_account"`     // 連結多個 Google 帳號時，每次上傳前詢問要用哪個帳號
+	AskDestination      bool              `firestore:"ask_destination"`       // 每次上傳前以按鈕選擇目的地與資料夾
+	PickFolders         map[string]string `firestore:"pick_folders"`          // 選擇目的地時額外列出的資料夾：名稱對應資料夾範本
+	PickDefault         string            `firestore:"pick_default"`          // 上次選擇的目的地，逾時未選擇時使用
+	NoteAuto            bool              `firestore:"note_auto"`             // 自動將私訊中的文字訊息存成筆記
+	SharingDisabled     bool              `firestore:"sharing_disabled"`      // 停用 /share 的公開分享
+	ConfirmUpload       bool              `firestore:"confirm_upload"`        // 上傳前先以按鈕確認
+	PhotoQualityHint    bool              `firestore:"photo_quality_hint"`    // 收到被壓縮的照片時，提醒改用檔案傳送原始畫質
+	PhotoSize           string            `firestore:"photo_size"`            // 壓縮照片要上傳的尺寸：largest、medium、smallest，空字串代表最大
+	SkipDuplicates      bool              `firestore:"skip_duplicates"`       // 略過之前已經上傳過的相同檔案
+	OCR                 bool              `firestore:"ocr"`                   // 辨識上傳圖片中的文字，需要管理者啟用 ENABLE_OCR
+	TranscribeVoice     bool              `firestore:"transcribe_voice"`      // 將語音訊息轉成文字，需要管理者啟用 ENABLE_TRANSCRIPTION
+	AutoCategorize      bool              `firestore:"auto_categorize"`       // 以 Gemini 分類檔案並上傳到對應的資料夾
+	CategoryFolders     map[string]string `firestore:"category_folders"`      // 類別對應的資料夾範本，未設定的類別使用預設資料夾
+	TagFolders          map[string]string `firestore:"tag_folders"`           // 說明文字中的 hashtag（小寫、不含 #）對應的資料夾範本
+	ConvertToGoogle     bool              `firestore:"convert_to_google"`     // 上傳到 Google Drive 時將 Office 與文字檔轉成 Google 文件格式
+	ImageMaxDimension   int               `firestore:"image_max_dimension"`   // 上傳前將圖片的長邊縮小到這個像素，0 代表不縮小
+	ImageQuality        int               `firestore:"image_quality"`         // 縮小後重新編碼的 JPEG 品質
+	ExifDate            bool              `firestore:"exif_date"`             // 照片以 EXIF 的拍攝時間取代訊息時間套用範本
+	ExifLocation        bool              `firestore:"exif_location"`         // 將照片 EXIF 中的拍攝地點寫入檔案描述
+	Language            string            `firestore:"language"`              // 回覆使用的語言，空字串代表跟隨 Telegram 用戶端
+	Digest              string            `firestore:"digest"`                // 定期寄送上傳摘要的週期：daily、weekly，空字串代表不寄送
+	WebhookURL          string            `firestore:"webhook_url"`           // 上傳完成或失敗時通知的網址
+	WebhookSecret       string            `firestore:"webhook_secret"`        // 簽署 webhook 請求的密鑰
+	LinkSitesDisabled   []string          `firestore:"link_sites_disabled"`   // 不從連結下載媒體的網站，例如 instagram、x
+	LinkSitesEnabled    []string          `firestore:"link_sites_enabled"`    // 開啟連結下載的預設關閉網站，例如 youtube
+	WebArchive          bool              `firestore:"web_archive"`           // 將私訊中只有網址的訊息存成 PDF，需要管理者設定 PDF_RENDERER_URL
+	MediaDestination    string            `firestore:"media_destination"`     // 照片與影片的上傳目的地，例如 photos，空字串代表與其他檔案相同
+	LedgerSpreadsheetID string            `firestore:"ledger_spreadsheet_id"` // 每次上傳後加上一列紀錄的 Google 試算表，空字串代表不記錄
+	LastUpload          *UploadMeta       `firestore:"last_upload"`
+	UpdatedAt           time.Time         `firestore:"updated_at"`
+}
+
+// ProcessingProfile 決定一次上傳要如何處理，可以來自使用者的個人設定或聊天室綁定
+type ProcessingProfile struct {
+	FilenameTemplate string `firestore:"filename_template"`
+	FolderTemplate   string `firestore:"folder_template"`
+	Silent           bool   `firestore:"silent"`         // 上傳成功時不回覆確認訊息
+	Convert          bool   `firestore:"convert"`        // 上傳到 Google Drive 時轉換成 Google 文件等原生格式
+	RetentionDays    int    `firestore:"retention_days"` // 上傳的檔案在幾天後移到目的地的垃圾桶，0 代表永久保留
+}
+
+// Profile 回傳使用者個人的預設處理方式
+func (s *UserSettings) Profile() ProcessingProfile {
+	return ProcessingProfile{
+		FilenameTemplate: s.FilenameTemplate,
+		FolderTemplate:   s.FolderTemplate,
+		Convert:          s.ConvertToGoogle,
+	}
+}
+
+// loadUserSettings 讀取使用者設定，尚未設定過時回傳預設值
+func loadUserSettings(ctx context.Context, userID int64) (*UserSettings, error) {
+	settings := &UserSettings{}
+	doc, err := collection(ctx, settingsCollection).Doc(fmt.Sprintf("%d", userID)).Get(ctx)
+	if err != nil {
+		if errors.Is(err, errDocNotFound) {
+			return settings, nil
+		}
+		return nil, err
+	}
+	if err := doc.DataTo(settings); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// updateUserSettings 只更新指定的欄位，其餘設定保持不變
+func updateUserSettings(ctx context.Context, userID int64, fields map[string]interface{}) error {
+	fields["updated_at"] = time.Now()
+	err := collection(ctx, settingsCollection).Doc(fmt.Sprintf("%d", userID)).Merge(ctx, fields)
+	return err
+}
+
+// 處理 /filename_template 指令
+func handleFilenameTemplate(ctx context.Context, message *tgbotapi.Message) {
+	handleTemplateCommand(ctx, message, filenameTemplate)
+}
+
+// 處理 /folder_template 指令
+func handleFolderTemplate(ctx context.Context, message *tgbotapi.Message) {
+	handleTemplateCommand(ctx, message, folderTemplate)
+}
+
+// handleTemplateCommand 處理範本的查詢、設定與重設，設定前會先驗證並顯示預覽
+func handleTemplateCommand(ctx context.Context, message *tgbotapi.Message, kind templateKind) {
+	userID := message.From.ID
+
+	command, field, label := "/filename_template", "filename_template", "檔名範本"
+	if kind == folderTemplate {
+		command, field, label = "/folder_template", "folder_template", "資料夾範本"
+	}
+
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的設定時發生錯誤，請稍後再試。")
+		return
+	}
+
+	current := settings.FilenameTemplate
+	if kind == folderTemplate {
+		current = settings.FolderTemplate
+	}
+
+	arg := strings.TrimSpace(message.CommandArguments())
+	switch arg {
+	case "":
+		if current == "" {
+			current = "（未設定）"
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf(
+			"目前的%s：%s\n\n設定方式：%s <範本>\n清除方式：%s reset\n\n可用的變數：\n%s",
+			label, current, command, command, placeholderHelp()))
+		return
+	case "reset":
+		if err := updateUserSettings(ctx, userID, map[string]interface{}{field: ""}); err != nil {
+			slog.ErrorContext(ctx, "Failed to reset template", "field", field, "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "儲存設定時發生錯誤，請稍後再試。")
+			return
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("已清除%s。", label))
+		return
+	}
+
+	if err := validateTemplate(arg, kind); err != nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("%s無效：%v\n\n範本未儲存，請修正後再試一次。", label, err))
+		return
+	}
+
+	if err := updateUserSettings(ctx, userID, map[string]interface{}{field: arg}); err != nil {
+		slog.ErrorContext(ctx, "Failed to save template", "field", field, "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "儲存設定時發生錯誤，請稍後再試。")
+		return
+	}
+
+	if kind == filenameTemplate {
+		settings.FilenameTemplate = arg
+	} else {
+		settings.FolderTemplate = arg
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("已儲存%s：%s\n\n%s", label, arg, templatePreview(settings)))
+}
+
+// templatePreview 以使用者最後一次上傳的檔案資訊渲染目前的範本
+func templatePreview(settings *UserSettings) string {
+	meta := settings.LastUpload
+	source := "以您最後一次上傳的檔案預覽"
+	if meta == nil {
+		meta = &UploadMeta{Name: "example", Ext: "pdf", Type: "document", Sender: "sender", Chat: "chat", Date: time.Now()}
+		source = "您尚未上傳過檔案，以範例檔案預覽"
+	}
+
+	fullPath := renderFileName(settings.FilenameTemplate, meta)
+	if folders := renderFolderPath(settings.FolderTemplate, meta); len(folders) > 0 {
+		fullPath = strings.Join(folders, "/") + "/" + fullPath
+	}
+	return fmt.Sprintf("%s：\n%s → My Drive/%s", source, joinExt(meta.Name, meta.Ext), fullPath)
+}
+
+// 處理 /destination 指令：查看或切換上傳目的地
+func handleDestination(ctx context.Context, message *tgbotapi.Message) {
+	userID := message.From.ID
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的設定時發生錯誤，請稍後再試。")
+		return
+	}
+
+	if configFor(ctx).ForceDestination != "" {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("此機器人的管理者已將所有上傳固定到 %s，無法切換。", userDestination(ctx, settings).DisplayName()))
+		return
+	}
+
+	arg := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+	if arg == "" {
+		current := userDestination(ctx, settings)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf(
+			"目前的上傳目的地：%s\n\n切換方式：/destination <%s>", current.DisplayName(), strings.Join(primaryDestinationNames(), "|")))
+		return
+	}
+
+	dest, ok := destinations[arg]
+	if _, media := dest.(mediaDestination); media {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("%s 只能存放照片與影片，請使用 /media_destination %s 指定照片與影片的目的地。", dest.DisplayName(), dest.Name()))
+		return
+	}
+	if !ok {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("不支援的目的地「%s」，可用的目的地：%s", arg, strings.Join(primaryDestinationNames(), "、")))
+		return
+	}
+	connected, err := dest.Connected(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to check destination connection", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的授權時發生錯誤，請稍後再試。")
+		return
+	}
+	if !connected {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("您尚未連結 %s，請先使用 %s 指令。", dest.DisplayName(), connectCommand(dest)))
+		return
+	}
+
+	if err := updateUserSettings(ctx, userID, map[string]interface{}{"destination": dest.Name()}); err != nil {
+		slog.ErrorContext(ctx, "Failed to save destination", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "儲存設定時發生錯誤，請稍後再試。")
+		return
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("已將上傳目的地切換為 %s。", dest.DisplayName()))
+}
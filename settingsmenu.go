@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- /settings 設定選單 ---
+
+// 可以在 /settings 中選擇的語言，依序切換；空字串代表跟隨 Telegram 用戶端
+var settingsLanguages = []string{"", "zh-TW", "en"}
+
+var languageLabels = map[string]string{
+	"":      "跟隨 Telegram",
+	"zh-TW": "繁體中文",
+	"en":    "English",
+}
+
+// 處理 /settings 指令：以內嵌按鈕顯示並切換所有個人設定
+func handleSettings(ctx context.Context, message *tgbotapi.Message) {
+	settings, err := loadUserSettings(ctx, message.From.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的設定時發生錯誤，請稍後再試。")
+		return
+	}
+	text, markup := settingsMenu(ctx, settings)
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyToMessageID = message.MessageID
+	msg.ReplyMarkup = markup
+	if _, err := sendMessage(ctx, msg); err != nil {
+		slog.ErrorContext(ctx, "Failed to send message", "error", err)
+	}
+}
+
+// settingsMenu 產生 /settings 的訊息內容與按鈕，按鈕上顯示目前的值，點選後切換
+func settingsMenu(ctx context.Context, settings *UserSettings) (string, tgbotapi.InlineKeyboardMarkup) {
+	onOff := func(on bool) string {
+		if on {
+			return "開"
+		}
+		return "關"
+	}
+	templateLabel := func(t string) string {
+		if t == "" {
+			return "（未設定）"
+		}
+		return t
+	}
+
+	var sb strings.Builder
+	sb.WriteString("您的設定：\n")
+	fmt.Fprintf(&sb, "• 上傳目的地：%s\n", userDestination(ctx, settings).DisplayName())
+	fmt.Fprintf(&sb, "• 檔名範本：%s\n", templateLabel(settings.FilenameTemplate))
+	fmt.Fprintf(&sb, "• 資料夾範本：%s\n", templateLabel(settings.FolderTemplate))
+	sb.WriteString("\n點選按鈕即可切換。")
+
+	resizeLabel := "關"
+	if settings.ImageMaxDimension > 0 {
+		resizeLabel = fmt.Sprintf("%d px", settings.ImageMaxDimension)
+	}
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if configFor(ctx).ForceDestination == "" && len(primaryDestinationNames()) > 1 {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"上傳目的地："+userDestination(ctx, settings).DisplayName(), encodeCallbackData("settings", "destination"))))
+	}
+	if configFor(ctx).ForceDestination == "" && len(mediaDestinationNames()) > 0 {
+		mediaLabel := "與其他檔案相同"
+		if d, ok := destinations[settings.MediaDestination].(mediaDestination); ok {
+			mediaLabel = d.DisplayName()
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"照片與影片："+mediaLabel, encodeCallbackData("settings", "media_destination"))))
+	}
+	rows = append(rows,
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"上傳前確認："+onOff(settings.ConfirmUpload), encodeCallbackData("settings", "confirm"))),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"每次選擇目的地："+onOff(settings.AskDestination), encodeCallbackData("settings", "pick_destination"))),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"略過重複的檔案："+onOff(settings.SkipDuplicates), encodeCallbackData("settings", "duplicates"))),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"原始畫質提醒："+onOff(settings.PhotoQualityHint), encodeCallbackData("settings", "photo_quality"))),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"照片尺寸："+photoSizeLabels[settings.PhotoSize], encodeCallbackData("settings", "photo_size"))),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"縮小大圖："+resizeLabel, encodeCallbackData("settings", "resize"))),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"依拍攝時間整理照片："+onOff(settings.ExifDate), encodeCallbackData("settings", "exif_date"))),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"寫入拍攝地點："+onOff(settings.ExifLocation), encodeCallbackData("settings", "exif_location"))),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"轉成 Google 文件格式："+onOff(settings.ConvertToGoogle), encodeCallbackData("settings", "convert"))),
+	)
+	// 需要付費的 AI 服務只有在管理者啟用時才顯示
+	if visionService != nil {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"辨識圖片文字："+onOff(settings.OCR), encodeCallbackData("settings", "ocr"))))
+	}
+	if geminiService != nil {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"AI 自動分類："+onOff(settings.AutoCategorize), encodeCallbackData("settings", "categorize"))))
+	}
+	if speechService != nil {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"語音轉文字："+onOff(settings.TranscribeVoice), encodeCallbackData("settings", "transcribe"))))
+	}
+	// 從連結下載媒體需要管理者設定 yt-dlp
+	if configFor(ctx).YtDlpPath != "" {
+		sites := availableLinkSites(ctx)
+		for i := range sites {
+			site := &sites[i]
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+				site.Label+" 連結下載："+onOff(linkSiteEnabled(settings, site)), encodeCallbackData("settings", "link_"+site.Name))))
+		}
+	}
+	if configFor(ctx).PDFRendererURL != "" {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"網址存成 PDF："+onOff(settings.WebArchive), encodeCallbackData("settings", "web_archive"))))
+	}
+	if configFor(ctx).CronSecret != "" {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"上傳摘要："+digestLabels[settings.Digest], encodeCallbackData("settings", "digest"))))
+	}
+	rows = append(rows,
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			"語言："+languageLabels[settings.Language], encodeCallbackData("settings", "language"))),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("設定檔名範本", encodeCallbackData("settings", "filename_template")),
+			tgbotapi.NewInlineKeyboardButtonData("設定資料夾範本", encodeCallbackData("settings", "folder_template")),
+		),
+	)
+	return sb.String(), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// handleSettingsCallback 處理 /settings 訊息上的按鈕
+func handleSettingsCallback(ctx context.Context, query *tgbotapi.CallbackQuery, args []string) {
+	userID := query.From.ID
+	if len(args) != 1 {
+		answerCallback(ctx, query, "")
+		return
+	}
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		answerCallback(ctx, query, "讀取您的設定時發生錯誤，請稍後再試。")
+		return
+	}
+
+	var fields map[string]interface{}
+	switch args[0] {
+	case "confirm":
+		settings.ConfirmUpload = !settings.ConfirmUpload
+		fields = map[string]interface{}{"confirm_upload": settings.ConfirmUpload}
+	case "pick_destination":
+		settings.AskDestination = !settings.AskDestination
+		fields = map[string]interface{}{"ask_destination": settings.AskDestination}
+	case "duplicates":
+		settings.SkipDuplicates = !settings.SkipDuplicates
+		fields = map[string]interface{}{"skip_duplicates": settings.SkipDuplicates}
+	case "photo_quality":
+		settings.PhotoQualityHint = !settings.PhotoQualityHint
+		fields = map[string]interface{}{"photo_quality_hint": settings.PhotoQualityHint}
+	case "photo_size":
+		settings.PhotoSize = nextPhotoSize(settings.PhotoSize)
+		fields = map[string]interface{}{"photo_size": settings.PhotoSize}
+	case "resize":
+		// 按鈕只切換開關，自訂尺寸與品質請使用 /resize
+		if settings.ImageMaxDimension > 0 {
+			settings.ImageMaxDimension = 0
+		} else {
+			settings.ImageMaxDimension, settings.ImageQuality = defaultImageMaxDimension, defaultImageQuality
+		}
+		fields = map[string]interface{}{"image_max_dimension": settings.ImageMaxDimension, "image_quality": settings.ImageQuality}
+	case "exif_date":
+		settings.ExifDate = !settings.ExifDate
+		fields = map[string]interface{}{"exif_date": settings.ExifDate}
+	case "exif_location":
+		settings.ExifLocation = !settings.ExifLocation
+		fields = map[string]interface{}{"exif_location": settings.ExifLocation}
+	case "convert":
+		settings.ConvertToGoogle = !settings.ConvertToGoogle
+		fields = map[string]interface{}{"convert_to_google": settings.ConvertToGoogle}
+	case "ocr":
+		settings.OCR = !settings.OCR
+		fields = map[string]interface{}{"ocr": settings.OCR}
+	case "transcribe":
+		settings.TranscribeVoice = !settings.TranscribeVoice
+		fields = map[string]interface{}{"transcribe_voice": settings.TranscribeVoice}
+	case "categorize":
+		settings.AutoCategorize = !settings.AutoCategorize
+		fields = map[string]interface{}{"auto_categorize": settings.AutoCategorize}
+	case "web_archive":
+		settings.WebArchive = !settings.WebArchive
+		fields = map[string]interface{}{"web_archive": settings.WebArchive}
+	case "language":
+		settings.Language = nextLanguage(settings.Language)
+		fields = map[string]interface{}{"language": settings.Language}
+	case "digest":
+		settings.Digest = nextDigest(settings.Digest)
+		fields = map[string]interface{}{"digest": settings.Digest}
+	case "destination":
+		dest, err := nextConnectedDestination(ctx, userID, userDestination(ctx, settings))
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to check destination connection", "error", err)
+			answerCallback(ctx, query, "讀取您的授權時發生錯誤，請稍後再試。")
+			return
+		}
+		if dest == nil {
+			answerCallback(ctx, query, "您只連結了一個目的地，請先使用 /help 中的 /connect_ 指令連結其他目的地。")
+			return
+		}
+		settings.Destination = dest.Name()
+		fields = map[string]interface{}{"destination": settings.Destination}
+	case "media_destination":
+		dest, err := nextMediaDestination(ctx, userID, settings.MediaDestination)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to check destination connection", "error", err)
+			answerCallback(ctx, query, "讀取您的授權時發生錯誤，請稍後再試。")
+			return
+		}
+		if dest == "" && settings.MediaDestination == "" {
+			answerCallback(ctx, query, "請先使用 /connect_photos 連結 Google Photos。")
+			return
+		}
+		settings.MediaDestination = dest
+		fields = map[string]interface{}{"media_destination": settings.MediaDestination}
+	case "filename_template", "folder_template":
+		if !query.Message.Chat.IsPrivate() {
+			answerCallback(ctx, query, "請在與機器人的私訊中設定範本。")
+			return
+		}
+		conv := &Conversation{Flow: "settings_template", Step: args[0], Data: map[string]string{}}
+		if err := saveConversation(ctx, userID, conv); err != nil {
+			slog.ErrorContext(ctx, "Failed to save conversation", "error", err)
+			answerCallback(ctx, query, "發生錯誤，請稍後再試。")
+			return
+		}
+		answerCallback(ctx, query, "")
+		label := "檔名範本"
+		if args[0] == "folder_template" {
+			label = "資料夾範本"
+		}
+		replyToUser(ctx, query.Message.Chat.ID, query.Message.MessageID, fmt.Sprintf("請輸入新的%s，輸入 reset 清除，隨時可以輸入 /cancel 取消。\n\n可用的變數：\n%s", label, placeholderHelp()))
+		return
+	default:
+		site := linkSiteByName(ctx, strings.TrimPrefix(args[0], "link_"))
+		if !strings.HasPrefix(args[0], "link_") || site == nil {
+			answerCallback(ctx, query, "")
+			return
+		}
+		// 預設開啟的網站記錄關閉的清單，預設關閉的網站記錄開啟的清單
+		list, field := &settings.LinkSitesDisabled, "link_sites_disabled"
+		if site.OptIn {
+			list, field = &settings.LinkSitesEnabled, "link_sites_enabled"
+		}
+		if i := slices.Index(*list, site.Name); i >= 0 {
+			*list = slices.Delete(*list, i, i+1)
+		} else {
+			*list = append(*list, site.Name)
+		}
+		fields = map[string]interface{}{field: *list}
+	}
+
+	if err := updateUserSettings(ctx, userID, fields); err != nil {
+		slog.ErrorContext(ctx, "Failed to update settings", "error", err)
+		answerCallback(ctx, query, "更新設定時發生錯誤，請稍後再試。")
+		return
+	}
+	answerCallback(ctx, query, "已更新設定")
+
+	// 更新原本的訊息，讓按鈕反映最新狀態
+	text, markup := settingsMenu(ctx, settings)
+	edit := tgbotapi.NewEditMessageTextAndMarkup(query.Message.Chat.ID, query.Message.MessageID, text, markup)
+	if _, err := sendChattable(ctx, query.Message.Chat.ID, edit); err != nil {
+		slog.WarnContext(ctx, "Failed to update settings message", "error", err)
+	}
+}
+
+// nextLanguage 回傳 settingsLanguages 中的下一個語言
+func nextLanguage(current string) string {
+	for i, lang := range settingsLanguages {
+		if lang == current {
+			return settingsLanguages[(i+1)%len(settingsLanguages)]
+		}
+	}
+	return settingsLanguages[0]
+}
+
+// nextConnectedDestination 依名稱順序找出下一個已連結的目的地，沒有其他已連結的目的地時回傳 nil
+func nextConnectedDestination(ctx context.Context, userID int64, current Destination) (Destination, error) {
+	names := primaryDestinationNames()
+	start := 0
+	for i, name := range names {
+		if name == current.Name() {
+			start = i
+		}
+	}
+	for i := 1; i < len(names); i++ {
+		dest := destinations[names[(start+i)%len(names)]]
+		connected, err := dest.Connected(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if connected {
+			return dest, nil
+		}
+	}
+	return nil, nil
+}
+
+// nextMediaDestination 依名稱順序找出下一個已連結、只接受照片與影片的目的地，最後一個之後回到空字串（與其他檔案相同）
+func nextMediaDestination(ctx context.Context, userID int64, current string) (string, error) {
+	names := mediaDestinationNames()
+	start := slices.Index(names, current) + 1
+	for _, name := range names[start:] {
+		connected, err := destinations[name].Connected(ctx, userID)
+		if err != nil {
+			return "", err
+		}
+		if connected {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+// continueSettingsTemplate 處理從 /settings 開始的範本設定，Step 是要設定的欄位
+func continueSettingsTemplate(ctx context.Context, message *tgbotapi.Message, conv *Conversation) {
+	kind, label := filenameTemplate, "檔名範本"
+	if conv.Step == "folder_template" {
+		kind, label = folderTemplate, "資料夾範本"
+	}
+	arg := strings.TrimSpace(message.Text)
+	if arg == "reset" {
+		arg = ""
+	} else if err := validateTemplate(arg, kind); err != nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("%s無效：%v\n\n請修正後再輸入一次，或輸入 /cancel 取消。", label, err))
+		return
+	}
+
+	if err := clearConversation(ctx, message.From.ID); err != nil {
+		slog.ErrorContext(ctx, "Failed to clear conversation", "error", err)
+	}
+	if err := updateUserSettings(ctx, message.From.ID, map[string]interface{}{conv.Step: arg}); err != nil {
+		slog.ErrorContext(ctx, "Failed to save template", "field", conv.Step, "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "儲存設定時發生錯誤，請稍後再試。")
+		return
+	}
+	if arg == "" {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("已清除%s。", label))
+		return
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("已儲存%s：%s\n\n輸入 /settings 可以查看所有設定。", label, arg))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// 處理 /share 指令：回覆已上傳的檔案或上傳確認訊息，產生任何人都能檢視的分享連結
+// /share off 可以完全停用公開分享，/share on 重新啟用
+func handleShare(ctx context.Context, message *tgbotapi.Message) {
+	userID := message.From.ID
+
+	if arg := strings.TrimSpace(message.CommandArguments()); arg != "" {
+		on, ok := parseOnOff(arg)
+		if !ok {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, shareUsage)
+			return
+		}
+		if err := updateUserSettings(ctx, userID, map[string]interface{}{"sharing_disabled": !on}); err != nil {
+			slog.ErrorContext(ctx, "Failed to update settings", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "更新設定時發生錯誤，請稍後再試。")
+			return
+		}
+		if on {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "已啟用公開分享。")
+		} else {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "已停用公開分享，/share 將不會再產生公開連結。")
+		}
+		return
+	}
+
+	if message.ReplyToMessage == nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, shareUsage)
+		return
+	}
+
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load user settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取設定時發生錯誤，請稍後再試。")
+		return
+	}
+	if settings.SharingDisabled {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "您已停用公開分享，可使用 /share on 重新啟用。")
+		return
+	}
+
+	record, err := findUploadRecord(ctx, message.Chat.ID, message.ReplyToMessage.MessageID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to find upload record", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取上傳紀錄時發生錯誤，請稍後再試。")
+		return
+	}
+	if record == nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "找不到這則訊息的上傳紀錄，請回覆您上傳的檔案或機器人的上傳確認訊息。")
+		return
+	}
+	if record.UserID != userID {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "只有上傳這個檔案的人可以分享它。")
+		return
+	}
+
+	dest, ok := destinations[record.Destination].(sharingDestination)
+	if !ok {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "這個檔案所在的目的地不支援產生分享連結。")
+		return
+	}
+	if record.Account != "" {
+		ctx = withGoogleAccount(ctx, record.Account)
+	}
+	link, err := dest.Share(ctx, userID, record.FileID)
+	if errors.Is(err, errNotConnected) {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "上傳這個檔案的帳號已不再連結，無法分享。")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to share file", "destination", record.Destination, "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "產生分享連結時發生錯誤，請稍後再試。")
+		return
+	}
+	slog.InfoContext(ctx, "Shared file", "destination", record.Destination, "file_id", record.FileID)
+	replyToUser(ctx, message.Chat.ID, message.MessageID, "任何知道連結的人都可以檢視「"+record.Name+"」：\n"+link)
+}
+
+const shareUsage = "用法：\n回覆已上傳的檔案或上傳確認訊息並輸入 /share - 產生分享連結\n/share off - 停用公開分享\n/share on - 啟用公開分享"
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 分割上傳 ---
+
+// splitPartSize 由 SPLIT_UPLOAD_MB 設定，超過這個大小的檔案會分割成多個部分上傳，0 代表不分割
+func (c *Config) splitPartSize() int64 {
+	return c.SplitUploadMB * 1024 * 1024
+}
+
+// splitPart 是分割上傳的一個部分
+type splitPart struct {
+	Name   string
+	Size   int64
+	SHA256 string
+}
+
+// partName 回傳第 i 個部分的檔名，例如 video.mp4.part1
+func partName(name string, i int) string {
+	return fmt.Sprintf("%s.part%d", name, i)
+}
+
+// uploadInParts 將檔案依序切成最多 partSize 位元組的部分上傳，最後上傳記錄各部分與合併方式的說明檔
+// 回傳說明檔的上傳結果與分割的數量；中途失敗時已上傳的部分會保留在目的地
+func uploadInParts(ctx context.Context, dest Destination, userID int64, file *UploadFile, partSize int64) (*UploadResult, int, error) {
+	src := bufio.NewReader(file.Body)
+	whole := sha256.New()
+	var parts []splitPart
+	for i := 1; ; i++ {
+		// 讀到結尾時停止，避免多上傳一個空的部分
+		if _, err := src.Peek(1); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+		h := sha256.New()
+		part := newLimitedReader(io.TeeReader(io.LimitReader(src, partSize), io.MultiWriter(whole, h)), partSize)
+		name := partName(file.Name, i)
+		if _, err := uploadTo(ctx, dest, userID, &UploadFile{
+			Name:     name,
+			Folders:  file.Folders,
+			Body:     part,
+			MimeType: "application/octet-stream",
+		}); err != nil {
+			return nil, 0, fmt.Errorf("failed to upload %s: %w", name, err)
+		}
+		parts = append(parts, splitPart{Name: name, Size: part.BytesRead(), SHA256: hexSum(h)})
+		slog.InfoContext(ctx, "Uploaded file part", "file_name", name, "size", part.BytesRead())
+	}
+
+	// 說明文字與來源寫在說明檔上，編輯說明文字時才找得到
+	manifest := &UploadFile{
+		Name:     file.Name + ".manifest.txt",
+		Folders:  file.Folders,
+		Body:     strings.NewReader(splitManifest(file.Name, parts, hexSum(whole))),
+		MimeType: "text/plain",
+		Caption:  file.Caption,
+		Origin:   file.Origin,
+		Source:   file.Source,
+	}
+	result, err := uploadTo(ctx, dest, userID, manifest)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to upload manifest: %w", err)
+	}
+	return result, len(parts), nil
+}
+
+func hexSum(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// splitManifest 產生說明檔的內容：原始檔案、各部分的大小與 SHA-256，以及合併的指令
+func splitManifest(name string, parts []splitPart, sum string) string {
+	var total int64
+	names := make([]string, len(parts))
+	for i, p := range parts {
+		total += p.Size
+		names[i] = p.Name
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "原始檔案：%s\n大小：%d 位元組（%s）\nSHA-256：%s\n\n", name, total, formatSize(total), sum)
+	fmt.Fprintf(&sb, "分割成 %d 個部分：\n", len(parts))
+	for _, p := range parts {
+		fmt.Fprintf(&sb, "%s\t%d\t%s\n", p.Name, p.Size, p.SHA256)
+	}
+	sb.WriteString("\n" + joinInstructions(name, names))
+	return sb.String()
+}
+
+// joinInstructions 回傳依序合併各部分的指令
+func joinInstructions(name string, parts []string) string {
+	quoted := make([]string, len(parts))
+	for i, p := range parts {
+		quoted[i] = `"` + p + `"`
+	}
+	return fmt.Sprintf("下載所有部分到同一個資料夾後依序合併：\nmacOS / Linux：cat %s > \"%s\"\nWindows：copy /b %s \"%s\"\n",
+		strings.Join(quoted, " "), name, strings.Join(quoted, "+"), name)
+}
+
+// 處理 /join 指令：說明如何合併分割上傳的檔案
+func handleJoin(ctx context.Context, message *tgbotapi.Message) {
+	text := "超過分割大小的檔案會以 <檔名>.part1、<檔名>.part2… 上傳，並附上 <檔名>.manifest.txt 記錄各部分的大小與 SHA-256。\n\n"
+	if configFor(ctx).splitPartSize() == 0 {
+		text = "此機器人沒有開啟分割上傳。\n\n"
+	}
+	name := strings.TrimSpace(message.CommandArguments())
+	if name == "" {
+		name = "video.mp4"
+		text += "以 video.mp4 分割成三個部分為例，"
+	}
+	text += joinInstructions(name, []string{partName(name, 1), partName(name, 2), partName(name, 3)}) +
+		"\n實際的部分數量請參考說明檔；合併後可以用 sha256sum 比對說明檔中的 SHA-256。"
+	replyToUser(ctx, message.Chat.ID, message.MessageID, text)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 加上星號 ---
+
+// 對上傳的檔案或確認訊息按下這些表情符號時，檔案會在 Drive 中加上星號
+var starEmojis = map[string]bool{"⭐": true, "🌟": true}
+
+// 處理 /star 指令：回覆上傳的檔案或確認訊息時將該檔案加上星號，否則為最近一次上傳的檔案；/star off 移除星號
+func handleStar(ctx context.Context, message *tgbotapi.Message) {
+	starred := true
+	if arg := strings.TrimSpace(message.CommandArguments()); arg != "" {
+		on, ok := parseOnOff(arg)
+		if !ok {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "用法：回覆已上傳的檔案並輸入 /star，或直接輸入 /star 標記最近一次上傳的檔案；/star off 移除星號。")
+			return
+		}
+		starred = on
+	}
+
+	var record *UploadRecord
+	var err error
+	if message.ReplyToMessage != nil {
+		record, err = findUploadRecord(ctx, message.Chat.ID, message.ReplyToMessage.MessageID)
+	} else {
+		record, err = latestUploadRecord(ctx, message.From.ID)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to find upload record", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取上傳紀錄時發生錯誤，請稍後再試。")
+		return
+	}
+	if record == nil || record.UserID != message.From.ID {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "找不到您上傳這個檔案的紀錄。")
+		return
+	}
+
+	if err := setUploadStarred(ctx, record, starred); err != nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, starErrorMessage(err))
+		return
+	}
+	if starred {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("已將「%s」加上星號。", record.Name))
+	} else {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("已移除「%s」的星號。", record.Name))
+	}
+}
+
+// latestUploadRecord 回傳使用者最近一次的上傳紀錄，沒有時回傳 nil
+func latestUploadRecord(ctx context.Context, userID int64) (*UploadRecord, error) {
+	docs, err := collection(ctx, uploadCollection).
+		Where("user_id", "==", userID).
+		OrderBy("created_at", Desc).
+		Limit(1).GetAll(ctx)
+	if err != nil || len(docs) == 0 {
+		return nil, err
+	}
+	var record UploadRecord
+	if err := docs[0].DataTo(&record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// errStarUnsupported 表示檔案所在的目的地不支援星號
+var errStarUnsupported = errors.New("destination does not support starring")
+
+// setUploadStarred 在目的地上加上或移除星號，並更新上傳紀錄
+func setUploadStarred(ctx context.Context, record *UploadRecord, starred bool) error {
+	dest, ok := destinations[record.Destination].(starDestination)
+	if !ok {
+		return errStarUnsupported
+	}
+	if record.Account != "" {
+		ctx = withGoogleAccount(ctx, record.Account)
+	}
+	if err := dest.SetStarred(ctx, record.UserID, record.FileID, starred); err != nil {
+		if !errors.Is(err, errNotConnected) {
+			slog.ErrorContext(ctx, "Failed to update starred state", "file_id", record.FileID, "starred", starred, "error", err)
+		}
+		return err
+	}
+	err := collection(ctx, uploadCollection).Doc(uploadRecordDocID(record.ChatID, record.MessageID)).Update(ctx, []Update{{Path: "starred", Value: starred}})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to update upload record", "error", err)
+	}
+	slog.InfoContext(ctx, "Updated starred state", "file_id", record.FileID, "starred", starred)
+	return nil
+}
+
+func starErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, errStarUnsupported):
+		return "這個檔案所在的目的地不支援星號。"
+	case errors.Is(err, errNotConnected):
+		return "上傳這個檔案的帳號已不再連結。"
+	}
+	return "更新檔案時發生錯誤，請稍後再試。"
+}
+
+// messageReaction 是 telegram-bot-api 尚未支援的 message_reaction 更新
+type messageReaction struct {
+	Chat        tgbotapi.Chat  `json:"chat"`
+	MessageID   int            `json:"message_id"`
+	User        *tgbotapi.User `json:"user"` // 以頻道身分匿名回應時為 nil
+	OldReaction []reactionType `json:"old_reaction"`
+	NewReaction []reactionType `json:"new_reaction"`
+}
+
+type reactionType struct {
+	Type  string `json:"type"`
+	Emoji string `json:"emoji"`
+}
+
+func hasStarReaction(reactions []reactionType) bool {
+	for _, r := range reactions {
+		if r.Type == "emoji" && starEmojis[r.Emoji] {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMessageReaction 從原始 update 取出表情符號回應，不是回應更新時回傳 nil
+func parseMessageReaction(body []byte) *messageReaction {
+	var raw struct {
+		MessageReaction *messageReaction `json:"message_reaction"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil
+	}
+	return raw.MessageReaction
+}
+
+// handleMessageReaction 在使用者對上傳的檔案或確認訊息按下或收回 ⭐ 時，同步 Drive 上的星號
+// 成功時不另外回覆，表情符號本身就是回饋；失敗時才回覆說明
+func handleMessageReaction(ctx context.Context, reaction *messageReaction) {
+	if reaction.User == nil || !isUserAllowed(ctx, reaction.User.ID) {
+		return
+	}
+	before, after := hasStarReaction(reaction.OldReaction), hasStarReaction(reaction.NewReaction)
+	if before == after {
+		return
+	}
+	ctx = withLogAttrs(ctx, slog.Int64("user_id", reaction.User.ID), slog.Int64("chat_id", reaction.Chat.ID))
+
+	record, err := findUploadRecord(ctx, reaction.Chat.ID, reaction.MessageID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to find upload record", "error", err)
+		return
+	}
+	if record == nil || record.UserID != reaction.User.ID {
+		return
+	}
+	if err := setUploadStarred(ctx, record, after); err != nil && !errors.Is(err, errStarUnsupported) {
+		replyToUser(ctx, reaction.Chat.ID, reaction.MessageID, starErrorMessage(err))
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Firestore 集合名稱
+const dailyStatsCollection = "daily_stats"
+
+// DailyStats 是全站每日（UTC）的上傳統計
+type DailyStats struct {
+	Date    string `firestore:"date"`
+	Uploads int64  `firestore:"uploads"`
+	Bytes   int64  `firestore:"bytes"`
+}
+
+// recordUploadStats 將一次成功的上傳累加到當日統計
+func recordUploadStats(ctx context.Context, size int64) error {
+	date := time.Now().UTC().Format("2006-01-02")
+	err := collection(ctx, dailyStatsCollection).Doc(date).Merge(ctx, map[string]interface{}{
+		"date":    date,
+		"uploads": increment(1),
+		"bytes":   increment(size),
+	})
+	return err
+}
+
+// loadDailyStats 讀取指定日期的統計，沒有紀錄時回傳零值
+func loadDailyStats(ctx context.Context, date string) (*DailyStats, error) {
+	stats := &DailyStats{Date: date}
+	doc, err := collection(ctx, dailyStatsCollection).Doc(date).Get(ctx)
+	if err != nil {
+		if errors.Is(err, errDocNotFound) {
+			return stats, nil
+		}
+		return nil, err
+	}
+	if err := doc.DataTo(stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// countDocuments 計算集合中的文件數，Firestore 使用聚合查詢，不需要讀取每份文件
+func countDocuments(ctx context.Context, name string) (int64, error) {
+	return collection(ctx, name).Count(ctx)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 貼圖 ---
+
+// 整個貼圖包存放的資料夾，每個貼圖包一個子資料夾
+const stickerSetFolder = "Telegram Stickers"
+
+// stickerFileName 產生包含貼圖包名稱的檔名，ext 需包含「.」
+func stickerFileName(s *tgbotapi.Sticker, ext string) string {
+	set := s.SetName
+	if set == "" {
+		set = "sticker"
+	}
+	return fmt.Sprintf("%s_%s%s", set, s.FileUniqueID, ext)
+}
+
+// guessStickerExt 在無法查詢檔案路徑時猜測貼圖格式；目前的 telegram-bot-api 版本沒有影片貼圖的欄位
+func guessStickerExt(s *tgbotapi.Sticker) string {
+	if s.IsAnimated {
+		return ".tgs"
+	}
+	return ".webp"
+}
+
+// stickerExt 從 Telegram 的檔案路徑判斷貼圖格式：靜態為 .webp、動態為 .tgs、影片為 .webm
+func stickerExt(ctx context.Context, s *tgbotapi.Sticker) string {
+	file, err := getTelegramFile(ctx, s.FileID)
+	if err == nil {
+		if ext := path.Ext(file.FilePath); ext != "" {
+			return ext
+		}
+	}
+	return guessStickerExt(s)
+}
+
+// 處理 /save_sticker_set 指令：將整個貼圖包存到「Telegram Stickers/<貼圖包名稱>」資料夾
+func handleSaveStickerSet(ctx context.Context, message *tgbotapi.Message) {
+	if message.From == nil {
+		return
+	}
+	name := strings.TrimSpace(message.CommandArguments())
+	if name == "" && message.ReplyToMessage != nil && message.ReplyToMessage.Sticker != nil {
+		name = message.ReplyToMessage.Sticker.SetName
+	}
+	// 也接受 https://t.me/addstickers/<名稱> 格式的連結
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "https://t.me/addstickers/"), "t.me/addstickers/")
+	if name == "" {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "用法：/save_sticker_set <貼圖包名稱或連結>，或回覆一張貼圖並輸入 /save_sticker_set")
+		return
+	}
+
+	set, err := botFor(ctx).GetStickerSet(tgbotapi.GetStickerSetConfig{Name: name})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to get sticker set", "set_name", name, "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "找不到這個貼圖包。")
+		return
+	}
+
+	userID := message.From.ID
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的設定時發生錯誤，請稍後再試。")
+		return
+	}
+	dest := userDestination(ctx, settings)
+	connected, err := dest.Connected(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to retrieve token", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的授權時發生錯誤，請稍後再試。")
+		return
+	}
+	if !connected {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("您的 %s 帳號尚未連結，請使用 %s 指令來重新連結。", dest.DisplayName(), connectCommand(dest)))
+		return
+	}
+
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("開始儲存貼圖包「%s」（%d 張）…", set.Title, len(set.Stickers)))
+
+	// 貼圖包可能有上百張貼圖，在背景上傳以免 webhook 逾時
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				reportPanic(ctx, r)
+			}
+		}()
+		saved, failed := 0, 0
+		var bytes int64
+		for i := range set.Stickers {
+			size, err := saveSticker(ctx, dest, userID, &set, i)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to save sticker", "set_name", set.Name, "index", i, "error", err)
+				failed++
+				continue
+			}
+			saved++
+			bytes += size
+		}
+		// 試運行時沒有實際上傳，不計入用量
+		if !dryRun(ctx) {
+			if err := recordUploadBytes(ctx, userID, bytes); err != nil {
+				slog.ErrorContext(ctx, "Failed to record upload bytes", "error", err)
+			}
+			if err := recordUploadStats(ctx, bytes); err != nil {
+				slog.ErrorContext(ctx, "Failed to record upload stats", "error", err)
+			}
+		}
+		slog.InfoContext(ctx, "Sticker set saved", "set_name", set.Name, "saved", saved, "failed", failed)
+		reply := fmt.Sprintf("貼圖包「%s」已存到您的 %s 的「%s/%s」資料夾：成功 %d 張", set.Title, dest.DisplayName(), stickerSetFolder, set.Title, saved)
+		if failed > 0 {
+			reply += fmt.Sprintf("，失敗 %d 張", failed)
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, dryRunReply(ctx, reply+"。"))
+	}()
+}
+
+// saveSticker 下載貼圖包中的第 index 張貼圖並上傳，回傳上傳的位元組數
+func saveSticker(ctx context.Context, dest Destination, userID int64, set *tgbotapi.StickerSet, index int) (int64, error) {
+	sticker := &set.Stickers[index]
+	file, err := getTelegramFile(ctx, sticker.FileID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get sticker file: %v", err)
+	}
+	ext := path.Ext(file.FilePath)
+	if ext == "" {
+		ext = guessStickerExt(sticker)
+	}
+
+	resp, err := openTelegramFile(ctx, file)
+	if err != nil {
+		return 0, fmt.Errorf("failed to download sticker: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body := newLimitedReader(resp.Body, configFor(ctx).maxFileSize())
+	// 依貼圖包中的順序編號
+	name := fmt.Sprintf("%s_%03d%s", set.Name, index+1, ext)
+	if _, err := uploadTo(ctx, dest, userID, &UploadFile{
+		Name:    name,
+		Folders: []string{stickerSetFolder, set.Title},
+		Body:    body,
+	}); err != nil {
+		return 0, err
+	}
+	return body.BytesRead(), nil
+}
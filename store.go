@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// --- 資料儲存 ---
+
+// Store 是保存權杖、設定與上傳紀錄等資料的後端，預設為 Firestore，STORAGE_BACKEND=postgres 時改用 PostgreSQL
+// 資料以集合與文件 ID 組織，欄位名稱沿用結構上的 firestore 標籤，各後端的讀寫語意與 Firestore 相同
+type Store interface {
+	// Get 讀取一份文件，不存在時回傳 errDocNotFound
+	Get(ctx context.Context, ref *DocRef) (*Document, error)
+	// Set 以 data 取代整份文件
+	Set(ctx context.Context, ref *DocRef, data interface{}) error
+	// Merge 只寫入 fields 中的欄位，巢狀的 map 會逐層合併，文件不存在時建立
+	Merge(ctx context.Context, ref *DocRef, fields map[string]interface{}) error
+	// Create 建立一份文件，已經存在時回傳 errDocExists
+	Create(ctx context.Context, ref *DocRef, data interface{}) error
+	// Update 更新以點分隔路徑指定的欄位，文件不存在時回傳 errDocNotFound
+	Update(ctx context.Context, ref *DocRef, updates []Update) error
+	Delete(ctx context.Context, ref *DocRef) error
+	// Query 回傳符合條件的文件，沒有欄位的文件不會符合任何條件，也不會出現在依該欄位排序的結果中
+	Query(ctx context.Context, q *Query) ([]*Document, error)
+	Count(ctx context.Context, collection string) (int64, error)
+	// RunTransaction 在交易中執行 fn，與其他交易衝突時會重新執行，fn 回傳錯誤時不寫入任何變更
+	RunTransaction(ctx context.Context, fn func(ctx context.Context, tx Tx) error) error
+	Close() error
+}
+
+// Tx 是交易中的讀寫，所有讀取都要在寫入之前
+type Tx interface {
+	Get(ref *DocRef) (*Document, error)
+	Set(ref *DocRef, data interface{}) error
+	Update(ref *DocRef, updates []Update) error
+	Delete(ref *DocRef) error
+}
+
+// store 是目前使用的儲存後端，由 initStore 設定
+var store Store
+
+var (
+	errDocNotFound = errors.New("document not found")
+	errDocExists   = errors.New("document already exists")
+)
+
+// 支援的儲存後端
+const (
+	storageFirestore = "firestore"
+	storagePostgres  = "postgres"
+	storageSQLite    = "sqlite"
+)
+
+// initStore 依 STORAGE_BACKEND 連線到儲存後端
+func initStore(ctx context.Context, cfg *Config) error {
+	switch cfg.StorageBackend {
+	case "", storageFirestore:
+		s, err := newFirestoreStore(ctx, cfg.GCPProjectID)
+		if err != nil {
+			return err
+		}
+		if firestoreEmulator() {
+			slog.Info("Using Firestore emulator", "host", os.Getenv("FIRESTORE_EMULATOR_HOST"))
+		}
+		store = s
+	case storagePostgres:
+		s, err := newPostgresStore(ctx, cfg.DatabaseURL)
+		if err != nil {
+			return err
+		}
+		slog.Info("Using PostgreSQL storage")
+		store = s
+	case storageSQLite:
+		s, err := newSQLiteStore(ctx, cfg.DatabaseURL)
+		if err != nil {
+			return err
+		}
+		slog.Info("Using SQLite storage", "path", cfg.DatabaseURL)
+		store = s
+	default:
+		return fmt.Errorf("unknown STORAGE_BACKEND %q", cfg.StorageBackend)
+	}
+	return nil
+}
+
+// CollectionRef 是一個集合，名稱已經加上機器人的命名空間
+type CollectionRef struct {
+	name string
+}
+
+// collection 回傳這個機器人命名空間中的集合
+func collection(ctx context.Context, name string) *CollectionRef {
+	return &CollectionRef{name: collectionName(ctx, name)}
+}
+
+// rawCollection 回傳名稱已經包含命名空間的集合
+func rawCollection(name string) *CollectionRef {
+	return &CollectionRef{name: name}
+}
+
+func (c *CollectionRef) Doc(id string) *DocRef {
+	return &DocRef{Collection: c.name, ID: id}
+}
+
+func (c *CollectionRef) query() *Query {
+	return &Query{Collection: c.name}
+}
+
+func (c *CollectionRef) Where(path, op string, value interface{}) *Query {
+	return c.query().Where(path, op, value)
+}
+
+func (c *CollectionRef) OrderBy(path string, dir Direction) *Query {
+	return c.query().OrderBy(path, dir)
+}
+
+func (c *CollectionRef) Limit(n int) *Query {
+	return c.query().Limit(n)
+}
+
+// GetAll 回傳集合中所有的文件
+func (c *CollectionRef) GetAll(ctx context.Context) ([]*Document, error) {
+	return c.query().GetAll(ctx)
+}
+
+// Count 回傳集合中的文件數
+func (c *CollectionRef) Count(ctx context.Context) (int64, error) {
+	return store.Count(ctx, c.name)
+}
+
+// DocRef 指向集合中的一份文件
+type DocRef struct {
+	Collection string
+	ID         string
+}
+
+func (r *DocRef) Get(ctx context.Context) (*Document, error) {
+	return store.Get(ctx, r)
+}
+
+func (r *DocRef) Set(ctx context.Context, data interface{}) error {
+	return store.Set(ctx, r, data)
+}
+
+func (r *DocRef) Merge(ctx context.Context, fields map[string]interface{}) error {
+	return store.Merge(ctx, r, fields)
+}
+
+func (r *DocRef) Create(ctx context.Context, data interface{}) error {
+	return store.Create(ctx, r, data)
+}
+
+func (r *DocRef) Update(ctx context.Context, updates []Update) error {
+	return store.Update(ctx, r, updates)
+}
+
+func (r *DocRef) Delete(ctx context.Context) error {
+	return store.Delete(ctx, r)
+}
+
+// Document 是讀取到的一份文件
+type Document struct {
+	Ref  *DocRef
+	data map[string]interface{}
+	// dataTo 由後端提供時用來解碼，例如 Firestore 沿用它自己的解碼方式
+	dataTo func(dst interface{}) error
+}
+
+// Data 回傳文件的所有欄位
+func (d *Document) Data() map[string]interface{} {
+	return d.data
+}
+
+// DataTo 將文件的欄位依 firestore 標籤解碼到 dst，dst 需為指向結構的指標
+func (d *Document) DataTo(dst interface{}) error {
+	if d.dataTo != nil {
+		return d.dataTo(dst)
+	}
+	return decodeDocument(d.data, dst)
+}
+
+// Update 設定一個以點分隔路徑指定的欄位，例如 meta.caption
+type Update struct {
+	Path  string
+	Value interface{}
+}
+
+// Direction 是排序的方向
+type Direction int
+
+const (
+	Asc Direction = iota
+	Desc
+)
+
+// Filter 是一個查詢條件，Op 為 ==、<、<=、> 或 >=
+type Filter struct {
+	Path  string
+	Op    string
+	Value interface{}
+}
+
+// Query 是對一個集合的查詢，每個方法都回傳新的查詢，不會改變原本的查詢
+type Query struct {
+	Collection string
+	Filters    []Filter
+	Order      string
+	Dir        Direction
+	Max        int // 0 代表不限制
+}
+
+func (q *Query) Where(path, op string, value interface{}) *Query {
+	c := *q
+	c.Filters = append(append([]Filter(nil), q.Filters...), Filter{Path: path, Op: op, Value: value})
+	return &c
+}
+
+func (q *Query) OrderBy(path string, dir Direction) *Query {
+	c := *q
+	c.Order, c.Dir = path, dir
+	return &c
+}
+
+func (q *Query) Limit(n int) *Query {
+	c := *q
+	c.Max = n
+	return &c
+}
+
+func (q *Query) GetAll(ctx context.Context) ([]*Document, error) {
+	return store.Query(ctx, q)
+}
+
+// --- 特殊的欄位值 ---
+
+type deleteFieldValue struct{}
+
+// deleteField 用在 Merge 與 Update 中，刪除該欄位
+var deleteField = deleteFieldValue{}
+
+type incrementValue struct {
+	n int64
+}
+
+// increment 用在 Merge 與 Update 中，將數值欄位加上 n，欄位不存在時視為 0
+func increment(n int64) interface{} {
+	return incrementValue{n: n}
+}
+
+type arrayUnionValue struct {
+	elems []interface{}
+}
+
+// arrayUnion 用在 Merge 與 Update 中，將陣列中還沒有的元素加到陣列最後
+func arrayUnion(elems ...interface{}) interface{} {
+	return arrayUnionValue{elems: elems}
+}
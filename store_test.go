@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type storeTestRecord struct {
+	UserID    int64             `firestore:"user_id"`
+	Name      string            `firestore:"name"`
+	Size      int64             `firestore:"size"`
+	Ratio     float64           `firestore:"ratio"`
+	Trashed   bool              `firestore:"trashed"`
+	Tags      []string          `firestore:"tags"`
+	Props     map[string]string `firestore:"props"`
+	Note      string            `firestore:"note,omitempty"`
+	CreatedAt time.Time         `firestore:"created_at"`
+}
+
+func TestMemoryStore(t *testing.T) {
+	runStoreTests(t, newMemoryStore(), "")
+}
+
+// runStoreTests 檢查 Store 的實作是否符合 Firestore 的語意，prefix 加在集合名稱前，讓共用的後端不會互相影響
+func runStoreTests(t *testing.T, s Store, prefix string) {
+	previous := store
+	store = s
+	t.Cleanup(func() { store = previous })
+	ctx := context.Background()
+	created := time.Date(2024, 5, 6, 7, 8, 9, 123000000, time.UTC)
+
+	t.Run("SetGet", func(t *testing.T) {
+		ref := rawCollection(prefix + "records").Doc("a")
+		want := storeTestRecord{
+			UserID:    42,
+			Name:      "report.pdf",
+			Size:      1 << 40,
+			Ratio:     0.5,
+			Trashed:   true,
+			Tags:      []string{"tax", "2024"},
+			Props:     map[string]string{"chat": "-100"},
+			CreatedAt: created,
+		}
+		if err := ref.Set(ctx, want); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		doc, err := ref.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		var got storeTestRecord
+		if err := doc.DataTo(&got); err != nil {
+			t.Fatalf("DataTo: %v", err)
+		}
+		if !got.CreatedAt.Equal(want.CreatedAt) {
+			t.Errorf("CreatedAt = %v, want %v", got.CreatedAt, want.CreatedAt)
+		}
+		got.CreatedAt = want.CreatedAt
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+		if _, ok := doc.Data()["note"]; ok {
+			t.Errorf("omitempty field note was stored")
+		}
+		if id := doc.Ref.ID; id != "a" {
+			t.Errorf("Ref.ID = %q, want a", id)
+		}
+	})
+
+	t.Run("GetMissing", func(t *testing.T) {
+		_, err := rawCollection(prefix + "records").Doc("missing").Get(ctx)
+		if !errors.Is(err, errDocNotFound) {
+			t.Errorf("Get = %v, want errDocNotFound", err)
+		}
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		ref := rawCollection(prefix + "created").Doc("once")
+		if err := ref.Create(ctx, map[string]interface{}{"n": 1}); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if err := ref.Create(ctx, map[string]interface{}{"n": 2}); !errors.Is(err, errDocExists) {
+			t.Errorf("second Create = %v, want errDocExists", err)
+		}
+	})
+
+	t.Run("Merge", func(t *testing.T) {
+		ref := rawCollection(prefix + "merged").Doc("u")
+		if err := ref.Merge(ctx, map[string]interface{}{
+			"uploads": increment(1),
+			"folders": map[string]interface{}{"tax": "Tax", "work": "Work"},
+		}); err != nil {
+			t.Fatalf("Merge: %v", err)
+		}
+		if err := ref.Merge(ctx, map[string]interface{}{
+			"uploads": increment(2),
+			"folders": map[string]interface{}{"tax": deleteField, "home": "Home"},
+		}); err != nil {
+			t.Fatalf("Merge: %v", err)
+		}
+		var got struct {
+			Uploads int64             `firestore:"uploads"`
+			Folders map[string]string `firestore:"folders"`
+		}
+		doc, err := ref.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if err := doc.DataTo(&got); err != nil {
+			t.Fatalf("DataTo: %v", err)
+		}
+		if got.Uploads != 3 {
+			t.Errorf("uploads = %d, want 3", got.Uploads)
+		}
+		if want := map[string]string{"work": "Work", "home": "Home"}; !reflect.DeepEqual(got.Folders, want) {
+			t.Errorf("folders = %v, want %v", got.Folders, want)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		ref := rawCollection(prefix + "updated").Doc("z")
+		if err := ref.Update(ctx, []Update{{Path: "name", Value: "x"}}); !errors.Is(err, errDocNotFound) {
+			t.Errorf("Update of a missing document = %v, want errDocNotFound", err)
+		}
+		if err := ref.Set(ctx, map[string]interface{}{"name": "a", "meta": map[string]interface{}{"caption": "old"}}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		err := ref.Update(ctx, []Update{
+			{Path: "meta.caption", Value: "new"},
+			{Path: "entries", Value: arrayUnion("one", "two")},
+			{Path: "name", Value: deleteField},
+		})
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		if err := ref.Update(ctx, []Update{{Path: "entries", Value: arrayUnion("two", "three")}}); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		var got struct {
+			Name    string   `firestore:"name"`
+			Entries []string `firestore:"entries"`
+			Meta    struct {
+				Caption string `firestore:"caption"`
+			} `firestore:"meta"`
+		}
+		doc, err := ref.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if err := doc.DataTo(&got); err != nil {
+			t.Fatalf("DataTo: %v", err)
+		}
+		if got.Name != "" || got.Meta.Caption != "new" || !reflect.DeepEqual(got.Entries, []string{"one", "two", "three"}) {
+			t.Errorf("got %+v", got)
+		}
+	})
+
+	t.Run("Query", func(t *testing.T) {
+		uploads := rawCollection(prefix + "uploads")
+		for i := 1; i <= 5; i++ {
+			data := map[string]interface{}{
+				"user_id":    int64(i % 2),
+				"size":       int64(i * 100),
+				"created_at": created.Add(time.Duration(i) * time.Hour),
+			}
+			if err := uploads.Doc(fmt.Sprintf("u%d", i)).Set(ctx, data); err != nil {
+				t.Fatalf("Set: %v", err)
+			}
+		}
+		// 沒有 created_at 的文件不會出現在依 created_at 的查詢與排序中
+		if err := uploads.Doc("u0").Set(ctx, map[string]interface{}{"user_id": int64(1)}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+
+		tests := []struct {
+			name  string
+			query *Query
+			want  []string
+		}{
+			{"equal", uploads.Where("user_id", "==", int64(1)).OrderBy("created_at", Asc), []string{"u1", "u3", "u5"}},
+			{"time range", uploads.Where("created_at", ">=", created.Add(2*time.Hour)).Where("created_at", "<", created.Add(4*time.Hour)).OrderBy("created_at", Asc), []string{"u2", "u3"}},
+			{"number range", uploads.Where("size", ">", 300).OrderBy("size", Asc), []string{"u4", "u5"}},
+			{"descending with limit", uploads.OrderBy("created_at", Desc).Limit(2), []string{"u5", "u4"}},
+			{"no match", uploads.Where("user_id", "==", int64(7)), nil},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				docs, err := tt.query.GetAll(ctx)
+				if err != nil {
+					t.Fatalf("GetAll: %v", err)
+				}
+				var got []string
+				for _, doc := range docs {
+					got = append(got, doc.Ref.ID)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("got %v, want %v", got, tt.want)
+				}
+			})
+		}
+
+		n, err := uploads.Count(ctx)
+		if err != nil {
+			t.Fatalf("Count: %v", err)
+		}
+		if n != 6 {
+			t.Errorf("Count = %d, want 6", n)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		ref := rawCollection(prefix + "deleted").Doc("d")
+		if err := ref.Set(ctx, map[string]interface{}{"n": 1}); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		if err := ref.Delete(ctx); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+		if _, err := ref.Get(ctx); !errors.Is(err, errDocNotFound) {
+			t.Errorf("Get after Delete = %v, want errDocNotFound", err)
+		}
+		// 刪除不存在的文件不是錯誤
+		if err := ref.Delete(ctx); err != nil {
+			t.Errorf("Delete of a missing document: %v", err)
+		}
+	})
+
+	t.Run("Transaction", func(t *testing.T) {
+		ref := rawCollection(prefix + "counters").Doc("c")
+		bump := func(fail error) error {
+			return store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+				var counter struct {
+					Count int64 `firestore:"count"`
+				}
+				doc, err := tx.Get(ref)
+				if err == nil {
+					if err := doc.DataTo(&counter); err != nil {
+						return err
+					}
+				} else if !errors.Is(err, errDocNotFound) {
+					return err
+				}
+				if err := tx.Set(ref, map[string]interface{}{"count": counter.Count + 1}); err != nil {
+					return err
+				}
+				return fail
+			})
+		}
+		if err := bump(nil); err != nil {
+			t.Fatalf("RunTransaction: %v", err)
+		}
+		errAbort := errors.New("abort")
+		if err := bump(errAbort); !errors.Is(err, errAbort) {
+			t.Fatalf("RunTransaction = %v, want errAbort", err)
+		}
+		doc, err := ref.Get(ctx)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if got := doc.Data()["count"]; got != int64(1) {
+			t.Errorf("count = %v, want 1 (the failed transaction must not write)", got)
+		}
+	})
+}
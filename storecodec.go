@@ -0,0 +1,418 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// --- 文件的編碼 ---
+
+// Firestore 以外的後端將文件編碼成只包含 nil、bool、int64、float64、string、[]byte、time.Time、
+// []interface{} 與 map[string]interface{} 的 map，欄位名稱與 Firestore 相同，取自 firestore 標籤
+
+// storeTimeFormat 是時間存成 JSON 時的格式，固定寬度的 UTC 時間可以直接以字串比較先後
+const storeTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// encodeDocument 將結構或 map 編碼成文件的欄位
+func encodeDocument(data interface{}) (map[string]interface{}, error) {
+	v, err := encodeValue(reflect.ValueOf(data))
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cannot store %T as a document", data)
+	}
+	return m, nil
+}
+
+func encodeValue(v reflect.Value) (interface{}, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+	switch x := v.Interface().(type) {
+	case time.Time:
+		return x.UTC(), nil
+	case []byte:
+		return x, nil
+	case deleteFieldValue, incrementValue, arrayUnionValue:
+		return x, nil
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return encodeValue(v.Elem())
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil, nil
+		}
+		out := make([]interface{}, v.Len())
+		for i := range out {
+			e, err := encodeValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			out[i] = e
+		}
+		return out, nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("unsupported map key type %s", v.Type().Key())
+		}
+		if v.IsNil() {
+			return nil, nil
+		}
+		out := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			e, err := encodeValue(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			out[iter.Key().String()] = e
+		}
+		return out, nil
+	case reflect.Struct:
+		out := map[string]interface{}{}
+		for _, f := range structFields(v.Type()) {
+			fv := v.Field(f.index)
+			if f.omitEmpty && fv.IsZero() {
+				continue
+			}
+			e, err := encodeValue(fv)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f.name, err)
+			}
+			out[f.name] = e
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", v.Type())
+}
+
+type storeField struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+// structFields 依 firestore 標籤列出結構中要儲存的欄位，沒有標籤時使用欄位名稱
+func structFields(t reflect.Type) []storeField {
+	var fields []storeField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("firestore"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, storeField{name: name, index: i, omitEmpty: opts == "omitempty"})
+	}
+	return fields
+}
+
+// decodeDocument 將文件的欄位解碼到 dst，文件中沒有的欄位維持原本的值
+func decodeDocument(data map[string]interface{}, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return fmt.Errorf("DataTo needs a non-nil pointer, got %T", dst)
+	}
+	return decodeValue(data, v.Elem())
+}
+
+func decodeValue(src interface{}, dst reflect.Value) error {
+	if src == nil {
+		dst.SetZero()
+		return nil
+	}
+	if dst.Type() == timeType {
+		switch s := src.(type) {
+		case time.Time:
+			dst.Set(reflect.ValueOf(s))
+			return nil
+		case string:
+			t, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return err
+			}
+			dst.Set(reflect.ValueOf(t))
+			return nil
+		}
+		return fmt.Errorf("cannot decode %T into time.Time", src)
+	}
+
+	switch dst.Kind() {
+	case reflect.Pointer:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return decodeValue(src, dst.Elem())
+	case reflect.Interface:
+		dst.Set(reflect.ValueOf(src))
+		return nil
+	case reflect.Bool:
+		b, ok := src.(bool)
+		if !ok {
+			return fmt.Errorf("cannot decode %T into bool", src)
+		}
+		dst.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch n := src.(type) {
+		case int64:
+			dst.SetInt(n)
+		case float64:
+			dst.SetInt(int64(n))
+		default:
+			return fmt.Errorf("cannot decode %T into %s", src, dst.Type())
+		}
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := src.(int64)
+		if !ok {
+			return fmt.Errorf("cannot decode %T into %s", src, dst.Type())
+		}
+		dst.SetUint(uint64(n))
+		return nil
+	case reflect.Float32, reflect.Float64:
+		switch n := src.(type) {
+		case int64:
+			dst.SetFloat(float64(n))
+		case float64:
+			dst.SetFloat(n)
+		default:
+			return fmt.Errorf("cannot decode %T into %s", src, dst.Type())
+		}
+		return nil
+	case reflect.String:
+		s, ok := src.(string)
+		if !ok {
+			return fmt.Errorf("cannot decode %T into string", src)
+		}
+		dst.SetString(s)
+		return nil
+	case reflect.Slice:
+		if dst.Type().Elem().Kind() == reflect.Uint8 {
+			switch b := src.(type) {
+			case []byte:
+				dst.SetBytes(b)
+				return nil
+			case string:
+				data, err := base64.StdEncoding.DecodeString(b)
+				if err != nil {
+					return err
+				}
+				dst.SetBytes(data)
+				return nil
+			}
+		}
+		items, ok := src.([]interface{})
+		if !ok {
+			return fmt.Errorf("cannot decode %T into %s", src, dst.Type())
+		}
+		out := reflect.MakeSlice(dst.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := decodeValue(item, out.Index(i)); err != nil {
+				return err
+			}
+		}
+		dst.Set(out)
+		return nil
+	case reflect.Map:
+		m, ok := src.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("cannot decode %T into %s", src, dst.Type())
+		}
+		out := reflect.MakeMapWithSize(dst.Type(), len(m))
+		for k, item := range m {
+			e := reflect.New(dst.Type().Elem()).Elem()
+			if err := decodeValue(item, e); err != nil {
+				return fmt.Errorf("%s: %w", k, err)
+			}
+			out.SetMapIndex(reflect.ValueOf(k).Convert(dst.Type().Key()), e)
+		}
+		dst.Set(out)
+		return nil
+	case reflect.Struct:
+		m, ok := src.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("cannot decode %T into %s", src, dst.Type())
+		}
+		for _, f := range structFields(dst.Type()) {
+			item, ok := m[f.name]
+			if !ok {
+				continue
+			}
+			if err := decodeValue(item, dst.Field(f.index)); err != nil {
+				return fmt.Errorf("%s: %w", f.name, err)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported type %s", dst.Type())
+}
+
+// applyMerge 將 fields 合併到文件：巢狀的 map 逐層合併，其他值取代原本的欄位
+func applyMerge(doc, fields map[string]interface{}) error {
+	for k, v := range fields {
+		if sub, ok := v.(map[string]interface{}); ok {
+			existing, _ := doc[k].(map[string]interface{})
+			if existing == nil {
+				existing = map[string]interface{}{}
+			}
+			if err := applyMerge(existing, sub); err != nil {
+				return err
+			}
+			doc[k] = existing
+			continue
+		}
+		if err := applyField(doc, k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyUpdates 依點分隔的路徑更新文件的欄位，路徑上缺少的 map 會自動建立
+func applyUpdates(doc map[string]interface{}, updates []Update) error {
+	for _, u := range updates {
+		parts := strings.Split(u.Path, ".")
+		m := doc
+		for _, p := range parts[:len(parts)-1] {
+			next, ok := m[p].(map[string]interface{})
+			if !ok {
+				next = map[string]interface{}{}
+				m[p] = next
+			}
+			m = next
+		}
+		if err := applyField(m, parts[len(parts)-1], u.Value); err != nil {
+			return fmt.Errorf("%s: %w", u.Path, err)
+		}
+	}
+	return nil
+}
+
+// applyField 寫入一個欄位，處理 deleteField、increment 與 arrayUnion
+func applyField(m map[string]interface{}, key string, value interface{}) error {
+	switch x := value.(type) {
+	case deleteFieldValue:
+		delete(m, key)
+	case incrementValue:
+		switch n := m[key].(type) {
+		case float64:
+			m[key] = n + float64(x.n)
+		case int64:
+			m[key] = n + x.n
+		default:
+			m[key] = x.n
+		}
+	case arrayUnionValue:
+		items, _ := m[key].([]interface{})
+		for _, elem := range x.elems {
+			e, err := encodeValue(reflect.ValueOf(elem))
+			if err != nil {
+				return err
+			}
+			found := false
+			for _, item := range items {
+				if reflect.DeepEqual(item, e) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				items = append(items, e)
+			}
+		}
+		m[key] = items
+	default:
+		e, err := encodeValue(reflect.ValueOf(value))
+		if err != nil {
+			return err
+		}
+		m[key] = e
+	}
+	return nil
+}
+
+// marshalStoreJSON 將文件編碼成 JSON，時間以 storeTimeFormat 表示
+func marshalStoreJSON(doc map[string]interface{}) ([]byte, error) {
+	return json.Marshal(jsonValue(doc))
+}
+
+func jsonValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case time.Time:
+		return x.UTC().Format(storeTimeFormat)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			out[k] = jsonValue(e)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, e := range x {
+			out[i] = jsonValue(e)
+		}
+		return out
+	}
+	return v
+}
+
+// unmarshalStoreJSON 解碼 marshalStoreJSON 產生的 JSON，整數還原成 int64；時間維持字串，解碼到 time.Time 欄位時再解析
+func unmarshalStoreJSON(data []byte) (map[string]interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(string(data)))
+	dec.UseNumber()
+	var doc map[string]interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return fromJSONValue(doc).(map[string]interface{}), nil
+}
+
+func fromJSONValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case json.Number:
+		if n, err := x.Int64(); err == nil {
+			return n
+		}
+		f, _ := x.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range x {
+			x[k] = fromJSONValue(e)
+		}
+		return x
+	case []interface{}:
+		for i, e := range x {
+			x[i] = fromJSONValue(e)
+		}
+		return x
+	}
+	return v
+}
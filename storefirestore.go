@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// --- Firestore 儲存 ---
+
+// firestoreStore 將資料存放在 Firestore，是預設的儲存後端
+type firestoreStore struct {
+	client *firestore.Client
+}
+
+func newFirestoreStore(ctx context.Context, projectID string) (*firestoreStore, error) {
+	client, err := firestore.NewClient(ctx, projectID,
+		option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(breakerUnaryInterceptor(firestoreBreaker))),
+		option.WithGRPCDialOption(grpc.WithChainStreamInterceptor(breakerStreamInterceptor(firestoreBreaker))))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create firestore client: %v", err)
+	}
+	return &firestoreStore{client: client}, nil
+}
+
+func (s *firestoreStore) doc(ref *DocRef) *firestore.DocumentRef {
+	return s.client.Collection(ref.Collection).Doc(ref.ID)
+}
+
+// firestoreError 將 Firestore 的 NotFound 與 AlreadyExists 轉成 errDocNotFound 與 errDocExists
+func firestoreError(err error) error {
+	switch status.Code(err) {
+	case codes.NotFound:
+		return fmt.Errorf("%w: %v", errDocNotFound, err)
+	case codes.AlreadyExists:
+		return fmt.Errorf("%w: %v", errDocExists, err)
+	}
+	return err
+}
+
+func firestoreDocument(ref *DocRef, snap *firestore.DocumentSnapshot) *Document {
+	return &Document{Ref: ref, data: snap.Data(), dataTo: snap.DataTo}
+}
+
+// firestoreValue 將 map 與陣列中的 deleteField、increment 與 arrayUnion 換成 Firestore 的對應值；結構交給 Firestore 編碼
+func firestoreValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case deleteFieldValue:
+		return firestore.Delete
+	case incrementValue:
+		return firestore.Increment(x.n)
+	case arrayUnionValue:
+		return firestore.ArrayUnion(x.elems...)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			out[k] = firestoreValue(e)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, e := range x {
+			out[i] = firestoreValue(e)
+		}
+		return out
+	}
+	return v
+}
+
+func firestoreUpdates(updates []Update) []firestore.Update {
+	out := make([]firestore.Update, len(updates))
+	for i, u := range updates {
+		out[i] = firestore.Update{Path: u.Path, Value: firestoreValue(u.Value)}
+	}
+	return out
+}
+
+func (s *firestoreStore) Get(ctx context.Context, ref *DocRef) (*Document, error) {
+	snap, err := s.doc(ref).Get(ctx)
+	if err != nil {
+		return nil, firestoreError(err)
+	}
+	return firestoreDocument(ref, snap), nil
+}
+
+func (s *firestoreStore) Set(ctx context.Context, ref *DocRef, data interface{}) error {
+	_, err := s.doc(ref).Set(ctx, firestoreValue(data))
+	return err
+}
+
+func (s *firestoreStore) Merge(ctx context.Context, ref *DocRef, fields map[string]interface{}) error {
+	_, err := s.doc(ref).Set(ctx, firestoreValue(fields), firestore.MergeAll)
+	return err
+}
+
+func (s *firestoreStore) Create(ctx context.Context, ref *DocRef, data interface{}) error {
+	_, err := s.doc(ref).Create(ctx, firestoreValue(data))
+	return firestoreError(err)
+}
+
+func (s *firestoreStore) Update(ctx context.Context, ref *DocRef, updates []Update) error {
+	_, err := s.doc(ref).Update(ctx, firestoreUpdates(updates))
+	return firestoreError(err)
+}
+
+func (s *firestoreStore) Delete(ctx context.Context, ref *DocRef) error {
+	_, err := s.doc(ref).Delete(ctx)
+	return err
+}
+
+func (s *firestoreStore) Query(ctx context.Context, q *Query) ([]*Document, error) {
+	fq := s.client.Collection(q.Collection).Query
+	for _, f := range q.Filters {
+		fq = fq.Where(f.Path, f.Op, f.Value)
+	}
+	if q.Order != "" {
+		dir := firestore.Asc
+		if q.Dir == Desc {
+			dir = firestore.Desc
+		}
+		fq = fq.OrderBy(q.Order, dir)
+	}
+	if q.Max > 0 {
+		fq = fq.Limit(q.Max)
+	}
+	snaps, err := fq.Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	docs := make([]*Document, len(snaps))
+	for i, snap := range snaps {
+		docs[i] = firestoreDocument(&DocRef{Collection: q.Collection, ID: snap.Ref.ID}, snap)
+	}
+	return docs, nil
+}
+
+func (s *firestoreStore) Count(ctx context.Context, collection string) (int64, error) {
+	result, err := s.client.Collection(collection).NewAggregationQuery().WithCount("count").Get(ctx)
+	if err != nil {
+		return 0, err
+	}
+	value, ok := result["count"].(*firestorepb.Value)
+	if !ok {
+		return 0, fmt.Errorf("unexpected count result type %T", result["count"])
+	}
+	return value.GetIntegerValue(), nil
+}
+
+func (s *firestoreStore) RunTransaction(ctx context.Context, fn func(ctx context.Context, tx Tx) error) error {
+	return s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		return fn(ctx, &firestoreTx{store: s, tx: tx})
+	})
+}
+
+func (s *firestoreStore) Close() error {
+	return s.client.Close()
+}
+
+type firestoreTx struct {
+	store *firestoreStore
+	tx    *firestore.Transaction
+}
+
+func (t *firestoreTx) Get(ref *DocRef) (*Document, error) {
+	snap, err := t.tx.Get(t.store.doc(ref))
+	if err != nil {
+		return nil, firestoreError(err)
+	}
+	return firestoreDocument(ref, snap), nil
+}
+
+func (t *firestoreTx) Set(ref *DocRef, data interface{}) error {
+	return t.tx.Set(t.store.doc(ref), firestoreValue(data))
+}
+
+func (t *firestoreTx) Update(ref *DocRef, updates []Update) error {
+	return t.tx.Update(t.store.doc(ref), firestoreUpdates(updates))
+}
+
+func (t *firestoreTx) Delete(ref *DocRef) error {
+	return t.tx.Delete(t.store.doc(ref))
+}
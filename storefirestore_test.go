@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// 整合測試使用的 Firestore 專案 ID，模擬器接受任意值
+const emulatorProjectID = "demo-tg-helper-test"
+
+// requireFirestoreEmulator 在沒有設定 FIRESTORE_EMULATOR_HOST 時略過測試，避免連線到真正的 Firestore
+func requireFirestoreEmulator(t *testing.T) {
+	t.Helper()
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("FIRESTORE_EMULATOR_HOST is not set; start the emulator with gcloud emulators firestore start")
+	}
+}
+
+// newEmulatorStore 連線到 Firestore 模擬器，測試結束時關閉連線
+func newEmulatorStore(t *testing.T) *firestoreStore {
+	t.Helper()
+	requireFirestoreEmulator(t)
+	s, err := newFirestoreStore(context.Background(), emulatorProjectID)
+	if err != nil {
+		t.Fatalf("newFirestoreStore: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// testNamespace 產生這次測試專用的集合名稱前綴，重複執行時不會讀到上一次留在模擬器中的資料
+func testNamespace() string {
+	return fmt.Sprintf("test%d_", time.Now().UnixNano())
+}
+
+func TestFirestoreStore(t *testing.T) {
+	runStoreTests(t, newEmulatorStore(t), testNamespace())
+}
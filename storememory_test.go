@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// --- 記憶體中的儲存 ---
+
+// memoryStore 是測試用的 Store，文件以 encodeDocument 編碼後存放在 map 中，查詢與交易的語意與 Firestore 相同
+// 交易依序執行，交易中的寫入在 fn 成功後才一次套用
+type memoryStore struct {
+	mu   sync.Mutex
+	docs map[string]map[string]map[string]interface{} // 集合 → 文件 ID → 欄位
+
+	txMu sync.Mutex
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{docs: map[string]map[string]map[string]interface{}{}}
+}
+
+// useMemoryStore 讓測試期間的 store 改用一個空的 memoryStore，測試結束後恢復
+func useMemoryStore(t *testing.T) *memoryStore {
+	t.Helper()
+	s := newMemoryStore()
+	previous := store
+	store = s
+	t.Cleanup(func() { store = previous })
+	return s
+}
+
+func (s *memoryStore) get(ref *DocRef) (map[string]interface{}, bool) {
+	doc, ok := s.docs[ref.Collection][ref.ID]
+	return doc, ok
+}
+
+func (s *memoryStore) put(ref *DocRef, doc map[string]interface{}) {
+	if s.docs[ref.Collection] == nil {
+		s.docs[ref.Collection] = map[string]map[string]interface{}{}
+	}
+	s.docs[ref.Collection][ref.ID] = doc
+}
+
+func (s *memoryStore) Get(ctx context.Context, ref *DocRef) (*Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc, ok := s.get(ref)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s/%s", errDocNotFound, ref.Collection, ref.ID)
+	}
+	return &Document{Ref: ref, data: copyValue(doc).(map[string]interface{})}, nil
+}
+
+func (s *memoryStore) Set(ctx context.Context, ref *DocRef, data interface{}) error {
+	doc, err := encodeDocument(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(ref, doc)
+	return nil
+}
+
+func (s *memoryStore) Merge(ctx context.Context, ref *DocRef, fields map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc := map[string]interface{}{}
+	if existing, ok := s.get(ref); ok {
+		doc = copyValue(existing).(map[string]interface{})
+	}
+	if err := applyMerge(doc, fields); err != nil {
+		return err
+	}
+	s.put(ref, doc)
+	return nil
+}
+
+func (s *memoryStore) Create(ctx context.Context, ref *DocRef, data interface{}) error {
+	doc, err := encodeDocument(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.get(ref); ok {
+		return fmt.Errorf("%w: %s/%s", errDocExists, ref.Collection, ref.ID)
+	}
+	s.put(ref, doc)
+	return nil
+}
+
+func (s *memoryStore) Update(ctx context.Context, ref *DocRef, updates []Update) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.update(ref, updates)
+}
+
+func (s *memoryStore) update(ref *DocRef, updates []Update) error {
+	existing, ok := s.get(ref)
+	if !ok {
+		return fmt.Errorf("%w: %s/%s", errDocNotFound, ref.Collection, ref.ID)
+	}
+	doc := copyValue(existing).(map[string]interface{})
+	if err := applyUpdates(doc, updates); err != nil {
+		return err
+	}
+	s.put(ref, doc)
+	return nil
+}
+
+func (s *memoryStore) Delete(ctx context.Context, ref *DocRef) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.docs[ref.Collection], ref.ID)
+	return nil
+}
+
+func (s *memoryStore) Query(ctx context.Context, q *Query) ([]*Document, error) {
+	filters := make([]Filter, len(q.Filters))
+	for i, f := range q.Filters {
+		v, err := encodeFieldValue(f.Value)
+		if err != nil {
+			return nil, err
+		}
+		filters[i] = Filter{Path: f.Path, Op: f.Op, Value: v}
+	}
+
+	s.mu.Lock()
+	var docs []*Document
+	for id, doc := range s.docs[q.Collection] {
+		if matchesFilters(doc, filters) && (q.Order == "" || fieldExists(doc, q.Order)) {
+			docs = append(docs, &Document{Ref: &DocRef{Collection: q.Collection, ID: id}, data: copyValue(doc).(map[string]interface{})})
+		}
+	}
+	s.mu.Unlock()
+
+	// 沒有指定排序時 Firestore 依文件 ID 排序
+	slices.SortFunc(docs, func(a, b *Document) int {
+		if q.Order != "" {
+			av, _ := lookupField(a.data, q.Order)
+			bv, _ := lookupField(b.data, q.Order)
+			c, _ := compareValues(av, bv)
+			if q.Dir == Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return strings.Compare(a.Ref.ID, b.Ref.ID)
+	})
+	if q.Max > 0 && len(docs) > q.Max {
+		docs = docs[:q.Max]
+	}
+	return docs, nil
+}
+
+func (s *memoryStore) Count(ctx context.Context, collection string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.docs[collection])), nil
+}
+
+func (s *memoryStore) RunTransaction(ctx context.Context, fn func(ctx context.Context, tx Tx) error) error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+	tx := &memoryTx{store: s}
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, write := range tx.writes {
+		if err := write(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+// memoryTx 記錄交易中的寫入，在 RunTransaction 提交時依序套用
+type memoryTx struct {
+	store  *memoryStore
+	writes []func() error
+}
+
+func (t *memoryTx) Get(ref *DocRef) (*Document, error) {
+	if len(t.writes) > 0 {
+		return nil, fmt.Errorf("read after write in transaction: %s/%s", ref.Collection, ref.ID)
+	}
+	return t.store.Get(context.Background(), ref)
+}
+
+func (t *memoryTx) Set(ref *DocRef, data interface{}) error {
+	doc, err := encodeDocument(data)
+	if err != nil {
+		return err
+	}
+	t.writes = append(t.writes, func() error {
+		t.store.put(ref, doc)
+		return nil
+	})
+	return nil
+}
+
+func (t *memoryTx) Update(ref *DocRef, updates []Update) error {
+	t.writes = append(t.writes, func() error {
+		return t.store.update(ref, updates)
+	})
+	return nil
+}
+
+func (t *memoryTx) Delete(ref *DocRef) error {
+	t.writes = append(t.writes, func() error {
+		delete(t.store.docs[ref.Collection], ref.ID)
+		return nil
+	})
+	return nil
+}
+
+// encodeFieldValue 將查詢條件的值編碼成與文件欄位相同的型別
+func encodeFieldValue(v interface{}) (interface{}, error) {
+	doc, err := encodeDocument(map[string]interface{}{"v": v})
+	if err != nil {
+		return nil, err
+	}
+	return doc["v"], nil
+}
+
+// lookupField 依點分隔的路徑讀取欄位
+func lookupField(doc map[string]interface{}, path string) (interface{}, bool) {
+	var v interface{} = doc
+	for _, p := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[p]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func fieldExists(doc map[string]interface{}, path string) bool {
+	_, ok := lookupField(doc, path)
+	return ok
+}
+
+func matchesFilters(doc map[string]interface{}, filters []Filter) bool {
+	for _, f := range filters {
+		v, ok := lookupField(doc, f.Path)
+		if !ok {
+			return false
+		}
+		c, ok := compareValues(v, f.Value)
+		if !ok {
+			return false
+		}
+		switch f.Op {
+		case "==":
+			ok = c == 0
+		case "<":
+			ok = c < 0
+		case "<=":
+			ok = c <= 0
+		case ">":
+			ok = c > 0
+		case ">=":
+			ok = c >= 0
+		default:
+			ok = false
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// compareValues 比較兩個同類型的欄位值，整數與浮點數視為同一類；類型不同時回傳 false
+func compareValues(a, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			return cmp.Compare(x, y), true
+		case float64:
+			return cmp.Compare(float64(x), y), true
+		}
+	case float64:
+		switch y := b.(type) {
+		case int64:
+			return cmp.Compare(x, float64(y)), true
+		case float64:
+			return cmp.Compare(x, y), true
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			if x == y {
+				return 0, true
+			}
+			if !x {
+				return -1, true
+			}
+			return 1, true
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y), true
+		}
+	case []byte:
+		if y, ok := b.([]byte); ok {
+			return bytes.Compare(x, y), true
+		}
+	case nil:
+		if b == nil {
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+// copyValue 複製巢狀的 map 與陣列，讓呼叫端修改讀取到的文件時不會影響存放的資料
+func copyValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for k, e := range x {
+			out[k] = copyValue(e)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, e := range x {
+			out[i] = copyValue(e)
+		}
+		return out
+	case []byte:
+		return bytes.Clone(x)
+	}
+	return v
+}
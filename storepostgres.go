@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// --- PostgreSQL 儲存 ---
+
+// postgresMigrations 是 PostgreSQL 的資料表遷移，只能在最後加上新的版本
+var postgresMigrations = []string{
+	// 1：所有集合共用一張表，文件以 JSONB 儲存；data 上的 GIN 索引讓 == 條件可以使用索引
+	// 沿用 IF NOT EXISTS，讓遷移加入前就已經建立資料表的資料庫也能直接套用
+	`CREATE TABLE IF NOT EXISTS documents (
+		collection text NOT NULL,
+		id text NOT NULL,
+		data jsonb NOT NULL,
+		PRIMARY KEY (collection, id)
+	);
+	CREATE INDEX IF NOT EXISTS documents_data_idx ON documents USING gin (data jsonb_path_ops);`,
+}
+
+const (
+	// PostgreSQL 的 serialization_failure 與 deadlock_detected
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+	// 遷移時以 pg_advisory_xact_lock 取得的鎖，數值只需要在同一個資料庫中不與其他程式衝突
+	pgMigrationLockID = 7214
+)
+
+// newPostgresStore 連線到 PostgreSQL 並套用資料表遷移，由 STORAGE_BACKEND=postgres 與 DATABASE_URL 啟用
+// 交易使用 SERIALIZABLE，衝突時重新執行
+func newPostgresStore(ctx context.Context, databaseURL string) (*sqlStore, error) {
+	db, err := sql.Open("pgx", databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DATABASE_URL: %v", err)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to postgres: %v", err)
+	}
+	if err := migrateSQL(ctx, db, postgresDialect{}, postgresMigrations); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate postgres schema: %v", err)
+	}
+	return newSQLStore(db, postgresDialect{}), nil
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// filter 將條件轉成 JSONB 運算：== 以包含運算 @> 比對，其他比較依值的型別轉成數值或以 C 定序比較字串
+func (postgresDialect) filter(f Filter, arg func(interface{}) string) (string, error) {
+	if f.Op == "==" {
+		contains, err := json.Marshal(jsonValue(nestedField(f.Path, f.Value)))
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf(`data @> %s::jsonb`, arg(string(contains))), nil
+	}
+	switch f.Op {
+	case "<", "<=", ">", ">=":
+	default:
+		return "", fmt.Errorf("unsupported query operator %q", f.Op)
+	}
+	path := arg(postgresPath(f.Path))
+	switch v := f.Value.(type) {
+	case time.Time:
+		return fmt.Sprintf(`(data #>> %s::text[]) COLLATE "C" %s %s`, path, f.Op, arg(v.UTC().Format(storeTimeFormat))), nil
+	case string:
+		return fmt.Sprintf(`(data #>> %s::text[]) COLLATE "C" %s %s`, path, f.Op, arg(v)), nil
+	case int, int64, float64:
+		return fmt.Sprintf(`jsonb_typeof(data #> %s::text[]) = 'number' AND (data #>> %s::text[])::numeric %s %s`, path, path, f.Op, arg(v)), nil
+	}
+	return "", fmt.Errorf("unsupported query value %T", f.Value)
+}
+
+func (postgresDialect) orderBy(path string, arg func(interface{}) string) (string, string) {
+	p := arg(postgresPath(path))
+	return fmt.Sprintf(`data #> %s::text[] IS NOT NULL`, p), fmt.Sprintf(`data #> %s::text[]`, p)
+}
+
+func (postgresDialect) txOptions() *sql.TxOptions {
+	return &sql.TxOptions{Isolation: sql.LevelSerializable}
+}
+
+func (postgresDialect) retryable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == pgSerializationFailure || pgErr.Code == pgDeadlockDetected)
+}
+
+func (postgresDialect) lockMigrations(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, pgMigrationLockID)
+	return err
+}
+
+// postgresPath 將點分隔的欄位路徑轉成 #> 運算使用的文字陣列，例如 {meta,caption}
+func postgresPath(path string) string {
+	return "{" + strings.Join(strings.Split(path, "."), ",") + "}"
+}
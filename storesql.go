@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// --- SQL 儲存 ---
+
+// SQL 後端的所有集合共用一張 documents 表，每份文件以 JSON 存放在 data 欄位，
+// 資料表由 schema_migrations 記錄的版本依序遷移；各資料庫不同的部分由 sqlDialect 提供
+type sqlDialect interface {
+	// placeholder 回傳第 n 個（從 1 開始）參數在 SQL 中的寫法
+	placeholder(n int) string
+	// filter 將一個查詢條件轉成 SQL，arg 加入一個參數並回傳它的寫法
+	filter(f Filter, arg func(interface{}) string) (string, error)
+	// orderBy 回傳排除沒有該欄位的文件的條件，以及排序用的運算式
+	orderBy(path string, arg func(interface{}) string) (cond, expr string)
+	// txOptions 是讀取後再寫入的交易使用的設定
+	txOptions() *sql.TxOptions
+	// retryable 回報交易是否因為與其他交易衝突而失敗，可以重新執行
+	retryable(err error) bool
+	// lockMigrations 在遷移的交易中取得鎖，避免多個執行個體同時遷移
+	lockMigrations(ctx context.Context, tx *sql.Tx) error
+}
+
+// 交易因為衝突失敗時最多重新執行的次數
+const sqlTxAttempts = 5
+
+// sqlStore 將資料存放在 SQL 資料庫
+// 讀取後再寫入的操作（Merge、Update 與交易）都在交易中進行，衝突時重新執行
+type sqlStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+	// 依資料庫的參數寫法產生的固定敘述
+	getSQL, putSQL, createSQL, deleteSQL, countSQL string
+}
+
+func newSQLStore(db *sql.DB, dialect sqlDialect) *sqlStore {
+	p := dialect.placeholder
+	return &sqlStore{
+		db:      db,
+		dialect: dialect,
+		getSQL:  fmt.Sprintf(`SELECT data FROM documents WHERE collection = %s AND id = %s`, p(1), p(2)),
+		putSQL: fmt.Sprintf(`INSERT INTO documents (collection, id, data) VALUES (%s, %s, %s)
+			ON CONFLICT (collection, id) DO UPDATE SET data = excluded.data`, p(1), p(2), p(3)),
+		createSQL: fmt.Sprintf(`INSERT INTO documents (collection, id, data) VALUES (%s, %s, %s)
+			ON CONFLICT (collection, id) DO NOTHING`, p(1), p(2), p(3)),
+		deleteSQL: fmt.Sprintf(`DELETE FROM documents WHERE collection = %s AND id = %s`, p(1), p(2)),
+		countSQL:  fmt.Sprintf(`SELECT count(*) FROM documents WHERE collection = %s`, p(1)),
+	}
+}
+
+// migrateSQL 在同一個交易中依序執行尚未套用的遷移，第 n 個遷移的版本為 n，套用後記錄在 schema_migrations
+// 遷移只能在清單最後加上新的版本，已經發布的遷移不可修改
+func migrateSQL(ctx context.Context, db *sql.DB, dialect sqlDialect, migrations []string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := dialect.lockMigrations(ctx, tx); err != nil {
+		return fmt.Errorf("failed to lock migrations: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version integer PRIMARY KEY,
+		applied_at text NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+	var current int
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+	if current > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this build supports (%d)", current, len(migrations))
+	}
+	insert := fmt.Sprintf(`INSERT INTO schema_migrations (version, applied_at) VALUES (%s, %s)`, dialect.placeholder(1), dialect.placeholder(2))
+	for version := current + 1; version <= len(migrations); version++ {
+		if _, err := tx.ExecContext(ctx, migrations[version-1]); err != nil {
+			return fmt.Errorf("migration %d failed: %v", version, err)
+		}
+		if _, err := tx.ExecContext(ctx, insert, version, time.Now().UTC().Format(time.RFC3339)); err != nil {
+			return fmt.Errorf("failed to record migration %d: %v", version, err)
+		}
+		slog.InfoContext(ctx, "Applied storage migration", "version", version)
+	}
+	return tx.Commit()
+}
+
+// sqlRunner 是 *sql.DB 與 *sql.Tx 共用的方法
+type sqlRunner interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (s *sqlStore) get(ctx context.Context, db sqlRunner, ref *DocRef) (*Document, error) {
+	var data []byte
+	err := db.QueryRowContext(ctx, s.getSQL, ref.Collection, ref.ID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s/%s", errDocNotFound, ref.Collection, ref.ID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	doc, err := unmarshalStoreJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Document{Ref: ref, data: doc}, nil
+}
+
+func (s *sqlStore) put(ctx context.Context, db sqlRunner, ref *DocRef, doc map[string]interface{}) error {
+	data, err := marshalStoreJSON(doc)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, s.putSQL, ref.Collection, ref.ID, string(data))
+	return err
+}
+
+func (s *sqlStore) set(ctx context.Context, db sqlRunner, ref *DocRef, data interface{}) error {
+	doc, err := encodeDocument(data)
+	if err != nil {
+		return err
+	}
+	return s.put(ctx, db, ref, doc)
+}
+
+func (s *sqlStore) update(ctx context.Context, db sqlRunner, ref *DocRef, updates []Update) error {
+	doc, err := s.get(ctx, db, ref)
+	if err != nil {
+		return err
+	}
+	if err := applyUpdates(doc.data, updates); err != nil {
+		return err
+	}
+	return s.put(ctx, db, ref, doc.data)
+}
+
+func (s *sqlStore) delete(ctx context.Context, db sqlRunner, ref *DocRef) error {
+	_, err := db.ExecContext(ctx, s.deleteSQL, ref.Collection, ref.ID)
+	return err
+}
+
+func (s *sqlStore) Get(ctx context.Context, ref *DocRef) (*Document, error) {
+	return s.get(ctx, s.db, ref)
+}
+
+func (s *sqlStore) Set(ctx context.Context, ref *DocRef, data interface{}) error {
+	return s.set(ctx, s.db, ref, data)
+}
+
+func (s *sqlStore) Merge(ctx context.Context, ref *DocRef, fields map[string]interface{}) error {
+	return s.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		stx := tx.(*sqlTx)
+		doc := map[string]interface{}{}
+		existing, err := s.get(ctx, stx.tx, ref)
+		if err == nil {
+			doc = existing.data
+		} else if !errors.Is(err, errDocNotFound) {
+			return err
+		}
+		if err := applyMerge(doc, fields); err != nil {
+			return err
+		}
+		return s.put(ctx, stx.tx, ref, doc)
+	})
+}
+
+func (s *sqlStore) Create(ctx context.Context, ref *DocRef, data interface{}) error {
+	doc, err := encodeDocument(data)
+	if err != nil {
+		return err
+	}
+	raw, err := marshalStoreJSON(doc)
+	if err != nil {
+		return err
+	}
+	result, err := s.db.ExecContext(ctx, s.createSQL, ref.Collection, ref.ID, string(raw))
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("%w: %s/%s", errDocExists, ref.Collection, ref.ID)
+	}
+	return nil
+}
+
+func (s *sqlStore) Update(ctx context.Context, ref *DocRef, updates []Update) error {
+	return s.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		return s.update(ctx, tx.(*sqlTx).tx, ref, updates)
+	})
+}
+
+func (s *sqlStore) Delete(ctx context.Context, ref *DocRef) error {
+	return s.delete(ctx, s.db, ref)
+}
+
+func (s *sqlStore) Query(ctx context.Context, q *Query) ([]*Document, error) {
+	var sb strings.Builder
+	args := []interface{}{q.Collection}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return s.dialect.placeholder(len(args))
+	}
+	fmt.Fprintf(&sb, `SELECT id, data FROM documents WHERE collection = %s`, s.dialect.placeholder(1))
+	for _, f := range q.Filters {
+		cond, err := s.dialect.filter(f, arg)
+		if err != nil {
+			return nil, err
+		}
+		sb.WriteString(" AND " + cond)
+	}
+	if q.Order != "" {
+		cond, expr := s.dialect.orderBy(q.Order, arg)
+		dir := "ASC"
+		if q.Dir == Desc {
+			dir = "DESC"
+		}
+		fmt.Fprintf(&sb, ` AND %s ORDER BY %s %s`, cond, expr, dir)
+	}
+	if q.Max > 0 {
+		fmt.Fprintf(&sb, ` LIMIT %d`, q.Max)
+	}
+
+	rows, err := s.db.QueryContext(ctx, sb.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var docs []*Document
+	for rows.Next() {
+		var id string
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		doc, err := unmarshalStoreJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s: %w", q.Collection, id, err)
+		}
+		docs = append(docs, &Document{Ref: &DocRef{Collection: q.Collection, ID: id}, data: doc})
+	}
+	return docs, rows.Err()
+}
+
+func (s *sqlStore) Count(ctx context.Context, collection string) (int64, error) {
+	var n int64
+	err := s.db.QueryRowContext(ctx, s.countSQL, collection).Scan(&n)
+	return n, err
+}
+
+func (s *sqlStore) RunTransaction(ctx context.Context, fn func(ctx context.Context, tx Tx) error) error {
+	for attempt := 1; ; attempt++ {
+		err := s.runTransaction(ctx, fn)
+		if err == nil || attempt >= sqlTxAttempts || !s.dialect.retryable(err) {
+			return err
+		}
+	}
+}
+
+func (s *sqlStore) runTransaction(ctx context.Context, fn func(ctx context.Context, tx Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, s.dialect.txOptions())
+	if err != nil {
+		return err
+	}
+	if err := fn(ctx, &sqlTx{ctx: ctx, tx: tx, store: s}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+type sqlTx struct {
+	ctx   context.Context
+	tx    *sql.Tx
+	store *sqlStore
+}
+
+func (t *sqlTx) Get(ref *DocRef) (*Document, error) {
+	return t.store.get(t.ctx, t.tx, ref)
+}
+
+func (t *sqlTx) Set(ref *DocRef, data interface{}) error {
+	return t.store.set(t.ctx, t.tx, ref, data)
+}
+
+func (t *sqlTx) Update(ref *DocRef, updates []Update) error {
+	return t.store.update(t.ctx, t.tx, ref, updates)
+}
+
+func (t *sqlTx) Delete(ref *DocRef) error {
+	return t.store.delete(t.ctx, t.tx, ref)
+}
+
+// nestedField 將點分隔的路徑與值轉成巢狀的 map，例如 meta.caption 與 x 轉成 {"meta": {"caption": x}}
+func nestedField(path string, value interface{}) map[string]interface{} {
+	parts := strings.Split(path, ".")
+	m := map[string]interface{}{parts[len(parts)-1]: value}
+	for i := len(parts) - 2; i >= 0; i-- {
+		m = map[string]interface{}{parts[i]: m}
+	}
+	return m
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// --- SQLite 儲存 ---
+
+// sqliteMigrations 是 SQLite 的資料表遷移，只能在最後加上新的版本
+var sqliteMigrations = []string{
+	// 1：所有集合共用一張表，文件以 JSON 文字儲存，查詢以 json_extract 讀取欄位
+	`CREATE TABLE documents (
+		collection text NOT NULL,
+		id text NOT NULL,
+		data text NOT NULL,
+		PRIMARY KEY (collection, id)
+	) WITHOUT ROWID`,
+}
+
+// 連線時加上的設定：等待其他連線釋放鎖、使用 WAL 讓讀取不被寫入阻擋，
+// 交易一開始就取得寫入鎖，讀取後再寫入的交易不會在升級鎖時失敗
+const sqliteDSNOptions = "_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_txlock=immediate"
+
+// newSQLiteStore 開啟 SQLite 資料庫檔案並套用資料表遷移，由 STORAGE_BACKEND=sqlite 與 DATABASE_URL（檔案路徑）啟用
+// 適合只有一個執行個體的部署；多個執行個體共用資料時改用 PostgreSQL
+func newSQLiteStore(ctx context.Context, path string) (*sqlStore, error) {
+	dsn := path
+	if strings.Contains(dsn, "?") {
+		dsn += "&" + sqliteDSNOptions
+	} else {
+		dsn += "?" + sqliteDSNOptions
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DATABASE_URL: %v", err)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open sqlite database: %v", err)
+	}
+	if err := migrateSQL(ctx, db, sqliteDialect{}, sqliteMigrations); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate sqlite schema: %v", err)
+	}
+	return newSQLStore(db, sqliteDialect{}), nil
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) placeholder(n int) string {
+	return fmt.Sprintf("?%d", n)
+}
+
+// filter 以 json_type 確認欄位的型別後再用 json_extract 比較，與 Firestore 一樣只比較相同型別的值；
+// 時間以固定長度的字串儲存，依字串排序即是依時間排序
+func (sqliteDialect) filter(f Filter, arg func(interface{}) string) (string, error) {
+	switch f.Op {
+	case "==", "<", "<=", ">", ">=":
+	default:
+		return "", fmt.Errorf("unsupported query operator %q", f.Op)
+	}
+	op := f.Op
+	if op == "==" {
+		op = "="
+	}
+	path := arg(sqlitePath(f.Path))
+	var types string
+	var value interface{}
+	switch v := f.Value.(type) {
+	case time.Time:
+		types, value = `'text'`, v.UTC().Format(storeTimeFormat)
+	case string:
+		types, value = `'text'`, v
+	case int:
+		types, value = `'integer', 'real'`, int64(v)
+	case int64, float64:
+		types, value = `'integer', 'real'`, v
+	case bool:
+		if f.Op != "==" {
+			return "", fmt.Errorf("unsupported query operator %q for bool", f.Op)
+		}
+		if v {
+			return fmt.Sprintf(`json_type(data, %s) = 'true'`, path), nil
+		}
+		return fmt.Sprintf(`json_type(data, %s) = 'false'`, path), nil
+	default:
+		return "", fmt.Errorf("unsupported query value %T", f.Value)
+	}
+	return fmt.Sprintf(`json_type(data, %s) IN (%s) AND json_extract(data, %s) %s %s`, path, types, path, op, arg(value)), nil
+}
+
+func (sqliteDialect) orderBy(path string, arg func(interface{}) string) (string, string) {
+	p := arg(sqlitePath(path))
+	return fmt.Sprintf(`json_type(data, %s) IS NOT NULL`, p), fmt.Sprintf(`json_extract(data, %s)`, p)
+}
+
+// SQLite 不支援設定隔離等級，交易本身就是可序列化的
+func (sqliteDialect) txOptions() *sql.TxOptions {
+	return nil
+}
+
+func (sqliteDialect) retryable(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	code := sqliteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+// 遷移的交易以 _txlock=immediate 開始，已經取得寫入鎖
+func (sqliteDialect) lockMigrations(ctx context.Context, tx *sql.Tx) error {
+	return nil
+}
+
+// sqlitePath 將點分隔的欄位路徑轉成 JSON 路徑，例如 $."meta"."caption"
+func sqlitePath(path string) string {
+	var sb strings.Builder
+	sb.WriteString("$")
+	for _, part := range strings.Split(path, ".") {
+		sb.WriteString(`."` + strings.ReplaceAll(part, `"`, `\"`) + `"`)
+	}
+	return sb.String()
+}
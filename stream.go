@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+const (
+	// Telegram Bot API 的檔案下載上限
+	cloudMaxFileSizeMB = 20
+	// 自架的 Local Bot API Server 或以 MTProto 下載時，機器人可以下載的檔案上限
+	localMaxFileSizeMB = 2000
+)
+
+// fileSizeCeilingMB 回傳目前的下載方式允許的上限
+func (c *Config) fileSizeCeilingMB() int64 {
+	if c.TelegramAPIURL != "" || c.MTProto.Enabled() {
+		return localMaxFileSizeMB
+	}
+	return cloudMaxFileSizeMB
+}
+
+// maxFileSize 是所有類型的檔案共用的下載上限，由 MAX_FILE_SIZE_MB 設定；未設定時使用目前的下載方式允許的上限
+func (c *Config) maxFileSize() int64 {
+	sizeMB := c.MaxFileSizeMB
+	if sizeMB == 0 {
+		sizeMB = c.fileSizeCeilingMB()
+	}
+	return sizeMB * 1024 * 1024
+}
+
+// telegramEndpoints 回傳呼叫 Bot API 與下載檔案的網址格式，設定 TELEGRAM_API_URL 時改為 Local Bot API Server 的位址
+func (c *Config) telegramEndpoints() (api, file string) {
+	if c.TelegramAPIURL == "" {
+		return tgbotapi.APIEndpoint, tgbotapi.FileEndpoint
+	}
+	return c.TelegramAPIURL + "/bot%s/%s", c.TelegramAPIURL + "/file/bot%s/%s"
+}
+
+// fileTooLargeMessage 回傳超過下載上限時給使用者的說明；size 為 0 代表大小是在串流時才發現超過
+func fileTooLargeMessage(ctx context.Context, size int64) string {
+	limitMB := configFor(ctx).maxFileSize() / 1024 / 1024
+	if size > 0 {
+		return fmt.Sprintf("檔案大小為 %s，已超過機器人 %d MB 的下載限制，無法處理。", formatSize(size), limitMB)
+	}
+	return fmt.Sprintf("檔案大小已超過機器人 %d MB 的下載限制，無法處理。", limitMB)
+}
+
+const (
+	// 與 Telegram 檔案伺服器建立連線（含 TLS 交握）的時限
+	telegramConnectTimeout = 10 * time.Second
+	// 送出請求後等待回應標頭的時限
+	telegramHeaderTimeout = 30 * time.Second
+	// 單次讀取等不到任何資料的時限，串流時間可能很長，因此不限制整體下載時間
+	telegramReadTimeout = 60 * time.Second
+)
+
+// telegramClient 只用來下載 Telegram 上的檔案，與 instrumentedClient 分開設定連線與讀取的時限
+var telegramClient = &http.Client{
+	Transport: otelhttp.NewTransport(&http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: telegramConnectTimeout, KeepAlive: 30 * time.Second}).DialContext,
+		TLSHandshakeTimeout:   telegramConnectTimeout,
+		ResponseHeaderTimeout: telegramHeaderTimeout,
+		IdleConnTimeout:       90 * time.Second,
+		MaxIdleConns:          100,
+		ForceAttemptHTTP2:     true,
+	}),
+}
+
+var errDownloadStalled = fmt.Errorf("download stalled for %s", telegramReadTimeout)
+
+// telegramDownload 是開始下載的 Telegram 檔案
+type telegramDownload struct {
+	Body          io.ReadCloser
+	ContentLength int64 // 伺服器宣告的大小，未知時為 -1
+}
+
+// getTelegramFile 查詢檔案的下載路徑
+func getTelegramFile(ctx context.Context, fileID string) (tgbotapi.File, error) {
+	return filesFor(ctx).GetFile(tgbotapi.FileConfig{FileID: fileID})
+}
+
+// openTelegramFile 開始下載 Telegram 上的檔案，回傳的 body 在讀取超過 maxFileSize 時回傳 errFileTooLarge，
+// 單次讀取超過 telegramReadTimeout 沒有資料時中止連線並回傳 errDownloadStalled
+// 宣告的 FileSize 與 Content-Length 都不一定可信，因此大小一律在串流時檢查；呼叫端需關閉 body
+// Local Bot API Server 以 --local 模式執行時回傳的是伺服器上的絕對路徑，此時直接從共用的磁碟讀取
+func openTelegramFile(ctx context.Context, file tgbotapi.File) (*telegramDownload, error) {
+	if filepath.IsAbs(file.FilePath) {
+		f, err := os.Open(file.FilePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open local file: %w", err)
+		}
+		size := int64(-1)
+		if info, err := f.Stat(); err == nil {
+			size = info.Size()
+		}
+		return &telegramDownload{Body: limitedReadCloser{newLimitedReader(f, configFor(ctx).maxFileSize()), f}, ContentLength: size}, nil
+	}
+
+	fileURL := filesFor(ctx).FileURL(file)
+	ctx, cancel := context.WithCancel(ctx)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp, err := telegramClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to download file: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("failed to download file: unexpected status %s", resp.Status)
+	}
+	body := &stallGuard{
+		r:       newLimitedReader(resp.Body, configFor(ctx).maxFileSize()),
+		body:    resp.Body,
+		cancel:  cancel,
+		timeout: telegramReadTimeout,
+	}
+	return &telegramDownload{Body: body, ContentLength: resp.ContentLength}, nil
+}
+
+// openMTProtoFile 在設定 MTProto 時直接以 file_id 下載，大小同樣在串流時檢查；未設定或下載失敗時回傳 false，呼叫端改用 Bot API
+func openMTProtoFile(ctx context.Context, fileID string) (*telegramDownload, bool) {
+	d := currentTenant(ctx).MTProto
+	if d == nil {
+		return nil, false
+	}
+	body, err := d.Open(ctx, fileID)
+	if err != nil {
+		slog.WarnContext(ctx, "MTProto download failed, falling back to the Bot API", "error", err)
+		return nil, false
+	}
+	return &telegramDownload{Body: limitedReadCloser{newLimitedReader(body, configFor(ctx).maxFileSize()), body}, ContentLength: -1}, true
+}
+
+// openTelegramFileByID 開始下載 file_id 指向的檔案：先嘗試 MTProto，再以 Bot API 查詢路徑後下載
+func openTelegramFileByID(ctx context.Context, fileID string) (*telegramDownload, error) {
+	if download, ok := openMTProtoFile(ctx, fileID); ok {
+		return download, nil
+	}
+	file, err := getTelegramFile(ctx, fileID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file: %w", err)
+	}
+	return openTelegramFile(ctx, file)
+}
+
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// stallGuard 在每次讀取時計時，超過時限沒有資料就取消請求
+// 只計算等待 Telegram 的時間，下游（例如上傳到目的地）處理得慢不會被當成停滯
+type stallGuard struct {
+	r       io.Reader
+	body    io.Closer
+	cancel  context.CancelFunc
+	timeout time.Duration
+	stalled atomic.Bool
+}
+
+func (g *stallGuard) Read(p []byte) (int, error) {
+	timer := time.AfterFunc(g.timeout, func() {
+		g.stalled.Store(true)
+		g.cancel()
+	})
+	n, err := g.r.Read(p)
+	timer.Stop()
+	if err != nil && !errors.Is(err, io.EOF) && g.stalled.Load() {
+		err = errDownloadStalled
+	}
+	return n, err
+}
+
+func (g *stallGuard) Close() error {
+	g.cancel()
+	return g.body.Close()
+}
+
+// limitedReader 在串流時計算已傳輸的位元組數，超過上限時中止讀取
+// Telegram 有時不會提供 FileSize，因此不能只依賴事前的大小檢查
+type limitedReader struct {
+	r        io.Reader
+	limit    int64
+	n        int64
+	exceeded bool
+}
+
+func newLimitedReader(r io.Reader, limit int64) *limitedReader {
+	return &limitedReader{r: r, limit: limit}
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, errFileTooLarge
+	}
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.limit {
+		l.exceeded = true
+		return n, errFileTooLarge
+	}
+	return n, err
+}
+
+// BytesRead 回傳目前為止實際傳輸的位元組數
+func (l *limitedReader) BytesRead() int64 { return l.n }
+
+// Exceeded 表示串流是否因為超過上限而被中止
+func (l *limitedReader) Exceeded() bool { return l.exceeded }
+
+var errFileTooLarge = errors.New("file exceeds the size limit")
+
+// formatSize 將位元組數轉成易讀的字串
+func formatSize(size int64) string {
+	switch {
+	case size >= 1024*1024:
+		return fmt.Sprintf("%.2f MB", float64(size)/1024/1024)
+	case size >= 1024:
+		return fmt.Sprintf("%.1f KB", float64(size)/1024)
+	}
+	return fmt.Sprintf("%d B", size)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 聯絡人與位置 ---
+
+// 聯絡人與位置沒有可以下載的檔案，改由訊息內容產生 vCard 與 GPX 檔上傳
+
+// generatedFile 回傳以 content 產生的檔案，UniqueID 取自內容的雜湊，讓略過重複檔案與 /get 也能使用
+func generatedFile(name, fileType, mimeType string, content []byte) *telegramFile {
+	sum := sha256.Sum256(content)
+	return &telegramFile{
+		UniqueID: fileType + "_" + hex.EncodeToString(sum[:8]),
+		Name:     name,
+		Type:     fileType,
+		Size:     int64(len(content)),
+		MimeType: mimeType,
+		Content:  content,
+	}
+}
+
+// contactFile 將聯絡人存成 vCard；傳送者附上完整的 vCard 時直接使用
+func contactFile(c *tgbotapi.Contact) *telegramFile {
+	fullName := strings.TrimSpace(c.FirstName + " " + c.LastName)
+	card := c.VCard
+	if card == "" {
+		lines := []string{
+			"BEGIN:VCARD",
+			"VERSION:3.0",
+			fmt.Sprintf("N:%s;%s;;;", escapeVCard(c.LastName), escapeVCard(c.FirstName)),
+			"FN:" + escapeVCard(fullName),
+			"TEL;TYPE=CELL:" + escapeVCard(c.PhoneNumber),
+		}
+		if c.UserID != 0 {
+			lines = append(lines, fmt.Sprintf("X-TELEGRAM-ID:%d", c.UserID))
+		}
+		card = strings.Join(append(lines, "END:VCARD"), "\r\n") + "\r\n"
+	}
+	name := sanitizeGeneratedName(fullName)
+	if name == "" {
+		name = "contact"
+	}
+	return generatedFile(name+".vcf", "contact", "text/vcard", []byte(card))
+}
+
+// escapeVCard 跳脫 vCard 欄位中有特殊意義的字元
+func escapeVCard(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\n", `\n`).Replace(s)
+}
+
+// gpxFile 將位置或地點存成只有一個航點的 GPX 檔；即時位置只記錄傳送當下的位置
+func gpxFile(message *tgbotapi.Message) *telegramFile {
+	loc := message.Location
+	date := time.Unix(int64(message.Date), 0).UTC()
+	point := gpxWaypoint{Lat: loc.Latitude, Lon: loc.Longitude, Time: date.Format(time.RFC3339)}
+	name, fileType := "location_"+date.Format("20060102_150405"), "location"
+	if v := message.Venue; v != nil {
+		point.Lat, point.Lon = v.Location.Latitude, v.Location.Longitude
+		point.Name, point.Desc = v.Title, v.Address
+		fileType = "venue"
+		if n := sanitizeGeneratedName(v.Title); n != "" {
+			name = n
+		}
+	}
+	doc := gpxDocument{Version: "1.1", Creator: "tg-helper", Xmlns: "http://www.topografix.com/GPX/1/1", Waypoints: []gpxWaypoint{point}}
+	out, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		// 只有數字與字串的結構不會編碼失敗
+		panic(err)
+	}
+	return generatedFile(name+".gpx", fileType, "application/gpx+xml", append([]byte(xml.Header), append(out, '\n')...))
+}
+
+type gpxDocument struct {
+	XMLName   xml.Name      `xml:"gpx"`
+	Version   string        `xml:"version,attr"`
+	Creator   string        `xml:"creator,attr"`
+	Xmlns     string        `xml:"xmlns,attr"`
+	Waypoints []gpxWaypoint `xml:"wpt"`
+}
+
+type gpxWaypoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Time string  `xml:"time"`
+	Name string  `xml:"name,omitempty"`
+	Desc string  `xml:"desc,omitempty"`
+}
+
+// sanitizeGeneratedName 移除名稱中不能作為檔名的字元
+func sanitizeGeneratedName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 0x20 {
+			return '_'
+		}
+		return r
+	}, strings.TrimSpace(s))
+	if r := []rune(s); len(r) > 80 {
+		s = string(r[:80])
+	}
+	return s
+}
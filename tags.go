@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- Hashtag 標籤 ---
+
+const (
+	// 每個檔案最多記錄的標籤數
+	maxUploadTags = 10
+	// 超過這個字數的 hashtag 不記錄
+	maxTagLength = 50
+	// 超過這個位元組數的 hashtag 也不記錄：Drive appProperties 的 key 與 value 合計不能超過 124 位元組，
+	// 中文一個字佔 3 個位元組，只限制字數時 tag_<標籤> 會超過上限
+	maxTagBytes = maxDriveAppPropertyBytes - len(propTagPrefix) - len("true")
+	// 最多可以設定的 hashtag 資料夾數
+	maxTagFolders = 50
+	// Drive appProperties 中標籤的 key 前綴，例如 tag_tax
+	propTagPrefix = "tag_"
+)
+
+// hashtagPattern 與 Telegram 辨識 hashtag 的規則相同：# 後面接文字、數字或底線
+var hashtagPattern = regexp.MustCompile(`#([\p{L}\p{N}_]+)`)
+
+// captionHashtags 依出現順序回傳說明文字中的 hashtag，轉成小寫、不含 #、不重複
+func captionHashtags(caption string) []string {
+	var tags []string
+	for _, m := range hashtagPattern.FindAllStringSubmatch(caption, -1) {
+		tag := strings.ToLower(m[1])
+		if len([]rune(tag)) > maxTagLength || len(tag) > maxTagBytes || slices.Contains(tags, tag) {
+			continue
+		}
+		tags = append(tags, tag)
+		if len(tags) == maxUploadTags {
+			break
+		}
+	}
+	return tags
+}
+
+// hashtagFolder 回傳第一個設定了資料夾的 hashtag 所對應的資料夾範本，都沒有設定時回傳空字串
+func hashtagFolder(settings *UserSettings, tags []string) string {
+	for _, tag := range tags {
+		if folder := settings.TagFolders[tag]; folder != "" {
+			return folder
+		}
+	}
+	return ""
+}
+
+// 處理 /tags 指令：查看或設定 hashtag 對應的資料夾
+func handleTags(ctx context.Context, message *tgbotapi.Message) {
+	userID := message.From.ID
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的設定時發生錯誤，請稍後再試。")
+		return
+	}
+
+	args := strings.Fields(message.CommandArguments())
+	if len(args) == 0 {
+		var sb strings.Builder
+		if len(settings.TagFolders) == 0 {
+			sb.WriteString("尚未設定任何 hashtag 資料夾。\n")
+		} else {
+			sb.WriteString("hashtag 對應的資料夾：\n")
+			for _, tag := range slices.Sorted(maps.Keys(settings.TagFolders)) {
+				fmt.Fprintf(&sb, "• #%s → %s\n", tag, settings.TagFolders[tag])
+			}
+		}
+		sb.WriteString("\n設定方式：/tags #<標籤> <資料夾範本>\n移除：/tags #<標籤> off\n傳送檔案時在說明文字加上 hashtag，就會上傳到對應的資料夾；有多個時以第一個設定了資料夾的 hashtag 為準。")
+		replyToUser(ctx, message.Chat.ID, message.MessageID, sb.String())
+		return
+	}
+
+	tags := captionHashtags(args[0])
+	if len(args) < 2 || len(tags) != 1 || "#"+tags[0] != strings.ToLower(args[0]) {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "用法：/tags #<標籤> <資料夾範本>，標籤只能包含文字、數字與底線。")
+		return
+	}
+	tag := tags[0]
+	folder := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(message.CommandArguments()), args[0]))
+	if folder == "off" {
+		if err := updateUserSettings(ctx, userID, map[string]interface{}{"tag_folders": map[string]interface{}{tag: deleteField}}); err != nil {
+			slog.ErrorContext(ctx, "Failed to save tag folder", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "儲存設定時發生錯誤，請稍後再試。")
+			return
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("已移除 #%s 的資料夾。", tag))
+		return
+	}
+	if _, exists := settings.TagFolders[tag]; !exists && len(settings.TagFolders) >= maxTagFolders {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("最多只能設定 %d 個 hashtag 資料夾，請先移除不需要的設定。", maxTagFolders))
+		return
+	}
+	if err := validateTemplate(folder, folderTemplate); err != nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("資料夾範本無效：%v", err))
+		return
+	}
+	if err := updateUserSettings(ctx, userID, map[string]interface{}{"tag_folders": map[string]interface{}{tag: folder}}); err != nil {
+		slog.ErrorContext(ctx, "Failed to save tag folder", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "儲存設定時發生錯誤，請稍後再試。")
+		return
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("之後說明文字含有 #%s 的檔案會上傳到「%s」。", tag, folder))
+}
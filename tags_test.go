@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCaptionHashtags(t *testing.T) {
+	longCJK := strings.Repeat("報", 41) // 41 個字，但有 123 個位元組
+	tests := []struct {
+		name    string
+		caption string
+		want    []string
+	}{
+		{"none", "no tags here", nil},
+		{"lower case and order", "#Tax report #2024", []string{"tax", "2024"}},
+		{"duplicates", "#tax #TAX #tax", []string{"tax"}},
+		{"cjk", "#報稅 收據", []string{"報稅"}},
+		{"too many runes", "#" + strings.Repeat("a", maxTagLength+1) + " #ok", []string{"ok"}},
+		{"too many bytes", "#" + longCJK + " #ok", []string{"ok"}},
+		{"limit", "#a #b #c #d #e #f #g #h #i #j #k #l", []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := captionHashtags(tt.caption); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("captionHashtags(%q) = %q, want %q", tt.caption, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+// --- 檔名與資料夾範本 ---
+
+// UploadMeta 記錄一次上傳的檔案資訊，用來渲染範本與預覽
+type UploadMeta struct {
+	Name      string    `firestore:"name"`       // 原始檔名（不含副檔名）
+	Ext       string    `firestore:"ext"`        // 副檔名（不含點）
+	Size      int64     `firestore:"size"`       // 檔案大小（位元組）
+	Type      string    `firestore:"type"`       // 內容類型，例如 document、photo、animation、video_note、sticker、voice、contact、location、venue
+	Sender    string    `firestore:"sender"`     // 傳送者名稱
+	Chat      string    `firestore:"chat"`       // 聊天室名稱
+	Topic     string    `firestore:"topic"`      // 論壇主題名稱，不在主題中時為空字串
+	Caption   string    `firestore:"caption"`    // 訊息的說明文字
+	Date      time.Time `firestore:"date"`       // 訊息時間
+	CreatedAt time.Time `firestore:"created_at"` // 上傳時間
+}
+
+// 範本中可用的變數，以及對應的說明
+var templatePlaceholders = []struct {
+	Name        string
+	Description string
+	Render      func(m *UploadMeta) string
+}{
+	{"name", "原始檔名（不含副檔名）", func(m *UploadMeta) string { return m.Name }},
+	{"ext", "副檔名", func(m *UploadMeta) string { return m.Ext }},
+	{"type", "內容類型 (document/photo/animation/video_note/sticker/voice/contact/location/venue)", func(m *UploadMeta) string { return m.Type }},
+	{"date", "日期 (2006-01-02)", func(m *UploadMeta) string { return m.Date.Format("2006-01-02") }},
+	{"time", "時間 (150405)", func(m *UploadMeta) string { return m.Date.Format("150405") }},
+	{"year", "年", func(m *UploadMeta) string { return m.Date.Format("2006") }},
+	{"month", "月", func(m *UploadMeta) string { return m.Date.Format("01") }},
+	{"day", "日", func(m *UploadMeta) string { return m.Date.Format("02") }},
+	{"sender", "傳送者名稱", func(m *UploadMeta) string { return m.Sender }},
+	{"chat", "聊天室名稱", func(m *UploadMeta) string { return m.Chat }},
+	{"topic", "論壇主題名稱（不在主題中時省略該層資料夾）", func(m *UploadMeta) string { return m.Topic }},
+	{"caption", "說明文字的第一行（編輯說明文字時會重新命名）", func(m *UploadMeta) string { return captionTitle(m.Caption) }},
+}
+
+const maxTemplateLength = 200
+
+// templateKind 區分檔名範本與資料夾範本，兩者的驗證規則不同
+type templateKind int
+
+const (
+	filenameTemplate templateKind = iota
+	folderTemplate
+)
+
+// templateError 是範本驗證失敗時回傳的錯誤，訊息會直接顯示給使用者
+type templateError struct {
+	msg string
+}
+
+func (e *templateError) Error() string { return e.msg }
+
+// validateTemplate 檢查範本語法，回傳可直接顯示給使用者的錯誤訊息
+func validateTemplate(tmpl string, kind templateKind) error {
+	if strings.TrimSpace(tmpl) == "" {
+		return &templateError{"範本不可為空白。"}
+	}
+	if len(tmpl) > maxTemplateLength {
+		return &templateError{fmt.Sprintf("範本長度不可超過 %d 個字元。", maxTemplateLength)}
+	}
+
+	// 逐字元掃描大括號，確認每個變數都有成對的括號且名稱有效
+	for i := 0; i < len(tmpl); i++ {
+		switch tmpl[i] {
+		case '{':
+			end := strings.IndexAny(tmpl[i+1:], "{}")
+			if end < 0 || tmpl[i+1+end] != '}' {
+				return &templateError{fmt.Sprintf("第 %d 個字元的「{」沒有對應的「}」。", i+1)}
+			}
+			name := tmpl[i+1 : i+1+end]
+			if !isKnownPlaceholder(name) {
+				return &templateError{fmt.Sprintf("未知的變數 {%s}。可用的變數：%s", name, placeholderList())}
+			}
+			i += end + 1
+		case '}':
+			return &templateError{fmt.Sprintf("第 %d 個字元的「}」沒有對應的「{」。", i+1)}
+		}
+	}
+
+	if strings.ContainsAny(tmpl, "\\\x00") {
+		return &templateError{"範本不可包含反斜線或控制字元。"}
+	}
+
+	switch kind {
+	case filenameTemplate:
+		if strings.Contains(tmpl, "/") {
+			return &templateError{"檔名範本不可包含「/」，如需分類請改用 /folder_template。"}
+		}
+	case folderTemplate:
+		for _, segment := range strings.Split(tmpl, "/") {
+			segment = strings.TrimSpace(segment)
+			if segment == "" {
+				return &templateError{"資料夾範本不可包含空的路徑段落（例如連續的「//」或以「/」開頭結尾）。"}
+			}
+			if segment == "." || segment == ".." {
+				return &templateError{"資料夾範本不可包含「.」或「..」路徑段落。"}
+			}
+		}
+	}
+	return nil
+}
+
+func isKnownPlaceholder(name string) bool {
+	for _, p := range templatePlaceholders {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func placeholderList() string {
+	names := make([]string, 0, len(templatePlaceholders))
+	for _, p := range templatePlaceholders {
+		names = append(names, "{"+p.Name+"}")
+	}
+	return strings.Join(names, " ")
+}
+
+// placeholderHelp 產生範本變數的說明文字
+func placeholderHelp() string {
+	var sb strings.Builder
+	for _, p := range templatePlaceholders {
+		fmt.Fprintf(&sb, "{%s}：%s\n", p.Name, p.Description)
+	}
+	return sb.String()
+}
+
+// renderTemplate 以檔案資訊渲染範本，範本須已通過 validateTemplate
+func renderTemplate(tmpl string, meta *UploadMeta) string {
+	var sb strings.Builder
+	for i := 0; i < len(tmpl); i++ {
+		if tmpl[i] != '{' {
+			sb.WriteByte(tmpl[i])
+			continue
+		}
+		end := strings.IndexByte(tmpl[i:], '}')
+		name := tmpl[i+1 : i+end]
+		for _, p := range templatePlaceholders {
+			if p.Name == name {
+				sb.WriteString(sanitizeNameSegment(p.Render(meta)))
+				break
+			}
+		}
+		i += end
+	}
+	return sb.String()
+}
+
+// renderFileName 產生上傳時使用的檔名；範本未包含 {ext} 時自動補上原始副檔名
+func renderFileName(tmpl string, meta *UploadMeta) string {
+	if tmpl == "" {
+		return joinExt(meta.Name, meta.Ext)
+	}
+	name := strings.TrimSpace(renderTemplate(tmpl, meta))
+	if name == "" {
+		name = meta.Name
+	}
+	if !strings.Contains(tmpl, "{ext}") {
+		name = joinExt(name, meta.Ext)
+	}
+	return name
+}
+
+// renderFolderPath 產生上傳的目標資料夾路徑段落；範本為空時回傳 nil（上傳到根目錄）
+func renderFolderPath(tmpl string, meta *UploadMeta) []string {
+	if tmpl == "" {
+		return nil
+	}
+	var segments []string
+	for _, segment := range strings.Split(renderTemplate(tmpl, meta), "/") {
+		segment = strings.TrimSpace(segment)
+		if segment == "" || segment == "." || segment == ".." {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	return segments
+}
+
+// splitFileName 將檔名拆成主檔名與副檔名（不含點）
+func splitFileName(fileName string) (string, string) {
+	ext := path.Ext(fileName)
+	if ext == fileName {
+		return fileName, ""
+	}
+	return strings.TrimSuffix(fileName, ext), strings.TrimPrefix(ext, ".")
+}
+
+func joinExt(name, ext string) string {
+	if ext == "" {
+		return name
+	}
+	return name + "." + ext
+}
+
+// sanitizeNameSegment 移除變數值中會破壞路徑的字元
+func sanitizeNameSegment(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\':
+			return '_'
+		case r < 0x20:
+			return -1
+		}
+		return r
+	}, s)
+}
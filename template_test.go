@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    string
+		kind    templateKind
+		wantErr string // 空字串代表應通過驗證
+	}{
+		{"plain name", "report", filenameTemplate, ""},
+		{"placeholders", "{date}_{name}.{ext}", filenameTemplate, ""},
+		{"folder path", "{year}/{month}/{chat}", folderTemplate, ""},
+		{"empty", "  ", filenameTemplate, "範本不可為空白"},
+		{"too long", strings.Repeat("a", maxTemplateLength+1), filenameTemplate, "範本長度不可超過"},
+		{"unclosed brace", "{name", filenameTemplate, "沒有對應的「}」"},
+		{"nested brace", "{na{me}", filenameTemplate, "沒有對應的「}」"},
+		{"stray closing brace", "name}", filenameTemplate, "沒有對應的「{」"},
+		{"unknown placeholder", "{nope}", filenameTemplate, "未知的變數 {nope}"},
+		{"backslash", `a\b`, filenameTemplate, "反斜線"},
+		{"slash in file name", "{year}/{name}", filenameTemplate, "檔名範本不可包含「/」"},
+		{"empty folder segment", "{year}//{month}", folderTemplate, "空的路徑段落"},
+		{"leading slash", "/{year}", folderTemplate, "空的路徑段落"},
+		{"dot dot segment", "{year}/../x", folderTemplate, "「.」或「..」"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTemplate(tt.tmpl, tt.kind)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateTemplate(%q) = %v, want nil", tt.tmpl, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateTemplate(%q) = %v, want an error containing %q", tt.tmpl, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenderTemplate(t *testing.T) {
+	meta := &UploadMeta{
+		Name:    "IMG_0001",
+		Ext:     "jpg",
+		Type:    "photo",
+		Sender:  "Alice/Bob",
+		Chat:    "Family",
+		Caption: "Trip to Tainan\nday one",
+		Date:    time.Date(2024, 3, 9, 14, 5, 6, 0, time.UTC),
+	}
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{"{name}", "IMG_0001"},
+		{"{date}_{time}", "2024-03-09_140506"},
+		{"{year}/{month}/{day}", "2024/03/09"},
+		{"{sender}", "Alice_Bob"}, // 變數值中的「/」不會產生新的資料夾
+		{"{caption}", "Trip to Tainan"},
+		{"{topic}-{type}", "-photo"},
+		{"no placeholders", "no placeholders"},
+	}
+	for _, tt := range tests {
+		if got := renderTemplate(tt.tmpl, meta); got != tt.want {
+			t.Errorf("renderTemplate(%q) = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
+
+func TestRenderFileName(t *testing.T) {
+	meta := &UploadMeta{Name: "scan", Ext: "pdf", Date: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)}
+	tests := []struct {
+		tmpl string
+		want string
+	}{
+		{"", "scan.pdf"},
+		{"{date}_{name}", "2024-01-02_scan.pdf"},
+		{"{name}.{ext}", "scan.pdf"},
+		{"{topic}", "scan.pdf"}, // 渲染結果為空時使用原始檔名
+	}
+	for _, tt := range tests {
+		if got := renderFileName(tt.tmpl, meta); got != tt.want {
+			t.Errorf("renderFileName(%q) = %q, want %q", tt.tmpl, got, tt.want)
+		}
+	}
+}
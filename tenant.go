@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// --- 多個機器人 ---
+
+// tenant 是同一個部署中的一個 Telegram 機器人，各自有 Google OAuth 用戶端與 Firestore 命名空間
+type tenant struct {
+	ID        string // 機器人 ID（權杖中冒號前的數字），也是 /webhook/{botID} 的路徑
+	Bot       *tgbotapi.BotAPI
+	Sender    MessageSender  // 處理訊息時送出回覆，測試時替換成假的實作
+	Files     FileURLGetter  // 取得使用者傳送的檔案
+	MTProto   FileDownloader // 設定 TELEGRAM_API_ID 時以 MTProto 直接下載檔案，未設定時為 nil，只使用 Bot API
+	OAuth     *oauth2.Config
+	Namespace string  // 加在所有 Firestore 集合名稱前的前綴，主要機器人為空字串，沿用原本的集合
+	Config    *Config // 部署的設定，所有機器人共用；處理請求時透過 configFor 讀取
+}
+
+var (
+	// defaultTenant 是 TELEGRAM_BOT_TOKEN 設定的主要機器人，接收 / 路由的 webhook
+	defaultTenant *tenant
+	// 所有機器人，依機器人 ID 索引
+	tenants = map[string]*tenant{}
+)
+
+// 命名空間只能使用小寫英數字、底線與連字號，避免產生不合法的集合名稱
+var namespacePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+type tenantKey struct{}
+
+// withTenant 讓之後的 Telegram 呼叫、OAuth 與 Firestore 讀寫都使用指定的機器人
+func withTenant(ctx context.Context, t *tenant) context.Context {
+	return context.WithValue(ctx, tenantKey{}, t)
+}
+
+// currentTenant 回傳 ctx 中的機器人，沒有指定時回傳主要機器人
+func currentTenant(ctx context.Context) *tenant {
+	if t, ok := ctx.Value(tenantKey{}).(*tenant); ok {
+		return t
+	}
+	return defaultTenant
+}
+
+// botFor 回傳處理這個請求的 Telegram 機器人
+func botFor(ctx context.Context) *tgbotapi.BotAPI {
+	return currentTenant(ctx).Bot
+}
+
+// MessageSender 送出訊息與不需要回傳訊息的 Bot API 呼叫，*tgbotapi.BotAPI 即符合這個介面
+type MessageSender interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+}
+
+// FileURLGetter 查詢 Telegram 上的檔案並產生下載網址
+type FileURLGetter interface {
+	GetFile(config tgbotapi.FileConfig) (tgbotapi.File, error)
+	FileURL(file tgbotapi.File) string
+}
+
+// botFileURLGetter 以機器人的權杖組出 endpoint 上的下載網址
+type botFileURLGetter struct {
+	bot      *tgbotapi.BotAPI
+	endpoint string
+}
+
+func (g botFileURLGetter) GetFile(config tgbotapi.FileConfig) (tgbotapi.File, error) {
+	return g.bot.GetFile(config)
+}
+
+func (g botFileURLGetter) FileURL(file tgbotapi.File) string {
+	return fmt.Sprintf(g.endpoint, g.bot.Token, file.FilePath)
+}
+
+// senderFor 回傳這個請求用來送出訊息的 MessageSender
+func senderFor(ctx context.Context) MessageSender {
+	return currentTenant(ctx).Sender
+}
+
+// filesFor 回傳這個請求用來取得檔案的 FileURLGetter
+func filesFor(ctx context.Context) FileURLGetter {
+	return currentTenant(ctx).Files
+}
+
+// googleOAuth 回傳這個機器人的 Google OAuth 設定
+func googleOAuth(ctx context.Context) *oauth2.Config {
+	return currentTenant(ctx).OAuth
+}
+
+// configFor 回傳處理這個請求時使用的設定
+func configFor(ctx context.Context) *Config {
+	return currentTenant(ctx).Config
+}
+
+// collectionName 回傳加上機器人命名空間的集合名稱
+func collectionName(ctx context.Context, name string) string {
+	return currentTenant(ctx).Namespace + name
+}
+
+func newGoogleOAuthConfig(cfg OAuthClientConfig) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,    // e.g., https://your-service.run.app/oauth/callback
+		Scopes:       baseGoogleScopes(), // 只要求上傳權限，其他權限在使用功能時再追加
+		Endpoint:     google.Endpoint,
+	}
+}
+
+// newTenant 建立機器人的 API 用戶端；namespace 為空字串時代表主要機器人
+// 設定 TELEGRAM_API_URL 時所有機器人都透過同一個 Local Bot API Server 呼叫，設定 TELEGRAM_API_ID 時各自以自己的權杖登入 MTProto
+func newTenant(cfg *Config, token, namespace string, google OAuthClientConfig) (*tenant, error) {
+	endpoint, fileEndpoint := cfg.telegramEndpoints()
+	api, err := tgbotapi.NewBotAPIWithAPIEndpoint(token, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	t := &tenant{
+		ID:     fmt.Sprintf("%d", api.Self.ID),
+		Bot:    api,
+		Sender: api,
+		Files:  botFileURLGetter{bot: api, endpoint: fileEndpoint},
+		OAuth:  newGoogleOAuthConfig(google),
+		Config: cfg,
+	}
+	if namespace != "" {
+		t.Namespace = namespace + "_"
+	}
+	if cfg.MTProto.Enabled() {
+		t.MTProto = newMTProtoDownloader(cfg.MTProto, token, rawCollection(t.Namespace+mtprotoSessionCollection).Doc(t.ID))
+	}
+	return t, nil
+}
+
+// initTenants 建立主要機器人與設定檔中 bots 列出的其他機器人；其他機器人未設定的 Google 欄位沿用主要機器人的值
+func initTenants(cfg *Config) error {
+	t, err := newTenant(cfg, cfg.TelegramBotToken, "", cfg.Google)
+	if err != nil {
+		return fmt.Errorf("failed to create bot API: %v", err)
+	}
+	defaultTenant = t
+	tenants[t.ID] = t
+
+	for _, b := range cfg.Bots {
+		google := b.Google
+		if google.ClientID == "" {
+			google.ClientID, google.ClientSecret = cfg.Google.ClientID, cfg.Google.ClientSecret
+		}
+		if google.RedirectURL == "" {
+			google.RedirectURL = cfg.Google.RedirectURL
+		}
+		t, err := newTenant(cfg, b.TelegramBotToken, b.Namespace, google)
+		if err != nil {
+			return fmt.Errorf("failed to create bot API for namespace %s: %v", b.Namespace, err)
+		}
+		if _, ok := tenants[t.ID]; ok {
+			return fmt.Errorf("bot %s is configured more than once", t.ID)
+		}
+		tenants[t.ID] = t
+		slog.Info("Registered bot", "bot_id", t.ID, "username", t.Bot.Self.UserName, "namespace", b.Namespace)
+	}
+	return nil
+}
+
+// tenantWebhookHandler 處理 /webhook/{botID}，依路徑選擇機器人後交給 webhookHandler
+func tenantWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	t, ok := tenants[r.PathValue("botID")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	webhookHandler(w, r.WithContext(withTenant(r.Context(), t)))
+}
+
+// tenantState 在 OAuth state 前加上機器人 ID，讓共用的 /oauth/callback 知道是哪個機器人的授權
+// 主要機器人不加前綴，與舊版的 state 相容；base64 URL 編碼不會出現句點，因此以句點分隔
+func tenantState(ctx context.Context, state string) string {
+	t := currentTenant(ctx)
+	if t == defaultTenant {
+		return state
+	}
+	return t.ID + "." + state
+}
+
+// tenantFromState 回傳 state 所屬的機器人；沒有前綴時為主要機器人，前綴無法辨識時回傳 nil
+func tenantFromState(state string) *tenant {
+	id, _, ok := strings.Cut(state, ".")
+	if !ok {
+		return defaultTenant
+	}
+	return tenants[id]
+}
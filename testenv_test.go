@@ -0,0 +1,574 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// --- 測試環境 ---
+
+// 假的 Bot Token，冒號前是機器人 ID
+const testBotToken = "123456:TEST-TOKEN"
+
+// testEnv 是不需要真正的 Telegram 與 Google Cloud 的測試環境：
+// Telegram Bot API 與 Google（OAuth 權杖與 Drive API）都由本機的假伺服器提供，
+// 資料預設存放在 memoryStore，設定 FIRESTORE_EMULATOR_HOST 時改用 Firestore 模擬器
+type testEnv struct {
+	tenant   *tenant
+	config   *Config // 機器人使用的設定，測試可以直接修改
+	telegram *fakeTelegram
+	google   *fakeGoogle
+}
+
+// newTestEnv 建立測試環境並替換相關的全域變數，測試結束時恢復
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	env := &testEnv{telegram: newFakeTelegram(t), google: newFakeGoogle(t)}
+
+	namespace := ""
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") != "" {
+		s := newEmulatorStore(t)
+		previous := store
+		store = s
+		t.Cleanup(func() { store = previous })
+		// 模擬器中的資料會保留到下一次執行，以命名空間隔開
+		namespace = strings.TrimSuffix(testNamespace(), "_")
+	} else {
+		useMemoryStore(t)
+	}
+
+	// 假的 Telegram 伺服器與 Local Bot API Server 使用相同的路徑，下載上限仍維持 Bot API 的預設值
+	env.config = defaultConfig()
+	env.config.TelegramAPIURL = env.telegram.URL
+	env.config.MaxFileSizeMB = cloudMaxFileSizeMB
+	tn, err := newTenant(env.config, testBotToken, namespace, OAuthClientConfig{
+		ClientID:     "test-client",
+		ClientSecret: "test-secret",
+		RedirectURL:  "https://tg-helper.example.com/oauth/callback",
+	})
+	if err != nil {
+		t.Fatalf("newTenant: %v", err)
+	}
+	tn.OAuth.Endpoint = oauth2.Endpoint{AuthURL: env.google.URL + "/auth", TokenURL: env.google.URL + "/token"}
+	env.tenant = tn
+
+	previousDefault, previousTenants := defaultTenant, tenants
+	previousDriveEndpoint, previousDestinations := driveEndpoint, destinations
+	defaultTenant, tenants = tn, map[string]*tenant{tn.ID: tn}
+	driveEndpoint = env.google.URL + "/drive/v3/"
+	destinations = map[string]Destination{}
+	registerDestination(driveDestination{})
+	t.Cleanup(func() {
+		defaultTenant, tenants = previousDefault, previousTenants
+		driveEndpoint, destinations = previousDriveEndpoint, previousDestinations
+	})
+	return env
+}
+
+// connectDrive 替使用者存入一組有效的 Google 權杖，模擬已經完成 /connect_drive
+func (env *testEnv) connectDrive(t *testing.T, userID int64) {
+	t.Helper()
+	token := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer", Expiry: time.Now().Add(time.Hour)}
+	ctx := withTenant(t.Context(), env.tenant)
+	if err := saveGoogleAccount(ctx, newUserToken(userID, "user@example.com", token)); err != nil {
+		t.Fatalf("saveGoogleAccount: %v", err)
+	}
+}
+
+// --- 假的 Telegram Bot API ---
+
+// fakeTelegram 回應 Bot API 的呼叫並記錄送出的訊息；/file/ 下提供以 addFile 加入的檔案
+type fakeTelegram struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	files  map[string][]byte // file_id → 內容
+	sent   []sentMessage
+	nextID int
+}
+
+// sentMessage 是機器人送出或編輯的一則訊息
+type sentMessage struct {
+	Method string
+	ChatID int64
+	Text   string
+}
+
+func newFakeTelegram(t *testing.T) *fakeTelegram {
+	f := &fakeTelegram{files: map[string][]byte{}, nextID: 1000}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// addFile 讓 getFile 與下載可以取得 fileID 的內容
+func (f *fakeTelegram) addFile(fileID string, content []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[fileID] = content
+}
+
+// messages 回傳送到 chatID 的所有訊息文字
+func (f *fakeTelegram) messages(chatID int64) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var texts []string
+	for _, m := range f.sent {
+		if m.ChatID == chatID {
+			texts = append(texts, m.Text)
+		}
+	}
+	return texts
+}
+
+func (f *fakeTelegram) serve(w http.ResponseWriter, r *http.Request) {
+	if rest, ok := strings.CutPrefix(r.URL.Path, "/file/bot"+testBotToken+"/"); ok {
+		f.mu.Lock()
+		content, ok := f.files[strings.TrimPrefix(rest, "documents/")]
+		f.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+		return
+	}
+	method, ok := strings.CutPrefix(r.URL.Path, "/bot"+testBotToken+"/")
+	if !ok {
+		writeTelegramError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		r.ParseMultipartForm(32 << 20)
+	} else {
+		r.ParseForm()
+	}
+	chatID := formInt(r.Form, "chat_id")
+
+	switch method {
+	case "getMe":
+		writeTelegramResult(w, map[string]interface{}{"id": 123456, "is_bot": true, "first_name": "Test", "username": "test_bot"})
+	case "getFile":
+		fileID := r.Form.Get("file_id")
+		f.mu.Lock()
+		content, ok := f.files[fileID]
+		f.mu.Unlock()
+		if !ok {
+			writeTelegramError(w, http.StatusBadRequest, "Bad Request: invalid file_id")
+			return
+		}
+		writeTelegramResult(w, map[string]interface{}{"file_id": fileID, "file_unique_id": "u-" + fileID, "file_size": len(content), "file_path": "documents/" + fileID})
+	case "sendMessage", "editMessageText", "sendDocument", "sendPhoto":
+		f.mu.Lock()
+		f.sent = append(f.sent, sentMessage{Method: method, ChatID: chatID, Text: r.Form.Get("text")})
+		f.nextID++
+		id := f.nextID
+		f.mu.Unlock()
+		writeTelegramResult(w, map[string]interface{}{
+			"message_id": id,
+			"date":       time.Now().Unix(),
+			"chat":       map[string]interface{}{"id": chatID, "type": "private"},
+			"text":       r.Form.Get("text"),
+		})
+	default:
+		writeTelegramResult(w, true)
+	}
+}
+
+func formInt(form url.Values, key string) int64 {
+	var n int64
+	fmt.Sscan(form.Get(key), &n)
+	return n
+}
+
+func writeTelegramResult(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+func writeTelegramError(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error_code": code, "description": description})
+}
+
+// --- 假的 Google OAuth 與 Drive API ---
+
+// fakeGoogle 兌換授權碼 validAuthCode，並接受 Drive 的資料夾查詢、建立與檔案上傳
+type fakeGoogle struct {
+	*httptest.Server
+
+	mu sync.Mutex
+	// uploadStatus 不為 0 時，檔案上傳以這個狀態碼與 uploadReason 失敗
+	uploadStatus int
+	uploadReason string
+	uploads      []driveUpload
+	nextID       int
+}
+
+// driveUpload 是一個上傳到假 Drive 的檔案
+type driveUpload struct {
+	Name     string
+	MimeType string
+	Parents  []string
+	Content  []byte
+}
+
+const validAuthCode = "valid-code"
+
+func newFakeGoogle(t *testing.T) *fakeGoogle {
+	f := &fakeGoogle{}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+// failUploads 讓之後的上傳以 Drive API 的錯誤失敗，例如 403 與 storageQuotaExceeded
+func (f *fakeGoogle) failUploads(status int, reason string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.uploadStatus, f.uploadReason = status, reason
+}
+
+func (f *fakeGoogle) uploaded() []driveUpload {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]driveUpload(nil), f.uploads...)
+}
+
+func (f *fakeGoogle) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.URL.Path == "/token":
+		r.ParseForm()
+		if r.Form.Get("grant_type") == "authorization_code" && r.Form.Get("code") != validAuthCode {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token":  "access",
+			"refresh_token": "refresh",
+			"token_type":    "Bearer",
+			"expires_in":    3600,
+			"scope":         strings.Join(baseGoogleScopes(), " "),
+		})
+	case r.URL.Path == "/drive/v3/about":
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"user":         map[string]string{"emailAddress": "user@example.com"},
+			"storageQuota": map[string]string{"usage": "0", "limit": "16106127360"},
+		})
+	case r.URL.Path == "/drive/v3/files" && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(map[string]interface{}{"files": []interface{}{}})
+	case r.URL.Path == "/drive/v3/files" && r.Method == http.MethodPost:
+		var folder struct {
+			Name string `json:"name"`
+		}
+		json.NewDecoder(r.Body).Decode(&folder)
+		json.NewEncoder(w).Encode(map[string]string{"id": f.newID("folder"), "name": folder.Name})
+	case r.URL.Path == "/upload/drive/v3/files":
+		f.serveUpload(w, r)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": 404, "message": "not found: " + r.URL.Path}})
+	}
+}
+
+func (f *fakeGoogle) newID(prefix string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	return fmt.Sprintf("%s-%d", prefix, f.nextID)
+}
+
+// serveUpload 解析 multipart 上傳的中繼資料與內容
+func (f *fakeGoogle) serveUpload(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	status, reason := f.uploadStatus, f.uploadReason
+	f.mu.Unlock()
+	if status != 0 {
+		io.Copy(io.Discard, r.Body)
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{
+			"code":    status,
+			"message": reason,
+			"errors":  []map[string]string{{"reason": reason, "message": reason}},
+		}})
+		return
+	}
+
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	var upload driveUpload
+	for i := 0; ; i++ {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if i == 0 {
+			var meta struct {
+				Name    string   `json:"name"`
+				Parents []string `json:"parents"`
+			}
+			json.NewDecoder(part).Decode(&meta)
+			upload.Name, upload.Parents = meta.Name, meta.Parents
+			continue
+		}
+		upload.MimeType = part.Header.Get("Content-Type")
+		upload.Content, _ = io.ReadAll(part)
+	}
+
+	id := f.newID("file")
+	f.mu.Lock()
+	f.uploads = append(f.uploads, upload)
+	f.mu.Unlock()
+	json.NewEncoder(w).Encode(map[string]string{
+		"id":          id,
+		"name":        upload.Name,
+		"webViewLink": "https://drive.google.com/file/d/" + id + "/view",
+	})
+}
+
+// --- handler 單元測試用的假實作 ---
+
+// handlerEnv 直接呼叫 handler 的測試環境：Telegram 與 Drive 都以 MessageSender、FileURLGetter 與
+// DriveUploader 的假實作取代，不經過 HTTP；資料存放在 memoryStore
+type handlerEnv struct {
+	ctx    context.Context
+	config *Config // 機器人使用的設定，測試可以直接修改
+	sender *fakeSender
+	files  *fakeFiles
+	drive  *fakeDriveUploader
+}
+
+// newHandlerEnv 建立測試環境並替換相關的全域變數，測試結束時恢復
+func newHandlerEnv(t *testing.T) *handlerEnv {
+	t.Helper()
+	useMemoryStore(t)
+	env := &handlerEnv{config: defaultConfig(), sender: &fakeSender{}, files: newFakeFiles(t), drive: &fakeDriveUploader{}}
+	tn := &tenant{
+		ID:     "123456",
+		Bot:    &tgbotapi.BotAPI{Token: testBotToken},
+		Sender: env.sender,
+		Files:  env.files,
+		OAuth: newGoogleOAuthConfig(OAuthClientConfig{
+			ClientID:     "test-client",
+			ClientSecret: "test-secret",
+			RedirectURL:  "https://tg-helper.example.com/oauth/callback",
+		}),
+		Config: env.config,
+	}
+	env.ctx = withTenant(t.Context(), tn)
+
+	previousDefault, previousTenants := defaultTenant, tenants
+	previousDestinations, previousUploader := destinations, newDriveUploader
+	defaultTenant, tenants = tn, map[string]*tenant{tn.ID: tn}
+	destinations = map[string]Destination{}
+	registerDestination(driveDestination{})
+	newDriveUploader = func(context.Context, *oauth2.Token) (DriveUploader, error) { return env.drive, nil }
+	t.Cleanup(func() {
+		defaultTenant, tenants = previousDefault, previousTenants
+		destinations, newDriveUploader = previousDestinations, previousUploader
+	})
+	return env
+}
+
+// connectDrive 替使用者存入一組 Google 權杖，模擬已經完成 /connect_drive
+func (env *handlerEnv) connectDrive(t *testing.T, userID int64) {
+	t.Helper()
+	token := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh", TokenType: "Bearer", Expiry: time.Now().Add(time.Hour)}
+	if err := saveGoogleAccount(env.ctx, newUserToken(userID, "user@example.com", token)); err != nil {
+		t.Fatalf("saveGoogleAccount: %v", err)
+	}
+}
+
+// fakeSender 記錄所有送出的 Chattable，一律回傳成功
+type fakeSender struct {
+	mu   sync.Mutex
+	sent []tgbotapi.Chattable
+}
+
+func (s *fakeSender) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, c)
+	chatID, _, _ := chattableText(c)
+	return tgbotapi.Message{MessageID: len(s.sent), Date: int(time.Now().Unix()), Chat: &tgbotapi.Chat{ID: chatID}}, nil
+}
+
+func (s *fakeSender) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sent = append(s.sent, c)
+	return &tgbotapi.APIResponse{Ok: true, Result: json.RawMessage("true")}, nil
+}
+
+// messages 回傳送到 chatID 的所有訊息與編輯後的文字
+func (s *fakeSender) messages(chatID int64) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var texts []string
+	for _, c := range s.sent {
+		if id, text, ok := chattableText(c); ok && id == chatID {
+			texts = append(texts, text)
+		}
+	}
+	return texts
+}
+
+// chattableText 取出訊息或編輯訊息的聊天室與文字
+func chattableText(c tgbotapi.Chattable) (int64, string, bool) {
+	switch m := c.(type) {
+	case tgbotapi.MessageConfig:
+		return m.ChatID, m.Text, true
+	case tgbotapi.EditMessageTextConfig:
+		return m.ChatID, m.Text, true
+	}
+	return 0, "", false
+}
+
+// fakeFiles 提供以 addFile 加入的檔案；下載網址指向本機的假伺服器
+type fakeFiles struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	files map[string][]byte // file_id → 內容
+	// getFileErr 不為 nil 時 GetFile 回傳這個錯誤
+	getFileErr error
+	// missing 中的檔案可以查詢，但下載時回傳 404
+	missing map[string]bool
+}
+
+func newFakeFiles(t *testing.T) *fakeFiles {
+	f := &fakeFiles{files: map[string][]byte{}, missing: map[string]bool{}}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeFiles) addFile(fileID string, content []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[fileID] = content
+}
+
+func (f *fakeFiles) GetFile(config tgbotapi.FileConfig) (tgbotapi.File, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.getFileErr != nil {
+		return tgbotapi.File{}, f.getFileErr
+	}
+	content, ok := f.files[config.FileID]
+	if !ok {
+		return tgbotapi.File{}, &tgbotapi.Error{Code: http.StatusBadRequest, Message: "Bad Request: invalid file_id"}
+	}
+	return tgbotapi.File{FileID: config.FileID, FileUniqueID: "u-" + config.FileID, FileSize: len(content), FilePath: "documents/" + config.FileID}, nil
+}
+
+func (f *fakeFiles) FileURL(file tgbotapi.File) string {
+	return f.URL + "/" + file.FilePath
+}
+
+func (f *fakeFiles) serve(w http.ResponseWriter, r *http.Request) {
+	fileID := strings.TrimPrefix(r.URL.Path, "/documents/")
+	f.mu.Lock()
+	content, ok := f.files[fileID]
+	missing := f.missing[fileID]
+	f.mu.Unlock()
+	if !ok || missing {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(content)
+}
+
+// fakeMTProto 以記憶體中的內容代替 MTProto 下載
+type fakeMTProto struct {
+	files map[string][]byte // file_id → 內容
+	// openErr 不為 nil 時 Open 回傳這個錯誤
+	openErr error
+}
+
+func (m *fakeMTProto) Open(ctx context.Context, fileID string) (io.ReadCloser, error) {
+	if m.openErr != nil {
+		return nil, m.openErr
+	}
+	content, ok := m.files[fileID]
+	if !ok {
+		return nil, errors.New("file not found")
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// useMTProto 讓機器人改以 fake 下載檔案，並依照設定 TELEGRAM_API_ID 時的上限檢查大小
+func (env *handlerEnv) useMTProto(m *fakeMTProto) {
+	env.config.MTProto = MTProtoConfig{APIID: 12345, APIHash: "hash"}
+	currentTenant(env.ctx).MTProto = m
+}
+
+// fakeDriveUploader 將上傳的檔案留在記憶體中
+type fakeDriveUploader struct {
+	mu sync.Mutex
+	// createErr 不為 nil 時 CreateFile 讀完內容後回傳這個錯誤
+	createErr error
+	// quota 是 StorageQuota 回傳的用量，nil 代表沒有上限
+	quota   *drive.AboutStorageQuota
+	uploads []driveUpload
+}
+
+func (d *fakeDriveUploader) EnsureFolder(ctx context.Context, segments []string) (string, error) {
+	return "folder-" + strings.Join(segments, "-"), nil
+}
+
+func (d *fakeDriveUploader) CreateFile(ctx context.Context, file *drive.File, media io.Reader, options ...googleapi.MediaOption) (*drive.File, error) {
+	content, err := io.ReadAll(media)
+	if err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.createErr != nil {
+		return nil, d.createErr
+	}
+	d.uploads = append(d.uploads, driveUpload{Name: file.Name, MimeType: file.MimeType, Parents: file.Parents, Content: content})
+	id := fmt.Sprintf("file-%d", len(d.uploads))
+	return &drive.File{Id: id, Name: file.Name, WebViewLink: "https://drive.google.com/file/d/" + id + "/view"}, nil
+}
+
+func (d *fakeDriveUploader) StorageQuota(ctx context.Context) (*drive.AboutStorageQuota, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.quota, nil
+}
+
+func (d *fakeDriveUploader) uploaded() []driveUpload {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]driveUpload(nil), d.uploads...)
+}
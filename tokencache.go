@@ -0,0 +1,121 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// --- 權杖快取 ---
+
+// tokenCache 是放在 Firestore 權杖紀錄前的 LRU 快取，讓常用的使用者不必每則訊息都讀一次 Firestore
+// 快取只存在單一執行個體的記憶體中；其他執行個體更新權杖時，最久要等到 TTL 過期才會看到
+type tokenCache struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	items map[string]*list.Element
+	order *list.List // 最近使用的在最前面
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type tokenCacheEntry struct {
+	key      string
+	token    UserToken
+	expireAt time.Time
+}
+
+// userTokenCache 為 nil 時代表不使用快取
+var userTokenCache *tokenCache
+
+// initTokenCache 設定權杖快取，大小或 TTL 為 0 時停用
+func initTokenCache(cfg TokenCacheConfig) {
+	if cfg.Size <= 0 || cfg.TTL <= 0 {
+		userTokenCache = nil
+		return
+	}
+	userTokenCache = &tokenCache{
+		size:  cfg.Size,
+		ttl:   cfg.TTL,
+		items: map[string]*list.Element{},
+		order: list.New(),
+	}
+}
+
+func tokenCacheKey(collection, docID string) string {
+	return collection + "/" + docID
+}
+
+// get 回傳快取中的權杖副本，不存在或已過期時回傳 false
+func (c *tokenCache) get(collection, docID string) (*UserToken, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[tokenCacheKey(collection, docID)]
+	if !ok {
+		c.misses.Add(1)
+		return nil, false
+	}
+	entry := el.Value.(*tokenCacheEntry)
+	if time.Now().After(entry.expireAt) {
+		c.removeElement(el)
+		c.misses.Add(1)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	c.hits.Add(1)
+	token := entry.token
+	return &token, true
+}
+
+// put 存入權杖的副本，超過大小時移除最久沒有使用的項目
+func (c *tokenCache) put(collection, docID string, token *UserToken) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := tokenCacheKey(collection, docID)
+	entry := &tokenCacheEntry{key: key, token: *token, expireAt: time.Now().Add(c.ttl)}
+	if el, ok := c.items[key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// invalidate 移除快取中的權杖，需在刪除或無法確定 Firestore 內容時呼叫
+func (c *tokenCache) invalidate(collection, docID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[tokenCacheKey(collection, docID)]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *tokenCache) removeElement(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.items, el.Value.(*tokenCacheEntry).key)
+}
+
+// stats 回傳啟動以來的命中與未命中次數
+func (c *tokenCache) stats() (hits, misses int64) {
+	if c == nil {
+		return 0, 0
+	}
+	return c.hits.Load(), c.misses.Load()
+}
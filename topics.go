@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 論壇主題 ---
+
+// Firestore 集合名稱
+const forumTopicCollection = "forum_topics"
+
+// forumTopic 是訊息所在的論壇主題；目前使用的 telegram-bot-api 版本沒有這些欄位，因此從原始的 update 解析
+type forumTopic struct {
+	ThreadID int
+	Name     string // 只有在訊息帶有主題建立或修改資訊時才會有值
+}
+
+// rawTopicMessage 只解析 update 中與論壇主題相關的欄位
+type rawTopicMessage struct {
+	MessageThreadID   int  `json:"message_thread_id"`
+	IsTopicMessage    bool `json:"is_topic_message"`
+	ForumTopicCreated *struct {
+		Name string `json:"name"`
+	} `json:"forum_topic_created"`
+	ForumTopicEdited *struct {
+		Name string `json:"name"`
+	} `json:"forum_topic_edited"`
+	ReplyToMessage *struct {
+		ForumTopicCreated *struct {
+			Name string `json:"name"`
+		} `json:"forum_topic_created"`
+	} `json:"reply_to_message"`
+}
+
+// parseForumTopic 從原始 update 取出訊息所在的主題，不在主題中時回傳 nil
+func parseForumTopic(body []byte) *forumTopic {
+	var raw struct {
+		Message *rawTopicMessage `json:"message"`
+	}
+	if err := json.Unmarshal(body, &raw); err != nil || raw.Message == nil || !raw.Message.IsTopicMessage {
+		return nil
+	}
+	m := raw.Message
+	topic := &forumTopic{ThreadID: m.MessageThreadID}
+	switch {
+	case m.ForumTopicEdited != nil && m.ForumTopicEdited.Name != "":
+		topic.Name = m.ForumTopicEdited.Name
+	case m.ForumTopicCreated != nil:
+		topic.Name = m.ForumTopicCreated.Name
+	case m.ReplyToMessage != nil && m.ReplyToMessage.ForumTopicCreated != nil:
+		// 主題中不是回覆其他訊息的訊息，reply_to_message 會是主題的建立訊息
+		topic.Name = m.ReplyToMessage.ForumTopicCreated.Name
+	}
+	return topic
+}
+
+type forumTopicKey struct{}
+
+func withForumTopic(ctx context.Context, topic *forumTopic) context.Context {
+	return context.WithValue(ctx, forumTopicKey{}, topic)
+}
+
+func forumTopicFromContext(ctx context.Context) *forumTopic {
+	topic, _ := ctx.Value(forumTopicKey{}).(*forumTopic)
+	return topic
+}
+
+func forumTopicDocID(chatID int64, threadID int) string {
+	return fmt.Sprintf("%d_%d", chatID, threadID)
+}
+
+// rememberForumTopic 記住主題名稱，之後同一個主題中的訊息即使沒有帶名稱也能使用
+func rememberForumTopic(ctx context.Context, chatID int64, topic *forumTopic) {
+	err := collection(ctx, forumTopicCollection).Doc(forumTopicDocID(chatID, topic.ThreadID)).Set(ctx, map[string]interface{}{
+		"chat_id":    chatID,
+		"thread_id":  topic.ThreadID,
+		"name":       topic.Name,
+		"updated_at": time.Now(),
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to save forum topic", "error", err)
+	}
+}
+
+// forumTopicName 回傳訊息所在主題的名稱；不在主題中時回傳空字串，從未見過主題名稱時以主題 ID 代替
+func forumTopicName(ctx context.Context, chatID int64) string {
+	topic := forumTopicFromContext(ctx)
+	if topic == nil {
+		return ""
+	}
+	if topic.Name != "" {
+		return topic.Name
+	}
+	doc, err := collection(ctx, forumTopicCollection).Doc(forumTopicDocID(chatID, topic.ThreadID)).Get(ctx)
+	if err == nil {
+		if name, ok := doc.Data()["name"].(string); ok && name != "" {
+			return name
+		}
+	} else if !errors.Is(err, errDocNotFound) {
+		slog.WarnContext(ctx, "Failed to load forum topic", "error", err)
+	}
+	return fmt.Sprintf("topic-%d", topic.ThreadID)
+}
+
+// sendMessage 以限速的方式送出訊息；訊息來自論壇主題時回覆到同一個主題，否則 Telegram 會把回覆放到「一般」主題
+func sendMessage(ctx context.Context, msg tgbotapi.MessageConfig) (tgbotapi.Message, error) {
+	topic := forumTopicFromContext(ctx)
+	if topic == nil {
+		return sendChattable(ctx, msg.ChatID, msg)
+	}
+
+	params := tgbotapi.Params{}
+	params.AddNonZero64("chat_id", msg.ChatID)
+	params.AddNonEmpty("text", msg.Text)
+	params.AddNonEmpty("parse_mode", msg.ParseMode)
+	params.AddNonZero("reply_to_message_id", msg.ReplyToMessageID)
+	params.AddNonZero("message_thread_id", topic.ThreadID)
+	if err := params.AddInterface("reply_markup", msg.ReplyMarkup); err != nil {
+		return tgbotapi.Message{}, err
+	}
+	var sent tgbotapi.Message
+	err := callTelegram(ctx, msg.ChatID, func() error {
+		resp, err := botFor(ctx).MakeRequest("sendMessage", params)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(resp.Result, &sent)
+	})
+	return sent, err
+}
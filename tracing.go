@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/oauth"
+)
+
+// --- 分散式追蹤 ---
+
+// Cloud Trace 的 OTLP 端點
+const cloudTraceEndpoint = "telemetry.googleapis.com:443"
+
+var tracer = otel.Tracer("tg-helper")
+
+// instrumentedClient 是會替每個請求建立 span 的 HTTP client，用於呼叫 Drive 等外部 API；下載 Telegram 檔案改用設有時限的 telegramClient
+var instrumentedClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+// initTracing 在 ENABLE_TRACING=true 時設定 OpenTelemetry，將 span 匯出到 Cloud Trace
+// 若設定了 OTEL_EXPORTER_OTLP_ENDPOINT，則改匯出到指定的 OTLP collector
+// 回傳的函式需在程式結束前呼叫，以送出尚未匯出的 span
+func initTracing(ctx context.Context, projectID string, cfg TracingConfig) (func(context.Context) error, error) {
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var opts []otlptracegrpc.Option
+	if cfg.OTLPEndpoint != "" {
+		opts = append(opts, otlptracegrpc.WithEndpointURL(cfg.OTLPEndpoint))
+	} else {
+		creds, err := oauth.NewApplicationDefault(ctx, "https://www.googleapis.com/auth/trace.append")
+		if err != nil {
+			return nil, fmt.Errorf("failed to load default credentials for tracing: %v", err)
+		}
+		opts = append(opts,
+			otlptracegrpc.WithEndpoint(cloudTraceEndpoint),
+			otlptracegrpc.WithDialOption(grpc.WithPerRPCCredentials(creds)),
+			otlptracegrpc.WithHeaders(map[string]string{"x-goog-user-project": projectID}),
+		)
+	}
+	exporter, err := otlptracegrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %v", err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			semconv.ServiceName("tg-helper"),
+			semconv.CloudProviderGCP,
+			semconv.CloudAccountID(projectID),
+			attribute.String("gcp.project_id", projectID),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %v", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// startSpan 建立一個子 span；呼叫端負責以 endSpan 結束
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name)
+}
+
+// endSpan 結束 span，並在發生錯誤時標記狀態
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// withTracedHTTPClient 讓 oauth2 產生的 client 沿用 instrumentedClient 的 transport
+func withTracedHTTPClient(ctx context.Context) context.Context {
+	return context.WithValue(ctx, oauth2.HTTPClient, instrumentedClient)
+}
+
+// traceLogAttrs 回傳 Cloud Logging 用來關聯日誌與追蹤的欄位
+func traceLogAttrs(ctx context.Context) (string, string, bool) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return "", "", false
+	}
+	projectID := configFor(ctx).GCPProjectID
+	if projectID == "" {
+		return "", "", false
+	}
+	return fmt.Sprintf("projects/%s/traces/%s", projectID, sc.TraceID()), sc.SpanID().String(), true
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"google.golang.org/api/speech/v1"
+)
+
+// --- 語音轉文字 ---
+
+const (
+	// 同步辨識只接受一分鐘以內的音訊，更長的語音改用長時間辨識並輪詢結果
+	maxSyncRecognizeDuration = 60
+	// 直接傳送內容時，音訊最大 10 MB
+	maxTranscriptionAudioSize = 10 * 1024 * 1024
+	// 長時間辨識最多等待的時間
+	longRecognizeTimeout = 10 * time.Minute
+	// Telegram 訊息最多 4096 個字元，逐字稿太長時回覆中只顯示開頭
+	maxTranscriptReplyRunes = 3500
+)
+
+var speechService *speech.Service
+
+// Speech-to-Text 的語言代碼；使用者的語言不在表中時以繁體中文辨識，並允許英文
+var speechLanguageCodes = map[string]string{
+	"zh":    "cmn-Hant-TW",
+	"zh-TW": "cmn-Hant-TW",
+	"zh-HK": "yue-Hant-HK",
+	"zh-CN": "cmn-Hans-CN",
+	"en":    "en-US",
+	"ja":    "ja-JP",
+	"ko":    "ko-KR",
+}
+
+// initTranscription 在 ENABLE_TRANSCRIPTION 開啟時建立 Speech-to-Text 用戶端，並註冊語音訊息的轉錄步驟
+func initTranscription(ctx context.Context, cfg AIConfig) error {
+	if !cfg.Transcription {
+		return nil
+	}
+	svc, err := speech.NewService(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create speech service: %v", err)
+	}
+	speechService = svc
+	registerPostProcessor(&postProcessor{
+		name: "transcription",
+		wants: func(settings *UserSettings, file *telegramFile) bool {
+			return settings.TranscribeVoice && file.Type == "voice" && file.Size <= maxTranscriptionAudioSize
+		},
+		process: processTranscription,
+	})
+	return nil
+}
+
+// processTranscription 將語音訊息轉成文字，回覆逐字稿並存成與音訊同一個資料夾的 .txt
+func processTranscription(ctx context.Context, u *uploadedFile) error {
+	if len(u.Content) == 0 || len(u.Content) > maxTranscriptionAudioSize {
+		return nil
+	}
+	text, err := transcribeVoice(ctx, u.Content, u.File.Duration, userLanguage(ctx))
+	if err != nil {
+		if !u.Silent {
+			replyToUser(ctx, u.NotifyChatID, u.ReplyTo, "語音轉文字失敗，音訊仍已上傳。")
+		}
+		return err
+	}
+	if text == "" {
+		if !u.Silent {
+			replyToUser(ctx, u.NotifyChatID, u.ReplyTo, "沒有辨識到語音內容。")
+		}
+		return nil
+	}
+
+	if _, err := saveSidecarText(ctx, u, text); err != nil {
+		return fmt.Errorf("failed to save transcript: %v", err)
+	}
+	if !u.Silent {
+		reply := text
+		if utf8.RuneCountInString(reply) > maxTranscriptReplyRunes {
+			reply = string([]rune(reply)[:maxTranscriptReplyRunes]) + "…"
+		}
+		replyToUser(ctx, u.NotifyChatID, u.ReplyTo, fmt.Sprintf("逐字稿（已存成 %s.txt）：\n%s", u.Result.Name, reply))
+	}
+	return nil
+}
+
+// transcribeVoice 辨識 Telegram 語音訊息（OGG Opus）；duration 超過一分鐘時使用長時間辨識
+func transcribeVoice(ctx context.Context, audio []byte, duration int, lang string) (string, error) {
+	code, ok := speechLanguageCodes[lang]
+	if !ok {
+		code = speechLanguageCodes[defaultLanguage]
+	}
+	config := &speech.RecognitionConfig{
+		Encoding:                   "OGG_OPUS",
+		SampleRateHertz:            48000,
+		LanguageCode:               code,
+		EnableAutomaticPunctuation: true,
+	}
+	if code != "en-US" {
+		config.AlternativeLanguageCodes = []string{"en-US"}
+	}
+	audioContent := &speech.RecognitionAudio{Content: base64.StdEncoding.EncodeToString(audio)}
+
+	ctx, span := startSpan(ctx, "speech.recognize")
+	var results []*speech.SpeechRecognitionResult
+	var err error
+	if duration <= maxSyncRecognizeDuration {
+		var resp *speech.RecognizeResponse
+		resp, err = speechService.Speech.Recognize(&speech.RecognizeRequest{Config: config, Audio: audioContent}).Context(ctx).Do()
+		if resp != nil {
+			results = resp.Results
+		}
+	} else {
+		results, err = longRunningRecognize(ctx, config, audioContent)
+	}
+	endSpan(span, err)
+	if err != nil {
+		return "", fmt.Errorf("failed to recognize speech: %v", err)
+	}
+
+	var sb strings.Builder
+	for _, r := range results {
+		if len(r.Alternatives) > 0 {
+			sb.WriteString(r.Alternatives[0].Transcript)
+		}
+	}
+	return strings.TrimSpace(sb.String()), nil
+}
+
+// longRunningRecognize 建立長時間辨識的作業，每五秒查詢一次直到完成
+func longRunningRecognize(ctx context.Context, config *speech.RecognitionConfig, audio *speech.RecognitionAudio) ([]*speech.SpeechRecognitionResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, longRecognizeTimeout)
+	defer cancel()
+
+	op, err := speechService.Speech.Longrunningrecognize(&speech.LongRunningRecognizeRequest{Config: config, Audio: audio}).Context(ctx).Do()
+	for err == nil && !op.Done {
+		select {
+		case <-time.After(5 * time.Second):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		op, err = speechService.Operations.Get(op.Name).Context(ctx).Do()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if op.Error != nil {
+		return nil, errors.New(op.Error.Message)
+	}
+	var resp speech.LongRunningRecognizeResponse
+	if err := json.Unmarshal(op.Response, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode recognize response: %v", err)
+	}
+	return resp.Results, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 垃圾桶 ---
+
+// /trash 與 /restore 最多列出的上傳紀錄數
+const maxTrashChoices = 8
+
+// 處理 /trash 指令：列出最近的上傳，點選後將檔案移到目的地的垃圾桶
+// 也可以回覆上傳的檔案或確認訊息並輸入 /trash，直接移除該檔案
+func handleTrash(ctx context.Context, message *tgbotapi.Message) {
+	if message.ReplyToMessage != nil {
+		record, err := findUploadRecord(ctx, message.Chat.ID, message.ReplyToMessage.MessageID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to find upload record", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取上傳紀錄時發生錯誤，請稍後再試。")
+			return
+		}
+		if record == nil || record.UserID != message.From.ID {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "找不到您上傳這個檔案的紀錄。")
+			return
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, setUploadTrashed(ctx, record, true))
+		return
+	}
+	promptTrashChoices(ctx, message, false)
+}
+
+// 處理 /restore 指令：列出已移到垃圾桶的上傳，點選後還原
+func handleRestore(ctx context.Context, message *tgbotapi.Message) {
+	promptTrashChoices(ctx, message, true)
+}
+
+// promptTrashChoices 以按鈕列出可以移到垃圾桶（trashed 為 false）或可以還原（trashed 為 true）的上傳紀錄
+func promptTrashChoices(ctx context.Context, message *tgbotapi.Message, trashed bool) {
+	// 多讀一些紀錄，略過不支援垃圾桶的目的地後仍能列出足夠的選項
+	// 舊的紀錄沒有 trashed 欄位，查詢 trashed == false 會漏掉它們，因此只在還原時以欄位查詢
+	q := collection(ctx, uploadCollection).Where("user_id", "==", message.From.ID)
+	if trashed {
+		q = q.Where("trashed", "==", true)
+	}
+	docs, err := q.OrderBy("created_at", Desc).Limit(maxTrashChoices * 3).GetAll(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list uploads", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取上傳紀錄時發生錯誤，請稍後再試。")
+		return
+	}
+
+	kind := "trash"
+	if trashed {
+		kind = "restore"
+	}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, doc := range docs {
+		var record UploadRecord
+		if err := doc.DataTo(&record); err != nil {
+			continue
+		}
+		if _, ok := destinations[record.Destination].(trashDestination); !ok || record.Trashed != trashed {
+			continue
+		}
+		label := fmt.Sprintf("%s（%s）", record.Name, record.CreatedAt.Format("01/02 15:04"))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(label, encodeCallbackData(kind, doc.Ref.ID))))
+		if len(rows) == maxTrashChoices {
+			break
+		}
+	}
+	if len(rows) == 0 {
+		text := "沒有可以移到垃圾桶的上傳紀錄。"
+		if trashed {
+			text = "垃圾桶中沒有可以還原的檔案。"
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, text)
+		return
+	}
+
+	text := "請選擇要移到垃圾桶的檔案，之後可以用 /restore 還原："
+	if trashed {
+		text = "請選擇要還原的檔案："
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyToMessageID = message.MessageID
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	if _, err := sendMessage(ctx, msg); err != nil {
+		slog.ErrorContext(ctx, "Failed to send message", "error", err)
+	}
+}
+
+// handleTrashCallback 處理 /trash 與 /restore 的選擇按鈕，args 是上傳紀錄的文件 ID
+func handleTrashCallback(trashed bool) callbackHandler {
+	return func(ctx context.Context, query *tgbotapi.CallbackQuery, args []string) {
+		if len(args) != 1 || query.Message == nil {
+			answerCallback(ctx, query, "")
+			return
+		}
+		doc, err := collection(ctx, uploadCollection).Doc(args[0]).Get(ctx)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to load upload record", "error", err)
+			answerCallback(ctx, query, "找不到這筆上傳紀錄。")
+			return
+		}
+		var record UploadRecord
+		if err := doc.DataTo(&record); err != nil || record.UserID != query.From.ID {
+			answerCallback(ctx, query, "找不到這筆上傳紀錄。")
+			return
+		}
+		answerCallback(ctx, query, "")
+		edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, setUploadTrashed(ctx, &record, trashed))
+		if _, err := sendChattable(ctx, query.Message.Chat.ID, edit); err != nil {
+			slog.WarnContext(ctx, "Failed to update trash message", "error", err)
+		}
+	}
+}
+
+// setUploadTrashed 將上傳的檔案移到垃圾桶或還原，並更新上傳紀錄；回傳要顯示給使用者的結果
+func setUploadTrashed(ctx context.Context, record *UploadRecord, trashed bool) string {
+	dest, ok := destinations[record.Destination].(trashDestination)
+	if !ok {
+		return "這個檔案所在的目的地不支援垃圾桶。"
+	}
+	if record.Account != "" {
+		ctx = withGoogleAccount(ctx, record.Account)
+	}
+	err := dest.SetTrashed(ctx, record.UserID, record.FileID, trashed)
+	if errors.Is(err, errNotConnected) {
+		return "上傳這個檔案的帳號已不再連結。"
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to update trashed state", "file_id", record.FileID, "trashed", trashed, "error", err)
+		return "更新檔案時發生錯誤，請稍後再試。"
+	}
+	err = collection(ctx, uploadCollection).Doc(uploadRecordDocID(record.ChatID, record.MessageID)).Update(ctx, []Update{{Path: "trashed", Value: trashed}})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to update upload record", "error", err)
+	}
+	slog.InfoContext(ctx, "Updated trashed state", "file_id", record.FileID, "trashed", trashed)
+	if trashed {
+		return fmt.Sprintf("已將「%s」移到垃圾桶，輸入 /restore 可以還原。", record.Name)
+	}
+	return fmt.Sprintf("已還原「%s」。", record.Name)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	admin "cloud.google.com/go/firestore/apiv1/admin"
+	"cloud.google.com/go/firestore/apiv1/admin/adminpb"
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// --- Firestore TTL ---
+
+// 短期資料共用的到期欄位，Firestore 的 TTL 政策會在這個時間之後刪除文件
+const ttlField = "expire_at"
+
+// 帶有 expire_at 的短期資料集合
+var ttlCollections = []string{
+	stateCollection,
+	processedUpdateCollection,
+	pendingUploadCollection,
+	conversationCollection,
+	zipSessionCollection,
+	importSessionCollection,
+	migrationCollection,
+	pollCollection,
+	uploadJobCollection,
+	fileRequestCollection,
+}
+
+// 每次清除時每個集合最多刪除的文件數，避免單一請求執行太久；剩下的留給下一次排程
+const maxCleanupPerCollection = 2000
+
+// firestoreEmulator 表示連線到 Firestore 模擬器，模擬器不支援 TTL 政策與 Admin API
+func firestoreEmulator() bool {
+	return os.Getenv("FIRESTORE_EMULATOR_HOST") != ""
+}
+
+// ttlStatus 是一個集合的 TTL 政策狀態
+type ttlStatus struct {
+	Collection string
+	State      string // ACTIVE、CREATING、NEEDS_REPAIR，或剛送出建立請求時為 REQUESTED
+	Err        error
+}
+
+// ensureTTLPolicies 替所有機器人命名空間中的短期資料集合設定 expire_at 的 TTL 政策，已設定的集合保持不變
+// 建立政策是長時間執行的作業，這裡只送出請求，不等待完成
+func ensureTTLPolicies(ctx context.Context) ([]ttlStatus, error) {
+	if _, ok := store.(*firestoreStore); !ok {
+		return nil, errors.New("TTL policies are only available with Firestore storage; use /cron/cleanup instead")
+	}
+	if firestoreEmulator() {
+		return nil, errors.New("the Firestore emulator does not support TTL policies; use /cron/cleanup instead")
+	}
+	client, err := admin.NewFirestoreAdminClient(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create firestore admin client: %v", err)
+	}
+	defer client.Close()
+
+	var statuses []ttlStatus
+	for _, t := range tenants {
+		tctx := withTenant(ctx, t)
+		for _, name := range ttlCollections {
+			s := ttlStatus{Collection: collectionName(tctx, name)}
+			s.State, s.Err = ensureTTLPolicy(ctx, client, s.Collection)
+			statuses = append(statuses, s)
+		}
+	}
+	return statuses, nil
+}
+
+func ensureTTLPolicy(ctx context.Context, client *admin.FirestoreAdminClient, collection string) (string, error) {
+	name := fmt.Sprintf("projects/%s/databases/(default)/collectionGroups/%s/fields/%s", configFor(ctx).GCPProjectID, collection, ttlField)
+	field, err := client.GetField(ctx, &adminpb.GetFieldRequest{Name: name})
+	if err != nil && status.Code(err) != codes.NotFound {
+		return "", err
+	}
+	if field != nil && field.GetTtlConfig() != nil {
+		return field.GetTtlConfig().GetState().String(), nil
+	}
+	_, err = client.UpdateField(ctx, &adminpb.UpdateFieldRequest{
+		Field:      &adminpb.Field{Name: name, TtlConfig: &adminpb.Field_TtlConfig{}},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"ttl_config"}},
+	})
+	if err != nil {
+		return "", err
+	}
+	return "REQUESTED", nil
+}
+
+// setupTTLPolicies 在啟動時於背景設定 TTL 政策，失敗時只記錄日誌
+func setupTTLPolicies(ctx context.Context) {
+	statuses, err := ensureTTLPolicies(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to set up Firestore TTL policies", "error", err)
+		return
+	}
+	for _, s := range statuses {
+		if s.Err != nil {
+			slog.WarnContext(ctx, "Failed to set up Firestore TTL policy", "collection", s.Collection, "error", s.Err)
+			continue
+		}
+		slog.InfoContext(ctx, "Firestore TTL policy", "collection", s.Collection, "state", s.State)
+	}
+}
+
+// handleAdminTTL 處理 /admin ttl：設定並回報各集合的 TTL 政策
+func handleAdminTTL(ctx context.Context, message *tgbotapi.Message) {
+	statuses, err := ensureTTLPolicies(ctx)
+	if err != nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("無法設定 TTL 政策：%v", err))
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString("Firestore TTL 政策（expire_at）：\n")
+	for _, s := range statuses {
+		if s.Err != nil {
+			fmt.Fprintf(&sb, "• %s：設定失敗（%v）\n", s.Collection, s.Err)
+			continue
+		}
+		fmt.Fprintf(&sb, "• %s：%s\n", s.Collection, s.State)
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, strings.TrimSpace(sb.String()))
+}
+
+// deleteExpired 刪除 ctx 中的機器人在短期資料集合中已經過期的文件，回傳刪除的數量
+// 在無法使用 TTL 政策的環境（Firestore 模擬器與 PostgreSQL、SQLite 後端）中取代 TTL；TTL 也可能在過期後 24 小時內才刪除
+func deleteExpired(ctx context.Context, now time.Time) (int, error) {
+	deleted := 0
+	for _, name := range ttlCollections {
+		docs, err := collection(ctx, name).Where(ttlField, "<", now).Limit(maxCleanupPerCollection).GetAll(ctx)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to list expired %s: %v", name, err)
+		}
+		for _, doc := range docs {
+			if err := doc.Ref.Delete(ctx); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// 處理 /cron/cleanup：刪除所有機器人已經過期的短期資料
+func cronCleanupHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorizeCron(w, r) {
+		return
+	}
+	ctx := r.Context()
+	now := time.Now()
+	deleted := 0
+	for _, t := range tenants {
+		n, err := deleteExpired(withTenant(ctx, t), now)
+		deleted += n
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to delete expired documents", "bot_id", t.ID, "error", err)
+			http.Error(w, "failed to delete expired documents", http.StatusInternalServerError)
+			return
+		}
+	}
+	slog.InfoContext(ctx, "Cleanup finished", "deleted", deleted)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"deleted": deleted})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// --- 上傳佇列 ---
+
+// userUploadQueue 以 buffered channel 當作號誌，users 是正在上傳或排隊中的數量，歸零時從 map 移除
+type userUploadQueue struct {
+	slots chan struct{}
+	users int
+}
+
+var (
+	uploadQueuesMu sync.Mutex
+	uploadQueues   = map[int64]*userUploadQueue{}
+)
+
+// acquireUploadSlot 等到使用者有空的上傳名額，回傳的函式用來歸還名額
+// 名額數由 UPLOAD_CONCURRENCY_PER_USER 設定，0 代表不限制；只在單一執行個體的記憶體中計算，不同使用者的上傳不受影響
+// ctx 結束（例如 webhook 請求逾時）時放棄等待並回傳錯誤
+func acquireUploadSlot(ctx context.Context, userID int64) (func(), error) {
+	concurrency := configFor(ctx).RateLimits.UploadConcurrency
+	if concurrency == 0 {
+		return func() {}, nil
+	}
+
+	uploadQueuesMu.Lock()
+	q, ok := uploadQueues[userID]
+	if !ok {
+		q = &userUploadQueue{slots: make(chan struct{}, concurrency)}
+		uploadQueues[userID] = q
+	}
+	q.users++
+	queued := q.users > concurrency
+	uploadQueuesMu.Unlock()
+
+	if queued {
+		slog.InfoContext(ctx, "Waiting for previous uploads to finish")
+	}
+	select {
+	case q.slots <- struct{}{}:
+	case <-ctx.Done():
+		leaveUploadQueue(userID, q)
+		return nil, ctx.Err()
+	}
+	return func() {
+		<-q.slots
+		leaveUploadQueue(userID, q)
+	}, nil
+}
+
+func leaveUploadQueue(userID int64, q *userUploadQueue) {
+	uploadQueuesMu.Lock()
+	defer uploadQueuesMu.Unlock()
+	q.users--
+	if q.users == 0 {
+		delete(uploadQueues, userID)
+	}
+}
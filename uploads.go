@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// --- 上傳紀錄 ---
+
+// Firestore 集合名稱
+const uploadCollection = "uploads"
+
+// UploadRecord 記錄一次成功的上傳，讓使用者之後可以回覆檔案或確認訊息來操作已上傳的檔案
+type UploadRecord struct {
+	UserID               int64       `firestore:"user_id"`
+	ChatID               int64       `firestore:"chat_id"`
+	MessageID            int         `firestore:"message_id"`       // 使用者傳送檔案的訊息
+	ReplyMessageID       int         `firestore:"reply_message_id"` // 機器人的上傳確認訊息，靜默模式下為 0
+	Destination          string      `firestore:"destination"`
+	Account              string      `firestore:"account"`
+	FileID               string      `firestore:"file_id"`
+	Name                 string      `firestore:"name"`
+	Link                 string      `firestore:"link"`
+	TelegramFileUniqueID string      `firestore:"telegram_file_unique_id"` // 用來判斷編輯過的頻道貼文是否換了檔案，以及略過重複的檔案
+	TelegramFileID       string      `firestore:"telegram_file_id"`        // 之後需要重新讀取檔案內容時，用來從 Telegram 再次下載
+	MimeType             string      `firestore:"mime_type"`
+	Size                 int64       `firestore:"size"`
+	Trashed              bool        `firestore:"trashed"`            // 已經以 /trash 移到目的地的垃圾桶
+	Starred              bool        `firestore:"starred"`            // 已經以 /star 或 ⭐ 回應加上星號
+	Meta                 *UploadMeta `firestore:"meta"`               // 上傳時的檔案資訊，編輯說明文字時用來重新渲染檔名
+	FilenameTemplate     string      `firestore:"filename_template"`  // 上傳時使用的檔名範本
+	TrashAt              time.Time   `firestore:"trash_at,omitempty"` // 依綁定的保留天數移到垃圾桶的時間，沒有期限時不寫入
+	CreatedAt            time.Time   `firestore:"created_at"`
+}
+
+// 以聊天室 ID 與檔案訊息 ID 作為文件 ID
+func uploadRecordDocID(chatID int64, messageID int) string {
+	return fmt.Sprintf("%d_%d", chatID, messageID)
+}
+
+func saveUploadRecord(ctx context.Context, record *UploadRecord) error {
+	err := collection(ctx, uploadCollection).Doc(uploadRecordDocID(record.ChatID, record.MessageID)).Set(ctx, record)
+	return err
+}
+
+// findUploadRecord 依使用者回覆的訊息找出上傳紀錄，該訊息可以是原本的檔案或機器人的確認訊息；找不到時回傳 nil
+func findUploadRecord(ctx context.Context, chatID int64, messageID int) (*UploadRecord, error) {
+	var record UploadRecord
+	doc, err := collection(ctx, uploadCollection).Doc(uploadRecordDocID(chatID, messageID)).Get(ctx)
+	if err == nil {
+		if err := doc.DataTo(&record); err != nil {
+			return nil, err
+		}
+		return &record, nil
+	}
+	if !errors.Is(err, errDocNotFound) {
+		return nil, err
+	}
+
+	docs, err := collection(ctx, uploadCollection).
+		Where("chat_id", "==", chatID).
+		Where("reply_message_id", "==", messageID).
+		Limit(1).GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	if err := docs[0].DataTo(&record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// findUploadByFile 找出使用者之前上傳到同一個目的地的相同 Telegram 檔案，沒有時回傳 nil
+func findUploadByFile(ctx context.Context, userID int64, destination, fileUniqueID string) (*UploadRecord, error) {
+	docs, err := collection(ctx, uploadCollection).
+		Where("user_id", "==", userID).
+		Where("telegram_file_unique_id", "==", fileUniqueID).
+		Where("destination", "==", destination).
+		Limit(1).GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) == 0 {
+		return nil, nil
+	}
+	var record UploadRecord
+	if err := docs[0].DataTo(&record); err != nil {
+		return nil, err
+	}
+	// 移到垃圾桶的檔案視為已刪除，重新傳送時會再上傳一次
+	if record.Trashed {
+		return nil, nil
+	}
+	return &record, nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- 個人上傳統計 ---
+
+// Firestore 集合名稱
+const usageCollection = "user_usage"
+
+// 每月統計中保留的最大檔案數
+const usageTopFiles = 3
+
+// UserUsage 是使用者在一段期間（UTC 的某一天或某個月）的上傳統計
+type UserUsage struct {
+	UserID   int64       `firestore:"user_id"`
+	Period   string      `firestore:"period"` // 2006-01 或 2006-01-02
+	Uploads  int64       `firestore:"uploads"`
+	Bytes    int64       `firestore:"bytes"`
+	TopFiles []UsageFile `firestore:"top_files,omitempty"` // 只有每月統計會記錄，由大到小
+}
+
+// UsageFile 是統計期間內上傳過的一個檔案
+type UsageFile struct {
+	Name string `firestore:"name"`
+	Size int64  `firestore:"size"`
+}
+
+// 以使用者 ID 與期間作為文件 ID
+func usageDocID(userID int64, period string) string {
+	return fmt.Sprintf("%d_%s", userID, period)
+}
+
+// recordUserUsage 將一次成功的上傳累加到使用者當日與當月的統計，當月統計同時更新最大的檔案
+func recordUserUsage(ctx context.Context, userID int64, name string, size int64) error {
+	now := time.Now().UTC()
+	day := now.Format("2006-01-02")
+	err := collection(ctx, usageCollection).Doc(usageDocID(userID, day)).Merge(ctx, map[string]interface{}{
+		"user_id": userID,
+		"period":  day,
+		"uploads": increment(1),
+		"bytes":   increment(size),
+	})
+	if err != nil {
+		return err
+	}
+
+	month := now.Format("2006-01")
+	ref := collection(ctx, usageCollection).Doc(usageDocID(userID, month))
+	return store.RunTransaction(ctx, func(ctx context.Context, tx Tx) error {
+		usage, err := readUserUsage(tx.Get(ref))
+		if err != nil {
+			return err
+		}
+		usage.UserID, usage.Period = userID, month
+		usage.Uploads++
+		usage.Bytes += size
+		usage.TopFiles = append(usage.TopFiles, UsageFile{Name: name, Size: size})
+		sort.SliceStable(usage.TopFiles, func(i, j int) bool { return usage.TopFiles[i].Size > usage.TopFiles[j].Size })
+		if len(usage.TopFiles) > usageTopFiles {
+			usage.TopFiles = usage.TopFiles[:usageTopFiles]
+		}
+		return tx.Set(ref, usage)
+	})
+}
+
+// loadUserUsage 讀取使用者在指定期間的統計，沒有紀錄時回傳零值
+func loadUserUsage(ctx context.Context, userID int64, period string) (*UserUsage, error) {
+	usage, err := readUserUsage(collection(ctx, usageCollection).Doc(usageDocID(userID, period)).Get(ctx))
+	if err != nil {
+		return nil, err
+	}
+	usage.UserID, usage.Period = userID, period
+	return usage, nil
+}
+
+func readUserUsage(doc *Document, err error) (*UserUsage, error) {
+	usage := &UserUsage{}
+	if err != nil {
+		if errors.Is(err, errDocNotFound) {
+			return usage, nil
+		}
+		return nil, err
+	}
+	if err := doc.DataTo(usage); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// loadDailyUploadBytes 讀取使用者今天（UTC）已計入上傳額度的位元組數
+func loadDailyUploadBytes(ctx context.Context, userID int64) (int64, error) {
+	if redisClient != nil {
+		return redisDailyUploadBytes(ctx, userID)
+	}
+	doc, err := collection(ctx, rateLimitCollection).Doc(fmt.Sprintf("%d", userID)).Get(ctx)
+	if err != nil {
+		if errors.Is(err, errDocNotFound) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var counter rateLimitCounter
+	if err := doc.DataTo(&counter); err != nil {
+		return 0, err
+	}
+	if counter.Day != time.Now().UTC().Format("2006-01-02") {
+		return 0, nil
+	}
+	return counter.DayBytes, nil
+}
+
+// 處理 /usage 指令：顯示使用者今天與本月的上傳量、本月最大的檔案，以及啟用上傳限制時今天剩餘的額度
+func handleUsage(ctx context.Context, message *tgbotapi.Message) {
+	userID := message.From.ID
+	now := time.Now().UTC()
+	today, err := loadUserUsage(ctx, userID, now.Format("2006-01-02"))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load usage", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取上傳統計時發生錯誤，請稍後再試。")
+		return
+	}
+	month, err := loadUserUsage(ctx, userID, now.Format("2006-01"))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load usage", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取上傳統計時發生錯誤，請稍後再試。")
+		return
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "您的上傳統計（UTC）：\n• 今天：%d 個檔案，%s\n• 本月：%d 個檔案，%s\n", today.Uploads, formatSize(today.Bytes), month.Uploads, formatSize(month.Bytes))
+	if len(month.TopFiles) > 0 {
+		sb.WriteString("\n本月最大的檔案：\n")
+		for i, f := range month.TopFiles {
+			fmt.Fprintf(&sb, "%d. %s（%s）\n", i+1, f.Name, formatSize(f.Size))
+		}
+	}
+	limits := configFor(ctx).RateLimits
+	if daily := limits.dailyUploadBytes(); daily > 0 {
+		used, err := loadDailyUploadBytes(ctx, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load daily upload bytes", "error", err)
+		} else {
+			fmt.Fprintf(&sb, "\n今天剩餘的上傳額度：%s / %s，將在 %s 後重置。\n",
+				formatSize(max(daily-used, 0)), formatSize(daily), time.Until(nextUTCMidnight(now)).Round(time.Minute))
+		}
+	}
+	if limits.UploadsPerMinute > 0 {
+		fmt.Fprintf(&sb, "每分鐘最多可上傳 %d 個檔案。\n", limits.UploadsPerMinute)
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, strings.TrimSpace(sb.String()))
+}
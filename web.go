@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- 網頁版 ---
+
+const (
+	webSessionCookie = "tg_helper_session"
+	webSessionTTL    = 24 * time.Hour
+	// Login Widget 的登入資料超過這個時間就不再接受，避免外流的網址被重複使用
+	webAuthMaxAge  = 10 * time.Minute
+	webUploadLimit = 50
+)
+
+// webHandler 回傳 /web/ 的路由：以 Telegram Login Widget 登入後，在瀏覽器中查看上傳紀錄與設定
+// 未設定 ENABLE_WEB_PORTAL 時 /web/ 一律回傳 404
+func webHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /web/{$}", webIndexHandler)
+	mux.HandleFunc("GET /web/auth", webAuthHandler)
+	mux.HandleFunc("POST /web/logout", webLogoutHandler)
+	mux.HandleFunc("GET /web/api/status", webAPI(webStatusHandler))
+	mux.HandleFunc("GET /web/api/uploads", webAPI(webUploadsHandler))
+	mux.HandleFunc("POST /web/api/disconnect", webAPI(webDisconnectHandler))
+	mux.HandleFunc("POST /web/api/settings", webAPI(webSettingsHandler))
+	mux.HandleFunc("GET /web/app", webAppHandler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !configFor(r.Context()).EnableWebPortal {
+			http.NotFound(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// verifyTelegramLogin 依 Telegram 的規則驗證 Login Widget 的資料：
+// 除了 hash 以外的欄位依名稱排序後以換行串接，以 SHA-256(機器人權杖) 為金鑰計算 HMAC-SHA256，再與 hash 比對
+func verifyTelegramLogin(values map[string][]string, botToken string, now time.Time) (int64, error) {
+	hash := firstValue(values, "hash")
+	if hash == "" {
+		return 0, fmt.Errorf("missing hash")
+	}
+	var pairs []string
+	for k, v := range values {
+		if k == "hash" || k == "bot" || len(v) == 0 {
+			continue
+		}
+		pairs = append(pairs, k+"="+v[0])
+	}
+	sort.Strings(pairs)
+
+	secret := sha256.Sum256([]byte(botToken))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(strings.Join(pairs, "\n")))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(hash)) {
+		return 0, fmt.Errorf("hash mismatch")
+	}
+
+	authDate, err := strconv.ParseInt(firstValue(values, "auth_date"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid auth_date: %v", err)
+	}
+	if now.Sub(time.Unix(authDate, 0)) > webAuthMaxAge {
+		return 0, fmt.Errorf("login data expired")
+	}
+	userID, err := strconv.ParseInt(firstValue(values, "id"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid id: %v", err)
+	}
+	return userID, nil
+}
+
+func firstValue(values map[string][]string, key string) string {
+	if v := values[key]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// webSessionKey 由機器人權杖衍生簽署 session cookie 的金鑰，更換權杖後所有人都需要重新登入
+func webSessionKey(t *tenant) []byte {
+	key := sha256.Sum256([]byte("tg-helper web session:" + t.Bot.Token))
+	return key[:]
+}
+
+// newWebSession 產生 "<機器人 ID>:<使用者 ID>:<到期時間>" 並附上 HMAC 簽章
+func newWebSession(t *tenant, userID int64, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%d:%d", t.ID, userID, expires.Unix())))
+	mac := hmac.New(sha256.New, webSessionKey(t))
+	mac.Write([]byte(payload))
+	return payload + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+// parseWebSession 驗證 session cookie，回傳所屬的機器人與使用者；無效或過期時回傳 false
+func parseWebSession(value string, now time.Time) (*tenant, int64, bool) {
+	payload, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, 0, false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, 0, false
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 3 {
+		return nil, 0, false
+	}
+	t, ok := tenants[parts[0]]
+	if !ok {
+		return nil, 0, false
+	}
+	mac := hmac.New(sha256.New, webSessionKey(t))
+	mac.Write([]byte(payload))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(signature)) {
+		return nil, 0, false
+	}
+	userID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, 0, false
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || now.After(time.Unix(expires, 0)) {
+		return nil, 0, false
+	}
+	return t, userID, true
+}
+
+// webTenant 回傳 ?bot=<機器人 ID> 指定的機器人，未指定時為主要機器人
+func webTenant(r *http.Request) (*tenant, bool) {
+	id := r.URL.Query().Get("bot")
+	if id == "" {
+		return defaultTenant, true
+	}
+	t, ok := tenants[id]
+	return t, ok
+}
+
+// 處理 GET /web/auth：Login Widget 登入後會帶著使用者資料轉址到這裡
+func webAuthHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	t, ok := webTenant(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	userID, err := verifyTelegramLogin(r.URL.Query(), t.Bot.Token, time.Now())
+	if err != nil {
+		slog.WarnContext(ctx, "Rejected Telegram login", "error", err)
+		http.Error(w, "登入資料無效或已過期，請重新登入。", http.StatusUnauthorized)
+		return
+	}
+	if !isUserAllowed(ctx, userID) {
+		http.Error(w, "您沒有使用此機器人的權限。", http.StatusForbidden)
+		return
+	}
+
+	expires := time.Now().Add(webSessionTTL)
+	http.SetCookie(w, &http.Cookie{
+		Name:     webSessionCookie,
+		Value:    newWebSession(t, userID, expires),
+		Path:     "/web/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	slog.InfoContext(ctx, "User signed in to web portal", "user_id", userID, "bot_id", t.ID)
+	http.Redirect(w, r, "/web/", http.StatusSeeOther)
+}
+
+func webLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: webSessionCookie, Path: "/web/", MaxAge: -1, HttpOnly: true, Secure: true})
+	http.Redirect(w, r, "/web/", http.StatusSeeOther)
+}
+
+type webPage struct {
+	BotName string
+	AuthURL string
+}
+
+// webUser 讀取 session cookie，回傳登入的機器人與使用者；未登入或已失去權限時回傳 false
+func webUser(r *http.Request) (*tenant, int64, bool) {
+	c, err := r.Cookie(webSessionCookie)
+	if err != nil {
+		return nil, 0, false
+	}
+	t, userID, ok := parseWebSession(c.Value, time.Now())
+	if !ok || !isUserAllowed(withTenant(r.Context(), t), userID) {
+		return nil, 0, false
+	}
+	return t, userID, true
+}
+
+// 處理 GET /web/：未登入時顯示 Login Widget，登入後顯示儀表板；儀表板是靜態頁面，資料由 /web/api/ 讀取
+func webIndexHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	t, _, ok := webUser(r)
+	if !ok {
+		t, ok := webTenant(r)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		authURL := "https://" + r.Host + "/web/auth"
+		if t != defaultTenant {
+			authURL += "?bot=" + t.ID
+		}
+		renderWebPage(ctx, w, webLoginTemplate, webPage{BotName: t.Bot.Self.UserName, AuthURL: authURL})
+		return
+	}
+	renderWebPage(ctx, w, webDashboardTemplate, webPage{BotName: t.Bot.Self.UserName})
+}
+
+// webAPI 以 Mini App 的 initData 或 session cookie 驗證 /web/api/ 的請求，handler 只能操作登入的使用者自己的資料
+// 以 cookie 驗證時，寫入的請求需要帶上 X-TG-Helper-Request 標頭，其他網站無法在不經過 CORS 預檢的情況下送出；
+// initData 放在 Authorization 標頭，本身就不會被瀏覽器自動帶上
+func webAPI(handler func(ctx context.Context, w http.ResponseWriter, r *http.Request, userID int64)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		t, userID, ok := webAppUser(r)
+		if !ok {
+			if t, userID, ok = webUser(r); !ok {
+				writeAPIError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+			if r.Method != http.MethodGet && r.Header.Get("X-TG-Helper-Request") == "" {
+				writeAPIError(w, http.StatusForbidden, "missing X-TG-Helper-Request header")
+				return
+			}
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		ctx := withLogAttrs(withTenant(r.Context(), t), slog.Int64("user_id", userID))
+		handler(ctx, w, r, userID)
+	}
+}
+
+// 處理 GET /web/api/status：與管理 API 的 /api/v1/users/{id}/status 相同
+func webStatusHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, userID int64) {
+	st, err := loadUserStatus(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load user status", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to load user status")
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, st)
+}
+
+// 處理 GET /web/api/uploads：最近的上傳紀錄，與管理 API 的 /api/v1/users/{id}/uploads 相同
+func webUploadsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, userID int64) {
+	uploads, err := listUserUploads(ctx, userID, webUploadLimit)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list uploads", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to list uploads")
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, map[string]interface{}{"uploads": uploads})
+}
+
+// 處理 POST /web/api/disconnect：刪除使用者在所有目的地的權杖，與 /disconnect 指令相同
+func webDisconnectHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, userID int64) {
+	if err := disconnectUser(ctx, userID); err != nil {
+		slog.ErrorContext(ctx, "Failed to disconnect user", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "failed to disconnect user")
+		return
+	}
+	slog.InfoContext(ctx, "User disconnected through web portal")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func renderWebPage(ctx context.Context, w http.ResponseWriter, tmpl *template.Template, page webPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// 頁面含有個人資料，不讓瀏覽器或代理伺服器快取
+	w.Header().Set("Cache-Control", "no-store")
+	if err := tmpl.Execute(w, page); err != nil {
+		slog.WarnContext(ctx, "Failed to render web page", "error", err)
+	}
+}
+
+const webPageStyle = `<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", "Noto Sans TC", sans-serif; background: #f4f6f8; color: #222; margin: 0; }
+main { background: #fff; border-radius: 12px; box-shadow: 0 2px 12px rgba(0,0,0,.08); padding: 24px 32px; max-width: 800px; margin: 32px auto; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: 8px; border-bottom: 1px solid #eee; word-break: break-all; }
+th { color: #555; font-weight: 600; }
+button { border: 0; background: none; color: #2aabee; cursor: pointer; font-size: inherit; padding: 0; }
+</style>`
+
+var webLoginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="zh-TW">
+<head>
+` + webPageStyle + `
+<title>tg-helper</title>
+</head>
+<body>
+<main>
+<h1>tg-helper</h1>
+<p>以 Telegram 帳號登入後，即可查看您的上傳紀錄與設定。</p>
+<script async src="https://telegram.org/js/telegram-widget.js?22" data-telegram-login="{{.BotName}}" data-size="large" data-auth-url="{{.AuthURL}}"></script>
+</main>
+</body>
+</html>
+`))
+
+// webDashboardTemplate 只包含頁面的框架，資料由瀏覽器呼叫 /web/api/ 後填入
+var webDashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html lang="zh-TW">
+<head>
+` + webPageStyle + `
+<title>tg-helper</title>
+</head>
+<body>
+<main>
+<form method="post" action="/web/logout" style="float: right"><button type="submit">登出</button></form>
+<h1>tg-helper</h1>
+<p id="error" hidden>讀取資料時發生錯誤，請重新整理頁面。</p>
+<p>使用者 ID：<span id="user-id"></span>，要變更設定請在 Telegram 中對 <a href="https://t.me/{{.BotName}}">@{{.BotName}}</a> 輸入 /settings。</p>
+<h2>連結狀態</h2>
+<table id="destinations"></table>
+<p><button id="disconnect" type="button">中斷所有連結</button></p>
+<h2>上傳量</h2>
+<table id="usage"></table>
+<h2>設定</h2>
+<table id="settings"></table>
+<h2>最近的上傳</h2>
+<table id="uploads"></table>
+</main>
+<script>
+const formatSize = (n) => {
+  const units = ["B", "KB", "MB", "GB", "TB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) { n /= 1024; i++; }
+  return (i === 0 ? n : n.toFixed(1)) + " " + units[i];
+};
+const row = (table, cells, header) => {
+  const tr = table.insertRow();
+  cells.forEach((c, i) => {
+    const cell = document.createElement(header && i === 0 ? "th" : "td");
+    if (c instanceof Node) cell.append(c); else cell.textContent = c;
+    tr.append(cell);
+  });
+};
+const onOff = (v) => v ? "開" : "關";
+const api = async (path, options) => {
+  const resp = await fetch("/web/api/" + path, options);
+  if (resp.status === 401) { location.reload(); throw new Error("unauthorized"); }
+  if (!resp.ok) throw new Error(resp.statusText);
+  return resp.status === 204 ? null : resp.json();
+};
+const load = async () => {
+  const [status, list] = await Promise.all([api("status"), api("uploads")]);
+  document.getElementById("user-id").textContent = status.user_id;
+
+  const dest = document.getElementById("destinations");
+  dest.replaceChildren();
+  status.destinations.forEach((d) => row(dest, [d.display_name, (d.connected ? "已連結" : "未連結") + (d.current ? "（使用中）" : "")], true));
+
+  const usage = document.getElementById("usage");
+  usage.replaceChildren();
+  row(usage, ["今天", status.usage.today_uploads + " 個檔案，" + formatSize(status.usage.today_bytes)], true);
+  row(usage, ["本月", status.usage.month_uploads + " 個檔案，" + formatSize(status.usage.month_bytes)], true);
+  if (status.quota.daily_limit_bytes) {
+    row(usage, ["今日額度", formatSize(status.quota.daily_used_bytes) + " / " + formatSize(status.quota.daily_limit_bytes)], true);
+  }
+  if (status.quota.uploads_per_minute) {
+    row(usage, ["每分鐘上限", status.quota.uploads_per_minute + " 個檔案"], true);
+  }
+
+  const s = status.settings, settings = document.getElementById("settings");
+  settings.replaceChildren();
+  row(settings, ["檔名範本", s.filename_template || "（未設定）"], true);
+  row(settings, ["資料夾範本", s.folder_template || "（未設定）"], true);
+  row(settings, ["上傳前確認", onOff(s.confirm_upload)], true);
+  row(settings, ["略過重複的檔案", onOff(s.skip_duplicates)], true);
+  row(settings, ["上傳摘要", { daily: "每日", weekly: "每週" }[s.digest] || "關"], true);
+  row(settings, ["Webhook", s.webhook_url || "（未設定）"], true);
+
+  const uploads = document.getElementById("uploads");
+  uploads.replaceChildren();
+  if (list.uploads.length === 0) { row(uploads, ["目前沒有上傳紀錄。"]); return; }
+  row(uploads, ["檔名", "大小", "時間"]);
+  list.uploads.forEach((u) => {
+    let name = u.name;
+    if (u.link) {
+      name = document.createElement("a");
+      name.href = u.link;
+      name.rel = "noopener";
+      name.target = "_blank";
+      name.textContent = u.name;
+    }
+    row(uploads, [name, formatSize(u.size), new Date(u.created_at).toLocaleString()]);
+  });
+};
+document.getElementById("disconnect").addEventListener("click", async () => {
+  if (!confirm("確定要中斷所有儲存空間的連結嗎？之後需要在 Telegram 中重新連結才能上傳。")) return;
+  try {
+    await api("disconnect", { method: "POST", headers: { "X-TG-Helper-Request": "1" } });
+    await load();
+  } catch (e) {
+    document.getElementById("error").hidden = false;
+  }
+});
+load().catch(() => { document.getElementById("error").hidden = false; });
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signTelegramLogin 依 Login Widget 的規則替 values 加上 hash
+func signTelegramLogin(values url.Values, botToken string) url.Values {
+	var pairs []string
+	for k, v := range values {
+		pairs = append(pairs, k+"="+v[0])
+	}
+	sort.Strings(pairs)
+	secret := sha256.Sum256([]byte(botToken))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(strings.Join(pairs, "\n")))
+	signed := url.Values{"hash": {hex.EncodeToString(mac.Sum(nil))}}
+	for k, v := range values {
+		signed[k] = v
+	}
+	return signed
+}
+
+func TestVerifyTelegramLogin(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	login := func(authDate time.Time) url.Values {
+		return url.Values{
+			"id":         {"42"},
+			"first_name": {"Alice"},
+			"username":   {"alice"},
+			"auth_date":  {strconv.FormatInt(authDate.Unix(), 10)},
+		}
+	}
+	tests := []struct {
+		name    string
+		values  url.Values
+		wantID  int64
+		wantErr string
+	}{
+		{"valid", signTelegramLogin(login(now), testBotToken), 42, ""},
+		{"bot parameter is ignored", func() url.Values {
+			v := signTelegramLogin(login(now), testBotToken)
+			v.Set("bot", "123456")
+			return v
+		}(), 42, ""},
+		{"missing hash", login(now), 0, "missing hash"},
+		{"wrong token", signTelegramLogin(login(now), "654321:OTHER"), 0, "hash mismatch"},
+		{"tampered id", func() url.Values {
+			v := signTelegramLogin(login(now), testBotToken)
+			v.Set("id", "43")
+			return v
+		}(), 0, "hash mismatch"},
+		{"expired", signTelegramLogin(login(now.Add(-webAuthMaxAge-time.Second)), testBotToken), 0, "expired"},
+		{"invalid id", signTelegramLogin(url.Values{"id": {"x"}, "auth_date": {strconv.FormatInt(now.Unix(), 10)}}, testBotToken), 0, "invalid id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := verifyTelegramLogin(tt.values, testBotToken, now)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("verifyTelegramLogin = %d, %v, want an error containing %q", id, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || id != tt.wantID {
+				t.Errorf("verifyTelegramLogin = %d, %v, want %d", id, err, tt.wantID)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- Telegram Mini App ---
+
+// initData 超過這個時間就不再接受；Mini App 開著的期間 initData 不會更新，因此比 Login Widget 寬鬆
+const webAppMaxAge = 24 * time.Hour
+
+// verifyWebAppInitData 依 Telegram 的規則驗證 Mini App 的 initData：
+// 除了 hash 以外的欄位依名稱排序後以換行串接，以 HMAC-SHA256("WebAppData", 機器人權杖) 為金鑰計算 HMAC-SHA256，再與 hash 比對
+func verifyWebAppInitData(initData, botToken string, now time.Time) (int64, error) {
+	values, err := url.ParseQuery(initData)
+	if err != nil {
+		return 0, fmt.Errorf("invalid init data: %v", err)
+	}
+	hash := firstValue(values, "hash")
+	if hash == "" {
+		return 0, fmt.Errorf("missing hash")
+	}
+	var pairs []string
+	for k, v := range values {
+		if k == "hash" || len(v) == 0 {
+			continue
+		}
+		pairs = append(pairs, k+"="+v[0])
+	}
+	sort.Strings(pairs)
+
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(botToken))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(pairs, "\n")))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(hash)) {
+		return 0, fmt.Errorf("hash mismatch")
+	}
+
+	authDate, err := strconv.ParseInt(firstValue(values, "auth_date"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid auth_date: %v", err)
+	}
+	if now.Sub(time.Unix(authDate, 0)) > webAppMaxAge {
+		return 0, fmt.Errorf("init data expired")
+	}
+	var user struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(firstValue(values, "user")), &user); err != nil || user.ID == 0 {
+		return 0, fmt.Errorf("invalid user")
+	}
+	return user.ID, nil
+}
+
+// webAppUser 驗證 Authorization: tma <initData> 標頭，回傳簽署 initData 的機器人與使用者
+// initData 沒有記錄是哪個機器人，依序以每個機器人的權杖驗證
+func webAppUser(r *http.Request) (*tenant, int64, bool) {
+	initData, ok := strings.CutPrefix(r.Header.Get("Authorization"), "tma ")
+	if !ok {
+		return nil, 0, false
+	}
+	now := time.Now()
+	for _, t := range tenants {
+		userID, err := verifyWebAppInitData(initData, t.Bot.Token, now)
+		if err == nil && isUserAllowed(withTenant(r.Context(), t), userID) {
+			return t, userID, true
+		}
+	}
+	return nil, 0, false
+}
+
+// 處理 /app 指令：傳送開啟 Mini App 的按鈕，在 Telegram 中管理設定與查看上傳紀錄
+func handleApp(ctx context.Context, message *tgbotapi.Message) {
+	if !message.Chat.IsPrivate() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請在與機器人的私訊中使用 /app。")
+		return
+	}
+	if !configFor(ctx).EnableWebPortal {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "此機器人尚未啟用網頁版。")
+		return
+	}
+	base, err := serviceURL(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to build mini app URL", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "發生錯誤，請稍後再試。")
+		return
+	}
+	// telegram-bot-api 尚未支援 web_app 按鈕，直接呼叫 Bot API
+	markup := map[string]interface{}{
+		"inline_keyboard": [][]map[string]interface{}{{
+			{"text": "開啟設定與上傳紀錄", "web_app": map[string]string{"url": base + "/web/app"}},
+		}},
+	}
+	params := tgbotapi.Params{}
+	params.AddNonZero64("chat_id", message.Chat.ID)
+	params.AddNonEmpty("text", "點選下方按鈕，不用離開 Telegram 就能調整設定、查看連結狀態與最近的上傳。")
+	params.AddNonZero("reply_to_message_id", message.MessageID)
+	if err := params.AddInterface("reply_markup", markup); err != nil {
+		slog.ErrorContext(ctx, "Failed to encode mini app button", "error", err)
+		return
+	}
+	err = callTelegram(ctx, message.Chat.ID, func() error {
+		_, err := botFor(ctx).MakeRequest("sendMessage", params)
+		return err
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to send mini app button", "error", err)
+	}
+}
+
+// 處理 GET /web/app：Mini App 的靜態頁面，以 initData 呼叫 /web/api/
+func webAppHandler(w http.ResponseWriter, r *http.Request) {
+	renderWebPage(r.Context(), w, webAppTemplate, webPage{})
+}
+
+// webSettingsUpdate 是 POST /web/api/settings 可以修改的設定，未提供的欄位維持不變
+type webSettingsUpdate struct {
+	Destination    *string `json:"destination"`
+	ConfirmUpload  *bool   `json:"confirm_upload"`
+	SkipDuplicates *bool   `json:"skip_duplicates"`
+	Digest         *string `json:"digest"`
+}
+
+// 處理 POST /web/api/settings：修改設定後回傳與 /web/api/status 相同的內容
+func webSettingsHandler(ctx context.Context, w http.ResponseWriter, r *http.Request, userID int64) {
+	var update webSettingsUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	fields := map[string]interface{}{}
+	if update.Destination != nil {
+		dest, ok := destinations[*update.Destination]
+		if _, media := dest.(mediaDestination); !ok || media {
+			writeAPIError(w, http.StatusBadRequest, "unknown destination")
+			return
+		}
+		if configFor(ctx).ForceDestination != "" {
+			writeAPIError(w, http.StatusConflict, "destination is fixed by the administrator")
+			return
+		}
+		connected, err := dest.Connected(ctx, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to check destination connection", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, "failed to check destination")
+			return
+		}
+		if !connected {
+			writeAPIError(w, http.StatusConflict, "destination is not connected")
+			return
+		}
+		fields["destination"] = dest.Name()
+	}
+	if update.ConfirmUpload != nil {
+		fields["confirm_upload"] = *update.ConfirmUpload
+	}
+	if update.SkipDuplicates != nil {
+		fields["skip_duplicates"] = *update.SkipDuplicates
+	}
+	if update.Digest != nil {
+		if _, ok := digestLabels[*update.Digest]; !ok {
+			writeAPIError(w, http.StatusBadRequest, "digest must be daily, weekly or empty")
+			return
+		}
+		fields["digest"] = *update.Digest
+	}
+	if len(fields) > 0 {
+		if err := updateUserSettings(ctx, userID, fields); err != nil {
+			slog.ErrorContext(ctx, "Failed to save settings", "error", err)
+			writeAPIError(w, http.StatusInternalServerError, "failed to save settings")
+			return
+		}
+	}
+	webStatusHandler(ctx, w, r, userID)
+}
+
+var webAppTemplate = template.Must(template.New("app").Parse(`<!DOCTYPE html>
+<html lang="zh-TW">
+<head>
+` + webPageStyle + `
+<script src="https://telegram.org/js/telegram-web-app.js"></script>
+<style>
+body { background: var(--tg-theme-bg-color, #f4f6f8); color: var(--tg-theme-text-color, #222); }
+main { background: var(--tg-theme-section-bg-color, #fff); margin: 0; border-radius: 0; box-shadow: none; padding: 16px; }
+a, button { color: var(--tg-theme-link-color, #2aabee); }
+select { font-size: inherit; }
+</style>
+<title>tg-helper</title>
+</head>
+<body>
+<main>
+<p id="error" hidden></p>
+<h2>設定</h2>
+<table>
+<tr><th>上傳目的地</th><td><select id="destination"></select></td></tr>
+<tr><th>上傳前確認</th><td><input type="checkbox" id="confirm_upload"></td></tr>
+<tr><th>略過重複的檔案</th><td><input type="checkbox" id="skip_duplicates"></td></tr>
+<tr><th>上傳摘要</th><td><select id="digest"><option value="">關</option><option value="daily">每日</option><option value="weekly">每週</option></select></td></tr>
+</table>
+<h2>連結狀態</h2>
+<table id="destinations"></table>
+<h2>上傳量</h2>
+<table id="usage"></table>
+<h2>最近的上傳</h2>
+<table id="uploads"></table>
+</main>
+<script>
+const app = window.Telegram.WebApp;
+app.ready();
+const showError = (text) => {
+  const el = document.getElementById("error");
+  el.textContent = text;
+  el.hidden = false;
+};
+const formatSize = (n) => {
+  const units = ["B", "KB", "MB", "GB", "TB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) { n /= 1024; i++; }
+  return (i === 0 ? n : n.toFixed(1)) + " " + units[i];
+};
+const row = (table, cells) => {
+  const tr = table.insertRow();
+  cells.forEach((c, i) => {
+    const cell = document.createElement(i === 0 && cells.length > 1 ? "th" : "td");
+    if (c instanceof Node) cell.append(c); else cell.textContent = c;
+    tr.append(cell);
+  });
+};
+const api = async (path, body) => {
+  const options = { headers: { "Authorization": "tma " + app.initData } };
+  if (body !== undefined) {
+    options.method = "POST";
+    options.headers["Content-Type"] = "application/json";
+    options.body = JSON.stringify(body);
+  }
+  const resp = await fetch("/web/api/" + path, options);
+  if (!resp.ok) throw new Error((await resp.json().catch(() => ({}))).error || resp.statusText);
+  return resp.json();
+};
+const render = (status) => {
+  const select = document.getElementById("destination");
+  select.replaceChildren();
+  const dest = document.getElementById("destinations");
+  dest.replaceChildren();
+  status.destinations.forEach((d) => {
+    row(dest, [d.display_name, d.connected ? "已連結" : "未連結"]);
+    if (d.connected && !d.media) {
+      select.add(new Option(d.display_name, d.name, false, d.current));
+    }
+  });
+  document.getElementById("confirm_upload").checked = status.settings.confirm_upload;
+  document.getElementById("skip_duplicates").checked = status.settings.skip_duplicates;
+  document.getElementById("digest").value = status.settings.digest;
+
+  const usage = document.getElementById("usage");
+  usage.replaceChildren();
+  row(usage, ["今天", status.usage.today_uploads + " 個檔案，" + formatSize(status.usage.today_bytes)]);
+  row(usage, ["本月", status.usage.month_uploads + " 個檔案，" + formatSize(status.usage.month_bytes)]);
+  if (status.quota.daily_limit_bytes) {
+    row(usage, ["今日額度", formatSize(status.quota.daily_used_bytes) + " / " + formatSize(status.quota.daily_limit_bytes)]);
+  }
+};
+const save = async (update) => {
+  try {
+    render(await api("settings", update));
+    app.HapticFeedback.notificationOccurred("success");
+  } catch (e) {
+    showError("儲存設定時發生錯誤：" + e.message);
+  }
+};
+document.getElementById("destination").addEventListener("change", (e) => save({ destination: e.target.value }));
+document.getElementById("confirm_upload").addEventListener("change", (e) => save({ confirm_upload: e.target.checked }));
+document.getElementById("skip_duplicates").addEventListener("change", (e) => save({ skip_duplicates: e.target.checked }));
+document.getElementById("digest").addEventListener("change", (e) => save({ digest: e.target.value }));
+
+Promise.all([api("status"), api("uploads")]).then(([status, list]) => {
+  render(status);
+  const uploads = document.getElementById("uploads");
+  if (list.uploads.length === 0) { row(uploads, ["目前沒有上傳紀錄。"]); return; }
+  list.uploads.forEach((u) => {
+    const name = document.createElement(u.link ? "a" : "span");
+    name.textContent = u.name;
+    if (u.link) {
+      name.href = u.link;
+      name.addEventListener("click", (e) => { e.preventDefault(); app.openLink(u.link); });
+    }
+    row(uploads, [name, formatSize(u.size), new Date(u.created_at).toLocaleString()]);
+  });
+}).catch((e) => showError("讀取資料時發生錯誤：" + e.message));
+</script>
+</body>
+</html>
+`))
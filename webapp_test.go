@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signWebAppInitData 依 Mini App 的規則替 values 加上 hash，回傳 initData 字串
+func signWebAppInitData(values url.Values, botToken string) string {
+	var pairs []string
+	for k, v := range values {
+		pairs = append(pairs, k+"="+v[0])
+	}
+	sort.Strings(pairs)
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(botToken))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(pairs, "\n")))
+	signed := url.Values{"hash": {hex.EncodeToString(mac.Sum(nil))}}
+	for k, v := range values {
+		signed[k] = v
+	}
+	return signed.Encode()
+}
+
+func TestVerifyWebAppInitData(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	initData := func(user string, authDate time.Time) url.Values {
+		return url.Values{
+			"query_id":  {"AAE"},
+			"user":      {user},
+			"auth_date": {strconv.FormatInt(authDate.Unix(), 10)},
+		}
+	}
+	const alice = `{"id":42,"first_name":"Alice"}`
+	tests := []struct {
+		name     string
+		initData string
+		wantID   int64
+		wantErr  string
+	}{
+		{"valid", signWebAppInitData(initData(alice, now), testBotToken), 42, ""},
+		{"missing hash", initData(alice, now).Encode(), 0, "missing hash"},
+		{"wrong token", signWebAppInitData(initData(alice, now), "654321:OTHER"), 0, "hash mismatch"},
+		{"tampered user", strings.Replace(signWebAppInitData(initData(alice, now), testBotToken), "%22id%22%3A42", "%22id%22%3A43", 1), 0, "hash mismatch"},
+		{"expired", signWebAppInitData(initData(alice, now.Add(-webAppMaxAge-time.Second)), testBotToken), 0, "expired"},
+		{"missing user id", signWebAppInitData(initData(`{"first_name":"Alice"}`, now), testBotToken), 0, "invalid user"},
+		{"malformed", "%zz", 0, "invalid init data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := verifyWebAppInitData(tt.initData, testBotToken, now)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("verifyWebAppInitData = %d, %v, want an error containing %q", id, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || id != tt.wantID {
+				t.Errorf("verifyWebAppInitData = %d, %v, want %d", id, err, tt.wantID)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// --- 網頁封存 ---
+
+const (
+	// 網頁存成 PDF 後上傳到的資料夾
+	readLaterFolder = "Read Later"
+	// 等待轉換服務回應的時限，包含載入網頁的時間
+	pdfRenderTimeout = 2 * time.Minute
+	// 讀取網頁標題時最多讀取的大小
+	maxTitleBytes = 256 * 1024
+)
+
+var titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// pageClient 讀取使用者傳來的網頁，只能連到公開的位址，也不跟隨轉址，避免被拿來存取內部網路
+var pageClient = &http.Client{
+	Transport: otelhttp.NewTransport(&http.Transport{
+		Proxy:       nil,
+		DialContext: (&net.Dialer{Timeout: 10 * time.Second, Control: rejectInternalAddress}).DialContext,
+	}),
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// initWebArchive 依設定開啟網頁封存；PDF_RENDERER_URL 是以 headless Chrome 將網頁轉成 PDF 的 Gotenberg 服務，未設定時不啟用
+func initWebArchive(cfg *Config) {
+	if cfg.PDFRendererProxyAddr != "" {
+		startEgressProxy(cfg.PDFRendererProxyAddr)
+	}
+	if cfg.PDFRendererURL == "" {
+		return
+	}
+	slog.Info("Web page archiving enabled", "renderer", cfg.PDFRendererURL)
+	if cfg.PDFRendererProxyAddr == "" {
+		slog.Warn("PDF_RENDERER_PROXY_ADDR is not set; the renderer must be prevented from reaching internal addresses by its own egress rules")
+	}
+}
+
+// pageLink 在文字只有一個 http(s) 網址時回傳該網址，否則回傳空字串
+func pageLink(text string) string {
+	fields := strings.Fields(text)
+	if len(fields) != 1 {
+		return ""
+	}
+	u, err := url.Parse(fields[0])
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return ""
+	}
+	return u.String()
+}
+
+// handleWebArchive 在使用者開啟自動封存時，將私訊中只有網址的訊息存成 PDF；沒有處理時回傳 false
+func handleWebArchive(ctx context.Context, message *tgbotapi.Message) bool {
+	if configFor(ctx).PDFRendererURL == "" || !message.Chat.IsPrivate() || message.From == nil {
+		return false
+	}
+	link := pageLink(message.Text)
+	if link == "" {
+		return false
+	}
+	settings, err := loadUserSettings(ctx, message.From.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		return false
+	}
+	if !settings.WebArchive {
+		return false
+	}
+	archiveWebPage(ctx, message, settings, link)
+	return true
+}
+
+// 處理 /pdf 指令：將網頁存成 PDF，也可以回覆一則只有網址的訊息
+func handlePDF(ctx context.Context, message *tgbotapi.Message) {
+	if configFor(ctx).PDFRendererURL == "" {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "此機器人沒有設定網頁轉換服務，無法將網頁存成 PDF。")
+		return
+	}
+	link := pageLink(message.CommandArguments())
+	if link == "" && message.ReplyToMessage != nil {
+		link = pageLink(message.ReplyToMessage.Text)
+	}
+	if link == "" {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "用法：/pdf <網址>，或回覆一則只有網址的訊息並輸入 /pdf")
+		return
+	}
+	settings, err := loadUserSettings(ctx, message.From.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的設定時發生錯誤，請稍後再試。")
+		return
+	}
+	archiveWebPage(ctx, message, settings, link)
+}
+
+// archiveWebPage 確認使用者已連結目的地後，在背景將網頁轉成 PDF 並上傳
+func archiveWebPage(ctx context.Context, message *tgbotapi.Message, settings *UserSettings, link string) {
+	userID := message.From.ID
+	dest := userDestination(ctx, settings)
+	connected, err := dest.Connected(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to retrieve token", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的授權時發生錯誤，請稍後再試。")
+		return
+	}
+	if !connected {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("您的 %s 帳號尚未連結，請使用 %s 指令來重新連結。", dest.DisplayName(), connectCommand(dest)))
+		return
+	}
+	if err := validatePublicURL(link, "https", "http"); err != nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("無法封存這個網址：%v", err))
+		return
+	}
+	if err := reserveUpload(ctx, userID, 0); err != nil {
+		var limitErr *rateLimitError
+		if errors.As(err, &limitErr) {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, rateLimitMessage(ctx, limitErr))
+			return
+		}
+		slog.ErrorContext(ctx, "Failed to check rate limit", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "檢查上傳額度時發生錯誤，請稍後再試。")
+		return
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, "正在將網頁存成 PDF…")
+
+	// 載入與轉換網頁可能需要數十秒，在背景進行以免 webhook 逾時
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				reportPanic(ctx, r)
+			}
+		}()
+		saveWebPage(ctx, message, settings, dest, link)
+	}()
+}
+
+func saveWebPage(ctx context.Context, message *tgbotapi.Message, settings *UserSettings, dest Destination, link string) {
+	userID := message.From.ID
+	title := fetchPageTitle(ctx, link)
+	if title == "" {
+		u, _ := url.Parse(link)
+		title = strings.Trim(u.Host+u.Path, "/")
+	}
+	name := fmt.Sprintf("%s %s.pdf", time.Now().Format("2006-01-02"), strings.NewReplacer("/", "_", "\\", "_").Replace(title))
+
+	pdf, err := renderPDF(ctx, link)
+	if err != nil {
+		reportError(ctx, "Failed to render web page", err, "link", link)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "無法將這個網頁轉成 PDF，網站可能拒絕了存取或需要登入，請稍後再試。")
+		return
+	}
+	defer pdf.Close()
+
+	body := newLimitedReader(pdf, configFor(ctx).maxFileSize())
+	result, err := uploadTo(ctx, dest, userID, &UploadFile{
+		Name:     name,
+		Folders:  []string{readLaterFolder},
+		Body:     body,
+		MimeType: "application/pdf",
+		Caption:  title + "\n\n" + link,
+	})
+	event := &UploadEvent{UserID: userID, ChatID: message.Chat.ID, Destination: dest.Name(), FileName: name, MimeType: "application/pdf", Size: body.BytesRead()}
+	if body.Exceeded() {
+		err = errFileTooLarge
+	}
+	if err != nil {
+		event.Event, event.Error = eventUploadFailed, err.Error()
+		emitUploadEvent(ctx, settings, event)
+		if errors.Is(err, errFileTooLarge) {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, fileTooLargeMessage(ctx, 0))
+			return
+		}
+		reportError(ctx, "Failed to upload web page", err, "destination", dest.Name())
+		replyToUser(ctx, message.Chat.ID, message.MessageID, uploadFailedMessage(ctx, dest, userID, err, fmt.Sprintf("上傳到您的 %s 失敗。", dest.DisplayName())))
+		return
+	}
+	recordLinkUpload(ctx, settings, dest, result, event)
+	slog.InfoContext(ctx, "Web page archived", "link", link, "file_name", result.Name, "size", event.Size)
+	text := fmt.Sprintf("已將網頁存成「%s/%s」（%s）。", readLaterFolder, result.Name, formatSize(event.Size))
+	if result.Link != "" {
+		text += "\n" + result.Link
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, dryRunReply(ctx, text))
+}
+
+// renderPDF 請 Gotenberg 以 headless Chrome 開啟網頁並轉成 PDF，呼叫端需關閉回傳的 body
+// 先解析主機確認是公開位址，及早拒絕明顯的內部網址；轉址與網頁中的資源由 Chromium 自己連線，
+// 需要由 egressProxy 或轉換服務的網路設定在撥號時限制
+func renderPDF(ctx context.Context, link string) (io.ReadCloser, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	if err := resolvePublicHost(ctx, u.Hostname()); err != nil {
+		return nil, fmt.Errorf("refusing to render %s: %w", u.Hostname(), err)
+	}
+
+	var form bytes.Buffer
+	w := multipart.NewWriter(&form)
+	w.WriteField("url", link)
+	// 等網頁的延遲載入內容出現後再轉換
+	w.WriteField("waitDelay", "2s")
+	w.WriteField("printBackground", "true")
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	endpoint := strings.TrimSuffix(configFor(ctx).PDFRendererURL, "/") + "/forms/chromium/convert/url"
+	ctx, cancel := context.WithTimeout(ctx, pdfRenderTimeout)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, &form)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	resp, err := instrumentedClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("renderer returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return cancelOnClose{resp.Body, cancel}, nil
+}
+
+// cancelOnClose 在 body 關閉時取消請求的 context
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// fetchPageTitle 讀取網頁的 <title> 作為檔名，失敗時回傳空字串
+func fetchPageTitle(ctx context.Context, link string) string {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return ""
+	}
+	resp, err := pageClient.Do(req)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to fetch page title", "error", err)
+		return ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("Content-Type"), "html") {
+		return ""
+	}
+	head, _ := io.ReadAll(io.LimitReader(resp.Body, maxTitleBytes))
+	m := titlePattern.FindSubmatch(head)
+	if m == nil {
+		return ""
+	}
+	title := strings.Join(strings.Fields(html.UnescapeString(string(m[1]))), " ")
+	if r := []rune(title); len(r) > 100 {
+		title = string(r[:100])
+	}
+	return title
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// Firestore 集合名稱
+const webDAVCredentialCollection = "webdav_credentials"
+
+// WebDAVCredential 是使用者註冊的 WebDAV 伺服器，密碼以 CREDENTIALS_ENCRYPTION_KEY 加密後儲存
+type WebDAVCredential struct {
+	UserID            int64     `firestore:"user_id"`
+	URL               string    `firestore:"url"`
+	Username          string    `firestore:"username"`
+	EncryptedPassword string    `firestore:"encrypted_password"`
+	CreatedAt         time.Time `firestore:"created_at"`
+}
+
+// webDAVClient 連到使用者自行設定的伺服器，只能連到公開的位址，也不跟隨轉址，避免被拿來存取內部網路
+var webDAVClient = &http.Client{
+	Transport: otelhttp.NewTransport(&http.Transport{
+		Proxy:                 nil,
+		DialContext:           (&net.Dialer{Timeout: 30 * time.Second, Control: rejectInternalAddress}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: time.Minute,
+	}),
+	CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+}
+
+// webDAVDestination 將檔案上傳到使用者自己的 WebDAV 伺服器（例如 Nextcloud）
+type webDAVDestination struct{}
+
+func (webDAVDestination) Name() string        { return "webdav" }
+func (webDAVDestination) DisplayName() string { return "WebDAV" }
+
+func (webDAVDestination) Connected(ctx context.Context, userID int64) (bool, error) {
+	return hasUserToken(ctx, webDAVCredentialCollection, userID)
+}
+
+// Connect 不提供授權連結，WebDAV 透過 StartConnect 的對話收集伺服器資訊
+func (webDAVDestination) Connect(ctx context.Context, userID int64) (string, error) {
+	return "", errInteractiveConnect
+}
+
+// StartConnect 開始 /connect_webdav 的引導對話
+func (webDAVDestination) StartConnect(ctx context.Context, message *tgbotapi.Message) {
+	if !message.Chat.IsPrivate() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "為了保護您的密碼，請在與機器人的私訊中使用 /connect_webdav。")
+		return
+	}
+	conv := &Conversation{Flow: "connect_webdav", Step: "url", Data: map[string]string{}}
+	if err := saveConversation(ctx, message.From.ID, conv); err != nil {
+		slog.ErrorContext(ctx, "Failed to save conversation", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "發生錯誤，請稍後再試。")
+		return
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID,
+		"請輸入您的 WebDAV 網址（需為 https），例如 Nextcloud 的：\nhttps://cloud.example.com/remote.php/dav/files/<使用者名稱>/\n\n隨時可以輸入 /cancel 取消。")
+}
+
+// continueConnectWebDAV 處理 /connect_webdav 對話中的每一步
+func continueConnectWebDAV(ctx context.Context, message *tgbotapi.Message, conv *Conversation) {
+	userID := message.From.ID
+	text := strings.TrimSpace(message.Text)
+
+	switch conv.Step {
+	case "url":
+		u, err := url.Parse(text)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "網址格式不正確，請輸入以 https:// 開頭的 WebDAV 網址。")
+			return
+		}
+		if err := validateWebhookURL(text); err != nil {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("無法使用這個網址：%v", err))
+			return
+		}
+		if !strings.HasSuffix(u.Path, "/") {
+			u.Path += "/"
+		}
+		conv.Data["url"] = u.String()
+		conv.Step = "username"
+		if err := saveConversation(ctx, userID, conv); err != nil {
+			slog.ErrorContext(ctx, "Failed to save conversation", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "發生錯誤，請稍後再試。")
+			return
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請輸入使用者名稱。")
+
+	case "username":
+		conv.Data["username"] = text
+		conv.Step = "password"
+		if err := saveConversation(ctx, userID, conv); err != nil {
+			slog.ErrorContext(ctx, "Failed to save conversation", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "發生錯誤，請稍後再試。")
+			return
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請輸入應用程式密碼（建議在 Nextcloud 的「安全性」設定中另外產生，而不是使用登入密碼）。收到後機器人會立即刪除該則訊息。")
+
+	case "password":
+		// 密碼不應留在聊天紀錄中
+		if _, err := senderFor(ctx).Request(tgbotapi.NewDeleteMessage(message.Chat.ID, message.MessageID)); err != nil {
+			slog.WarnContext(ctx, "Failed to delete password message", "error", err)
+		}
+
+		cred := &WebDAVCredential{UserID: userID, URL: conv.Data["url"], Username: conv.Data["username"], CreatedAt: time.Now()}
+		if err := checkWebDAV(ctx, cred.URL, cred.Username, text); err != nil {
+			slog.WarnContext(ctx, "WebDAV verification failed", "error", err)
+			if err := clearConversation(ctx, userID); err != nil {
+				slog.ErrorContext(ctx, "Failed to clear conversation", "error", err)
+			}
+			replyToUser(ctx, message.Chat.ID, 0, "無法使用這組帳號密碼連線到 WebDAV 伺服器，請確認後重新執行 /connect_webdav。")
+			return
+		}
+
+		encrypted, err := encryptSecret(ctx, text)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to encrypt WebDAV password", "error", err)
+			// 密碼訊息已經刪除，留在密碼步驟只會讓使用者卡住，結束對話讓使用者重新開始
+			if err := clearConversation(ctx, userID); err != nil {
+				slog.ErrorContext(ctx, "Failed to clear conversation", "error", err)
+			}
+			replyToUser(ctx, message.Chat.ID, 0, "儲存憑證時發生錯誤，請稍後重新執行 /connect_webdav。")
+			return
+		}
+		cred.EncryptedPassword = encrypted
+		if err := collection(ctx, webDAVCredentialCollection).Doc(fmt.Sprintf("%d", userID)).Set(ctx, cred); err != nil {
+			slog.ErrorContext(ctx, "Failed to save WebDAV credential", "error", err)
+			if err := clearConversation(ctx, userID); err != nil {
+				slog.ErrorContext(ctx, "Failed to clear conversation", "error", err)
+			}
+			replyToUser(ctx, message.Chat.ID, 0, "儲存憑證時發生錯誤，請稍後重新執行 /connect_webdav。")
+			return
+		}
+		if err := clearConversation(ctx, userID); err != nil {
+			slog.ErrorContext(ctx, "Failed to clear conversation", "error", err)
+		}
+		if err := updateUserSettings(ctx, userID, map[string]interface{}{"destination": "webdav"}); err != nil {
+			slog.ErrorContext(ctx, "Failed to update destination preference", "error", err)
+		}
+		slog.InfoContext(ctx, "WebDAV connected")
+		replyToUser(ctx, message.Chat.ID, 0, "WebDAV 連結成功！之後傳送的檔案會上傳到您的 WebDAV 伺服器，可使用 /destination 切換目的地。")
+	}
+}
+
+func (webDAVDestination) Upload(ctx context.Context, userID int64, file *UploadFile) (*UploadResult, error) {
+	cred, password, err := loadWebDAVCredential(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 逐層建立資料夾；資料夾已存在時伺服器回傳 405，視為成功
+	dirURL := cred.URL
+	for _, folder := range file.Folders {
+		dirURL += url.PathEscape(folder) + "/"
+		resp, err := webDAVRequest(ctx, "MKCOL", dirURL, cred.Username, password, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusMethodNotAllowed {
+			return nil, fmt.Errorf("MKCOL %s returned %s", dirURL, resp.Status)
+		}
+	}
+
+	// WebDAV 的 PUT 會覆寫同名檔案，遇到衝突時在檔名後加上編號
+	name, ext := splitFileName(file.Name)
+	fileName := file.Name
+	for i := 1; ; i++ {
+		resp, err := webDAVRequest(ctx, http.MethodHead, dirURL+url.PathEscape(fileName), cred.Username, password, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			break
+		}
+		if i >= 100 {
+			return nil, errors.New("too many files with the same name")
+		}
+		fileName = joinExt(fmt.Sprintf("%s (%d)", name, i), ext)
+	}
+
+	fileURL := dirURL + url.PathEscape(fileName)
+	spanCtx, span := startSpan(ctx, "webdav.upload")
+	resp, err := webDAVRequest(spanCtx, http.MethodPut, fileURL, cred.Username, password, file.Body, nil)
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+			err = fmt.Errorf("PUT returned %s", resp.Status)
+		}
+	}
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return &UploadResult{FileID: strings.TrimPrefix(fileURL, cred.URL), Name: fileName, Link: fileURL}, nil
+}
+
+func (webDAVDestination) Link(ctx context.Context, userID int64, fileID string) (string, error) {
+	cred, _, err := loadWebDAVCredential(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	return cred.URL + fileID, nil
+}
+
+// loadWebDAVCredential 讀取並解密使用者的 WebDAV 憑證
+func loadWebDAVCredential(ctx context.Context, userID int64) (*WebDAVCredential, string, error) {
+	doc, err := collection(ctx, webDAVCredentialCollection).Doc(fmt.Sprintf("%d", userID)).Get(ctx)
+	if err != nil {
+		if errors.Is(err, errDocNotFound) {
+			return nil, "", errNotConnected
+		}
+		return nil, "", err
+	}
+	var cred WebDAVCredential
+	if err := doc.DataTo(&cred); err != nil {
+		return nil, "", err
+	}
+	password, err := decryptSecret(ctx, cred.EncryptedPassword)
+	if err != nil {
+		return nil, "", err
+	}
+	return &cred, password, nil
+}
+
+// checkWebDAV 以 PROPFIND 確認網址與帳號密碼可以使用
+func checkWebDAV(ctx context.Context, baseURL, username, password string) error {
+	resp, err := webDAVRequest(ctx, "PROPFIND", baseURL, username, password, nil, map[string]string{"Depth": "0"})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return fmt.Errorf("PROPFIND returned %s", resp.Status)
+	}
+	return nil
+}
+
+func webDAVRequest(ctx context.Context, method, target, username, password string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(username, password)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return webDAVClient.Do(req)
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// --- 上傳事件 Webhook ---
+
+// 事件名稱，放在 payload 的 event 欄位與 X-TG-Helper-Event 標頭
+const (
+	eventUploadCompleted = "upload.completed"
+	eventUploadFailed    = "upload.failed"
+)
+
+const (
+	webhookTimeout  = 10 * time.Second
+	webhookAttempts = 3
+)
+
+// UploadEvent 是送到 webhook 的 JSON 內容
+type UploadEvent struct {
+	Event       string    `json:"event"`
+	Timestamp   time.Time `json:"timestamp"`
+	UserID      int64     `json:"user_id"`
+	ChatID      int64     `json:"chat_id"`
+	Chat        string    `json:"chat,omitempty"`
+	Destination string    `json:"destination"`
+	Account     string    `json:"account,omitempty"`
+	FileName    string    `json:"file_name"`
+	FileID      string    `json:"file_id,omitempty"`
+	Link        string    `json:"link,omitempty"`
+	MimeType    string    `json:"mime_type,omitempty"`
+	Size        int64     `json:"size"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// webhookTarget 是一個接收事件的網址與簽章用的密鑰
+type webhookTarget struct {
+	URL    string
+	Secret string
+	// 使用者自行設定的網址不能連到內部網路
+	External bool
+}
+
+// 管理者的 webhook 可以是內部服務；使用者的 webhook 只能連到公開的位址，避免被拿來存取內部網路
+var (
+	webhookClient = &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: otelhttp.NewTransport(http.DefaultTransport),
+	}
+	externalWebhookClient = &http.Client{
+		Timeout: webhookTimeout,
+		Transport: otelhttp.NewTransport(&http.Transport{
+			Proxy:       nil,
+			DialContext: (&net.Dialer{Timeout: webhookTimeout, Control: rejectInternalAddress}).DialContext,
+		}),
+		// 轉址可能指向內部位址，一律不跟隨
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+)
+
+// rejectInternalAddress 在建立連線前拒絕私有、迴路與鏈路本地位址
+func rejectInternalAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("address %s is not allowed", host)
+	}
+	return nil
+}
+
+// emitUploadEvent 在背景將事件送到管理者與使用者設定的 webhook，失敗只記錄日誌，不影響上傳
+// 管理者的 webhook 由 EVENT_WEBHOOK_URL 設定，所有使用者的事件都會送到這裡
+func emitUploadEvent(ctx context.Context, settings *UserSettings, event *UploadEvent) {
+	event.Timestamp = time.Now().UTC()
+	recordLedger(ctx, settings, event)
+
+	var targets []*webhookTarget
+	if operator := configFor(ctx).EventWebhook; operator.URL != "" {
+		targets = append(targets, &webhookTarget{URL: operator.URL, Secret: operator.Secret})
+	}
+	if settings.WebhookURL != "" {
+		targets = append(targets, &webhookTarget{URL: settings.WebhookURL, Secret: settings.WebhookSecret, External: true})
+	}
+	if len(targets) == 0 {
+		return
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode webhook event", "error", err)
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	for _, target := range targets {
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					reportPanic(ctx, r)
+				}
+			}()
+			if err := deliverWebhook(ctx, target, event.Event, body); err != nil {
+				slog.WarnContext(ctx, "Failed to deliver webhook", "event", event.Event, "external", target.External, "error", err)
+			}
+		}()
+	}
+}
+
+// deliverWebhook 送出事件，網路錯誤與 5xx 會以指數退避重試
+func deliverWebhook(ctx context.Context, target *webhookTarget, event string, body []byte) error {
+	client := webhookClient
+	if target.External {
+		client = externalWebhookClient
+	}
+	var err error
+	for attempt := 0; attempt < webhookAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(1<<attempt) * time.Second)
+		}
+		var retry bool
+		retry, err = postWebhook(ctx, client, target, event, body)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+func postWebhook(ctx context.Context, client *http.Client, target *webhookTarget, event string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tg-helper")
+	req.Header.Set("X-TG-Helper-Event", event)
+	req.Header.Set("X-TG-Helper-Timestamp", timestamp)
+	if target.Secret != "" {
+		req.Header.Set("X-TG-Helper-Signature", "sha256="+signWebhook(target.Secret, timestamp, body))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return true, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if resp.StatusCode >= 300 {
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return false, nil
+}
+
+// signWebhook 以 HMAC-SHA256 簽署 "<timestamp>.<body>"，接收端可以拒絕時間差太大的請求來防止重送
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validateWebhookURL 只接受 https 網址，且不能明顯指向內部網路；實際連線時還會再檢查解析出的位址
+func validateWebhookURL(raw string) error {
+	return validatePublicURL(raw, "https")
+}
+
+// validatePublicURL 檢查網址使用允許的 scheme，且主機不是 localhost、內部網域或內部 IP
+func validatePublicURL(raw string, schemes ...string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return errors.New("網址格式不正確")
+	}
+	if !slices.Contains(schemes, u.Scheme) {
+		return fmt.Errorf("只支援 %s 網址", strings.Join(schemes, "、"))
+	}
+	host := u.Hostname()
+	if host == "localhost" || strings.HasSuffix(host, ".internal") || strings.HasSuffix(host, ".local") {
+		return errors.New("不能使用內部網路的網址")
+	}
+	if ip := net.ParseIP(host); ip != nil && rejectInternalAddress("tcp", net.JoinHostPort(host, "443"), nil) != nil {
+		return errors.New("不能使用內部網路的網址")
+	}
+	return nil
+}
+
+// resolvePublicHost 解析主機名稱並確認所有位址都不是內部位址，無法解析時也視為不允許；
+// 用在網址會交給其他服務連線、無法在撥號時檢查的情況
+func resolvePublicHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("resolve %s: %w", host, err)
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("resolve %s: no addresses", host)
+	}
+	for _, addr := range addrs {
+		if err := rejectInternalAddress("tcp", net.JoinHostPort(addr.IP.String(), "443"), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 處理 /webhook 指令：設定上傳完成或失敗時要通知的網址
+func handleWebhook(ctx context.Context, message *tgbotapi.Message) {
+	if !message.Chat.IsPrivate() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請在與機器人的私訊中使用 /webhook。")
+		return
+	}
+	userID := message.From.ID
+	arg := strings.TrimSpace(message.CommandArguments())
+	if arg == "" {
+		settings, err := loadUserSettings(ctx, userID)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的設定時發生錯誤，請稍後再試。")
+			return
+		}
+		state := "未設定"
+		if settings.WebhookURL != "" {
+			state = settings.WebhookURL
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("目前的 Webhook：%s\n用法：/webhook <https 網址> 設定，/webhook off 停用。", state))
+		return
+	}
+
+	if on, ok := parseOnOff(arg); ok && !on {
+		if err := updateUserSettings(ctx, userID, map[string]interface{}{"webhook_url": "", "webhook_secret": ""}); err != nil {
+			slog.ErrorContext(ctx, "Failed to update settings", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "更新設定時發生錯誤，請稍後再試。")
+			return
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "已停用 Webhook。")
+		return
+	}
+	if err := validateWebhookURL(arg); err != nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("Webhook 網址無效：%v", err))
+		return
+	}
+
+	// 每次設定都產生新的密鑰，只在這裡顯示一次
+	b := make([]byte, 32)
+	rand.Read(b)
+	secret := hex.EncodeToString(b)
+	if err := updateUserSettings(ctx, userID, map[string]interface{}{"webhook_url": arg, "webhook_secret": secret}); err != nil {
+		slog.ErrorContext(ctx, "Failed to update settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "更新設定時發生錯誤，請稍後再試。")
+		return
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf(
+		"已設定 Webhook，上傳完成或失敗時會 POST JSON 到 %s。\n\n簽章密鑰（只會顯示這一次）：\n%s\n\n"+
+			"驗證方式：以此密鑰對「X-TG-Helper-Timestamp 標頭、一個句點與請求內容」計算 HMAC-SHA256，與 X-TG-Helper-Signature 標頭比對。",
+		arg, secret))
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- YouTube ---
+
+const (
+	youtubeSite = "youtube"
+	// 下載並合併一部影片的時限
+	youtubeDownloadTimeout = 30 * time.Minute
+)
+
+// youtubeQuality 是選擇格式的按鈕，Format 是 yt-dlp 的 -f 參數
+// 720p 以上只有分開的影像與音訊，需要 ffmpeg 合併成 MP4
+type youtubeQuality struct {
+	Name   string
+	Label  string
+	Format string
+	Audio  bool
+}
+
+var youtubeQualities = []youtubeQuality{
+	{Name: "audio", Label: "🎵 僅音訊", Format: "ba[ext=m4a]/ba", Audio: true},
+	{Name: "360", Label: "360p", Format: "b[height<=360]/bv*[height<=360]+ba"},
+	{Name: "720", Label: "720p", Format: "bv*[height<=720]+ba/b[height<=720]"},
+	{Name: "1080", Label: "1080p", Format: "bv*[height<=1080]+ba/b[height<=1080]"},
+}
+
+var youtubeIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+
+func youtubeQualityByName(name string) *youtubeQuality {
+	for i := range youtubeQualities {
+		if youtubeQualities[i].Name == name {
+			return &youtubeQualities[i]
+		}
+	}
+	return nil
+}
+
+// youtubeVideoID 從 watch、youtu.be、shorts 與 live 連結取出影片 ID，找不到時回傳空字串
+func youtubeVideoID(u *url.URL) string {
+	var id string
+	host := strings.ToLower(u.Hostname())
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch {
+	case host == "youtu.be":
+		id = segments[0]
+	case u.Query().Get("v") != "":
+		id = u.Query().Get("v")
+	case len(segments) == 2 && (segments[0] == "shorts" || segments[0] == "live" || segments[0] == "embed"):
+		id = segments[1]
+	}
+	if !youtubeIDPattern.MatchString(id) {
+		return ""
+	}
+	return id
+}
+
+// youtubeEnabled 回報管理者是否提供 YouTube 下載
+func youtubeEnabled(ctx context.Context) bool {
+	return configFor(ctx).YtDlpPath != "" && linkSiteByName(ctx, youtubeSite) != nil
+}
+
+// promptYouTubeQuality 以按鈕詢問要下載的格式，按下後才開始下載
+func promptYouTubeQuality(ctx context.Context, message *tgbotapi.Message, link string) {
+	u, err := url.Parse(link)
+	id := ""
+	if err == nil {
+		id = youtubeVideoID(u)
+	}
+	if id == "" {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "無法從連結中找到 YouTube 影片，請傳送影片頁面的連結；播放清單與頻道無法下載。")
+		return
+	}
+	var buttons []tgbotapi.InlineKeyboardButton
+	for _, q := range youtubeQualities {
+		buttons = append(buttons, tgbotapi.NewInlineKeyboardButtonData(q.Label, encodeCallbackData("youtube", q.Name, id)))
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, "要將這部 YouTube 影片存成哪種格式？")
+	msg.ReplyToMessageID = message.MessageID
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(buttons[:2], buttons[2:])
+	if _, err := sendMessage(ctx, msg); err != nil {
+		slog.ErrorContext(ctx, "Failed to send message", "error", err)
+	}
+}
+
+// handleYouTubeCallback 處理格式選擇的按鈕，在背景下載並上傳
+func handleYouTubeCallback(ctx context.Context, query *tgbotapi.CallbackQuery, args []string) {
+	if len(args) != 2 || query.Message == nil || !youtubeIDPattern.MatchString(args[1]) {
+		answerCallback(ctx, query, "")
+		return
+	}
+	q := youtubeQualityByName(args[0])
+	if q == nil {
+		answerCallback(ctx, query, "")
+		return
+	}
+	if !youtubeEnabled(ctx) {
+		answerCallback(ctx, query, "此機器人已停用 YouTube 下載。")
+		return
+	}
+	userID := query.From.ID
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		answerCallback(ctx, query, "讀取您的設定時發生錯誤，請稍後再試。")
+		return
+	}
+	dest := userDestination(ctx, settings)
+	connected, err := dest.Connected(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to retrieve token", "error", err)
+		answerCallback(ctx, query, "讀取您的授權時發生錯誤，請稍後再試。")
+		return
+	}
+	if !connected {
+		answerCallback(ctx, query, fmt.Sprintf("您的 %s 帳號尚未連結，請使用 %s 指令來重新連結。", dest.DisplayName(), connectCommand(dest)))
+		return
+	}
+	answerCallback(ctx, query, "")
+
+	// 移除按鈕，避免重複下載
+	chatID, replyTo := query.Message.Chat.ID, query.Message.MessageID
+	if query.Message.ReplyToMessage != nil {
+		replyTo = query.Message.ReplyToMessage.MessageID
+	}
+	edit := tgbotapi.NewEditMessageText(chatID, query.Message.MessageID, fmt.Sprintf("正在下載 YouTube 影片（%s）…", q.Label))
+	if _, err := sendChattable(ctx, chatID, edit); err != nil {
+		slog.WarnContext(ctx, "Failed to update YouTube prompt", "error", err)
+	}
+
+	// 下載與合併可能需要數分鐘，在背景進行以免 webhook 逾時
+	ctx = withLogAttrs(context.WithoutCancel(ctx), slog.String("video_id", args[1]), slog.String("quality", q.Name))
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				reportPanic(ctx, r)
+			}
+		}()
+		saveYouTube(ctx, settings, dest, userID, chatID, replyTo, args[1], q)
+	}()
+}
+
+// downloadYouTube 以 yt-dlp 將影片下載到 dir，回傳影片資訊與下載的檔案路徑
+// 超過 maxFileSize 的影片 yt-dlp 會略過不下載，此時回傳 errFileTooLarge
+func downloadYouTube(ctx context.Context, dir, id string, q *youtubeQuality) (*linkMediaInfo, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, youtubeDownloadTimeout)
+	defer cancel()
+	args := []string{"--dump-json", "--no-simulate", "--no-warnings", "--no-progress", "--no-playlist",
+		"-f", q.Format, "--max-filesize", strconv.FormatInt(configFor(ctx).maxFileSize(), 10), "-o", filepath.Join(dir, "%(id)s.%(ext)s")}
+	if !q.Audio {
+		args = append(args, "--merge-output-format", "mp4")
+	}
+	cmd := exec.CommandContext(ctx, configFor(ctx).YtDlpPath, append(args, "--", "https://www.youtube.com/watch?v="+id)...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, "", errLinkExtractLimit
+	}
+	if err != nil {
+		return nil, "", classifyLinkError(stderr.String(), err)
+	}
+	var info linkMediaInfo
+	if err := json.Unmarshal(out, &info); err != nil {
+		return nil, "", fmt.Errorf("failed to parse yt-dlp output: %v", err)
+	}
+	// 合併後的副檔名不一定與輸出的資訊相同，直接找出下載完成的檔案
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, e := range entries {
+		if !e.IsDir() && !strings.HasSuffix(e.Name(), ".part") && !strings.HasSuffix(e.Name(), ".ytdl") {
+			return &info, filepath.Join(dir, e.Name()), nil
+		}
+	}
+	return nil, "", errFileTooLarge
+}
+
+// saveYouTube 下載影片並上傳到使用者的目的地，完成後回覆結果
+func saveYouTube(ctx context.Context, settings *UserSettings, dest Destination, userID, chatID int64, replyTo int, id string, q *youtubeQuality) {
+	if err := reserveUpload(ctx, userID, 0); err != nil {
+		var limitErr *rateLimitError
+		if errors.As(err, &limitErr) {
+			replyToUser(ctx, chatID, replyTo, rateLimitMessage(ctx, limitErr))
+			return
+		}
+		slog.ErrorContext(ctx, "Failed to check rate limit", "error", err)
+		replyToUser(ctx, chatID, replyTo, "檢查上傳額度時發生錯誤，請稍後再試。")
+		return
+	}
+
+	dir, err := os.MkdirTemp("", "youtube-")
+	if err != nil {
+		reportError(ctx, "Failed to create temp dir", err)
+		replyToUser(ctx, chatID, replyTo, "下載影片時發生錯誤，請稍後再試。")
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	site := linkSiteByName(ctx, youtubeSite)
+	info, path, err := downloadYouTube(ctx, dir, id, q)
+	if errors.Is(err, errFileTooLarge) {
+		replyToUser(ctx, chatID, replyTo, fmt.Sprintf("這部影片的 %s 超過機器人 %d MB 的下載限制，請改選較低的畫質或僅音訊。", q.Label, configFor(ctx).maxFileSize()/1024/1024))
+		return
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to download YouTube video", "error", err)
+		replyToUser(ctx, chatID, replyTo, linkErrorMessage(site, err))
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		reportError(ctx, "Failed to open downloaded video", err)
+		replyToUser(ctx, chatID, replyTo, "下載影片時發生錯誤，請稍後再試。")
+		return
+	}
+	defer f.Close()
+
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	name := strings.ReplaceAll(strings.TrimSpace(info.Title), "/", "_")
+	if name == "" {
+		name = youtubeSite + "_" + id
+	}
+	mediaType := "video"
+	if q.Audio {
+		mediaType = "audio"
+	}
+	date := time.Now()
+	if info.Timestamp > 0 {
+		date = time.Unix(info.Timestamp, 0)
+	}
+	meta := &UploadMeta{
+		Name:      name,
+		Ext:       ext,
+		Type:      mediaType,
+		Sender:    info.Uploader,
+		Chat:      site.Label,
+		Caption:   info.Title,
+		Date:      date,
+		CreatedAt: time.Now(),
+	}
+	if stat, err := f.Stat(); err == nil {
+		meta.Size = stat.Size()
+	}
+
+	profile := settings.Profile()
+	link := "https://www.youtube.com/watch?v=" + id
+	body := newLimitedReader(f, configFor(ctx).maxFileSize())
+	upload := &UploadFile{
+		Name:     renderFileName(profile.FilenameTemplate, meta),
+		Folders:  renderFolderPath(profile.FolderTemplate, meta),
+		Body:     body,
+		MimeType: mimeTypeByExt("." + ext),
+		Caption:  info.Title + "\n\n" + link,
+	}
+	result, err := uploadTo(ctx, dest, userID, upload)
+	event := &UploadEvent{UserID: userID, ChatID: chatID, Destination: dest.Name(), FileName: upload.Name, MimeType: upload.MimeType, Size: body.BytesRead()}
+	if err != nil {
+		event.Event, event.Error = eventUploadFailed, err.Error()
+		emitUploadEvent(ctx, settings, event)
+		reportError(ctx, "Failed to upload YouTube video", err, "destination", dest.Name())
+		replyToUser(ctx, chatID, replyTo, uploadFailedMessage(ctx, dest, userID, err, fmt.Sprintf("上傳到您的 %s 失敗。", dest.DisplayName())))
+		return
+	}
+	recordLinkUpload(ctx, settings, dest, result, event)
+	slog.InfoContext(ctx, "YouTube video uploaded", "file_name", result.Name, "size", event.Size)
+	if dryRun(ctx) {
+		replyToUser(ctx, chatID, replyTo, dryRunReply(ctx, fmt.Sprintf("已下載 '%s'（%s），試運行模式不會實際上傳到您的 %s。", result.Name, formatSize(event.Size), dest.DisplayName())))
+		return
+	}
+	text := fmt.Sprintf("已將 '%s'（%s，%s）上傳到您的 %s！", result.Name, q.Label, formatSize(event.Size), dest.DisplayName())
+	if result.Link != "" {
+		text += "\n" + result.Link
+	}
+	replyToUser(ctx, chatID, replyTo, text)
+}
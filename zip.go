@@ -0,0 +1,291 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// --- ZIP 打包 ---
+
+// Firestore 集合名稱
+const zipSessionCollection = "zip_sessions"
+
+const (
+	// 打包模式在最後一次加入檔案後多久失效
+	zipSessionTTL = 30 * time.Minute
+	// 一個壓縮檔最多包含的檔案數
+	maxZipEntries = 50
+)
+
+// ZipSession 記錄使用者在 /zip 模式中傳送的檔案，/zip done 時才一次下載並打包上傳
+type ZipSession struct {
+	Entries  []ZipEntry `firestore:"entries"`
+	ExpireAt time.Time  `firestore:"expire_at"`
+}
+
+type ZipEntry struct {
+	FileID string `firestore:"file_id"`
+	Name   string `firestore:"name"`
+	Size   int64  `firestore:"size"`
+}
+
+func zipSessionRef(ctx context.Context, userID int64) *DocRef {
+	return collection(ctx, zipSessionCollection).Doc(fmt.Sprintf("%d", userID))
+}
+
+// loadZipSession 讀取使用者進行中的打包模式，沒有或已過期時回傳 nil
+func loadZipSession(ctx context.Context, userID int64) (*ZipSession, error) {
+	doc, err := zipSessionRef(ctx, userID).Get(ctx)
+	if err != nil {
+		if errors.Is(err, errDocNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var session ZipSession
+	if err := doc.DataTo(&session); err != nil {
+		return nil, err
+	}
+	if time.Now().After(session.ExpireAt) {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+// 處理 /zip 指令：/zip 開始收集檔案，/zip done [檔名] 打包上傳，/zip cancel 放棄
+func handleZip(ctx context.Context, message *tgbotapi.Message) {
+	if !message.Chat.IsPrivate() {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "請在與機器人的私訊中使用 /zip。")
+		return
+	}
+	userID := message.From.ID
+	args := strings.Fields(message.CommandArguments())
+	action := ""
+	if len(args) > 0 {
+		action = strings.ToLower(args[0])
+	}
+
+	switch action {
+	case "":
+		err := zipSessionRef(ctx, userID).Set(ctx, &ZipSession{Entries: []ZipEntry{}, ExpireAt: time.Now().Add(zipSessionTTL)})
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to save zip session", "error", err)
+			replyToUser(ctx, message.Chat.ID, message.MessageID, "發生錯誤，請稍後再試。")
+			return
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf(
+			"已開始打包模式，接下來傳送的檔案會先暫存（最多 %d 個）。\n傳送完畢後輸入 /zip done [壓縮檔名稱] 打包上傳，或輸入 /zip cancel 取消。", maxZipEntries))
+	case "cancel":
+		if err := zipSessionRef(ctx, userID).Delete(ctx); err != nil {
+			slog.ErrorContext(ctx, "Failed to delete zip session", "error", err)
+		}
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "已取消打包模式。")
+	case "done":
+		name := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(message.CommandArguments()), args[0]))
+		finishZip(ctx, message, name)
+	default:
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "用法：/zip 開始打包、/zip done [壓縮檔名稱] 打包上傳、/zip cancel 取消。")
+	}
+}
+
+// handleZipFile 在打包模式中暫存檔案，不在打包模式時回傳 false
+func handleZipFile(ctx context.Context, message *tgbotapi.Message) bool {
+	if !message.Chat.IsPrivate() || message.From == nil {
+		return false
+	}
+	session, err := loadZipSession(ctx, message.From.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load zip session", "error", err)
+		return false
+	}
+	if session == nil {
+		return false
+	}
+
+	file := messageFile(message)
+	// 聯絡人與位置沒有 Telegram 檔案可以在打包時下載，照常直接上傳
+	if file.Content != nil {
+		return false
+	}
+	if len(session.Entries) >= maxZipEntries {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("已達 %d 個檔案的上限，請輸入 /zip done 打包上傳。", maxZipEntries))
+		return true
+	}
+	if maxSize := configFor(ctx).maxFileSize(); file.Size > maxSize {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("檔案大小為 %s，已超過機器人 %d MB 的下載限制，無法加入壓縮檔。", formatSize(file.Size), maxSize/1024/1024))
+		return true
+	}
+	err = zipSessionRef(ctx, message.From.ID).Update(ctx, []Update{
+		{Path: "entries", Value: arrayUnion(ZipEntry{FileID: file.ID, Name: file.Name, Size: file.Size})},
+		{Path: "expire_at", Value: time.Now().Add(zipSessionTTL)},
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to add file to zip session", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "暫存檔案時發生錯誤，請重新傳送。")
+		return true
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("已加入壓縮檔（第 %d 個）：%s", len(session.Entries)+1, file.Name))
+	return true
+}
+
+// finishZip 下載打包模式中暫存的檔案，串流寫成 ZIP 並上傳到使用者的目的地
+func finishZip(ctx context.Context, message *tgbotapi.Message, name string) {
+	userID := message.From.ID
+	session, err := loadZipSession(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load zip session", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "發生錯誤，請稍後再試。")
+		return
+	}
+	if session == nil {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "目前不在打包模式，請先輸入 /zip。")
+		return
+	}
+	if len(session.Entries) == 0 {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "還沒有加入任何檔案，請先傳送檔案，或輸入 /zip cancel 取消。")
+		return
+	}
+
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load settings", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的設定時發生錯誤，請稍後再試。")
+		return
+	}
+	dest := userDestination(ctx, settings)
+	connected, err := dest.Connected(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to retrieve token", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "讀取您的授權時發生錯誤，請稍後再試。")
+		return
+	}
+	if !connected {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("您的 %s 帳號尚未連結，請使用 %s 指令來重新連結。", dest.DisplayName(), connectCommand(dest)))
+		return
+	}
+
+	var declared int64
+	for _, e := range session.Entries {
+		declared += e.Size
+	}
+	quota, err := loadQuota(ctx, dest, userID)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to check storage quota", "error", err)
+	}
+	if quota != nil && quota.Remaining() >= 0 && quota.Remaining() < declared {
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("您的 %s 空間不足（已使用 %s / %s），無法上傳約 %s 的壓縮檔。請清出空間後再輸入 /zip done。",
+			dest.DisplayName(), formatSize(quota.Used), formatSize(quota.Limit), formatSize(declared)))
+		return
+	}
+	if err := reserveUpload(ctx, userID, declared); err != nil {
+		var limitErr *rateLimitError
+		if errors.As(err, &limitErr) {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, rateLimitMessage(ctx, limitErr))
+			return
+		}
+		slog.ErrorContext(ctx, "Failed to check rate limit", "error", err)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, "檢查上傳額度時發生錯誤，請稍後再試。")
+		return
+	}
+
+	// 先刪除暫存，避免重複輸入 /zip done 造成重複上傳
+	if err := zipSessionRef(ctx, userID).Delete(ctx); err != nil {
+		slog.ErrorContext(ctx, "Failed to delete zip session", "error", err)
+	}
+
+	name = strings.ReplaceAll(name, "/", "_")
+	if name == "" {
+		name = "telegram_" + time.Unix(int64(message.Date), 0).Format("20060102_150405")
+	}
+	if !strings.EqualFold(path.Ext(name), ".zip") {
+		name += ".zip"
+	}
+	replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("正在打包 %d 個檔案…", len(session.Entries)))
+
+	// 下載與上傳可能需要數分鐘，在背景進行以免 webhook 逾時
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				reportPanic(ctx, r)
+			}
+		}()
+
+		pr, pw := io.Pipe()
+		counter := newLimitedReader(pr, maxZipEntries*configFor(ctx).maxFileSize())
+		go func() {
+			pw.CloseWithError(writeZip(ctx, pw, session.Entries))
+		}()
+		result, err := uploadTo(ctx, dest, userID, &UploadFile{Name: name, Body: counter, MimeType: "application/zip"})
+		// 上傳失敗時讓寫入端結束
+		pr.CloseWithError(io.ErrClosedPipe)
+		event := &UploadEvent{UserID: userID, ChatID: message.Chat.ID, Destination: dest.Name(), FileName: name, MimeType: "application/zip", Size: counter.BytesRead()}
+		if err != nil {
+			event.Event, event.Error = eventUploadFailed, err.Error()
+			emitUploadEvent(ctx, settings, event)
+			reportError(ctx, "Failed to upload zip", err, "destination", dest.Name())
+			replyToUser(ctx, message.Chat.ID, message.MessageID, uploadFailedMessage(ctx, dest, userID, err, fmt.Sprintf("壓縮檔上傳到您的 %s 失敗。", dest.DisplayName())))
+			return
+		}
+		size := counter.BytesRead()
+		if dryRun(ctx) {
+			replyToUser(ctx, message.Chat.ID, message.MessageID, dryRunReply(ctx, fmt.Sprintf("已將 %d 個檔案打包成 '%s'（%s），試運行模式不會實際上傳到您的 %s。", len(session.Entries), result.Name, formatSize(size), dest.DisplayName())))
+			return
+		}
+		addQuotaUsage(ctx, dest, userID, size)
+		if err := recordUploadBytes(ctx, userID, size); err != nil {
+			slog.ErrorContext(ctx, "Failed to record upload bytes", "error", err)
+		}
+		if err := recordUploadStats(ctx, size); err != nil {
+			slog.ErrorContext(ctx, "Failed to record upload stats", "error", err)
+		}
+		event.Event = eventUploadCompleted
+		event.FileName, event.FileID, event.Link, event.Account = result.Name, result.FileID, result.Link, result.Account
+		emitUploadEvent(ctx, settings, event)
+		slog.InfoContext(ctx, "Zip uploaded", "file_name", result.Name, "entries", len(session.Entries), "size", size)
+		replyToUser(ctx, message.Chat.ID, message.MessageID, fmt.Sprintf("已將 %d 個檔案打包成 '%s'（%s）並上傳到您的 %s！", len(session.Entries), result.Name, formatSize(size), dest.DisplayName()))
+	}()
+}
+
+// writeZip 依序從 Telegram 下載檔案並寫入 ZIP，同名的檔案會加上編號
+func writeZip(ctx context.Context, w io.Writer, entries []ZipEntry) error {
+	zw := zip.NewWriter(w)
+	used := map[string]int{}
+	for _, e := range entries {
+		name := e.Name
+		if n := used[name]; n > 0 {
+			ext := path.Ext(name)
+			name = fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+		}
+		used[e.Name]++
+
+		if err := copyTelegramFile(ctx, zw, name, e.FileID); err != nil {
+			return fmt.Errorf("failed to add %s: %v", e.Name, err)
+		}
+	}
+	return zw.Close()
+}
+
+func copyTelegramFile(ctx context.Context, zw *zip.Writer, name, fileID string) error {
+	resp, err := openTelegramFileByID(ctx, fileID)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, resp.Body)
+	return err
+}